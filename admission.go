@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Admission policies are consulted before a reconstructed transaction is added to the tx pool,
+// so different anti-spam policies can be compared under tx-flood workloads.
+type AdmissionPolicy interface {
+	name() string
+	// returns false if the transaction should not be admitted into the tx pool
+	admit(ac *AdmissionControl, t *Transaction, sender [32]byte, fee int) bool
+}
+
+// Runs all configured policies and keeps the per sender state they share.
+type AdmissionControl struct {
+	policies  []AdmissionPolicy
+	pending   map[[32]byte]int      // sender -> txes in tx pool
+	txSenders map[[32]byte][32]byte // tx id -> sender, for txes in tx pool
+	admitted  uint64
+	rejected  map[string]uint64 // policy name -> rejected txes
+	changed   bool
+	mux       sync.Mutex
+}
+
+func (ac *AdmissionControl) init(flagArgs *FlagArgs) {
+	ac.mux.Lock()
+	defer ac.mux.Unlock()
+	ac.pending = make(map[[32]byte]int)
+	ac.txSenders = make(map[[32]byte][32]byte)
+	ac.rejected = make(map[string]uint64)
+	ac.policies = []AdmissionPolicy{}
+	ac.admitted = 0
+	ac.changed = false
+	if flagArgs.admission == "" {
+		return
+	}
+	for _, name := range strings.Split(flagArgs.admission, ",") {
+		switch strings.TrimSpace(name) {
+		case "ratelimit":
+			p := new(rateLimitPolicy)
+			p.init(flagArgs.senderRate)
+			ac.policies = append(ac.policies, p)
+		case "minfee":
+			ac.policies = append(ac.policies, &minFeePolicy{int(flagArgs.minFee)})
+		case "maxpending":
+			ac.policies = append(ac.policies, &maxPendingPolicy{int(flagArgs.maxPendingPerUser)})
+		default:
+			errFatal(nil, fmt.Sprintf("unknown admission policy %s", name))
+		}
+	}
+}
+
+// checks all policies and records the tx as pending for its sender if admitted
+func (ac *AdmissionControl) admit(nodeCtx *NodeCtx, t *Transaction) bool {
+	sender, fee := txSenderAndFee(nodeCtx, t)
+
+	ac.mux.Lock()
+	defer ac.mux.Unlock()
+	ac.changed = true
+	for _, p := range ac.policies {
+		if !p.admit(ac, t, sender, fee) {
+			ac.rejected[p.name()]++
+			return false
+		}
+	}
+	ac.admitted++
+	if sender != [32]byte{} {
+		ac.pending[sender]++
+		ac.txSenders[t.id()] = sender
+	}
+	return true
+}
+
+// transactions in a processed block are no longer pending for their sender
+func (ac *AdmissionControl) processBlock(transactions []*Transaction) {
+	ac.mux.Lock()
+	defer ac.mux.Unlock()
+	for _, t := range transactions {
+		for _, id := range [][32]byte{t.Hash, t.OrigTxHash} {
+			sender, ok := ac.txSenders[id]
+			if !ok {
+				continue
+			}
+			delete(ac.txSenders, id)
+			ac.pending[sender]--
+			if ac.pending[sender] <= 0 {
+				delete(ac.pending, sender)
+			}
+		}
+	}
+}
+
+// returns admitted and rejected counters as "admitted,policy:rejected;..." if they changed since last call
+func (ac *AdmissionControl) statsIfChanged() (string, bool) {
+	ac.mux.Lock()
+	defer ac.mux.Unlock()
+	if !ac.changed {
+		return "", false
+	}
+	ac.changed = false
+	names := make([]string, 0, len(ac.rejected))
+	for name := range ac.rejected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s := fmt.Sprintf("%d,", ac.admitted)
+	for i, name := range names {
+		if i > 0 {
+			s += ";"
+		}
+		s += fmt.Sprintf("%s:%d", name, ac.rejected[name])
+	}
+	return s, true
+}
+
+// The sender is the owner of the inputs found in this committees UTXO set, and the fee is the known
+// input value minus the output value. If no input is known (all inputs in other committees) the
+// sender is empty and the fee is 0, and per sender policies does not apply.
+func txSenderAndFee(nodeCtx *NodeCtx, t *Transaction) ([32]byte, int) {
+	sender := [32]byte{}
+	inValue := 0
+	allKnown := true
+	for _, inp := range t.Inputs {
+		out := nodeCtx.utxoSet.get(inp.TxHash, inp.N)
+		if out == nil {
+			allKnown = false
+			continue
+		}
+		if sender == [32]byte{} {
+			sender = out.PubKey.Bytes
+		}
+		inValue += int(out.Value)
+	}
+	if !allKnown {
+		return sender, 0
+	}
+	outValue := 0
+	for _, out := range t.Outputs {
+		outValue += int(out.Value)
+	}
+	return sender, inValue - outValue
+}
+
+// token bucket per sender, refilled with rate txes per second
+type rateLimitPolicy struct {
+	rate    float64
+	buckets map[[32]byte]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (p *rateLimitPolicy) init(rate uint) {
+	p.rate = float64(rate)
+	p.buckets = make(map[[32]byte]*tokenBucket)
+}
+
+func (p *rateLimitPolicy) name() string { return "ratelimit" }
+
+func (p *rateLimitPolicy) admit(ac *AdmissionControl, t *Transaction, sender [32]byte, fee int) bool {
+	if sender == [32]byte{} {
+		return true
+	}
+	now := clockNow()
+	b, ok := p.buckets[sender]
+	if !ok {
+		b = &tokenBucket{p.rate, now}
+		p.buckets[sender] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * p.rate
+	if b.tokens > p.rate {
+		// burst of at most one second
+		b.tokens = p.rate
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type minFeePolicy struct {
+	min int
+}
+
+func (p *minFeePolicy) name() string { return "minfee" }
+
+func (p *minFeePolicy) admit(ac *AdmissionControl, t *Transaction, sender [32]byte, fee int) bool {
+	if sender == [32]byte{} {
+		// fee is not known for txes with inputs in other committees
+		return true
+	}
+	return fee >= p.min
+}
+
+type maxPendingPolicy struct {
+	max int
+}
+
+func (p *maxPendingPolicy) name() string { return "maxpending" }
+
+func (p *maxPendingPolicy) admit(ac *AdmissionControl, t *Transaction, sender [32]byte, fee int) bool {
+	if sender == [32]byte{} {
+		return true
+	}
+	return ac.pending[sender] < p.max
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the adaptive adversary of -adversary, corrupts the nodes of the committees it targets every epoch
+
+const adversaryStatic = "static"
+const adversaryRandom = "random"
+const adversaryReference = "reference"
+const adversarySmallest = "smallest"
+
+func isAdversaryStrategy(s string) bool {
+	return s == adversaryStatic || s == adversaryRandom || s == adversaryReference || s == adversarySmallest
+}
+
+// the nodes the adversary corrupted on a block, they are adversaries from the next block on
+type Corruption struct {
+	targets   [][32]byte // committees
+	nodes     map[[32]byte]bool
+	inTargets int
+}
+
+// committees of rBlock sorted by size and then id
+func committeesBySize(rBlock *ReconfigurationBlock) []*Committee {
+	cs := []*Committee{}
+	for _, c := range rBlock.Committees {
+		cs = append(cs, c)
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		if len(cs[i].Members) != len(cs[j].Members) {
+			return len(cs[i].Members) < len(cs[j].Members)
+		}
+		return bytes.Compare(cs[i].ID[:], cs[j].ID[:]) < 0
+	})
+	return cs
+}
+
+// corrupts up to budget nodes of rBlock with strategy, the members of the targeted committees first
+func corrupt(rBlock *ReconfigurationBlock, strategy string, budget int, source RandomSource) *Corruption {
+	cr := &Corruption{nodes: make(map[[32]byte]bool)}
+	target := func(c *Committee) {
+		cr.targets = append(cr.targets, c.ID)
+		for _, pub := range c.getMemberIDsAsSortedList() {
+			if len(cr.nodes) < budget {
+				cr.nodes[pub] = true
+			}
+		}
+	}
+	switch strategy {
+	case adversaryReference:
+		target(referenceCommittee(rBlock))
+	case adversarySmallest:
+		for _, c := range committeesBySize(rBlock) {
+			if len(cr.nodes) >= budget {
+				break
+			}
+			target(c)
+		}
+	}
+	cr.inTargets = len(cr.nodes)
+
+	// the rest of the budget at random
+	rest := [][32]byte{}
+	for _, c := range committeesBySize(rBlock) {
+		for _, pub := range c.getMemberIDsAsSortedList() {
+			if !cr.nodes[pub] {
+				rest = append(rest, pub)
+			}
+		}
+	}
+	source.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+	for _, pub := range rest {
+		if len(cr.nodes) >= budget {
+			break
+		}
+		cr.nodes[pub] = true
+	}
+	return cr
+}
+
+// makes the nodes corrupted on the last block the adversaries and corrupts the nodes of rBlock.
+// Returns the row of rBlock, "" before the first corruption took effect
+func (ms *Membership) _adapt(rBlock *ReconfigurationBlock) string {
+	if ms.adversary == adversaryStatic {
+		return ""
+	}
+	row := ""
+	if last := ms.corruption; last != nil {
+		for pub, info := range ms.nodes {
+			info.IsHonest = !last.nodes[pub]
+			ms.nodes[pub] = info
+		}
+		row = ms._adversaryRow(rBlock, last)
+	}
+	budget := len(ms.nodes) / int(ms.totalF)
+	ms.corruption = corrupt(rBlock, ms.adversary, budget, protocolRand)
+	return row
+}
+
+func (ms *Membership) _adversaryRow(rBlock *ReconfigurationBlock, cr *Corruption) string {
+	inTargets := 0
+	for _, id := range cr.targets {
+		if c, ok := rBlock.Committees[id]; ok {
+			f, _ := ms._adversaries(c)
+			inTargets += f
+		}
+	}
+	most, members, unsafe := 0, 0, 0
+	for _, c := range committeesBySize(rBlock) {
+		f, safe := ms._adversaries(c)
+		if f > most {
+			most, members = f, len(c.Members)
+		}
+		if !safe {
+			unsafe++
+		}
+	}
+	targets := []string{}
+	for _, id := range cr.targets {
+		targets = append(targets, bytes32ToString(id))
+	}
+	return fmt.Sprintf("%d,%s,%s,%d,%d,%d,%d,%d,%d", rBlock.StartIteration, ms.adversary, strings.Join(targets, ";"), len(cr.nodes), cr.inTargets, inTargets, most, members, unsafe)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// the committee assignment of the coordinator without the network, checked by assignment_test.go
+
+// if f adversaries of a committee of members stay below the 1/committeeF bound
+func underCommitteeBound(f int, members int, committeeF uint) bool {
+	return f < int(math.Ceil(float64(members)/float64(committeeF)))
+}
+
+// the sizes of the m committees of n nodes, the rest of the division goes to the last one
+func committeeSizes(n uint, m uint) []int {
+	sizes := make([]int, m)
+	for c := range sizes {
+		sizes[c] = int(n / m)
+	}
+	sizes[m-1] += int(n % m)
+	return sizes
+}
+
+// the adversaries of the committees of sizes: every other committee just under the 1/committeeF
+// bound and the others under a third of that, 1/2 and 1/6 by default, so a committee can fail while
+// the rest stay safe. Over the n/totalF bound in total, which an odd m reaches, the last committees
+// get less
+func committeeAdversaries(sizes []int, committeeF uint, totalF uint) []int {
+	fs := make([]int, len(sizes))
+	n, total := 0, 0
+	for c, size := range sizes {
+		d := int(committeeF)
+		if c%2 == 1 {
+			d *= 3
+		}
+		fs[c] = size / d
+		// exactly at the bound is over it
+		if size%d == 0 && fs[c] > 0 {
+			fs[c]--
+		}
+		n += size
+		total += fs[c]
+	}
+	for c := len(fs) - 1; total > 0 && total*int(totalF) >= n; c = (c + len(fs) - 1) % len(fs) {
+		if fs[c] > 0 {
+			fs[c]--
+			total--
+		}
+	}
+	return fs
+}
+
+// shuffles nodeInfos with source into the committees of committeeSizes, the first nodes of every
+// committee are its adversaries
+func committeeAssignment(flagArgs *FlagArgs, nodeInfos []NodeAllInfo, source RandomSource) []committeeInfo {
+	source.Shuffle(len(nodeInfos), func(i, j int) { nodeInfos[i], nodeInfos[j] = nodeInfos[j], nodeInfos[i] })
+
+	sizes := committeeSizes(flagArgs.n, flagArgs.m)
+	fs := committeeAdversaries(sizes, flagArgs.committeeF, flagArgs.totalF)
+	committeeInfos := make([]committeeInfo, flagArgs.m)
+	i := 0
+	for c := range committeeInfos {
+		committeeInfos[c].id = hash(getBytes(source.Intn(maxId)))
+		for k := 0; k < sizes[c]; k++ {
+			nodeInfos[i].CommitteeID = committeeInfos[c].id
+			nodeInfos[i].IsHonest = k >= fs[c]
+			i++
+		}
+	}
+
+	// count again from the nodes
+	byID := make(map[[32]byte]*committeeInfo)
+	for c := range committeeInfos {
+		byID[committeeInfos[c].id] = &committeeInfos[c]
+	}
+	for _, info := range nodeInfos {
+		ci := byID[info.CommitteeID]
+		ci.npm++
+		if !info.IsHonest {
+			ci.f++
+		}
+	}
+	return committeeInfos
+}
+
+// the invariants of the assignment that do not hold
+func assignmentViolations(flagArgs *FlagArgs, committeeInfos []committeeInfo) []string {
+	violations := []string{}
+	if len(committeeInfos) != int(flagArgs.m) {
+		violations = append(violations, fmt.Sprintf("%d committees instead of %d", len(committeeInfos), flagArgs.m))
+	}
+	npm, rest := flagArgs.n/flagArgs.m, flagArgs.n%flagArgs.m
+	ids := make(map[[32]byte]bool)
+	totalF, nodes := 0, uint(0)
+	for _, ci := range committeeInfos {
+		if ci.npm != npm && ci.npm != npm+rest {
+			violations = append(violations, fmt.Sprintf("committee %s has %d nodes, not %d or %d", bytes32ToString(ci.id), ci.npm, npm, npm+rest))
+		}
+		if !underCommitteeBound(ci.f, int(ci.npm), flagArgs.committeeF) {
+			violations = append(violations, fmt.Sprintf("committee %s has too many adversaries %d of %d", bytes32ToString(ci.id), ci.f, ci.npm))
+		}
+		if ids[ci.id] {
+			violations = append(violations, "committee id "+bytes32ToString(ci.id)+" twice")
+		}
+		ids[ci.id] = true
+		totalF += ci.f
+		nodes += ci.npm
+	}
+	if nodes != flagArgs.n {
+		violations = append(violations, fmt.Sprintf("%d nodes in committees of %d", nodes, flagArgs.n))
+	}
+	if totalF > 0 && totalF*int(flagArgs.totalF) >= int(flagArgs.n) {
+		violations = append(violations, fmt.Sprintf("too many adversaries in total %d of %d", totalF, flagArgs.n))
+	}
+	return violations
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"testing/quick"
+)
+
+// most nodes of a random setup
+const assignmentMaxN = 2000
+
+// a setup of the seed: n up to assignmentMaxN, m up to n, committeeF and totalF from 2 to 5
+func assignmentSetup(seed int64) (*FlagArgs, RandomSource) {
+	source := newSeededSource(seed)
+	flagArgs := &FlagArgs{
+		n:          uint(1 + source.Intn(assignmentMaxN)),
+		committeeF: uint(2 + source.Intn(4)),
+		totalF:     uint(2 + source.Intn(4)),
+	}
+	flagArgs.m = uint(1 + source.Intn(int(flagArgs.n)))
+	return flagArgs, source
+}
+
+// the invariants of assignmentViolations hold on shuffled nodes, every node is in exactly one
+// committee with the same key and the committee ids differ
+func TestAssignmentInvariants(t *testing.T) {
+	check := func(seed int64) bool {
+		flagArgs, source := assignmentSetup(seed)
+		setup := fmt.Sprintf("seed %d: -n %d -m %d -committeeF %d -totalF %d", seed, flagArgs.n, flagArgs.m, flagArgs.committeeF, flagArgs.totalF)
+
+		nodeInfos := make([]NodeAllInfo, flagArgs.n)
+		keys := make(map[[32]byte]bool)
+		for i := range nodeInfos {
+			pub := &PubKey{Bytes: hash(getBytes(i))}
+			nodeInfos[i].Pub = pub
+			keys[pub.Bytes] = true
+		}
+		committeeInfos := committeeAssignment(flagArgs, nodeInfos, source)
+		violations := assignmentViolations(flagArgs, committeeInfos)
+
+		committees := make(map[[32]byte]bool)
+		for _, ci := range committeeInfos {
+			committees[ci.id] = true
+		}
+		for _, info := range nodeInfos {
+			if !keys[info.Pub.Bytes] {
+				violations = append(violations, "node "+bytes32ToString(info.Pub.Bytes)+" twice or not at all")
+			}
+			delete(keys, info.Pub.Bytes)
+			if !committees[info.CommitteeID] {
+				violations = append(violations, "node "+bytes32ToString(info.Pub.Bytes)+" in no committee")
+			}
+		}
+		for _, v := range violations {
+			t.Errorf("%s: %s", setup, v)
+		}
+		return len(violations) == 0
+	}
+	if err := quick.Check(check, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// audit verifies every block of the -blockStore stores against the committees stored next to them
+
+type auditSummary struct {
+	blocks  int
+	valid   int
+	invalid int
+	pruned  int
+}
+
+// returns the committee set used in iteration, which is the last reconfiguration block from before it
+func recBlockForIteration(recBlocks []StoredRecBlock, iteration uint) *ReconfigurationBlock {
+	var rb *ReconfigurationBlock
+	for _, stored := range recBlocks {
+		if stored.FromIteration <= iteration {
+			rb = stored.Block
+		}
+	}
+	return rb
+}
+
+// returns "" if the block is valid, otherwise the reason
+func auditBlock(block *FinalBlock, previous *FinalBlock, recBlocks []StoredRecBlock, committeeF uint, workers uint) string {
+	pb := block.ProposedBlock
+	if previous != nil && pb.PreviousGossipHash != previous.ProposedBlock.GossipHash {
+		return "parent " + bytes32ToString(pb.PreviousGossipHash)
+	}
+	if pb.Iteration == 0 && pb.PreviousGossipHash == [32]byte{} {
+		// the genesis hash is only known by the coordinator
+		return ""
+	}
+	if pb.GossipHash != pb.calculateHash() {
+		return "block hash"
+	}
+	if !block.Pruned && !pb.isMerkleRootCorrect(nil) {
+		return "merkle root"
+	}
+	rb := recBlockForIteration(recBlocks, pb.Iteration)
+	if rb == nil {
+		return "no committee set"
+	}
+	committee, ok := rb.Committees[pb.CommitteeID]
+	if !ok {
+		return "unknown committee"
+	}
+	members := make(map[[32]byte]bool)
+	for pub := range committee.Members {
+		members[pub] = true
+	}
+	// same as the nodes, which count the members except self
+	requiredVotes := rb.Weights.blockQuorum(members, committeeF)
+	return signaturesError(block, members, rb.Weights, requiredVotes, workers)
+}
+
+func auditStore(path string, committeeF uint, workers uint, f *os.File) auditSummary {
+	summary := auditSummary{}
+	blocks, err := readBlocks(path)
+	if ifErr(err, "reading block store "+path) {
+		return summary
+	}
+	recBlocks, err := readRecBlocks(path)
+	ifErr(err, "reading reconfiguration blocks of "+path)
+
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].ProposedBlock.Iteration < blocks[j].ProposedBlock.Iteration
+	})
+
+	var previous *FinalBlock
+	for _, block := range blocks {
+		reason := auditBlock(block, previous, recBlocks, committeeF, workers)
+		summary.blocks++
+		if block.Pruned {
+			summary.pruned++
+		}
+		if reason == "" {
+			summary.valid++
+		} else {
+			summary.invalid++
+		}
+		writeStringToFile(fmt.Sprintf("%s,%d,%s,%t,%t,%s", filepath.Base(path), block.ProposedBlock.Iteration, bytes32ToString(block.ProposedBlock.GossipHash), block.Pruned, reason == "", reason), f)
+		previous = block
+	}
+	return summary
+}
+
+func audit(flagArgs *FlagArgs) {
+	paths := []string{flagArgs.blockStore}
+	if info, err := os.Stat(flagArgs.blockStore); err == nil && info.IsDir() {
+		paths, err = filepath.Glob(filepath.Join(flagArgs.blockStore, "*.blocks"))
+		ifErrFatal(err, "listing block stores")
+	}
+	if len(paths) == 0 || flagArgs.blockStore == "" {
+		errFatal(nil, "no block stores to audit, set -blockStore to a store or a directory of stores")
+	}
+
+	f, err := os.Create(resultsName("audit") + ".csv")
+	ifErrFatal(err, "audit")
+	defer f.Close()
+
+	invalid := 0
+	for _, path := range paths {
+		s := auditStore(path, flagArgs.committeeF, flagArgs.vCPUs, f)
+		log.Printf("[Audit] %s: %d blocks, %d valid, %d invalid, %d pruned\n", filepath.Base(path), s.blocks, s.valid, s.invalid, s.pruned)
+		invalid += s.invalid
+	}
+	if invalid > 0 {
+		log.Printf("[Audit] %d invalid blocks in %d stores\n", invalid, len(paths))
+		os.Exit(1)
+	}
+	log.Printf("[Audit] all blocks in %d stores valid\n", len(paths))
+}
@@ -1,2 +1,2 @@
-go build
+go build -ldflags "-X main.version=$(git describe --tags --always --dirty) -X main.gitCommit=$(git rev-parse HEAD)"
 aws s3 cp rapidchain s3://rapidchain-bucket
\ No newline at end of file
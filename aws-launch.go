@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// aws-launch starts, watches and terminates the ec2 instances of a run
+
+type AwsRun struct {
+	id        string
+	client    *ec2.Client
+	instances []string
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// the user data of an instance that runs sub with env
+func awsUserData(flagArgs *FlagArgs, run *AwsRun, sub string, env [][2]string) string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, `#!/bin/bash -x
+exec > >(tee /dev/console) 2>&1
+ulimit -n 65000
+sysctl -w net.ipv4.tcp_tw_reuse=1
+cd /home/ubuntu
+wget -q -O rapidchain https://%s.s3.amazonaws.com/rapidchain
+chmod +x rapidchain
+`, flagArgs.awsBucket)
+	for _, e := range env {
+		fmt.Fprintf(b, "export %s=%s\n", e[0], shellQuote(e[1]))
+	}
+	// the secret is not traced to the console
+	if flagArgs.awsStatsParameter != "" {
+		fmt.Fprintf(b, `set +x
+export %s=$(aws ssm get-parameter --with-decryption --name %s --query Parameter.Value --output text)
+set -x
+`, envName("statsSecret"), shellQuote(flagArgs.awsStatsParameter))
+	}
+	if sub == "node" {
+		fmt.Fprintf(b, `TOKEN=$(curl -s -X PUT http://169.254.169.254/latest/api/token -H "X-aws-ec2-metadata-token-ttl-seconds: 300")
+export %s=$(curl -s -H "X-aws-ec2-metadata-token: $TOKEN" http://169.254.169.254/latest/meta-data/local-ipv4)
+./rapidchain node
+`, envName("advertise"))
+		return b.String()
+	}
+	fmt.Fprintf(b, `timeout -s INT %d ./rapidchain coordinator
+aws s3 cp --recursive %s s3://%s/results/%s/
+shutdown -h now
+`, flagArgs.awsRunTime, shellQuote(flagArgs.resultsDir), flagArgs.awsBucket, run.id)
+	return b.String()
+}
+
+// starts count instances of the template that run sub, returns their private ips
+func (run *AwsRun) start(flagArgs *FlagArgs, sub string, count uint, env [][2]string) ([]string, error) {
+	ctx := context.Background()
+	out, err := run.client.RunInstances(ctx, &ec2.RunInstancesInput{
+		LaunchTemplate: &types.LaunchTemplateSpecification{LaunchTemplateName: aws.String(flagArgs.awsTemplate)},
+		MinCount:       aws.Int32(int32(count)),
+		MaxCount:       aws.Int32(int32(count)),
+		UserData:       aws.String(base64.StdEncoding.EncodeToString([]byte(awsUserData(flagArgs, run, sub, env)))),
+		TagSpecifications: []types.TagSpecification{{
+			ResourceType: types.ResourceTypeInstance,
+			Tags: []types.Tag{
+				{Key: aws.String("Name"), Value: aws.String("rapidchain-" + sub)},
+				{Key: aws.String("rapidchain-run"), Value: aws.String(run.id)},
+			},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("run instances: %v", err)
+	}
+	started := []string{}
+	ips := []string{}
+	for _, in := range out.Instances {
+		started = append(started, aws.ToString(in.InstanceId))
+		ips = append(ips, aws.ToString(in.PrivateIpAddress))
+	}
+	run.instances = append(run.instances, started...)
+	awsLog(run, "started %d %s instances", len(started), sub)
+	if uint(len(started)) != count {
+		return nil, fmt.Errorf("started %d of %d instances", len(started), count)
+	}
+	waiter := ec2.NewInstanceRunningWaiter(run.client)
+	if err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{InstanceIds: started}, default_awsGrace*time.Second); err != nil {
+		return nil, fmt.Errorf("waiting for the %s instances: %v", sub, err)
+	}
+	return ips, nil
+}
+
+// the state of the coordinator and the number of instances of the run in every state
+func (run *AwsRun) states(coordinatorID string) (types.InstanceStateName, map[types.InstanceStateName]int, error) {
+	var coordinator types.InstanceStateName
+	states := make(map[types.InstanceStateName]int)
+	pages := ec2.NewDescribeInstancesPaginator(run.client, &ec2.DescribeInstancesInput{InstanceIds: run.instances})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(context.Background())
+		if err != nil {
+			return "", nil, fmt.Errorf("describe instances: %v", err)
+		}
+		for _, r := range page.Reservations {
+			for _, in := range r.Instances {
+				if in.State == nil {
+					continue
+				}
+				states[in.State.Name]++
+				if aws.ToString(in.InstanceId) == coordinatorID {
+					coordinator = in.State.Name
+				}
+			}
+		}
+	}
+	return coordinator, states, nil
+}
+
+func (run *AwsRun) terminate() {
+	if len(run.instances) == 0 {
+		return
+	}
+	_, err := run.client.TerminateInstances(context.Background(), &ec2.TerminateInstancesInput{InstanceIds: run.instances})
+	if ifErr(err, "terminating the instances of run "+run.id) {
+		coordinatorLog.errorf(nil, "[AWS] terminate them by the tag rapidchain-run=%s", run.id)
+		return
+	}
+	awsLog(run, "terminated %d instances", len(run.instances))
+	run.instances = nil
+}
+
+func awsLog(run *AwsRun, format string, a ...interface{}) {
+	coordinatorLog.infof(nil, "[AWS] "+run.id+" "+format, a...)
+}
+
+// logs the states of the instances until the coordinator shut its instance down or the time is up
+func (run *AwsRun) watch(flagArgs *FlagArgs, coordinatorID string, sigs chan os.Signal) {
+	start := time.Now()
+	deadline := start.Add(time.Duration(flagArgs.awsRunTime+default_awsGrace) * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case sig := <-sigs:
+			coordinatorLog.warnf(nil, "[AWS] %v, ending run %s", sig, run.id)
+			return
+		case <-time.After(default_awsPoll * time.Second):
+		}
+		coordinator, states, err := run.states(coordinatorID)
+		if ifErr(err, "states of the instances") {
+			continue
+		}
+		awsLog(run, "coordinator %s, %d of %d instances running, %d of %d seconds", coordinator, states[types.InstanceStateNameRunning], len(run.instances), int(time.Since(start).Seconds()), flagArgs.awsRunTime)
+		// the coordinator shuts its instance down after it copied the results
+		if coordinator != types.InstanceStateNamePending && coordinator != types.InstanceStateNameRunning {
+			awsLog(run, "done, results in s3://%s/results/%s/", flagArgs.awsBucket, run.id)
+			return
+		}
+	}
+	coordinatorLog.warnf(nil, "[AWS] run %s did not end after %d seconds", run.id, flagArgs.awsRunTime+default_awsGrace)
+}
+
+func awsLaunch(flagArgs *FlagArgs) {
+	if flagArgs.awsTemplate == "" {
+		errFatal(nil, "aws-launch needs the launch template of -awsTemplate")
+	}
+	if flagArgs.statsSecret != "" && flagArgs.awsStatsParameter == "" {
+		errFatal(nil, "aws-launch does not pass -statsSecret in the user data of the instances, put it in an ssm parameter and give its name with -awsStatsParameter")
+	}
+	machines := nodeMachines(flagArgs)
+	opts := []func(*config.LoadOptions) error{}
+	if flagArgs.awsRegion != "" {
+		opts = append(opts, config.WithRegion(flagArgs.awsRegion))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		errFatal(err, "aws config")
+	}
+	run := &AwsRun{id: time.Now().Format("20060102-150405"), client: ec2.NewFromConfig(cfg)}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	// the instances cost money, they do not outlive the launcher
+	defer run.terminate()
+
+	ips, err := run.start(flagArgs, "coordinator", 1, remoteEnv("coordinator"))
+	if ifErr(err, "starting the coordinator") {
+		return
+	}
+	coordinatorID := run.instances[0]
+	awsLog(run, "coordinator %s at %s", coordinatorID, ips[0])
+	env := append(remoteEnv("node"), [2]string{envName("coordinator"), ips[0]})
+	if _, err := run.start(flagArgs, "node", machines, env); ifErr(err, "starting the nodes") {
+		return
+	}
+	run.watch(flagArgs, coordinatorID, sigs)
+}
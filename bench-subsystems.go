@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/renzhf/go-merkletree"
+)
+
+// benchmarks of ida, a consensus round and the routing on synthetic inputs, without the network
+
+// the latencies of a phase of a bench mode
+type benchPhase struct {
+	name  string
+	h     *LatencyHistogram
+	total time.Duration
+}
+
+func newBenchPhases(names ...string) []*benchPhase {
+	phases := make([]*benchPhase, len(names))
+	for i, name := range names {
+		phases[i] = &benchPhase{name: name, h: newLatencyHistogram()}
+	}
+	return phases
+}
+
+func (p *benchPhase) time(f func()) {
+	start := time.Now()
+	f()
+	d := time.Since(start)
+	p.total += d
+	p.h.record(int64(d / latencyUnit))
+}
+
+func printBenchPhases(phases []*benchPhase) {
+	fmt.Printf("%-24s %8s %12s %12s %12s %12s\n", "phase", "ops", "ops/s", "p50", "p99", "max")
+	for _, p := range phases {
+		perSecond := 0.0
+		if p.total > 0 {
+			perSecond = float64(p.h.Count) / p.total.Seconds()
+		}
+		fmt.Printf("%-24s %8d %12.1f %12s %12s %12s\n", p.name, p.h.Count, perSecond,
+			time.Duration(p.h.quantile(0.5))*latencyUnit, time.Duration(p.h.quantile(0.99))*latencyUnit, time.Duration(p.h.Max)*latencyUnit)
+	}
+}
+
+func benchSource(flagArgs *FlagArgs, mode string) *seededSource {
+	if flagArgs.runSeed == 0 {
+		flagArgs.runSeed = randomInt64(protocolRand)
+	}
+	fmt.Printf("%s: %d rounds from run seed %d\n", mode, flagArgs.trials, flagArgs.runSeed)
+	return newSeededSource(flagArgs.runSeed)
+}
+
+func benchIDA(flagArgs *FlagArgs) {
+	source := benchSource(flagArgs, "bench-ida")
+	fmt.Printf("%d bytes in %d chunks and %d parity chunks\n", flagArgs.B, default_kappa, default_parity)
+	phases := newBenchPhases("encode", "verify", "reconstruct")
+	failed := 0
+	for t := uint(0); t < flagArgs.trials; t++ {
+		msg := make([]byte, flagArgs.B)
+		source.Read(msg)
+		var data [][]byte
+		var proofs []*merkletree.Proof
+		var root [32]byte
+		phases[0].time(func() { data, proofs, root = idaEncode(nil, msg) })
+
+		// a node reconstructs from the first kappa chunks it gets, in any order
+		got := IDAGossipMsg{"block", [][]byte{}, []*merkletree.Proof{}, root}
+		order := make([]int, len(data))
+		for i := range order {
+			order[i] = i
+		}
+		source.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		for _, i := range order[:default_kappa] {
+			got.Chunks = append(got.Chunks, data[i])
+			got.Proofs = append(got.Proofs, proofs[i])
+		}
+		verified := false
+		phases[1].time(func() { verified = verifyIDAChunks(got) })
+
+		received := make([][]byte, len(data))
+		for i, chunk := range got.Chunks {
+			received[got.Proofs[i].Index] = chunk
+		}
+		var err error
+		phases[2].time(func() { err = idaReconstruct(received) })
+		if !verified || ifErr(err, "bench-ida reconstruct") || !bytes.Equal(bytes.Join(received[:default_kappa], nil), bytes.Join(data[:default_kappa], nil)) {
+			failed++
+		}
+	}
+	printBenchPhases(phases)
+	fmt.Printf("%.1f MB/s encoded, %.1f MB/s reconstructed\n", benchMBs(flagArgs, phases[0]), benchMBs(flagArgs, phases[2]))
+	benchFailed(failed, flagArgs.trials)
+}
+
+func benchMBs(flagArgs *FlagArgs, p *benchPhase) float64 {
+	if p.total == 0 {
+		return 0
+	}
+	return float64(flagArgs.B) * float64(p.h.Count) / p.total.Seconds() / 1e6
+}
+
+// exits with 1 if a round of a bench mode did not end like in a run
+func benchFailed(failed int, trials uint) {
+	if failed > 0 {
+		fmt.Printf("%d of %d rounds failed\n", failed, trials)
+		os.Exit(1)
+	}
+}
+
+// the votes of the members of a committee for a block, encoded like on the wire
+func benchVotes(members []*PrivKey, gh [32]byte, tag string) [][]byte {
+	votes := make([][]byte, len(members))
+	for i, k := range members {
+		cMsg := &ConsensusMsg{GossipHash: gh, Tag: tag, Pub: k.Pub}
+		cMsg.sign(k)
+		var buf bytes.Buffer
+		ifErrFatal(gob.NewEncoder(&buf).Encode(Msg{"consensus", cMsg, k.Pub}), "encoding vote")
+		votes[i] = buf.Bytes()
+	}
+	return votes
+}
+
+func benchConsensus(flagArgs *FlagArgs) {
+	source := benchSource(flagArgs, "bench-consensus")
+	// every round verifies new signatures, not the ones in the cache
+	initSigCaches(0)
+	size := int(flagArgs.n / flagArgs.m)
+	if size < 1 {
+		errFatal(nil, "bench-consensus needs n/m members, at least one")
+	}
+	members := make([]*PrivKey, size)
+	for i := range members {
+		members[i] = new(PrivKey)
+		members[i].gen()
+	}
+	fmt.Printf("committee of %d members, accepts verified on %d workers\n", size, flagArgs.vCPUs)
+	phases := newBenchPhases("sign", "echos", "accepts", "round")
+	failed := 0
+	for t := uint(0); t < flagArgs.trials; t++ {
+		gh := hash(getBytes(randomInt64(source)))
+		echos, accepts := benchVotes(members, gh, "echo"), benchVotes(members, gh, "accept")
+		// a member signs its echo and its accept
+		phases[0].time(func() {
+			for _, tag := range []string{"echo", "accept"} {
+				cMsg := &ConsensusMsg{GossipHash: gh, Tag: tag, Pub: members[0].Pub}
+				cMsg.sign(members[0])
+			}
+		})
+
+		cMsgs := ConsensusMsgs{}
+		cMsgs.init()
+		cMsgs.initGossipHash(gh)
+		add := func(votes [][]byte) {
+			for _, b := range votes {
+				msg := new(Msg)
+				if ifErr(gob.NewDecoder(bytes.NewReader(b)).Decode(msg), "decoding vote") {
+					continue
+				}
+				cMsg := msg.Msg.(ConsensusMsg)
+				cMsgs.add(cMsg.GossipHash, cMsg.Pub.Bytes, &cMsg)
+			}
+		}
+		echoVotes, acceptVotes := 0, 0
+		phases[3].time(func() {
+			phases[1].time(func() {
+				add(echos)
+				echoVotes = cMsgs.countValidVotes(gh, nil)
+			})
+			phases[2].time(func() {
+				add(accepts)
+				acceptVotes = cMsgs.countValidAccepts(gh, flagArgs.vCPUs, nil)
+			})
+		})
+		if echoVotes != size || acceptVotes != size {
+			failed++
+		}
+	}
+	printBenchPhases(phases)
+	benchFailed(failed, flagArgs.trials)
+}
+
+// a node of every committee with the routing table it builds in a run
+func benchRoutingNodes(flagArgs *FlagArgs, source RandomSource) map[[32]byte]*NodeCtx {
+	allInfo := make(map[[32]byte]NodeAllInfo)
+	committees := make([][32]byte, flagArgs.m)
+	for c := range committees {
+		committees[c] = hash(getBytes(randomInt64(source)))
+	}
+	for i := uint(0); i < flagArgs.n; i++ {
+		pub := &PubKey{Bytes: hash(getBytes(randomInt64(source)))}
+		allInfo[pub.Bytes] = NodeAllInfo{Pub: pub, CommitteeID: committees[i%flagArgs.m], IP: fmt.Sprintf("node-%d", i)}
+	}
+	nodes := make(map[[32]byte]*NodeCtx)
+	for _, id := range committees {
+		nodeCtx := new(NodeCtx)
+		nodeCtx.flagArgs = *flagArgs
+		nodeCtx.setCommitteeID(id)
+		buildRoutingTable(nodeCtx, id, allInfo)
+		nodes[id] = nodeCtx
+	}
+	return nodes
+}
+
+// routes a tx from the committee from like routeTx and returns the find_node rounds, -1 if
+// find_node came back to a committee it asked before, where recursiveFindNode would not stop, or
+// was dropped
+func benchRoute(nodes map[[32]byte]*NodeCtx, from [32]byte, txHash [32]byte) int {
+	nodeCtx := nodes[from]
+	target := txFindClosestCommittee(nodeCtx, txHash)
+	if target == from {
+		return 0
+	}
+	for _, c := range nodeCtx.routingTable.get() {
+		if c.ID == target {
+			return 0
+		}
+	}
+	c, ok := findClosestsCommittee(nodeCtx, target)
+	if !ok {
+		return -1
+	}
+	asked := map[[32]byte]bool{}
+	for hops := 1; !asked[c.ID]; hops++ {
+		asked[c.ID] = true
+		// every member of c answers with the same committee of its routing table
+		c, ok = _handleFindNode(nodes[c.ID], KademliaFindNodeMsg{target})
+		if !ok {
+			return -1
+		}
+		if c.ID == target {
+			return hops
+		}
+	}
+	return -1
+}
+
+func benchRouting(flagArgs *FlagArgs) {
+	source := benchSource(flagArgs, "bench-routing")
+	if flagArgs.m < 2 || flagArgs.n/flagArgs.m < 2 {
+		errFatal(nil, "bench-routing needs two committees of two nodes at least")
+	}
+	// every node logs its routing table
+	logLevels[routingLog.module] = levelWarn
+	phases := newBenchPhases("routing table", "route")
+	var nodes map[[32]byte]*NodeCtx
+	phases[0].time(func() { nodes = benchRoutingNodes(flagArgs, source) })
+	committees := [][32]byte{}
+	for id := range nodes {
+		committees = append(committees, id)
+	}
+	sort.Slice(committees, func(i, j int) bool { return bytes.Compare(committees[i][:], committees[j][:]) < 0 })
+	fmt.Printf("%d committees of %d nodes\n", flagArgs.m, flagArgs.n/flagArgs.m)
+
+	hops := newLatencyHistogram()
+	failed := 0
+	for t := uint(0); t < flagArgs.trials; t++ {
+		from := committees[source.Intn(len(committees))]
+		txHash := hash(getBytes(randomInt64(source)))
+		h := 0
+		phases[1].time(func() { h = benchRoute(nodes, from, txHash) })
+		if h < 0 {
+			failed++
+			continue
+		}
+		hops.record(int64(h))
+	}
+	printBenchPhases(phases)
+	mean := 0.0
+	if hops.Count > 0 {
+		mean = float64(hops.Sum) / float64(hops.Count)
+	}
+	fmt.Printf("find_node rounds: mean %.2f, p99 %d, max %d\n", mean, hops.quantile(0.99), hops.Max)
+	if failed > 0 {
+		fmt.Printf("find_node went around in a circle for %d txs\n", failed)
+	}
+	benchFailed(failed, flagArgs.trials)
+}
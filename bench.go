@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// benchmarks run from the binary, on the machines of the experiments
+
+type namedBenchmark struct {
+	name string
+	f    func()
+}
+
+// the time of a call of f, measured over calls for at least default_benchTime
+func timeOp(f func()) (time.Duration, int) {
+	for n := 1; ; n *= 2 {
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			f()
+		}
+		if d := time.Since(start); d >= default_benchTime*time.Second || n >= 1<<30 {
+			return d / time.Duration(n), n
+		}
+	}
+}
+
+func runBenchmarks(benchmarks []namedBenchmark) []time.Duration {
+	results := make([]time.Duration, len(benchmarks))
+	for i, bm := range benchmarks {
+		op, n := timeOp(bm.f)
+		fmt.Printf("%-32s %10d %12d ns/op\n", bm.name, n, op.Nanoseconds())
+		results[i] = op
+	}
+	return results
+}
+
+// block times the committee sizes of benchcrypto are recommended for, and the share of the block
+// time that signing and verifying may take, the rest is gossip and waiting for deltas
+var benchCryptoBlockTimes = []time.Duration{time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second}
+
+const benchCryptoBudget = 0.25
+
+// sign, verify and aggregate of every signature scheme, and the largest committee whose votes a
+// member can sign and verify on vCPUs cores within the budget of a block time. Per block a member
+// signs an echo, an accept and as leader a propose, and verifies the accept of every member
+func benchCrypto(flagArgs *FlagArgs) {
+	initSigCaches(0)
+	defer func(scheme string) { sigScheme = scheme }(sigScheme)
+	const aggregated = 128
+
+	type schemeCost struct {
+		scheme          string
+		sign, verify    time.Duration
+		aggregatePerSig time.Duration
+		pubSize         int
+		sigSize         int
+	}
+	costs := []schemeCost{}
+	for _, scheme := range sigSchemes {
+		sigScheme = scheme
+		k := new(PrivKey)
+		k.gen()
+		h := hash([]byte("block"))
+		sig := k.sign(h)
+		benchmarks := []namedBenchmark{
+			{scheme + " sign", func() { k.sign(h) }},
+			{scheme + " verify", func() { k.Pub.verify(h, sig) }},
+		}
+		if scheme == schemeBls {
+			sigs := make([][]byte, aggregated)
+			for i := range sigs {
+				sigs[i] = k.sign(hash(getBytes(i))).Raw
+			}
+			benchmarks = append(benchmarks, namedBenchmark{fmt.Sprintf("%s aggregate %d", scheme, aggregated), func() { blsAggregateSigs(sigs) }})
+		}
+		results := runBenchmarks(benchmarks)
+		c := schemeCost{scheme, results[0], results[1], 0, len(k.Pub.encode()), len(sig.encode())}
+		if len(results) > 2 {
+			c.aggregatePerSig = results[2] / aggregated
+		}
+		costs = append(costs, c)
+	}
+
+	workers := flagArgs.vCPUs
+	if workers < 1 {
+		workers = 1
+	}
+	fmt.Printf("\nlargest committee per block time, %.0f%% of it for crypto on %d vCPUs\n", benchCryptoBudget*100, workers)
+	fmt.Printf("%-10s %6s %6s", "scheme", "pub B", "sig B")
+	for _, t := range benchCryptoBlockTimes {
+		fmt.Printf(" %8s", t)
+	}
+	fmt.Println()
+	for _, c := range costs {
+		fmt.Printf("%-10s %6d %6d", c.scheme, c.pubSize, c.sigSize)
+		perMember := c.verify/time.Duration(workers) + c.aggregatePerSig
+		for _, t := range benchCryptoBlockTimes {
+			budget := time.Duration(float64(t)*benchCryptoBudget) - 3*c.sign
+			size := 0
+			if budget > 0 && perMember > 0 {
+				size = int(budget / perMember)
+			}
+			fmt.Printf(" %8d", size)
+		}
+		fmt.Println()
+	}
+}
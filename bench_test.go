@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// creates a signed transaction with nInputs inputs and two outputs
+func benchTransaction(nInputs int) *Transaction {
+	user := new(PrivKey)
+	user.gen()
+	t := new(Transaction)
+	t.Inputs = make([]*InTx, nInputs)
+	for i := range t.Inputs {
+		t.Inputs[i] = &InTx{TxHash: hash(uintToByte(uint(i))), N: uint(i)}
+	}
+	t.Outputs = []*OutTx{{Value: 10, N: 0, PubKey: user.Pub}, {Value: 5, N: 1, PubKey: user.Pub}}
+	t.setHash()
+	t.signInputs(user)
+	return t
+}
+
+func benchCommitteeList(m int) *NodeCtx {
+	nodeCtx := new(NodeCtx)
+	nodeCtx.committeeList = make([][32]byte, m)
+	for i := range nodeCtx.committeeList {
+		nodeCtx.committeeList[i] = hash(getBytes(i))
+	}
+	return nodeCtx
+}
+
+// the committees of the lookups of the tx benchmarks
+const benchCommittees = 16
+
+func BenchmarkTxHash(b *testing.B) {
+	enc := benchTransaction(2).encode()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		hash(enc)
+	}
+}
+
+func BenchmarkTxCalculateHash(b *testing.B) {
+	t := benchTransaction(2)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		t.calculateHash()
+	}
+}
+
+func BenchmarkTxEncode(b *testing.B) {
+	t := benchTransaction(2)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		t.encode()
+	}
+}
+
+func BenchmarkTxDecode(b *testing.B) {
+	enc := benchTransaction(2).encode()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tx := new(Transaction)
+		tx.decode(enc)
+	}
+}
+
+func BenchmarkGetBytes(b *testing.B) {
+	t := benchTransaction(2)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		getBytes(t.Hash)
+	}
+}
+
+func BenchmarkTxFindClosestCommittee(b *testing.B) {
+	t := benchTransaction(2)
+	nodeCtx := benchCommitteeList(benchCommittees)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		txFindClosestCommittee(nodeCtx, t.Hash)
+	}
+}
+
+func BenchmarkTxClosestCommitteeCached(b *testing.B) {
+	t := benchTransaction(2)
+	nodeCtx := benchCommitteeList(benchCommittees)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		t.closestCommittee(nodeCtx, t.Hash)
+	}
+}
+
+// the nodes of a simulated run look up the same tx at the same time, for its hash and its inputs
+func BenchmarkTxClosestCommitteeShared(b *testing.B) {
+	t := benchTransaction(2)
+	nodeCtx := benchCommitteeList(benchCommittees)
+	hs := [][32]byte{t.Hash, t.Inputs[0].TxHash}
+	want := map[[32]byte][32]byte{}
+	for _, h := range hs {
+		want[h] = txFindClosestCommittee(nodeCtx, h)
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			h := hs[i%len(hs)]
+			if t.closestCommittee(nodeCtx, h) != want[h] {
+				b.Error("closest committee of another hash")
+			}
+		}
+	})
+}
+
+func BenchmarkInTxGetHash(b *testing.B) {
+	t := benchTransaction(2)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		t.Inputs[0].getHash(t.Hash)
+	}
+}
+
+// the accept votes of a committee of size
+func benchAccepts(size int) []SignedItem {
+	items := make([]SignedItem, size)
+	gh := hash([]byte("block"))
+	for i := range items {
+		k := new(PrivKey)
+		k.gen()
+		cMsg := &ConsensusMsg{GossipHash: gh, Tag: "accept", Pub: k.Pub}
+		cMsg.sign(k)
+		items[i] = SignedItem{k.Pub, cMsg.calculateHash(), cMsg.Sig}
+	}
+	return items
+}
+
+// the accept votes of a committee one by one and with VerifyBatch on a worker per cpu
+func BenchmarkVerify(b *testing.B) {
+	// every iteration verifies the same signatures, which would only measure the cache
+	initSigCaches(0)
+	for _, size := range []int{64, 128, 256} {
+		items := benchAccepts(size)
+		b.Run(fmt.Sprintf("serial/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, it := range items {
+					it.Pub.verify(it.Hash, it.Sig)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("VerifyBatch/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				VerifyBatch(items, uint(runtime.NumCPU()), false)
+			}
+		})
+	}
+}
+
+// hash() at the sizes it is called with: a header, a transaction, an ida chunk and a block
+func BenchmarkHash(b *testing.B) {
+	defer setHashFunction(hashFunction)
+	for _, name := range []string{hashSHA256, hashBLAKE3} {
+		for _, size := range []int{64, 512, 4096, 65536} {
+			msg := make([]byte, size)
+			b.Run(fmt.Sprintf("%s/%d", name, size), func(b *testing.B) {
+				setHashFunction(name)
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					hash(msg)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// equivocation proofs of leaders and the blacklist of the reconfiguration block
+
+// the headers of two blocks of the same leader and iteration, without their transactions
+type EquivocationProof struct {
+	A *ProposedBlock
+	B *ProposedBlock
+}
+
+// block without its transactions, its hash and leader signature can be verified without them
+func blockHeader(b *ProposedBlock) *ProposedBlock {
+	return &ProposedBlock{
+		GossipHash:         b.GossipHash,
+		PreviousGossipHash: b.PreviousGossipHash,
+		Iteration:          b.Iteration,
+		CommitteeID:        b.CommitteeID,
+		LeaderPub:          b.LeaderPub,
+		MerkleRoot:         b.MerkleRoot,
+		LeaderSig:          b.LeaderSig,
+	}
+}
+
+func isSignedByLeader(b *ProposedBlock) bool {
+	return b.LeaderPub != nil && b.isHashesCorrect() && b.LeaderPub.verify(b.GossipHash, b.LeaderSig)
+}
+
+func (p *EquivocationProof) culprit() [32]byte {
+	return p.A.LeaderPub.Bytes
+}
+
+// returns "" if p proves that its leader equivocated, otherwise the reason
+func (p *EquivocationProof) verify() string {
+	if p.A == nil || p.B == nil || p.A.LeaderPub == nil || p.B.LeaderPub == nil {
+		return "missing block"
+	}
+	if p.A.LeaderPub.Bytes != p.B.LeaderPub.Bytes || p.A.Iteration != p.B.Iteration || p.A.CommitteeID != p.B.CommitteeID {
+		return "blocks of different leaders or iterations"
+	}
+	if p.A.GossipHash == p.B.GossipHash {
+		return "same block"
+	}
+	if !isSignedByLeader(p.A) || !isSignedByLeader(p.B) {
+		return "leader signature"
+	}
+	return ""
+}
+
+// the blocks seen per leader and iteration, and the proofs a member of the reference committee got
+type Equivocations struct {
+	seen     map[[32]byte]*ProposedBlock // hash(leader | iteration) -> header of the first block
+	reported map[[32]byte]bool
+	pending  map[[32]byte]EquivocationProof // culprit -> proof, not blacklisted yet
+	mux      sync.Mutex
+}
+
+func (e *Equivocations) init() {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.seen = make(map[[32]byte]*ProposedBlock)
+	e.reported = make(map[[32]byte]bool)
+	e.pending = make(map[[32]byte]EquivocationProof)
+}
+
+// keeps the header of block and reports its leader if it signed another block for the iteration
+func (e *Equivocations) check(nodeCtx *NodeCtx, block *ProposedBlock) {
+	if block.LeaderPub == nil || block.LeaderPub.Bytes == nodeCtx.self.Priv.Pub.Bytes || !isSignedByLeader(block) {
+		return
+	}
+	key := hash(byteSliceAppend(block.LeaderPub.Bytes[:], uintToByte(block.Iteration)))
+	e.mux.Lock()
+	first, ok := e.seen[key]
+	if !ok {
+		e.seen[key] = blockHeader(block)
+		// the blocks of an iteration arrive during it
+		for k, b := range e.seen {
+			if b.Iteration+2 < block.Iteration {
+				delete(e.seen, k)
+			}
+		}
+		e.mux.Unlock()
+		return
+	}
+	if first.GossipHash == block.GossipHash || e.reported[block.LeaderPub.Bytes] {
+		e.mux.Unlock()
+		return
+	}
+	e.reported[block.LeaderPub.Bytes] = true
+	e.mux.Unlock()
+
+	p := EquivocationProof{first, blockHeader(block)}
+	consensusLog.warnf(nodeCtx, "[Blacklist] %s equivocated in iteration %d", bytes32ToString(p.culprit()), block.Iteration)
+	spawnDialAndSendToCoordinator("equivocation", p)
+	if nodeCtx.flagArgs.drg {
+		for _, m := range referenceCommittee(nodeCtx.blockchain.getLastReconfigurationBlock()).sortedMembers() {
+			spawnDialAndSend(m.IP, Msg{"equivocation_proof", p, nodeCtx.self.Priv.Pub})
+		}
+	}
+}
+
+// a proof sent to the reference committee, kept until the aggregator of the drg puts it in a result
+func (e *Equivocations) add(nodeCtx *NodeCtx, p EquivocationProof) {
+	if reason := p.verify(); reason != "" {
+		errr(nil, "equivocation proof: "+reason)
+		return
+	}
+	if nodeCtx.blockchain.getLastReconfigurationBlock().isBlacklisted(p.culprit()) {
+		return
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if _, ok := e.pending[p.culprit()]; !ok {
+		e.pending[p.culprit()] = p
+	}
+}
+
+// the proofs of keys rBlock does not blacklist yet, sorted by key
+func (e *Equivocations) evidence(rBlock *ReconfigurationBlock) []EquivocationProof {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return pendingEvidence(e.pending, rBlock)
+}
+
+func pendingEvidence(pending map[[32]byte]EquivocationProof, rBlock *ReconfigurationBlock) []EquivocationProof {
+	evidence := []EquivocationProof{}
+	for pub, p := range pending {
+		if rBlock.isBlacklisted(pub) {
+			delete(pending, pub)
+			continue
+		}
+		evidence = append(evidence, p)
+	}
+	sort.Slice(evidence, func(i, j int) bool {
+		a, b := evidence[i].culprit(), evidence[j].culprit()
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	return evidence
+}
+
+func (rb *ReconfigurationBlock) isBlacklisted(pub [32]byte) bool {
+	_, ok := rb.Blacklist[pub]
+	return ok
+}
+
+// blacklists the leaders of the verified evidence, rb is not hashed yet
+func (rb *ReconfigurationBlock) addEvidence(evidence []EquivocationProof) {
+	if len(evidence) == 0 {
+		return
+	}
+	blacklist := make(map[[32]byte]uint)
+	for pub, i := range rb.Blacklist {
+		blacklist[pub] = i
+	}
+	for _, p := range evidence {
+		if _, ok := blacklist[p.culprit()]; !ok {
+			blacklist[p.culprit()] = p.A.Iteration
+		}
+	}
+	rb.Blacklist = blacklist
+}
+
+// a block for the iteration of block that differs from it, the transactions without the last one.
+// nil if block has less than two
+func equivocatingBlock(nodeCtx *NodeCtx, block *ProposedBlock) *ProposedBlock {
+	if len(block.Transactions) < 2 {
+		return nil
+	}
+	other := blockHeader(block)
+	other.Transactions = block.Transactions[:len(block.Transactions)-1]
+	other.MerkleRoot = toByte32(createMerkleTree(nodeCtx, other.Transactions).Root())
+	other.setHash()
+	other.LeaderSig = nodeCtx.self.Priv.sign(other.GossipHash)
+	return other
+}
+
+// the first proof of every culprit on the coordinator, for the proofs in blocks with -reference
+// coordinator and the time until a key is blacklisted
+type BlacklistReports struct {
+	evidence map[[32]byte]EquivocationProof
+	reported map[[32]byte]time.Time
+	excluded map[[32]byte]bool
+}
+
+func (r *BlacklistReports) init() {
+	r.evidence = make(map[[32]byte]EquivocationProof)
+	r.reported = make(map[[32]byte]time.Time)
+	r.excluded = make(map[[32]byte]bool)
+}
+
+func (ms *Membership) addEvidence(p EquivocationProof) {
+	if reason := p.verify(); reason != "" {
+		errr(nil, "equivocation proof: "+reason)
+		return
+	}
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	if _, ok := ms.blacklist.reported[p.culprit()]; ok {
+		return
+	}
+	ms.blacklist.reported[p.culprit()] = clockNow()
+	ms.blacklist.evidence[p.culprit()] = p
+	coordinatorLog.warnf(nil, "[Blacklist] %s equivocated in iteration %d of committee %s", bytes32ToString(p.culprit()), p.A.Iteration, bytes32ToString(p.A.CommitteeID))
+	faultCoverage.react("blacklist", bytes32ToString(p.A.CommitteeID), bytes32ToString(p.culprit()))
+}
+
+// rows of the keys rBlock blacklists first
+func (ms *Membership) excluded(rBlock *ReconfigurationBlock) []string {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	pubs := [][32]byte{}
+	for pub := range rBlock.Blacklist {
+		if !ms.blacklist.excluded[pub] {
+			pubs = append(pubs, pub)
+		}
+	}
+	sort.Slice(pubs, func(i, j int) bool { return bytes.Compare(pubs[i][:], pubs[j][:]) < 0 })
+	rows := []string{}
+	for _, pub := range pubs {
+		ms.blacklist.excluded[pub] = true
+		committee := "-"
+		if c := rBlock.committeeOf(pub); c != nil {
+			committee = bytes32ToString(c.ID)
+		}
+		since := int64(-1)
+		if t, ok := ms.blacklist.reported[pub]; ok {
+			since = clockSince(t).Milliseconds()
+		}
+		i := rBlock.Blacklist[pub]
+		coordinatorLog.infof(nil, "[Blacklist] %s blacklisted from iteration %d", bytes32ToString(pub), rBlock.StartIteration)
+		rows = append(rows, fmt.Sprintf("%s,%s,%d,%d,%d,%d", bytes32ToString(pub), committee, i, rBlock.StartIteration, int(rBlock.StartIteration)-int(i), since))
+	}
+	return rows
+}
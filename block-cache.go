@@ -0,0 +1,88 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// LRU cache of blocks read from the block store. Recent blocks are kept in memory by the
+// blockchain, older blocks are read from disk, and catching up peers tend to request the same
+// older blocks, so these are cached to avoid reading and decoding them for every request.
+type BlockCache struct {
+	size    uint
+	ll      *list.List                 // front is most recently used
+	m       map[[32]byte]*list.Element // GossipHash -> element with *FinalBlock
+	hits    uint64
+	misses  uint64
+	changed bool
+	mux     sync.Mutex
+}
+
+func (c *BlockCache) init(size uint) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.size = size
+	c.ll = list.New()
+	c.m = make(map[[32]byte]*list.Element)
+}
+
+func (c *BlockCache) get(gh [32]byte) (*FinalBlock, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.size == 0 {
+		return nil, false
+	}
+	c.changed = true
+	e, ok := c.m[gh]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.ll.MoveToFront(e)
+	return e.Value.(*FinalBlock), true
+}
+
+func (c *BlockCache) add(block *FinalBlock) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.size == 0 {
+		return
+	}
+	gh := block.ProposedBlock.GossipHash
+	if e, ok := c.m[gh]; ok {
+		e.Value = block
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.m[gh] = c.ll.PushFront(block)
+	for uint(c.ll.Len()) > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.m, oldest.Value.(*FinalBlock).ProposedBlock.GossipHash)
+	}
+}
+
+// drops every block, used when the blocks on disk are rewritten
+func (c *BlockCache) clear() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.ll = list.New()
+	c.m = make(map[[32]byte]*list.Element)
+}
+
+// returns "hits,misses,hitrate,cached blocks" if there has been lookups since last call
+func (c *BlockCache) statsIfChanged() (string, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if !c.changed {
+		return "", false
+	}
+	c.changed = false
+	rate := 0.0
+	if c.hits+c.misses > 0 {
+		rate = float64(c.hits) / float64(c.hits+c.misses)
+	}
+	return fmt.Sprintf("%d,%d,%.3f,%d", c.hits, c.misses, rate, c.ll.Len()), true
+}
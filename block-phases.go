@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// the durations of the consensus phases of every final block, results/phases*.csv
+
+type BlockPhases struct {
+	CommitteeID  [32]byte
+	Iteration    uint
+	GossipHash   [32]byte
+	Txs          int
+	Echos        int
+	Accepts      int
+	Created      time.Time
+	Disseminated time.Time
+	Proposed     time.Time
+	LastEcho     time.Time
+	AcceptSent   time.Time
+	Accepted     time.Time
+	Sent         time.Time
+	first        time.Time
+}
+
+// the phases of the blocks in consensus, by gossip hash
+type PhaseMarks struct {
+	blocks map[[32]byte]*BlockPhases
+	mux    sync.Mutex
+}
+
+func (pm *PhaseMarks) init() {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	pm.blocks = make(map[[32]byte]*BlockPhases)
+}
+
+// the phases of the block with gossip hash h, blocks that were never accepted are forgotten
+func (pm *PhaseMarks) _get(h [32]byte) *BlockPhases {
+	if p, ok := pm.blocks[h]; ok {
+		return p
+	}
+	now := clockNow()
+	for other, p := range pm.blocks {
+		if now.Sub(p.first) > metricStartTimeout*time.Second {
+			delete(pm.blocks, other)
+		}
+	}
+	p := &BlockPhases{GossipHash: h, first: now}
+	pm.blocks[h] = p
+	return p
+}
+
+// the leader created block at created and reconstructed it now
+func (pm *PhaseMarks) proposal(block *ProposedBlock, created time.Time) {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	p := pm._get(block.GossipHash)
+	p.Created = created
+	p.Disseminated = clockNow()
+}
+
+func (pm *PhaseMarks) subscribe(nodeCtx *NodeCtx) {
+	nodeCtx.events.subscribe(func(e Event) {
+		switch d := e.Data.(type) {
+		case VoteSentEvent:
+			pm.mux.Lock()
+			defer pm.mux.Unlock()
+			p := pm._get(d.GossipHash)
+			switch d.Tag {
+			case "propose":
+				p.Proposed = e.T
+			case "accept":
+				p.AcceptSent = e.T
+			}
+		case VoteReceivedEvent:
+			pm.mux.Lock()
+			defer pm.mux.Unlock()
+			p := pm._get(d.GossipHash)
+			switch d.Tag {
+			case "echo":
+				if p.AcceptSent.IsZero() {
+					p.LastEcho = e.T
+					p.Echos++
+				}
+			case "accept":
+				p.Accepts++
+			}
+		}
+	})
+}
+
+// the phases of the block with gossip hash h, which are forgotten
+func (pm *PhaseMarks) take(h [32]byte) *BlockPhases {
+	pm.mux.Lock()
+	defer pm.mux.Unlock()
+	p := pm._get(h)
+	delete(pm.blocks, h)
+	return p
+}
+
+// sends the phases of the block the leader accepted at accepted to the coordinator
+func reportBlockPhases(nodeCtx *NodeCtx, p *BlockPhases, block *ProposedBlock, accepted time.Time) {
+	p.CommitteeID = nodeCtx.committeeID()
+	if block != nil {
+		p.Iteration = block.Iteration
+		p.Txs = len(block.Transactions)
+	}
+	p.Accepted = accepted
+	p.Sent = clockNow()
+	spawnDialAndSend(coordinatorAddr(), Msg{"block_phases", *p, nodeCtx.self.Priv.Pub})
+}
+
+// the row of the phases for results/phases*.csv
+func (p *BlockPhases) row() string {
+	marks := []time.Time{p.Created, p.Disseminated, p.Proposed, p.LastEcho, p.AcceptSent, p.Accepted, p.Sent}
+	s := fmt.Sprintf("%s,%d,%s,%d,%d,%d", bytes32ToString(p.CommitteeID), p.Iteration, bytes32ToString(p.GossipHash), p.Txs, p.Echos, p.Accepts)
+	var first, last time.Time
+	for i := 1; i < len(marks); i++ {
+		if marks[i-1].IsZero() || marks[i].IsZero() {
+			s += ",-1"
+			continue
+		}
+		s += fmt.Sprintf(",%d", marks[i].Sub(marks[i-1]).Nanoseconds())
+	}
+	for _, t := range marks {
+		if t.IsZero() {
+			continue
+		}
+		if first.IsZero() {
+			first = t
+		}
+		last = t
+	}
+	return s + fmt.Sprintf(",%d", last.Sub(first).Nanoseconds())
+}
@@ -0,0 +1,55 @@
+package main
+
+// range queries of blocks for a node that is behind, capped by count and size
+
+type RequestBlocksMsg struct {
+	From  uint64 // first iteration
+	Limit uint64 // max blocks, capped by default_maxBlocksPerRequest
+}
+
+type RequestBlocksAnswer struct {
+	Blocks        []*FinalBlock // consecutive blocks starting at From
+	LastIteration uint64        // iteration of the latest block of the peer
+	More          bool          // the answer was capped, request again from the iteration after the last block
+}
+
+func answerRequestBlocks(nodeCtx *NodeCtx, rMsg RequestBlocksMsg) *RequestBlocksAnswer {
+	answer := new(RequestBlocksAnswer)
+	lastBlock := nodeCtx.blockchain.getLatest()
+	if lastBlock == nil {
+		return answer
+	}
+	answer.LastIteration = uint64(lastBlock.ProposedBlock.Iteration)
+
+	limit := rMsg.Limit
+	if limit == 0 || limit > default_maxBlocksPerRequest {
+		limit = default_maxBlocksPerRequest
+	}
+	size := 0
+	for h := rMsg.From; h <= answer.LastIteration; h++ {
+		if uint64(len(answer.Blocks)) >= limit {
+			answer.More = true
+			break
+		}
+		block := nodeCtx.blockchain.getByHeight(h)
+		if block == nil {
+			continue
+		}
+		// always send at least one block, even if it is larger than the cap
+		size += len(getBytes(block))
+		if size > default_maxRequestBlocksBytes && len(answer.Blocks) > 0 {
+			answer.More = true
+			break
+		}
+		answer.Blocks = append(answer.Blocks, block)
+	}
+	return answer
+}
+
+func requestBlocks(nodeCtx *NodeCtx, node *CommitteeMember, from uint64) *RequestBlocksAnswer {
+	request := Msg{"request_blocks", RequestBlocksMsg{from, default_maxBlocksPerRequest}, nodeCtx.self.Priv.Pub}
+	response := new(RequestBlocksAnswer)
+	// a peer that is down has no blocks
+	requestFrom(node.IP, request, response)
+	return response
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Append-only block store on disk. Every record is a 4 byte little endian length followed by the
+// gob encoded FinalBlock, zstd compressed if the highest bit of the length is set. The index by
+// hash and height is rebuilt by scanning the file when it is opened, so a restarted node can serve
+// blocks it committed before the restart.
+type BlockStore struct {
+	f           *os.File
+	size        int64
+	byHash      map[[32]byte]int64  // GossipHash -> offset of record
+	byHeight    map[uint64][32]byte // Iteration -> GossipHash
+	heights     []uint64            // sorted heights
+	compress    bool
+	cache       BlockCache // blocks read from disk
+	rawBytes    int64      // encoded size of the blocks appended since open
+	storedBytes int64      // size on disk of the blocks appended since open
+	mux         sync.Mutex
+}
+
+const storeCompressedFlag uint32 = 1 << 31
+
+func blockStorePath(dir string, committeeID [32]byte, ip string) string {
+	name := bytes32ToString(committeeID)[:16] + "-" + strings.NewReplacer(":", "_", ".", "_").Replace(ip) + ".blocks"
+	return filepath.Join(dir, name)
+}
+
+func (s *BlockStore) open(path string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	s.f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	s.byHash = make(map[[32]byte]int64)
+	s.byHeight = make(map[uint64][32]byte)
+	s.heights = []uint64{}
+	return s._rebuildIndex()
+}
+
+// scans the file and indexes every complete record. A partial record at the end (crash while
+// appending) is truncated.
+func (s *BlockStore) _rebuildIndex() error {
+	offset := int64(0)
+	for {
+		block, n, err := s._readAt(offset)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			nodeLog.warnf(nil, "[BlockStore] corrupt record at offset %v %v", offset, err)
+			break
+		}
+		s._index(block, offset)
+		offset += n
+	}
+	s.size = offset
+	if err := s.f.Truncate(offset); err != nil {
+		return err
+	}
+	if len(s.byHash) > 0 {
+		nodeLog.infof(nil, "[BlockStore] loaded %d blocks from %s", len(s.byHash), s.f.Name())
+	}
+	return nil
+}
+
+func (s *BlockStore) _index(block *FinalBlock, offset int64) {
+	gh := block.ProposedBlock.GossipHash
+	height := uint64(block.ProposedBlock.Iteration)
+	s.byHash[gh] = offset
+	if _, ok := s.byHeight[height]; !ok {
+		s.heights = append(s.heights, height)
+		sort.Slice(s.heights, func(i, j int) bool { return s.heights[i] < s.heights[j] })
+	}
+	s.byHeight[height] = gh
+}
+
+// reads the record at offset, returns the block and the length of the record
+func (s *BlockStore) _readAt(offset int64) (*FinalBlock, int64, error) {
+	var l [4]byte
+	if _, err := s.f.ReadAt(l[:], offset); err != nil {
+		return nil, 0, err
+	}
+	n := binary.LittleEndian.Uint32(l[:])
+	compressed := n&storeCompressedFlag != 0
+	n &^= storeCompressedFlag
+	data := make([]byte, n)
+	if _, err := s.f.ReadAt(data, offset+4); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	var err error
+	if compressed {
+		data, err = zstdDecompress(data)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	block := new(FinalBlock)
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(block)
+	if err != nil {
+		return nil, 0, err
+	}
+	return block, int64(n) + 4, nil
+}
+
+// length prefixed record of the block, and the size of the block before compression
+func (s *BlockStore) _encode(block *FinalBlock) ([]byte, int) {
+	data := getBytes(block)
+	raw := len(data)
+	l := uint32(len(data))
+	if s.compress {
+		data = zstdCompress(data)
+		l = uint32(len(data)) | storeCompressedFlag
+	}
+	var lb [4]byte
+	binary.LittleEndian.PutUint32(lb[:], l)
+	return byteSliceAppend(lb[:], data), raw
+}
+
+// appends a block, blocks allready in the store are ignored
+func (s *BlockStore) append(block *FinalBlock) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.byHash[block.ProposedBlock.GossipHash]; ok {
+		return
+	}
+	record, raw := s._encode(block)
+	_, err := s.f.WriteAt(record, s.size)
+	if ifErr(err, "block store append") {
+		return
+	}
+	s._index(block, s.size)
+	s.size += int64(len(record))
+	s.rawBytes += int64(raw)
+	s.storedBytes += int64(len(record))
+}
+
+// encoded and on disk size of the blocks appended since the store was opened
+func (s *BlockStore) compressionStats() (int64, int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.rawBytes, s.storedBytes
+}
+
+func (s *BlockStore) has(gh [32]byte) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_, ok := s.byHash[gh]
+	return ok
+}
+
+func (s *BlockStore) getByHash(gh [32]byte) *FinalBlock {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	offset, ok := s.byHash[gh]
+	if !ok {
+		return nil
+	}
+	if block, ok := s.cache.get(gh); ok {
+		return block
+	}
+	block, _, err := s._readAt(offset)
+	if ifErr(err, "block store read") {
+		return nil
+	}
+	s.cache.add(block)
+	return block
+}
+
+func (s *BlockStore) getByHeight(height uint64) *FinalBlock {
+	s.mux.Lock()
+	gh, ok := s.byHeight[height]
+	s.mux.Unlock()
+	if !ok {
+		return nil
+	}
+	return s.getByHash(gh)
+}
+
+// returns the block with the highest height that is lower or equal to height
+func (s *BlockStore) getByMaxHeight(height uint64) *FinalBlock {
+	s.mux.Lock()
+	i := sort.Search(len(s.heights), func(i int) bool { return s.heights[i] > height })
+	if i == 0 {
+		s.mux.Unlock()
+		return nil
+	}
+	h := s.heights[i-1]
+	s.mux.Unlock()
+	return s.getByHeight(h)
+}
+
+// returns the highest height in the store
+func (s *BlockStore) head() (uint64, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if len(s.heights) == 0 {
+		return 0, false
+	}
+	return s.heights[len(s.heights)-1], true
+}
+
+// rewrites the store with the transaction bodies of blocks below height removed.
+// Returns the size of the store before and after
+func (s *BlockStore) prune(below uint64) (int64, int64, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	before := s.size
+
+	path := s.f.Name()
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return before, before, err
+	}
+	offset := int64(0)
+	for offset < s.size {
+		block, n, err := s._readAt(offset)
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return before, before, err
+		}
+		offset += n
+		if uint64(block.ProposedBlock.Iteration) < below && !block.Pruned {
+			block = block.pruned()
+		}
+		record, _ := s._encode(block)
+		if _, err := tmp.Write(record); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return before, before, err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return before, before, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return before, before, err
+	}
+
+	// reopen and index the compacted file, cached blocks may have been pruned
+	s.cache.clear()
+	s.f.Close()
+	s.f, err = os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return before, before, err
+	}
+	s.byHash = make(map[[32]byte]int64)
+	s.byHeight = make(map[uint64][32]byte)
+	s.heights = []uint64{}
+	err = s._rebuildIndex()
+	return before, s.size, err
+}
+
+// Reconfiguration blocks are kept in a file next to the block store, so the certificates in the
+// store can be checked against the committees offline
+type StoredRecBlock struct {
+	FromIteration uint // first iteration the committees are used for
+	Block         *ReconfigurationBlock
+}
+
+func (s *BlockStore) appendRecBlock(from uint, rb *ReconfigurationBlock) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	f, err := os.OpenFile(s.f.Name()+".rec", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if ifErr(err, "opening reconfiguration block file") {
+		return
+	}
+	defer f.Close()
+	data := getBytes(StoredRecBlock{from, rb})
+	var l [4]byte
+	binary.LittleEndian.PutUint32(l[:], uint32(len(data)))
+	_, err = f.Write(byteSliceAppend(l[:], data))
+	ifErr(err, "writing reconfiguration block")
+}
+
+// reads the reconfiguration blocks stored next to the block store at path
+func readRecBlocks(path string) ([]StoredRecBlock, error) {
+	data, err := os.ReadFile(path + ".rec")
+	if err != nil {
+		return nil, err
+	}
+	recBlocks := []StoredRecBlock{}
+	for len(data) >= 4 {
+		n := int(binary.LittleEndian.Uint32(data[:4]))
+		if len(data) < 4+n {
+			break
+		}
+		rb := StoredRecBlock{}
+		if err := gob.NewDecoder(bytes.NewReader(data[4 : 4+n])).Decode(&rb); err != nil {
+			return recBlocks, err
+		}
+		recBlocks = append(recBlocks, rb)
+		data = data[4+n:]
+	}
+	return recBlocks, nil
+}
+
+// reads all complete blocks in the store at path in the order they were appended, without
+// modifying the file
+func readBlocks(path string) ([]*FinalBlock, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := &BlockStore{f: f}
+	blocks := []*FinalBlock{}
+	offset := int64(0)
+	for {
+		block, n, err := s._readAt(offset)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return blocks, nil
+		}
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, block)
+		offset += n
+	}
+}
+
+func (s *BlockStore) sync() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	ifErr(s.f.Sync(), "block store sync")
+}
+
+func (s *BlockStore) close() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	ifErr(s.f.Close(), "block store close")
+}
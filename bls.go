@@ -0,0 +1,337 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	bls12381 "github.com/kilic/bls12-381"
+)
+
+// bls signatures on BLS12-381, aggregated certificates and threshold keys of a committee
+
+var blsDomain = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_")
+var blsPopDomain = []byte("BLS_POP_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_")
+
+func blsOrder() *big.Int {
+	return bls12381.NewG1().Q()
+}
+
+func blsRandScalar() *big.Int {
+	for {
+		sk, err := rand.Int(rand.Reader, blsOrder())
+		ifErrFatal(err, "bls random scalar")
+		if sk.Sign() != 0 {
+			return sk
+		}
+	}
+}
+
+func blsPub(sk *big.Int) []byte {
+	g := bls12381.NewG1()
+	return g.ToCompressed(g.MulScalarBig(g.New(), g.One(), sk))
+}
+
+func blsHash(msg []byte, domain []byte) *bls12381.PointG2 {
+	h, err := bls12381.NewG2().HashToCurve(msg, domain)
+	ifErrFatal(err, "bls hash to curve")
+	return h
+}
+
+func blsSignDomain(sk *big.Int, msg []byte, domain []byte) []byte {
+	g := bls12381.NewG2()
+	return g.ToCompressed(g.MulScalarBig(g.New(), blsHash(msg, domain), sk))
+}
+
+func blsSign(sk *big.Int, msg []byte) []byte {
+	return blsSignDomain(sk, msg, blsDomain)
+}
+
+// decompression and the subgroup check are expensive, so decoded keys are cached. The points
+// are shared and must not be modified
+func blsDecodePub(pk []byte) (*bls12381.PointG1, error) {
+	if p, ok := keyCache.get(hash(pk)); ok {
+		return p.(*bls12381.PointG1), nil
+	}
+	g := bls12381.NewG1()
+	p, err := g.FromCompressed(pk)
+	if err != nil {
+		return nil, err
+	}
+	if g.IsZero(p) || !g.InCorrectSubgroup(p) {
+		return nil, errors.New("bls pub not in subgroup")
+	}
+	keyCache.add(hash(pk), p)
+	return p, nil
+}
+
+func blsDecodeSig(sig []byte) (*bls12381.PointG2, error) {
+	g := bls12381.NewG2()
+	s, err := g.FromCompressed(sig)
+	if err != nil {
+		return nil, err
+	}
+	if !g.InCorrectSubgroup(s) {
+		return nil, errors.New("bls sig not in subgroup")
+	}
+	return s, nil
+}
+
+// e(pk_i, H(msg_i)) for all i == e(g1, sig)
+func blsVerifyPairs(pks []*bls12381.PointG1, msgs [][]byte, domain []byte, sig []byte) bool {
+	s, err := blsDecodeSig(sig)
+	if err != nil || len(pks) != len(msgs) || len(pks) == 0 {
+		return false
+	}
+	e := bls12381.NewEngine()
+	for i := range pks {
+		e.AddPair(pks[i], blsHash(msgs[i], domain))
+	}
+	e.AddPairInv(e.G1.One(), s)
+	return e.Check()
+}
+
+func blsVerify(pk []byte, msg []byte, sig []byte) bool {
+	p, err := blsDecodePub(pk)
+	if err != nil {
+		return false
+	}
+	return blsVerifyPairs([]*bls12381.PointG1{p}, [][]byte{msg}, blsDomain, sig)
+}
+
+func blsAggregateSigs(sigs [][]byte) ([]byte, error) {
+	g := bls12381.NewG2()
+	agg := g.Zero()
+	for _, sig := range sigs {
+		s, err := blsDecodeSig(sig)
+		if err != nil {
+			return nil, err
+		}
+		g.Add(agg, agg, s)
+	}
+	return g.ToCompressed(agg), nil
+}
+
+func blsAggregatePubs(pks [][]byte) ([]byte, error) {
+	g := bls12381.NewG1()
+	agg := g.Zero()
+	for _, pk := range pks {
+		p, err := blsDecodePub(pk)
+		if err != nil {
+			return nil, err
+		}
+		g.Add(agg, agg, p)
+	}
+	return g.ToCompressed(agg), nil
+}
+
+// aggregate signature of every pk on its own msg, costs one pairing per signer
+func blsVerifyAggregate(pks [][]byte, msgs [][]byte, aggSig []byte) bool {
+	points := make([]*bls12381.PointG1, len(pks))
+	for i, pk := range pks {
+		p, err := blsDecodePub(pk)
+		if err != nil {
+			return false
+		}
+		points[i] = p
+	}
+	return blsVerifyPairs(points, msgs, blsDomain, aggSig)
+}
+
+// aggregate signature of every pk on the same msg, costs two pairings. Only safe if the
+// possession of every key has been proven
+func blsVerifySameMsg(pks [][]byte, msg []byte, aggSig []byte) bool {
+	aggPub, err := blsAggregatePubs(pks)
+	if err != nil {
+		return false
+	}
+	return blsVerify(aggPub, msg, aggSig)
+}
+
+func blsProvePossession(sk *big.Int) []byte {
+	return blsSignDomain(sk, blsPub(sk), blsPopDomain)
+}
+
+func blsVerifyPossession(pk []byte, proof []byte) bool {
+	p, err := blsDecodePub(pk)
+	if err != nil {
+		return false
+	}
+	return blsVerifyPairs([]*bls12381.PointG1{p}, [][]byte{pk}, blsPopDomain, proof)
+}
+
+// Vote certificate of a final block, the aggregate of the accept signatures. Pubs are the BLS keys
+// of the signers, the message of every signer is its accept ConsensusMsg.
+type BlsCertificate struct {
+	Pubs [][]byte
+	Sig  []byte
+}
+
+func blsAcceptMsg(gossipHash [32]byte, pk []byte) []byte {
+	cMsg := ConsensusMsg{GossipHash: gossipHash, Tag: "accept", Pub: &PubKey{Bytes: hash(pk)}}
+	h := cMsg.calculateHash()
+	return h[:]
+}
+
+// nil if any of the signers does not use a BLS key
+func aggregateCertificate(cMsgs []*ConsensusMsg) *BlsCertificate {
+	cert := new(BlsCertificate)
+	sigs := [][]byte{}
+	for _, cMsg := range cMsgs {
+		if cMsg.Pub == nil || cMsg.Pub.Bls == nil || cMsg.Sig == nil {
+			return nil
+		}
+		cert.Pubs = append(cert.Pubs, cMsg.Pub.Bls)
+		sigs = append(sigs, cMsg.Sig.Raw)
+	}
+	if len(sigs) == 0 {
+		return nil
+	}
+	agg, err := blsAggregateSigs(sigs)
+	if ifErr(err, "bls aggregate certificate") {
+		return nil
+	}
+	cert.Sig = agg
+	return cert
+}
+
+// returns "" if the certificate has enough distinct signers from members, otherwise the reason
+func (c *BlsCertificate) verifyError(gossipHash [32]byte, members map[[32]byte]bool, w Weights, requiredVotes int) string {
+	signers := make(map[[32]byte]bool)
+	msgs := [][]byte{}
+	for _, pk := range c.Pubs {
+		pub := hash(pk)
+		if !members[pub] {
+			return "certificate signer not a member"
+		}
+		if signers[pub] {
+			return "certificate signer twice"
+		}
+		signers[pub] = true
+		msgs = append(msgs, blsAcceptMsg(gossipHash, pk))
+	}
+	if w.ofSet(signers) < requiredVotes {
+		return fmt.Sprintf("%d of %d signatures", w.ofSet(signers), requiredVotes)
+	}
+	if !blsVerifyAggregate(c.Pubs, msgs, c.Sig) {
+		return "certificate signature"
+	}
+	return ""
+}
+
+func (b *FinalBlock) signerCount() int {
+	if len(b.Signatures) == 0 && b.Certificate != nil {
+		return len(b.Certificate.Pubs)
+	}
+	return len(b.Signatures)
+}
+
+// (t,n) threshold key of a committee. Share i (1..n) is f(i) of a random polynomial f of degree
+// t-1, the group key is f(0). The shares are dealt by a trusted dealer (the coordinator). Any t
+// members give the same signature, so the signature of a message everyone knows is unpredictable
+// randomness until t members have signed.
+type BlsThresholdKey struct {
+	T         int
+	N         int
+	GroupPub  []byte
+	SharePubs [][]byte // pub of share i at i-1, to verify partial signatures
+}
+
+type BlsShare struct {
+	Index int
+	Sk    *big.Int
+}
+
+type BlsPartialSig struct {
+	Index int
+	Sig   []byte
+}
+
+func blsDealThreshold(t, n int) (*BlsThresholdKey, []BlsShare) {
+	if t < 1 || t > n {
+		errFatal(nil, "bls threshold must be between 1 and n")
+	}
+	q := blsOrder()
+	coeffs := make([]*big.Int, t)
+	for i := range coeffs {
+		coeffs[i] = blsRandScalar()
+	}
+	key := &BlsThresholdKey{T: t, N: n, GroupPub: blsPub(coeffs[0])}
+	shares := make([]BlsShare, n)
+	for i := 1; i <= n; i++ {
+		// horner
+		x := big.NewInt(int64(i))
+		y := new(big.Int)
+		for j := t - 1; j >= 0; j-- {
+			y.Mul(y, x)
+			y.Add(y, coeffs[j])
+			y.Mod(y, q)
+		}
+		shares[i-1] = BlsShare{i, y}
+		key.SharePubs = append(key.SharePubs, blsPub(y))
+	}
+	return key, shares
+}
+
+func (s BlsShare) signPartial(msg []byte) BlsPartialSig {
+	return BlsPartialSig{s.Index, blsSign(s.Sk, msg)}
+}
+
+func (k *BlsThresholdKey) verifyPartial(msg []byte, p BlsPartialSig) bool {
+	if p.Index < 1 || p.Index > len(k.SharePubs) {
+		return false
+	}
+	return blsVerify(k.SharePubs[p.Index-1], msg, p.Sig)
+}
+
+// lagrange coefficient at 0 of index i for the set of indexes
+func blsLagrange(i int, indexes []int) *big.Int {
+	q := blsOrder()
+	num := big.NewInt(1)
+	den := big.NewInt(1)
+	for _, j := range indexes {
+		if j == i {
+			continue
+		}
+		num.Mul(num, big.NewInt(int64(j)))
+		num.Mod(num, q)
+		den.Mul(den, big.NewInt(int64(j-i)))
+		den.Mod(den, q)
+	}
+	return num.Mul(num, den.ModInverse(den, q)).Mod(num, q)
+}
+
+// combines t partial signatures (which should be verified first) to the signature of the group key
+func (k *BlsThresholdKey) combine(partials []BlsPartialSig) ([]byte, error) {
+	chosen := []BlsPartialSig{}
+	indexes := []int{}
+	seen := make(map[int]bool)
+	for _, p := range partials {
+		if len(chosen) == k.T {
+			break
+		}
+		if !seen[p.Index] {
+			seen[p.Index] = true
+			chosen = append(chosen, p)
+			indexes = append(indexes, p.Index)
+		}
+	}
+	if len(chosen) < k.T {
+		return nil, errors.New("not enough partial signatures")
+	}
+	g := bls12381.NewG2()
+	sig := g.Zero()
+	for _, p := range chosen {
+		s, err := blsDecodeSig(p.Sig)
+		if err != nil {
+			return nil, err
+		}
+		g.Add(sig, sig, g.MulScalarBig(g.New(), s, blsLagrange(p.Index, indexes)))
+	}
+	return g.ToCompressed(sig), nil
+}
+
+func (k *BlsThresholdKey) verify(msg []byte, sig []byte) bool {
+	return blsVerify(k.GroupPub, msg, sig)
+}
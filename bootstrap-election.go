@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// -bootstrap election, the first committees sampled by an election of the pow identities
+
+const bootstrapByCoordinator = "coordinator"
+const bootstrapByElection = "election"
+
+func isBootstrapMode(s string) bool {
+	return s == bootstrapByCoordinator || s == bootstrapByElection
+}
+
+func electionSeed(challenge PowChallenge, runSeed int64) [32]byte {
+	return hash(byteSliceAppend([]byte("election"), challenge.Seed[:], uintToByte(uint(runSeed))))
+}
+
+func electionTicket(seed [32]byte, level int, pub [32]byte) [32]byte {
+	return hash(byteSliceAppend(seed[:], uintToByte(uint(level)), pub[:]))
+}
+
+func sortByTicket(pubs [][32]byte, seed [32]byte, level int) {
+	tickets := make(map[[32]byte][32]byte)
+	for _, pub := range pubs {
+		tickets[pub] = electionTicket(seed, level, pub)
+	}
+	sort.Slice(pubs, func(i, j int) bool {
+		a, b := tickets[pubs[i]], tickets[pubs[j]]
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+}
+
+type Election struct {
+	levels     int
+	root       [][32]byte
+	randomness [32]byte
+	committees map[[32]byte][32]byte // Pub.Bytes -> committee id
+	ids        [][32]byte
+}
+
+// the groups of the next level, the last one takes the rest if it is less than half a group
+func electionGroups(candidates [][32]byte, groupSize int) [][][32]byte {
+	groups := [][][32]byte{}
+	for i := 0; i < len(candidates); i += groupSize {
+		end := i + groupSize
+		if end > len(candidates) || len(candidates)-end < groupSize/2 {
+			end = len(candidates)
+		}
+		groups = append(groups, candidates[i:end])
+		i = end - groupSize
+	}
+	return groups
+}
+
+// runs the election network on pubs with seed and samples m committees
+func elect(pubs [][32]byte, seed [32]byte, m int, groupSize int) *Election {
+	e := &Election{committees: make(map[[32]byte][32]byte)}
+	candidates := append([][32]byte{}, pubs...)
+	for level := 0; ; level++ {
+		e.levels++
+		sortByTicket(candidates, seed, 2*level)
+		groups := electionGroups(candidates, groupSize)
+		if len(groups) == 1 {
+			e.root = groups[0]
+			break
+		}
+		next := [][32]byte{}
+		for _, g := range groups {
+			winners := append([][32]byte{}, g...)
+			sortByTicket(winners, seed, 2*level+1)
+			next = append(next, winners[:(len(winners)+1)/2]...)
+		}
+		candidates = next
+	}
+
+	root := append([][32]byte{}, e.root...)
+	sort.Slice(root, func(i, j int) bool { return bytes.Compare(root[i][:], root[j][:]) < 0 })
+	all := seed[:]
+	for _, pub := range root {
+		all = byteSliceAppend(all, pub[:])
+	}
+	e.randomness = hash(all)
+
+	for i := 0; i < m; i++ {
+		e.ids = append(e.ids, hash(byteSliceAppend(e.randomness[:], []byte("committee"), uintToByte(uint(i)))))
+	}
+	order := make([][32]byte, len(pubs))
+	tickets := make(map[[32]byte][32]byte)
+	for i, pub := range pubs {
+		order[i] = pub
+		tickets[pub] = hash(byteSliceAppend(e.randomness[:], pub[:]))
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := tickets[order[i]], tickets[order[j]]
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	for i, pub := range order {
+		e.committees[pub] = e.ids[i*m/len(order)]
+	}
+	return e
+}
+
+// assigns nodeInfos to the committees of the election and picks the adversaries. Returns the
+// committees and the row of the election csv
+func electionBootstrap(flagArgs *FlagArgs, nodeInfos []NodeAllInfo, seed [32]byte, registered time.Duration) ([]committeeInfo, *Election, string) {
+	start := clockNow()
+	sortNodeInfos(nodeInfos)
+	pubs := [][32]byte{}
+	for _, info := range nodeInfos {
+		pubs = append(pubs, info.Pub.Bytes)
+	}
+	e := elect(pubs, seed, int(flagArgs.m), default_electionGroupSize)
+	elected := clockSince(start)
+
+	adversaries := make([]int, len(nodeInfos))
+	for i := range adversaries {
+		adversaries[i] = i
+	}
+	protocolRand.Shuffle(len(adversaries), func(i, j int) { adversaries[i], adversaries[j] = adversaries[j], adversaries[i] })
+	for i := range nodeInfos {
+		nodeInfos[i].CommitteeID = e.committees[nodeInfos[i].Pub.Bytes]
+		nodeInfos[i].IsHonest = true
+	}
+	for _, i := range adversaries[:len(nodeInfos)/int(flagArgs.totalF)] {
+		nodeInfos[i].IsHonest = false
+	}
+
+	infos := make(map[[32]byte]*committeeInfo)
+	committeeInfos := make([]committeeInfo, len(e.ids))
+	for i, id := range e.ids {
+		committeeInfos[i].id = id
+		infos[id] = &committeeInfos[i]
+	}
+	for _, info := range nodeInfos {
+		ci := infos[info.CommitteeID]
+		ci.npm++
+		if !info.IsHonest {
+			ci.f++
+		}
+	}
+	unsafe, most := 0, 0.0
+	for _, ci := range committeeInfos {
+		if !underCommitteeBound(ci.f, int(ci.npm), flagArgs.committeeF) {
+			coordinatorLog.warnf(nil, "[Bootstrap] committee %s has %d adversaries of %d members, over the 1/%d bound", bytes32ToString(ci.id), ci.f, ci.npm, flagArgs.committeeF)
+			unsafe++
+		}
+		most = math.Max(most, float64(ci.f)/float64(ci.npm))
+	}
+	coordinatorLog.infof(nil, "[Bootstrap] %d identities elected a root group of %d in %d levels, %d committees after %s", len(pubs), len(e.root), e.levels, len(e.ids), elected)
+	row := fmt.Sprintf("%d,%d,%d,%d,%d,%d,%d,%.4f", len(pubs), len(e.ids), e.levels, len(e.root), registered.Milliseconds(), elected.Milliseconds(), unsafe, most)
+	return committeeInfos, e, row
+}
+
+// returns "" if the committees of the coordinator are the ones of the election, otherwise the reason
+func verifyElection(response *ResponseToNodes, m uint) string {
+	pubs := [][32]byte{}
+	for _, info := range response.Nodes {
+		pubs = append(pubs, info.Pub.Bytes)
+	}
+	sort.Slice(pubs, func(i, j int) bool { return bytes.Compare(pubs[i][:], pubs[j][:]) < 0 })
+	e := elect(pubs, response.ElectionSeed, int(m), default_electionGroupSize)
+	for _, info := range response.Nodes {
+		if e.committees[info.Pub.Bytes] != info.CommitteeID {
+			return "committee of " + bytes32ToString(info.Pub.Bytes)
+		}
+	}
+	if r := response.ReconfigurationBlock.Randomness; r != [32]byte{} && r != e.randomness {
+		return "randomness"
+	}
+	return ""
+}
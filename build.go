@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// the version and commit of the binary, the coordinator refuses nodes of another build
+
+// set by the linker
+var version string
+
+type Build struct {
+	Version string
+	Commit  string
+}
+
+func (b Build) String() string {
+	if b == (Build{}) {
+		return "no build"
+	}
+	return b.Version + " (" + b.Commit + ")"
+}
+
+// a build with a commit of git
+func (b Build) known() bool {
+	return b.Commit != "" && b.Commit != unknownCommit
+}
+
+var ownBuild Build
+var ownBuildOnce sync.Once
+
+// the build of this binary
+func currentBuild() Build {
+	ownBuildOnce.Do(func() {
+		ownBuild = Build{version, runCommit()}
+		if ownBuild.Version == "" {
+			ownBuild.Version = "dev"
+		}
+	})
+	return ownBuild
+}
+
+func printVersion() {
+	fmt.Printf("rapidchain %s, %s %s/%s\n", currentBuild(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// the builds of the nodes the coordinator admitted
+type BuildCheck struct {
+	mixed bool
+	nodes map[Build]uint
+	mux   sync.Mutex
+}
+
+func (c *BuildCheck) init(flagArgs *FlagArgs) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.mixed = flagArgs.mixedBuilds
+	c.nodes = make(map[Build]uint)
+}
+
+// why the node at addr with build is refused, empty if it is admitted
+func (c *BuildCheck) admit(addr string, build Build) string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	own := currentBuild()
+	if build != own {
+		if !c.mixed && build.known() && own.known() {
+			coordinatorLog.warnf(nil, "[Build] refused node at %s with %s, the coordinator has %s", addr, build, own)
+			return fmt.Sprintf("the node has %s and the coordinator %s, deploy the same binary everywhere or run the coordinator with -mixedBuilds", build, own)
+		}
+		coordinatorLog.warnf(nil, "[Build] node at %s has %s, the coordinator %s", addr, build, own)
+	}
+	c.nodes[build]++
+	return ""
+}
+
+// the number of nodes of every build, for the manifest
+func (c *BuildCheck) counts() map[string]uint {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	counts := make(map[string]uint, len(c.nodes))
+	for b, n := range c.nodes {
+		counts[b.String()] = n
+	}
+	return counts
+}
@@ -0,0 +1,13 @@
+package main
+
+// blackhole: the node does not route the txs and cross-txs it should send to another committee,
+// the txs are only lost if every member of the committee that routes them does the same
+type blackholeStrategy struct {
+	honestStrategy
+}
+
+func (blackholeStrategy) name() string { return "blackhole" }
+
+func (blackholeStrategy) route(nodeCtx *NodeCtx, msg *Msg) bool {
+	return false
+}
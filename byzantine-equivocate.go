@@ -0,0 +1,18 @@
+package main
+
+// equivocate: as leader the node gossips a second block without the last tx of its block, signed
+// like the first one, so the nodes that reconstruct both can prove it and it is blacklisted, see
+// blacklist.go
+type equivocateStrategy struct {
+	honestStrategy
+}
+
+func (equivocateStrategy) name() string { return "equivocate" }
+
+func (equivocateStrategy) lead(nodeCtx *NodeCtx, block *ProposedBlock) []*ProposedBlock {
+	if other := equivocatingBlock(nodeCtx, block); other != nil {
+		consensusLog.infof(nodeCtx, "[Blacklist] equivocating in iteration %d", block.Iteration)
+		return []*ProposedBlock{block, other}
+	}
+	return []*ProposedBlock{block}
+}
@@ -0,0 +1,14 @@
+package main
+
+// silent: the node takes part in the committee but never sends an echo, pending or accept vote. As
+// leader it still proposes, so the committee needs the votes of the others to reach the accept
+// threshold and a committee with more silent members than its bound stalls
+type silentStrategy struct {
+	honestStrategy
+}
+
+func (silentStrategy) name() string { return "silent" }
+
+func (silentStrategy) vote(nodeCtx *NodeCtx, cMsg *ConsensusMsg) bool {
+	return cMsg.Tag == "propose"
+}
@@ -0,0 +1,13 @@
+package main
+
+// withhold: the node keeps the ida chunks it gets of blocks of other leaders, it reconstructs them
+// but does not gossip them on, so its neighbours have to get enough chunks from the other ones
+type withholdStrategy struct {
+	honestStrategy
+}
+
+func (withholdStrategy) name() string { return "withhold" }
+
+func (withholdStrategy) chunk(nodeCtx *NodeCtx, idaMsg *IDAGossipMsg) bool {
+	return idaMsg.Typ != "block" || nodeCtx.amILeader()
+}
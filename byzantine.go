@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the strategies of -byzantine, the hooks where an adversary differs from an honest node
+
+type ByzantineStrategy interface {
+	name() string
+	// the vote the node is about to send, false if it is not sent
+	vote(nodeCtx *NodeCtx, cMsg *ConsensusMsg) bool
+	// the blocks the node gossips instead of block as leader, the first one is the one it proposes
+	lead(nodeCtx *NodeCtx, block *ProposedBlock) []*ProposedBlock
+	// a new ida chunk the node got, false if it is not gossiped on
+	chunk(nodeCtx *NodeCtx, idaMsg *IDAGossipMsg) bool
+	// a tx the node routes to another committee, false if it is not routed
+	route(nodeCtx *NodeCtx, msg *Msg) bool
+}
+
+// the strategies of -byzantine by name, see the byzantine-*.go files
+var byzantineStrategies = map[string]func() ByzantineStrategy{
+	"equivocate": func() ByzantineStrategy { return equivocateStrategy{} },
+	"silent":     func() ByzantineStrategy { return silentStrategy{} },
+	"withhold":   func() ByzantineStrategy { return withholdStrategy{} },
+	"blackhole":  func() ByzantineStrategy { return blackholeStrategy{} },
+}
+
+// does what an honest node does, the strategies embed it and override their hooks
+type honestStrategy struct{}
+
+func (honestStrategy) vote(nodeCtx *NodeCtx, cMsg *ConsensusMsg) bool { return true }
+
+func (honestStrategy) lead(nodeCtx *NodeCtx, block *ProposedBlock) []*ProposedBlock {
+	return []*ProposedBlock{block}
+}
+
+func (honestStrategy) chunk(nodeCtx *NodeCtx, idaMsg *IDAGossipMsg) bool { return true }
+
+func (honestStrategy) route(nodeCtx *NodeCtx, msg *Msg) bool { return true }
+
+type byzantineEntry struct {
+	prefix   string
+	strategy string
+}
+
+// the entries of -byzantine, returns "" or the reason it is invalid
+func parseByzantine(s string) ([]byzantineEntry, string) {
+	entries := []byzantineEntry{}
+	if s == "" {
+		return entries, ""
+	}
+	for _, e := range strings.Split(s, ",") {
+		entry := byzantineEntry{strategy: strings.TrimSpace(e)}
+		if kv := strings.SplitN(entry.strategy, "=", 2); len(kv) == 2 {
+			entry.prefix, entry.strategy = kv[0], kv[1]
+		}
+		if _, ok := byzantineStrategies[entry.strategy]; !ok {
+			return nil, "unknown -byzantine strategy " + entry.strategy
+		}
+		entries = append(entries, entry)
+	}
+	return entries, ""
+}
+
+// the strategies of a dishonest node, nil for an honest one
+type Byzantine struct {
+	strategies []ByzantineStrategy
+}
+
+func newByzantine(nodeCtx *NodeCtx) *Byzantine {
+	if nodeCtx.self.IsHonest {
+		return nil
+	}
+	// checked by checkByzantine
+	entries, _ := parseByzantine(nodeCtx.flagArgs.byzantine)
+	if nodeCtx.flagArgs.equivocate {
+		entries = append(entries, byzantineEntry{"", "equivocate"})
+	}
+	key := bytes32ToString(nodeCtx.self.Priv.Pub.Bytes)
+	picked, rest := []string{}, []string{}
+	for _, e := range entries {
+		if e.prefix == "" {
+			rest = append(rest, e.strategy)
+		} else if strings.HasPrefix(key, e.prefix) {
+			picked = append(picked, e.strategy)
+		}
+	}
+	if len(picked) == 0 && len(rest) > 0 {
+		picked = append(picked, rest[int(nodeCtx.self.Priv.Pub.Bytes[0])%len(rest)])
+	}
+	if len(picked) == 0 {
+		return nil
+	}
+	b := &Byzantine{}
+	for _, name := range picked {
+		b.strategies = append(b.strategies, byzantineStrategies[name]())
+	}
+	nodeLog.warnf(nodeCtx, "[Byzantine] %s", b.String())
+	return b
+}
+
+func (b *Byzantine) String() string {
+	names := []string{}
+	for _, s := range b.strategies {
+		names = append(names, s.name())
+	}
+	return strings.Join(names, ",")
+}
+
+// false if one of the strategies does not send the vote
+func (b *Byzantine) vote(nodeCtx *NodeCtx, cMsg *ConsensusMsg) bool {
+	if b == nil {
+		return true
+	}
+	for _, s := range b.strategies {
+		if !s.vote(nodeCtx, cMsg) {
+			consensusLog.debugf(nodeCtx, "[Byzantine] %s did not send %s", s.name(), cMsg.Tag)
+			return false
+		}
+	}
+	return true
+}
+
+// the blocks to gossip for block, every strategy on the blocks of the one before
+func (b *Byzantine) lead(nodeCtx *NodeCtx, block *ProposedBlock) []*ProposedBlock {
+	blocks := []*ProposedBlock{block}
+	if b == nil {
+		return blocks
+	}
+	for _, s := range b.strategies {
+		next := []*ProposedBlock{}
+		for _, gossiped := range blocks {
+			next = append(next, s.lead(nodeCtx, gossiped)...)
+		}
+		if len(next) != len(blocks) {
+			consensusLog.infof(nodeCtx, "[Byzantine] %s gossips %d blocks in iteration %d", s.name(), len(next), block.Iteration)
+		}
+		blocks = next
+	}
+	return blocks
+}
+
+// false if one of the strategies does not gossip the chunk on
+func (b *Byzantine) chunk(nodeCtx *NodeCtx, idaMsg *IDAGossipMsg) bool {
+	if b == nil {
+		return true
+	}
+	for _, s := range b.strategies {
+		if !s.chunk(nodeCtx, idaMsg) {
+			idaLog.debugf(nodeCtx, "[Byzantine] %s kept chunks of %s", s.name(), bytes32ToString(idaMsg.MerkleRoot))
+			return false
+		}
+	}
+	return true
+}
+
+// false if one of the strategies does not route the msg
+func (b *Byzantine) route(nodeCtx *NodeCtx, msg *Msg) bool {
+	if b == nil {
+		return true
+	}
+	for _, s := range b.strategies {
+		if !s.route(nodeCtx, msg) {
+			nodeLog.debugf(nodeCtx, "[Byzantine] %s did not route %s", s.name(), msg.Typ)
+			return false
+		}
+	}
+	return true
+}
+
+// checks -byzantine before the nodes start
+func checkByzantine(flagArgs *FlagArgs) {
+	if _, reason := parseByzantine(flagArgs.byzantine); reason != "" {
+		names := []string{}
+		for name := range byzantineStrategies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		errFatal(nil, fmt.Sprintf("%s, give one of %s", reason, strings.Join(names, ", ")))
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Canonical encoding of blocks, used for hashing instead of gob so block hashes does not depend on
+// the go types, gob version or codec. Integers are 8 byte little endian, fixed size hashes and pub
+// keys (Pub.Bytes) are written as is, variable length bytes and lists are prefixed with their length.
+// Changing anything here changes every block hash, so bump canonicalVersion and the golden vectors.
+const canonicalVersion = 2
+
+type canonicalWriter struct {
+	buf *bytes.Buffer
+}
+
+func (w canonicalWriter) uint(u uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], u)
+	w.buf.Write(b[:])
+}
+
+func (w canonicalWriter) bytes32(b [32]byte) {
+	w.buf.Write(b[:])
+}
+
+func (w canonicalWriter) bytes(b []byte) {
+	w.uint(uint64(len(b)))
+	w.buf.Write(b)
+}
+
+func (w canonicalWriter) bool(b bool) {
+	if b {
+		w.buf.WriteByte(1)
+	} else {
+		w.buf.WriteByte(0)
+	}
+}
+
+func (w canonicalWriter) pub(p *PubKey) {
+	if p == nil {
+		w.bytes32([32]byte{})
+		return
+	}
+	w.bytes32(p.Bytes)
+}
+
+// R and S are empty for schemes other than ecdsa, Raw is empty for ecdsa
+func (w canonicalWriter) sig(s *Sig) {
+	if s == nil {
+		w.bytes(nil)
+		w.bytes(nil)
+		w.bytes(nil)
+		return
+	}
+	if s.R == nil || s.S == nil {
+		w.bytes(nil)
+		w.bytes(nil)
+	} else {
+		w.bytes(s.R.Bytes())
+		w.bytes(s.S.Bytes())
+	}
+	w.bytes(s.Raw)
+}
+
+// header fields that are hashed in calculateHashExceptMerkleRoot
+func (b *ProposedBlock) writeCanonicalHeader(w canonicalWriter) {
+	w.uint(canonicalVersion)
+	w.bytes32(b.PreviousGossipHash)
+	w.uint(uint64(b.Iteration))
+	w.bytes32(b.CommitteeID)
+	w.pub(b.LeaderPub)
+}
+
+func (t *Transaction) writeCanonical(w canonicalWriter) {
+	w.bytes32(t.Hash)
+	w.bytes32(t.OrigTxHash)
+	w.uint(uint64(len(t.Inputs)))
+	for _, inp := range t.Inputs {
+		w.bytes32(inp.TxHash)
+		w.uint(uint64(inp.N))
+		w.sig(inp.Sig)
+	}
+	w.uint(uint64(len(t.Outputs)))
+	for _, out := range t.Outputs {
+		w.uint(uint64(out.Value))
+		w.uint(uint64(out.N))
+		w.pub(out.PubKey)
+	}
+}
+
+// the whole block: header, merkle root, hash, leader signature and transactions.
+// Proof of consensus on transactions is not included since it is not part of the block hash
+func (b *ProposedBlock) writeCanonical(w canonicalWriter) {
+	b.writeCanonicalHeader(w)
+	w.bytes32(b.MerkleRoot)
+	w.bytes32(b.GossipHash)
+	w.sig(b.LeaderSig)
+	w.uint(uint64(len(b.Transactions)))
+	for _, t := range b.Transactions {
+		t.writeCanonical(w)
+	}
+}
+
+func (b *FinalBlock) writeCanonical(w canonicalWriter) {
+	b.ProposedBlock.writeCanonical(w)
+	w.bool(b.Pruned)
+	w.uint(uint64(len(b.Signatures)))
+	for _, cMsg := range b.Signatures {
+		w.bytes32(cMsg.GossipHash)
+		w.bytes([]byte(cMsg.Tag))
+		w.pub(cMsg.Pub)
+		w.sig(cMsg.Sig)
+	}
+	// only written if there is one, so blocks without are encoded as before
+	if b.Certificate != nil {
+		w.uint(uint64(len(b.Certificate.Pubs)))
+		for _, pk := range b.Certificate.Pubs {
+			w.bytes(pk)
+		}
+		w.bytes(b.Certificate.Sig)
+	}
+}
+
+func (b *ProposedBlock) canonicalBytes() []byte {
+	buf := new(bytes.Buffer)
+	b.writeCanonical(canonicalWriter{buf})
+	return buf.Bytes()
+}
+
+func (b *FinalBlock) canonicalBytes() []byte {
+	buf := new(bytes.Buffer)
+	b.writeCanonical(canonicalWriter{buf})
+	return buf.Bytes()
+}
+
+// hash of the canonical encoding of the final block, including signatures
+func (b *FinalBlock) calculateHash() [32]byte {
+	return hash(b.canonicalBytes())
+}
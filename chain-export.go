@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// the chains of the committees as results/chains*.json and results/chains*.dot
+
+type ChainExportBlock struct {
+	Height     uint      `json:"height"`
+	Hash       string    `json:"hash"`
+	Previous   string    `json:"previous"`
+	Leader     string    `json:"leader"`
+	Txs        int       `json:"txs"`
+	Signatures int       `json:"signatures"`
+	Time       time.Time `json:"time"` // when the coordinator recived the block
+}
+
+type ChainExportCommittee struct {
+	ID     string             `json:"id"`
+	Blocks []ChainExportBlock `json:"blocks"`
+}
+
+type ChainExport struct {
+	m   map[[32]byte]map[[32]byte]ChainExportBlock // CommitteeID -> GossipHash -> block
+	mux sync.Mutex
+}
+
+func (c *ChainExport) init() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.m = make(map[[32]byte]map[[32]byte]ChainExportBlock)
+}
+
+func (c *ChainExport) add(b *FinalBlock) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	pb := b.ProposedBlock
+	if _, ok := c.m[pb.CommitteeID]; !ok {
+		c.m[pb.CommitteeID] = make(map[[32]byte]ChainExportBlock)
+	}
+	leader := ""
+	if pb.LeaderPub != nil {
+		leader = bytes32ToString(pb.LeaderPub.Bytes)
+	}
+	for h, other := range c.m[pb.CommitteeID] {
+		if other.Height == pb.Iteration && h != pb.GossipHash {
+			safetyViolation(fmt.Sprintf("committee %s has final blocks %s and %s at height %d", bytes32ToString(pb.CommitteeID), other.Hash, bytes32ToString(pb.GossipHash), pb.Iteration))
+			faultCoverage.react("violation", bytes32ToString(pb.CommitteeID), "")
+		}
+	}
+	previous := ""
+	if pb.PreviousGossipHash != [32]byte{} {
+		previous = bytes32ToString(pb.PreviousGossipHash)
+	}
+	c.m[pb.CommitteeID][pb.GossipHash] = ChainExportBlock{pb.Iteration, bytes32ToString(pb.GossipHash), previous, leader, len(pb.Transactions), b.signerCount(), clockNow()}
+}
+
+func (c *ChainExport) addGenesis(blocks []*FinalBlock) {
+	for _, b := range blocks {
+		c.add(b)
+	}
+}
+
+// committees sorted by id, blocks sorted by height
+func (c *ChainExport) committees() []ChainExportCommittee {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	res := []ChainExportCommittee{}
+	for id, blocks := range c.m {
+		committee := ChainExportCommittee{bytes32ToString(id), []ChainExportBlock{}}
+		for _, b := range blocks {
+			committee.Blocks = append(committee.Blocks, b)
+		}
+		sort.Slice(committee.Blocks, func(i, j int) bool {
+			if committee.Blocks[i].Height != committee.Blocks[j].Height {
+				return committee.Blocks[i].Height < committee.Blocks[j].Height
+			}
+			return committee.Blocks[i].Hash < committee.Blocks[j].Hash
+		})
+		res = append(res, committee)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func chainsDot(committees []ChainExportCommittee) string {
+	var sb strings.Builder
+	sb.WriteString("digraph chains {\n\trankdir=LR;\n\tnode [shape=box, fontname=monospace];\n")
+	for i, committee := range committees {
+		fmt.Fprintf(&sb, "\tsubgraph cluster_%d {\n\t\tlabel=\"committee %s\";\n", i, committee.ID[:8])
+		known := make(map[string]bool)
+		heights := make(map[uint]int)
+		for _, b := range committee.Blocks {
+			known[b.Hash] = true
+			heights[b.Height]++
+		}
+		for _, b := range committee.Blocks {
+			leader := b.Leader
+			if len(leader) > 8 {
+				leader = leader[:8]
+			}
+			color := "black"
+			if heights[b.Height] > 1 {
+				// more than one block at this height
+				color = "red"
+			}
+			fmt.Fprintf(&sb, "\t\t\"%s\" [label=\"h=%d %s\\nleader %s\\n%d tx, %d sigs\\n%s\", color=%s];\n",
+				b.Hash, b.Height, b.Hash[:8], leader, b.Txs, b.Signatures, b.Time.Format("15:04:05.000"), color)
+		}
+		for _, b := range committee.Blocks {
+			if b.Previous == "" {
+				continue
+			}
+			if !known[b.Previous] {
+				fmt.Fprintf(&sb, "\t\t\"%s\" [label=\"missing %s\", style=dashed];\n", b.Previous, b.Previous[:8])
+				known[b.Previous] = true
+			}
+			fmt.Fprintf(&sb, "\t\t\"%s\" -> \"%s\";\n", b.Previous, b.Hash)
+		}
+		sb.WriteString("\t}\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func (c *ChainExport) write(name string) {
+	committees := c.committees()
+	data, err := json.MarshalIndent(map[string]interface{}{"committees": committees}, "", "  ")
+	if ifErr(err, "chain export json") {
+		return
+	}
+	ifErr(os.WriteFile(name+".json", data, 0644), "chain export json")
+	ifErr(os.WriteFile(name+".dot", []byte(chainsDot(committees)), 0644), "chain export dot")
+	coordinatorLog.infof(nil, "Exported chains of %d committees to %s.json and %s.dot", len(committees), name, name)
+}
+
+// writes the chains, the global ledger and the stats per epoch when the coordinator is stopped (the
+// experiment ends by killing it)
+// also when the watchdog aborts the run, see watchdog.go, and exits with the code of exit-status.go
+func exportOnExit(c *ChainExport, ledger *GlobalLedger, epochStats *EpochStats, wire *WireResults, manifest *Manifest, ms *Membership, aborted <-chan string) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	code := 0
+	reason := ""
+	select {
+	case <-sigs:
+	case reason = <-aborted:
+		code = exitAborted
+	}
+	if simNet != nil {
+		simNet.scheduler.halt()
+	}
+	c.write(resultsName("chains"))
+	ledger.write(resultsName("ledger"))
+	epochStats.write(resultsName("epochstats"))
+	wire.write(resultsName("wire"))
+	writeTrace(c, manifest, resultsName("trace")+".txt")
+	faultCoverage.write(resultsName("faults") + ".csv")
+	statsLimit.report()
+	standbyMirror.end()
+	// the status is a file of the run in the manifest
+	code = exitStatus.finish(code, reason)
+	manifest.finish(ms, reason)
+	os.Exit(code)
+}
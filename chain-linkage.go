@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Reported to the coordinator when a block does not extend the head of a nodes blockchain
+type ForkReport struct {
+	CommitteeID  [32]byte
+	Pub          [32]byte // reporting node
+	Stage        string   // propose, accept or sync
+	Reason       string
+	Iteration    uint     // iteration of the block
+	GossipHash   [32]byte // block
+	PreviousHash [32]byte // parent the block commits to
+	HeadHash     [32]byte // head of the reporting node
+	HeadIter     uint
+}
+
+// Checks that the block commits to the current head as its parent and is the next iteration.
+// Returns "" if it extends the head, otherwise the reason:
+//
+//	fork: the parent is a block we have, but not the head
+//	unknown parent: the parent is not in the blockchain, we are probably behind
+//	iteration: the parent is the head, but the iteration is not the next one
+func checkLinkage(nodeCtx *NodeCtx, block *ProposedBlock) (string, *FinalBlock) {
+	nodeCtx.blockchain.mux.Lock()
+	defer nodeCtx.blockchain.mux.Unlock()
+	head := nodeCtx.blockchain._getLatest()
+	if head == nil {
+		// no blocks yet, waiting for state sync
+		return "unknown parent", &FinalBlock{ProposedBlock: new(ProposedBlock)}
+	}
+	if block.PreviousGossipHash != head.ProposedBlock.GossipHash {
+		if nodeCtx.blockchain._isBlock(block.PreviousGossipHash) {
+			return "fork", head
+		}
+		return "unknown parent", head
+	}
+	if block.Iteration != head.ProposedBlock.Iteration+1 {
+		return "iteration", head
+	}
+	return "", head
+}
+
+// returns false and reports to the coordinator if the block does not extend the head
+func verifyLinkage(nodeCtx *NodeCtx, block *ProposedBlock, stage string) bool {
+	reason, head := checkLinkage(nodeCtx, block)
+	if reason == "" {
+		return true
+	}
+	consensusLog.warnf(nodeCtx, "[Linkage] %s: block %s iter %d does not extend head %s iter %d: %s", stage, bytes32ToString(block.GossipHash), block.Iteration, bytes32ToString(head.ProposedBlock.GossipHash), head.ProposedBlock.Iteration, reason)
+
+	report := ForkReport{
+		CommitteeID:  nodeCtx.committeeID(),
+		Pub:          nodeCtx.self.Priv.Pub.Bytes,
+		Stage:        stage,
+		Reason:       reason,
+		Iteration:    block.Iteration,
+		GossipHash:   block.GossipHash,
+		PreviousHash: block.PreviousGossipHash,
+		HeadHash:     head.ProposedBlock.GossipHash,
+		HeadIter:     head.ProposedBlock.Iteration,
+	}
+	spawnDialAndSendToCoordinator("fork", report)
+	return false
+}
+
+func forkReportString(r ForkReport) string {
+	return fmt.Sprintf("%s,%s,%s,%s,%d,%s,%s,%s,%d", bytes32ToString(r.CommitteeID), bytes32ToString(r.Pub), r.Stage, r.Reason, r.Iteration, bytes32ToString(r.GossipHash), bytes32ToString(r.PreviousHash), bytes32ToString(r.HeadHash), r.HeadIter)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// -chaos delays, reorders and drops the messages of a local run
+
+// the messages a dropped copy of only costs a retransmit or a view change, not an answer
+var chaosDroppable = map[string]bool{
+	"IDAGossipMsg":        true,
+	"consensus":           true,
+	"vrf_claim":           true,
+	"drg_commit":          true,
+	"drg_reveal":          true,
+	"reconfiguration_sig": true,
+}
+
+// seed of the chaos of all nodes of the process, set with -chaos
+var chaosSeed int64
+
+func initChaos(flagArgs *FlagArgs) {
+	chaosSeed = flagArgs.runSeed
+	if chaosSeed == 0 {
+		chaosSeed = randomInt64(cryptoSource{})
+	}
+	nodeLog.warnf(nil, "[Chaos] delays, reorders and drops messages with seed %d, repeat with -runSeed %d", chaosSeed, chaosSeed)
+}
+
+// the chaos of a node, nil without -chaos
+type Chaos struct {
+	rand *seededSource
+}
+
+func newChaos(nodeCtx *NodeCtx) *Chaos {
+	if !nodeCtx.flagArgs.chaos {
+		return nil
+	}
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(chaosSeed))
+	h := hash(byteSliceAppend([]byte("chaos"), b, nodeCtx.self.Priv.Pub.Bytes[:]))
+	return &Chaos{rand: newSeededSource(int64(binary.LittleEndian.Uint64(h[:8])))}
+}
+
+// chance p in a million
+func (c *Chaos) _happens(p float64) bool {
+	return c.rand.Intn(1000000) < int(p*1000000)
+}
+
+// delays a received message, false if it is dropped
+func (c *Chaos) shake(nodeCtx *NodeCtx, msg *Msg) bool {
+	if c == nil {
+		return true
+	}
+	if _, ok := faultModules[msg.Typ]; !ok {
+		return true
+	}
+	if chaosDroppable[msg.Typ] && c._happens(default_chaosDrop) {
+		nodeLog.debugf(nodeCtx, "[Chaos] dropped %s", msg.Typ)
+		nodeCtx.metrics.add("rapidchain_chaos_total", "drop", 1)
+		return false
+	}
+	delay := time.Duration(c.rand.Intn(default_chaosDelay*1000)) * time.Microsecond
+	if c._happens(default_chaosReorder) {
+		delay *= default_chaosHold
+		nodeCtx.metrics.add("rapidchain_chaos_total", "reorder", 1)
+	}
+	clockSleep(delay)
+	return true
+}
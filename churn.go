@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// -churnRate kills nodes during the run and starts them again after -churnDowntime
+
+const churnFixed = "fixed"
+const churnUniform = "uniform"
+const churnPoisson = "poisson"
+
+func isChurnDist(s string) bool {
+	return s == churnFixed || s == churnUniform || s == churnPoisson
+}
+
+// time until the next kill with rate kills per minute
+func churnInterval(dist string, rate float64) time.Duration {
+	mean := float64(time.Minute) / rate
+	switch dist {
+	case churnUniform:
+		return time.Duration(rand.Float64() * 2 * mean)
+	case churnPoisson:
+		return time.Duration(rand.ExpFloat64() * mean)
+	default:
+		return time.Duration(mean)
+	}
+}
+
+// set when the node is killed, its goroutines check it before they send
+type Stopped struct {
+	stopped bool
+	mux     sync.Mutex
+}
+
+func (s *Stopped) set() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.stopped = true
+}
+
+func (s *Stopped) get() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.stopped
+}
+
+// an instance of the node process, the node that currently runs in it
+type Instance struct {
+	count    uint
+	flagArgs *FlagArgs
+	privKey  *PrivKey
+	listener net.Listener
+	nodeCtx  *NodeCtx // nil until the node started its first iteration
+	down     bool
+	killed   time.Time
+	restarts uint // after a panic, see instance-supervisor.go
+	mux      sync.Mutex
+}
+
+func (in *Instance) started(flagArgs *FlagArgs, privKey *PrivKey, listener net.Listener, nodeCtx *NodeCtx) {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+	in.flagArgs, in.privKey, in.listener, in.nodeCtx = flagArgs, privKey, listener, nodeCtx
+}
+
+func (in *Instance) isRunning() bool {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+	return in.nodeCtx != nil && !in.down
+}
+
+// stops the node and starts it again after downtime, it stays down if downtime is 0
+func (in *Instance) kill(downtime time.Duration) {
+	nodeCtx := in.stop("kill", downtime)
+	if nodeCtx == nil {
+		return
+	}
+	nodeLog.infof(nodeCtx, "[Churn] killed %s in committee %s at iteration %d", nodeCtx.self.IP, bytes32ToString(nodeCtx.committeeID()), nodeCtx.i.getI())
+	if downtime == 0 {
+		return
+	}
+	clockSleep(downtime)
+	in.respawn()
+}
+
+// stops the node of the instance and reports it as a kind row, nil if it is not running
+func (in *Instance) stop(kind string, downtime time.Duration) *NodeCtx {
+	in.mux.Lock()
+	nodeCtx := in.nodeCtx
+	if nodeCtx == nil || in.down {
+		in.mux.Unlock()
+		return nil
+	}
+	in.down = true
+	in.killed = clockNow()
+	in.mux.Unlock()
+
+	nodeCtx.stopped.set()
+	ifErr(in.listener.Close(), "closing listener of stopped node")
+	// kind,committee,pub,iteration,downtime ms
+	s := fmt.Sprintf("%s,%s,%s,%d,%d", kind, bytes32ToString(nodeCtx.committeeID()), bytes32ToString(nodeCtx.self.Priv.Pub.Bytes), nodeCtx.i.getI(), downtime.Milliseconds())
+	spawnDialAndSendToCoordinator("churn", s)
+	return nodeCtx
+}
+
+// starts the node again with its key and port. The committees may have changed with an epoch
+// while it was down, so they come from the coordinator like for a joining node
+func (in *Instance) respawn() {
+	listener, err := netListen(in.listener.Addr().String())
+	ifErrFatal(err, "listener respawned node")
+	in.rejoin(listener, false)
+}
+
+// the node of the instance rejoins the run on listener, recovered in a new process by the
+// supervisor (recovery.go) or after a kill
+func (in *Instance) rejoin(listener net.Listener, recovered bool) {
+	nodeCtx := new(NodeCtx)
+	nodeCtx.flagArgs = *in.flagArgs
+	nodeCtx.instance = in
+	nodeCtx.join = &JoinReport{start: in.killed, respawn: true, recovered: recovered}
+
+	response := new(ResponseToNodes)
+	conn := dial(coordinatorAddr())
+	sendMsg(conn, Msg{"rejoin", "", in.privKey.Pub})
+	reciveMsg(conn, response)
+	conn.Close()
+	if len(response.Nodes) == 0 {
+		nodeLog.warnf(nil, "[Churn] %s is not a node of the run anymore", listener.Addr())
+		ifErr(listener.Close(), "closing listener")
+		return
+	}
+	nodeCtx.fastSync = true
+	setupFromResponse(nodeCtx, in.privKey, response)
+	nodeCtx.join.assigned = clockSince(in.killed)
+	blocks := requestReconfigurationBlocks(nodeCtx, response.ReconfigurationBlock.Hash)
+	nodeCtx.blockchain.setReconfigurationBlocks(blocks)
+	nodeCtx.drg.init(nodeCtx.blockchain.epoch() + 1)
+
+	in.mux.Lock()
+	in.listener, in.nodeCtx, in.down = listener, nodeCtx, false
+	in.mux.Unlock()
+	spawn(func() { listen(listener, nodeCtx) })
+	fastSync(nodeCtx)
+	nodeCtx.join.mux.Lock()
+	nodeCtx.join.synced = clockSince(in.killed)
+	nodeCtx.join.mux.Unlock()
+	rejoinFromWAL(nodeCtx)
+	if nodeCtx.flagArgs.mac {
+		nodeCtx.macKeys.rotate(nodeCtx, nodeCtx.blockchain.getLastReconfigurationBlock(), nodeCtx.blockchain.epoch())
+	}
+	nodeLog.infof(nodeCtx, "[Churn] %s started again in committee %s at iteration %d after %s", nodeCtx.self.IP, bytes32ToString(nodeCtx.committeeID()), nodeCtx.i.getI(), clockSince(in.killed))
+	startNewIteration(nodeCtx)
+}
+
+// kills random running instances of this process
+func churnLocal(flagArgs *FlagArgs, instances []*Instance) {
+	downtime := time.Duration(flagArgs.churnDowntime) * time.Second
+	for {
+		clockSleep(churnInterval(flagArgs.churnDist, flagArgs.churnRate))
+		running := []*Instance{}
+		for _, in := range instances {
+			if in.isRunning() {
+				running = append(running, in)
+			}
+		}
+		if len(running) == 0 {
+			continue
+		}
+		in := running[rand.Intn(len(running))]
+		spawn(func() { in.kill(downtime) })
+	}
+}
+
+// kills random nodes of the run with a churn_kill message. A node that is already down ignores it
+func churnByCoordinator(flagArgs *FlagArgs, ms *Membership) {
+	for {
+		clockSleep(churnInterval(flagArgs.churnDist, flagArgs.churnRate))
+		nodes := ms.list()
+		if len(nodes) == 0 {
+			continue
+		}
+		n := nodes[rand.Intn(len(nodes))]
+		coordinatorLog.infof(nil, "[Churn] killing %s", n.IP)
+		spawnDialAndSend(n.IP, withControlToken(n.Pub, Msg{"churn_kill", flagArgs.churnDowntime, nil}))
+	}
+}
+
+func (ms *Membership) list() []NodeAllInfo {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	return ms._list()
+}
+
+func (ms *Membership) _list() []NodeAllInfo {
+	nodes := make([]NodeAllInfo, 0, len(ms.nodes))
+	for _, n := range ms.nodes {
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// the setup of a node that starts again after a kill, without nodes if it is not known
+func (ms *Membership) rejoin(pub *PubKey) ResponseToNodes {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	if pub == nil || ms.rBlock == nil {
+		return ResponseToNodes{}
+	}
+	if _, ok := ms.nodes[pub.Bytes]; !ok {
+		return ResponseToNodes{}
+	}
+	return ResponseToNodes{Nodes: ms._list(), GenesisHashes: ms.genesisHashes, ReconfigurationBlock: ms.rBlock}
+}
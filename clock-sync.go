@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/gob"
+	"net"
+	"sync"
+	"time"
+)
+
+// -clockSync estimates the offset of the clock of a node to the clock of the coordinator
+
+type ClockAnswer struct {
+	Received time.Time
+	Sent     time.Time
+}
+
+// the offset of the clock of this process to the clock of the coordinator
+var clockOffset struct {
+	offset    time.Duration
+	roundTrip time.Duration
+	mux       sync.Mutex
+}
+
+var clockMeasured, clockRefreshed sync.Once
+
+// one exchange with the coordinator, after setup it is a msg like the stats. A coordinator that is
+// gone is an error, a standby may take over (standby.go)
+func exchangeClock(afterSetup bool) (time.Duration, time.Duration, error) {
+	conn, err := dialWithRetry(coordinatorAddr())
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	// the connection is up, so the round trip is of the request and answer only
+	t0 := clockNow()
+	if afterSetup {
+		sendMsg(conn, Msg{"clock", "", nil})
+	} else {
+		sendMsg(conn, Node_InitialMessageToCoordinator{ClockRequest: true})
+	}
+	var answer ClockAnswer
+	if err := gob.NewDecoder(conn).Decode(&answer); err != nil {
+		return 0, 0, err
+	}
+	t3 := clockNow()
+	offset := (answer.Received.Sub(t0) + answer.Sent.Sub(t3)) / 2
+	roundTrip := t3.Sub(t0) - answer.Sent.Sub(answer.Received)
+	return offset, roundTrip, nil
+}
+
+// the offset of the exchange with the shortest round trip, and the round trip. The offset stays
+// when an exchange fails
+func measureClock(afterSetup bool) (time.Duration, time.Duration, error) {
+	var offset, roundTrip time.Duration
+	for i := 0; i < default_clockSamples; i++ {
+		o, rt, err := exchangeClock(afterSetup)
+		if err != nil {
+			return 0, 0, err
+		}
+		if i == 0 || rt < roundTrip {
+			offset, roundTrip = o, rt
+		}
+	}
+	clockOffset.mux.Lock()
+	clockOffset.offset, clockOffset.roundTrip = offset, roundTrip
+	clockOffset.mux.Unlock()
+	return offset, roundTrip, nil
+}
+
+// measures the offset before the first node of the process registers, the other nodes wait for it.
+// A node that joins the running experiment asks like after the setup. The nodes of a simulation
+// have the clock of the coordinator.
+func syncClock(flagArgs *FlagArgs, afterSetup bool) {
+	if !flagArgs.clockSync || simNet != nil {
+		return
+	}
+	clockMeasured.Do(func() {
+		offset, roundTrip, err := measureClock(afterSetup)
+		ifErrFatal(err, "clock of the coordinator")
+		nodeLog.infof(nil, "[Clock] offset to the coordinator %s, round trip %s", offset, roundTrip)
+	})
+}
+
+// measures the offset again every default_clockInterval seconds once the node is set up, before
+// that the coordinator takes every connection for a registration
+func refreshClock(flagArgs *FlagArgs) {
+	if !flagArgs.clockSync || simNet != nil {
+		return
+	}
+	clockRefreshed.Do(func() {
+		spawn(func() {
+			for {
+				clockSleep(default_clockInterval * time.Second)
+				offset, roundTrip, err := measureClock(true)
+				if ifErr(err, "clock of the coordinator") {
+					continue
+				}
+				nodeLog.debugf(nil, "[Clock] offset to the coordinator %s, round trip %s", offset, roundTrip)
+			}
+		})
+	})
+}
+
+// t of this process in the time of the coordinator
+func coordinatorTime(t time.Time) time.Time {
+	clockOffset.mux.Lock()
+	defer clockOffset.mux.Unlock()
+	return t.Add(clockOffset.offset)
+}
+
+// answers a clock request on conn the coordinator got at got
+func answerClock(conn net.Conn, got time.Time) {
+	sendMsg(conn, ClockAnswer{got, clockNow()})
+}
@@ -0,0 +1,64 @@
+package main
+
+import "time"
+
+// the clock and the goroutines of the protocol, virtual in simulate
+
+func clockNow() time.Time {
+	if simNet != nil {
+		return simNet.scheduler.clockNow()
+	}
+	return time.Now()
+}
+
+func clockSince(t time.Time) time.Duration {
+	return clockNow().Sub(t)
+}
+
+func clockUntil(t time.Time) time.Duration {
+	return t.Sub(clockNow())
+}
+
+func clockSleep(d time.Duration) {
+	if simNet != nil {
+		simNet.scheduler.sleep(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+// f runs as its own goroutine after d
+func clockAfterFunc(d time.Duration, f func()) {
+	if simNet != nil {
+		simNet.scheduler.afterFunc(d, f)
+		return
+	}
+	time.AfterFunc(d, f)
+}
+
+// runs f as a goroutine of the protocol
+func spawn(f func()) {
+	if simNet != nil {
+		simNet.scheduler.spawn(f)
+		return
+	}
+	goRecorded(f)
+}
+
+// runs f as a goroutine of the protocol and waits for it, for the goroutines that are not ones, like
+// the http handlers that ask the nodes
+func spawnAndWait(f func()) {
+	if simNet == nil {
+		f()
+		return
+	}
+	done := make(chan struct{})
+	simNet.scheduler.spawn(func() {
+		defer close(done)
+		f()
+	})
+	select {
+	case <-done:
+	case <-simNet.scheduler.halted:
+	}
+}
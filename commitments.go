@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/subtle"
+)
+
+// hash commitments of the commit-reveal of the drg
+
+type Commitment [32]byte
+
+type Opening struct {
+	Value []byte
+	Nonce [32]byte
+}
+
+func commitTo(domain string, value []byte, nonce [32]byte) Commitment {
+	return Commitment(hash(byteSliceAppend([]byte(domain), nonce[:], value)))
+}
+
+// commits to value with a random nonce, the opening has to be kept until it is revealed
+func commit(domain string, value []byte) (Commitment, Opening) {
+	var nonce [32]byte
+	nodeRand().Read(nonce[:])
+	return commitTo(domain, value, nonce), Opening{value, nonce}
+}
+
+func (c Commitment) opens(domain string, o Opening) bool {
+	d := commitTo(domain, o.Value, o.Nonce)
+	return subtle.ConstantTimeCompare(c[:], d[:]) == 1
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// -viewCheck compares the views of the members of a committee on who is in it
+
+type CommitteeView struct {
+	Pub         [32]byte
+	CommitteeID [32]byte
+	Iteration   uint
+	Epoch       uint
+	Hash        [32]byte
+	Members     int
+}
+
+func committeeViewHash(nodeCtx *NodeCtx) ([32]byte, int) {
+	pubs := [][32]byte{nodeCtx.self.Priv.Pub.Bytes}
+	for pub := range nodeCtx.committee.Members {
+		pubs = append(pubs, pub)
+	}
+	sort.Slice(pubs, func(i, j int) bool { return bytes.Compare(pubs[i][:], pubs[j][:]) < 0 })
+	all := nodeCtx.committee.ID[:]
+	for _, pub := range pubs {
+		all = byteSliceAppend(all, pub[:])
+	}
+	return hash(all), len(pubs)
+}
+
+func reportCommitteeView(nodeCtx *NodeCtx) {
+	k := nodeCtx.flagArgs.viewCheck
+	i := nodeCtx.i.getI()
+	if k == 0 || i%k != 0 {
+		return
+	}
+	h, members := committeeViewHash(nodeCtx)
+	v := CommitteeView{nodeCtx.self.Priv.Pub.Bytes, nodeCtx.committee.ID, i, nodeCtx.blockchain.epoch(), h, members}
+	spawnDialAndSendToCoordinator("committee_view", v)
+}
+
+type viewKey struct {
+	committee [32]byte
+	iteration uint
+}
+
+// the reports of every committee and iteration that are not checked yet
+type ViewChecks struct {
+	views map[viewKey][]CommitteeView
+	mux   sync.Mutex
+}
+
+func (vc *ViewChecks) init() {
+	vc.mux.Lock()
+	defer vc.mux.Unlock()
+	vc.views = make(map[viewKey][]CommitteeView)
+}
+
+// adds v and checks its committee and iteration after default_viewCheckWait
+func (vc *ViewChecks) add(v CommitteeView, f *os.File) {
+	vc.mux.Lock()
+	defer vc.mux.Unlock()
+	key := viewKey{v.CommitteeID, v.Iteration}
+	if _, ok := vc.views[key]; !ok {
+		clockAfterFunc(default_viewCheckWait*time.Second, func() { vc.check(key, f) })
+	}
+	vc.views[key] = append(vc.views[key], v)
+}
+
+func (vc *ViewChecks) check(key viewKey, f *os.File) {
+	vc.mux.Lock()
+	views := vc.views[key]
+	delete(vc.views, key)
+	vc.mux.Unlock()
+
+	counts := make(map[[32]byte]int)
+	for _, v := range views {
+		counts[v.Hash]++
+	}
+	var majority CommitteeView
+	for _, v := range views {
+		c, best := counts[v.Hash], counts[majority.Hash]
+		if c > best || (c == best && bytes.Compare(v.Hash[:], majority.Hash[:]) < 0) {
+			majority = v
+		}
+	}
+	for _, v := range views {
+		if v.Hash == majority.Hash {
+			continue
+		}
+		coordinatorLog.warnf(nil, "[View] %s disagrees about the members of committee %s in iteration %d: %d members, %d of %d nodes see %d", bytes32ToString(v.Pub), bytes32ToString(key.committee), key.iteration, v.Members, counts[majority.Hash], len(views), majority.Members)
+		writeStringToFile(fmt.Sprintf("%d,%d,%s,%s,%d,%d,%d,%d", key.iteration, v.Epoch, bytes32ToString(key.committee), bytes32ToString(v.Pub), v.Members, majority.Members, counts[majority.Hash], len(views)), f)
+	}
+}
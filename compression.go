@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstd compression of block bodies in the store and in ida gossip
+
+const (
+	codecRaw  byte = 0
+	codecZstd byte = 1
+)
+
+// EncodeAll and DecodeAll are safe for concurrent use
+var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+func zstdCompress(data []byte) []byte {
+	return zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/2))
+}
+
+func zstdDecompress(data []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(data, nil)
+}
+
+// prefixes data with the codec, compressed if compress is set
+func encodeBody(data []byte, compress bool) []byte {
+	if !compress {
+		return byteSliceAppend([]byte{codecRaw}, data)
+	}
+	return byteSliceAppend([]byte{codecZstd}, zstdCompress(data))
+}
+
+func decodeBody(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty body")
+	}
+	switch data[0] {
+	case codecRaw:
+		return data[1:], nil
+	case codecZstd:
+		return zstdDecompress(data[1:])
+	default:
+		return nil, fmt.Errorf("unknown codec %d", data[0])
+	}
+}
+
+// encodes a proposed block for ida gossip and reports the compression ratio
+func encodeBlockForGossip(nodeCtx *NodeCtx, block *ProposedBlock) []byte {
+	raw := block.encode()
+	body := encodeBody(raw, nodeCtx.flagArgs.compress)
+	reportCompression(nodeCtx, "ida", int64(len(raw)), int64(len(body)))
+	return body
+}
+
+func decodeGossipedBlock(data []byte) (*ProposedBlock, error) {
+	raw, err := decodeBody(data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeProposedBlock(raw)
+}
+
+// kind is ida (per gossiped block) or store (total since the store was opened)
+func reportCompression(nodeCtx *NodeCtx, kind string, raw, compressed int64) {
+	if raw == 0 {
+		return
+	}
+	s := fmt.Sprintf("%s,%s,%s,%d,%d,%.3f", bytes32ToString(nodeCtx.committeeID()), bytes32ToString(nodeCtx.self.Priv.Pub.Bytes), kind, raw, compressed, float64(raw)/float64(compressed))
+	spawnDialAndSendToCoordinator("compression", s)
+}
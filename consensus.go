@@ -2,9 +2,6 @@ package main
 
 import (
 	"encoding/binary"
-	"fmt"
-	"log"
-	"time"
 
 	"github.com/jinzhu/copier"
 )
@@ -19,6 +16,16 @@ func handleConsensus(
 	cMsg.Tag = _cMsg.Tag
 	cMsg.Pub = _cMsg.Pub
 	cMsg.Sig = _cMsg.Sig
+	cMsg.Vrf = _cMsg.Vrf
+	cMsg.Mac = _cMsg.Mac
+	cMsg.MacEpoch = _cMsg.MacEpoch
+
+	if nodeCtx.flagArgs.mac && cMsg.Tag != "accept" {
+		if reason := nodeCtx.macKeys.verify(nodeCtx, cMsg); reason != "" {
+			errr(nil, cMsg.Tag+" from "+bytes32ToString(fromPub.Bytes)+": "+reason)
+			return
+		}
+	}
 
 	switch cMsg.Tag {
 	case "propose":
@@ -26,8 +33,23 @@ func handleConsensus(
 
 		// TODO check header actually comes from leader both by sig, and by election protocol
 		// double check
-		if cMsg.Pub.Bytes != fromPub.Bytes {
-			errFatal(nil, "LeaderID not the same as FromID")
+		if cMsg.Pub == nil || cMsg.Pub.Bytes != fromPub.Bytes {
+			consensusLog.warnf(nodeCtx, "dropped propose of %s, it is not its leader", bytes32ToString(fromPub.Bytes))
+			return
+		}
+
+		// only echo proposals that extend our head
+		block := nodeCtx.blockchain.getProposedBlock(cMsg.GossipHash)
+		if block == nil {
+			errr(nil, "propose without proposed block")
+			return
+		}
+		if nodeCtx.flagArgs.vrf && !verifyLeaderClaim(nodeCtx, cMsg, block) {
+			errr(nil, "propose from "+bytes32ToString(cMsg.Pub.Bytes)+" without a valid leader claim")
+			return
+		}
+		if !verifyLinkage(nodeCtx, block, "propose") {
+			return
 		}
 
 		// lock consensusMsg operations
@@ -37,6 +59,7 @@ func handleConsensus(
 		// TODO if blocks are reproposed then chagne this
 		if nodeCtx.consensusMsgs._exists(cMsg.GossipHash) {
 			// fmt.Println(cMsg)
+			nodeCtx.consensusMsgs.mux.Unlock()
 			errr(nil, "allready have msgs in this gossiphash")
 			return
 		}
@@ -45,18 +68,22 @@ func handleConsensus(
 
 		// unlock mutex
 		nodeCtx.consensusMsgs.mux.Unlock()
+		nodeCtx.events.publish(nodeCtx, VoteReceivedEvent{cMsg.Tag, cMsg.GossipHash})
+
+		// never echo two blocks in one iteration, also not across restarts
+		if !nodeCtx.wal.vote("echo", block.Iteration, cMsg.GossipHash) {
+			return
+		}
 
-		dur := time.Duration(nodeCtx.flagArgs.delta) * time.Millisecond
-		time.Sleep(dur)
+		dur := nodeCtx.delta()
+		clockSleep(dur)
 
 		// log.Println("sent echo")
 		newMsg := new(ConsensusMsg)
 		newMsg.GossipHash = cMsg.GossipHash
 		newMsg.Tag = "echo"
 		newMsg.Pub = nodeCtx.self.Priv.Pub
-		newMsg.sign(nodeCtx.self.Priv)
-		msg := Msg{"consensus", newMsg, nodeCtx.self.Priv.Pub}
-		sendMsgToCommitteeAndSelf(msg, nodeCtx)
+		sendConsensusMsg(newMsg, nodeCtx)
 
 	case "echo":
 		// add echo
@@ -69,11 +96,11 @@ func handleConsensus(
 		// TODO check that we recived propose from leader allready
 
 		// check that we have recived a propose from this gossiphash
-		dur := time.Duration(nodeCtx.flagArgs.delta) * time.Millisecond
+		dur := nodeCtx.delta()
 		if !nodeCtx.consensusMsgs.exists(cMsg.GossipHash) {
 			timeout := 0
 			for {
-				time.Sleep(dur)
+				clockSleep(dur)
 				if nodeCtx.consensusMsgs.exists(cMsg.GossipHash) {
 					break
 				}
@@ -89,29 +116,23 @@ func handleConsensus(
 		// log.Println("Echo recived from ", fromPub.string())
 		nodeCtx.consensusMsgs.add(cMsg.GossipHash, cMsg.Pub.Bytes, cMsg)
 
-		_msg := Msg{"consensus", "echo", nodeCtx.self.Priv.Pub}
-		go dialAndSend(coord+":8080", _msg)
+		nodeCtx.events.publish(nodeCtx, VoteReceivedEvent{cMsg.Tag, cMsg.GossipHash})
 	case "pending":
 		// don't accept this iteration
 
 		// TODO check validity of header
 
 		// TODO check that it is different from other recivied valid headers
-		errFatal(nil, "this shouldnt be reached")
-		// set header of fromid to this pending, so accept round can check
-		nodeCtx.consensusMsgs.add(cMsg.GossipHash, cMsg.Pub.Bytes, cMsg)
-
-		_msg := Msg{"consensus", "pending", nodeCtx.self.Priv.Pub}
-		go dialAndSend(coord+":8080", _msg)
-		// terminate without accepting
+		// no node sends pending
+		consensusLog.warnf(nodeCtx, "dropped pending of %s", bytes32ToString(fromPub.Bytes))
 		return
 	case "accept":
-		dur := time.Duration(nodeCtx.flagArgs.delta) * time.Millisecond
+		dur := nodeCtx.delta()
 
 		if !nodeCtx.consensusMsgs.exists(cMsg.GossipHash) {
 			timeout := 0
 			for {
-				time.Sleep(dur)
+				clockSleep(dur)
 				if nodeCtx.consensusMsgs.exists(cMsg.GossipHash) {
 					break
 				}
@@ -126,15 +147,14 @@ func handleConsensus(
 
 		nodeCtx.consensusMsgs.add(cMsg.GossipHash, cMsg.Pub.Bytes, cMsg)
 
-		_msg := Msg{"consensus", "accept", nodeCtx.self.Priv.Pub}
-		go dialAndSend(coord+":8080", _msg)
+		nodeCtx.events.publish(nodeCtx, VoteReceivedEvent{cMsg.Tag, cMsg.GossipHash})
 
 		// now add final block if recived enough accepts
 
 		// log.Println("Success, recived accept from ", fromPub)
 
 	default:
-		errFatal(nil, "header tag not known")
+		consensusLog.warnf(nodeCtx, "dropped consensus msg of %s with unknown tag %q", bytes32ToString(fromPub.Bytes), cMsg.Tag)
 	}
 }
 
@@ -142,13 +162,16 @@ func handleConsensus(
 func handleConsensusEcho(
 	cMsg ConsensusMsg,
 	nodeCtx *NodeCtx, recursive uint) {
+	if recursive == 0 {
+		defer nodeCtx.instance.catchPanic()
+	}
 
-	requiredVotes := (len(nodeCtx.committee.Members) / int(nodeCtx.flagArgs.committeeF)) + 1
+	requiredVotes := consensusQuorum(nodeCtx)
 
 	if recursive > 0 {
-		time.Sleep(time.Duration(nodeCtx.flagArgs.delta) * time.Millisecond)
+		clockSleep(nodeCtx.delta())
 	} else {
-		time.Sleep(2 * time.Duration(nodeCtx.flagArgs.delta) * time.Millisecond)
+		clockSleep(2 * nodeCtx.delta())
 	}
 	// leader propose, echo gossip
 
@@ -156,7 +179,7 @@ func handleConsensusEcho(
 	// 	//  wait a few ms to be sure (computing)
 	// 	timeout := uint(0)
 	// 	for len(nodeCtx.channels.echoChan) < int(requiredVotes) {
-	// 		time.Sleep(10 * time.Millisecond)
+	// 		clockSleep(10 * time.Millisecond)
 	// 		timeout += 1
 	// 		if timeout >= nodeCtx.flagArgs.delta/100 {
 	// 			// requestAndAddMissingBlocks(nodeCtx)
@@ -169,24 +192,29 @@ func handleConsensusEcho(
 	// TODO handle pending
 
 	// check if we have enough required votes
-	totalVotes := nodeCtx.consensusMsgs.countValidVotes(cMsg.GossipHash)
+	totalVotes := nodeCtx.consensusMsgs.countValidVotes(cMsg.GossipHash, nodeCtx.blockchain.getLastReconfigurationBlock().Weights)
 
 	// TODO change to flagArgs
 	if totalVotes >= requiredVotes {
 		// enough votes, send accept
+		iteration := nodeCtx.i.getI()
+		if block := nodeCtx.blockchain.getProposedBlock(cMsg.GossipHash); block != nil {
+			iteration = block.Iteration
+		}
+		if !nodeCtx.wal.vote("accept", iteration, cMsg.GossipHash) {
+			return
+		}
 		newMsg := new(ConsensusMsg)
 		newMsg.GossipHash = cMsg.GossipHash
 		newMsg.Tag = "accept"
 		newMsg.Pub = nodeCtx.self.Priv.Pub
-		newMsg.sign(nodeCtx.self.Priv)
-		msg := Msg{"consensus", newMsg, nodeCtx.self.Priv.Pub}
-		sendMsgToCommitteeAndSelf(msg, nodeCtx)
+		sendConsensusMsg(newMsg, nodeCtx)
 
 	} else {
 		// not enough votes, terminate
 		// TODO add coordinator feedback here
 
-		log.Println("Not enough votes ", totalVotes)
+		consensusLog.warnf(nodeCtx, "Not enough votes %v", totalVotes)
 
 		recursive++
 		if recursive >= 2 {
@@ -202,32 +230,51 @@ func handleConsensusAccept(
 	cMsg ConsensusMsg,
 	nodeCtx *NodeCtx,
 	recursive int64) {
+	if recursive == 0 {
+		defer nodeCtx.instance.catchPanic()
+	}
 
-	requiredVotes := (len(nodeCtx.committee.Members) / int(nodeCtx.flagArgs.committeeF)) + 1
+	requiredVotes := consensusQuorum(nodeCtx)
 
 	if recursive > 0 {
-		time.Sleep(time.Duration(nodeCtx.flagArgs.delta) * time.Millisecond)
+		clockSleep(nodeCtx.delta())
 	} else {
 		// leader propose, echo gossip, accept gossip
-		time.Sleep(3 * time.Duration(nodeCtx.flagArgs.delta) * time.Millisecond)
+		clockSleep(3 * nodeCtx.delta())
 	}
 
 	// check if we have enough required votes
-	totalVotes := nodeCtx.consensusMsgs.countValidAccepts(cMsg.GossipHash)
+	totalVotes := nodeCtx.consensusMsgs.countValidAccepts(cMsg.GossipHash, nodeCtx.flagArgs.vCPUs, nodeCtx.blockchain.getLastReconfigurationBlock().Weights)
 
 	//log.Println("handleConsensusAccept", totalVotes, requiredVotes)
 	// TODO change to flagArgs
 	if totalVotes >= requiredVotes {
 		// enough accepts
 		consensusMsgs := nodeCtx.consensusMsgs.pop(cMsg.GossipHash)
+		phases := nodeCtx.tracer.take(cMsg.GossipHash)
+		marks := nodeCtx.blockPhases.take(cMsg.GossipHash)
+		accepted := clockNow()
 
 		// get original block
 		block := nodeCtx.blockchain.popProposedBlock(cMsg.GossipHash)
+		nodeCtx.events.publish(nodeCtx, BlockAcceptedEvent{cMsg.GossipHash, block})
+
+		// the committee accepted the block, so it is added even if it does not extend our head,
+		// but the fork is reported
+		verifyLinkage(nodeCtx, block, "accept")
+		nodeCtx.wal.accept(block)
 
 		// create new final block
 		finalBlock := new(FinalBlock)
 		finalBlock.ProposedBlock = block
+		if nodeCtx.flagArgs.mac {
+			// proposes and echos only have a mac for us
+			consensusMsgs = signedConsensusMsgs(consensusMsgs)
+		}
 		finalBlock.Signatures = consensusMsgs
+		if nodeCtx.flagArgs.blsAggregate {
+			finalBlock.Certificate = aggregateCertificate(consensusMsgs)
+		}
 
 		// add to blockchain
 		nodeCtx.blockchain.add(finalBlock)
@@ -251,15 +298,16 @@ func handleConsensusAccept(
 					addProofOfConsensus(nodeCtx, newTx, finalBlock)
 
 					msg := Msg{"crosstransactionresponse", newTx, nodeCtx.self.Priv.Pub}
-					go routeTx(nodeCtx, msg, txFindClosestCommittee(nodeCtx, newTx.OrigTxHash))
+					closest := txFindClosestCommittee(nodeCtx, newTx.OrigTxHash)
+					spawn(func() { routeTx(nodeCtx, msg, closest) })
 
 				} else if what == "crosstx" {
 					msg := Msg{"crosstransaction", t, nodeCtx.self.Priv.Pub}
 					closest := txFindClosestCommittee(nodeCtx, t.Inputs[0].TxHash)
-					if closest == nodeCtx.self.CommitteeID {
+					if closest == nodeCtx.committeeID() {
 						errFatal(nil, "closest was own committe crosstx")
 					}
-					go routeTx(nodeCtx, msg, closest)
+					spawn(func() { routeTx(nodeCtx, msg, closest) })
 				}
 
 			}
@@ -267,16 +315,27 @@ func handleConsensusAccept(
 
 		// call coordinator and send transaction list, but only if you are leader
 		if nodeCtx.amILeader() {
-			fmt.Println("Final block: ", finalBlock.ProposedBlock)
-			fmt.Printf("\n\nsent final block to coordinator\n\n")
+			consensusLog.debugf(nodeCtx, "Final block: %v", finalBlock.ProposedBlock)
+			consensusLog.debugf(nodeCtx, "sent final block to coordinator")
 			msg := Msg{"finalblock", finalBlock, nodeCtx.self.Priv.Pub}
-			go dialAndSend(coord+":8080", msg)
+			spawnDialAndSend(coordinatorAddr(), msg)
+
+			// return signed receipts for the committed transactions to the client
+			if nodeCtx.flagArgs.receipts {
+				spawnDialAndSendToCoordinator("tx_receipts", *createReceipts(nodeCtx, finalBlock))
+			}
+			traceFinalBlock(nodeCtx, block, phases, accepted)
+			reportBlockPhases(nodeCtx, marks, block, accepted)
+		}
+
+		if nodeCtx.join != nil {
+			nodeCtx.join.useful(nodeCtx)
 		}
 
 		// increase iteration
 		nodeCtx.i.add()
 
-		log.Println("Accept sucess!")
+		consensusLog.infof(nodeCtx, "Accept sucess!")
 		// start new iteration
 		startNewIteration(nodeCtx)
 	} else {
@@ -292,11 +351,13 @@ func handleConsensusAccept(
 		rec := make([]byte, 8)
 		binary.LittleEndian.PutUint64(rec, uint64(recursive))
 
-		bat.B = byteSliceAppend(nodeCtx.self.CommitteeID[:], nodeCtx.self.Priv.Pub.Bytes[:], iter[:], totV[:], rec[:])
-		bat.T = time.Now() // dont need timestamp but why not
-		go dialAndSendToCoordinator("consensus_accept_fail", bat)
+		cID := nodeCtx.committeeID()
+		bat.B = byteSliceAppend(cID[:], nodeCtx.self.Priv.Pub.Bytes[:], iter[:], totV[:], rec[:])
+		bat.T = coordinatorTime(clockNow()) // dont need timestamp but why not
+		bat.Epoch = nodeCtx.blockchain.epoch()
+		spawnDialAndSendToCoordinator("consensus_accept_fail", bat)
 
-		log.Println("Not enough votes ", totalVotes)
+		consensusLog.warnf(nodeCtx, "Not enough votes %v", totalVotes)
 		recursive++
 		if recursive >= 2 {
 			requestAndAddMissingBlocks(nodeCtx)
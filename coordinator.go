@@ -4,13 +4,10 @@ import (
 	"encoding/binary"
 	"encoding/gob"
 	"fmt"
-	"log"
-	"math"
 	"math/rand"
 	"net"
 	"os"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 )
@@ -28,6 +25,40 @@ type committeeInfo struct {
 
 type consensusResult struct {
 	echos, pending, accepts int
+	mux                     sync.Mutex
+}
+
+func (c *consensusResult) add(tag string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	switch tag {
+	case "echo":
+		c.echos += 1
+	case "pending":
+		c.pending += 1
+	case "accept":
+		c.accepts += 1
+	}
+}
+
+// merkleroot -> number of nodes succesfully recreated it
+type idaSuccesses struct {
+	m   map[[32]byte]int
+	mux sync.Mutex
+}
+
+func (s *idaSuccesses) init() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.m = make(map[[32]byte]int)
+}
+
+// adds a node that recreated root, returns the nodes that did
+func (s *idaSuccesses) add(root [32]byte) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.m[root] += 1
+	return s.m[root]
 }
 
 // measure routing of tx
@@ -51,7 +82,7 @@ func (r *routetxresults) add(cId [32]byte, tim time.Time) bool {
 	r.mux.Lock()
 	defer r.mux.Unlock()
 	if r.committees == nil {
-		r.init()
+		r.committees = make(map[[32]byte]time.Time)
 	} else if _, ok := r.committees[cId]; ok {
 		return false
 	}
@@ -81,6 +112,33 @@ func (r *routetxresults) addEnd(tim time.Time) bool {
 	return false
 }
 
+// the row of results/routing*.csv: start,end and committee,time of every committee on the way
+func (r *routetxresults) row() string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	var s string
+	if r.start.IsZero() {
+		s += "0"
+	} else {
+		s += strconv.FormatInt(r.start.UnixNano(), 10)
+	}
+	s += ","
+	s += strconv.FormatInt(r.end.UnixNano(), 10)
+	for cID, tStamp := range r.committees {
+		s += ","
+		s += bytes32ToString(cID)
+		s += ","
+		s += strconv.FormatInt(tStamp.UnixNano(), 10)
+	}
+	return s
+}
+
+func (r *routetxresults) times() (time.Time, time.Time) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.start, r.end
+}
+
 type routetxmap struct {
 	m   map[[32]byte]*routetxresults
 	mux sync.Mutex
@@ -142,6 +200,30 @@ func (ida *IDAGossipResults) addReconstructed(tim time.Time) bool {
 	return false
 }
 
+func (ida *IDAGossipResults) setStart(tim time.Time) {
+	ida.mux.Lock()
+	defer ida.mux.Unlock()
+	ida.start = tim
+}
+
+func (ida *IDAGossipResults) startTime() time.Time {
+	ida.mux.Lock()
+	defer ida.mux.Unlock()
+	return ida.start
+}
+
+// the row of results/ida*.csv: start and the time of every reconstruction
+func (ida *IDAGossipResults) row() string {
+	ida.mux.Lock()
+	defer ida.mux.Unlock()
+	s := strconv.FormatInt(ida.start.UnixNano(), 10)
+	for _, tStamp := range ida.reconstructed {
+		s += ","
+		s += strconv.FormatInt(tStamp.UnixNano(), 10)
+	}
+	return s
+}
+
 func launchCoordinator(flagArgs *FlagArgs) {
 	/*
 		The coordinator should listen to incoming connections untill it has recived n different ids
@@ -154,73 +236,176 @@ func launchCoordinator(flagArgs *FlagArgs) {
 	*/
 
 	// To be used to send ID and IP from node connection to coordinator
-	chanToCoordinator := make(chan InitialMessageToCoordinator, flagArgs.n)
+	chanToCoordinator := newQueue(int(flagArgs.n))
+
+	if flagArgs.runSeed != 0 {
+		protocolRand = newSeededSource(flagArgs.runSeed)
+	} else {
+		flagArgs.runSeed = randomInt64(protocolRand)
+	}
+	coordinatorLog.infof(nil, "Run seed %d", flagArgs.runSeed)
+	// commit, flags and files of the run for the analysis of the results
+	manifest := new(Manifest)
+	manifest.init(flagArgs)
 
 	// To be used to send result back to node connection
-	chanToNodes := make([]chan ResponseToNodes, flagArgs.n)
+	chanToNodes := make([]*Queue, flagArgs.n)
 	for i := uint(0); i < flagArgs.n; i++ {
-		chanToNodes[i] = make(chan ResponseToNodes)
+		chanToNodes[i] = newQueue(1)
 	}
 
 	// waitgroup for all node connections to have recived an ID
-	var wg sync.WaitGroup
+	var wg WaitGroup
 	wg.Add(int(flagArgs.n))
 
 	// waitgroup for when coordinator is done and sent all data to connections
-	var wg_done sync.WaitGroup
+	var wg_done WaitGroup
 	wg_done.Add(int(flagArgs.n))
 
 	rand.Seed(1337)
 
-	finalBlockChan := make(chan FinalBlock, flagArgs.m*2)
+	finalBlockChan := newQueue(int(flagArgs.m * 2))
 
 	var err error
 
 	// result files
-	files := make([]*os.File, 6)
-	files[0], err = os.Create("results/tx" + time.Now().String() + ".csv")
+	files := make([]*os.File, 31)
+	files[0], err = os.Create(resultsName("tx") + ".csv")
 	ifErrFatal(err, "txresfile")
-	files[1], err = os.Create("results/pocverify" + time.Now().String() + ".csv")
+	files[1], err = os.Create(resultsName("pocverify") + ".csv")
 	ifErrFatal(err, "pocverifyfile")
-	files[2], err = os.Create("results/pocadd" + time.Now().String() + ".csv")
+	files[2], err = os.Create(resultsName("pocadd") + ".csv")
 	ifErrFatal(err, "pocaddfile")
-	files[3], err = os.Create("results/routing" + time.Now().String() + ".csv")
+	files[3], err = os.Create(resultsName("routing") + ".csv")
 	ifErrFatal(err, "routing")
-	files[4], err = os.Create("results/ida" + time.Now().String() + ".csv")
+	files[4], err = os.Create(resultsName("ida") + ".csv")
 	ifErrFatal(err, "ida")
-	files[5], err = os.Create("results/consensusacceptfail" + time.Now().String() + ".csv")
+	files[5], err = os.Create(resultsName("consensusacceptfail") + ".csv")
 	ifErrFatal(err, "consensusacceptfail")
+	files[6], err = os.Create(resultsName("ramp") + ".csv")
+	ifErrFatal(err, "ramp")
+	files[7], err = os.Create(resultsName("txclass") + ".csv")
+	ifErrFatal(err, "txclass")
+	files[8], err = os.Create(resultsName("orphan") + ".csv")
+	ifErrFatal(err, "orphan")
+	files[9], err = os.Create(resultsName("receipts") + ".csv")
+	ifErrFatal(err, "receipts")
+	files[10], err = os.Create(resultsName("admission") + ".csv")
+	ifErrFatal(err, "admission")
+	files[11], err = os.Create(resultsName("fork") + ".csv")
+	ifErrFatal(err, "fork")
+	files[12], err = os.Create(resultsName("bootstrap") + ".csv")
+	ifErrFatal(err, "bootstrap")
+	files[13], err = os.Create(resultsName("compression") + ".csv")
+	ifErrFatal(err, "compression")
+	files[14], err = os.Create(resultsName("blockcache") + ".csv")
+	ifErrFatal(err, "blockcache")
+	files[15], err = os.Create(resultsName("ledger") + ".csv")
+	ifErrFatal(err, "ledger")
+	files[16], err = os.Create(resultsName("drg") + ".csv")
+	ifErrFatal(err, "drg")
+	files[17], err = os.Create(resultsName("pow") + ".csv")
+	ifErrFatal(err, "pow")
+	files[18], err = os.Create(resultsName("sigcache") + ".csv")
+	ifErrFatal(err, "sigcache")
+	files[19], err = os.Create(resultsName("epoch") + ".csv")
+	ifErrFatal(err, "epoch")
+	files[20], err = os.Create(resultsName("join") + ".csv")
+	ifErrFatal(err, "join")
+	files[21], err = os.Create(resultsName("leave") + ".csv")
+	ifErrFatal(err, "leave")
+	files[22], err = os.Create(resultsName("switch") + ".csv")
+	ifErrFatal(err, "switch")
+	files[23], err = os.Create(resultsName("churn") + ".csv")
+	ifErrFatal(err, "churn")
+	files[24], err = os.Create(resultsName("adversary") + ".csv")
+	ifErrFatal(err, "adversary")
+	files[25], err = os.Create(resultsName("blacklist") + ".csv")
+	ifErrFatal(err, "blacklist")
+	files[26], err = os.Create(resultsName("election") + ".csv")
+	ifErrFatal(err, "election")
+	files[27], err = os.Create(resultsName("views") + ".csv")
+	ifErrFatal(err, "views")
+	files[28], err = os.Create(resultsName("latency") + ".csv")
+	ifErrFatal(err, "latency")
+	files[29], err = os.Create(resultsName("phases") + ".csv")
+	ifErrFatal(err, "phases")
+	files[30], err = os.Create(resultsName("tuning") + ".csv")
+	ifErrFatal(err, "tuning")
+	coordinatorTuning.init(flagArgs, files[30])
 	for _, f := range files {
 		defer f.Close()
 	}
 
-	go coordinator(chanToCoordinator, chanToNodes, &wg, flagArgs, finalBlockChan, files)
-
-	listener, err := net.Listen("tcp", ":8080")
-	ifErrFatal(err, "tcp listen on port 8080")
-	log.Println("coordinator prepare listen on port 8080")
-	var i uint = 0
-
-	// block main and listen to all incoming connections
-	for i < flagArgs.n {
-		log.Printf("coordinator listen on connection %v\n", i)
-		// accept new connection
-		conn, err := listener.Accept()
-		ifErrFatal(err, "tcp accept")
-		// spawn off goroutine to able to accept new connections
-		go coordinatorHandleConnection(conn, chanToCoordinator, chanToNodes[i], &wg, &wg_done)
-
-		// if flagArgs.n > 20 && i%(flagArgs.n/10) == 0 {
-		// 	fmt.Printf("#connections: %d\n", i)
-		// }
-		i += 1
+	// verifies tx receipts using only the committee membership
+	receiptVerifier := new(ReceiptVerifier)
+	receiptVerifier.init()
+
+	// genesis blocks for the dispersers with -genesisGossip
+	genesis := new(GenesisBlocks)
+	genesis.init()
+
+	// chain of every committee and the global ledger assembled from them, exported when the coordinator is stopped
+	chains := new(ChainExport)
+	chains.init()
+	ledger := new(GlobalLedger)
+	ledger.init()
+	// routing, ida and consensus stats summed per epoch
+	epochStats := new(EpochStats)
+	epochStats.init()
+	// bytes on the wire by committee and type with -wireStats
+	wire := new(WireResults)
+	wire.init()
+	// committee views of the nodes with -viewCheck
+	views := new(ViewChecks)
+	views.init()
+	// histograms of the nodes with -latencyStats histograms
+	latencies := new(LatencyResults)
+	latencies.init()
+	if flagArgs.latencyStats == latencyByHistograms {
+		spawn(func() { writeLatencies(latencies, files[28]) })
+	}
+	// a file per node with -resourceInterval
+	resources := new(ResourceFiles)
+	resources.init(resultsName("resources"))
+	// summaries of the run every -progress seconds
+	progress := new(Progress)
+	progress.init()
+
+	// puzzle of the bootstrap with -powDifficulty
+	powChallenge := PowChallenge{Difficulty: flagArgs.powDifficulty}
+	seed := make([]byte, 32)
+	protocolRand.Read(seed)
+	powChallenge.Seed = hash(seed)
+
+	// nodes and committees for nodes that join with -join
+	membership := new(Membership)
+	membership.init(powChallenge, flagArgs)
+	// the reason of the watchdog with -abortStuck or -abortSilent
+	aborted := make(chan string, 1)
+	// what the faults of -faults and the fault api hit and the reactions to them
+	faultCoverage = new(FaultCoverage)
+	faultCoverage.init()
+	// the per-event stats the coordinator drops with -statsKeep and -statsRate
+	startStatsLimit(flagArgs)
+	goRecorded(func() { exportOnExit(chains, ledger, epochStats, wire, manifest, membership, aborted) })
+	manifest.write(membership)
+	// prometheus metrics with -metricsPort
+	var metrics *Metrics
+	if flagArgs.metricsPort != 0 {
+		metrics = coordinatorMetrics(membership)
+		goRecorded(func() { serveMetrics(metrics, flagArgs.metricsPort, flagArgs.local) })
+	}
+	// pprof and profiles of nodes with -pprofPort
+	if flagArgs.pprofPort != 0 {
+		goRecorded(func() { servePprof(coordinatorPprofHandler(membership), flagArgs.pprofPort, flagArgs.local) })
+	}
+	if flagArgs.progress != 0 {
+		spawn(func() { logProgress(progress, membership, flagArgs.progress) })
 	}
 
-	wg_done.Wait()
-	log.Println("Coordination executed")
-
-	// merkleroot -> number of nodes succesfully recreated it
-	successfullGossips := make(map[[32]byte]int)
+	successfullGossips := new(idaSuccesses)
+	successfullGossips.init()
 
 	// committee -> iteration -> echo, pending, accept messages
 	consensusResults := new(consensusResult)
@@ -233,161 +418,141 @@ func launchCoordinator(flagArgs *FlagArgs) {
 	idaresults := new(IDAGossipResultsMap)
 	idaresults.m = make(map[[32]byte]*IDAGossipResults)
 
+	var listener net.Listener
+	if subcommandMode(flagArgs.function) == "standby" {
+		// the standby has no tx generator that takes the final blocks
+		go func() {
+			defer recordPanic()
+			for {
+				finalBlockChan.recv()
+			}
+		}()
+		listener = followCoordinator(membership, func(setup *StandbySetup) {
+			r := setup.Response
+			membership.follow(setup)
+			receiptVerifier.setCommittees(r.ReconfigurationBlock)
+			chains.addGenesis(r.GensisisBlocks)
+			ledger.addGenesis(r.GensisisBlocks)
+			if flagArgs.genesisGossip {
+				genesis.set(r.GensisisBlocks)
+			}
+		}, func(conn net.Conn, msg *Msg) {
+			coordinatorHandleStat(conn, msg, successfullGossips, consensusResults, finalBlockChan, files, routetxmap, idaresults, receiptVerifier, genesis, chains, ledger, membership, epochStats, views, latencies, resources, progress, wire, metrics)
+		})
+	} else {
+		startStandbyMirror(flagArgs)
+		spawn(func() {
+			coordinator(chanToCoordinator, chanToNodes, &wg, flagArgs, finalBlockChan, files, receiptVerifier, genesis, chains, ledger, membership, progress)
+		})
+
+		listener, err = netListen(":8080")
+		ifErrFatal(err, "tcp listen on port 8080")
+		integration.listening(chains, progress)
+		coordinatorLog.infof(nil, "coordinator prepare listen on port 8080")
+		var i uint = 0
+
+		// block main and listen to all incoming connections
+		for i < flagArgs.n {
+			coordinatorLog.infof(nil, "coordinator listen on connection %v", i)
+			// accept new connection
+			conn, err := listener.Accept()
+			ifErrFatal(err, "tcp accept")
+			rec_msg, ok := coordinatorAdmit(conn, powChallenge, flagArgs.runSeed, &membership.builds)
+			if !ok {
+				continue
+			}
+			// spawn off goroutine to able to accept new connections
+			toNode := chanToNodes[i]
+			spawn(func() { coordinatorHandleConnection(conn, rec_msg, chanToCoordinator, toNode, &wg, &wg_done) })
+
+			// if flagArgs.n > 20 && i%(flagArgs.n/10) == 0 {
+			// 	fmt.Printf("#connections: %d\n", i)
+			// }
+			i += 1
+		}
+
+		wg_done.Wait()
+	}
+	coordinatorLog.infof(nil, "Coordination executed")
+	manifest.write(membership)
+	if flagArgs.abortStuck != 0 || flagArgs.abortSilent != 0 {
+		spawn(func() { watchdog(progress, membership, flagArgs, aborted) })
+	}
+
 	// start listening for debug/stats
 	for {
 		// accept new connection
 		conn, err := listener.Accept()
 		ifErrFatal(err, "tcp accept")
 		// spawn off goroutine to able to accept new connections
-		go coordinatorDebugStatsHandleConnection(conn, &successfullGossips, consensusResults, finalBlockChan, files, routetxmap, idaresults)
+		spawn(func() {
+			coordinatorDebugStatsHandleConnection(conn, successfullGossips, consensusResults, finalBlockChan, files, routetxmap, idaresults, receiptVerifier, genesis, chains, ledger, membership, epochStats, views, latencies, resources, progress, wire, metrics)
+		})
 	}
 }
 
 func coordinatorHandleConnection(conn net.Conn,
-	chanToCoordinator chan<- InitialMessageToCoordinator,
-	chanFromCoordinator <-chan ResponseToNodes,
-	wg, wg_done *sync.WaitGroup) {
-
-	dec := gob.NewDecoder(conn)
-	rec_msg := new(Node_InitialMessageToCoordinator)
-	err := dec.Decode(rec_msg)
-	ifErrFatal(err, "decoding")
+	rec_msg *Node_InitialMessageToCoordinator,
+	chanToCoordinator *Queue,
+	chanFromCoordinator *Queue,
+	wg, wg_done *WaitGroup) {
 
-	// get the remote address of the client
-	clientAddr := conn.RemoteAddr().String()
-	// remove port number and add rec_msg.Port instead
-	//fmt.Println("1: ", clientAddr)
-	clientAddr = fmt.Sprintf("%s:%d", clientAddr[:strings.IndexByte(clientAddr, ':')], rec_msg.Port)
-	fmt.Println("client address: ", clientAddr)
+	// get the remote address of the client with rec_msg.Port instead of its port
+	clientAddr := nodeAddr(conn, rec_msg.Host, rec_msg.Port)
+	coordinatorLog.debugf(nil, "client address: %s", clientAddr)
 
-	chanToCoordinator <- InitialMessageToCoordinator{rec_msg.Pub, clientAddr} // send msg to node
+	chanToCoordinator.send(InitialMessageToCoordinator{rec_msg.Pub, clientAddr}) // send msg to node
 
 	// signalize to waitgroup that this connection has recived an ID
 	wg.Done()
 
-	fmt.Println("waiting for returnMessage")
-	returnMessage := <-chanFromCoordinator //receivce msg from node
+	coordinatorLog.debugf(nil, "waiting for returnMessage")
+	returnMessage := chanFromCoordinator.recv().(ResponseToNodes) //receivce msg from node
 	enc := gob.NewEncoder(conn)
-	err = enc.Encode(returnMessage)
+	err := enc.Encode(returnMessage)
 	ifErrFatal(err, "encoding")
 	wg_done.Done()
-	fmt.Println("received for returnMessage")
+	coordinatorLog.debugf(nil, "received for returnMessage")
 }
 
 func coordinator(
-	chanToCoordinator chan InitialMessageToCoordinator,
-	chanToNodes []chan ResponseToNodes,
-	wg *sync.WaitGroup,
+	chanToCoordinator *Queue,
+	chanToNodes []*Queue,
+	wg *WaitGroup,
 	flagArgs *FlagArgs,
-	finalBlockChan chan FinalBlock,
-	files []*os.File) {
+	finalBlockChan *Queue,
+	files []*os.File,
+	receiptVerifier *ReceiptVerifier,
+	genesis *GenesisBlocks,
+	chains *ChainExport,
+	ledger *GlobalLedger,
+	membership *Membership,
+	progress *Progress) {
 
 	// wait untill all node connections have pushed an ID/IP to chan
+	start := clockNow()
 	wg.Wait()
-	log.Println("all nodes have pushed an ID/IP to chan ")
-	close(chanToCoordinator)
+	coordinatorLog.infof(nil, "all nodes have pushed an ID/IP to chan")
 
 	// create array of structs that has all info about a node and assign it id/ip
 	nodeInfos := make([]NodeAllInfo, flagArgs.n)
-	i := 0
-	for elem := range chanToCoordinator {
+	for i := range nodeInfos {
+		elem := chanToCoordinator.recv().(InitialMessageToCoordinator)
 		nodeInfos[i].Pub = elem.pub
 		nodeInfos[i].IP = elem.ip
-		i += 1
 	}
 
-	// shuffle the list
-	rand.Seed(time.Now().UnixNano())
-	rand.Shuffle(len(nodeInfos), func(i, j int) { nodeInfos[i], nodeInfos[j] = nodeInfos[j], nodeInfos[i] })
-
-	// Create committees with id
-	committees := make([][32]byte, flagArgs.m)
-	for i := uint(0); i < flagArgs.m; i++ {
-		committees[i] = hash(getBytes(rand.Intn(maxId)))
+	// assign the committees, or let the identities elect them
+	var committeeInfos []committeeInfo
+	var election *Election
+	if flagArgs.bootstrap == bootstrapByElection {
+		var row string
+		committeeInfos, election, row = electionBootstrap(flagArgs, nodeInfos, electionSeed(membership.challenge, flagArgs.runSeed), clockSince(start))
+		writeStringToFile(row, files[26])
+	} else {
+		committeeInfos = assignCommittees(flagArgs, nodeInfos)
 	}
 
-	fmt.Println("Committees: ", committees)
-
-	// divide idIdPairs into equal m chunks and assign them to the committees
-	npm := int(flagArgs.n / flagArgs.m)
-	rest := int(flagArgs.n % flagArgs.m)
-	c := 0
-	for i := int(0); i < int(flagArgs.n); i++ {
-		t_npm := npm
-		if i != 0 && i%npm == 0 {
-			if i != int(flagArgs.n)-rest { // if there is a rest, it will be put into the last committee
-				c++
-			} else {
-				t_npm += rest
-			}
-		}
-		nodeInfos[i].CommitteeID = committees[c]
-
-		// Every other committee should have 1/2 -1 adversaries and the other have 1/6 -1
-		// This way we achive 1/3 total resiliency
-		// first committee aka ref c should have 1/2 -1 f
-		var c_div int
-		if c%2 == 0 {
-			c_div = 3
-		} else {
-			c_div = 1
-		}
-		// TODO: this is not variable with committeeF
-
-		// amount of adversaries in this committee
-		f := (t_npm / (6 / c_div))
-		// if the above division created exactly 50% adversaries then we subtract one
-		if t_npm%(6/c_div) == 0 {
-			f--
-		}
-		if t_npm == 0 || i%t_npm < f {
-			nodeInfos[i].IsHonest = false
-		} else {
-			nodeInfos[i].IsHonest = true
-		}
-	}
-
-	// double check amount of nodes in each committee and their adversaries
-	lastCommittee := committees[0]
-	committeeInfos := make([]committeeInfo, flagArgs.m)
-	iCommittee := 0
-	for i := int(0); i < int(flagArgs.n); i++ {
-		if nodeInfos[i].CommitteeID != lastCommittee {
-			lastCommittee = nodeInfos[i].CommitteeID
-			iCommittee += 1
-		}
-		committeeInfos[iCommittee].id = nodeInfos[i].CommitteeID
-		committeeInfos[iCommittee].npm += 1
-		if !nodeInfos[i].IsHonest {
-			committeeInfos[iCommittee].f += 1
-		}
-	}
-
-	fmt.Println("Committee info: ", committeeInfos)
-	for _, ci := range committeeInfos {
-		fmt.Println("Committee ", bytes32ToString(ci.id), ci.npm, ci.f)
-	}
-
-	// check that invariants are held
-	checkTotalF := 0
-	for i := 0; i < len(committeeInfos); i++ {
-		if committeeInfos[i].npm != uint(npm) && committeeInfos[i].npm != uint(npm+rest) {
-			log.Fatal("Number of nodes in committee not right", npm, npm+rest, committeeInfos[i].npm)
-		}
-
-		if committeeInfos[i].f >= int(math.Ceil(float64(committeeInfos[i].npm)/float64(flagArgs.committeeF))) {
-			log.Fatal("Comitte %d has too many adversaries %d", committeeInfos[i].id, committeeInfos[i].f)
-		}
-
-		checkTotalF += committeeInfos[i].f
-	}
-
-	if flagArgs.n/flagArgs.m != 1 && int(flagArgs.n)/checkTotalF < 1/int(flagArgs.totalF) {
-		log.Fatal("There was too many adversaries in total %d", checkTotalF)
-	}
-
-	fmt.Println("Total adversary percentage: ", float64(checkTotalF)/float64(flagArgs.n))
-
 	// gen set of idenetites
 	users := genUsers(flagArgs)
 	genesisBlocks := genGenesisBlock(flagArgs, committeeInfos, users)
@@ -408,23 +573,77 @@ func coordinator(
 		}
 		rBlock.Committees[newCom.ID] = newCom
 	}
-	// create initial randomness
-	rnd := make([]byte, 32)
-	rand.Read(rnd)
-	rBlock.Randomness = hash(rnd)
+	// create initial randomness, with -drg it is generated by the reference committee
+	if !flagArgs.drg {
+		rnd := make([]byte, 32)
+		protocolRand.Read(rnd)
+		rBlock.Randomness = hash(rnd)
+		if election != nil {
+			rBlock.Randomness = election.randomness
+		}
+	}
+	rBlock.Weights = drawWeights(nodeInfos, flagArgs.weights, flagArgs.maxWeight, protocolRand)
 	rBlock.setHash()
+	receiptVerifier.setCommittees(rBlock)
+	chains.addGenesis(genesisBlocks)
+	ledger.addGenesis(genesisBlocks)
 
-	msg := ResponseToNodes{nodeInfos, genesisBlocks, nodeInfos[0].Pub.Bytes, rBlock}
+	msg := ResponseToNodes{nodeInfos, genesisBlocks, genesisHashes(genesisBlocks), nodeInfos[0].Pub.Bytes, rBlock, [32]byte{}, ""}
+	if election != nil {
+		msg.ElectionSeed = electionSeed(membership.challenge, flagArgs.runSeed)
+	}
+	membership.set(nodeInfos, rBlock, msg.GenesisHashes)
+	standbyMirror.setup(flagArgs, membership.challenge, msg)
+	if flagArgs.genesisGossip {
+		// only send the hashes, the bodies are dispersed by the committees
+		genesis.set(genesisBlocks)
+		msg.GensisisBlocks = nil
+	}
 
 	for _, c := range chanToNodes {
-		c <- msg
+		c.send(msg)
+	}
+	// locally the node processes kill their own instances
+	if flagArgs.churnRate > 0 && !flagArgs.local {
+		spawn(func() { churnByCoordinator(flagArgs, membership) })
 	}
 
-	txGenerator(flagArgs, nodeInfos, users, genesisBlocks, finalBlockChan, files)
+	txGenerator(flagArgs, nodeInfos, users, genesisBlocks, finalBlockChan, files, progress)
 }
 
+// shuffles nodeInfos into m committees of equal size, with a fixed number of adversaries in every
+// committee that keeps it under the committeeF bound, see assignment.go
+func assignCommittees(flagArgs *FlagArgs, nodeInfos []NodeAllInfo) []committeeInfo {
+	source := protocolRand
+	if flagArgs.stableAssignment {
+		// same keys give the same committees, independent of the order the nodes connected in
+		source = newSeededSource(stableAssignmentSeed(nodeInfos))
+	} else {
+		// with -runSeed the same keys give the same committees
+		sortNodeInfos(nodeInfos)
+	}
+	committeeInfos := committeeAssignment(flagArgs, nodeInfos, source)
+
+	coordinatorLog.debugf(nil, "Committee info: %v", committeeInfos)
+	checkTotalF := 0
+	for _, ci := range committeeInfos {
+		coordinatorLog.infof(nil, "Committee %s %d %d", bytes32ToString(ci.id), ci.npm, ci.f)
+		checkTotalF += ci.f
+	}
+
+	// check that invariants are held
+	for _, v := range assignmentViolations(flagArgs, committeeInfos) {
+		coordinatorLog.fatalf(nil, "Committee assignment: %s", v)
+	}
+
+	coordinatorLog.infof(nil, "Total adversary percentage: %v", float64(checkTotalF)/float64(flagArgs.n))
+
+	return committeeInfos
+}
+
+// prefixes a row with the time in unix nanoseconds, like the timestamps in the rows
 func prepareResultString(s string) string {
-	tmp := strconv.FormatInt(time.Now().Unix(), 10)
+	tmp := strconv.FormatInt(clockNow().UnixNano(), 10)
 	tmp += ","
 	tmp += s
 	tmp += "\n"
@@ -448,31 +667,105 @@ func writeStringToFile(s string, f *os.File) {
 }
 
 func coordinatorDebugStatsHandleConnection(conn net.Conn,
-	successfullGossips *map[[32]byte]int,
+	successfullGossips *idaSuccesses,
 	consensusResults *consensusResult,
-	finalBlockChan chan FinalBlock,
+	finalBlockChan *Queue,
 	files []*os.File,
 	rMap *routetxmap,
-	idaresults *IDAGossipResultsMap) {
+	idaresults *IDAGossipResultsMap,
+	receiptVerifier *ReceiptVerifier,
+	genesis *GenesisBlocks,
+	chains *ChainExport,
+	ledger *GlobalLedger,
+	membership *Membership,
+	epochStats *EpochStats,
+	views *ViewChecks,
+	latencies *LatencyResults,
+	resources *ResourceFiles,
+	progress *Progress,
+	wire *WireResults,
+	metrics *Metrics) {
+	if !statsAuth.admit(conn) {
+		conn.Close()
+		return
+	}
 	msg := new(Msg)
-	reciveMsg(conn, msg)
+	counted := &countingConn{Conn: conn}
+	if !reciveMsgFromPeer(counted, msg) {
+		return
+	}
+	metrics.add("rapidchain_received_bytes_total", msg.Typ, float64(counted.n))
+	if reason := checkInbound(coordinatorInbound, msg); reason != "" {
+		coordinatorLog.warnf(nil, "[Inbound] dropped %s from %s: %s", msg.Typ, conn.RemoteAddr(), reason)
+		return
+	}
+	// the stats of -statsBatch, every one is handled like it came in its own connection
+	if msg.Typ == "stats_batch" {
+		batch, ok := msg.Msg.(StatsBatch)
+		notOkErr(ok, "stats_batch")
+		for i := range batch.Msgs {
+			if !statsLimit.admit(&batch.Msgs[i]) {
+				metrics.add("rapidchain_stats_dropped_total", batch.Msgs[i].Typ, 1)
+				continue
+			}
+			msg := &batch.Msgs[i]
+			spawn(func() {
+				coordinatorHandleStat(conn, msg, successfullGossips, consensusResults, finalBlockChan, files, rMap, idaresults, receiptVerifier, genesis, chains, ledger, membership, epochStats, views, latencies, resources, progress, wire, metrics)
+			})
+		}
+		return
+	}
+	if !statsLimit.admit(msg) {
+		metrics.add("rapidchain_stats_dropped_total", msg.Typ, 1)
+		return
+	}
+	coordinatorHandleStat(conn, msg, successfullGossips, consensusResults, finalBlockChan, files, rMap, idaresults, receiptVerifier, genesis, chains, ledger, membership, epochStats, views, latencies, resources, progress, wire, metrics)
+}
+
+// conn is the connection of a batch for a stat of -statsBatch, which are never answered
+func coordinatorHandleStat(conn net.Conn,
+	msg *Msg,
+	successfullGossips *idaSuccesses,
+	consensusResults *consensusResult,
+	finalBlockChan *Queue,
+	files []*os.File,
+	rMap *routetxmap,
+	idaresults *IDAGossipResultsMap,
+	receiptVerifier *ReceiptVerifier,
+	genesis *GenesisBlocks,
+	chains *ChainExport,
+	ledger *GlobalLedger,
+	membership *Membership,
+	epochStats *EpochStats,
+	views *ViewChecks,
+	latencies *LatencyResults,
+	resources *ResourceFiles,
+	progress *Progress,
+	wire *WireResults,
+	metrics *Metrics) {
+	standbyMirror.stat(conn, msg)
+	progress.heard(msg.FromPub)
+	metrics.add("rapidchain_stats_received_total", msg.Typ, 1)
 	switch msg.Typ {
 	case "IDASuccess":
 		_, ok := msg.Msg.([32]byte)
 		if !ok {
 			errFatal(ok, "IDASuccess decoding")
 		}
-		//coordinatorHandleIDASuccess(idaMsg, successfullGossips)
 
 	case "consensus":
-		_, ok := msg.Msg.(string)
+		bat, ok := msg.Msg.(ByteArrayAndTimestamp)
 		notOkErr(ok, "coordinator consensus cMsg decoding")
-		//coordinatorHandleConsensus(cMsg, consensusResults)
+		epochStats.addConsensus(bat.Epoch, string(bat.B), bat.T)
 	case "finalblock":
-		log.Println("Recived: ", msg.Typ)
+		coordinatorLog.debugf(nil, "Recived: %s", msg.Typ)
 		block, ok := msg.Msg.(FinalBlock)
 		notOkErr(ok, "finalblock")
-		finalBlockChan <- block
+		chains.add(&block)
+		progress.finalBlock(block.ProposedBlock.CommitteeID)
+		narrator.finalBlock(&block)
+		ledger.addAndAssemble(&block, files[15])
+		finalBlockChan.send(block)
 	case "pocverify":
 		dur, ok := msg.Msg.(time.Duration)
 		notOkErr(ok, "pocverify")
@@ -505,22 +798,10 @@ func coordinatorDebugStatsHandleConnection(conn net.Conn,
 		ok = r.addEnd(bat.T)
 		if ok {
 			// sleep for a delta to let incomming request be processed
-			time.Sleep(default_delta * 3 * time.Millisecond)
-			var s string
-			if r.start.IsZero() {
-				s += "0"
-			} else {
-				s += strconv.FormatInt(r.start.Unix(), 10)
-			}
-			s += ","
-			s += strconv.FormatInt(r.end.Unix(), 10)
-			for cID, tStamp := range r.committees {
-				s += ","
-				s += bytes32ToString(cID)
-				s += ","
-				s += strconv.FormatInt(tStamp.Unix(), 10)
-			}
-			writeStringToFile(s, files[3])
+			clockSleep(default_delta * 3 * time.Millisecond)
+			writeStringToFile(r.row(), files[3])
+			start, end := r.times()
+			epochStats.addTx(bat.Epoch, start, end)
 		}
 	case "start_ida_gossip":
 		bat, ok := msg.Msg.(ByteArrayAndTimestamp)
@@ -528,7 +809,7 @@ func coordinatorDebugStatsHandleConnection(conn net.Conn,
 		ID := toByte32(bat.B)
 		idaresults.add(ID)
 		ida := idaresults.get(ID)
-		ida.start = bat.T
+		ida.setStart(bat.T)
 	case "reconstructed_ida_gossip":
 		bat, ok := msg.Msg.(ByteArrayAndTimestamp)
 		notOkErr(ok, "reconstructed idagossip")
@@ -537,22 +818,14 @@ func coordinatorDebugStatsHandleConnection(conn net.Conn,
 		ida := idaresults.get(ID)
 
 		ok = ida.addReconstructed(bat.T)
+		epochStats.addReconstruction(bat.Epoch, ida.startTime(), bat.T)
 
 		if ok {
-			time.Sleep(default_delta * time.Millisecond * 3)
-			var s string
-			ida.mux.Lock()
-
-			s += strconv.FormatInt(ida.start.Unix(), 10)
-			for _, tStamp := range ida.reconstructed {
-				s += ","
-				s += strconv.FormatInt(tStamp.Unix(), 10)
-			}
-			ida.mux.Unlock()
-			writeStringToFile(s, files[4])
+			clockSleep(default_delta * time.Millisecond * 3)
+			writeStringToFile(ida.row(), files[4])
 		}
 	case "consensus_accept_fail":
-		log.Println("Recived: ", msg.Typ)
+		coordinatorLog.debugf(nil, "Recived: %s", msg.Typ)
 		bat, ok := msg.Msg.(ByteArrayAndTimestamp)
 		notOkErr(ok, "consensus accept fail")
 		if len(bat.B) != 88 {
@@ -564,46 +837,174 @@ func coordinatorDebugStatsHandleConnection(conn net.Conn,
 		iter := binary.LittleEndian.Uint64(bat.B[64:72])
 		totalVotes := int64(binary.LittleEndian.Uint64(bat.B[72:80]))
 		rec := int64(binary.LittleEndian.Uint64(bat.B[80:88]))
-		log.Printf("[ConsensusAcceptFail] cID: %s, pub: %s, iter: %d, totalVotes: %d, rec: %d", bytes32ToString(cID), bytes32ToString(pub), iter, totalVotes, rec)
+		coordinatorLog.warnf(nil, "[ConsensusAcceptFail] cID: %s, pub: %s, iter: %d, totalVotes: %d, rec: %d", bytes32ToString(cID), bytes32ToString(pub), iter, totalVotes, rec)
 		s := fmt.Sprintf("%s,%s,%d,%d,%d", bytes32ToString(cID), bytes32ToString(pub), iter, totalVotes, rec)
 		writeStringToFile(s, files[5])
+		epochStats.addConsensus(bat.Epoch, "accept_fail", bat.T)
+		progress.acceptFail()
+		faultCoverage.react("accept_fail", bytes32ToString(cID), bytes32ToString(pub))
+	case "orphan_stats":
+		bat, ok := msg.Msg.(ByteArrayAndTimestamp)
+		notOkErr(ok, "orphan stats")
+		writeStringToFile(orphanStatsString(bat.B), files[8])
+	case "tx_receipts":
+		batch, ok := msg.Msg.(TxReceiptBatch)
+		notOkErr(ok, "tx receipts")
+		for _, r := range batch.Receipts {
+			valid, reason := receiptVerifier.verify(r)
+			writeStringToFile(receiptResultString(r, valid, reason), files[9])
+		}
+	case "admission_stats":
+		stats, ok := msg.Msg.(string)
+		notOkErr(ok, "admission stats")
+		writeStringToFile(stats, files[10])
+	case "fork":
+		coordinatorLog.debugf(nil, "Recived: %s", msg.Typ)
+		report, ok := msg.Msg.(ForkReport)
+		notOkErr(ok, "fork")
+		writeStringToFile(forkReportString(report), files[11])
+		progress.fork()
+		faultCoverage.react("fork", bytes32ToString(report.CommitteeID), bytes32ToString(report.Pub))
+	case "request_genesis":
+		cID, ok := msg.Msg.([32]byte)
+		notOkErr(ok, "request genesis")
+		block := genesis.get(cID)
+		if block == nil {
+			coordinatorLog.warnf(nil, "[Inbound] dropped request_genesis from %s: no genesis block for committee %s", conn.RemoteAddr(), bytes32ToString(cID))
+			return
+		}
+		sendMsg(conn, block)
+	case "bootstrap":
+		s, ok := msg.Msg.(string)
+		notOkErr(ok, "bootstrap")
+		writeStringToFile(s, files[12])
+	case "compression":
+		s, ok := msg.Msg.(string)
+		notOkErr(ok, "compression")
+		writeStringToFile(s, files[13])
+	case "block_cache":
+		s, ok := msg.Msg.(string)
+		notOkErr(ok, "block_cache")
+		writeStringToFile(s, files[14])
+	case "drg":
+		s, ok := msg.Msg.(string)
+		notOkErr(ok, "drg")
+		writeStringToFile(s, files[16])
+	case "pow":
+		s, ok := msg.Msg.(string)
+		notOkErr(ok, "pow")
+		writeStringToFile(s, files[17])
+	case "sig_cache":
+		s, ok := msg.Msg.(string)
+		notOkErr(ok, "sig_cache")
+		writeStringToFile(s, files[18])
+	case "reconfiguration":
+		rBlock, ok := msg.Msg.(ReconfigurationBlock)
+		notOkErr(ok, "reconfiguration")
+		moved := receiptVerifier.setCommittees(&rBlock)
+		row := membership.setReconfiguration(&rBlock)
+		coordinatorLog.infof(nil, "[Reconfiguration] epoch from iteration %d, %d nodes moved", rBlock.StartIteration, moved)
+		writeStringToFile(reconfigurationString(&rBlock, moved), files[19])
+		coordinatorTuning.boundary(tuneAtEpoch, rBlock.StartIteration)
+		if row != "" {
+			writeStringToFile(row, files[24])
+		}
+		for _, row := range membership.excluded(&rBlock) {
+			writeStringToFile(row, files[25])
+		}
+	case "pow_challenge":
+		sendMsg(conn, membership.challenge)
+	case "run_seed":
+		sendMsg(conn, membership.runSeed)
+	case "clock":
+		answerClock(conn, clockNow())
+	case "join":
+		req, ok := msg.Msg.(Node_InitialMessageToCoordinator)
+		notOkErr(ok, "join")
+		response := membership.join(nodeAddr(conn, req.Host, req.Port), req)
+		if len(response.Nodes) > 0 {
+			receiptVerifier.addMember(req.Pub.Bytes, response.ReconfigurationBlock.committeeOf(req.Pub.Bytes).ID)
+		}
+		sendMsg(conn, response)
+	case "join_report":
+		s, ok := msg.Msg.(string)
+		notOkErr(ok, "join_report")
+		writeStringToFile(s, files[20])
+	case "request_reconfiguration":
+		req, ok := msg.Msg.(EpochRequest)
+		notOkErr(ok, "request_reconfiguration")
+		rBlock, moved, isNew, row := membership.reconfiguration(req)
+		sendMsg(conn, *rBlock)
+		if isNew {
+			receiptVerifier.setCommittees(rBlock)
+			coordinatorLog.infof(nil, "[Reconfiguration] epoch %d from iteration %d, %d nodes moved", req.Epoch, rBlock.StartIteration, moved)
+			writeStringToFile(reconfigurationString(rBlock, moved), files[19])
+			if row != "" {
+				writeStringToFile(row, files[24])
+			}
+			for _, row := range membership.excluded(rBlock) {
+				writeStringToFile(row, files[25])
+			}
+		}
+	case "request_reference":
+		sendMsg(conn, membership.reference())
+	case "node_join":
+		cert, ok := msg.Msg.(JoinCertificate)
+		notOkErr(ok, "node_join")
+		membership.add(cert.Info)
+		receiptVerifier.addMember(cert.Info.Pub.Bytes, cert.Info.CommitteeID)
+	case "leave":
+		l, ok := msg.Msg.(Leave)
+		notOkErr(ok, "leave")
+		if s, ok := membership.leave(l); ok {
+			writeStringToFile(s, files[21])
+		}
+	case "switch":
+		s, ok := msg.Msg.(string)
+		notOkErr(ok, "switch")
+		writeStringToFile(s, files[22])
+	case "tuning":
+		s, ok := msg.Msg.(string)
+		notOkErr(ok, "tuning")
+		writeStringToFile(s, files[30])
+	case "rejoin":
+		sendMsg(conn, membership.rejoin(msg.FromPub))
+	case "churn":
+		s, ok := msg.Msg.(string)
+		notOkErr(ok, "churn")
+		writeStringToFile(s, files[23])
+		faultCoverage.reactChurn(s)
+	case "committee_view":
+		v, ok := msg.Msg.(CommitteeView)
+		notOkErr(ok, "committee_view")
+		views.add(v, files[27])
+	case "latency_report":
+		r, ok := msg.Msg.(LatencyReport)
+		notOkErr(ok, "latency_report")
+		latencies.add(&r)
+		epochStats.addLatencyReport(&r)
+	case "resource_sample":
+		s, ok := msg.Msg.(ResourceSample)
+		notOkErr(ok, "resource_sample")
+		resources.write(s)
+	case "wire_stats":
+		r, ok := msg.Msg.(WireReport)
+		notOkErr(ok, "wire_stats")
+		wire.add(&r)
+	case "block_phases":
+		p, ok := msg.Msg.(BlockPhases)
+		notOkErr(ok, "block_phases")
+		writeStringToFile(p.row(), files[29])
+	case "equivocation":
+		p, ok := msg.Msg.(EquivocationProof)
+		notOkErr(ok, "equivocation")
+		membership.addEvidence(p)
+	case "fault_hit":
+		h, ok := msg.Msg.(FaultHit)
+		notOkErr(ok, "fault_hit")
+		faultCoverage.hit(h)
 
 	default:
 		errFatal(nil, "no known message type (coordinator)")
 	}
 }
-
-func coordinatorHandleIDASuccess(root [32]byte, successfullGossips *map[[32]byte]int) {
-	/*
-		(*successfullGossips)[root] += 1
-		if (*successfullGossips)[root] >= int(default_n/default_m) {
-			// this is not perfect, but it will atleast show if all nodes recived a successfull ida msg
-			log.Println("IDAGossip success for root ", root, "with ", (*successfullGossips)[root], " nodes succesfull")
-		}
-	*/
-}
-
-func coordinatorHandleConsensus(tag string, consensusResults *consensusResult) {
-
-	tmp := (*consensusResults)
-	switch tag {
-	case "echo":
-		tmp.echos += 1
-	case "pending":
-		tmp.pending += 1
-	case "accept":
-		tmp.accepts += 1
-	}
-	(*consensusResults) = tmp
-	// TODO fix this to handle multiple committees
-	/*
-		if v := uint((default_n/default_m)/default_committeeF) + uint(1); uint(tmp.accepts) >= v*v {
-			log.Println(tmp.accepts, " accepts")
-		} else if v*v <= uint(tmp.pending) {
-			log.Println(tmp.pending, " pendings")
-		} else if v*v <= uint(tmp.echos) {
-			log.Println(tmp.echos, " echos")
-		}
-	*/
-
-}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// -vpcus and -cpuSet, the cpus of a process
+
+// the cpus of a list like 0-3,8,10-11
+func parseCPUSet(s string) ([]int, error) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("cpu %q of -cpuSet %q is not a number", bounds[0], s)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("range %q of -cpuSet %q is not a range", part, s)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			seen[cpu] = true
+		}
+	}
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+func formatCPUSet(cpus []int) string {
+	parts := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		parts[i] = strconv.Itoa(cpu)
+	}
+	return strings.Join(parts, ",")
+}
+
+// the cpus of process i of count that share cpus
+func cpuShare(cpus []int, i uint, count uint) []int {
+	if count == 0 || uint(len(cpus)) < count {
+		return []int{cpus[int(i)%len(cpus)]}
+	}
+	size := uint(len(cpus)) / count
+	return cpus[i*size : (i+1)*size]
+}
+
+// pins the process to -cpuSet and sets GOMAXPROCS
+func applyCPUs(flagArgs *FlagArgs, vCPUsGiven bool) {
+	if flagArgs.cpuSet != "" {
+		cpus, err := parseCPUSet(flagArgs.cpuSet)
+		ifErrFatal(err, "-cpuSet")
+		ifErrFatal(pinCPUs(cpus), "pinning the process to -cpuSet "+flagArgs.cpuSet)
+		nodeLog.infof(nil, "[CPU] pinned to cpus %s", formatCPUSet(cpus))
+		if !vCPUsGiven {
+			flagArgs.vCPUs = uint(len(cpus))
+		}
+	} else if !vCPUsGiven {
+		return
+	}
+	runtime.GOMAXPROCS(int(flagArgs.vCPUs))
+	nodeLog.infof(nil, "[CPU] GOMAXPROCS %d", flagArgs.vCPUs)
+}
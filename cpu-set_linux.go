@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// sched_setaffinity pins a thread, the threads the runtime starts later inherit the cpus of the
+// thread that starts them, so every thread of the process is pinned until no new one came up
+func pinCPUs(cpus []int) error {
+	mask := make([]uint64, cpus[len(cpus)-1]/64+1)
+	for _, cpu := range cpus {
+		mask[cpu/64] |= 1 << uint(cpu%64)
+	}
+	pinned := make(map[int]bool)
+	for {
+		tasks, err := ioutil.ReadDir("/proc/self/task")
+		if err != nil {
+			return err
+		}
+		fresh := 0
+		for _, task := range tasks {
+			tid, err := strconv.Atoi(task.Name())
+			if err != nil || pinned[tid] {
+				continue
+			}
+			_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+			// a thread that ended meanwhile is gone
+			if errno != 0 && errno != syscall.ESRCH {
+				return errno
+			}
+			pinned[tid] = true
+			fresh++
+		}
+		if fresh == 0 {
+			return nil
+		}
+	}
+}
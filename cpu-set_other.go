@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+func pinCPUs(cpus []int) error {
+	return errors.New("-cpuSet pins to cpus on linux only")
+}
@@ -2,8 +2,6 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"time"
 
 	"github.com/jinzhu/copier"
 	"github.com/renzhf/go-merkletree"
@@ -263,7 +261,7 @@ func createProposeBlock(nodeCtx *NodeCtx) *ProposedBlock {
 	block.Transactions = txes
 
 	block.Iteration = nodeCtx.i.getI()
-	block.CommitteeID = nodeCtx.self.CommitteeID
+	block.CommitteeID = nodeCtx.committeeID()
 	block.LeaderPub = nodeCtx.self.Priv.Pub
 
 	tree := createMerkleTree(nodeCtx, txes)
@@ -332,7 +330,7 @@ func processTransactions(nodeCtx *NodeCtx, txes []*Transaction) []*Transaction {
 				closestCommittee := txFindClosestCommittee(nodeCtx, inp.TxHash)
 				// fmt.Println(bytes32ToString(closestCommittee), bytes32ToString(nodeCtx.self.CommitteeID))
 				// fmt.Println(inp)
-				if closestCommittee != nodeCtx.self.CommitteeID {
+				if closestCommittee != nodeCtx.committeeID() {
 					// the input belongs to another committee
 					normal = false
 					break
@@ -340,6 +338,12 @@ func processTransactions(nodeCtx *NodeCtx, txes []*Transaction) []*Transaction {
 			}
 			if normal {
 				// all inputs belonged to this committee
+				if missing := missingParents(nodeCtx, t, spentUTXOSet, addedUTXOSet); len(missing) > 0 {
+					// parent transaction is not committed yet, hold as orphan untill it is
+					nodeCtx.orphanPool.add(trans, missing, nodeCtx.i.getI())
+					nodeCtx.txPool.remove(trans.id())
+					continue
+				}
 				// log.Println("Normal transaction, all inputs in this committee")
 				// todo add rest of sets here
 				res := processNormalTransaction(nodeCtx, t, spentUTXOSet, addedUTXOSet)
@@ -402,12 +406,12 @@ func proccessCrossTxResponse(nodeCtx *NodeCtx,
 	tmpCrossTxPool *CrossTxPool) (*Transaction, bool) {
 
 	// calculate time it took to validate
-	before := time.Now()
+	before := clockNow()
 
 	// verify proof of consensus (PoC):
 	if t.ProofOfConsensus == nil {
-		fmt.Println(t)
-		errFatal(nil, "Proof of consensus was nil")
+		nodeLog.warnf(nodeCtx, "dropped cross-tx-response without proof of consensus")
+		return nil, false
 	}
 
 	// PoC: validate merkle proof
@@ -415,45 +419,61 @@ func proccessCrossTxResponse(nodeCtx *NodeCtx,
 	verified, err := merkletree.VerifyProof(tmpid[:], false, t.ProofOfConsensus.MerkleProof, [][]byte{t.ProofOfConsensus.MerkleRoot[:]})
 	fail := ifErr(err, "merkletree.Verifyproof")
 	if fail || !verified {
-		errFatal(err, "proof could not be verified")
+		nodeLog.warnf(nodeCtx, "dropped cross-tx-response, merkle proof could not be verified")
+		return nil, false
 	}
 
 	// PoC: validate hashes
 	mrHash := hash(t.ProofOfConsensus.MerkleRoot[:])
 	valHash := hash(byteSliceAppend(t.ProofOfConsensus.IntermediateHash[:], mrHash[:]))
 	if valHash != t.ProofOfConsensus.GossipHash {
-		errFatal(nil, fmt.Sprintf("ProofOfConsensus hashes invalid. calculatedhash: %s, GossipHash: %s", bytes32ToString(valHash), bytes32ToString(t.ProofOfConsensus.GossipHash)))
+		nodeLog.warnf(nodeCtx, "dropped cross-tx-response, ProofOfConsensus hashes invalid. calculatedhash: %s, GossipHash: %s", bytes32ToString(valHash), bytes32ToString(t.ProofOfConsensus.GossipHash))
+		return nil, false
 	}
 
 	// PoC: validate signatures:
 
-	if uint(len(t.ProofOfConsensus.Signatures)) < (nodeCtx.flagArgs.n/nodeCtx.flagArgs.m)/nodeCtx.flagArgs.committeeF {
-		errFatal(nil, fmt.Sprintf("Len of signatures: %d was lower than required: %d ", len(t.ProofOfConsensus.Signatures), (nodeCtx.flagArgs.n/nodeCtx.flagArgs.m)/nodeCtx.flagArgs.committeeF))
+	// the blockchain is locked by createProposeBlock
+	if rb := nodeCtx.blockchain._getLastReconfigurationBlock(); rb.Weights != nil {
+		// with -weights the signers need the weight of a quorum of their committee
+		signers := make(map[[32]byte]bool)
+		for _, cMsg := range t.ProofOfConsensus.Signatures {
+			signers[cMsg.Pub.Bytes] = true
+		}
+		required := rb.Weights.blockQuorum(rb.membersOf(txFindClosestCommittee(nodeCtx, t.Inputs[0].TxHash)), nodeCtx.flagArgs.committeeF)
+		if rb.Weights.ofSet(signers) < required {
+			nodeLog.warnf(nodeCtx, "dropped cross-tx-response, weight of signatures: %d was lower than required: %d", rb.Weights.ofSet(signers), required)
+			return nil, false
+		}
+	} else if uint(len(t.ProofOfConsensus.Signatures)) < (nodeCtx.flagArgs.n/nodeCtx.flagArgs.m)/nodeCtx.flagArgs.committeeF {
+		nodeLog.warnf(nodeCtx, "dropped cross-tx-response, len of signatures: %d was lower than required: %d", len(t.ProofOfConsensus.Signatures), (nodeCtx.flagArgs.n/nodeCtx.flagArgs.m)/nodeCtx.flagArgs.committeeF)
+		return nil, false
+	}
+	if ok, _ := VerifyBatch(consensusMsgItems(t.ProofOfConsensus.Signatures), nodeCtx.flagArgs.vCPUs, true); !ok {
+		nodeLog.warnf(nodeCtx, "dropped cross-tx-response with an invalid signature")
+		return nil, false
 	}
-	rBlock := nodeCtx.blockchain._getLastReconfigurationBlock()
 	for _, cMsg := range t.ProofOfConsensus.Signatures {
-		// verify signature
-
-		ok := cMsg.Pub.verify(cMsg.calculateHash(), cMsg.Sig)
-		notOkErr(ok, "signature verify")
-
 		// verify that pub exists in that committee
 		// the id of the committee that sent cross-tx-response is the same as the committee that the input belongs to
 		comitteeID := txFindClosestCommittee(nodeCtx, t.Inputs[0].TxHash)
-		if comitteeID == nodeCtx.self.CommitteeID {
-			errFatal(nil, "committee of cross-tx-response was this committee?")
+		if comitteeID == nodeCtx.committeeID() {
+			nodeLog.warnf(nodeCtx, "dropped cross-tx-response of this committee")
+			return nil, false
 		}
-		if _, ok := rBlock.Committees[comitteeID].Members[cMsg.Pub.Bytes]; !ok {
-			errFatal(nil, "signature pub did not exist in that committee")
+		if !nodeCtx.blockchain._inRecentCommittee(comitteeID, cMsg.Pub.Bytes) {
+			nodeLog.warnf(nodeCtx, "dropped cross-tx-response, signature pub did not exist in that committee")
+			return nil, false
 		}
 	}
 
-	dur := time.Now().Sub(before)
-	go dialAndSendToCoordinator("pocverify", dur)
+	dur := clockNow().Sub(before)
+	spawnDialAndSendToCoordinator("pocverify", dur)
 
 	// add output to temp
 	if len(t.Inputs) != len(t.Outputs) {
-		errFatal(nil, "length of crossTxResponse inputs was not equal to len of outputs")
+		nodeLog.warnf(nodeCtx, "dropped cross-tx-response, length of inputs was not equal to len of outputs")
+		return nil, false
 	}
 
 	// get original tx, throw error if the original tx is not found
@@ -461,8 +481,8 @@ func proccessCrossTxResponse(nodeCtx *NodeCtx,
 	if original == nil {
 		original = tmpCrossTxPool.getOriginal(t.OrigTxHash)
 		if original == nil {
-			fmt.Println(t)
-			errFatal(nil, "no original tx")
+			nodeLog.warnf(nodeCtx, "dropped cross-tx-response without original tx %s", bytes32ToString(t.OrigTxHash))
+			return nil, false
 		}
 	}
 
@@ -515,6 +535,8 @@ func proccessCrossTxResponse(nodeCtx *NodeCtx,
 	newTx := new(Transaction)
 
 	newTx.OrigTxHash = original.OrigTxHash
+	newTx.Class = original.Class
+	newTx.Trace = original.Trace
 	newTx.Outputs = original.Outputs
 
 	newInputs := []*InTx{}
@@ -530,16 +552,16 @@ func proccessCrossTxResponse(nodeCtx *NodeCtx,
 		if outTx == nil {
 			outTx = addedUTXOSet.get(inp.TxHash, inp.N)
 			if outTx == nil {
-				fmt.Println(original)
-				fmt.Println(inp)
-				fmt.Println(outTx)
+				nodeLog.debugf(nodeCtx, "%v", original)
+				nodeLog.debugf(nodeCtx, "%v", inp)
+				nodeLog.debugf(nodeCtx, "%v", outTx)
 
-				fmt.Println(bytes32ToString(txFindClosestCommittee(nodeCtx, inp.TxHash)))
-				fmt.Println(bytes32ToString(nodeCtx.self.CommitteeID))
+				nodeLog.debugf(nodeCtx, "%v", bytes32ToString(txFindClosestCommittee(nodeCtx, inp.TxHash)))
+				nodeLog.debugf(nodeCtx, "%v", bytes32ToString(nodeCtx.committeeID()))
 
 				if spenttx := spentUTXOSet.get(inp.TxHash, inp.N); spenttx != nil {
-					log.Println("original UTXO was allready spent :o ")
-					log.Println(spenttx)
+					nodeLog.warnf(nodeCtx, "original UTXO was allready spent :o")
+					nodeLog.debugf(nodeCtx, "%v", spenttx)
 					errFatal(nil, "spent")
 				}
 
@@ -553,20 +575,20 @@ func proccessCrossTxResponse(nodeCtx *NodeCtx,
 		// }
 
 		if spentUTXOSet.get(inp.TxHash, inp.N) != nil {
-			fmt.Println(inp)
-			fmt.Println(outTx)
+			nodeLog.debugf(nodeCtx, "%v", inp)
+			nodeLog.debugf(nodeCtx, "%v", outTx)
 			// fmt.Println(bytes32ToString(c.CrossTxResponseID))
 			// fmt.Println(c.Nonce)
 
-			fmt.Println("UTXOSet ", nodeCtx.utxoSet)
-			fmt.Println("addedUTXOSet ", addedUTXOSet)
-			fmt.Println("spentUTXOSet ", spentUTXOSet)
-			fmt.Println("original: ", original)
+			nodeLog.debugf(nodeCtx, "UTXOSet %v", nodeCtx.utxoSet)
+			nodeLog.debugf(nodeCtx, "addedUTXOSet %v", addedUTXOSet)
+			nodeLog.debugf(nodeCtx, "spentUTXOSet %v", spentUTXOSet)
+			nodeLog.debugf(nodeCtx, "original: %v", original)
 
-			fmt.Println(bytes32ToString(txFindClosestCommittee(nodeCtx, inp.TxHash)))
-			fmt.Println(bytes32ToString(nodeCtx.self.CommitteeID))
+			nodeLog.debugf(nodeCtx, "%v", bytes32ToString(txFindClosestCommittee(nodeCtx, inp.TxHash)))
+			nodeLog.debugf(nodeCtx, "%v", bytes32ToString(nodeCtx.committeeID()))
 
-			log.Println("UTXO allready spent")
+			nodeLog.warnf(nodeCtx, "UTXO allready spent")
 			errFatal(nil, "spent")
 		}
 
@@ -600,24 +622,25 @@ func processIncommingCrossTx(nodeCtx *NodeCtx, t *Transaction, spentUTXOSet *UTX
 	// validate inputs.
 
 	if t.Outputs != nil {
-		fmt.Print(t)
-		fmt.Print(t.whatAmI(nodeCtx))
-		fmt.Print(bytes32ToString(txFindClosestCommittee(nodeCtx, t.OrigTxHash)))
-		fmt.Println(bytes32ToString(nodeCtx.self.CommitteeID))
-		errFatal(nil, "outputs was not nil in incomming cross-tx")
+		nodeLog.debugf(nodeCtx, "%v", t)
+		nodeLog.debugf(nodeCtx, "%v", t.whatAmI(nodeCtx))
+		nodeLog.debugf(nodeCtx, "%v", bytes32ToString(txFindClosestCommittee(nodeCtx, t.OrigTxHash)))
+		nodeLog.debugf(nodeCtx, "%v", bytes32ToString(nodeCtx.committeeID()))
+		nodeLog.warnf(nodeCtx, "dropped incomming cross-tx, outputs was not nil")
+		return false
 	}
 
 	// fmt.Println(bytes32ToString(nodeCtx.self.CommitteeID), bytes32ToString(txFindClosestCommittee(nodeCtx, t.Inputs[0].TxHash)))
-	if nodeCtx.self.CommitteeID != txFindClosestCommittee(nodeCtx, t.Inputs[0].TxHash) {
-		fmt.Println(bytes32ToString(nodeCtx.self.CommitteeID), bytes32ToString(txFindClosestCommittee(nodeCtx, t.Inputs[0].TxHash)))
-		errFatal(nil, "incomming cross tx not beloning in thsi committee")
+	if nodeCtx.committeeID() != txFindClosestCommittee(nodeCtx, t.Inputs[0].TxHash) {
+		nodeLog.debugf(nodeCtx, "%v %v", bytes32ToString(nodeCtx.committeeID()), bytes32ToString(txFindClosestCommittee(nodeCtx, t.Inputs[0].TxHash)))
+		nodeLog.warnf(nodeCtx, "dropped incomming cross-tx not beloning in this committee")
+		return false
 	}
 
 	for _, inp := range t.Inputs {
 		if !validateInput(nodeCtx, inp, t.OrigTxHash, spentUTXOSet, addedUTXOSet) {
-			log.Println("Incoming cross-tx input not valid")
-			fmt.Println(t)
-			errFatal(nil, "cross-tx")
+			nodeLog.warnf(nodeCtx, "Incoming cross-tx input not valid")
+			nodeLog.debugf(nodeCtx, "%v", t)
 			return false
 		}
 	}
@@ -629,13 +652,13 @@ func processIncommingCrossTx(nodeCtx *NodeCtx, t *Transaction, spentUTXOSet *UTX
 
 	// create new transaction with outputs
 	if t.Outputs != nil {
-		errFatal(nil, fmt.Sprintf("t.Outputs was not nil: ", t.Outputs))
+		errFatal(nil, fmt.Sprintf("t.Outputs was not nil: %v", t.Outputs))
 	}
 
 	t.Outputs = newOuts
 
 	if t.Hash != [32]byte{} {
-		errFatal(nil, fmt.Sprintf("t.Hash was not nil: ", t.Hash))
+		errFatal(nil, fmt.Sprintf("t.Hash was not nil: %v", t.Hash))
 	}
 
 	// set a new hash
@@ -659,7 +682,7 @@ func processTransactionWithUnknowInputs(nodeCtx *NodeCtx, t *Transaction, spentU
 	testtmp := 0
 	for _, inp := range t.Inputs {
 		closestCommittee := txFindClosestCommittee(nodeCtx, inp.TxHash)
-		if closestCommittee != nodeCtx.self.CommitteeID {
+		if closestCommittee != nodeCtx.committeeID() {
 			// in another committee
 			if len(newInputs[closestCommittee]) == 0 {
 				newInputs[closestCommittee] = []*InTx{inp}
@@ -671,7 +694,7 @@ func processTransactionWithUnknowInputs(nodeCtx *NodeCtx, t *Transaction, spentU
 			testtmp++
 			// in this committe, validate
 			if !validateInput(nodeCtx, inp, t.id(), spentUTXOSet, addedUTXOSet) {
-				log.Println("input not valid")
+				nodeLog.warnf(nodeCtx, "input not valid")
 				errFatal(nil, "input not valid")
 				return nil
 			}
@@ -723,7 +746,7 @@ func validateInput(nodeCtx *NodeCtx, iTx *InTx, txID [32]byte, spentUTXOSet *UTX
 
 	// check if output is not allready spent
 	if spentUTXOSet.get(iTx.TxHash, iTx.N) != nil {
-		log.Println("UTXO allready spent")
+		nodeLog.warnf(nodeCtx, "UTXO allready spent")
 		errFatal(nil, "spent")
 		return false
 	}
@@ -733,21 +756,21 @@ func validateInput(nodeCtx *NodeCtx, iTx *InTx, txID [32]byte, spentUTXOSet *UTX
 	if outTx == nil {
 		outTx = addedUTXOSet.get(iTx.TxHash, iTx.N)
 		if outTx == nil {
-			log.Println("No UTXO on this input")
-			fmt.Println(iTx)
+			nodeLog.warnf(nodeCtx, "No UTXO on this input")
+			nodeLog.debugf(nodeCtx, "%v", iTx)
 			errFatal(nil, "utxo")
 			return false
 		}
 	}
 
 	// check signature
-	if !verify(outTx.PubKey.Pub, iTx.getHash(txID), iTx.Sig) {
-		log.Println("Signature not valid")
-		fmt.Println("Pubkey ", bytesToString(outTx.PubKey.Bytes[:]))
-		fmt.Println("Out tx N: ", outTx.N, "InTX N: ", iTx.N)
-		fmt.Println("OutTx", outTx)
-		fmt.Println("Input", iTx)
-		fmt.Println("txhash", bytesToString(iTx.TxHash[:]))
+	if !outTx.PubKey.verify(iTx.getHash(txID), iTx.Sig) {
+		nodeLog.warnf(nodeCtx, "Signature not valid")
+		nodeLog.debugf(nodeCtx, "Pubkey %v", bytesToString(outTx.PubKey.Bytes[:]))
+		nodeLog.debugf(nodeCtx, "Out tx N: %v InTX N: %v", outTx.N, iTx.N)
+		nodeLog.debugf(nodeCtx, "OutTx %v", outTx)
+		nodeLog.debugf(nodeCtx, "Input %v", iTx)
+		nodeLog.debugf(nodeCtx, "txhash %v", bytesToString(iTx.TxHash[:]))
 		return false
 	}
 
@@ -800,7 +823,7 @@ func validateNormalTransaction(nodeCtx *NodeCtx, t *Transaction, spentUTXOSet *U
 
 		// check if output is not allready spent
 		if spentUTXOSet.get(inp.TxHash, inp.N) != nil {
-			log.Println("UTXO allready spent")
+			nodeLog.warnf(nodeCtx, "UTXO allready spent")
 			errFatal(nil, "UTXO allready spent")
 			return false
 		}
@@ -809,23 +832,23 @@ func validateNormalTransaction(nodeCtx *NodeCtx, t *Transaction, spentUTXOSet *U
 		if outTx == nil {
 			outTx = addedUTXOSet._get(inp.TxHash, inp.N)
 			if outTx == nil {
-				log.Println("No UTXO on this input")
-				fmt.Println(t)
+				nodeLog.warnf(nodeCtx, "No UTXO on this input")
+				nodeLog.debugf(nodeCtx, "%v", t)
 				errFatal(nil, "No UTXO on this input")
 				return false
 			}
 		}
 
 		// check signature
-		if !verify(outTx.PubKey.Pub, inp.getHash(t.id()), inp.Sig) {
-			log.Println("Signature not valid")
+		if !outTx.PubKey.verify(inp.getHash(t.id()), inp.Sig) {
+			nodeLog.warnf(nodeCtx, "Signature not valid")
 
-			fmt.Println("Pubkey ", bytesToString(outTx.PubKey.Bytes[:]))
+			nodeLog.debugf(nodeCtx, "Pubkey %v", bytesToString(outTx.PubKey.Bytes[:]))
 
-			fmt.Println("Out tx N: ", outTx.N, "InTX N: ", inp.N)
-			fmt.Println("OutTx", outTx)
-			fmt.Println("Input", inp)
-			fmt.Println("Spec: ", inp.N, inp.TxHash)
+			nodeLog.debugf(nodeCtx, "Out tx N: %v InTX N: %v", outTx.N, inp.N)
+			nodeLog.debugf(nodeCtx, "OutTx %v", outTx)
+			nodeLog.debugf(nodeCtx, "Input %v", inp)
+			nodeLog.debugf(nodeCtx, "Spec: %v %v", inp.N, inp.TxHash)
 			errFatal(nil, "signature")
 			return false
 		}
@@ -839,7 +862,7 @@ func validateNormalTransaction(nodeCtx *NodeCtx, t *Transaction, spentUTXOSet *U
 	}
 
 	if totalOutputValue != totalUTXOValue {
-		log.Printf("Total output value in transaction %d not equal to total UTXO value %d", totalOutputValue, totalUTXOValue)
+		nodeLog.warnf(nodeCtx, "Total output value in transaction %d not equal to total UTXO value %d", totalOutputValue, totalUTXOValue)
 		errFatal(nil, "value")
 		return false
 	}
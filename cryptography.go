@@ -2,9 +2,9 @@ package main
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/hex"
 	"math/big"
 )
@@ -12,28 +12,58 @@ import (
 // since everyone uses the same curve its okay to have it as a global param
 var eCurve elliptic.Curve = elliptic.P256()
 
+// Only one of the keys is set, depending on the signature scheme (-sigScheme)
 type PrivKey struct {
 	Priv *ecdsa.PrivateKey
+	Ed   ed25519.PrivateKey
+	Bls  *big.Int
 	Pub  *PubKey
 }
 
 type PubKey struct {
 	Pub   *ecdsa.PublicKey
-	Bytes [32]byte // hash of x.bytes | y.bytes
+	Ed    ed25519.PublicKey
+	Bls   []byte   // compressed G1 point
+	Bytes [32]byte // hash of x.bytes | y.bytes, or of the ed25519 or bls key
 }
 
+// R and S for ecdsa, Raw for the other schemes
 type Sig struct {
-	R *big.Int
-	S *big.Int
+	R   *big.Int
+	S   *big.Int
+	Raw []byte
 }
 
 func (s *Sig) bytes() []byte {
+	if s.R == nil || s.S == nil {
+		return s.Raw
+	}
 	return byteSliceAppend(s.R.Bytes(), s.S.Bytes())
 }
 
+// generates a key of the scheme selected with -sigScheme, in a simulation from its run seed
 func (k *PrivKey) gen() {
-	privKey, err := ecdsa.GenerateKey(eCurve, rand.Reader)
-	ifErrFatal(err, "ecdsa genkey")
+	if simNet != nil {
+		var seed [32]byte
+		nodeRand().Read(seed[:])
+		k.derive(seed)
+		return
+	}
+	switch sigScheme {
+	case schemeBls:
+		k.setBls(blsRandScalar())
+	case schemeEd25519:
+		_, privKey, err := ed25519.GenerateKey(rand.Reader)
+		ifErrFatal(err, "ed25519 genkey")
+		k.setEd(privKey)
+	default:
+		privKey, err := ecdsa.GenerateKey(eCurve, rand.Reader)
+		ifErrFatal(err, "ecdsa genkey")
+		k.setPriv(privKey)
+	}
+}
+
+func (k *PrivKey) setPriv(privKey *ecdsa.PrivateKey) {
 	k.Priv = privKey
 	k.Pub = &PubKey{}
 	k.Pub.Pub = &k.Priv.PublicKey
@@ -41,19 +71,47 @@ func (k *PrivKey) gen() {
 	k.Pub.init()
 }
 
+func (k *PrivKey) setEd(privKey ed25519.PrivateKey) {
+	k.Ed = privKey
+	k.Pub = &PubKey{}
+	k.Pub.Ed = privKey.Public().(ed25519.PublicKey)
+
+	k.Pub.init()
+}
+
+func (k *PrivKey) setBls(sk *big.Int) {
+	k.Bls = sk
+	k.Pub = &PubKey{}
+	k.Pub.Bls = blsPub(sk)
+
+	k.Pub.init()
+}
+
 func (k *PrivKey) sign(hashedMsg [32]byte) *Sig {
-	r, s, err := ecdsa.Sign(rand.Reader, k.Priv, hashedMsg[:])
-	ifErrFatal(err, "ecdsa sign")
-	return &Sig{r, s}
+	return k.signer().sign(hashedMsg)
 }
 
 func (k *PubKey) init() {
-	b := k.xyBytes()
-	k.Bytes = hash(b[:])
+	k.Bytes = hash(k.verifier().keyBytes())
 }
 
 func (k *PubKey) verify(hashedMsg [32]byte, sig *Sig) bool {
-	return verify(k.Pub, hashedMsg, sig)
+	if sig == nil {
+		return false
+	}
+	v := k.verifier()
+	if !sigCache.enabled() {
+		return v.verify(hashedMsg, sig)
+	}
+	key := sigCacheKey(v.keyBytes(), hashedMsg, sig)
+	if _, ok := sigCache.get(key); ok {
+		return true
+	}
+	if !v.verify(hashedMsg, sig) {
+		return false
+	}
+	sigCache.add(key, true)
+	return true
 }
 
 func (k *PubKey) xyBytes() [64]byte {
@@ -71,16 +129,7 @@ func (k *PubKey) string() string {
 	return hex.EncodeToString(k.Bytes[:])
 }
 
-func verify(pubKey *ecdsa.PublicKey, hashedMsg [32]byte, sig *Sig) bool {
-	return ecdsa.Verify(pubKey, hashedMsg[:], sig.R, sig.S)
-}
-
 func hash(msg []byte) [32]byte {
-	h := sha256.New()
-	h.Write(msg)
-	hashedMsg := h.Sum(nil)
-	if len(hashedMsg) != 32 {
-		errFatal(nil, "hash not 32 bytes")
-	}
-	return toByte32(hashedMsg)
+	// Sum256 of both hash functions does not allocate, this is called for every chunk, tx and header
+	return hashSum(msg)
 }
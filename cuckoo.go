@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// the cuckoo rule that keeps the committees below their adversary bound
+
+type cuckooRing struct {
+	ids     [][32]byte // committees sorted by id
+	regions uint64     // per committee
+	width   uint64     // of a region
+}
+
+func newCuckooRing(rBlock *ReconfigurationBlock, regions uint) *cuckooRing {
+	r := &cuckooRing{regions: uint64(regions)}
+	for id := range rBlock.Committees {
+		r.ids = append(r.ids, id)
+	}
+	sort.Slice(r.ids, func(i, j int) bool { return bytes.Compare(r.ids[i][:], r.ids[j][:]) < 0 })
+	r.width = math.MaxUint64 / (uint64(len(r.ids)) * r.regions)
+	return r
+}
+
+func (r *cuckooRing) region(pos uint64) uint64 {
+	region := pos / r.width
+	if last := uint64(len(r.ids))*r.regions - 1; region > last {
+		region = last
+	}
+	return region
+}
+
+func (r *cuckooRing) committee(pos uint64) [32]byte {
+	return r.ids[r.region(pos)/r.regions]
+}
+
+// positions of the members of rBlock. Members without one, all of them in the block of the
+// coordinator and nodes that joined during the epoch, get one derived in the span of their committee
+func cuckooPositions(rBlock *ReconfigurationBlock, r *cuckooRing) map[[32]byte]uint64 {
+	positions := make(map[[32]byte]uint64)
+	for pub, pos := range rBlock.Positions {
+		positions[pub] = pos
+	}
+	span := r.regions * r.width
+	for i, id := range r.ids {
+		for pub := range rBlock.Committees[id].Members {
+			if _, ok := positions[pub]; ok {
+				continue
+			}
+			h := hash(byteSliceAppend(rBlock.Randomness[:], pub[:]))
+			positions[pub] = uint64(i)*span + binary.LittleEndian.Uint64(h[:8])%span
+		}
+	}
+	return positions
+}
+
+// the next assignment: a churn fraction of the nodes, chosen with rnd, leaves and joins again by
+// the cuckoo rule. Moves that would leave a committee with less than half the average size, or
+// less than default_minCommitteeSize, are skipped, and committees that are below that size after
+// nodes left get random members of the largest committee. Returns the block without its hash and
+// the number of nodes that changed committee
+func cuckooRule(prev *ReconfigurationBlock, rnd [32]byte, churn float64, regions uint) (*ReconfigurationBlock, int) {
+	ring := newCuckooRing(prev, regions)
+	positions := cuckooPositions(prev, ring)
+	members := make(map[[32]byte]*CommitteeMember)
+	sizes := make(map[[32]byte]int)
+	pubs := [][32]byte{}
+	for id, c := range prev.Committees {
+		for pub, m := range c.Members {
+			members[pub] = m
+			sizes[id]++
+			pubs = append(pubs, pub)
+		}
+	}
+	sort.Slice(pubs, func(i, j int) bool { return bytes.Compare(pubs[i][:], pubs[j][:]) < 0 })
+
+	minSize := len(pubs) / len(ring.ids) / 2
+	if minSize < default_minCommitteeSize {
+		minSize = default_minCommitteeSize
+	}
+	source := newSeededSource(int64(binary.LittleEndian.Uint64(rnd[:8])))
+	move := func(pub [32]byte, pos uint64) bool {
+		from, to := ring.committee(positions[pub]), ring.committee(pos)
+		if from != to && sizes[from] <= minSize {
+			return false
+		}
+		sizes[from]--
+		sizes[to]++
+		positions[pub] = pos
+		return true
+	}
+
+	rejoin := append([][32]byte{}, pubs...)
+	source.Shuffle(len(rejoin), func(i, j int) { rejoin[i], rejoin[j] = rejoin[j], rejoin[i] })
+	count := int(math.Ceil(churn * float64(len(rejoin))))
+	if count > len(rejoin) {
+		count = len(rejoin)
+	}
+	for _, pub := range rejoin[:count] {
+		pos := uint64(randomInt64(source))
+		if !move(pub, pos) {
+			continue
+		}
+		region := ring.region(pos)
+		for _, other := range pubs {
+			if other != pub && ring.region(positions[other]) == region {
+				move(other, uint64(randomInt64(source)))
+			}
+		}
+	}
+
+	// rebalance, the cuckoo rule does not fill committees that lost members by leaves
+	span := ring.regions * ring.width
+	for {
+		small, large := 0, 0
+		for i, id := range ring.ids {
+			if sizes[id] < sizes[ring.ids[small]] {
+				small = i
+			}
+			if sizes[id] > sizes[ring.ids[large]] {
+				large = i
+			}
+		}
+		if sizes[ring.ids[small]] >= minSize || sizes[ring.ids[large]] <= minSize {
+			break
+		}
+		candidates := [][32]byte{}
+		for _, pub := range pubs {
+			if ring.committee(positions[pub]) == ring.ids[large] {
+				candidates = append(candidates, pub)
+			}
+		}
+		pub := candidates[source.Intn(len(candidates))]
+		move(pub, uint64(small)*span+uint64(randomInt64(source))%span)
+	}
+
+	next := new(ReconfigurationBlock)
+	next.init()
+	for _, id := range ring.ids {
+		c := new(Committee)
+		c.init(id)
+		next.Committees[id] = c
+	}
+	moved := 0
+	next.Positions = make(map[[32]byte]uint64)
+	for _, pub := range pubs {
+		id := ring.committee(positions[pub])
+		m := members[pub]
+		next.Committees[id].addMember(&CommitteeMember{m.Pub, m.IP})
+		// positions of nodes that left are dropped
+		next.Positions[pub] = positions[pub]
+		if _, ok := prev.Committees[id].Members[pub]; !ok {
+			moved++
+		}
+	}
+	next.Randomness = rnd
+	next.Weights = prev.Weights
+	next.Blacklist = prev.Blacklist
+	next.EpochLength = prev.EpochLength
+	return next, moved
+}
+
+func (rb *ReconfigurationBlock) committeeOf(pub [32]byte) *Committee {
+	for _, c := range rb.Committees {
+		if _, ok := c.Members[pub]; ok {
+			return c
+		}
+	}
+	return nil
+}
@@ -5,10 +5,10 @@ import (
 	"encoding/binary"
 	"encoding/gob"
 	"fmt"
-	"log"
 	"math/big"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -18,8 +18,14 @@ import (
 // only data structures that are common in multiple, disjoint files, should belong here
 
 type Node_InitialMessageToCoordinator struct {
-	Pub  *PubKey
-	Port int
+	Pub              *PubKey
+	Port             int
+	Host             string // -advertise, the host of the connection if it is ""
+	PowNonce         uint64
+	ChallengeRequest bool // only asks for the proof of work puzzle
+	SeedRequest      bool // only asks for the run seed, see key-derivation.go
+	ClockRequest     bool // only asks for the time, see clock-sync.go
+	Build            Build
 }
 
 type SelfInfo struct {
@@ -43,14 +49,18 @@ type PlaceHolder struct {
 
 type ResponseToNodes struct {
 	Nodes                []NodeAllInfo
-	GensisisBlocks       []*FinalBlock
+	GensisisBlocks       []*FinalBlock         // empty with -genesisGossip
+	GenesisHashes        map[[32]byte][32]byte // CommitteeID -> GossipHash of genesis block
 	DebugNode            [32]byte
 	ReconfigurationBlock *ReconfigurationBlock
+	ElectionSeed         [32]byte // with -bootstrap election, see bootstrap-election.go
+	Refused              string   // why the coordinator refused the node, see build.go
 }
 
 type ByteArrayAndTimestamp struct {
-	B []byte
-	T time.Time
+	B     []byte
+	T     time.Time
+	Epoch uint // of the node that sent the stat, see epoch-stats.go
 }
 
 // Representation of a member beloning to the current committee of a node
@@ -100,6 +110,17 @@ func (c *Committee) getMemberIDsAsSortedList() [][32]byte {
 	return newList
 }
 
+// the members in the order of their ids, for the loops that send, pick or ask one after the other,
+// so a replay of a simulation does the same
+func (c *Committee) sortedMembers() []*CommitteeMember {
+	members := make([]*CommitteeMember, 0, len(c.Members))
+	for _, m := range c.Members {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return bytes.Compare(members[i].Pub.Bytes[:], members[j].Pub.Bytes[:]) < 0 })
+	return members
+}
+
 // Recived transactions that have not been included in a block yet
 type TxPool struct {
 	pool map[[32]byte]*Transaction // TxHash -> Transaction
@@ -113,6 +134,8 @@ func (t *TxPool) len() uint {
 }
 
 func (t *TxPool) init() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
 	t.pool = make(map[[32]byte]*Transaction)
 }
 
@@ -144,24 +167,28 @@ func (t *TxPool) get(txHash [32]byte) *Transaction {
 	return t.pool[txHash]
 }
 
-func (t *TxPool) getAll() []*Transaction {
-	t.mux.Lock()
-	defer t.mux.Unlock()
-	txes := make([]*Transaction, len(t.pool))
-	i := 0
+// the txs in the order of their hashes, so the blocks of a replay of a simulation are the same
+func (t *TxPool) _sorted() []*Transaction {
+	txes := make([]*Transaction, 0, len(t.pool))
 	for _, tx := range t.pool {
-		txes[i] = tx
-		i++
+		txes = append(txes, tx)
 	}
+	sort.Slice(txes, func(i, j int) bool { return bytes.Compare(txes[i].Hash[:], txes[j].Hash[:]) < 0 })
 	return txes
 }
 
+func (t *TxPool) getAll() []*Transaction {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t._sorted()
+}
+
 func (t *TxPool) getEnoughToFillblock(blockSize uint) []*Transaction {
 	t.mux.Lock()
 	defer t.mux.Unlock()
 	txes := []*Transaction{}
 	size := uint(0)
-	for _, tx := range t.pool {
+	for _, tx := range t._sorted() {
 		txes = append(txes, tx)
 		tmp := unsafe.Sizeof(*tx)
 		size += uint(tmp)
@@ -169,7 +196,7 @@ func (t *TxPool) getEnoughToFillblock(blockSize uint) []*Transaction {
 			break
 		}
 	}
-	log.Printf("Got %d bytes from txpool", size)
+	nodeLog.infof(nil, "Got %d bytes from txpool", size)
 	return txes
 }
 
@@ -194,12 +221,7 @@ func (t *TxPool) pop(txHash [32]byte) (*Transaction, bool) {
 }
 
 func (t *TxPool) _popAll() []*Transaction {
-	txes := make([]*Transaction, len(t.pool))
-	i := 0
-	for _, tx := range t.pool {
-		txes[i] = tx
-		i++
-	}
+	txes := t._sorted()
 	t.pool = make(map[[32]byte]*Transaction)
 	return txes
 }
@@ -235,6 +257,8 @@ type CrossTxPool struct {
 }
 
 func (ctp *CrossTxPool) init() {
+	ctp.mux.Lock()
+	defer ctp.mux.Unlock()
 	// ctp.set = make(map[[32]byte]map[[32]byte]CrossTxMap)
 	ctp.original = make(map[[32]byte]*Transaction)
 }
@@ -347,6 +371,12 @@ func (s *UTXOSet) String() string {
 }
 
 func (s *UTXOSet) init() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s._init()
+}
+
+func (s *UTXOSet) _init() {
 	s.set = make(map[[32]byte]map[uint]*OutTx)
 }
 
@@ -409,7 +439,7 @@ func (s *UTXOSet) verifyNonces() {
 		// fmt.Printf("Number of output in tx: %d", len(t))
 		for k, o := range t {
 			if k != o.N {
-				fmt.Printf("\nTxID: %s map nonce N %d and outtx N %d\n", bytesToString(o.PubKey.Bytes[:]), k, o.N)
+				nodeLog.debugf(nil, "TxID: %s map nonce N %d and outtx N %d", bytesToString(o.PubKey.Bytes[:]), k, o.N)
 				errFatal(nil, "nonces verifyNonces()")
 			}
 		}
@@ -483,8 +513,19 @@ func (s *UTXOSet) getOutputsToFillValue(value uint) ([]*txIDNonceTuple, bool) {
 	defer s.mux.Unlock()
 	res := []*txIDNonceTuple{}
 	var remV int = int(value)
+	// in the order of the tx ids and nonces, so a replay of a simulation spends the same outputs
+	txIDs := make([][32]byte, 0, len(s.set))
 	for txID := range s.set {
+		txIDs = append(txIDs, txID)
+	}
+	sort.Slice(txIDs, func(i, j int) bool { return bytes.Compare(txIDs[i][:], txIDs[j][:]) < 0 })
+	for _, txID := range txIDs {
+		nonces := make([]uint, 0, len(s.set[txID]))
 		for nonce := range s.set[txID] {
+			nonces = append(nonces, nonce)
+		}
+		sort.Slice(nonces, func(i, j int) bool { return nonces[i] < nonces[j] })
+		for _, nonce := range nonces {
 			v := s.set[txID][nonce].Value
 			remV -= int(v)
 			tnp := new(txIDNonceTuple)
@@ -532,6 +573,16 @@ func (o *OutTx) String() string {
 	return fmt.Sprintf("[OutTx] Value: %d, N: %d, PubKey: %s", o.Value, o.N, bytes32ToString(o.PubKey.Bytes))
 }
 
+// writes the same bytes as bytes() without allocating
+func (o *OutTx) writeBytes(buf *bytes.Buffer) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(o.Value))
+	buf.Write(b[:])
+	binary.LittleEndian.PutUint64(b[:], uint64(o.N))
+	buf.Write(b[:])
+	buf.Write(o.PubKey.Bytes[:])
+}
+
 func (o *OutTx) bytes() []byte {
 	b1 := make([]byte, 8) //uint64 is 8 bytes
 	binary.LittleEndian.PutUint64(b1, uint64(o.Value))
@@ -549,6 +600,14 @@ func (iTx *InTx) bytesExceptSig() []byte {
 	return byteSliceAppend(iTx.TxHash[:], uintToByte(iTx.N))
 }
 
+// writes the same bytes as bytesExceptSig() without allocating
+func (iTx *InTx) writeBytesExceptSig(buf *bytes.Buffer) {
+	var b [8]byte
+	buf.Write(iTx.TxHash[:])
+	binary.LittleEndian.PutUint64(b[:], uint64(iTx.N))
+	buf.Write(b[:])
+}
+
 func (iTx *InTx) getHash(extra [32]byte) [32]byte {
 	return hash(byteSliceAppend(iTx.bytesExceptSig(), extra[:]))
 }
@@ -576,6 +635,15 @@ type Transaction struct {
 	Inputs           []*InTx
 	Outputs          []*OutTx
 	ProofOfConsensus *ProofOfConsensus
+	Class            string       // class set by tx generator, not hashed
+	Trace            TraceContext // trace of the tx with -traceCollector, not hashed
+
+	// cached closestCommittee, a closestLookup, not encoded. The nodes of a process share the tx, so
+	// it is kept for the committee list it was looked up in
+	closest atomic.Value
+
+	// when the tx was added to the pool of this node, not encoded
+	pooled time.Time
 }
 
 func (t *Transaction) String() string {
@@ -599,11 +667,11 @@ func (t *Transaction) whatAmI(nodeCtx *NodeCtx) string {
 		return "crosstx"
 	} else if t.Hash == [32]byte{} && t.OrigTxHash != [32]byte{} && t.Outputs != nil {
 		return "originaltx"
-	} else if t.Hash != [32]byte{} && t.OrigTxHash != [32]byte{} && txFindClosestCommittee(nodeCtx, t.OrigTxHash) != nodeCtx.self.CommitteeID {
+	} else if t.Hash != [32]byte{} && t.OrigTxHash != [32]byte{} && t.closestCommittee(nodeCtx, t.OrigTxHash) != nodeCtx.committeeID() {
 		return "crosstxresponse_C_in"
 	} else if t.Hash != [32]byte{} && t.OrigTxHash != [32]byte{} && t.ProofOfConsensus != nil {
 		return "crosstxresponse_C_out"
-	} else if t.Hash != [32]byte{} && t.OrigTxHash != [32]byte{} && txFindClosestCommittee(nodeCtx, t.OrigTxHash) == nodeCtx.self.CommitteeID {
+	} else if t.Hash != [32]byte{} && t.OrigTxHash != [32]byte{} && t.closestCommittee(nodeCtx, t.OrigTxHash) == nodeCtx.committeeID() {
 		return "finaltransaction"
 	} else {
 		errFatal(nil, "unknown transaction type?")
@@ -612,14 +680,48 @@ func (t *Transaction) whatAmI(nodeCtx *NodeCtx) string {
 }
 
 func (t *Transaction) calculateHash() [32]byte {
-	b := []byte{}
+	buf := getBuffer()
+	defer putBuffer(buf)
 	for i := range t.Inputs {
-		b = append(b, t.Inputs[i].bytesExceptSig()...)
+		t.Inputs[i].writeBytesExceptSig(buf)
 	}
 	for i := range t.Outputs {
-		b = append(b, t.Outputs[i].bytes()...)
+		t.Outputs[i].writeBytes(buf)
+	}
+	buf.Write(t.OrigTxHash[:])
+	return hash(buf.Bytes())
+}
+
+// closest committee to h, cached on the transaction since the same lookup is done
+// for routing, whatAmI and validation of the same tx
+func (t *Transaction) closestCommittee(nodeCtx *NodeCtx, h [32]byte) [32]byte {
+	// read before the list, a list set in between is looked up again the next time
+	gen := nodeCtx.getCommitteeListGen()
+	if l, ok := t.closest.Load().(closestLookup); ok && h != [32]byte{} && l.h == h && l.gen == gen {
+		return l.committee
 	}
-	return hash(byteSliceAppend(b, t.OrigTxHash[:]))
+	c := txFindClosestCommittee(nodeCtx, h)
+	t.closest.Store(closestLookup{h, gen, c})
+	return c
+}
+
+// the closest committee of h in the committee list gen, stored as one value so a reader never sees
+// the committee of another h or list
+type closestLookup struct {
+	h         [32]byte
+	gen       uint64
+	committee [32]byte
+}
+
+// the committee a tx is routed to and gossiped in: of its hash for a new tx, of its inputs for a
+// cross-tx and of the original tx for a cross-tx-response
+func (t *Transaction) gossipCommittee(nodeCtx *NodeCtx) [32]byte {
+	if t.Hash != [32]byte{} && t.OrigTxHash == [32]byte{} {
+		return t.closestCommittee(nodeCtx, t.Hash)
+	} else if t.Hash == [32]byte{} && t.Outputs == nil && len(t.Inputs) > 0 {
+		return t.closestCommittee(nodeCtx, t.Inputs[0].TxHash)
+	}
+	return t.closestCommittee(nodeCtx, t.OrigTxHash)
 }
 
 // since Hash or OrigTxHash can be nil, we need an identifier for internal functions that will
@@ -657,11 +759,14 @@ func (t *Transaction) signInputs(priv *PrivKey) {
 }
 
 func (t *Transaction) encode() []byte {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
+	buf := getBuffer()
+	defer putBuffer(buf)
+	enc := gob.NewEncoder(buf)
 	err := enc.Encode(t)
 	ifErrFatal(err, "transaction encode")
-	return buf.Bytes()
+	b := make([]byte, buf.Len())
+	copy(b, buf.Bytes())
+	return b
 }
 
 func (t *Transaction) decode(b []byte) {
@@ -704,11 +809,10 @@ func (b *ProposedBlock) calculateHash() [32]byte {
 }
 
 func (b *ProposedBlock) calculateHashExceptMerkleRoot() [32]byte {
-	pgb := b.PreviousGossipHash[:]
-	i := uintToByte(b.Iteration)
-	c := b.CommitteeID[:]
-	lp := getBytes(b.LeaderPub)
-	return hash(byteSliceAppend(pgb, i, c, lp))
+	buf := getBuffer()
+	defer putBuffer(buf)
+	b.writeCanonicalHeader(canonicalWriter{buf})
+	return hash(buf.Bytes())
 }
 
 func (b *ProposedBlock) calculateHashOfMerkleRoot() [32]byte {
@@ -755,6 +859,8 @@ func (b *ProposedBlock) decode(bArr []byte) {
 type FinalBlock struct {
 	ProposedBlock *ProposedBlock
 	Signatures    []*ConsensusMsg
+	Certificate   *BlsCertificate // aggregate of the accept signatures with -blsAggregate, replaces them when pruned
+	Pruned        bool            // transaction bodies have been discarded, header and signatures are kept
 }
 
 // processes the final block by changing the UTXO set and remove transaction from tx pool
@@ -871,6 +977,31 @@ func (b *FinalBlock) processBlock(nodeCtx *NodeCtx) {
 	// fmt.Println(nodeCtx.utxoSet)
 
 	nodeCtx.utxoSet.mux.Unlock()
+
+	afterBlockProcessed(nodeCtx, b)
+}
+
+// updates node state that depends on committed transactions, after the UTXO set has been updated
+func afterBlockProcessed(nodeCtx *NodeCtx, b *FinalBlock) {
+	// committed txes are no longer pending for their sender
+	nodeCtx.admission.processBlock(b.ProposedBlock.Transactions)
+	if stats, changed := nodeCtx.admission.statsIfChanged(); changed {
+		spawnDialAndSendToCoordinator("admission_stats", bytes32ToString(nodeCtx.self.Priv.Pub.Bytes)+","+stats)
+	}
+
+	// parents in this block may release orphans
+	releaseOrphans(nodeCtx, b)
+
+	if nodeCtx.blockchain.store != nil {
+		if nodeCtx.amILeader() {
+			raw, stored := nodeCtx.blockchain.store.compressionStats()
+			reportCompression(nodeCtx, "store", raw, stored)
+		}
+		if stats, changed := nodeCtx.blockchain.store.cache.statsIfChanged(); changed {
+			spawnDialAndSendToCoordinator("block_cache", bytes32ToString(nodeCtx.self.Priv.Pub.Bytes)+","+stats)
+		}
+	}
+	reportSigCache(nodeCtx)
 }
 
 // forces the processing of a block without checking for valid UTXOs. (This is valid only if signature set is valid)
@@ -945,13 +1076,18 @@ func (b *FinalBlock) forceProcessBlock(nodeCtx *NodeCtx) {
 	// fmt.Println(nodeCtx.utxoSet)
 
 	nodeCtx.utxoSet.mux.Unlock()
+
+	afterBlockProcessed(nodeCtx, b)
 }
 
 type ConsensusMsg struct {
 	GossipHash [32]byte
 	Tag        string // propose, echo, accept or pending
 	Pub        *PubKey
-	Sig        *Sig // Sig of the hash of the above
+	Sig        *Sig      // Sig of the hash of the above
+	Vrf        *VrfClaim // leader proof of a propose with -vrf, not signed since the proof verifies itself
+	Mac        []byte    // with -mac instead of Sig for proposes and echos, see mac.go
+	MacEpoch   uint      // epoch of the mac key
 }
 
 func (cMsg *ConsensusMsg) String() string {
@@ -973,6 +1109,8 @@ type ConsensusMsgs struct {
 }
 
 func (cMsgs *ConsensusMsgs) init() {
+	cMsgs.mux.Lock()
+	defer cMsgs.mux.Unlock()
 	cMsgs.m = make(map[[32]byte]map[[32]byte]*ConsensusMsg)
 }
 
@@ -1070,14 +1208,14 @@ func (cMsgs *ConsensusMsgs) pop(gh [32]byte) []*ConsensusMsg {
 // Counts valid votes
 // Votes are valid if the iteration is I and Tag is echo or accepted
 // Votes are valid if the iteration is below I and Tag is accepted
-func (cMsgs *ConsensusMsgs) countValidVotes(gh [32]byte) int {
+func (cMsgs *ConsensusMsgs) countValidVotes(gh [32]byte, w Weights) int {
 	cMsgs.mux.Lock()
 	defer cMsgs.mux.Unlock()
 
 	votes := 0
 	for _, v := range cMsgs.m[gh] {
 		if v.Tag == "echo" || v.Tag == "accept" {
-			votes++
+			votes += w.of(v.Pub.Bytes)
 		}
 	}
 	return votes
@@ -1085,14 +1223,22 @@ func (cMsgs *ConsensusMsgs) countValidVotes(gh [32]byte) int {
 
 // Counts valid accepts
 // Votes are valid if the Tag is accepted
-func (cMsgs *ConsensusMsgs) countValidAccepts(gh [32]byte) int {
+// accepts with a valid signature, verified on workers goroutines, counted with their weight
+func (cMsgs *ConsensusMsgs) countValidAccepts(gh [32]byte, workers uint, w Weights) int {
 	cMsgs.mux.Lock()
-	defer cMsgs.mux.Unlock()
-
-	votes := 0
+	accepts := []*ConsensusMsg{}
 	for _, v := range cMsgs.m[gh] {
 		if v.Tag == "accept" {
-			votes++
+			accepts = append(accepts, v)
+		}
+	}
+	cMsgs.mux.Unlock()
+
+	_, valid := VerifyBatch(consensusMsgItems(accepts), workers, false)
+	votes := 0
+	for i, ok := range valid {
+		if ok {
+			votes += w.of(accepts[i].Pub.Bytes)
 		}
 	}
 	return votes
@@ -1108,9 +1254,15 @@ func (cMsgs *ConsensusMsgs) getLen(gh [32]byte) uint {
 
 // Todo define, extend and create reconfiguration block
 type ReconfigurationBlock struct {
-	Hash       [32]byte
-	Committees map[[32]byte]*Committee
-	Randomness [32]byte
+	Hash           [32]byte
+	Committees     map[[32]byte]*Committee
+	Randomness     [32]byte
+	Positions      map[[32]byte]uint64   // Pub.Bytes -> position on the ring of the cuckoo rule, see cuckoo.go
+	StartIteration uint                  // first iteration of the epoch
+	Departed       map[[32]byte][32]byte // Pub.Bytes -> committee of the nodes that left during the epoch, not part of the hash
+	Weights        Weights               // voting weights, see weights.go
+	Blacklist      map[[32]byte]uint     // Pub.Bytes -> iteration of its equivocation, see blacklist.go
+	EpochLength    uint                  // iterations until the next epoch, see epoch-trigger.go
 }
 
 func (rb *ReconfigurationBlock) init() {
@@ -1119,13 +1271,46 @@ func (rb *ReconfigurationBlock) init() {
 
 func (rb *ReconfigurationBlock) calculateHash() [32]byte {
 	// calculate hash of all committee ids, all comittee members public key and randomness
-	var toHash []byte = rb.Randomness[:]
-	for _, committee := range rb.Committees {
+	// in sorted order, so every node gets the same hash. Positions are only set after the first epoch,
+	// weights only with -weights, the blacklist after the first equivocation and the epoch length
+	// in blocks of the epochs
+	start := make([]byte, 8)
+	binary.LittleEndian.PutUint64(start, uint64(rb.StartIteration))
+	var toHash []byte = byteSliceAppend(rb.Randomness[:], start)
+	if rb.EpochLength > 0 {
+		toHash = append(toHash, uintToByte(rb.EpochLength)...)
+	}
+	ids := [][32]byte{}
+	for id := range rb.Committees {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+	for _, id := range ids {
+		committee := rb.Committees[id]
 		toHash = append(toHash, committee.ID[:]...)
-		for _, member := range committee.Members {
-			toHash = append(toHash, member.Pub.Bytes[:]...)
+		for _, member := range committee.getMemberIDsAsSortedList() {
+			toHash = append(toHash, member[:]...)
+			if pos, ok := rb.Positions[member]; ok {
+				p := make([]byte, 8)
+				binary.LittleEndian.PutUint64(p, pos)
+				toHash = append(toHash, p...)
+			}
+			if weight, ok := rb.Weights[member]; ok {
+				w := make([]byte, 8)
+				binary.LittleEndian.PutUint64(w, weight)
+				toHash = append(toHash, w...)
+			}
 		}
 	}
+	blacklisted := [][32]byte{}
+	for pub := range rb.Blacklist {
+		blacklisted = append(blacklisted, pub)
+	}
+	sort.Slice(blacklisted, func(i, j int) bool { return bytes.Compare(blacklisted[i][:], blacklisted[j][:]) < 0 })
+	for _, pub := range blacklisted {
+		toHash = append(toHash, pub[:]...)
+		toHash = append(toHash, uintToByte(rb.Blacklist[pub])...)
+	}
 	return hash(toHash)
 }
 
@@ -1139,6 +1324,9 @@ type Blockchain struct {
 	LatestBlock           [32]byte                    // GossipHash
 	ProposedBlocks        map[[32]byte]*ProposedBlock // GossipHash -> ProposedBlock
 	ReconfigurationBlocks []*ReconfigurationBlock
+	store                 *BlockStore // nil if blocks are only kept in memory
+	maxInMemory           uint        // committed blocks kept in memory when store is used
+	retention             uint        // blocks with transaction bodies, 0 if pruning is off
 	mux                   sync.Mutex
 }
 
@@ -1147,8 +1335,35 @@ func (b *Blockchain) init(committeeID [32]byte) {
 	defer b.mux.Unlock()
 	b.CommitteeID = committeeID
 	b.Blocks = make(map[[32]byte]*FinalBlock)
+	b.LatestBlock = [32]byte{}
 	b.ProposedBlocks = make(map[[32]byte]*ProposedBlock)
 	b.ReconfigurationBlocks = []*ReconfigurationBlock{}
+	b.store = nil
+	b.maxInMemory = 0
+	b.retention = 0
+}
+
+// persists every added block to the store at path, and only keeps the last maxInMemory blocks in memory.
+// The last cacheSize blocks read from the store are cached
+func (b *Blockchain) openStore(path string, maxInMemory uint, compress bool, cacheSize uint) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.store = new(BlockStore)
+	b.store.cache.init(cacheSize)
+	ifErrFatal(b.store.open(path), "opening block store")
+	b.store.compress = compress
+	b.maxInMemory = maxInMemory
+}
+
+// syncs and closes the store before the process exits
+func (b *Blockchain) closeStore() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.store == nil {
+		return
+	}
+	b.store.sync()
+	b.store.close()
 }
 
 func (b *Blockchain) _getLatest() *FinalBlock {
@@ -1167,7 +1382,14 @@ func (b *Blockchain) getByIteration(iteration uint64) *FinalBlock {
 
 	currentBlock := b.LatestBlock
 	for {
-		block := b.Blocks[currentBlock]
+		block, ok := b.Blocks[currentBlock]
+		if !ok {
+			// block has been evicted from memory
+			if b.store == nil {
+				errFatal(nil, "block not in blockchain")
+			}
+			return b.store.getByMaxHeight(iteration)
+		}
 		if uint64(block.ProposedBlock.Iteration) <= iteration {
 			return block
 		} else if block.ProposedBlock.PreviousGossipHash == [32]byte{} {
@@ -1182,6 +1404,27 @@ func (b *Blockchain) getByIteration(iteration uint64) *FinalBlock {
 func (b *Blockchain) _add(block *FinalBlock) {
 	b.Blocks[block.ProposedBlock.GossipHash] = block
 	b.LatestBlock = block.ProposedBlock.GossipHash
+	if b.store != nil {
+		b.store.append(block)
+		b._evict()
+	}
+	if b.retention > 0 {
+		b._prune()
+		b._pruneStore()
+	}
+}
+
+// removes blocks older than maxInMemory iterations from memory, they are still in the store
+func (b *Blockchain) _evict() {
+	latest := b._getLatest().ProposedBlock.Iteration
+	if latest <= b.maxInMemory {
+		return
+	}
+	for gh, block := range b.Blocks {
+		if block.ProposedBlock.Iteration < latest-b.maxInMemory {
+			delete(b.Blocks, gh)
+		}
+	}
 }
 func (b *Blockchain) add(block *FinalBlock) {
 	b.mux.Lock()
@@ -1210,6 +1453,9 @@ func (b *Blockchain) safeAdd(block *FinalBlock) bool {
 
 func (b *Blockchain) _isBlock(gh [32]byte) bool {
 	_, ok := b.Blocks[gh]
+	if !ok && b.store != nil {
+		return b.store.has(gh)
+	}
 	return ok
 }
 
@@ -1277,6 +1523,15 @@ func (b *Blockchain) safeAddProposedBlock(block *ProposedBlock) bool {
 
 func (b *Blockchain) _addRecBlock(block *ReconfigurationBlock) {
 	b.ReconfigurationBlocks = append(b.ReconfigurationBlocks, block)
+	if b.store != nil {
+		// blocks of the epochs are used from their start iteration, also when a node that joins
+		// the committee adds them later
+		from := block.StartIteration
+		if latest := b._getLatest(); latest != nil && from == 0 {
+			from = latest.ProposedBlock.Iteration + 1
+		}
+		b.store.appendRecBlock(from, block)
+	}
 }
 
 func (b *Blockchain) addRecBlock(block *ReconfigurationBlock) {
@@ -1300,12 +1555,95 @@ func (b *Blockchain) _getLastReconfigurationBlock() *ReconfigurationBlock {
 	return b.ReconfigurationBlocks[len(b.ReconfigurationBlocks)-1]
 }
 
+// replaces the reconfiguration blocks, for a node that joins with the blocks of its committee
+func (b *Blockchain) setReconfigurationBlocks(blocks []*ReconfigurationBlock) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.ReconfigurationBlocks = []*ReconfigurationBlock{}
+	for _, rb := range blocks {
+		b._addRecBlock(rb)
+	}
+}
+
+// adds a node that joined during the epoch to its committee in the last reconfiguration block
+func (b *Blockchain) addJoinedMember(committeeID [32]byte, m *CommitteeMember) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	last := len(b.ReconfigurationBlocks) - 1
+	b.ReconfigurationBlocks[last] = withMember(b.ReconfigurationBlocks[last], committeeID, m)
+}
+
+// removes a node that left from its committee in the last reconfiguration block
+func (b *Blockchain) removeLeftMember(committeeID [32]byte, pub [32]byte) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	last := len(b.ReconfigurationBlocks) - 1
+	next := withChangedCommittee(b.ReconfigurationBlocks[last], committeeID, func(c *Committee) { delete(c.Members, pub) })
+	// the signatures it made before it left stay valid
+	next.Departed = make(map[[32]byte][32]byte)
+	for p, id := range b.ReconfigurationBlocks[last].Departed {
+		next.Departed[p] = id
+	}
+	next.Departed[pub] = committeeID
+	b.ReconfigurationBlocks[last] = next
+}
+
+// if pub is a member of the committee in the last epoch or the one before, other committees can
+// still be in the epoch before
+func (b *Blockchain) _inRecentCommittee(committeeID [32]byte, pub [32]byte) bool {
+	for i := len(b.ReconfigurationBlocks) - 1; i >= 0 && i >= len(b.ReconfigurationBlocks)-2; i-- {
+		if c, ok := b.ReconfigurationBlocks[i].Committees[committeeID]; ok {
+			if _, ok := c.Members[pub]; ok {
+				return true
+			}
+		}
+		if id, ok := b.ReconfigurationBlocks[i].Departed[pub]; ok && id == committeeID {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *Blockchain) getLastReconfigurationBlock() *ReconfigurationBlock {
 	b.mux.Lock()
 	defer b.mux.Unlock()
 	return b._getLastReconfigurationBlock()
 }
 
+// the reconfiguration block from the coordinator is epoch 0
+func (b *Blockchain) epoch() uint {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return uint(len(b.ReconfigurationBlocks) - 1)
+}
+
+// the reconfiguration block of the epoch iteration is in
+func (b *Blockchain) reconfigurationBlockAt(iteration uint) *ReconfigurationBlock {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for i := len(b.ReconfigurationBlocks) - 1; i > 0; i-- {
+		if b.ReconfigurationBlocks[i].StartIteration <= iteration {
+			return b.ReconfigurationBlocks[i]
+		}
+	}
+	return b.ReconfigurationBlocks[0]
+}
+
+func (b *Blockchain) getReconfigurationBlocks() []*ReconfigurationBlock {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return append([]*ReconfigurationBlock{}, b.ReconfigurationBlocks...)
+}
+
+func (b *Blockchain) getReconfigurationBlock(epoch uint) *ReconfigurationBlock {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if epoch >= uint(len(b.ReconfigurationBlocks)) {
+		return nil
+	}
+	return b.ReconfigurationBlocks[epoch]
+}
+
 // routing table
 type RoutingTable struct {
 	l   []Committee // Your known commites, sorted by distance
@@ -1331,10 +1669,15 @@ func (r *RoutingTable) addMember(i uint, cm *CommitteeMember) {
 	r.mux.Unlock()
 }
 
+// without the committees at the end that are not set, with fewer committees than its length
 func (r *RoutingTable) get() []Committee {
 	r.mux.Lock()
 	defer r.mux.Unlock()
-	return r.l
+	l := r.l
+	for len(l) > 0 && l[len(l)-1].Members == nil {
+		l = l[:len(l)-1]
+	}
+	return l
 }
 
 type KademliaFindNodeMsg struct {
@@ -1343,6 +1686,7 @@ type KademliaFindNodeMsg struct {
 
 type KademliaFindNodeResponse struct {
 	Committee Committee
+	Proof     MembershipProof // of the node that answers, on the committee
 }
 
 type IdaMsgs struct {
@@ -1520,11 +1864,13 @@ func (b *ReconstructedIdaMsgs) popData(root [32]byte) []byte {
 }
 
 type Channels struct {
-	echoChan chan bool
+	echoChan    chan bool
+	genesisChan *Queue
 }
 
 func (c *Channels) init(l int) {
 	c.echoChan = make(chan bool, l)
+	c.genesisChan = newQueue(1)
 }
 
 type CurrentIteration struct {
@@ -1558,17 +1904,127 @@ type NodeCtx struct {
 	i                    CurrentIteration
 	routingTable         RoutingTable
 	committeeList        [][32]byte //list of all committee ids, to be replaced with reference block?
+	committeeListGen     uint64     // committeeListGens when committeeList was set, the key of the closest committees of the txs
 	txPool               TxPool
+	orphanPool           OrphanPool
+	admission            AdmissionControl
+	wal                  ConsensusWAL
+	vrfClaims            VrfClaims
+	drg                  DrgState
+	macKeys              MacKeys
+	fastSync             bool // join by state sync instead of the genesis block
+	genesisGossip        bool // genesis block is recived by ida gossip
+	genesisHash          [32]byte
+	genesisHashes        map[[32]byte][32]byte // committee id -> genesis hash, for committees joined in later epochs
+	join                 *JoinReport           // nil if the node took part in the setup
+	departures           Departures
+	reconfigurations     Reconfigurations
+	instance             *Instance // the instance of the node process, restarted after a kill
+	stopped              Stopped
+	membershipTrees      MembershipTrees
+	equivocations        Equivocations
+	epochClock           EpochClock
+	metrics              *Metrics // nil without -metricsPort, see metrics.go
+	latencies            LatencyStats
+	events               EventBus // instrumentation of the protocol, see events.go
+	wire                 WireStats
+	blockPhases          PhaseMarks      // consensus phases of the blocks, see block-phases.go
+	tracer               *Tracer         // nil without -traceCollector, see tracing.go
+	recorder             *FlightRecorder // nil without -flightRecorder, see flight-recorder.go
+	faults               FaultInjector   // see faults.go
+	tuning               NodeTuning      // parameters changed during the run, see tuning.go
+	chaos                *Chaos          // nil without -chaos, see chaos.go
+	byzantine            *Byzantine      // nil for an honest node, see byzantine.go
 	crossTxPool          CrossTxPool
 	utxoSet              *UTXOSet
 	blockchain           Blockchain
+	stateMux             sync.Mutex // self.CommitteeID, committee, neighbors and committeeList, set while every goroutine reads them
 }
 
-func (nc *NodeCtx) amILeader() bool {
-	if nc.self.Priv.Pub.Bytes == nc.committee.CurrentLeader.Bytes {
-		return true
+// the committee of the node, an epoch switch can change it
+func (nc *NodeCtx) committeeID() [32]byte {
+	nc.stateMux.Lock()
+	defer nc.stateMux.Unlock()
+	return nc.self.CommitteeID
+}
+
+func (nc *NodeCtx) setCommitteeID(id [32]byte) {
+	nc.stateMux.Lock()
+	defer nc.stateMux.Unlock()
+	nc.self.CommitteeID = id
+}
+
+// the leader of the iteration, nil in a committee this node just moved to
+func (nc *NodeCtx) currentLeader() *PubKey {
+	nc.stateMux.Lock()
+	defer nc.stateMux.Unlock()
+	return nc.committee.CurrentLeader
+}
+
+func (nc *NodeCtx) setCurrentLeader(pub *PubKey) {
+	nc.stateMux.Lock()
+	defer nc.stateMux.Unlock()
+	nc.committee.CurrentLeader = pub
+}
+
+func (nc *NodeCtx) setCommittee(c Committee) {
+	nc.stateMux.Lock()
+	defer nc.stateMux.Unlock()
+	nc.committee = c
+}
+
+// the members of the committee after a node joined or left it, the leader stays
+func (nc *NodeCtx) setMembers(members map[[32]byte]*CommitteeMember) {
+	nc.stateMux.Lock()
+	defer nc.stateMux.Unlock()
+	nc.committee.Members = members
+}
+
+func (nc *NodeCtx) setNeighbors(neighbors [][32]byte) {
+	nc.stateMux.Lock()
+	defer nc.stateMux.Unlock()
+	nc.neighbors = neighbors
+}
+
+// the addresses of the neighbors in the committee, for ida gossip
+func (nc *NodeCtx) neighborAddrs() []string {
+	nc.stateMux.Lock()
+	defer nc.stateMux.Unlock()
+	addrs := make([]string, 0, len(nc.neighbors))
+	for _, n := range nc.neighbors {
+		if m, ok := nc.committee.Members[n]; ok {
+			addrs = append(addrs, m.IP)
+		}
 	}
-	return false
+	return addrs
+}
+
+// the lists set so far by the nodes of the process, which share the txs
+var committeeListGens uint64
+
+func (nc *NodeCtx) setCommitteeList(list [][32]byte) {
+	nc.stateMux.Lock()
+	defer nc.stateMux.Unlock()
+	nc.committeeList = list
+	nc.committeeListGen = atomic.AddUint64(&committeeListGens, 1)
+}
+
+func (nc *NodeCtx) getCommitteeListGen() uint64 {
+	nc.stateMux.Lock()
+	defer nc.stateMux.Unlock()
+	return nc.committeeListGen
+}
+
+// the ids of all committees, this one first
+func (nc *NodeCtx) getCommitteeList() [][32]byte {
+	nc.stateMux.Lock()
+	defer nc.stateMux.Unlock()
+	return nc.committeeList
+}
+
+func (nc *NodeCtx) amILeader() bool {
+	leader := nc.currentLeader()
+	return leader != nil && nc.self.Priv.Pub.Bytes == leader.Bytes
 }
 
 // generic msg. typ indicates which struct to decode msg to.
@@ -30,8 +30,212 @@ const coord_aws string = "172.31.38.0"
 const coord_gcloud string = "10.128.0.3"
 const coord_local string = "127.0.0.1"
 
-// defalt ip port
-const default_ip_ports = 9000
+// tps ramp mode
+const default_rampStep uint = default_m
+const default_rampInterval uint = 60   // s
+const default_rampLatency uint = 60000 // ms
+
+// transaction classes
+const default_largeTxInputs = 4
+const default_hotAccountDivisor uint = 20 // nUsers/x users are hot accounts
+
+// orphan transactions
+const default_maxOrphans uint = 1000
+const default_orphanMaxAge uint = 20 // iterations
+
+// mempool admission policies
+const default_senderRate uint = 5
+const default_minFee uint = 0
+const default_maxPendingPerUser uint = 10
+
+// block store
+const default_blocksInMemory uint = 100
+const default_blockCache uint = 256
+
+// verified signatures and parsed keys cached per process
+const default_sigCache uint = 4096
+
+// block range requests
+const default_maxBlocksPerRequest = 64
+const default_maxRequestBlocksBytes = 16 << 20
+
+// consensus wal, accepted blocks between compactions
+const default_walCompactEvery uint = 100
+
+// iterations per block of the global ledger
+const default_ledgerEpoch uint = 10
+
+// genesis ida gossip, in deltas
+const default_genesisTimeout = 10
+
+// drg phases, in deltas
+const default_drgTimeout = 20
+
+// messages with a membership proof of the next epoch that wait for its reconfiguration block at once
+const default_membershipWaits = 64
+
+// state sync
+const default_fastSyncAttempts = 10
+
+// epochs, fraction of nodes that rejoin every epoch and regions of a committee on the cuckoo ring
+const default_churn = 0.1
+const default_cuckooRegions = 4
+
+// epoch triggers, bounds of the iterations of an epoch, seconds of an epoch with -epochTrigger time
+// and fraction of the members that join or leave in an epoch with -epochTrigger churn
+const default_minEpochLength = 1
+const default_maxEpochLength = 1000
+const default_epochTime uint = 60
+const default_epochChurn = 0.1
+
+// identities in a group of the election network of -bootstrap election
+const default_electionGroupSize = 8
+
+// seconds the coordinator collects the committee views of an iteration before it compares them
+const default_viewCheckWait = 10
+
+// seconds of a cpu profile collected by the coordinator, and how much longer it waits for the answer
+const default_profileSeconds = 10
+const default_profileTimeout = 10
+
+// seconds between the latency histograms of a node with -latencyStats histograms
+const default_latencyInterval = 10
+
+// fraction of the txs traced with -traceCollector, seconds between the exports of the spans, seconds
+// an export may take and spans kept until the next export
+const default_traceSample = 0.01
+const default_traceFlush = 5
+const default_traceTimeout = 5
+const default_traceBuffer = 10000
+
+// seconds between the progress summaries of the coordinator, and seconds a node is alive in them
+// after its last stat
+const default_progressInterval uint = 10
+const default_progressAlive = 60
+
+// seconds between the wire counts of a node with -wireStats
+const default_wireInterval = 10
+
+// deltas without a final block and fraction of silent nodes after which the coordinator aborts the run
+const default_abortStuck uint = 0
+const default_abortSilent = 0.0
+
+// events in the flight recorder of a node
+const default_flightRecorder uint = 0
+
+// seconds between the stats batches of a process, fraction of the nodes that send per-event stats,
+// fraction of the txs and gossips the coordinator keeps them of and the most it handles a second
+const default_statsBatch uint = 0
+const default_statsSample = 1.0
+const default_statsKeep = 1.0
+const default_statsRate uint = 0
+
+// exchanges with the coordinator per clock offset, and seconds between the offsets with -clockSync
+const default_clockSamples = 5
+const default_clockInterval = 60
+
+// link model of -function simulate, milliseconds, bytes per second, and seconds of the run
+const default_simLatency uint = 0
+const default_simJitter uint = 0
+const default_simBandwidth uint = 0
+const default_simTime uint = 0
+
+// seconds without a turn before the scheduler of -function simulate logs its goroutines
+const default_simStall = 30
+
+// milliseconds until a lost connection of -simLoss is sent again, and the most times it is lost
+const default_simRetransmit = 200
+const default_simMaxRetransmits = 6
+
+// seconds the integration test runs its cluster, and times it sums the coins again before the balances fail
+const default_integrationTime uint = 60
+const default_integrationRetries = 10
+
+// seconds between the exits of the nodes of -function supervise, and how many of them exit
+const default_recoverEvery uint = 0
+const default_recoverNodes uint = 1
+
+// seconds between the fault_hit reports of a fault on a node, and seconds after its last hit a
+// reaction of the protocol still counts for a fault
+const default_faultHitInterval = 5
+const default_faultWindow = 30
+
+// -chaos: milliseconds a message waits at most, the share held back this many times as long, and
+// the share of dropped messages
+const default_chaosDelay = 20
+const default_chaosReorder = 0.05
+const default_chaosHold = 5
+const default_chaosDrop = 0.002
+
+// differences -function tracediff prints before it only counts them
+const default_traceDiffs = 20
+const default_traceFields = "leader,txs,sigs"
+
+// seconds every point of -function sweep runs, and seconds a run gets to stop before it is killed
+const default_sweepTime uint = 60
+const default_sweepGrace = 30
+
+// times a peer is dialed again before a message to it is dropped, and ms before the first try again
+const default_dialRetries = 3
+const default_dialBackoff = 50
+
+// seconds a node process takes at most to shut down on a signal, and to send a message to the
+// coordinator while it does
+const default_shutdownTimeout = 10
+const default_shutdownDial = 2
+
+// seconds a node waits for the coordinator of -coordinator to come up
+const default_coordinatorWait = 120
+
+// seconds the coordinator of aws-launch runs, seconds more before the launcher gives up on it and
+// seconds between the polls of the states of the instances
+const default_awsRunTime uint = 600
+const default_awsGrace = 300
+const default_awsPoll = 15
+
+// seconds before the first restart of a node that panicked with -restarts
+const default_restartBackoff = 2
+
+// ms of -delta of rapidchain demo and seconds between its summaries
+const default_demoDelta = 1000
+const default_demoSummary = 30
+
+// the preflight checks, result files a process opens, MB a run needs under -resultsDir, the first
+// year a clock can be at and ms the clock of a node can be off the one of the coordinator
+const default_preflightFiles = 64
+const default_preflightDisk = 512
+const default_preflightYear = 2021
+const default_preflightSkew = 100
+
+// port the standby waits for its coordinator on, seconds without a heartbeat before it takes over and
+// stats the mirror queues for it
+const default_standbyPort = 8081
+const default_standbyTimeout = 5
+const default_standbyQueue = 4096
+
+// seconds the benchmarks of the bench modes take at least
+const default_benchTime = 1
+
+// -statsSecret, seconds the coordinator waits for the token of a connection and seconds a token can be
+// off its clock
+const default_statsAuthTimeout = 5
+const default_statsAuthWindow = 300
+
+// churn generator, seconds a killed node is down before it starts again
+const default_churnDowntime uint = 20
+
+// highest voting weight of a node with -weights
+const default_maxWeight uint = 100
+
+// epochs and trials of -function dryrun
+const default_dryRunEpochs uint = 100
+const default_dryRunTrials uint = 100
+
+// smallest committee that can run consensus and ida gossip
+const default_minCommitteeSize = 3
+
+// port of the first node of a process, 0 is a free port for every node
+const default_ip_ports = 0
 
 var coord string = coord_local
 
@@ -44,11 +248,108 @@ type FlagArgs struct {
 	totalF     uint
 	committeeF uint
 	// d          uint
-	B          uint
-	nUsers     uint
-	totalCoins uint
-	tps        uint
-	local      bool
-	delta      uint
-	portsBegin uint
+	B                 uint
+	nUsers            uint
+	totalCoins        uint
+	tps               uint
+	local             bool
+	delta             uint
+	portsBegin        uint
+	ramp              bool
+	rampStep          uint
+	rampInterval      uint
+	rampLatency       uint
+	maxOrphans        uint
+	receipts          bool
+	admission         string
+	senderRate        uint
+	minFee            uint
+	maxPendingPerUser uint
+	blockStore        string
+	blocksInMemory    uint
+	fastSyncNodes     uint
+	retention         uint
+	explorerPort      uint
+	genesisGossip     bool
+	compress          bool
+	blockCache        uint
+	keyfile           string
+	stableAssignment  bool
+	blsAggregate      bool
+	vrf               bool
+	drg               bool
+	powDifficulty     uint
+	mac               bool
+	runSeed           int64
+	deriveKeys        bool
+	epochLength       uint
+	churn             float64
+	join              bool
+	leaveAfter        uint
+	reference         string
+	churnRate         float64
+	churnDist         string
+	churnDowntime     uint
+	adversary         string
+	weights           string
+	maxWeight         uint
+	epochs            uint
+	trials            uint
+	equivocate        bool
+	epochTrigger      string
+	epochTime         uint
+	epochChurn        float64
+	bootstrap         string
+	viewCheck         uint
+	metricsPort       uint
+	pprofPort         uint
+	latencyStats      string
+	traceCollector    string
+	traceSample       float64
+	resourceInterval  uint
+	progress          uint
+	wireStats         bool
+	abortStuck        uint
+	abortSilent       float64
+	flightRecorder    uint
+	statsBatch        uint
+	statsSample       float64
+	statsKeep         float64
+	statsRate         uint
+	clockSync         bool
+	simLatency        uint
+	simJitter         uint
+	simBandwidth      uint
+	simTime           uint
+	faults            string
+	recoverEvery      uint
+	recoverNodes      uint
+	recoverExit       bool
+	recoverFrom       int64
+	chaos             bool
+	goldenTrace       string
+	runTrace          string
+	traceFields       string
+	byzantine         string
+	simLoss           float64
+	sweep             string
+	sweepRun          string
+	sweepTime         uint
+	sweepDir          string
+	coordinator       string
+	advertise         string
+	image             string
+	awsTemplate       string
+	awsRegion         string
+	awsBucket         string
+	awsRunTime        uint
+	awsStatsParameter string
+	resultsDir        string
+	runName           string
+	restarts          uint
+	preflight         bool
+	cpuSet            string
+	mixedBuilds       bool
+	standby           string
+	statsSecret       string
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// demo runs a small cluster in this process and narrates its blocks
+
+// the narration of the demo, nil when it does not run
+var narrator *DemoNarrator
+
+type DemoNarrator struct {
+	start  time.Time
+	blocks uint
+	txs    uint
+	names  map[[32]byte]int // the committees numbered by the order their first block came in
+	mux    sync.Mutex
+}
+
+func (d *DemoNarrator) init() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.start = clockNow()
+	d.names = make(map[[32]byte]int)
+}
+
+// the coordinator got a final block
+func (d *DemoNarrator) finalBlock(b *FinalBlock) {
+	if d == nil {
+		return
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	pb := b.ProposedBlock
+	if _, ok := d.names[pb.CommitteeID]; !ok {
+		d.names[pb.CommitteeID] = len(d.names) + 1
+	}
+	d.blocks++
+	d.txs += uint(len(pb.Transactions))
+	leader := "-"
+	if pb.LeaderPub != nil {
+		leader = shortID(pb.LeaderPub.Bytes)
+	}
+	fmt.Printf("%6s  committee %d (%s) finalized block %d: %d txs, led by %s, accepted by %d members\n",
+		clockSince(d.start).Round(time.Second), d.names[pb.CommitteeID], shortID(pb.CommitteeID), pb.Iteration, len(pb.Transactions), leader, b.signerCount())
+}
+
+func (d *DemoNarrator) summary() string {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	elapsed := clockSince(d.start)
+	if d.blocks == 0 {
+		return fmt.Sprintf("%6s  no final block yet, the committees are still being set up", elapsed.Round(time.Second))
+	}
+	return fmt.Sprintf("%6s  so far %d blocks in %d committees with %d txs, %.1f txs per second",
+		elapsed.Round(time.Second), d.blocks, len(d.names), d.txs, float64(d.txs)/elapsed.Seconds())
+}
+
+func demo(flagArgs *FlagArgs) {
+	given := givenFlags()
+	if !given["logLevel"] {
+		setLogLevels("warn", "")
+	}
+	if !given["delta"] {
+		flagArgs.delta = default_demoDelta
+	}
+	narrator = new(DemoNarrator)
+	narrator.init()
+	fmt.Printf("rapidchain demo: %d nodes in %d committees in this process, %d txs per second\n", flagArgs.n, flagArgs.m, flagArgs.tps)
+	fmt.Printf("the committees are set up first, then every committee finalizes a block of txs every few deltas of %s\n", time.Duration(flagArgs.delta)*time.Millisecond)
+	fmt.Printf("press Ctrl-C to end it, the results are written to %s/\n\n", resultsDir)
+
+	launchLocalCluster(flagArgs)
+	for {
+		clockSleep(default_demoSummary * time.Second)
+		fmt.Println(narrator.summary())
+	}
+}
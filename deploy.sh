@@ -1,3 +1,3 @@
-go build
+go build -ldflags "-X main.version=$(git describe --tags --always --dirty) -X main.gitCommit=$(git rev-parse HEAD)"
 gsutil cp rapidchain gs://rapidchain-bucket/
 
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// -drg, the epoch randomness by commit-reveal of the reference committee
+
+type DrgCommit struct {
+	Epoch  uint
+	Pub    *PubKey
+	Commit Commitment
+	Sig    *Sig
+}
+
+func (c *DrgCommit) calculateHash() [32]byte {
+	e := make([]byte, 8)
+	binary.LittleEndian.PutUint64(e, uint64(c.Epoch))
+	return hash(byteSliceAppend([]byte("drg"), e, c.Pub.Bytes[:], c.Commit[:]))
+}
+
+type DrgReveal struct {
+	Epoch  uint
+	Pub    [32]byte
+	Secret [32]byte
+}
+
+type DrgResult struct {
+	Epoch       uint
+	Commits     []DrgCommit
+	Reveals     []DrgReveal
+	Evidence    []EquivocationProof // of keys the next block blacklists, see blacklist.go
+	EpochLength uint                // of the next epoch, 0 without epochs, see epoch-trigger.go
+}
+
+const drgDomain = "drg"
+
+// the secret is the nonce of a commitment to epoch | pub
+func drgOpening(epoch uint, pub [32]byte, secret [32]byte) Opening {
+	e := make([]byte, 8)
+	binary.LittleEndian.PutUint64(e, uint64(epoch))
+	return Opening{byteSliceAppend(e, pub[:]), secret}
+}
+
+func drgCommitment(epoch uint, pub [32]byte, secret [32]byte) Commitment {
+	o := drgOpening(epoch, pub, secret)
+	return commitTo(drgDomain, o.Value, o.Nonce)
+}
+
+func (r *DrgResult) randomness() [32]byte {
+	reveals := append([]DrgReveal{}, r.Reveals...)
+	sort.Slice(reveals, func(i, j int) bool { return bytes.Compare(reveals[i].Pub[:], reveals[j].Pub[:]) < 0 })
+	e := make([]byte, 8)
+	binary.LittleEndian.PutUint64(e, uint64(r.Epoch))
+	all := e
+	for _, reveal := range reveals {
+		all = append(all, reveal.Secret[:]...)
+	}
+	return hash(all)
+}
+
+func referenceCommittee(rBlock *ReconfigurationBlock) *Committee {
+	var ref *Committee
+	for _, c := range rBlock.Committees {
+		if ref == nil || bytes.Compare(c.ID[:], ref.ID[:]) < 0 {
+			ref = c
+		}
+	}
+	return ref
+}
+
+func drgRequired(ref *Committee, nodeCtx *NodeCtx) int {
+	return len(ref.Members)/int(nodeCtx.flagArgs.committeeF) + 1
+}
+
+// state of the current epoch on a node
+type DrgState struct {
+	epoch      uint
+	secret     [32]byte
+	commits    map[[32]byte]DrgCommit // Pub.Bytes -> commit
+	commitSet  map[[32]byte]DrgCommit // fixed by the aggregator
+	revealed   bool
+	reveals    map[[32]byte]DrgReveal
+	resultChan *Queue
+	mux        sync.Mutex
+}
+
+func (d *DrgState) init(epoch uint) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.epoch = epoch
+	d.commits = make(map[[32]byte]DrgCommit)
+	d.commitSet = nil
+	d.revealed = false
+	d.reveals = make(map[[32]byte]DrgReveal)
+	d.resultChan = newQueue(1)
+}
+
+// returns "" if the commitment is signed by a member of the reference committee, otherwise the reason
+func verifyDrgCommit(ref *Committee, c *DrgCommit) string {
+	if c.Pub == nil {
+		return "no pub"
+	}
+	if _, ok := ref.Members[c.Pub.Bytes]; !ok {
+		return "not a member of the reference committee"
+	}
+	if !c.Pub.verify(c.calculateHash(), c.Sig) {
+		return "signature"
+	}
+	return ""
+}
+
+// returns "" if the result is valid, otherwise the reason
+func verifyDrgResult(ref *Committee, nodeCtx *NodeCtx, r *DrgResult) string {
+	commits := make(map[[32]byte]DrgCommit)
+	for _, c := range r.Commits {
+		c := c
+		if c.Epoch != r.Epoch {
+			return "commit epoch"
+		}
+		if reason := verifyDrgCommit(ref, &c); reason != "" {
+			return "commit " + reason
+		}
+		commits[c.Pub.Bytes] = c
+	}
+	revealed := make(map[[32]byte]bool)
+	for _, reveal := range r.Reveals {
+		c, ok := commits[reveal.Pub]
+		if !ok || revealed[reveal.Pub] {
+			return "reveal without commit"
+		}
+		if !c.Commit.opens(drgDomain, drgOpening(r.Epoch, reveal.Pub, reveal.Secret)) {
+			return "reveal does not match commit"
+		}
+		revealed[reveal.Pub] = true
+	}
+	if len(revealed) < drgRequired(ref, nodeCtx) {
+		return fmt.Sprintf("%d of %d reveals", len(revealed), drgRequired(ref, nodeCtx))
+	}
+	if r.EpochLength != 0 && !isValidEpochLength(r.EpochLength) {
+		return fmt.Sprintf("epoch length %d", r.EpochLength)
+	}
+	for _, p := range r.Evidence {
+		if reason := p.verify(); reason != "" {
+			return "evidence " + reason
+		}
+	}
+	return ""
+}
+
+func amIDrgAggregator(nodeCtx *NodeCtx, ref *Committee) bool {
+	members := ref.getMemberIDsAsSortedList()
+	return len(members) > 0 && members[0] == nodeCtx.self.Priv.Pub.Bytes
+}
+
+func sendMsgToAll(msg Msg, nodeCtx *NodeCtx) {
+	for _, n := range nodeCtx.allInfo {
+		spawnDialAndSend(n.IP, msg)
+	}
+}
+
+func handleDrgCommit(nodeCtx *NodeCtx, c DrgCommit) {
+	ref := referenceCommittee(nodeCtx.blockchain.getLastReconfigurationBlock())
+	if reason := verifyDrgCommit(ref, &c); reason != "" {
+		errr(nil, "drg commit: "+reason)
+		return
+	}
+	d := &nodeCtx.drg
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if c.Epoch == d.epoch && d.commitSet == nil {
+		d.commits[c.Pub.Bytes] = c
+	}
+}
+
+// the commitment set from the aggregator, reveal if ours is in it
+func handleDrgCommitSet(nodeCtx *NodeCtx, r DrgResult) {
+	ref := referenceCommittee(nodeCtx.blockchain.getLastReconfigurationBlock())
+	self := nodeCtx.self.Priv.Pub.Bytes
+	d := &nodeCtx.drg
+	d.mux.Lock()
+	if r.Epoch != d.epoch || d.revealed || len(r.Commits) < drgRequired(ref, nodeCtx) {
+		d.mux.Unlock()
+		return
+	}
+	set := make(map[[32]byte]DrgCommit)
+	for _, c := range r.Commits {
+		c := c
+		if reason := verifyDrgCommit(ref, &c); reason != "" || c.Epoch != d.epoch {
+			d.mux.Unlock()
+			errr(nil, "drg commit set: "+reason)
+			return
+		}
+		set[c.Pub.Bytes] = c
+	}
+	own, ok := set[self]
+	if !ok || !own.Commit.opens(drgDomain, drgOpening(d.epoch, self, d.secret)) {
+		d.mux.Unlock()
+		return
+	}
+	d.commitSet = set
+	d.revealed = true
+	reveal := DrgReveal{d.epoch, self, d.secret}
+	d.mux.Unlock()
+	sendMsgToCommitteeAndSelf(Msg{"drg_reveal", reveal, nodeCtx.self.Priv.Pub}, nodeCtx)
+}
+
+func handleDrgReveal(nodeCtx *NodeCtx, reveal DrgReveal) {
+	d := &nodeCtx.drg
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if reveal.Epoch != d.epoch {
+		return
+	}
+	// reveals can arrive before the commitment set
+	d.reveals[reveal.Pub] = reveal
+}
+
+func handleDrgResult(nodeCtx *NodeCtx, r DrgResult) {
+	ref := referenceCommittee(nodeCtx.blockchain.getLastReconfigurationBlock())
+	if reason := verifyDrgResult(ref, nodeCtx, &r); reason != "" {
+		errr(nil, "drg result: "+reason)
+		return
+	}
+	d := &nodeCtx.drg
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if r.Epoch != d.epoch {
+		return
+	}
+	d.resultChan.trySend(&r)
+}
+
+// waits until cond or the timeout, in steps of a delta
+func drgWait(nodeCtx *NodeCtx, cond func() bool) {
+	for i := 0; i < default_drgTimeout && !cond(); i++ {
+		clockSleep(nodeCtx.delta())
+	}
+}
+
+// collects the commitments and reveals of the reference committee and sends the result
+func aggregateDrg(nodeCtx *NodeCtx, ref *Committee, start time.Time, toAll bool) {
+	defer nodeCtx.instance.catchPanic()
+	d := &nodeCtx.drg
+	drgWait(nodeCtx, func() bool {
+		d.mux.Lock()
+		defer d.mux.Unlock()
+		return len(d.commits) == len(ref.Members)
+	})
+	d.mux.Lock()
+	commitSet := DrgResult{Epoch: d.epoch}
+	for _, c := range d.commits {
+		commitSet.Commits = append(commitSet.Commits, c)
+	}
+	d.mux.Unlock()
+	if len(commitSet.Commits) < drgRequired(ref, nodeCtx) {
+		errFatal(nil, fmt.Sprintf("drg: %d of %d commits", len(commitSet.Commits), drgRequired(ref, nodeCtx)))
+	}
+	sendMsgToCommitteeAndSelf(Msg{"drg_commits", commitSet, nodeCtx.self.Priv.Pub}, nodeCtx)
+
+	drgWait(nodeCtx, func() bool {
+		d.mux.Lock()
+		defer d.mux.Unlock()
+		return d.commitSet != nil && len(d.reveals) >= len(d.commitSet)
+	})
+	d.mux.Lock()
+	result := DrgResult{Epoch: d.epoch, Commits: commitSet.Commits}
+	for pub, reveal := range d.reveals {
+		if _, ok := d.commitSet[pub]; ok {
+			result.Reveals = append(result.Reveals, reveal)
+		}
+	}
+	d.mux.Unlock()
+	result.Evidence = nodeCtx.equivocations.evidence(nodeCtx.blockchain.getLastReconfigurationBlock())
+	result.EpochLength = nodeNextEpochLength(nodeCtx)
+	if reason := verifyDrgResult(ref, nodeCtx, &result); reason != "" {
+		errFatal(nil, "drg: "+reason)
+	}
+	if toAll {
+		sendMsgToAll(Msg{"drg_result", result, nodeCtx.self.Priv.Pub}, nodeCtx)
+	} else {
+		sendMsgToCommittee(Msg{"drg_result", result, nodeCtx.self.Priv.Pub}, &nodeCtx.committee)
+	}
+	handleDrgResult(nodeCtx, result)
+
+	s := fmt.Sprintf("%d,%s,%d,%d,%d", result.Epoch, bytes32ToString(result.randomness()), len(result.Commits), len(result.Reveals), clockSince(start).Milliseconds())
+	spawnDialAndSendToCoordinator("drg", s)
+}
+
+// runs the drg of the next epoch and returns its verified result, then waits for the epoch after.
+// The result is sent to every node if toAll, otherwise only to the reference committee
+func drgRound(nodeCtx *NodeCtx, toAll bool) *DrgResult {
+	start := clockNow()
+	ref := referenceCommittee(nodeCtx.blockchain.getLastReconfigurationBlock())
+	d := &nodeCtx.drg
+
+	if nodeCtx.committeeID() == ref.ID {
+		d.mux.Lock()
+		nodeRand().Read(d.secret[:])
+		c := DrgCommit{Epoch: d.epoch, Pub: nodeCtx.self.Priv.Pub, Commit: drgCommitment(d.epoch, nodeCtx.self.Priv.Pub.Bytes, d.secret)}
+		c.Sig = nodeCtx.self.Priv.sign(c.calculateHash())
+		d.mux.Unlock()
+		sendMsgToCommitteeAndSelf(Msg{"drg_commit", c, nodeCtx.self.Priv.Pub}, nodeCtx)
+		if amIDrgAggregator(nodeCtx, ref) {
+			spawn(func() { aggregateDrg(nodeCtx, ref, start, toAll) })
+		}
+	}
+
+	v, ok := d.resultChan.recvTimeout(3 * default_drgTimeout * nodeCtx.delta())
+	if !ok {
+		errFatal(nil, "drg result not recived")
+	}
+	result := v.(*DrgResult)
+	d.init(result.Epoch + 1)
+	nodeLog.infof(nodeCtx, "DRG epoch %d randomness %s after %s", result.Epoch, bytes32ToString(result.randomness()), clockSince(start))
+	return result
+}
+
+// runs the drg of the next epoch and adds the reconfiguration block with its randomness and the
+// same committees
+func runDrg(nodeCtx *NodeCtx) {
+	rBlock := nodeCtx.blockchain.getLastReconfigurationBlock()
+	result := drgRound(nodeCtx, true)
+
+	newBlock := new(ReconfigurationBlock)
+	newBlock.init()
+	for id, c := range rBlock.Committees {
+		newBlock.Committees[id] = c
+	}
+	newBlock.Randomness = result.randomness()
+	newBlock.Positions = rBlock.Positions
+	newBlock.Weights = rBlock.Weights
+	newBlock.Blacklist = rBlock.Blacklist
+	newBlock.EpochLength = rBlock.EpochLength
+	newBlock.addEvidence(result.Evidence)
+	newBlock.StartIteration = rBlock.StartIteration
+	newBlock.setHash()
+	addEpochBlock(nodeCtx, newBlock)
+}
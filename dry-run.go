@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dryrun simulates the committees over epochs of the cuckoo rule without a network
+
+// the result of the trials in one epoch
+type dryRunEpoch struct {
+	failed      int // trials with a failed committee in this epoch
+	failedUntil int // trials with a failed committee in this or an earlier epoch
+	maxFraction float64
+	moved       int
+}
+
+// adversaries of c and if they are below the committeeF bound
+func dryRunCommittee(c *Committee, adversaries map[[32]byte]bool, committeeF uint) (float64, bool) {
+	f := 0
+	for pub := range c.Members {
+		if adversaries[pub] {
+			f++
+		}
+	}
+	if len(c.Members) == 0 {
+		return 0, true
+	}
+	return float64(f) / float64(len(c.Members)), underCommitteeBound(f, len(c.Members), committeeF)
+}
+
+// the assignment of the coordinator for a trial: the nodes shuffled into m committees of about equal size
+func dryRunSetup(flagArgs *FlagArgs, source RandomSource) (*ReconfigurationBlock, map[[32]byte]bool) {
+	pubs := make([][32]byte, flagArgs.n)
+	for i := range pubs {
+		pubs[i] = hash(byteSliceAppend([]byte("dryrun node"), uintToByte(uint(i))))
+	}
+	source.Shuffle(len(pubs), func(i, j int) { pubs[i], pubs[j] = pubs[j], pubs[i] })
+	rBlock := new(ReconfigurationBlock)
+	rBlock.init()
+	ids := make([][32]byte, flagArgs.m)
+	for i := range ids {
+		rnd := make([]byte, 32)
+		source.Read(rnd)
+		ids[i] = hash(rnd)
+		c := new(Committee)
+		c.init(ids[i])
+		rBlock.Committees[ids[i]] = c
+	}
+	for i, pub := range pubs {
+		rBlock.Committees[ids[i%len(ids)]].addMember(&CommitteeMember{&PubKey{Bytes: pub}, ""})
+	}
+
+	// the adversaries are the first nodes of another shuffle
+	source.Shuffle(len(pubs), func(i, j int) { pubs[i], pubs[j] = pubs[j], pubs[i] })
+	adversaries := make(map[[32]byte]bool)
+	for _, pub := range pubs[:len(pubs)/int(flagArgs.totalF)] {
+		adversaries[pub] = true
+	}
+	return rBlock, adversaries
+}
+
+// runs one trial and adds it to epochs
+func dryRunTrial(flagArgs *FlagArgs, seed int64, epochs []dryRunEpoch, mux *sync.Mutex) {
+	source := newSeededSource(seed)
+	rBlock, adversaries := dryRunSetup(flagArgs, source)
+	budget := len(adversaries)
+	var corruption *Corruption
+	if flagArgs.adversary != adversaryStatic {
+		corruption = corrupt(rBlock, flagArgs.adversary, budget, source)
+	}
+	failedBefore := false
+	for e := range epochs {
+		rnd := make([]byte, 32)
+		source.Read(rnd)
+		next, moved := cuckooRule(rBlock, hash(rnd), flagArgs.churn, default_cuckooRegions)
+		rBlock = next
+		if corruption != nil {
+			adversaries = corruption.nodes
+			corruption = corrupt(rBlock, flagArgs.adversary, budget, source)
+		}
+		failed := false
+		maxFraction := 0.0
+		for _, c := range rBlock.Committees {
+			fraction, safe := dryRunCommittee(c, adversaries, flagArgs.committeeF)
+			failed = failed || !safe
+			maxFraction = math.Max(maxFraction, fraction)
+		}
+		failedBefore = failedBefore || failed
+		mux.Lock()
+		if failed {
+			epochs[e].failed++
+		}
+		if failedBefore {
+			epochs[e].failedUntil++
+		}
+		epochs[e].maxFraction += maxFraction
+		epochs[e].moved += moved
+		mux.Unlock()
+	}
+}
+
+func dryRun(flagArgs *FlagArgs) {
+	if flagArgs.m == 0 || flagArgs.n < flagArgs.m || flagArgs.epochs == 0 || flagArgs.trials == 0 {
+		errFatal(nil, "dryrun needs -n >= -m > 0, -epochs and -trials")
+	}
+	if flagArgs.runSeed == 0 {
+		flagArgs.runSeed = randomInt64(protocolRand)
+	}
+	log.Printf("[DryRun] %d nodes in %d committees, churn %.3f, adversary %s, %d epochs, %d trials, run seed %d\n", flagArgs.n, flagArgs.m, flagArgs.churn, flagArgs.adversary, flagArgs.epochs, flagArgs.trials, flagArgs.runSeed)
+	start := time.Now()
+
+	epochs := make([]dryRunEpoch, flagArgs.epochs)
+	var mux sync.Mutex
+	trials := make(chan int64, flagArgs.trials)
+	for t := uint(0); t < flagArgs.trials; t++ {
+		trials <- flagArgs.runSeed + int64(t)
+	}
+	close(trials)
+	workers := flagArgs.vCPUs
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for w := uint(0); w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer recordPanic()
+			defer wg.Done()
+			for seed := range trials {
+				dryRunTrial(flagArgs, seed, epochs, &mux)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var b strings.Builder
+	n := float64(flagArgs.trials)
+	for e, r := range epochs {
+		fmt.Fprintf(&b, "%d,%d,%.4f,%.4f,%.2f\n", e+1, r.failed, float64(r.failedUntil)/n, r.maxFraction/n, float64(r.moved)/n)
+	}
+	f, err := os.Create(resultsName("dryrun") + ".csv")
+	ifErrFatal(err, "dryrun")
+	defer f.Close()
+	_, err = f.WriteString(b.String())
+	ifErrFatal(err, "writing dryrun")
+
+	last := epochs[len(epochs)-1]
+	log.Printf("[DryRun] probability that a committee reaches the 1/%d bound within %d epochs: %.4f (%d of %d trials), after %s\n", flagArgs.committeeF, flagArgs.epochs, float64(last.failedUntil)/n, last.failedUntil, flagArgs.trials, time.Since(start))
+}
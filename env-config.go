@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// every flag as an RC_ environment variable
+
+// the variable of the flag name
+func envName(name string) string {
+	var b strings.Builder
+	b.WriteString("RC_")
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) && !unicode.IsUpper(rune(name[i-1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+func givenFlags() map[string]bool {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	return given
+}
+
+func setFromEnv(sub string, f *flag.Flag) {
+	v, ok := os.LookupEnv(envName(f.Name))
+	if !ok {
+		return
+	}
+	if err := flag.Set(f.Name, v); err != nil {
+		usageErr(sub, "%s=%s: %v", envName(f.Name), v, err)
+	}
+}
+
+// RC_FUNCTION, before the subcommand is known
+func envFunction(sub string) {
+	if sub != "" || givenFlags()["function"] {
+		return
+	}
+	setFromEnv(sub, flag.Lookup("function"))
+}
+
+// the flags of sub from their variables, the ones on the command line stay
+func applyEnv(sub string) {
+	given := givenFlags()
+	flag.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || f.Name == "function" {
+			return
+		}
+		if !takesFlag(sub, f.Name) {
+			if _, ok := os.LookupEnv(envName(f.Name)); ok {
+				nodeLog.warnf(nil, "%s skipped, -%s is not a flag of %s", envName(f.Name), f.Name, sub)
+			}
+			return
+		}
+		setFromEnv(sub, f)
+	})
+}
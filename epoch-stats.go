@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the routing, ida and consensus stats summed per epoch, results/epochstats*.csv
+
+// the stats of one epoch at the coordinator
+type EpochStat struct {
+	first, last     time.Time
+	txs             int // txs that reached their committee
+	routed          int // of those, txs that were routed from another committee
+	routing         time.Duration
+	reconstructions int
+	ida             time.Duration
+	echos           int
+	accepts         int
+	pendings        int
+	acceptFails     int
+}
+
+type EpochStats struct {
+	epochs map[uint]*EpochStat
+	mux    sync.Mutex
+}
+
+func (es *EpochStats) init() {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	es.epochs = make(map[uint]*EpochStat)
+}
+
+// the stat of epoch, seen at t
+func (es *EpochStats) _get(epoch uint, t time.Time) *EpochStat {
+	s, ok := es.epochs[epoch]
+	if !ok {
+		s = &EpochStat{first: t, last: t}
+		es.epochs[epoch] = s
+	}
+	if t.Before(s.first) {
+		s.first = t
+	}
+	if t.After(s.last) {
+		s.last = t
+	}
+	return s
+}
+
+// a tx reached its committee, start is zero if it was not routed
+func (es *EpochStats) addTx(epoch uint, start, end time.Time) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	s := es._get(epoch, end)
+	s.txs++
+	if !start.IsZero() {
+		s.routed++
+		s.routing += end.Sub(start)
+	}
+}
+
+// a node reconstructed an ida gossip, start is zero if the start is not known yet
+func (es *EpochStats) addReconstruction(epoch uint, start, t time.Time) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	s := es._get(epoch, t)
+	if !start.IsZero() {
+		s.reconstructions++
+		s.ida += t.Sub(start)
+	}
+}
+
+func (es *EpochStats) addConsensus(epoch uint, tag string, t time.Time) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	s := es._get(epoch, t)
+	switch tag {
+	case "echo":
+		s.echos++
+	case "accept":
+		s.accepts++
+	case "pending":
+		s.pendings++
+	case "accept_fail":
+		s.acceptFails++
+	}
+}
+
+func meanSeconds(d time.Duration, n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	return d.Seconds() / float64(n)
+}
+
+func (es *EpochStats) write(name string) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	epochs := make([]uint, 0, len(es.epochs))
+	for e := range es.epochs {
+		epochs = append(epochs, e)
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i] < epochs[j] })
+	var b strings.Builder
+	for _, e := range epochs {
+		s := es.epochs[e]
+		fmt.Fprintf(&b, "%d,%d,%d,%d,%d,%.3f,%d,%.3f,%d,%d,%d,%d\n", e, s.first.UnixNano(), s.last.UnixNano(), s.txs, s.routed, meanSeconds(s.routing, s.routed), s.reconstructions, meanSeconds(s.ida, s.reconstructions), s.echos, s.accepts, s.pendings, s.acceptFails)
+	}
+	ifErr(os.WriteFile(name+".csv", []byte(b.String()), 0644), "epoch stats csv")
+}
+
+// a consensus stat of this node for the coordinator
+func consensusStat(nodeCtx *NodeCtx, tag string) Msg {
+	return Msg{"consensus", ByteArrayAndTimestamp{[]byte(tag), coordinatorTime(clockNow()), nodeCtx.blockchain.epoch()}, nodeCtx.self.Priv.Pub}
+}
+
+// with -latencyStats histograms the stat is only counted, see latency-histograms.go
+func sendConsensusStat(nodeCtx *NodeCtx, tag string) {
+	if nodeCtx.latencies.enabled {
+		nodeCtx.latencies.count(tag + "s")
+		return
+	}
+	sendStat(nodeCtx, consensusStat(nodeCtx, tag))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// -epochTrigger, what ends an epoch
+
+const epochByBlocks = "blocks"
+const epochByTime = "time"
+const epochByChurn = "churn"
+
+func isEpochTrigger(s string) bool {
+	return s == epochByBlocks || s == epochByTime || s == epochByChurn
+}
+
+// the start of the current epoch and the joins and leaves since, on a node or the coordinator
+type EpochClock struct {
+	start   time.Time
+	changes int
+	mux     sync.Mutex
+}
+
+func (ec *EpochClock) init() {
+	ec.mux.Lock()
+	defer ec.mux.Unlock()
+	ec.start = clockNow()
+	ec.changes = 0
+}
+
+func (ec *EpochClock) change() {
+	ec.mux.Lock()
+	defer ec.mux.Unlock()
+	ec.changes++
+}
+
+func (ec *EpochClock) get() (time.Duration, int) {
+	ec.mux.Lock()
+	defer ec.mux.Unlock()
+	return clockSince(ec.start), ec.changes
+}
+
+// iterations of the epoch of rBlock, 0 if there are no epochs
+func epochLengthOf(rBlock *ReconfigurationBlock, flagArgs *FlagArgs) uint {
+	if rBlock.EpochLength > 0 {
+		return rBlock.EpochLength
+	}
+	return flagArgs.epochLength
+}
+
+func isValidEpochLength(l uint) bool {
+	return l >= default_minEpochLength && l <= default_maxEpochLength
+}
+
+// iterations of the epoch after the one of prev, that took elapsed with changes joins and leaves
+func nextEpochLength(flagArgs *FlagArgs, prev *ReconfigurationBlock, elapsed time.Duration, changes int) uint {
+	iterations := float64(epochLengthOf(prev, flagArgs))
+	var l float64
+	switch flagArgs.epochTrigger {
+	case epochByTime:
+		l = float64(flagArgs.epochTime) * iterations / elapsed.Seconds()
+	case epochByChurn:
+		members := 0
+		for _, c := range prev.Committees {
+			members += len(c.Members)
+		}
+		l = default_maxEpochLength
+		if changes > 0 {
+			l = flagArgs.epochChurn * float64(members) * iterations / float64(changes)
+		}
+	default:
+		l = float64(flagArgs.epochLength)
+	}
+	return uint(math.Max(default_minEpochLength, math.Min(default_maxEpochLength, math.Round(l))))
+}
+
+// the length a node that produces the next block, the aggregator of the drg, puts in it
+func nodeNextEpochLength(nodeCtx *NodeCtx) uint {
+	if nodeCtx.flagArgs.epochLength == 0 {
+		return 0
+	}
+	elapsed, changes := nodeCtx.epochClock.get()
+	return nextEpochLength(&nodeCtx.flagArgs, nodeCtx.blockchain.getLastReconfigurationBlock(), elapsed, changes)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// -epochLength, the reconfiguration of the committees every epoch
+
+func epochDue(nodeCtx *NodeCtx) bool {
+	rBlock := nodeCtx.blockchain.getLastReconfigurationBlock()
+	l := epochLengthOf(rBlock, &nodeCtx.flagArgs)
+	i := nodeCtx.i.getI()
+	return l > 0 && i > 0 && i == rBlock.StartIteration+l
+}
+
+// gets the block of the next epoch, from the coordinator, the drg of the reference committee or its
+// gossip (reconfiguration-gossip.go), and switches to the committee of this node
+func runEpoch(nodeCtx *NodeCtx) {
+	start := clockNow()
+	// blocks synced while waiting for the drg can move the iteration
+	i := nodeCtx.i.getI()
+	prev := nodeCtx.blockchain.getLastReconfigurationBlock()
+	var rBlock *ReconfigurationBlock
+	var moved int
+	if nodeCtx.flagArgs.reference == referenceByCoordinator {
+		rBlock = requestReconfiguration(nodeCtx, i)
+		moved = movedNodes(prev, rBlock)
+	} else {
+		if nodeCtx.committeeID() == referenceCommittee(prev).ID {
+			result := drgRound(nodeCtx, false)
+			next, _ := cuckooRule(prev, result.randomness(), nodeCtx.flagArgs.churn, default_cuckooRegions)
+			next.StartIteration = i
+			next.addEvidence(result.Evidence)
+			if result.EpochLength > 0 {
+				next.EpochLength = result.EpochLength
+			}
+			next.setHash()
+			signReconfiguration(nodeCtx, prev, next)
+		} else {
+			// the drg of the reference committee is not sent to the other committees
+			nodeCtx.drg.init(nodeCtx.blockchain.epoch() + 2)
+		}
+		// the reference committee also waits for the certified block, so the nodes that move
+		// switch at about the same time as their new committee
+		rBlock = awaitReconfiguration(nodeCtx, i)
+		moved = movedNodes(prev, rBlock)
+	}
+	known := clockSince(start)
+	from := nodeCtx.committeeID()
+	addEpochBlock(nodeCtx, rBlock)
+	reportSwitch(nodeCtx, from, start, known)
+	nodeLog.infof(nodeCtx, "Epoch %d from iteration %d, %d nodes moved, committee %s -> %s after %s", nodeCtx.blockchain.epoch(), rBlock.StartIteration, moved, bytes32ToString(from), bytes32ToString(nodeCtx.committeeID()), clockSince(start))
+}
+
+// adds the reconfiguration block of a new epoch, switches to the committee of this node in it and
+// rotates the mac keys. The keys of the new epoch are derived before the block is added so both
+// change together
+func addEpochBlock(nodeCtx *NodeCtx, rBlock *ReconfigurationBlock) {
+	epoch := nodeCtx.blockchain.epoch() + 1
+	next := MacKeys{}
+	if nodeCtx.flagArgs.mac {
+		next.rotate(nodeCtx, rBlock, epoch)
+	}
+	nodeCtx.blockchain.addRecBlock(rBlock)
+	nodeCtx.epochClock.init()
+	switchCommittee(nodeCtx, rBlock)
+	if nodeCtx.flagArgs.mac {
+		nodeCtx.macKeys.mux.Lock()
+		nodeCtx.macKeys.epoch, nodeCtx.macKeys.rBlock, nodeCtx.macKeys.keys = next.epoch, next.rBlock, next.keys
+		nodeCtx.macKeys.mux.Unlock()
+	}
+}
+
+// updates the committee, routing table and neighbours to rBlock, and joins the new committee if
+// this node moved
+func switchCommittee(nodeCtx *NodeCtx, rBlock *ReconfigurationBlock) {
+	self := nodeCtx.self.Priv.Pub.Bytes
+	c := rBlock.committeeOf(self)
+	if c == nil {
+		errFatal(nil, "not in any committee of reconfiguration block "+bytes32ToString(rBlock.Hash))
+	}
+	allInfo := make(map[[32]byte]NodeAllInfo)
+	for pub, info := range nodeCtx.allInfo {
+		if other := rBlock.committeeOf(pub); other != nil {
+			info.CommitteeID = other.ID
+		}
+		allInfo[pub] = info
+	}
+	committee := Committee{}
+	committee.init(c.ID)
+	for pub, member := range c.Members {
+		if pub != self {
+			committee.Members[pub] = &CommitteeMember{member.Pub, member.IP}
+		}
+	}
+	moved := c.ID != nodeCtx.committeeID()
+
+	nodeCtx.allInfo = allInfo
+	nodeCtx.setCommittee(committee)
+	nodeCtx.setCommitteeID(c.ID)
+	buildRoutingTable(nodeCtx, c.ID, allInfo)
+	buildCurrentNeighbours(nodeCtx)
+	if !moved {
+		return
+	}
+
+	recBlocks := nodeCtx.blockchain.getReconfigurationBlocks()
+	initCommitteeState(nodeCtx)
+	for _, rb := range recBlocks {
+		nodeCtx.blockchain.addRecBlock(rb)
+	}
+	nodeCtx.genesisHash = nodeCtx.genesisHashes[c.ID]
+	joinCommittee(nodeCtx, rBlock)
+}
+
+// state syncs from the members that stay in the committee, and waits until they committed the
+// first block of the epoch. Its proposal was sent before this node switched, so it can not take
+// part in that iteration
+func joinCommittee(nodeCtx *NodeCtx, rBlock *ReconfigurationBlock) {
+	prev := nodeCtx.blockchain.getReconfigurationBlock(nodeCtx.blockchain.epoch() - 1)
+	peers := []*CommitteeMember{}
+	for _, member := range nodeCtx.committee.sortedMembers() {
+		if _, ok := prev.Committees[nodeCtx.committeeID()].Members[member.Pub.Bytes]; ok {
+			peers = append(peers, member)
+		}
+	}
+	if len(peers) == 0 {
+		errFatal(nil, fmt.Sprintf("no member stayed in committee %s", bytes32ToString(nodeCtx.committeeID())))
+	}
+	fastSyncFrom(nodeCtx, peers)
+	for attempt := 0; nodeCtx.i.getI() <= rBlock.StartIteration; attempt++ {
+		if attempt >= 3*default_drgTimeout {
+			errFatal(nil, "committee did not reach the epoch")
+		}
+		clockSleep(nodeCtx.delta())
+		syncBlocksFrom(nodeCtx, peers)
+	}
+	nodeLog.infof(nodeCtx, "%s joined committee %s at iteration %d", nodeCtx.self.IP, bytes32ToString(nodeCtx.committeeID()), nodeCtx.i.getI())
+}
+
+// a node that moved to the committee with the epoch can not take part in its first iteration, see
+// joinCommittee, so it is not elected leader of it
+func canLead(nodeCtx *NodeCtx, rBlock *ReconfigurationBlock, pub [32]byte, i uint) bool {
+	epoch := nodeCtx.blockchain.epoch()
+	if epoch == 0 || rBlock.StartIteration != i {
+		return true
+	}
+	prev := nodeCtx.blockchain.getReconfigurationBlock(epoch - 1)
+	c, ok := prev.Committees[nodeCtx.committeeID()]
+	if !ok {
+		return true
+	}
+	_, ok = c.Members[pub]
+	return ok
+}
+
+// the nodes that move to a committee switch within a delta of it, so the leader of the first
+// iteration of an epoch waits a delta before its block is gossiped to them
+func firstIterationOfEpoch(nodeCtx *NodeCtx) bool {
+	return nodeCtx.flagArgs.epochLength > 0 && nodeCtx.blockchain.epoch() > 0 && nodeCtx.blockchain.getLastReconfigurationBlock().StartIteration == nodeCtx.i.getI()
+}
+
+// hash,startIteration,randomness,committee sizes sorted by id,moved,iterations until the next epoch
+// (0 if the nodes use their -epochLength)
+func reconfigurationString(rBlock *ReconfigurationBlock, moved int) string {
+	sizes := []string{}
+	for _, id := range newCuckooRing(rBlock, 1).ids {
+		sizes = append(sizes, fmt.Sprint(len(rBlock.Committees[id].Members)))
+	}
+	return fmt.Sprintf("%s,%d,%s,%s,%d,%d", bytes32ToString(rBlock.Hash), rBlock.StartIteration, bytes32ToString(rBlock.Randomness), strings.Join(sizes, ";"), moved, rBlock.EpochLength)
+}
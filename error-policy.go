@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// how a node handles an error by its kind, only what it can't go on without is fatal
+
+// a connection to addr, dialed again on an error unless peers can crash
+func dialWithRetry(addr string) (net.Conn, error) {
+	tries := default_dialRetries + 1
+	if peersCanCrash {
+		tries = 1
+	}
+	backoff := default_dialBackoff * time.Millisecond
+	var err error
+	for try := 0; try < tries; try++ {
+		if try > 0 {
+			clockSleep(backoff)
+			backoff *= 2
+		}
+		var conn net.Conn
+		if conn, err = netDialTimeout(addr, 0); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// the type of a msg for the logs
+func msgTyp(msg interface{}) string {
+	if m, ok := msg.(Msg); ok {
+		return m.Typ
+	}
+	return "answer"
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// the event bus of a node that stats, metrics and traces subscribe to
+
+type Event struct {
+	T     time.Time
+	Epoch uint
+	Data  interface{} // one of the event types below
+}
+
+// a tx reached the committee it belongs to, or a node that routes it on
+type TxReceivedEvent struct {
+	ID       [32]byte
+	AtTarget bool
+}
+
+// a node routed a tx to its committee with hops find_node rounds
+type TxRoutedEvent struct {
+	Duration time.Duration
+	Hops     int
+}
+
+// a node starts to ida gossip the message with hash ID
+type IdaStartedEvent struct {
+	ID [32]byte
+}
+
+// the first chunk of the ida gossip with merkle root Root
+type ChunkReceivedEvent struct {
+	Root [32]byte
+}
+
+// the chunks of Root reconstructed the message with hash ID
+type ChunkReconstructedEvent struct {
+	Root [32]byte
+	ID   [32]byte
+}
+
+// a node got a find_node for the committee Target
+type FindNodeEvent struct {
+	Target [32]byte
+}
+
+// a propose, echo, pending or accept of the block with GossipHash
+type VoteReceivedEvent struct {
+	Tag        string
+	GossipHash [32]byte
+}
+
+type VoteSentEvent struct {
+	Tag        string
+	GossipHash [32]byte
+}
+
+// the committee accepted the block with GossipHash, before it is processed
+type BlockAcceptedEvent struct {
+	GossipHash [32]byte
+	Block      *ProposedBlock
+}
+
+type EventBus struct {
+	subscribers []func(Event)
+	mux         sync.Mutex
+}
+
+func (eb *EventBus) init() {
+	eb.mux.Lock()
+	defer eb.mux.Unlock()
+	eb.subscribers = nil
+}
+
+func (eb *EventBus) subscribe(f func(Event)) {
+	eb.mux.Lock()
+	defer eb.mux.Unlock()
+	eb.subscribers = append(eb.subscribers, f)
+}
+
+func (eb *EventBus) publish(nodeCtx *NodeCtx, data interface{}) {
+	eb.mux.Lock()
+	subscribers := eb.subscribers
+	eb.mux.Unlock()
+	if len(subscribers) == 0 {
+		return
+	}
+	e := Event{clockNow(), nodeCtx.blockchain.epoch(), data}
+	for _, f := range subscribers {
+		f(e)
+	}
+}
+
+func statAt(id []byte, e Event) *ByteArrayAndTimestamp {
+	return &ByteArrayAndTimestamp{id, coordinatorTime(e.T), e.Epoch}
+}
+
+// the stats of the node for the coordinator, with -latencyStats histograms most of them are
+// recorded in the histograms and counts of the node instead
+func subscribeStats(nodeCtx *NodeCtx) {
+	histograms := nodeCtx.latencies.enabled
+	nodeCtx.events.subscribe(func(e Event) {
+		switch d := e.Data.(type) {
+		case TxReceivedEvent:
+			if histograms {
+				if d.AtTarget {
+					nodeCtx.latencies.count("txs")
+				}
+			} else if d.AtTarget {
+				sendStat(nodeCtx, Msg{"transaction_recieved", statAt(d.ID[:], e), nil})
+			} else {
+				sendStat(nodeCtx, Msg{"routetx", statAt(d.ID[:], e), nil})
+			}
+		case TxRoutedEvent:
+			nodeCtx.latencies.recordDuration("routing_s", d.Duration)
+			nodeCtx.latencies.record("routing_hops", int64(d.Hops))
+		case IdaStartedEvent:
+			sendStat(nodeCtx, Msg{"start_ida_gossip", statAt(d.ID[:], e), nil})
+		case ChunkReceivedEvent:
+			nodeCtx.latencies.start("ida", d.Root)
+		case ChunkReconstructedEvent:
+			nodeCtx.latencies.since("ida_s", "ida", d.Root, true)
+			if !histograms {
+				sendStat(nodeCtx, Msg{"reconstructed_ida_gossip", statAt(d.ID[:], e), nil})
+			}
+		case FindNodeEvent:
+			if !histograms {
+				cID := nodeCtx.committeeID()
+				sendStat(nodeCtx, Msg{"find_node", statAt(byteSliceAppend(d.Target[:], cID[:]), e), nil})
+			}
+		case VoteReceivedEvent:
+			if d.Tag == "propose" {
+				nodeCtx.latencies.start("consensus", d.GossipHash)
+			} else {
+				sendConsensusStat(nodeCtx, d.Tag)
+			}
+		case VoteSentEvent:
+			if d.Tag == "accept" {
+				nodeCtx.latencies.since("consensus_echo_s", "consensus", d.GossipHash, false)
+			}
+		case BlockAcceptedEvent:
+			nodeCtx.latencies.since("consensus_accept_s", "consensus", d.GossipHash, true)
+		}
+	})
+}
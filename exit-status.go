@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// the exit codes of the processes and their results/status*.json
+
+const (
+	exitSuccess   = 0
+	exitFatal     = 1
+	exitPanic     = 2 // the code of the go runtime
+	exitAborted   = 4
+	exitViolation = 5
+	exitUsage     = 64
+)
+
+var exitStatusNames = map[int]string{
+	exitSuccess:     "success",
+	exitFatal:       "fatal",
+	exitPanic:       "panic",
+	exitUsage:       "usage",
+	recoverExitCode: "recover",
+	exitAborted:     "aborted",
+	exitViolation:   "violation",
+}
+
+type ExitStatus struct {
+	Role       string    `json:"role"`
+	Status     string    `json:"status"`
+	ExitCode   int       `json:"exit_code"`
+	Reason     string    `json:"reason,omitempty"`
+	Violations []string  `json:"violations,omitempty"`
+	Build      string    `json:"build"`
+	Pid        int       `json:"pid"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	written    bool
+	mux        sync.Mutex
+}
+
+// the status of the process, nil when it does not take part in a run
+var exitStatus *ExitStatus
+
+var exitStatusRoles = map[string]string{
+	"coordinator": "coordinator",
+	"standby":     "standby",
+	"node":        "node",
+	"simulate":    "run",
+	"demo":        "run",
+}
+
+func startExitStatus(mode string) {
+	role, ok := exitStatusRoles[mode]
+	if !ok {
+		return
+	}
+	exitStatus = &ExitStatus{Role: role, Build: currentBuild().String(), Pid: os.Getpid(), Start: time.Now()}
+}
+
+func (s *ExitStatus) violation(description string) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.Violations = append(s.Violations, description)
+}
+
+// writes the status of the process that exits with code, returns the code it exits with
+func (s *ExitStatus) finish(code int, reason string) int {
+	if s == nil {
+		return code
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if code == exitSuccess && len(s.Violations) > 0 {
+		code = exitViolation
+	}
+	// the first exit counts, a fatal error while the results are written does not overwrite it
+	if s.written {
+		return code
+	}
+	s.written = true
+	s.ExitCode, s.Status, s.Reason, s.End = code, exitStatusNames[code], reason, time.Now()
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return code
+	}
+	// not resultsName, which is fatal when it fails
+	name := filepath.Join(resultsDir, "status-"+runName+".json")
+	switch s.Role {
+	case "node":
+		name = filepath.Join(resultsDir, fmt.Sprintf("status-%s-node-%d.json", runName, s.Pid))
+	case "standby":
+		name = filepath.Join(resultsDir, "status-"+runName+"-standby.json")
+	}
+	if os.MkdirAll(resultsDir, 0755) == nil {
+		os.WriteFile(name, b, 0644)
+	}
+	return code
+}
+
+// writes the status and exits with code
+func (s *ExitStatus) exit(code int, reason string) {
+	os.Exit(s.finish(code, reason))
+}
+
+// deferred by main and the goroutines, writes the status of a panic no instance caught before it
+// ends the process
+func recordPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	exitStatus.finish(exitPanic, fmt.Sprintf("panic: %v", r))
+	panic(r)
+}
+
+// go f() with the panics of f in the status
+func goRecorded(f func()) {
+	go func() {
+		defer recordPanic()
+		f()
+	}()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// the read-only block explorer of -explorerPort
+
+type explorerInTx struct {
+	TxHash string
+	N      uint
+}
+
+type explorerOutTx struct {
+	Value  uint
+	N      uint
+	PubKey string
+	Key    string // encoded key, see key-encoding.go
+}
+
+type explorerTx struct {
+	Hash       string
+	OrigTxHash string
+	Class      string
+	Inputs     []explorerInTx
+	Outputs    []explorerOutTx
+}
+
+type explorerBlock struct {
+	GossipHash         string
+	PreviousGossipHash string
+	Iteration          uint
+	CommitteeID        string
+	Leader             string
+	LeaderKey          string
+	MerkleRoot         string
+	Signatures         int
+	Pruned             bool
+	Transactions       []explorerTx
+}
+
+type explorerTxAnswer struct {
+	Block    string
+	Position int
+	Tx       explorerTx
+}
+
+func toExplorerTx(t *Transaction) explorerTx {
+	et := explorerTx{Hash: bytes32ToString(t.Hash), OrigTxHash: bytes32ToString(t.OrigTxHash), Class: t.Class}
+	for _, inp := range t.Inputs {
+		et.Inputs = append(et.Inputs, explorerInTx{bytes32ToString(inp.TxHash), inp.N})
+	}
+	for _, out := range t.Outputs {
+		pub, key := "", ""
+		if out.PubKey != nil {
+			pub = bytes32ToString(out.PubKey.Bytes)
+			key = out.PubKey.encodedString()
+		}
+		et.Outputs = append(et.Outputs, explorerOutTx{out.Value, out.N, pub, key})
+	}
+	return et
+}
+
+func toExplorerBlock(b *FinalBlock) explorerBlock {
+	pb := b.ProposedBlock
+	eb := explorerBlock{
+		GossipHash:         bytes32ToString(pb.GossipHash),
+		PreviousGossipHash: bytes32ToString(pb.PreviousGossipHash),
+		Iteration:          pb.Iteration,
+		CommitteeID:        bytes32ToString(pb.CommitteeID),
+		MerkleRoot:         bytes32ToString(pb.MerkleRoot),
+		Signatures:         b.signerCount(),
+		Pruned:             b.Pruned,
+		Transactions:       []explorerTx{},
+	}
+	if pb.LeaderPub != nil {
+		eb.Leader = bytes32ToString(pb.LeaderPub.Bytes)
+		eb.LeaderKey = pb.LeaderPub.encodedString()
+	}
+	for _, t := range pb.Transactions {
+		eb.Transactions = append(eb.Transactions, toExplorerTx(t))
+	}
+	return eb
+}
+
+// returns the block with GossipHash gh from memory or the store, nil if not found
+func (b *Blockchain) getByHash(gh [32]byte) *FinalBlock {
+	b.mux.Lock()
+	block, ok := b.Blocks[gh]
+	store := b.store
+	b.mux.Unlock()
+	if ok {
+		return block
+	}
+	if store != nil {
+		return store.getByHash(gh)
+	}
+	return nil
+}
+
+// returns the block with exactly this iteration, nil if not found
+func (b *Blockchain) getByHeight(height uint64) *FinalBlock {
+	if b.getLatest() == nil {
+		return nil
+	}
+	block := b.getByIteration(height)
+	if block == nil || uint64(block.ProposedBlock.Iteration) != height {
+		return nil
+	}
+	return block
+}
+
+// returns the block containing the transaction with id and its position, nil if not found.
+// Walks backwards from the latest block, so it is slow for old transactions
+func (b *Blockchain) findTx(id [32]byte) (*FinalBlock, int) {
+	block := b.getLatest()
+	for block != nil {
+		for i, t := range block.ProposedBlock.Transactions {
+			if t.Hash == id || t.id() == id {
+				return block, i
+			}
+		}
+		if block.ProposedBlock.PreviousGossipHash == [32]byte{} {
+			break
+		}
+		block = b.getByHash(block.ProposedBlock.PreviousGossipHash)
+	}
+	return nil, -1
+}
+
+func parseHash(s string) ([32]byte, bool) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		return [32]byte{}, false
+	}
+	return toByte32(b), true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	ifErr(enc.Encode(v), "explorer encode")
+}
+
+func explorerHandler(nodeCtx *NodeCtx) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/head", func(w http.ResponseWriter, r *http.Request) {
+		block := nodeCtx.blockchain.getLatest()
+		if block == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, toExplorerBlock(block))
+	})
+	mux.HandleFunc("/block/", func(w http.ResponseWriter, r *http.Request) {
+		arg := strings.TrimPrefix(r.URL.Path, "/block/")
+		var block *FinalBlock
+		if strings.HasPrefix(arg, "height/") {
+			h, err := strconv.ParseUint(strings.TrimPrefix(arg, "height/"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid height", http.StatusBadRequest)
+				return
+			}
+			block = nodeCtx.blockchain.getByHeight(h)
+		} else {
+			gh, ok := parseHash(arg)
+			if !ok {
+				http.Error(w, "invalid hash", http.StatusBadRequest)
+				return
+			}
+			block = nodeCtx.blockchain.getByHash(gh)
+		}
+		if block == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, toExplorerBlock(block))
+	})
+	mux.HandleFunc("/tx/", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := parseHash(strings.TrimPrefix(r.URL.Path, "/tx/"))
+		if !ok {
+			http.Error(w, "invalid tx id", http.StatusBadRequest)
+			return
+		}
+		block, i := nodeCtx.blockchain.findTx(id)
+		if block == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, explorerTxAnswer{bytes32ToString(block.ProposedBlock.GossipHash), i, toExplorerTx(block.ProposedBlock.Transactions[i])})
+	})
+	return mux
+}
+
+func launchExplorer(nodeCtx *NodeCtx, port uint) {
+	addr := "127.0.0.1:" + strconv.FormatUint(uint64(port), 10)
+	if !nodeCtx.flagArgs.local {
+		addr = ":" + strconv.FormatUint(uint64(port), 10)
+	}
+	nodeLog.infof(nodeCtx, "Explorer listening on %s", addr)
+	ifErrFatal(http.ListenAndServe(addr, explorerHandler(nodeCtx)), "explorer listen")
+}
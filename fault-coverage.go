@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// which injected faults hit nodes and how the protocol reacted, results/faults*.csv
+
+// a fault that hit a node, Messages since the last report
+type FaultHit struct {
+	ID        uint
+	Pub       [32]byte
+	Committee [32]byte
+	Messages  uint
+}
+
+type faultReaction struct {
+	kind      string
+	committee string // "" for the whole run
+	t         time.Time
+}
+
+type faultRecord struct {
+	fault     Fault
+	nodes     map[string]string // pub -> committee of the nodes it hit
+	messages  uint
+	first     time.Time
+	last      time.Time
+	reactions map[string]int
+	respawned map[string]bool
+}
+
+var faultReactionKinds = []string{"accept_fail", "fork", "kill", "respawn", "recover", "blacklist", "violation", "abort"}
+
+// the faults of a run and what the coordinator saw, nil outside the coordinator
+type FaultCoverage struct {
+	faults    map[uint]*faultRecord
+	next      uint
+	reactions []faultReaction
+	mux       sync.Mutex
+}
+
+var faultCoverage *FaultCoverage
+
+func (fc *FaultCoverage) init() {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	fc.faults = make(map[uint]*faultRecord)
+	for _, f := range faultScenario {
+		fc._add(f)
+	}
+}
+
+func (fc *FaultCoverage) _add(f Fault) uint {
+	if f.ID == 0 {
+		f.ID = fc.next + 1
+	}
+	if f.ID > fc.next {
+		fc.next = f.ID
+	}
+	if f.Kind != "clear" {
+		fc.faults[f.ID] = &faultRecord{fault: f, nodes: make(map[string]string), reactions: make(map[string]int), respawned: make(map[string]bool)}
+	}
+	return f.ID
+}
+
+// a fault of the coordinator api, returns its id
+func (fc *FaultCoverage) add(f Fault) uint {
+	if fc == nil {
+		return 0
+	}
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	return fc._add(f)
+}
+
+func (fc *FaultCoverage) hit(h FaultHit) {
+	if fc == nil {
+		return
+	}
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	r, ok := fc.faults[h.ID]
+	if !ok {
+		coordinatorLog.warnf(nil, "[Fault] hit of unknown fault %d", h.ID)
+		return
+	}
+	now := clockNow()
+	if r.first.IsZero() {
+		r.first = now
+	}
+	r.last = now
+	r.nodes[bytes32ToString(h.Pub)] = bytes32ToString(h.Committee)
+	r.messages += h.Messages
+}
+
+// a reaction of the protocol in committee, or in the whole run with "", on the node pub or ""
+func (fc *FaultCoverage) react(kind string, committee string, pub string) {
+	if fc == nil {
+		return
+	}
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	fc.reactions = append(fc.reactions, faultReaction{kind, committee, clockNow()})
+	if kind != "respawn" && kind != "recover" {
+		return
+	}
+	for _, r := range fc.faults {
+		if _, ok := r.nodes[pub]; ok && r.fault.Kind == "crash" {
+			r.respawned[pub] = true
+		}
+	}
+}
+
+// the kind, committee and node of a row of the churn csv
+func (fc *FaultCoverage) reactChurn(row string) {
+	fields := strings.Split(row, ",")
+	if len(fields) < 3 {
+		return
+	}
+	fc.react(fields[0], fields[1], fields[2])
+}
+
+// counts the reactions of every fault and its verdict
+func (fc *FaultCoverage) _verdict(r *faultRecord) string {
+	if r.first.IsZero() {
+		return "untested"
+	}
+	committees := make(map[string]bool)
+	for _, c := range r.nodes {
+		committees[c] = true
+	}
+	end := r.last.Add(default_faultWindow * time.Second)
+	for kind := range r.reactions {
+		r.reactions[kind] = 0
+	}
+	for _, re := range fc.reactions {
+		if re.t.Before(r.first) || re.t.After(end) || (re.committee != "" && !committees[re.committee]) {
+			continue
+		}
+		r.reactions[re.kind]++
+	}
+	if r.reactions["violation"] > 0 || r.reactions["abort"] > 0 {
+		return "violated"
+	}
+	if r.fault.Kind == "crash" && r.fault.Downtime > 0 && len(r.respawned) < len(r.nodes) {
+		return "unrecovered"
+	}
+	for _, kind := range faultReactionKinds {
+		if r.reactions[kind] > 0 {
+			return "reacted"
+		}
+	}
+	return "tolerated"
+}
+
+func (fc *FaultCoverage) rows() []string {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	ids := []uint{}
+	for id := range fc.faults {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	rows := []string{}
+	for _, id := range ids {
+		r := fc.faults[id]
+		verdict := fc._verdict(r)
+		first, last := int64(0), int64(0)
+		if !r.first.IsZero() {
+			first, last = r.first.UnixNano(), r.last.UnixNano()
+		}
+		row := fmt.Sprintf("%d,%s,%s,%s,%d,%d,%d,%d", id, r.fault.Kind, r.fault.Module, strings.Join(r.fault.Nodes, ";"), len(r.nodes), r.messages, first, last)
+		for _, kind := range faultReactionKinds {
+			row += fmt.Sprintf(",%d", r.reactions[kind])
+		}
+		rows = append(rows, row+","+verdict)
+	}
+	return rows
+}
+
+// writes the report if there were faults and logs the verdicts
+func (fc *FaultCoverage) write(name string) {
+	if fc == nil {
+		return
+	}
+	rows := fc.rows()
+	if len(rows) == 0 {
+		return
+	}
+	verdicts := make(map[string]int)
+	for _, row := range rows {
+		verdicts[row[strings.LastIndex(row, ",")+1:]]++
+	}
+	if ifErr(os.WriteFile(name, []byte(strings.Join(rows, "\n")+"\n"), 0644), "fault report") {
+		return
+	}
+	summary := []string{}
+	for _, v := range []string{"untested", "violated", "unrecovered", "reacted", "tolerated"} {
+		if verdicts[v] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", verdicts[v], v))
+		}
+	}
+	coordinatorLog.infof(nil, "[Fault] %d faults: %s, see %s", len(rows), strings.Join(summary, ", "), name)
+}
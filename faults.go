@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// faults injected into chosen nodes by -faults or /fault
+
+type Fault struct {
+	ID        uint     `json:"id"` // the position in the scenario, or given by the coordinator
+	Nodes     []string `json:"nodes"`
+	Kind      string   `json:"kind"`
+	Module    string   `json:"module"`
+	Count     uint     `json:"count"`
+	Delay     uint     `json:"delay"`
+	Field     string   `json:"field"`
+	Iteration uint     `json:"iteration"`
+	Downtime  uint     `json:"downtime"`
+}
+
+// the module of the message types, the control messages of the coordinator are never faulted
+var faultModules = map[string]string{
+	"IDAGossipMsg":                      "ida",
+	"consensus":                         "consensus",
+	"equivocation_proof":                "consensus",
+	"find_node":                         "routing",
+	"transaction":                       "routing",
+	"crosstransaction":                  "routing",
+	"crosstransactionresponse":          "routing",
+	"vrf_claim":                         "drg",
+	"drg_commit":                        "drg",
+	"drg_commits":                       "drg",
+	"drg_reveal":                        "drg",
+	"drg_result":                        "drg",
+	"request_block":                     "sync",
+	"request_blocks":                    "sync",
+	"request_snapshot":                  "sync",
+	"request_state_hash":                "sync",
+	"request_reconfiguration_blocks":    "sync",
+	"request_certified_reconfiguration": "sync",
+	"request_inclusion_proof":           "sync",
+	"reconfiguration_sig":               "reconfiguration",
+	"certified_reconfiguration":         "reconfiguration",
+	"node_leave":                        "reconfiguration",
+	"node_join":                         "reconfiguration",
+	"request_join_challenge":            "reconfiguration",
+	"join_request":                      "reconfiguration",
+}
+
+// the faults of -faults
+var faultScenario []Fault
+
+func isFaultKind(kind string) bool {
+	switch kind {
+	case "drop", "delay", "corrupt", "crash", "clear":
+		return true
+	}
+	return false
+}
+
+// reads the scenario, a crash in it lets the peers of a node be down
+func readFaultScenario(path string) {
+	b, err := os.ReadFile(path)
+	ifErrFatal(err, "reading fault scenario "+path)
+	ifErrFatal(json.Unmarshal(b, &faultScenario), "decoding fault scenario "+path)
+	for i, f := range faultScenario {
+		// the coordinator and the nodes read the same scenario
+		if f.ID == 0 {
+			faultScenario[i].ID = uint(i + 1)
+		}
+		if !isFaultKind(f.Kind) {
+			errFatal(nil, "unknown fault kind "+f.Kind+" in "+path)
+		}
+		if f.Kind == "crash" {
+			peersCanCrash = true
+		}
+	}
+}
+
+func (f *Fault) hits(pub [32]byte) bool {
+	key := bytes32ToString(pub)
+	for _, p := range f.Nodes {
+		if p == "all" || (p != "" && strings.HasPrefix(key, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fault) matches(typ string) bool {
+	module, ok := faultModules[typ]
+	if !ok {
+		return false
+	}
+	return f.Module == "all" || f.Module == module || f.Module == typ
+}
+
+func (f *Fault) String() string {
+	switch f.Kind {
+	case "delay":
+		return fmt.Sprintf("delay %s by %dms", f.Module, f.Delay)
+	case "crash":
+		return fmt.Sprintf("crash at iteration %d for %ds", f.Iteration, f.Downtime)
+	case "corrupt":
+		return fmt.Sprintf("corrupt %s of %d %s", f.Field, f.Count, f.Module)
+	}
+	return fmt.Sprintf("%s %d %s", f.Kind, f.Count, f.Module)
+}
+
+// the faults of a node, count is what is left of a fault with a count
+type FaultInjector struct {
+	faults   []*Fault
+	messages map[uint]uint      // fault id -> messages hit since the last report
+	reported map[uint]time.Time // fault id -> last report
+	mux      sync.Mutex
+}
+
+// a node that starts again after a crash keeps the faults it had left instead of the scenario
+func (fi *FaultInjector) init(nodeCtx *NodeCtx) {
+	var previous *NodeCtx
+	if nodeCtx.join != nil && nodeCtx.join.respawn {
+		nodeCtx.instance.mux.Lock()
+		previous = nodeCtx.instance.nodeCtx
+		nodeCtx.instance.mux.Unlock()
+	}
+	fi.mux.Lock()
+	fi.messages = make(map[uint]uint)
+	fi.reported = make(map[uint]time.Time)
+	fi.mux.Unlock()
+	if previous != nil {
+		previous.faults.mux.Lock()
+		faults := previous.faults.faults
+		previous.faults.mux.Unlock()
+		fi.mux.Lock()
+		fi.faults = faults
+		fi.mux.Unlock()
+		return
+	}
+	fi.mux.Lock()
+	fi.faults = []*Fault{}
+	fi.mux.Unlock()
+	for _, f := range faultScenario {
+		fi.add(nodeCtx, f)
+	}
+}
+
+func (fi *FaultInjector) add(nodeCtx *NodeCtx, f Fault) {
+	if f.Kind == "crash" {
+		peersCanCrash = true
+	}
+	if !f.hits(nodeCtx.self.Priv.Pub.Bytes) {
+		return
+	}
+	fi.mux.Lock()
+	defer fi.mux.Unlock()
+	if f.Kind == "clear" {
+		fi.faults = []*Fault{}
+		nodeLog.warnf(nodeCtx, "[Fault] cleared")
+		return
+	}
+	fi.faults = append(fi.faults, &f)
+	nodeLog.warnf(nodeCtx, "[Fault] %s", f.String())
+}
+
+// the faults of kind that hit a message of typ, the ones with a count use one up
+func (fi *FaultInjector) _take(kind string, typ string) []*Fault {
+	hit := []*Fault{}
+	kept := fi.faults[:0]
+	for _, f := range fi.faults {
+		if f.Kind == kind && f.matches(typ) {
+			hit = append(hit, f)
+			fi.messages[f.ID]++
+			if f.Count == 1 {
+				continue
+			}
+			if f.Count > 1 {
+				f.Count--
+			}
+		}
+		kept = append(kept, f)
+	}
+	fi.faults = kept
+	return hit
+}
+
+// the hits of the faults that are due to be reported to the coordinator, the first one at once and
+// the last ones of a fault with a count when it is used up
+func (fi *FaultInjector) _due(nodeCtx *NodeCtx) []FaultHit {
+	active := make(map[uint]bool)
+	for _, f := range fi.faults {
+		active[f.ID] = true
+	}
+	hits := []FaultHit{}
+	for id, n := range fi.messages {
+		if n == 0 || (active[id] && clockSince(fi.reported[id]) < default_faultHitInterval*time.Second) {
+			continue
+		}
+		hits = append(hits, FaultHit{id, nodeCtx.self.Priv.Pub.Bytes, nodeCtx.committeeID(), n})
+		fi.messages[id] = 0
+		fi.reported[id] = clockNow()
+	}
+	return hits
+}
+
+func reportFaultHits(hits []FaultHit) {
+	for _, h := range hits {
+		spawnDialAndSendToCoordinator("fault_hit", h)
+	}
+}
+
+// applies the faults to a received message, false if it is dropped
+func (fi *FaultInjector) inject(nodeCtx *NodeCtx, msg *Msg) bool {
+	fi.mux.Lock()
+	if len(fi.faults) == 0 {
+		fi.mux.Unlock()
+		return true
+	}
+	defer func() {
+		fi.mux.Lock()
+		hits := fi._due(nodeCtx)
+		fi.mux.Unlock()
+		reportFaultHits(hits)
+	}()
+	dropped := len(fi._take("drop", msg.Typ)) > 0
+	var delay time.Duration
+	corrupt := []string{}
+	if !dropped {
+		for _, f := range fi._take("delay", msg.Typ) {
+			delay += time.Duration(f.Delay) * time.Millisecond
+		}
+		for _, f := range fi._take("corrupt", msg.Typ) {
+			corrupt = append(corrupt, f.Field)
+		}
+	}
+	fi.mux.Unlock()
+	if dropped {
+		nodeLog.debugf(nodeCtx, "[Fault] dropped %s", msg.Typ)
+		return false
+	}
+	for _, field := range corrupt {
+		if !corruptField(msg, field) {
+			nodeLog.warnf(nodeCtx, "[Fault] %s has no field %s to corrupt", msg.Typ, field)
+		}
+	}
+	clockSleep(delay)
+	return true
+}
+
+// kills the node if a crash fault is due at the start of iteration, true if it does
+func (fi *FaultInjector) iteration(nodeCtx *NodeCtx, iteration uint) bool {
+	fi.mux.Lock()
+	var crash *Fault
+	for i, f := range fi.faults {
+		if f.Kind == "crash" && f.Iteration <= iteration {
+			crash = f
+			fi.faults = append(fi.faults[:i], fi.faults[i+1:]...)
+			break
+		}
+	}
+	fi.mux.Unlock()
+	if crash == nil {
+		return false
+	}
+	reportFaultHits([]FaultHit{{crash.ID, nodeCtx.self.Priv.Pub.Bytes, nodeCtx.committeeID(), 0}})
+	nodeLog.warnf(nodeCtx, "[Fault] crash at iteration %d", iteration)
+	downtime := time.Duration(crash.Downtime) * time.Second
+	spawn(func() { nodeCtx.instance.kill(downtime) })
+	return true
+}
+
+// changes the field at path of the msg, the msg gets a copy of its content
+func corruptField(msg *Msg, path string) bool {
+	if msg.Msg == nil {
+		return false
+	}
+	v := reflect.New(reflect.TypeOf(msg.Msg)).Elem()
+	v.Set(reflect.ValueOf(msg.Msg))
+	field := v
+	for _, name := range strings.Split(path, ".") {
+		for field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				return false
+			}
+			field = field.Elem()
+		}
+		if field.Kind() != reflect.Struct {
+			return false
+		}
+		field = field.FieldByName(name)
+		if !field.IsValid() || !field.CanSet() {
+			return false
+		}
+	}
+	switch field.Kind() {
+	case reflect.Array, reflect.Slice:
+		if field.Len() == 0 || field.Type().Elem().Kind() != reflect.Uint8 {
+			return false
+		}
+		if field.Kind() == reflect.Slice {
+			field.Set(reflect.AppendSlice(reflect.MakeSlice(field.Type(), 0, field.Len()), field))
+		}
+		field.Index(0).SetUint(field.Index(0).Uint() ^ 0xff)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		field.SetInt(field.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		field.SetUint(field.Uint() + 1)
+	case reflect.Bool:
+		field.SetBool(!field.Bool())
+	case reflect.String:
+		field.SetString(field.String() + "x")
+	default:
+		return false
+	}
+	msg.Msg = v.Interface()
+	return true
+}
+
+// the fault of the query of /fault
+func faultFromQuery(r *http.Request) (Fault, error) {
+	q := r.URL.Query()
+	f := Fault{Nodes: strings.Split(q.Get("nodes"), ","), Kind: q.Get("kind"), Module: q.Get("module"), Field: q.Get("field")}
+	if !isFaultKind(f.Kind) {
+		return f, fmt.Errorf("unknown kind %q, give kind=drop, delay, corrupt, crash or clear", f.Kind)
+	}
+	for name, value := range map[string]*uint{"count": &f.Count, "delay": &f.Delay, "iteration": &f.Iteration, "downtime": &f.Downtime} {
+		if q.Get(name) == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(q.Get(name), 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("%s: %v", name, err)
+		}
+		*value = uint(n)
+	}
+	return f, nil
+}
+
+// sends the fault of the query to every node
+func serveFault(ms *Membership, w http.ResponseWriter, r *http.Request) {
+	f, err := faultFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Kind == "crash" {
+		peersCanCrash = true
+	}
+	f.ID = faultCoverage.add(f)
+	nodes := ms.selectNodes([]string{"all"})
+	for _, info := range nodes {
+		spawnDialAndSend(info.IP, withControlToken(info.Pub, Msg{"fault", f, nil}))
+	}
+	coordinatorLog.infof(nil, "[Fault] %d %s to nodes %s", f.ID, f.String(), strings.Join(f.Nodes, ","))
+	fmt.Fprintf(w, "%s sent to %d nodes\n", f.String(), len(nodes))
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -flightRecorder, the last events of a node written on a failure
+
+type FlightRecorder struct {
+	pub    [32]byte
+	events []Event
+	next   int
+	full   bool
+	mux    sync.Mutex
+}
+
+type FlightDump struct {
+	Dump string
+	Err  string
+}
+
+// the recorders of the nodes of this process, written on a fatal error or a panic
+var flightRecorders struct {
+	list []*FlightRecorder
+	mux  sync.Mutex
+}
+
+// the recorder of a node with size events, subscribed to its events, nil without -flightRecorder
+func newFlightRecorder(nodeCtx *NodeCtx, size uint) *FlightRecorder {
+	if size == 0 {
+		return nil
+	}
+	fr := new(FlightRecorder)
+	fr.pub = nodeCtx.self.Priv.Pub.Bytes
+	fr.events = make([]Event, size)
+	nodeCtx.events.subscribe(fr.record)
+	flightRecorders.mux.Lock()
+	flightRecorders.list = append(flightRecorders.list, fr)
+	flightRecorders.mux.Unlock()
+	return fr
+}
+
+func (fr *FlightRecorder) record(e Event) {
+	// the block would stay in memory as long as the event
+	if d, ok := e.Data.(BlockAcceptedEvent); ok {
+		d.Block = nil
+		e.Data = d
+	}
+	fr.mux.Lock()
+	defer fr.mux.Unlock()
+	fr.events[fr.next] = e
+	fr.next = (fr.next + 1) % len(fr.events)
+	if fr.next == 0 {
+		fr.full = true
+	}
+}
+
+func describeEvent(data interface{}) string {
+	switch d := data.(type) {
+	case TxReceivedEvent:
+		return fmt.Sprintf("tx_received %s at target %v", shortID(d.ID), d.AtTarget)
+	case TxRoutedEvent:
+		return fmt.Sprintf("tx_routed in %s with %d hops", d.Duration, d.Hops)
+	case IdaStartedEvent:
+		return fmt.Sprintf("ida_started %s", shortID(d.ID))
+	case ChunkReceivedEvent:
+		return fmt.Sprintf("chunk_received root %s", shortID(d.Root))
+	case ChunkReconstructedEvent:
+		return fmt.Sprintf("chunk_reconstructed root %s %s", shortID(d.Root), shortID(d.ID))
+	case FindNodeEvent:
+		return fmt.Sprintf("find_node %s", shortID(d.Target))
+	case VoteReceivedEvent:
+		return fmt.Sprintf("vote_received %s %s", d.Tag, shortID(d.GossipHash))
+	case VoteSentEvent:
+		return fmt.Sprintf("vote_sent %s %s", d.Tag, shortID(d.GossipHash))
+	case BlockAcceptedEvent:
+		return fmt.Sprintf("block_accepted %s", shortID(d.GossipHash))
+	}
+	return fmt.Sprintf("%T %v", data, data)
+}
+
+// the recording, the oldest event first
+func (fr *FlightRecorder) dump(reason string) string {
+	fr.mux.Lock()
+	defer fr.mux.Unlock()
+	events := fr.events[:fr.next]
+	if fr.full {
+		events = append(append([]Event{}, fr.events[fr.next:]...), fr.events[:fr.next]...)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "flight recording of %s, %s, last %d events\n", bytes32ToString(fr.pub), reason, len(events))
+	for _, e := range events {
+		fmt.Fprintf(&b, "%s epoch %d %s\n", e.T.UTC().Format(time.RFC3339Nano), e.Epoch, describeEvent(e.Data))
+	}
+	return b.String()
+}
+
+func writeFlightDump(key string, dump string) (string, error) {
+	dir := filepath.Join(resultsDir, "flight")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%s_%d.txt", key, time.Now().Unix()))
+	return name, os.WriteFile(name, []byte(dump), 0644)
+}
+
+// writes the recordings of all nodes of the process, before it goes down
+func dumpFlightRecorders(reason string) {
+	flightRecorders.mux.Lock()
+	defer flightRecorders.mux.Unlock()
+	for _, fr := range flightRecorders.list {
+		name, err := writeFlightDump(shortID(fr.pub), fr.dump(reason))
+		if !ifErr(err, "flight recording") {
+			nodeLog.infof(nil, "[Flight] wrote %s", name)
+		}
+	}
+}
+
+func handleFlightDump(nodeCtx *NodeCtx, conn net.Conn) {
+	answer := FlightDump{Err: "flight recorder is off"}
+	if nodeCtx.recorder != nil {
+		answer = FlightDump{Dump: nodeCtx.recorder.dump("requested by the coordinator")}
+	}
+	ifErr(gob.NewEncoder(conn).Encode(answer), "flight dump answer")
+}
+
+// asks the node of info for its recording, a node that is down is an error and does not end the run
+func requestFlightDump(info NodeAllInfo) FlightDump {
+	conn, err := netDialTimeout(info.IP, 5*time.Second)
+	if err != nil {
+		return FlightDump{Err: err.Error()}
+	}
+	defer conn.Close()
+	conn.SetDeadline(clockNow().Add(default_profileTimeout * time.Second))
+	if err := gob.NewEncoder(conn).Encode(withControlToken(info.Pub, Msg{"flight_dump", "", nil})); err != nil {
+		return FlightDump{Err: err.Error()}
+	}
+	var answer FlightDump
+	if err := gob.NewDecoder(conn).Decode(&answer); err != nil {
+		return FlightDump{Err: err.Error()}
+	}
+	return answer
+}
+
+// collects the recordings of nodes, a line for every node
+func collectFlightDumps(nodes []NodeAllInfo) []string {
+	lines := make([]string, len(nodes))
+	var wg WaitGroup
+	for i, info := range nodes {
+		i, info := i, info
+		wg.Add(1)
+		spawn(func() {
+			defer wg.Done()
+			key := shortID(info.Pub.Bytes)
+			answer := requestFlightDump(info)
+			if answer.Err != "" {
+				lines[i] = fmt.Sprintf("%s error %s", key, answer.Err)
+				return
+			}
+			name, err := writeFlightDump(key, answer.Dump)
+			if err != nil {
+				lines[i] = fmt.Sprintf("%s error %s", key, err)
+				return
+			}
+			lines[i] = fmt.Sprintf("%s %s", key, name)
+		})
+	}
+	wg.Wait()
+	return lines
+}
+
+func serveFlightDumps(ms *Membership, w http.ResponseWriter, r *http.Request) {
+	nodes := ms.selectNodes(strings.Split(r.URL.Query().Get("nodes"), ","))
+	if len(nodes) == 0 {
+		http.Error(w, "no nodes selected, give nodes=<key prefixes> or nodes=all", http.StatusBadRequest)
+		return
+	}
+	coordinatorLog.infof(nil, "[Flight] recordings of %d nodes", len(nodes))
+	fmt.Fprintln(w, strings.Join(collectFlightDumps(nodes), "\n"))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/renzhf/go-merkletree"
+)
+
+// fuzz targets that feed gob streams of msgs through decodeInbound into the handlers
+
+// the golden keys have no key behind their bytes to encode
+func fuzzPub(b byte) *PubKey {
+	k := new(PrivKey)
+	k.derive(goldenHash(b))
+	return k.Pub
+}
+
+// the first block of the committee of the golden block
+func fuzzGenesis() *ProposedBlock {
+	b := new(ProposedBlock)
+	b.CommitteeID = goldenHash(4)
+	b.LeaderPub = fuzzPub(0xcc)
+	b.setHash()
+	return b
+}
+
+// the golden block with a tx with keys, on top of fuzzGenesis
+func fuzzBlock() *ProposedBlock {
+	tx := goldenTransaction()
+	for i, out := range tx.Outputs {
+		out.PubKey = fuzzPub(byte(0xaa + i))
+	}
+	tx.setHash()
+	b := goldenProposedBlock()
+	b.PreviousGossipHash = fuzzGenesis().GossipHash
+	b.Iteration = 1
+	b.LeaderPub = fuzzPub(0xcc)
+	b.Transactions = []*Transaction{tx}
+	b.MerkleRoot = toByte32(createMerkleTree(nil, b.Transactions).Root())
+	b.setHash()
+	return b
+}
+
+// two chunks of the body of block with the proofs of their tree, like a neighbour sends them
+func fuzzIDAMsg(f *testing.F, block *ProposedBlock) IDAGossipMsg {
+	body := encodeBody(block.encode(), false)
+	chunks := [][]byte{body[:len(body)/2], body[len(body)/2:]}
+	tree, err := merkletree.New(chunks)
+	if err != nil {
+		f.Fatal(err)
+	}
+	proofs := make([]*merkletree.Proof, len(chunks))
+	for i := range proofs {
+		if proofs[i], err = tree.GenerateProofUsingIndex(uint64(i), 0); err != nil {
+			f.Fatal(err)
+		}
+	}
+	return IDAGossipMsg{"block", chunks, proofs, toByte32(tree.Root())}
+}
+
+// every msg is the seed of a stream of its own
+func fuzzSeeds(f *testing.F, msgs ...Msg) {
+	for _, msg := range msgs {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(msg); err != nil {
+			f.Fatalf("encoding the seed %s: %v", msg.Typ, err)
+		}
+		f.Add(buf.Bytes())
+	}
+}
+
+// the msgs of data up to the first one decodeInbound drops
+func fuzzMsgs(inbound map[string][]interface{}, data []byte) []*Msg {
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	msgs := []*Msg{}
+	for {
+		msg, err := decodeInbound(inbound, dec)
+		if err != nil {
+			return msgs
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// the connection a msg came in on, answers go nowhere
+func fuzzConn() net.Conn {
+	return newMirroredConn("127.0.0.1:9")
+}
+
+// what main sets up before a node or the coordinator runs. errFatal panics like in a node of an
+// instance and a peer that is down is skipped, the peers of the node are not there
+func fuzzInit(f *testing.F) *FlagArgs {
+	defer func(s, c bool) {
+		f.Cleanup(func() { supervised, peersCanCrash = s, c })
+	}(supervised, peersCanCrash)
+	supervised, peersCanCrash = true, true
+	flagArgs := testFlagArgs(f.TempDir())
+	// a handler that waits deltas for the block of a vote gives up at once, an input does not sleep
+	flagArgs.delta = 0
+	setResults(flagArgs.resultsDir, "fuzz")
+	registerGobTypes()
+	initSigCaches(default_sigCache)
+	// the handlers log every input they drop
+	setLogLevels("error", "")
+	return flagArgs
+}
+
+// a node of a committee of four that has the final block of fuzzGenesis and the block of fuzzBlock, next to another committee of four.
+// The other nodes are not up
+func fuzzNode(flagArgs *FlagArgs) *NodeCtx {
+	genesis, block := fuzzGenesis(), fuzzBlock()
+	rBlock := new(ReconfigurationBlock)
+	rBlock.init()
+	response := &ResponseToNodes{ReconfigurationBlock: rBlock, GenesisHashes: map[[32]byte][32]byte{block.CommitteeID: genesis.GossipHash}}
+	var self *PrivKey
+	for c, id := range [][32]byte{block.CommitteeID, goldenHash(6)} {
+		committee := new(Committee)
+		committee.init(id)
+		for i := 0; i < 4; i++ {
+			k := new(PrivKey)
+			k.derive(goldenHash(byte(0x10 + 4*c + i)))
+			if self == nil {
+				self = k
+			}
+			info := NodeAllInfo{k.Pub, id, fmt.Sprintf("127.0.0.1:%d", 1+4*c+i), true}
+			response.Nodes = append(response.Nodes, info)
+			committee.addMember(&CommitteeMember{info.Pub, info.IP})
+		}
+		rBlock.Committees[id] = committee
+	}
+	rBlock.setHash()
+
+	nodeCtx := new(NodeCtx)
+	nodeCtx.flagArgs = *flagArgs
+	setupFromResponse(nodeCtx, self, response)
+	nodeCtx.blockchain.add(&FinalBlock{ProposedBlock: genesis})
+	nodeCtx.blockchain.addProposedBlock(block)
+	return nodeCtx
+}
+
+func FuzzNodeHandleMsg(f *testing.F) {
+	nodeCtx := fuzzNode(fuzzInit(f))
+	block := fuzzBlock()
+	tx := block.Transactions[0]
+	fuzzSeeds(f,
+		Msg{"IDAGossipMsg", fuzzIDAMsg(f, block), fuzzPub(1)},
+		Msg{"consensus", ConsensusMsg{GossipHash: block.GossipHash, Tag: "echo", Pub: fuzzPub(1), Sig: &Sig{R: big.NewInt(11), S: big.NewInt(12)}}, fuzzPub(1)},
+		Msg{"find_node", KademliaFindNodeMsg{goldenHash(5)}, fuzzPub(1)},
+		Msg{"transaction", *tx, fuzzPub(1)},
+		Msg{"crosstransaction", *tx, fuzzPub(1)},
+		Msg{"request_block", uint64(0), fuzzPub(1)})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, msg := range fuzzMsgs(nodeInbound, data) {
+			nodeHandleMsg(fuzzConn(), *msg, nodeCtx)
+		}
+	})
+}
+
+func FuzzHandleConsensus(f *testing.F) {
+	nodeCtx := fuzzNode(fuzzInit(f))
+	gh := fuzzBlock().GossipHash
+	sig := &Sig{R: big.NewInt(11), S: big.NewInt(12)}
+	fuzzSeeds(f,
+		Msg{"consensus", ConsensusMsg{GossipHash: gh, Tag: "propose", Pub: fuzzPub(2), Sig: sig}, fuzzPub(2)},
+		Msg{"consensus", ConsensusMsg{GossipHash: gh, Tag: "echo", Pub: fuzzPub(1), Sig: sig}, fuzzPub(1)},
+		Msg{"consensus", ConsensusMsg{GossipHash: gh, Tag: "pending", Pub: fuzzPub(3), Mac: []byte{1, 2, 3}, MacEpoch: 1}, fuzzPub(3)},
+		Msg{"consensus", ConsensusMsg{GossipHash: gh, Tag: "accept", Pub: fuzzPub(1), Sig: sig}, fuzzPub(1)})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, msg := range fuzzMsgs(nodeInbound, data) {
+			if cMsg, ok := msg.Msg.(ConsensusMsg); ok {
+				handleConsensus(nodeCtx, cMsg, msg.FromPub)
+			}
+		}
+	})
+}
+
+// the state of launchCoordinator the stats go to, without its listener and tx generator
+func fuzzCoordinator(f *testing.F, flagArgs *FlagArgs) func(net.Conn, *Msg) {
+	files := make([]*os.File, 31)
+	for i := range files {
+		var err error
+		if files[i], err = os.Create(resultsName(fmt.Sprintf("fuzz%d", i)) + ".csv"); err != nil {
+			f.Fatal(err)
+		}
+		f.Cleanup(func() { files[i].Close() })
+	}
+	coordinatorTuning.init(flagArgs, files[30])
+	faultCoverage = new(FaultCoverage)
+	faultCoverage.init()
+	startStatsLimit(flagArgs)
+
+	successfullGossips := new(idaSuccesses)
+	successfullGossips.init()
+	consensusResults := new(consensusResult)
+	finalBlockChan := newQueue(int(flagArgs.m * 2))
+	// there is no tx generator that takes the final blocks
+	go func() {
+		for {
+			finalBlockChan.recv()
+		}
+	}()
+	rMap := new(routetxmap)
+	rMap.m = make(map[[32]byte]*routetxresults)
+	idaresults := new(IDAGossipResultsMap)
+	idaresults.m = make(map[[32]byte]*IDAGossipResults)
+	receiptVerifier := new(ReceiptVerifier)
+	receiptVerifier.init()
+	genesis := new(GenesisBlocks)
+	genesis.init()
+	chains := new(ChainExport)
+	chains.init()
+	ledger := new(GlobalLedger)
+	ledger.init()
+	membership := new(Membership)
+	membership.init(PowChallenge{}, flagArgs)
+	epochStats := new(EpochStats)
+	epochStats.init()
+	views := new(ViewChecks)
+	views.init()
+	latencies := new(LatencyResults)
+	latencies.init()
+	resources := new(ResourceFiles)
+	resources.init(resultsName("resources"))
+	progress := new(Progress)
+	progress.init()
+	wire := new(WireResults)
+	wire.init()
+	return func(conn net.Conn, msg *Msg) {
+		coordinatorHandleStat(conn, msg, successfullGossips, consensusResults, finalBlockChan, files, rMap, idaresults, receiptVerifier, genesis, chains, ledger, membership, epochStats, views, latencies, resources, progress, wire, nil)
+	}
+}
+
+func FuzzCoordinatorHandleStat(f *testing.F) {
+	handle := fuzzCoordinator(f, fuzzInit(f))
+	block := fuzzBlock()
+	sig := &Sig{R: big.NewInt(11), S: big.NewInt(12)}
+	stat := func(typ string, n int) Msg {
+		return Msg{typ, ByteArrayAndTimestamp{bytes.Repeat([]byte{7}, n), time.Unix(1, 0), 1}, fuzzPub(1)}
+	}
+	fuzzSeeds(f,
+		stat("consensus_accept_fail", 88),
+		stat("orphan_stats", 64),
+		stat("find_node", 64),
+		stat("routetx", 32),
+		Msg{"finalblock", FinalBlock{ProposedBlock: block, Signatures: []*ConsensusMsg{{GossipHash: block.GossipHash, Tag: "accept", Pub: fuzzPub(1), Sig: sig}}}, fuzzPub(1)},
+		Msg{"churn", "respawn,a,b,1,2,3,4", fuzzPub(1)},
+		Msg{"stats_batch", StatsBatch{[]Msg{stat("routetx", 32), {"pocadd", time.Second, fuzzPub(1)}}}, fuzzPub(1)})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, msg := range fuzzMsgs(coordinatorInbound, data) {
+			// every stat of a batch is handled like it came in its own connection
+			if batch, ok := msg.Msg.(StatsBatch); ok {
+				for i := range batch.Msgs {
+					handle(fuzzConn(), &batch.Msgs[i])
+				}
+				continue
+			}
+			handle(fuzzConn(), msg)
+		}
+	})
+}
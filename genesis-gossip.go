@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// -genesisGossip, a member of every committee disperses its genesis block by ida gossip
+
+// genesis blocks held by the coordinator until the dispersers of each committee ask for them
+type GenesisBlocks struct {
+	m   map[[32]byte]*ProposedBlock // CommitteeID -> genesis block
+	mux sync.Mutex
+}
+
+func (g *GenesisBlocks) init() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.m = make(map[[32]byte]*ProposedBlock)
+}
+
+func (g *GenesisBlocks) set(blocks []*FinalBlock) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	for _, b := range blocks {
+		g.m[b.ProposedBlock.CommitteeID] = b.ProposedBlock
+	}
+}
+
+func (g *GenesisBlocks) get(committeeID [32]byte) *ProposedBlock {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	return g.m[committeeID]
+}
+
+func genesisHashes(blocks []*FinalBlock) map[[32]byte][32]byte {
+	hashes := make(map[[32]byte][32]byte)
+	for _, b := range blocks {
+		hashes[b.ProposedBlock.CommitteeID] = b.ProposedBlock.GossipHash
+	}
+	return hashes
+}
+
+func amIGenesisDisperser(nodeCtx *NodeCtx) bool {
+	members := nodeCtx.committee.getMemberIDsAsSortedList()
+	return len(members) == 0 || bytes.Compare(nodeCtx.self.Priv.Pub.Bytes[:], members[0][:]) < 0
+}
+
+// fetches the genesis block of this committee from the coordinator and ida gossips it to the committee
+func disperseGenesis(nodeCtx *NodeCtx) *ProposedBlock {
+	block := new(ProposedBlock)
+	conn := dial(coordinatorAddr())
+	sendMsg(conn, Msg{"request_genesis", nodeCtx.committeeID(), nodeCtx.self.Priv.Pub})
+	reciveMsg(conn, block)
+	conn.Close()
+
+	// let the rest of the committee start listening
+	clockSleep(nodeCtx.delta())
+	IDAGossip(nodeCtx, encodeBlockForGossip(nodeCtx, block), "genesis")
+	return block
+}
+
+// gets the genesis block by ida gossip and processes it. If it is not recived in time the node
+// falls back to state sync
+func receiveGenesis(nodeCtx *NodeCtx) {
+	var block *ProposedBlock
+	if amIGenesisDisperser(nodeCtx) {
+		block = disperseGenesis(nodeCtx)
+	} else {
+		v, ok := nodeCtx.channels.genesisChan.recvTimeout(default_genesisTimeout * nodeCtx.delta())
+		if !ok {
+			idaLog.warnf(nodeCtx, "Genesis block not recived by ida gossip, state sync instead")
+			nodeCtx.fastSync = true
+			return
+		}
+		block = v.(*ProposedBlock)
+	}
+	if block.GossipHash != nodeCtx.genesisHash || block.CommitteeID != nodeCtx.committeeID() {
+		errFatal(nil, fmt.Sprintf("genesis block %s does not match genesis hash %s", bytes32ToString(block.GossipHash), bytes32ToString(nodeCtx.genesisHash)))
+	}
+	b := &FinalBlock{ProposedBlock: block}
+	b.processBlock(nodeCtx)
+	nodeCtx.blockchain.add(b)
+	nodeCtx.utxoSet.verifyNonces()
+}
+
+// reports the time from registering at the coordinator untill the node has the genesis state
+func reportBootstrap(nodeCtx *NodeCtx, start time.Time) {
+	mode := nodeCtx.flagArgs.bootstrap
+	if nodeCtx.join != nil {
+		mode = "join"
+	} else if nodeCtx.fastSync {
+		mode = "fastsync"
+	} else if nodeCtx.genesisGossip {
+		mode = "ida"
+	}
+	s := fmt.Sprintf("%s,%s,%s,%d", bytes32ToString(nodeCtx.committeeID()), bytes32ToString(nodeCtx.self.Priv.Pub.Bytes), mode, clockSince(start).Milliseconds())
+	spawnDialAndSendToCoordinator("bootstrap", s)
+}
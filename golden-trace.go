@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// the golden trace of the final blocks of a run and tracediff
+
+const traceHeader = "# rapidchain trace"
+
+// the lines of the trace, the chains sorted like the chain export
+func traceLines(committees []ChainExportCommittee, manifest *Manifest) []string {
+	manifest.mux.Lock()
+	lines := []string{fmt.Sprintf("%s run seed %d n %d m %s", traceHeader, manifest.RunSeed, manifest.NodesRequested, manifest.Flags["m"])}
+	manifest.mux.Unlock()
+	for _, committee := range committees {
+		for _, b := range committee.Blocks {
+			leader := "-"
+			if len(b.Leader) > 8 {
+				leader = b.Leader[:8]
+			}
+			lines = append(lines, fmt.Sprintf("committee %s height %d leader %s txs %d sigs %d", committee.ID[:8], b.Height, leader, b.Txs, b.Signatures))
+		}
+	}
+	return lines
+}
+
+func writeTrace(c *ChainExport, manifest *Manifest, name string) {
+	lines := traceLines(c.committees(), manifest)
+	if ifErr(os.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"), 0644), "trace") {
+		return
+	}
+	coordinatorLog.infof(nil, "Wrote the trace of %d blocks to %s", len(lines)-1, name)
+}
+
+// a read trace, the blocks of a committee by height, a fork has more than one
+type Trace struct {
+	header string
+	blocks map[string]map[uint][]string
+	top    map[string]uint
+}
+
+func readTrace(name string) *Trace {
+	f, err := os.Open(name)
+	ifErrFatal(err, "reading trace "+name)
+	defer f.Close()
+	t := &Trace{blocks: make(map[string]map[uint][]string), top: make(map[string]uint)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, traceHeader) {
+			t.header = line
+			continue
+		}
+		var committee string
+		var height uint
+		if _, err := fmt.Sscanf(line, "committee %s height %d", &committee, &height); err != nil {
+			errFatal(err, "trace line "+line+" of "+name)
+		}
+		if t.blocks[committee] == nil {
+			t.blocks[committee] = make(map[uint][]string)
+		}
+		t.blocks[committee][height] = append(t.blocks[committee][height], line)
+		if height > t.top[committee] {
+			t.top[committee] = height
+		}
+	}
+	ifErrFatal(scanner.Err(), "reading trace "+name)
+	return t
+}
+
+// the fields of the blocks at a height, a fork has more than one block
+func traceFields(lines []string, fields []string) string {
+	blocks := []string{}
+	for _, line := range lines {
+		words := strings.Fields(line)
+		values := []string{}
+		for i := 0; i+1 < len(words); i += 2 {
+			for _, f := range fields {
+				if words[i] == f {
+					values = append(values, words[i]+" "+words[i+1])
+				}
+			}
+		}
+		blocks = append(blocks, strings.Join(values, " "))
+	}
+	return strings.Join(blocks, " | ")
+}
+
+// the differences of run from golden in fields
+func (golden *Trace) diff(run *Trace, fields []string) []string {
+	diffs := []string{}
+	if golden.header != run.header {
+		diffs = append(diffs, fmt.Sprintf("run: golden %q, now %q", golden.header, run.header))
+	}
+	committees := []string{}
+	for c := range golden.blocks {
+		committees = append(committees, c)
+	}
+	for c := range run.blocks {
+		if _, ok := golden.blocks[c]; !ok {
+			diffs = append(diffs, "committee "+c+" is not in the golden trace")
+		}
+	}
+	sort.Strings(committees)
+	for _, c := range committees {
+		if _, ok := run.blocks[c]; !ok {
+			diffs = append(diffs, "committee "+c+" is missing")
+			continue
+		}
+		top := golden.top[c]
+		if run.top[c] < top {
+			top = run.top[c]
+		}
+		for h := uint(0); h <= top; h++ {
+			if traceFields(golden.blocks[c][h], fields) != traceFields(run.blocks[c][h], fields) {
+				want, got := strings.Join(golden.blocks[c][h], " | "), strings.Join(run.blocks[c][h], " | ")
+				diffs = append(diffs, fmt.Sprintf("golden %s\n  now    %s", want, got))
+			}
+		}
+	}
+	return diffs
+}
+
+func traceDiff(flagArgs *FlagArgs) {
+	if flagArgs.goldenTrace == "" || flagArgs.runTrace == "" {
+		errFatal(nil, "-function tracediff needs -goldenTrace and -runTrace")
+	}
+	golden, run := readTrace(flagArgs.goldenTrace), readTrace(flagArgs.runTrace)
+	diffs := golden.diff(run, strings.Split(flagArgs.traceFields, ","))
+	committees := []string{}
+	for c := range golden.top {
+		committees = append(committees, c)
+	}
+	sort.Strings(committees)
+	for _, c := range committees {
+		log.Printf("[Trace] committee %s golden up to height %d, now %d\n", c, golden.top[c], run.top[c])
+	}
+	for i, d := range diffs {
+		if i == default_traceDiffs {
+			log.Printf("[Trace] %d more differences\n", len(diffs)-i)
+			break
+		}
+		log.Printf("[Trace] %s\n", d)
+	}
+	if len(diffs) > 0 {
+		os.Exit(1)
+	}
+	log.Printf("[Trace] same as the golden trace\n")
+}
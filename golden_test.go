@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+// the hashes of fixed blocks, bump canonicalVersion when they change on purpose
+
+// the fixed blocks of the golden vectors
+func goldenPub(b byte) *PubKey {
+	p := new(PubKey)
+	for i := range p.Bytes {
+		p.Bytes[i] = b
+	}
+	return p
+}
+
+func goldenHash(b byte) [32]byte {
+	return hash([]byte{b})
+}
+
+func goldenTransaction() *Transaction {
+	t := new(Transaction)
+	t.Inputs = []*InTx{
+		{TxHash: goldenHash(1), N: 0, Sig: &Sig{R: big.NewInt(11), S: big.NewInt(12)}},
+		{TxHash: goldenHash(2), N: 3, Sig: &Sig{Raw: []byte{13, 14, 15, 16}}},
+	}
+	t.Outputs = []*OutTx{
+		{Value: 10, N: 0, PubKey: goldenPub(0xaa)},
+		{Value: 5, N: 1, PubKey: goldenPub(0xbb)},
+	}
+	t.setHash()
+	return t
+}
+
+func goldenProposedBlock() *ProposedBlock {
+	b := new(ProposedBlock)
+	b.PreviousGossipHash = goldenHash(3)
+	b.Iteration = 7
+	b.CommitteeID = goldenHash(4)
+	b.LeaderPub = goldenPub(0xcc)
+	b.Transactions = []*Transaction{goldenTransaction()}
+	b.MerkleRoot = toByte32(createMerkleTree(nil, b.Transactions).Root())
+	b.setHash()
+	b.LeaderSig = &Sig{R: big.NewInt(21), S: big.NewInt(22)}
+	return b
+}
+
+func goldenFinalBlock() *FinalBlock {
+	b := new(FinalBlock)
+	b.ProposedBlock = goldenProposedBlock()
+	for i := byte(0); i < 3; i++ {
+		cMsg := &ConsensusMsg{GossipHash: b.ProposedBlock.GossipHash, Tag: "accept", Pub: goldenPub(0xd0 + i), Sig: &Sig{R: big.NewInt(int64(31 + i)), S: big.NewInt(int64(41 + i))}}
+		b.Signatures = append(b.Signatures, cMsg)
+	}
+	return b
+}
+
+type goldenVector struct {
+	name string
+	got  func() [32]byte
+	want string
+}
+
+var goldenVectors = []goldenVector{
+	{"tx.calculateHash", func() [32]byte { return goldenTransaction().calculateHash() },
+		"16b41d68c2c1360a77857ffe40d1de2bedc8b09004bbdc0db80e8009c1b87c5a"},
+	{"block.calculateHashExceptMerkleRoot", func() [32]byte { return goldenProposedBlock().calculateHashExceptMerkleRoot() },
+		"3d971c6ca1f49aafa45ea55915d6416712260659a73d5308db9e87dbfc1ea6c1"},
+	{"block.MerkleRoot", func() [32]byte { return goldenProposedBlock().MerkleRoot },
+		"04f52e3c9fcd023493cf4490de6e4ac30bcd2d67c145d545ec9e77dde77b5a04"},
+	{"block.calculateHash", func() [32]byte { return goldenProposedBlock().calculateHash() },
+		"bdc0f39024ed1e9d6e3d34ad92da456b9d493709f7b738331466eb80220b6364"},
+	{"block.canonicalBytes", func() [32]byte { return hash(goldenProposedBlock().canonicalBytes()) },
+		"2893d69f06b21a0a783077677ad5613c1412927fdf8bcb5a0d2f4a3bca88723b"},
+	{"finalblock.calculateHash", func() [32]byte { return goldenFinalBlock().calculateHash() },
+		"466f42c89872f462839f95516bce6251d84933ad4e4d0efa4339339cc9fa6a2a"},
+}
+
+func TestGoldenVectors(t *testing.T) {
+	// the vectors are of sha256
+	defer setHashFunction(hashFunction)
+	setHashFunction(hashSHA256)
+	for _, v := range goldenVectors {
+		if got := bytes32ToString(v.got()); got != v.want {
+			t.Errorf("%s changed\n\twant %s\n\tgot  %s", v.name, v.want, got)
+		}
+	}
+}
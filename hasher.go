@@ -0,0 +1,33 @@
+package main
+
+import (
+	"crypto/sha256"
+
+	"lukechampine.com/blake3"
+)
+
+// the hash functions of -hash
+
+const (
+	hashSHA256 = "sha256"
+	hashBLAKE3 = "blake3"
+)
+
+var hashFunction string = hashSHA256
+
+// the function behind hash()
+var hashSum func(msg []byte) [32]byte = sha256.Sum256
+
+func isHashFunction(name string) bool {
+	return name == hashSHA256 || name == hashBLAKE3
+}
+
+func setHashFunction(name string) {
+	switch name {
+	case hashBLAKE3:
+		hashSum = blake3.Sum256
+	default:
+		hashSum = sha256.Sum256
+	}
+	hashFunction = name
+}
@@ -6,15 +6,32 @@ import (
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
-	"log"
 	"math/big"
 	"math/rand"
 	"sort"
+	"sync"
 )
 
+// pool of buffers used when serializing on the hot path (tx hashing, encoding)
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	bufPool.Put(buf)
+}
+
 func ifErr(e interface{}, msg string) bool {
 	if e != nil {
-		log.Printf("[Error] msg(%s) error (%s)", msg, e)
+		nodeLog.errorf(nil, "msg(%s) error(%s)", msg, e)
 		return true
 	}
 	return false
@@ -22,19 +39,32 @@ func ifErr(e interface{}, msg string) bool {
 
 func ifErrFatal(e interface{}, msg string) bool {
 	if e != nil {
-		log.Fatalf("[Fatal] msg(%s) error(%s)", msg, e)
-		panic(e)
+		errFatal(e, msg)
 		return true
 	}
 	return false
 }
 
 func errr(e interface{}, msg string) {
-	log.Printf("[Error] msg(%s) error(%s)", msg, e)
+	nodeLog.errorf(nil, "msg(%s) error(%s)", msg, e)
+}
+
+// true in the processes that run nodes under a supervisor, errFatal panics there and the
+// supervisor of the goroutine decides, instead of ending the process
+var supervised bool
+
+// the panic of errFatal in a supervised process
+type fatalError struct {
+	msg string
 }
 
+func (e fatalError) Error() string { return e.msg }
+
 func errFatal(e interface{}, msg string) {
-	log.Fatalf("[Fatal] msg(%s) error: (%s)", msg, e)
+	if supervised {
+		panic(fatalError{fmt.Sprintf("msg(%s) error(%s)", msg, e)})
+	}
+	nodeLog.fatalf(nil, "msg(%s) error(%s)", msg, e)
 	panic(e)
 }
 
@@ -46,8 +76,9 @@ func notOkErr(ok bool, msg string) {
 
 func randIndexesWithoutReplacement(arrayLength, sampleSize int) []int {
 	// Sample a list of sampleSize random indexes in an array of length arrayLength
-	// record indexes here to prevent duplicates
+	// record indexes here to prevent duplicates, keys in the order they were drawn
 	indexes := make(map[int]bool)
+	keys := make([]int, 0, sampleSize)
 
 	// create n random indexes
 	for i := 0; i < sampleSize; i++ {
@@ -61,11 +92,7 @@ func randIndexesWithoutReplacement(arrayLength, sampleSize int) []int {
 		}
 
 		indexes[r] = true
-	}
-
-	keys := make([]int, 0, len(indexes))
-	for k := range indexes {
-		keys = append(keys, k)
+		keys = append(keys, r)
 	}
 
 	return keys
@@ -73,11 +100,15 @@ func randIndexesWithoutReplacement(arrayLength, sampleSize int) []int {
 
 func getBytes(thing interface{}) []byte {
 	// return a byte array of anyting
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
+	buf := getBuffer()
+	defer putBuffer(buf)
+	enc := gob.NewEncoder(buf)
 	err := enc.Encode(thing)
 	ifErrFatal(err, "getBytes enc")
-	return buf.Bytes()
+	// copy out since the buffer is returned to the pool
+	b := make([]byte, buf.Len())
+	copy(b, buf.Bytes())
+	return b
 }
 
 func byteSliceAppend(b ...[]byte) []byte {
@@ -1,10 +1,8 @@
 package main
 
 import (
-	"fmt"
-	"log"
 	"reflect"
-	"time"
+	"sync"
 
 	"github.com/klauspost/reedsolomon"
 	"github.com/renzhf/go-merkletree"
@@ -24,13 +22,44 @@ func IDAGossip(nodeCtx *NodeCtx, msg []byte, typ string) [32]byte {
 	// Therefor we add a last datashard to contain the rest of the msg.
 	// if msg can be evenly divded, then the last data shard is simply zero bytes
 
-	// log to coordinator that we are initiating ida gossip process
-	bat := new(ByteArrayAndTimestamp)
-	battmp := hash(msg)
-	bat.B = battmp[:]
-	bat.T = time.Now()
-	go dialAndSendToCoordinator("start_ida_gossip", bat)
+	nodeCtx.events.publish(nodeCtx, IdaStartedEvent{hash(msg)})
 
+	data, proofs, root32 := idaEncode(nodeCtx, msg)
+	kappa, parity := default_kappa, default_parity
+
+	// gossip (kappa+parity)/d data chuncks (with proofs) to each neighbour.
+	addrs := nodeCtx.neighborAddrs()
+	var chunksToEach = (kappa + parity) / len(addrs)
+	if (kappa+parity)%len(addrs) != 0 {
+		idaLog.warnf(nodeCtx, "chunks %d, doesnt divide evenly among %d neighbours", (kappa + parity), len(addrs))
+	}
+
+	msgs := make([]Msg, len(addrs))
+
+	ii := 0
+	total_chunks := 0
+	for i := 0; i < len(addrs)*chunksToEach; i += chunksToEach {
+		chunks := data[i : i+chunksToEach]
+		//fmt.Println("\n\n", chunks)
+		total_chunks += len(chunks)
+		proofs := proofs[i : i+chunksToEach]
+		msgs[ii] = Msg{"IDAGossipMsg", IDAGossipMsg{typ, chunks, proofs, root32}, nodeCtx.self.Priv.Pub}
+		ii += 1
+	}
+	// log.Println("Creating: Len of chunks ", total_chunks, chunksToEach, len(msgs))
+
+	// send each msg to node
+	for i, msgToNode := range msgs {
+		// fmt.Println("neig", nodeCtx.committee.Members[nodeCtx.neighbors[i]])
+		// fmt.Println("neigg", nodeCtx.neighbors)
+		// fmt.Println("neiggg", nodeCtx.neighbors[i])
+		dialAndSend(addrs[i], msgToNode)
+	}
+	return root32
+}
+
+// splits msg into the kappa+parity reed solomon chunks of ida and the proofs of their merkle tree
+func idaEncode(nodeCtx *NodeCtx, msg []byte) ([][]byte, []*merkletree.Proof, [32]byte) {
 	// initate some static variables
 	// TODO: dynamicly create these
 	var phi float64 = default_phi
@@ -42,8 +71,7 @@ func IDAGossip(nodeCtx *NodeCtx, msg []byte, typ string) [32]byte {
 	// log.Println("Paritiy: ", parity)
 
 	// build reed solomon chunks
-	enc, err := reedsolomon.New(kappa, parity)
-	ifErrFatal(err, "createing reedsolomon")
+	enc := idaCoder()
 
 	data := make([][]byte, kappa+parity)
 
@@ -64,7 +92,7 @@ func IDAGossip(nodeCtx *NodeCtx, msg []byte, typ string) [32]byte {
 		notOkErr(okk, "msg was not padded?")
 		unpadded := unPad(msg)
 		if !reflect.DeepEqual(unpadded, originalMsg) {
-			fmt.Println(unpadded, "\n\n", originalMsg)
+			idaLog.debugf(nodeCtx, "unpadded %v, original %v", unpadded, originalMsg)
 			errFatal(nil, "unpadded was not equal to orgiinal msg")
 		}
 
@@ -85,13 +113,13 @@ func IDAGossip(nodeCtx *NodeCtx, msg []byte, typ string) [32]byte {
 		data[i] = msg[i*chunkSize : (i+1)*chunkSize]
 	}
 
-	err = enc.Encode(data)
+	err := enc.Encode(data)
 	ifErrFatal(err, "encoding reedsolomon")
 
 	ok, err := enc.Verify(data)
 	ifErrFatal(err, "reedsolomon shard sizes not equal")
 	if !ok {
-		log.Fatal("[Error] Reed solomon codes not ok: ", ok)
+		idaLog.fatalf(nodeCtx, "Reed solomon codes not ok: %v", ok)
 	}
 
 	// create merkle tree over data:
@@ -110,40 +138,11 @@ func IDAGossip(nodeCtx *NodeCtx, msg []byte, typ string) [32]byte {
 		ifErrFatal(err, "generating proof")
 		// fmt.Println(i, proofs[i].Index, proofs[i], "\n", data[i], "\n\n")
 		if proofs[i].Index != uint64(i) {
-			fmt.Println(proofs[i].Index, i)
+			idaLog.debugf(nodeCtx, "proof index %d of chunk %d", proofs[i].Index, i)
 			errFatal(nil, "Proof index not the same as index")
 		}
 	}
-
-	// gossip (kappa+parity)/d data chuncks (with proofs) to each neighbour.
-	var chunksToEach = (kappa + parity) / len(nodeCtx.neighbors)
-	if (kappa+parity)%len(nodeCtx.neighbors) != 0 {
-		log.Printf("chunks %d, doesnt divide evenly among %d neighbours", (kappa + parity), len(nodeCtx.neighbors))
-	}
-
-	msgs := make([]Msg, len(nodeCtx.neighbors))
-
-	ii := 0
-	total_chunks := 0
-	for i := 0; i < len(nodeCtx.neighbors)*chunksToEach; i += chunksToEach {
-		chunks := data[i : i+chunksToEach]
-		//fmt.Println("\n\n", chunks)
-		total_chunks += len(chunks)
-		proofs := proofs[i : i+chunksToEach]
-		msgs[ii] = Msg{"IDAGossipMsg", IDAGossipMsg{typ, chunks, proofs, root32}, nodeCtx.self.Priv.Pub}
-		ii += 1
-	}
-	// log.Println("Creating: Len of chunks ", total_chunks, chunksToEach, len(msgs))
-
-	// send each msg to node
-	for i, msgToNode := range msgs {
-		// fmt.Println("neig", nodeCtx.committee.Members[nodeCtx.neighbors[i]])
-		// fmt.Println("neigg", nodeCtx.neighbors)
-		// fmt.Println("neiggg", nodeCtx.neighbors[i])
-		var addr string = nodeCtx.committee.Members[nodeCtx.neighbors[i]].IP
-		dialAndSend(addr, msgToNode)
-	}
-	return root32
+	return data, proofs, root32
 }
 
 /*
@@ -168,30 +167,19 @@ func handleIDAGossipMsg(
 	}
 
 	// check that IDA messages was correct
-	if len(idaMsg.Chunks) != len(idaMsg.Proofs) {
-		errr(nil, "number of proofs not matching amount of chunks")
+	if !verifyIDAChunks(idaMsg) {
 		return false
 	}
 
-	for i, _ := range idaMsg.Chunks {
-		root := make([]byte, 32)
-		for i, elem := range idaMsg.MerkleRoot {
-			root[i] = elem
-		}
-		verified, err := merkletree.VerifyProof(idaMsg.Chunks[i], false, idaMsg.Proofs[i], [][]byte{root})
-		fail := ifErr(err, "merkletree.Verifyproof")
-		if fail || !verified {
-			errr(nil, "chunk could not be verified")
-			return false
-		}
-	}
-
 	// check if merkleRoot is new
 	nodeCtx.idaMsgs.mux.Lock()
 	if ok := nodeCtx.idaMsgs._isArr(idaMsg.MerkleRoot); !ok {
 		nodeCtx.idaMsgs._add(idaMsg.MerkleRoot, idaMsg)
 		nodeCtx.idaMsgs.mux.Unlock()
-		gossipSend(idaMsg, nodeCtx)
+		nodeCtx.events.publish(nodeCtx, ChunkReceivedEvent{idaMsg.MerkleRoot})
+		if nodeCtx.byzantine.chunk(nodeCtx, &idaMsg) {
+			gossipSend(idaMsg, nodeCtx)
+		}
 	} else {
 		nodeCtx.idaMsgs.mux.Unlock()
 		// check if the message is unique
@@ -229,19 +217,18 @@ func handleIDAGossipMsg(
 				}
 			}
 
-			enc, err := reedsolomon.New(default_kappa, default_parity)
-			ifErrFatal(err, "reedsolomon encoder creation")
-
 			// now we can recreate the message
-			err = enc.Reconstruct(data)
-			if err != nil {
-				fmt.Println(data)
-				fmt.Println(len(data))
+			if err := idaReconstruct(data); err != nil {
+				idaLog.debugf(nodeCtx, "chunks %v", data)
+				idaLog.debugf(nodeCtx, "%d chunks", len(data))
 				for i, d := range data {
-					fmt.Println(i, bytesToString(d))
+					idaLog.debugf(nodeCtx, "chunk %d %s", i, bytesToString(d))
 				}
-				fmt.Println("Len of chunks: ", nodeCtx.idaMsgs._getLenOfChunks(idaMsg.MerkleRoot))
-				ifErrFatal(err, "Could not reconstruct data")
+				idaLog.debugf(nodeCtx, "Len of chunks: %d", nodeCtx.idaMsgs._getLenOfChunks(idaMsg.MerkleRoot))
+				// chunks of different sizes with valid proofs come from a byzantine sender
+				nodeCtx.idaMsgs.mux.Unlock()
+				errr(err, "Could not reconstruct data")
+				return false
 			}
 
 			// add IDAMsg to check that we don't allready have a msg for this root
@@ -253,9 +240,8 @@ func handleIDAGossipMsg(
 				// log.Println("Message succesfully recreated and added")
 
 				// send success message to coordinator
-				msg := Msg{"IDASuccess", idaMsg.MerkleRoot, nodeCtx.self.Priv.Pub}
-				go dialAndSend(coord+":8080", msg)
-				go gossipSend(idaMsg, nodeCtx)
+				sendStat(nodeCtx, Msg{"IDASuccess", idaMsg.MerkleRoot, nodeCtx.self.Priv.Pub})
+				spawn(func() { gossipSend(idaMsg, nodeCtx) })
 
 				return true
 			}
@@ -263,15 +249,62 @@ func handleIDAGossipMsg(
 			nodeCtx.idaMsgs.mux.Unlock()
 		}
 
-		go gossipSend(idaMsg, nodeCtx)
+		spawn(func() { gossipSend(idaMsg, nodeCtx) })
 
 	}
 	return false
 }
 
+// the reed solomon coder of the chunks, creating one takes longer than coding a block with it
+var idaCoderOnce struct {
+	enc  reedsolomon.Encoder
+	once sync.Once
+}
+
+func idaCoder() reedsolomon.Encoder {
+	idaCoderOnce.once.Do(func() {
+		enc, err := reedsolomon.New(default_kappa, default_parity)
+		ifErrFatal(err, "reedsolomon encoder creation")
+		idaCoderOnce.enc = enc
+	})
+	return idaCoderOnce.enc
+}
+
+// fills in the missing chunks of data from the ones there are, at least kappa
+func idaReconstruct(data [][]byte) error {
+	return idaCoder().Reconstruct(data)
+}
+
+// the chunks of the msg are in the tree of its merkle root
+func verifyIDAChunks(idaMsg IDAGossipMsg) bool {
+	if len(idaMsg.Chunks) != len(idaMsg.Proofs) {
+		errr(nil, "number of proofs not matching amount of chunks")
+		return false
+	}
+
+	for i, _ := range idaMsg.Chunks {
+		root := make([]byte, 32)
+		for i, elem := range idaMsg.MerkleRoot {
+			root[i] = elem
+		}
+		verified, err := merkletree.VerifyProof(idaMsg.Chunks[i], false, idaMsg.Proofs[i], [][]byte{root})
+		fail := ifErr(err, "merkletree.Verifyproof")
+		if fail || !verified {
+			errr(nil, "chunk could not be verified")
+			return false
+		}
+	}
+	return true
+}
+
 func gossipSend(msg IDAGossipMsg, nodeCtx *NodeCtx) {
+	defer nodeCtx.instance.catchPanic()
+	if nodeCtx.stopped.get() {
+		return
+	}
 	// If we do not have enough chunks then gossip the message to all neighbours
-	msgs := make([]Msg, len(nodeCtx.neighbors))
+	addrs := nodeCtx.neighborAddrs()
+	msgs := make([]Msg, len(addrs))
 
 	for i := range msgs {
 		msgs[i] = Msg{"IDAGossipMsg", msg, nodeCtx.self.Priv.Pub}
@@ -282,8 +315,7 @@ func gossipSend(msg IDAGossipMsg, nodeCtx *NodeCtx) {
 		// fmt.Println("neig", nodeCtx.committee.Members[nodeCtx.neighbors[i]])
 		// fmt.Println("neigg", nodeCtx.neighbors)
 		// fmt.Println("neiggg", nodeCtx.neighbors[i])
-		var addr string = nodeCtx.committee.Members[nodeCtx.neighbors[i]].IP
-		//log.Printf("addr: %s\n", addr)
-		go dialAndSend(addr, msg)
+		//log.Printf("addr: %s\n", addrs[i])
+		spawnDialAndSend(addrs[i], msg)
 	}
 }
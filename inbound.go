@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// the checks of a msg from a peer before its handler gets it
+
+// the payload types the node handles by message type, nil is a request without a payload
+var nodeInbound = map[string][]interface{}{
+	"IDAGossipMsg":                      {IDAGossipMsg{}},
+	"consensus":                         {ConsensusMsg{}},
+	"vrf_claim":                         {VrfClaim{}},
+	"drg_commit":                        {DrgCommit{}},
+	"drg_commits":                       {DrgResult{}},
+	"drg_reveal":                        {DrgReveal{}},
+	"drg_result":                        {DrgResult{}},
+	"equivocation_proof":                {EquivocationProof{}},
+	"committee_msg":                     {CommitteeMsg{}},
+	"find_node":                         {KademliaFindNodeMsg{}},
+	"transaction":                       {Transaction{}},
+	"crosstransaction":                  {Transaction{}},
+	"crosstransactionresponse":          {Transaction{}},
+	"request_block":                     {uint64(0)},
+	"request_blocks":                    {RequestBlocksMsg{}},
+	"request_snapshot":                  nil,
+	"request_state_hash":                nil,
+	"request_reconfiguration_blocks":    nil,
+	"node_leave":                        {Leave{}},
+	"churn_kill":                        {uint(0)},
+	"node_join":                         {NodeAllInfo{}, JoinCertificate{}},
+	"reconfiguration_sig":               {RecBlockSig{}},
+	"certified_reconfiguration":         {CertifiedReconfiguration{}},
+	"request_certified_reconfiguration": {uint(0)},
+	"request_join_challenge":            nil,
+	"join_request":                      {JoinRequest{}},
+	"request_inclusion_proof":           {[32]byte{}},
+	"profile":                           {ProfileRequest{}},
+	"flight_dump":                       nil,
+	"fault":                             {Fault{}},
+	"tune":                              {Tuning{}},
+	"coordinator_takeover":              {""},
+	"control":                           {ControlMsg{}},
+}
+
+// the payload types of the stats and requests the coordinator handles
+var coordinatorInbound = map[string][]interface{}{
+	"stats_batch":              {StatsBatch{}},
+	"IDASuccess":               {[32]byte{}},
+	"consensus":                {ByteArrayAndTimestamp{}},
+	"finalblock":               {FinalBlock{}},
+	"pocverify":                {time.Duration(0)},
+	"pocadd":                   {time.Duration(0)},
+	"routetx":                  {ByteArrayAndTimestamp{}},
+	"find_node":                {ByteArrayAndTimestamp{}},
+	"transaction_recieved":     {ByteArrayAndTimestamp{}},
+	"start_ida_gossip":         {ByteArrayAndTimestamp{}},
+	"reconstructed_ida_gossip": {ByteArrayAndTimestamp{}},
+	"consensus_accept_fail":    {ByteArrayAndTimestamp{}},
+	"orphan_stats":             {ByteArrayAndTimestamp{}},
+	"tx_receipts":              {TxReceiptBatch{}},
+	"admission_stats":          {""},
+	"fork":                     {ForkReport{}},
+	"request_genesis":          {[32]byte{}},
+	"bootstrap":                {""},
+	"compression":              {""},
+	"block_cache":              {""},
+	"drg":                      {""},
+	"pow":                      {""},
+	"sig_cache":                {""},
+	"reconfiguration":          {ReconfigurationBlock{}},
+	"pow_challenge":            nil,
+	"run_seed":                 nil,
+	"clock":                    nil,
+	"join":                     {Node_InitialMessageToCoordinator{}},
+	"join_report":              {""},
+	"request_reconfiguration":  {EpochRequest{}},
+	"request_reference":        nil,
+	"node_join":                {JoinCertificate{}},
+	"leave":                    {Leave{}},
+	"switch":                   {""},
+	"tuning":                   {""},
+	"rejoin":                   nil,
+	"churn":                    {""},
+	"committee_view":           {CommitteeView{}},
+	"latency_report":           {LatencyReport{}},
+	"resource_sample":          {ResourceSample{}},
+	"wire_stats":               {WireReport{}},
+	"block_phases":             {BlockPhases{}},
+	"equivocation":             {EquivocationProof{}},
+	"fault_hit":                {FaultHit{}},
+}
+
+// the ids of the stats are at most 32 bytes, the fixed rows have their length
+var coordinatorStatLengths = map[string][2]int{
+	"routetx":                  {0, 32},
+	"transaction_recieved":     {0, 32},
+	"start_ida_gossip":         {0, 32},
+	"reconstructed_ida_gossip": {0, 32},
+	"find_node":                {64, 64},
+	"consensus_accept_fail":    {88, 88},
+	"orphan_stats":             {64, 64},
+}
+
+var idaGossipTypes = map[string]bool{"tx": true, "genesis": true, "reconfiguration": true, "block": true}
+
+// why msg can not go to the handler of its type in inbound, "" if it can
+func checkInbound(inbound map[string][]interface{}, msg *Msg) string {
+	types, ok := inbound[msg.Typ]
+	if !ok {
+		return "unknown type"
+	}
+	if types != nil {
+		known := false
+		for _, t := range types {
+			known = known || reflect.TypeOf(msg.Msg) == reflect.TypeOf(t)
+		}
+		if !known {
+			return fmt.Sprintf("payload %T", msg.Msg)
+		}
+	}
+	switch m := msg.Msg.(type) {
+	case IDAGossipMsg:
+		if !idaGossipTypes[m.Typ] {
+			return "ida type " + m.Typ
+		}
+		if len(m.Chunks) != len(m.Proofs) {
+			return "chunks without proofs"
+		}
+		for _, p := range m.Proofs {
+			if p == nil || p.Index >= default_kappa+default_parity {
+				return "chunk index out of range"
+			}
+		}
+	case ConsensusMsg:
+		if m.Pub == nil || msg.FromPub == nil {
+			return "consensus without a key"
+		}
+	case Transaction:
+		if msg.Typ == "transaction" && m.Hash == [32]byte{} {
+			return "transaction without hash"
+		}
+		for _, in := range m.Inputs {
+			if in == nil {
+				return "nil input"
+			}
+		}
+		for _, out := range m.Outputs {
+			if out == nil {
+				return "nil output"
+			}
+		}
+	case Node_InitialMessageToCoordinator:
+		if m.Pub == nil {
+			return "join without a key"
+		}
+	case JoinCertificate:
+		if m.Info.Pub == nil {
+			return "join certificate without a key"
+		}
+	case FinalBlock:
+		if m.ProposedBlock == nil {
+			return "final block without block"
+		}
+	case ByteArrayAndTimestamp:
+		if l, ok := coordinatorStatLengths[msg.Typ]; ok && (len(m.B) < l[0] || len(m.B) > l[1]) {
+			return fmt.Sprintf("%d bytes", len(m.B))
+		}
+	case StatsBatch:
+		for i := range m.Msgs {
+			if m.Msgs[i].Typ == "stats_batch" {
+				return "batch in a batch"
+			}
+			if reason := checkInbound(inbound, &m.Msgs[i]); reason != "" {
+				return "batched " + m.Msgs[i].Typ + ": " + reason
+			}
+		}
+	}
+	return ""
+}
+
+// decodes the next msg of dec and checks it
+func decodeInbound(inbound map[string][]interface{}, dec *gob.Decoder) (*Msg, error) {
+	msg := new(Msg)
+	if err := dec.Decode(msg); err != nil {
+		return nil, err
+	}
+	if reason := checkInbound(inbound, msg); reason != "" {
+		return nil, fmt.Errorf("%s: %s", msg.Typ, reason)
+	}
+	return msg, nil
+}
+
+func decodeTransaction(b []byte) (*Transaction, error) {
+	t := new(Transaction)
+	err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(t)
+	return t, err
+}
+
+func decodeProposedBlock(b []byte) (*ProposedBlock, error) {
+	block := new(ProposedBlock)
+	err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(block)
+	return block, err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/renzhf/go-merkletree"
+)
+
+// Proof that a transaction is included in a block. It only needs the header fields of the block,
+// so light clients and other committees can check inclusion without the transactions of the block.
+type TxInclusionProof struct {
+	TxID             [32]byte
+	Position         uint     // index of tx in block
+	GossipHash       [32]byte // hash of block
+	IntermediateHash [32]byte // hash of header except merkle root
+	MerkleRoot       [32]byte
+	MerkleProof      *merkletree.Proof
+}
+
+// creates the inclusion proof of the transaction at position i, tree is the merkle tree of the block transactions
+func txInclusionProofFromTree(block *ProposedBlock, tree *merkletree.MerkleTree, intermediate [32]byte, i int) (*TxInclusionProof, error) {
+	proof, err := tree.GenerateProofUsingIndex(uint64(i), 0)
+	if err != nil {
+		return nil, err
+	}
+	p := new(TxInclusionProof)
+	p.TxID = block.Transactions[i].ifOrigRetOrigIfNotRetHash()
+	p.Position = uint(i)
+	p.GossipHash = block.GossipHash
+	p.IntermediateHash = intermediate
+	p.MerkleRoot = block.MerkleRoot
+	p.MerkleProof = proof
+	return p, nil
+}
+
+// creates the inclusion proof of the transaction with id txID, returns nil if it is not in the block
+func createTxInclusionProof(nodeCtx *NodeCtx, block *ProposedBlock, txID [32]byte) *TxInclusionProof {
+	for i, t := range block.Transactions {
+		if t.ifOrigRetOrigIfNotRetHash() != txID {
+			continue
+		}
+		tree := createMerkleTree(nodeCtx, block.Transactions)
+		p, err := txInclusionProofFromTree(block, tree, block.calculateHashExceptMerkleRoot(), i)
+		if ifErr(err, "generating inclusion proof") {
+			return nil
+		}
+		return p
+	}
+	return nil
+}
+
+// answers a request_inclusion_proof from a light client with the proof of txID from the blocks
+// in memory, or an empty proof if it is not found
+func handleRequestInclusionProof(nodeCtx *NodeCtx, txID [32]byte) TxInclusionProof {
+	nodeCtx.blockchain.mux.Lock()
+	blocks := make([]*ProposedBlock, 0, len(nodeCtx.blockchain.Blocks))
+	for _, b := range nodeCtx.blockchain.Blocks {
+		blocks = append(blocks, b.ProposedBlock)
+	}
+	nodeCtx.blockchain.mux.Unlock()
+
+	for _, block := range blocks {
+		if p := createTxInclusionProof(nodeCtx, block, txID); p != nil {
+			return *p
+		}
+	}
+	return TxInclusionProof{}
+}
+
+// returns if the proof is valid, and the reason if it is not
+func (p *TxInclusionProof) verify() (bool, string) {
+	if p.MerkleProof == nil {
+		return false, "incomplete"
+	}
+	if p.MerkleProof.Index != uint64(p.Position) {
+		return false, "position"
+	}
+	verified, err := merkletree.VerifyProof(p.TxID[:], false, p.MerkleProof, [][]byte{p.MerkleRoot[:]})
+	if err != nil || !verified {
+		return false, "merkle proof"
+	}
+	mrHash := hash(p.MerkleRoot[:])
+	if hash(byteSliceAppend(p.IntermediateHash[:], mrHash[:])) != p.GossipHash {
+		return false, "block hash"
+	}
+	return true, ""
+}
+
+// checks that the merkle root in the header is the root of the transactions in the block
+func (b *ProposedBlock) isMerkleRootCorrect(nodeCtx *NodeCtx) bool {
+	tree := createMerkleTree(nodeCtx, b.Transactions)
+	return toByte32(tree.Root()) == b.MerkleRoot
+}
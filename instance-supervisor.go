@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// the supervisor that keeps a panic of a node from ending the other instances
+
+// runs start for the instance and catches its panics
+func (in *Instance) supervise(start func(*FlagArgs, *Instance), flagArgs *FlagArgs) {
+	defer in.catchPanic()
+	start(flagArgs, in)
+}
+
+// deferred by the goroutines of the node of the instance. A node without an instance, in a harness
+// like fuzz_test.go, still panics on
+func (in *Instance) catchPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	dumpFlightRecorders(fmt.Sprintf("panic: %v", r))
+	if in == nil {
+		panic(r)
+	}
+	buf := make([]byte, 1<<16)
+	in.failed(r, buf[:runtime.Stack(buf, false)])
+}
+
+func (in *Instance) failed(cause interface{}, stack []byte) {
+	in.mux.Lock()
+	setUp, down, restarts := in.nodeCtx != nil, in.down, in.restarts
+	restart := setUp && !down && restarts < in.flagArgs.restarts
+	if restart {
+		in.restarts++
+	}
+	in.mux.Unlock()
+	if !setUp {
+		nodeLog.errorf(nil, "[Supervisor] instance %d failed during its setup, not started again: %v\n%s", in.count, cause, stack)
+		return
+	}
+	// the other goroutines of a node that failed or was killed can still panic
+	if down {
+		nodeLog.warnf(nil, "[Supervisor] instance %d failed while it was down: %v\n%s", in.count, cause, stack)
+		return
+	}
+	backoff := time.Duration(0)
+	if restart {
+		backoff = default_restartBackoff * time.Second << restarts
+	}
+	nodeCtx := in.stop("fail", backoff)
+	if nodeCtx == nil {
+		return
+	}
+	nodeLog.errorf(nodeCtx, "[Supervisor] instance %d failed at iteration %d: %v\n%s", in.count, nodeCtx.i.getI(), cause, stack)
+	if !restart {
+		nodeLog.errorf(nodeCtx, "[Supervisor] instance %d stays down after %d restarts", in.count, restarts)
+		return
+	}
+	nodeLog.warnf(nodeCtx, "[Supervisor] instance %d starts again in %s, restart %d of %d", in.count, backoff, restarts+1, in.flagArgs.restarts)
+	spawn(func() {
+		defer in.catchPanic()
+		clockSleep(backoff)
+		in.respawn()
+	})
+}
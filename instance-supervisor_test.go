@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+// errFatal in a supervised node fails the instance, it does not end the process
+func TestErrFatalSupervised(t *testing.T) {
+	defer func(s bool) { supervised = s }(supervised)
+	supervised = true
+	in := &Instance{count: 0}
+	in.supervise(func(*FlagArgs, *Instance) {
+		errFatal(nil, "test")
+	}, new(FlagArgs))
+	func() {
+		defer func() {
+			if _, ok := recover().(fatalError); !ok {
+				t.Errorf("errFatal without an instance did not panic with a fatalError")
+			}
+		}()
+		var none *Instance
+		defer none.catchPanic()
+		errFatal(nil, "test")
+	}()
+}
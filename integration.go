@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sync"
+)
+
+// a cluster in this process, for demo and the integration test
+
+// the state of the coordinator the integration test looks at, nil when no cluster runs in process
+var integration *IntegrationRun
+
+type IntegrationRun struct {
+	chains   *ChainExport
+	progress *Progress
+	users    *UserSets
+	tracker  *TransactionTracker
+	genesis  uint // coins of the users at the start of the tx generator
+	ready    chan struct{}
+	mux      sync.Mutex
+}
+
+func (ir *IntegrationRun) init() {
+	ir.mux.Lock()
+	defer ir.mux.Unlock()
+	ir.ready = make(chan struct{})
+}
+
+// the coordinator listens for the nodes
+func (ir *IntegrationRun) listening(chains *ChainExport, progress *Progress) {
+	if ir == nil {
+		return
+	}
+	ir.mux.Lock()
+	defer ir.mux.Unlock()
+	ir.chains, ir.progress = chains, progress
+	close(ir.ready)
+}
+
+// the tx generator starts with the genesis coins in users
+func (ir *IntegrationRun) generator(users *UserSets, tracker *TransactionTracker) {
+	if ir == nil {
+		return
+	}
+	ir.mux.Lock()
+	defer ir.mux.Unlock()
+	ir.users, ir.tracker = users, tracker
+	ir.genesis, _ = ir._coins()
+}
+
+// the coins the users hold and the coins of the txs that did not finish
+func (ir *IntegrationRun) _coins() (uint, uint) {
+	ir.users.mux.Lock()
+	defer ir.users.mux.Unlock()
+	ir.tracker.mux.Lock()
+	defer ir.tracker.mux.Unlock()
+	var held, pending uint
+	for _, set := range ir.users.m {
+		held += set.totalValue()
+	}
+	for _, track := range ir.tracker.m {
+		if !track.recived.IsZero() {
+			continue
+		}
+		for _, out := range track.t.Outputs {
+			pending += out.Value
+		}
+	}
+	return held, pending
+}
+
+// launches the coordinator and -n nodes over localhost, returns once the coordinator listens
+func launchLocalCluster(flagArgs *FlagArgs) {
+	integration = new(IntegrationRun)
+	integration.init()
+	coord = coord_local
+	goRecorded(func() { launchCoordinator(flagArgs) })
+	<-integration.ready
+	for i := uint(0); i < flagArgs.n; i++ {
+		in := &Instance{count: i}
+		goRecorded(func() { in.supervise(launchNode, flagArgs) })
+	}
+}
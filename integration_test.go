@@ -0,0 +1,73 @@
+//go:build integration
+// +build integration
+
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+// the coordinator and 8 nodes over localhost, checked after -integrationTime seconds
+
+var integrationTime = flag.Uint("integrationTime", default_integrationTime, "seconds the cluster of the integration test runs before it is checked")
+
+func TestIntegration(t *testing.T) {
+	flagArgs := testFlagArgs(t.TempDir())
+	setResults(flagArgs.resultsDir, "integration")
+	registerGobTypes()
+	initSigCaches(default_sigCache)
+	supervised = true
+
+	launchLocalCluster(flagArgs)
+	t.Logf("%d nodes in %d committees for %d seconds", flagArgs.n, flagArgs.m, *integrationTime)
+	clockSleep(time.Duration(*integrationTime) * time.Second)
+
+	ir := integration
+	ir.mux.Lock()
+	defer ir.mux.Unlock()
+
+	committees := ir.chains.committees()
+	if len(committees) != int(flagArgs.m) {
+		t.Fatalf("committees: %d of %d have a chain", len(committees), flagArgs.m)
+	}
+	for _, c := range committees {
+		height := uint(0)
+		for _, b := range c.Blocks {
+			if b.Height > height {
+				height = b.Height
+			}
+		}
+		t.Logf("committee %s at height %d", c.ID[:8], height)
+		if height == 0 {
+			t.Fatalf("committee %s has no final block after genesis", c.ID[:8])
+		}
+	}
+
+	ir.progress.mux.Lock()
+	acceptFails, forks, txs := ir.progress.acceptFails, ir.progress.forks, ir.progress.txs
+	ir.progress.mux.Unlock()
+	t.Logf("%d txs finished, %d accept fails, %d forks", txs, acceptFails, forks)
+	if acceptFails > 0 || forks > 0 {
+		t.Fatalf("consensus: %d accept fails, %d forks", acceptFails, forks)
+	}
+
+	if ir.users == nil {
+		t.Fatalf("balances: the tx generator did not start")
+	}
+	// a tx moves its coins from the users to the tracker and back in two steps, so an uneven sum
+	// is only a failure when it stays
+	var held, pending uint
+	for i := 0; i < default_integrationRetries; i++ {
+		held, pending = ir._coins()
+		if held+pending == ir.genesis {
+			break
+		}
+		clockSleep(100 * time.Millisecond)
+	}
+	t.Logf("users hold %d coins, %d in unfinished txs, genesis %d", held, pending, ir.genesis)
+	if held+pending != ir.genesis {
+		t.Fatalf("balances: %d coins of %d", held+pending, ir.genesis)
+	}
+}
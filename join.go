@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -join, nodes that join a running experiment
+
+// view of the coordinator on the nodes, updated by joins and the reconfiguration blocks of the epochs
+type Membership struct {
+	nodes         map[[32]byte]NodeAllInfo
+	rBlock        *ReconfigurationBlock
+	genesisHashes map[[32]byte][32]byte
+	epochs        map[uint]*ReconfigurationBlock // blocks the coordinator produced, see reference.go
+	challenge     PowChallenge
+	runSeed       int64
+	committeeF    uint
+	totalF        uint
+	churn         float64
+	adversary     string
+	corruption    *Corruption // the nodes the adversary corrupts for the next epoch, see adversary.go
+	blacklist     BlacklistReports
+	flagArgs      *FlagArgs // the epoch trigger, see epoch-trigger.go
+	clock         EpochClock
+	builds        BuildCheck
+	mirroring     bool // a standby leaves the announcements to its coordinator, see standby.go
+	mux           sync.Mutex
+}
+
+func (ms *Membership) init(challenge PowChallenge, flagArgs *FlagArgs) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	ms.nodes = make(map[[32]byte]NodeAllInfo)
+	ms.epochs = make(map[uint]*ReconfigurationBlock)
+	ms.challenge = challenge
+	ms.runSeed = flagArgs.runSeed
+	ms.committeeF = flagArgs.committeeF
+	ms.totalF = flagArgs.totalF
+	ms.churn = flagArgs.churn
+	ms.adversary = flagArgs.adversary
+	ms.blacklist.init()
+	ms.flagArgs = flagArgs
+	ms.builds.init(flagArgs)
+}
+
+// the nodes and committees of the setup
+func (ms *Membership) set(nodeInfos []NodeAllInfo, rBlock *ReconfigurationBlock, genesisHashes map[[32]byte][32]byte) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	for _, info := range nodeInfos {
+		ms.nodes[info.Pub.Bytes] = info
+	}
+	ms.rBlock = rBlock
+	ms.genesisHashes = genesisHashes
+	ms._adapt(rBlock)
+	ms.clock.init()
+}
+
+// returns the adversary row of rBlock, "" if there is none
+func (ms *Membership) setReconfiguration(rBlock *ReconfigurationBlock) string {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	for _, c := range rBlock.Committees {
+		for pub := range c.Members {
+			if info, ok := ms.nodes[pub]; ok {
+				info.CommitteeID = c.ID
+				ms.nodes[pub] = info
+			}
+		}
+	}
+	ms.rBlock = rBlock
+	row := ms._adapt(rBlock)
+	ms._checkAdversaries(rBlock)
+	return row
+}
+
+// the committee with the fewest members, the lowest id of those
+func smallestCommittee(rBlock *ReconfigurationBlock) [32]byte {
+	var smallest *Committee
+	for _, c := range rBlock.Committees {
+		if smallest == nil || len(c.Members) < len(smallest.Members) ||
+			(len(c.Members) == len(smallest.Members) && bytes.Compare(c.ID[:], smallest.ID[:]) < 0) {
+			smallest = c
+		}
+	}
+	return smallest.ID
+}
+
+// a copy of rBlock with change applied to a copy of committee, rBlock can be read by others while
+// it is changed. The hash stays the one of the epoch
+func withChangedCommittee(rBlock *ReconfigurationBlock, committeeID [32]byte, change func(c *Committee)) *ReconfigurationBlock {
+	next := *rBlock
+	next.Committees = make(map[[32]byte]*Committee)
+	for id, c := range rBlock.Committees {
+		next.Committees[id] = c
+	}
+	if c, ok := rBlock.Committees[committeeID]; ok {
+		changed := &Committee{ID: c.ID, BigIntID: c.BigIntID, CurrentLeader: c.CurrentLeader, Members: make(map[[32]byte]*CommitteeMember)}
+		for pub, member := range c.Members {
+			changed.Members[pub] = member
+		}
+		change(changed)
+		next.Committees[committeeID] = changed
+	}
+	return &next
+}
+
+func withMember(rBlock *ReconfigurationBlock, committeeID [32]byte, m *CommitteeMember) *ReconfigurationBlock {
+	return withChangedCommittee(rBlock, committeeID, func(c *Committee) { c.addMember(m) })
+}
+
+// admits a node that joins at addr, assigns it a committee and announces it to the other nodes.
+// Returns the setup of the node, without nodes if it is rejected
+func (ms *Membership) join(addr string, req Node_InitialMessageToCoordinator) ResponseToNodes {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	if ms.rBlock == nil || req.Pub == nil {
+		return ResponseToNodes{}
+	}
+	if ms.challenge.Difficulty > 0 && !verifyPow(ms.challenge, req.Pub.Bytes, req.PowNonce) {
+		coordinatorLog.warnf(nil, "Rejected joining node at %s without a valid proof of work", addr)
+		return ResponseToNodes{}
+	}
+	if _, ok := ms.nodes[req.Pub.Bytes]; ok {
+		coordinatorLog.warnf(nil, "Rejected joining node at %s, key %s is already a node", addr, bytes32ToString(req.Pub.Bytes))
+		return ResponseToNodes{}
+	}
+	if reason := ms.builds.admit(addr, req.Build); reason != "" {
+		return ResponseToNodes{Refused: reason}
+	}
+	info := NodeAllInfo{Pub: req.Pub, CommitteeID: smallestCommittee(ms.rBlock), IP: addr, IsHonest: true}
+	for _, n := range ms.nodes {
+		if !ms.mirroring {
+			spawnDialAndSend(n.IP, Msg{"node_join", info, nil})
+		}
+	}
+	ms.nodes[req.Pub.Bytes] = info
+	ms.rBlock = withMember(ms.rBlock, info.CommitteeID, &CommitteeMember{info.Pub, info.IP})
+	ms.clock.change()
+	coordinatorLog.infof(nil, "[Join] %s joined committee %s", addr, bytes32ToString(info.CommitteeID))
+
+	nodes := make([]NodeAllInfo, 0, len(ms.nodes))
+	for _, n := range ms.nodes {
+		nodes = append(nodes, n)
+	}
+	return ResponseToNodes{Nodes: nodes, GenesisHashes: ms.genesisHashes, ReconfigurationBlock: ms.rBlock}
+}
+
+// the address a node listens on, the host it advertises or the one of the connection, with its port
+func nodeAddr(conn net.Conn, host string, port int) string {
+	if host == "" {
+		addr := conn.RemoteAddr().String()
+		host = addr[:strings.LastIndexByte(addr, ':')]
+	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+// after the setup the coordinator only reads Msgs, so joining nodes ask with a Msg of typ instead
+// of req
+func requestFromCoordinator(join bool, typ string, req Node_InitialMessageToCoordinator, answer interface{}) {
+	conn := dial(coordinatorAddr())
+	defer conn.Close()
+	if join {
+		sendMsg(conn, Msg{typ, "", nil})
+	} else {
+		sendMsg(conn, req)
+	}
+	reciveMsg(conn, answer)
+}
+
+// timings of a node that joined, reported once it committed a block
+type JoinReport struct {
+	start     time.Time
+	assigned  time.Duration // has a committee
+	synced    time.Duration // has the state of the committee
+	respawn   bool          // started again after a kill (churn.go), start is the kill
+	recovered bool          // a respawn in a new process of the supervisor, see recovery.go
+	once      sync.Once
+	mux       sync.Mutex
+}
+
+func (j *JoinReport) isSynced() bool {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	return j.synced > 0
+}
+
+// sets up a node that joins a running experiment when the coordinator or the reference committee
+// admitted it. Returns the solution of the puzzle of the reference committee, nil if it is admitted
+// by the coordinator
+func joinSetup(conn net.Conn, portNumber int, privKey *PrivKey, powNonce uint64, nodeCtx *NodeCtx, start time.Time) *PowSolution {
+	nodeCtx.join = &JoinReport{start: start}
+	var pow *PowSolution
+	response := new(ResponseToNodes)
+	if nodeCtx.flagArgs.reference == referenceByCommittee {
+		response, pow = admissionByReference(conn, portNumber, privKey, nodeCtx)
+	} else {
+		sendMsg(conn, Msg{"join", Node_InitialMessageToCoordinator{Pub: privKey.Pub, Port: portNumber, Host: nodeCtx.flagArgs.advertise, PowNonce: powNonce, Build: currentBuild()}, privKey.Pub})
+		reciveMsg(conn, response)
+	}
+	if response.Refused != "" {
+		errFatal(nil, "the coordinator refused the node: "+response.Refused)
+	}
+	if len(response.Nodes) == 0 {
+		errFatal(nil, "join rejected by the coordinator")
+	}
+	nodeCtx.fastSync = true
+	setupFromResponse(nodeCtx, privKey, response)
+	nodeCtx.join.assigned = clockSince(start)
+
+	// the coordinator only knows the committees, the randomness of the epochs comes from the committee
+	blocks := requestReconfigurationBlocks(nodeCtx, response.ReconfigurationBlock.Hash)
+	nodeCtx.blockchain.setReconfigurationBlocks(blocks)
+	nodeCtx.blockchain.addJoinedMember(nodeCtx.committeeID(), &CommitteeMember{privKey.Pub, nodeCtx.self.IP})
+	nodeCtx.drg.init(nodeCtx.blockchain.epoch() + 1)
+	nodeLog.infof(nodeCtx, "Joined committee %s in epoch %d", bytes32ToString(nodeCtx.committeeID()), nodeCtx.blockchain.epoch())
+	return pow
+}
+
+// the reconfiguration blocks of a member of the committee, which have to include the block of the
+// coordinator
+func requestReconfigurationBlocks(nodeCtx *NodeCtx, known [32]byte) []*ReconfigurationBlock {
+	for _, member := range nodeCtx.committee.sortedMembers() {
+		blocks := []*ReconfigurationBlock{}
+		if !requestFrom(member.IP, Msg{"request_reconfiguration_blocks", "", nodeCtx.self.Priv.Pub}, &blocks) {
+			continue
+		}
+		for _, rb := range blocks {
+			if rb.Hash == known {
+				return blocks
+			}
+		}
+		nodeLog.warnf(nodeCtx, "[Join] reconfiguration blocks from %s rejected", member.IP)
+	}
+	errFatal(nil, "no member has the reconfiguration blocks")
+	return nil
+}
+
+// state syncs from the committee. The committee can be in an iteration it started before it knew
+// this node, so we wait until that block is committed and take part from the next iteration
+func joinSync(nodeCtx *NodeCtx) {
+	peers := nodeCtx.committee.sortedMembers()
+	fastSyncFrom(nodeCtx, peers)
+	i := nodeCtx.i.getI()
+	for attempt := 0; nodeCtx.i.getI() <= i; attempt++ {
+		if attempt >= 3*default_drgTimeout {
+			errFatal(nil, "committee did not commit a block after the join")
+		}
+		clockSleep(nodeCtx.delta())
+		syncBlocksFrom(nodeCtx, peers)
+	}
+	nodeCtx.join.mux.Lock()
+	nodeCtx.join.synced = clockSince(nodeCtx.join.start)
+	nodeCtx.join.mux.Unlock()
+}
+
+// adds a node that joined to the committees, and to our committee if it is in it
+func handleNodeJoin(nodeCtx *NodeCtx, info NodeAllInfo) {
+	if info.Pub == nil || info.Pub.Bytes == nodeCtx.self.Priv.Pub.Bytes {
+		return
+	}
+	member := &CommitteeMember{info.Pub, info.IP}
+	allInfo := make(map[[32]byte]NodeAllInfo)
+	for pub, i := range nodeCtx.allInfo {
+		allInfo[pub] = i
+	}
+	allInfo[info.Pub.Bytes] = info
+	nodeCtx.allInfo = allInfo
+	nodeCtx.blockchain.addJoinedMember(info.CommitteeID, member)
+	if info.CommitteeID == nodeCtx.committeeID() {
+		members := make(map[[32]byte]*CommitteeMember)
+		for pub, m := range nodeCtx.committee.Members {
+			members[pub] = m
+		}
+		members[member.Pub.Bytes] = member
+		nodeCtx.setMembers(members)
+		buildCurrentNeighbours(nodeCtx)
+	}
+	buildRoutingTable(nodeCtx, nodeCtx.committeeID(), allInfo)
+	nodeCtx.epochClock.change()
+	nodeLog.infof(nodeCtx, "Node %s joined committee %s", info.IP, bytes32ToString(info.CommitteeID))
+}
+
+// committee,pub,assigned ms,synced ms,useful ms,iteration. A respawn is written to the churn csv
+// with respawn in front
+func (j *JoinReport) useful(nodeCtx *NodeCtx) {
+	j.once.Do(func() {
+		j.mux.Lock()
+		synced := j.synced
+		j.mux.Unlock()
+		s := fmt.Sprintf("%s,%s,%d,%d,%d,%d", bytes32ToString(nodeCtx.committeeID()), bytes32ToString(nodeCtx.self.Priv.Pub.Bytes), j.assigned.Milliseconds(), synced.Milliseconds(), clockSince(j.start).Milliseconds(), nodeCtx.i.getI())
+		if j.recovered {
+			spawnDialAndSendToCoordinator("churn", "recover,"+s)
+			return
+		}
+		if j.respawn {
+			spawnDialAndSendToCoordinator("churn", "respawn,"+s)
+			return
+		}
+		spawnDialAndSendToCoordinator("join_report", s)
+	})
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"math/big"
 	"net"
-	"sync"
+	"strconv"
 )
 
 // todo replace xor operations with these functions
@@ -21,52 +22,75 @@ func xorBigInt(b1, b2 *big.Int) *big.Int {
 
 func txFindClosestCommittee(nodeCtx *NodeCtx, txHash [32]byte) [32]byte {
 	// returns the closest committee
-
-	txInt := toBigInt(txHash)
-
-	// xor all committeList with tx
-	xored := make([]*big.Int, len(nodeCtx.committeeList))
-	for i := range xored {
-		xored[i] = xorBigInt(txInt, toBigInt(nodeCtx.committeeList[i]))
+	// The xor distances are compared bytewise (big endian like toBigInt) so no big.Int is
+	// allocated, this is done several times for every tx.
+
+	var closest [32]byte
+	var closestDist [32]byte
+	for i, c := range nodeCtx.getCommitteeList() {
+		var dist [32]byte
+		for j := range dist {
+			dist[j] = txHash[j] ^ c[j]
+		}
+		if i == 0 || bytes.Compare(dist[:], closestDist[:]) < 0 {
+			closest = c
+			closestDist = dist
+		}
 	}
 
-	//sort xored
-	sortBigIntArr(&xored)
-
-	// lowest distance is now the first elem of the sorted array
-	// xor it back with txInt to get original committee ID
-	lowest := xorBigInt(txInt, xored[0])
-
-	return toByte32(lowest.Bytes())
+	return closest
 }
 
 func routeTx(nodeCtx *NodeCtx, msg Msg, closestCommitteeID [32]byte) {
+	defer nodeCtx.instance.catchPanic()
 	// routes tx
 	// closesCommitteID may or not be in routing table. But it is definitly not ownCommittteeID
+	if !nodeCtx.byzantine.route(nodeCtx, &msg) {
+		return
+	}
+	start := clockNow()
+	msg, span, parent := traceRouting(nodeCtx, msg)
+
+	// the receiving committee only takes it with our membership proof
+	msg = withMembershipProof(nodeCtx, msg)
 
 	// check if closesCommitteeID is in routing table
+	hops := 0
 	r := nodeCtx.routingTable.get()
+	found := false
 	for _, c := range r {
 		if c.ID == closestCommitteeID {
 			// we have it! c
 			sendMsgToCommittee(msg, &c)
-			return
+			found = true
+			break
 		}
 	}
 
 	// closestCommitteeID is not in routing table. Therefor iniate routing
-	findNodeAndSend(nodeCtx, closestCommitteeID, msg)
+	if !found {
+		hops = findNodeAndSend(nodeCtx, closestCommitteeID, msg)
+	}
+	nodeCtx.events.publish(nodeCtx, TxRoutedEvent{clockSince(start), hops})
+	traceSpan(nodeCtx, span, parent, "routing", start, clockNow(), "rapidchain.target", shortID(closestCommitteeID), "rapidchain.hops", strconv.Itoa(hops))
 }
 
-func findClosestsCommittee(nodeCtx *NodeCtx, committeeIDbytes [32]byte) Committee {
+// the committee of our routing table closest to committeeIDbytes, false with a warning if the
+// routing table has none, what a peer can ask for
+func findClosestsCommittee(nodeCtx *NodeCtx, committeeIDbytes [32]byte) (Committee, bool) {
 	// convert to big ints to be able to do bitwise xor operations
+	selfCommitteeIDbytes := nodeCtx.committeeID()
 	selfCommitteeID := new(big.Int)
-	selfCommitteeID.SetBytes(nodeCtx.self.CommitteeID[:])
+	selfCommitteeID.SetBytes(selfCommitteeIDbytes[:])
 	committeeID := new(big.Int)
 	committeeID.SetBytes(committeeIDbytes[:])
 
 	xored := new(big.Int).Xor(selfCommitteeID, committeeID)
 	r := nodeCtx.routingTable.get()
+	if len(r) == 0 {
+		routingLog.warnf(nodeCtx, "dropped find_node of %s, our routing table is empty", bytes32ToString(committeeIDbytes))
+		return Committee{}, false
+	}
 	var closest int = 0
 	// it can't be less the first entry in our routing table since that is our closest neighbor
 	for i := range r {
@@ -78,9 +102,11 @@ func findClosestsCommittee(nodeCtx *NodeCtx, committeeIDbytes [32]byte) Committe
 			// curr > xored
 			if curr.Cmp(xored) > 0 {
 				//fmt.Println(i, len(r), curr, xored)
-				errFatal(nil, "what2 findNode")
+				routingLog.warnf(nodeCtx, "dropped find_node of %s, it is closer than our routing table", bytes32ToString(committeeIDbytes))
+				return Committee{}, false
 			} else if curr.Cmp(xored) == 0 {
-				errFatal(nil, "2: got committteID that was in routing table")
+				routingLog.warnf(nodeCtx, "dropped find_node of %s, it is in our routing table", bytes32ToString(committeeIDbytes))
+				return Committee{}, false
 			}
 			// the closest node is the one furthes away in our routing table
 			closest = i
@@ -89,7 +115,8 @@ func findClosestsCommittee(nodeCtx *NodeCtx, committeeIDbytes [32]byte) Committe
 		next := new(big.Int).Xor(selfCommitteeID, r[i+1].BigIntID)
 
 		if xored.Cmp(curr) == 0 || xored.Cmp(next) == 0 {
-			errFatal(nil, "got a committeID that was in routing table")
+			routingLog.warnf(nodeCtx, "dropped find_node of %s, it is in our routing table", bytes32ToString(committeeIDbytes))
+			return Committee{}, false
 		}
 
 		// curr < xored
@@ -109,57 +136,78 @@ func findClosestsCommittee(nodeCtx *NodeCtx, committeeIDbytes [32]byte) Committe
 				continue
 			}
 		} else {
-			errFatal(nil, "what findNode")
+			routingLog.warnf(nodeCtx, "dropped find_node of %s, it is closer than our routing table", bytes32ToString(committeeIDbytes))
+			return Committee{}, false
 		}
 	}
-	return r[closest]
+	return r[closest], true
 }
 
-func findNodeAndSend(nodeCtx *NodeCtx, commiteeID [32]byte, msg interface{}) {
-	c := findNode(nodeCtx, commiteeID)
+// returns the find_node rounds it took
+func findNodeAndSend(nodeCtx *NodeCtx, commiteeID [32]byte, msg interface{}) int {
+	c, hops, ok := findNode(nodeCtx, commiteeID)
+	if !ok {
+		return 0
+	}
 
-	for _, v := range c.Members {
-		go dialAndSend(v.IP, msg)
+	for _, v := range c.sortedMembers() {
+		spawnDialAndSend(v.IP, msg)
 	}
+	return hops
 }
 
-func findNode(nodeCtx *NodeCtx, committeeID [32]byte) Committee {
+func findNode(nodeCtx *NodeCtx, committeeID [32]byte) (Committee, int, bool) {
 	// given that committeeID is not in our routing table, then send findNode request to closests committe to committeeID
 
-	c := findClosestsCommittee(nodeCtx, committeeID)
+	c, ok := findClosestsCommittee(nodeCtx, committeeID)
+	if !ok {
+		return c, 0, false
+	}
 
 	if c.ID == committeeID {
 		errFatal(nil, "what3")
 	}
 
 	// find closest committee in our routing table to committeeID
-	return recursiveFindNode(nodeCtx, committeeID, c)
+	c, hops := recursiveFindNode(nodeCtx, committeeID, c)
+	return c, hops, true
 }
 
-func recursiveFindNode(nodeCtx *NodeCtx, committeeID [32]byte, nCommittee Committee) Committee {
+// returns the committee and the find_node rounds it took
+func recursiveFindNode(nodeCtx *NodeCtx, committeeID [32]byte, nCommittee Committee) (Committee, int) {
 	// construct findNode message and send it.
 	findNodeMsg := KademliaFindNodeMsg{committeeID}
-	msg := Msg{"find_node", findNodeMsg, nodeCtx.self.Priv.Pub}
-	var wg sync.WaitGroup
+	msg := withMembershipProof(nodeCtx, Msg{"find_node", findNodeMsg, nodeCtx.self.Priv.Pub})
+	var wg WaitGroup
 	responses := make(chan KademliaFindNodeResponse, len(nCommittee.Members))
-	for _, m := range nCommittee.Members {
+	for _, m := range nCommittee.sortedMembers() {
+		m := m
 		wg.Add(1)
-		go func() {
-			conn := dial(m.IP)
-			// TODO not all connections may retrun positive
-			sendMsg(conn, msg)
+		spawn(func() {
 			response := new(KademliaFindNodeResponse)
-
-			reciveMsg(conn, response)
+			// a member that is down does not answer
+			if requestFrom(m.IP, msg, response) {
+				// an answer of a node that is not a member of the committee we asked is dropped
+				reason := verifyMembership(nodeCtx, findNodeResponseData(&response.Committee), &response.Proof)
+				if reason == "" && response.Proof.CommitteeID != nCommittee.ID {
+					reason = "member of committee " + bytes32ToString(response.Proof.CommitteeID)
+				}
+				if reason == "" {
+					responses <- *response
+				} else {
+					routingLog.warnf(nodeCtx, "[Membership] dropped a find_node answer of %s: %s", m.IP, reason)
+				}
+			}
 			// fmt.Println(response)
-			conn.Close()
-			responses <- *response
 			wg.Done()
-		}()
+		})
 	}
 	wg.Wait()
 
 	l := len(responses)
+	if l == 0 {
+		errFatal(nil, "no member answered find_node with a membership proof")
+	}
 	resp := make([]KademliaFindNodeResponse, l)
 	for i := 0; i < l; i++ {
 		resp[i] = <-responses
@@ -179,7 +227,7 @@ func recursiveFindNode(nodeCtx *NodeCtx, committeeID [32]byte, nCommittee Commit
 	if _id == committeeID {
 		// success found the committee ID
 		// return all members in that committee
-		return aggregateResponses(resp, _id)
+		return aggregateResponses(resp, _id), 1
 	}
 	// aggregate all members and pick log(n/m) of them to continue
 	c := aggregateResponses(resp, _id)
@@ -191,7 +239,7 @@ func recursiveFindNode(nodeCtx *NodeCtx, committeeID [32]byte, nCommittee Commit
 	newC.init(_id)
 
 	i := 0
-	for _, v := range c.Members {
+	for _, v := range c.sortedMembers() {
 		var isIn bool = false
 		for _, j := range indexes {
 			if i == j {
@@ -206,7 +254,8 @@ func recursiveFindNode(nodeCtx *NodeCtx, committeeID [32]byte, nCommittee Commit
 		newC.addMember(v)
 		i++
 	}
-	return recursiveFindNode(nodeCtx, committeeID, newC)
+	c, hops := recursiveFindNode(nodeCtx, committeeID, newC)
+	return c, hops + 1
 }
 
 func aggregateResponses(resp []KademliaFindNodeResponse, ID [32]byte) Committee {
@@ -221,19 +270,23 @@ func aggregateResponses(resp []KademliaFindNodeResponse, ID [32]byte) Committee
 }
 
 func handleFindNode(nodeCtx *NodeCtx, conn net.Conn, msg KademliaFindNodeMsg) {
-	c := _handleFindNode(nodeCtx, msg)
+	c, ok := _handleFindNode(nodeCtx, msg)
+	if !ok {
+		return
+	}
 	response := KademliaFindNodeResponse{}
 	response.Committee = c
+	response.Proof = proveMembership(nodeCtx, findNodeResponseData(&c))
 	sendMsg(conn, response)
 }
 
-func _handleFindNode(nodeCtx *NodeCtx, msg KademliaFindNodeMsg) Committee {
+func _handleFindNode(nodeCtx *NodeCtx, msg KademliaFindNodeMsg) (Committee, bool) {
 	// check if we have committeeID in our routing table
 	r := nodeCtx.routingTable.get()
 	for _, c := range r {
 		if c.ID == msg.ID {
 			// we have it!
-			return c
+			return c, true
 		}
 	}
 
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/binary"
+	"math/big"
+)
+
+// -deriveKeys, the keys of the nodes from the run seed
+
+func keySeed(runSeed int64, index uint) [32]byte {
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint64(b, uint64(runSeed))
+	binary.LittleEndian.PutUint64(b[8:], uint64(index))
+	return hash(byteSliceAppend([]byte("key"), b))
+}
+
+// a scalar in [1, order-1] from seed
+func seedScalar(seed [32]byte, order *big.Int) *big.Int {
+	n := new(big.Int).Sub(order, big.NewInt(1))
+	d := new(big.Int).Mod(new(big.Int).SetBytes(seed[:]), n)
+	return d.Add(d, big.NewInt(1))
+}
+
+// derives a key of the scheme selected with -sigScheme from seed
+func (k *PrivKey) derive(seed [32]byte) {
+	switch sigScheme {
+	case schemeBls:
+		k.setBls(seedScalar(seed, blsOrder()))
+	case schemeEd25519:
+		k.setEd(ed25519.NewKeyFromSeed(seed[:]))
+	default:
+		priv := new(ecdsa.PrivateKey)
+		priv.Curve = eCurve
+		priv.D = seedScalar(seed, eCurve.Params().N)
+		priv.X, priv.Y = eCurve.ScalarBaseMult(priv.D.Bytes())
+		k.setPriv(priv)
+	}
+}
+
+func requestRunSeed(join bool) int64 {
+	var runSeed int64
+	requestFromCoordinator(join, "run_seed", Node_InitialMessageToCoordinator{SeedRequest: true}, &runSeed)
+	return runSeed
+}
+
+func deriveNodeKey(runSeed int64, index uint) *PrivKey {
+	k := new(PrivKey)
+	k.derive(keySeed(runSeed, index))
+	nodeLog.infof(nil, "Derived key %s from run seed %d and index %d", bytes32ToString(k.Pub.Bytes), runSeed, index)
+	return k
+}
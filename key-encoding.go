@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// the wire encoding of keys and signatures
+
+type derSig struct {
+	R, S *big.Int
+}
+
+func (v ecdsaVerifier) encode() []byte {
+	return elliptic.MarshalCompressed(v.pub.Pub.Curve, v.pub.Pub.X, v.pub.Pub.Y)
+}
+
+func (v ed25519Verifier) encode() []byte {
+	return v.pub
+}
+
+func (v blsVerifier) encode() []byte {
+	return v.pk
+}
+
+func (k *PubKey) hasKey() bool {
+	return k.Pub != nil || k.Ed != nil || k.Bls != nil
+}
+
+func (k *PubKey) encode() []byte {
+	if !k.hasKey() {
+		return nil
+	}
+	return k.verifier().encode()
+}
+
+// hex of the encoded key, for output that is read outside this program
+func (k *PubKey) encodedString() string {
+	return hex.EncodeToString(k.encode())
+}
+
+func decodePubKey(b []byte) (*PubKey, error) {
+	k := new(PubKey)
+	switch {
+	case len(b) == 33 && (b[0] == 0x02 || b[0] == 0x03):
+		x, y := elliptic.UnmarshalCompressed(eCurve, b)
+		if x == nil {
+			return nil, errors.New("invalid compressed point")
+		}
+		k.Pub = &ecdsa.PublicKey{Curve: eCurve, X: x, Y: y}
+	case len(b) == 65 && b[0] == 0x04:
+		x, y := elliptic.Unmarshal(eCurve, b)
+		if x == nil {
+			return nil, errors.New("invalid uncompressed point")
+		}
+		k.Pub = &ecdsa.PublicKey{Curve: eCurve, X: x, Y: y}
+	case len(b) == ed25519.PublicKeySize:
+		k.Ed = append(ed25519.PublicKey{}, b...)
+	case len(b) == 48:
+		if _, err := blsDecodePub(b); err != nil {
+			return nil, err
+		}
+		k.Bls = append([]byte{}, b...)
+	default:
+		return nil, fmt.Errorf("unknown public key encoding of %d bytes", len(b))
+	}
+	k.init()
+	return k, nil
+}
+
+func (s *Sig) encode() []byte {
+	if s.R == nil || s.S == nil {
+		return s.Raw
+	}
+	der, err := asn1.Marshal(derSig{s.R, s.S})
+	ifErrFatal(err, "der sig")
+	return der
+}
+
+// DER if b is a valid DER sequence of two integers, otherwise a raw signature. Raw ed25519 and
+// bls signatures are never valid DER of their length
+func decodeSig(b []byte) *Sig {
+	if len(b) > 0 && b[0] == 0x30 {
+		var d derSig
+		rest, err := asn1.Unmarshal(b, &d)
+		if err == nil && len(rest) == 0 && d.R.Sign() > 0 && d.S.Sign() > 0 {
+			return &Sig{R: d.R, S: d.S}
+		}
+	}
+	return &Sig{Raw: append([]byte{}, b...)}
+}
+
+func (k PubKey) GobEncode() ([]byte, error) {
+	if !k.hasKey() {
+		return nil, errors.New("public key without key")
+	}
+	return k.encode(), nil
+}
+
+func (k *PubKey) GobDecode(b []byte) error {
+	d, err := decodePubKey(b)
+	if err != nil {
+		return err
+	}
+	*k = *d
+	return nil
+}
+
+func (s Sig) GobEncode() ([]byte, error) {
+	return s.encode(), nil
+}
+
+func (s *Sig) GobDecode(b []byte) error {
+	*s = *decodeSig(b)
+	return nil
+}
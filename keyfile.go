@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// -keyfile, the key of a node on disk
+
+// with more than one instance every node gets its own file, node.pem -> node-3.pem
+func keyfilePath(keyfile string, count uint, instances uint) string {
+	if instances <= 1 {
+		return keyfile
+	}
+	ext := filepath.Ext(keyfile)
+	return strings.TrimSuffix(keyfile, ext) + "-" + strconv.FormatUint(uint64(count), 10) + ext
+}
+
+func (k *PrivKey) save(path string) error {
+	typ := "EC PRIVATE KEY"
+	var der []byte
+	var err error
+	if k.Ed != nil {
+		typ = "PRIVATE KEY"
+		der, err = x509.MarshalPKCS8PrivateKey(k.Ed)
+	} else if k.Bls != nil {
+		// no standard encoding, the 32 byte big endian scalar
+		typ = "BLS PRIVATE KEY"
+		der = k.Bls.FillBytes(make([]byte, 32))
+	} else {
+		der, err = x509.MarshalECPrivateKey(k.Priv)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	return os.WriteFile(path, data, 0600)
+}
+
+func (k *PrivKey) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var priv *ecdsa.PrivateKey
+	if block, _ := pem.Decode(data); block != nil && block.Type == "PRIVATE KEY" {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		switch key := key.(type) {
+		case ed25519.PrivateKey:
+			k.setEd(key)
+			return nil
+		case *ecdsa.PrivateKey:
+			priv = key
+		default:
+			return fmt.Errorf("unsupported key type %T", key)
+		}
+	} else if block != nil && block.Type == "BLS PRIVATE KEY" {
+		k.setBls(new(big.Int).SetBytes(block.Bytes))
+		return nil
+	} else if block != nil {
+		priv, err = x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+	} else {
+		// raw private key
+		d, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("key is neither PEM nor hex: %v", err)
+		}
+		if sigScheme == schemeBls {
+			k.setBls(new(big.Int).SetBytes(d))
+			return nil
+		}
+		if sigScheme == schemeEd25519 {
+			if len(d) != ed25519.SeedSize {
+				return fmt.Errorf("ed25519 seed must be %d bytes", ed25519.SeedSize)
+			}
+			k.setEd(ed25519.NewKeyFromSeed(d))
+			return nil
+		}
+		priv = new(ecdsa.PrivateKey)
+		priv.Curve = eCurve
+		priv.D = new(big.Int).SetBytes(d)
+		priv.X, priv.Y = eCurve.ScalarBaseMult(d)
+	}
+	if priv.Curve != eCurve {
+		return fmt.Errorf("key is not on %s", eCurve.Params().Name)
+	}
+	k.setPriv(priv)
+	return nil
+}
+
+// loads the key at path, or generates one and saves it there
+func loadOrGenKey(path string) *PrivKey {
+	k := new(PrivKey)
+	err := k.load(path)
+	if err == nil {
+		nodeLog.infof(nil, "Loaded key %s from %s", bytes32ToString(k.Pub.Bytes), path)
+		return k
+	}
+	if !os.IsNotExist(err) {
+		errFatal(err, "loading key "+path)
+	}
+	k.gen()
+	ifErrFatal(k.save(path), "saving key "+path)
+	nodeLog.infof(nil, "Generated key %s and saved it to %s", bytes32ToString(k.Pub.Bytes), path)
+	return k
+}
+
+// sorts the nodes by key, so the assignment does not depend on the order they connected in
+func sortNodeInfos(nodeInfos []NodeAllInfo) {
+	sort.Slice(nodeInfos, func(i, j int) bool {
+		return bytes.Compare(nodeInfos[i].Pub.Bytes[:], nodeInfos[j].Pub.Bytes[:]) < 0
+	})
+}
+
+// sorts the nodes by key and returns a seed derived from all keys
+func stableAssignmentSeed(nodeInfos []NodeAllInfo) int64 {
+	sortNodeInfos(nodeInfos)
+	all := []byte{}
+	for _, n := range nodeInfos {
+		all = append(all, n.Pub.Bytes[:]...)
+	}
+	h := hash(all)
+	return int64(binary.LittleEndian.Uint64(h[:8]))
+}
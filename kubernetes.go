@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// a run on kubernetes and genmanifests
+
+const k8sCoordinatorService = "rapidchain-coordinator"
+
+// the secret with the -statsSecret of a run in its key secret, kubectl create secret generic
+// rapidchain-stats --from-literal=secret=<secret>
+const k8sStatsSecret = "rapidchain-stats"
+
+// the address a node listens on, every interface when it advertises its host
+func nodeListenAddr(flagArgs *FlagArgs, port uint) string {
+	if flagArgs.advertise != "" {
+		return fmt.Sprintf(":%d", port)
+	}
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+// waits until the coordinator of -coordinator answers, asks for the run seed since it takes every
+// other connection of the setup for a node
+func waitForCoordinator(flagArgs *FlagArgs) {
+	if flagArgs.coordinator == "" {
+		return
+	}
+	deadline := time.Now().Add(default_coordinatorWait * time.Second)
+	for {
+		conn, err := netDialTimeout(coordinatorAddr(), time.Second)
+		if err == nil {
+			var runSeed int64
+			err = gob.NewEncoder(conn).Encode(Node_InitialMessageToCoordinator{SeedRequest: true})
+			if err == nil {
+				err = gob.NewDecoder(conn).Decode(&runSeed)
+			}
+			conn.Close()
+			if err == nil {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			errFatal(err, "coordinator "+coord+" did not come up")
+		}
+		nodeLog.infof(nil, "Waiting for coordinator %s: %v", coord, err)
+		time.Sleep(time.Second)
+	}
+}
+
+// the flags of the command line as the variables of sub on another machine, a pod or an instance
+// of aws-launch
+func remoteEnv(sub string) [][2]string {
+	env := [][2]string{}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		// the secret is not written where the machine or the cluster shows it, see k8sStatsSecret
+		case "function", "image", "coordinator", "advertise", "local", "statsSecret":
+			return
+		}
+		if takesFlag(sub, f.Name) {
+			env = append(env, [2]string{envName(f.Name), f.Value.String()})
+		}
+	})
+	sort.Slice(env, func(i, j int) bool { return env[i][0] < env[j][0] })
+	return append(env, [2]string{envName("local"), "false"})
+}
+
+// the env of a container at indent, with -statsSecret from the key of the secret k8sStatsSecret, if
+// there is one
+func writeK8sEnv(b *strings.Builder, indent string, env [][2]string) {
+	fmt.Fprintf(b, "%senv:\n", indent)
+	for _, e := range env {
+		fmt.Fprintf(b, "%[1]s- name: %[2]s\n%[1]s  value: %[3]q\n", indent, e[0], e[1])
+	}
+	fmt.Fprintf(b, `%[1]s- name: %[2]s
+%[1]s  valueFrom:
+%[1]s    secretKeyRef:
+%[1]s      name: %[3]s
+%[1]s      key: secret
+%[1]s      optional: true
+`, indent, envName("statsSecret"), k8sStatsSecret)
+}
+
+// the pods or instances of the nodes, each runs -instances nodes
+func nodeMachines(flagArgs *FlagArgs) uint {
+	if flagArgs.instances == 0 || flagArgs.n%flagArgs.instances != 0 {
+		errFatal(nil, fmt.Sprintf("-n %d is not a multiple of -instances %d, every machine runs -instances nodes", flagArgs.n, flagArgs.instances))
+	}
+	return flagArgs.n / flagArgs.instances
+}
+
+func genManifests(flagArgs *FlagArgs) {
+	pods := nodeMachines(flagArgs)
+	// the workdir of the image is /run
+	results := flagArgs.resultsDir
+	if !filepath.IsAbs(results) {
+		results = filepath.Join("/run", results)
+	}
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "# rapidchain run of %d nodes in %d committees, %d pods of %d nodes\n", flagArgs.n, flagArgs.m, pods, flagArgs.instances)
+	fmt.Fprintf(b, `apiVersion: v1
+kind: Service
+metadata:
+  name: %[1]s
+spec:
+  selector:
+    app: %[1]s
+  ports:
+  - port: 8080
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: rapidchain-results
+spec:
+  accessModes: [ReadWriteOnce]
+  resources:
+    requests:
+      storage: 1Gi
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: %[1]s
+  labels:
+    app: %[1]s
+spec:
+  restartPolicy: Never
+  containers:
+  - name: coordinator
+    image: %[2]s
+    args: [coordinator]
+    ports:
+    - containerPort: 8080
+    volumeMounts:
+    - name: results
+      mountPath: %[3]s
+`, k8sCoordinatorService, flagArgs.image, results)
+	writeK8sEnv(b, "    ", remoteEnv("coordinator"))
+	fmt.Fprintf(b, `  volumes:
+  - name: results
+    persistentVolumeClaim:
+      claimName: rapidchain-results
+---
+# the headless service of the stateful set, the nodes are reached by their pod ips
+apiVersion: v1
+kind: Service
+metadata:
+  name: rapidchain-node
+spec:
+  clusterIP: None
+  selector:
+    app: rapidchain-node
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: rapidchain-node
+spec:
+  serviceName: rapidchain-node
+  replicas: %d
+  podManagementPolicy: Parallel
+  selector:
+    matchLabels:
+      app: rapidchain-node
+  template:
+    metadata:
+      labels:
+        app: rapidchain-node
+    spec:
+      terminationGracePeriodSeconds: %d
+      containers:
+      - name: node
+        image: %s
+        args: [node]
+`, pods, default_shutdownTimeout+5, flagArgs.image)
+	// the ports of -ports, the free ones of the nodes are reachable in the cluster anyway
+	if flagArgs.portsBegin != 0 {
+		fmt.Fprintf(b, "        ports:\n")
+		for i := uint(0); i < flagArgs.instances; i++ {
+			fmt.Fprintf(b, "        - containerPort: %d\n", flagArgs.portsBegin+i)
+		}
+	}
+	writeK8sEnv(b, "        ", append(remoteEnv("node"), [2]string{envName("coordinator"), k8sCoordinatorService}))
+	fmt.Fprintf(b, `        - name: %s
+          valueFrom:
+            fieldRef:
+              fieldPath: status.podIP
+`, envName("advertise"))
+	os.Stdout.WriteString(b.String())
+}
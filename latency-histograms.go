@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -latencyStats histograms, the latencies of the nodes in histograms instead of a stat per event
+
+const latencyByEvents = "events"
+const latencyByHistograms = "histograms"
+
+func isLatencyStats(s string) bool {
+	return s == latencyByEvents || s == latencyByHistograms
+}
+
+// the latencies are recorded in microseconds
+const latencyUnit = time.Microsecond
+
+// 128 buckets per power of two
+const latencySubBuckets = 128
+
+type LatencyHistogram struct {
+	Counts map[int32]uint64 // bucket -> values
+	Count  uint64
+	Sum    int64
+	Max    int64
+}
+
+func newLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{Counts: make(map[int32]uint64)}
+}
+
+func latencyBucket(v int64) int32 {
+	if v < latencySubBuckets {
+		return int32(v)
+	}
+	shift := bits.Len64(uint64(v)) - 8
+	return int32((shift+1)*latencySubBuckets) + int32(v>>uint(shift)) - latencySubBuckets
+}
+
+// the highest value of bucket b
+func latencyBucketValue(b int32) int64 {
+	if b < latencySubBuckets {
+		return int64(b)
+	}
+	shift := uint(b/latencySubBuckets - 1)
+	return (int64(b%latencySubBuckets+latencySubBuckets+1) << shift) - 1
+}
+
+func (h *LatencyHistogram) record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	h.Counts[latencyBucket(v)]++
+	h.Count++
+	h.Sum += v
+	if v > h.Max {
+		h.Max = v
+	}
+}
+
+func (h *LatencyHistogram) merge(o *LatencyHistogram) {
+	for b, c := range o.Counts {
+		h.Counts[b] += c
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+	if o.Max > h.Max {
+		h.Max = o.Max
+	}
+}
+
+// the value under which q of the values are
+func (h *LatencyHistogram) quantile(q float64) int64 {
+	if h.Count == 0 {
+		return 0
+	}
+	buckets := make([]int32, 0, len(h.Counts))
+	for b := range h.Counts {
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	rank := uint64(q*float64(h.Count) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	seen := uint64(0)
+	for _, b := range buckets {
+		seen += h.Counts[b]
+		if seen >= rank {
+			if v := latencyBucketValue(b); v < h.Max {
+				return v
+			}
+			break
+		}
+	}
+	return h.Max
+}
+
+// the histograms and counts of a node since its last report, nothing is recorded without
+// -latencyStats histograms
+type LatencyStats struct {
+	enabled bool
+	hists   map[string]*LatencyHistogram
+	counts  map[string]uint64
+	starts  map[string]map[[32]byte]time.Time // phase -> gossip hash or merkle root -> start
+	mux     sync.Mutex
+}
+
+func (ls *LatencyStats) init(enabled bool) {
+	ls.mux.Lock()
+	defer ls.mux.Unlock()
+	ls.enabled = enabled
+	ls._reset()
+	ls.starts = make(map[string]map[[32]byte]time.Time)
+}
+
+func (ls *LatencyStats) _reset() {
+	ls.hists = make(map[string]*LatencyHistogram)
+	ls.counts = make(map[string]uint64)
+}
+
+func (ls *LatencyStats) record(name string, v int64) {
+	ls.mux.Lock()
+	defer ls.mux.Unlock()
+	if !ls.enabled {
+		return
+	}
+	h, ok := ls.hists[name]
+	if !ok {
+		h = newLatencyHistogram()
+		ls.hists[name] = h
+	}
+	h.record(v)
+}
+
+func (ls *LatencyStats) recordDuration(name string, d time.Duration) {
+	ls.record(name, int64(d/latencyUnit))
+}
+
+func (ls *LatencyStats) count(name string) {
+	ls.mux.Lock()
+	defer ls.mux.Unlock()
+	if !ls.enabled {
+		return
+	}
+	ls.counts[name]++
+}
+
+// marks the start of id for the histograms measured from it, the first start counts
+func (ls *LatencyStats) start(kind string, id [32]byte) {
+	ls.mux.Lock()
+	defer ls.mux.Unlock()
+	if !ls.enabled {
+		return
+	}
+	starts, ok := ls.starts[kind]
+	if !ok {
+		starts = make(map[[32]byte]time.Time)
+		ls.starts[kind] = starts
+	}
+	if _, ok := starts[id]; ok {
+		return
+	}
+	now := clockNow()
+	for other, t := range starts {
+		if now.Sub(t) > metricStartTimeout*time.Second {
+			delete(starts, other)
+		}
+	}
+	starts[id] = now
+}
+
+// records the time since the start of id in name, done forgets the start
+func (ls *LatencyStats) since(name string, kind string, id [32]byte, done bool) {
+	ls.mux.Lock()
+	t, ok := ls.starts[kind][id]
+	if done {
+		delete(ls.starts[kind], id)
+	}
+	ls.mux.Unlock()
+	if ok {
+		ls.recordDuration(name, clockSince(t))
+	}
+}
+
+type LatencyReport struct {
+	Epoch      uint
+	T          time.Time
+	Histograms map[string]*LatencyHistogram
+	Counts     map[string]uint64
+}
+
+// the report since the last one, nil if nothing was recorded
+func (ls *LatencyStats) take(epoch uint) *LatencyReport {
+	ls.mux.Lock()
+	defer ls.mux.Unlock()
+	if len(ls.hists) == 0 && len(ls.counts) == 0 {
+		return nil
+	}
+	r := &LatencyReport{epoch, clockNow(), ls.hists, ls.counts}
+	ls._reset()
+	return r
+}
+
+// sends the histograms of the node to the coordinator every default_latencyInterval seconds
+func reportLatencies(nodeCtx *NodeCtx) {
+	for {
+		clockSleep(default_latencyInterval * time.Second)
+		if nodeCtx.stopped.get() {
+			return
+		}
+		if r := nodeCtx.latencies.take(nodeCtx.blockchain.epoch()); r != nil {
+			// with the key, so the coordinator knows the node is alive
+			spawnDialAndSend(coordinatorAddr(), Msg{"latency_report", *r, nodeCtx.self.Priv.Pub})
+		}
+	}
+}
+
+// the histograms of all nodes on the coordinator, of the interval and the whole run
+type LatencyResults struct {
+	window map[string]*LatencyHistogram
+	total  map[string]*LatencyHistogram
+	mux    sync.Mutex
+}
+
+func (lr *LatencyResults) init() {
+	lr.mux.Lock()
+	defer lr.mux.Unlock()
+	lr.window = make(map[string]*LatencyHistogram)
+	lr.total = make(map[string]*LatencyHistogram)
+}
+
+func (lr *LatencyResults) add(r *LatencyReport) {
+	lr.mux.Lock()
+	defer lr.mux.Unlock()
+	for name, h := range r.Histograms {
+		for _, m := range []map[string]*LatencyHistogram{lr.window, lr.total} {
+			if _, ok := m[name]; !ok {
+				m[name] = newLatencyHistogram()
+			}
+			m[name].merge(h)
+		}
+	}
+}
+
+func latencyColumns(name string, h *LatencyHistogram) string {
+	if h == nil {
+		h = newLatencyHistogram()
+	}
+	values := []int64{h.quantile(0.5), h.quantile(0.95), h.quantile(0.99), h.Max}
+	cols := []string{fmt.Sprint(h.Count)}
+	for _, v := range values {
+		if strings.HasSuffix(name, "_s") {
+			cols = append(cols, fmt.Sprintf("%.6f", (time.Duration(v)*latencyUnit).Seconds()))
+		} else {
+			cols = append(cols, fmt.Sprint(v))
+		}
+	}
+	return strings.Join(cols, ",")
+}
+
+// the rows of the interval since the last call
+func (lr *LatencyResults) rows() []string {
+	lr.mux.Lock()
+	defer lr.mux.Unlock()
+	names := []string{}
+	for name := range lr.total {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rows := []string{}
+	for _, name := range names {
+		rows = append(rows, name+","+latencyColumns(name, lr.window[name])+","+latencyColumns(name, lr.total[name]))
+	}
+	lr.window = make(map[string]*LatencyHistogram)
+	return rows
+}
+
+func writeLatencies(lr *LatencyResults, f *os.File) {
+	for {
+		clockSleep(default_latencyInterval * time.Second)
+		for _, row := range lr.rows() {
+			writeStringToFile(row, f)
+		}
+	}
+}
+
+// adds the counts and sums of a report to the stats of its epoch
+func (es *EpochStats) addLatencyReport(r *LatencyReport) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	s := es._get(r.Epoch, r.T)
+	s.txs += int(r.Counts["txs"])
+	s.echos += int(r.Counts["echos"])
+	s.accepts += int(r.Counts["accepts"])
+	s.pendings += int(r.Counts["pendings"])
+	if h, ok := r.Histograms["routing_s"]; ok {
+		s.routed += int(h.Count)
+		s.routing += time.Duration(h.Sum) * latencyUnit
+	}
+	if h, ok := r.Histograms["ida_s"]; ok {
+		s.reconstructions += int(h.Count)
+		s.ida += time.Duration(h.Sum) * latencyUnit
+	}
+}
@@ -2,19 +2,40 @@ package main
 
 import (
 	"encoding/binary"
-	"fmt"
-	"log"
 	"math"
 	"time"
 )
 
 // Start a completly new iteration. With leader election and if you are leader, perform leader duties.
 func startNewIteration(nodeCtx *NodeCtx) {
+	if nodeCtx.stopped.get() || nodeCtx.faults.iteration(nodeCtx, nodeCtx.i.getI()) {
+		return
+	}
+	recoverExit(nodeCtx)
+	if leaving(nodeCtx) {
+		consensusLog.infof(nodeCtx, "Left before iteration %d", nodeCtx.i.getI())
+		return
+	}
+	applyLeaves(nodeCtx, nodeCtx.i.getI())
+	if epochDue(nodeCtx) {
+		runEpoch(nodeCtx)
+		nodeCtx.tuning.boundary(nodeCtx, tuneAtEpoch)
+	}
+	nodeCtx.tuning.boundary(nodeCtx, tuneAtBlock)
+	reportCommitteeView(nodeCtx)
+
 	// launch leader election protocol
-	leaderElection(nodeCtx)
+	if nodeCtx.flagArgs.vrf {
+		vrfLeaderElection(nodeCtx)
+	} else {
+		leaderElection(nodeCtx)
+	}
 
 	// If this node is leader then initate leader protocol
-	if nodeCtx.committee.CurrentLeader.Bytes == nodeCtx.self.Priv.Pub.Bytes {
+	if nodeCtx.currentLeader().Bytes == nodeCtx.self.Priv.Pub.Bytes {
+		if firstIterationOfEpoch(nodeCtx) {
+			clockSleep(nodeCtx.delta())
+		}
 
 		// go debug(nodeCtx)
 
@@ -24,7 +45,10 @@ func startNewIteration(nodeCtx *NodeCtx) {
 			if l >= 10 {
 				break
 			}
-			time.Sleep(100 * time.Millisecond)
+			if nodeCtx.stopped.get() {
+				return
+			}
+			clockSleep(100 * time.Millisecond)
 			// fmt.Print(l)
 		}
 		leader(nodeCtx)
@@ -55,14 +79,15 @@ func leaderElection(nodeCtx *NodeCtx) {
 	currI := make([]byte, 8)
 	binary.LittleEndian.PutUint64(currI, uint64(_currIteration))
 
-	listOfHashes := make([]byte32sortHelper, len(nodeCtx.committee.Members))
+	listOfHashes := make([]byte32sortHelper, 0, len(nodeCtx.committee.Members))
 	// calculate hash(id | rnd | currI) for every member
-	ii := 0
 	for _, m := range nodeCtx.committee.Members {
+		if !canLead(nodeCtx, recBlock, m.Pub.Bytes, _currIteration) || recBlock.isBlacklisted(m.Pub.Bytes) {
+			continue
+		}
 		connoctated := byteSliceAppend(m.Pub.Bytes[:], rnd[:], currI)
 		hsh := hash(connoctated)
-		listOfHashes[ii] = byte32sortHelper{m.Pub.Bytes, hsh}
-		ii++
+		listOfHashes = append(listOfHashes, byte32sortHelper{m.Pub.Bytes, hsh})
 	}
 
 	// sort list
@@ -77,12 +102,13 @@ func leaderElection(nodeCtx *NodeCtx) {
 
 	// the leader is the lowest in list except if selfHash is lower than that.
 	// fmt.Println(byte32Operations(selfHash, "<", listOfHashes[0].toSort))
-	if byte32Operations(selfHash, "<", listOfHashes[0].toSort) {
-		nodeCtx.committee.CurrentLeader = nodeCtx.self.Priv.Pub
-		log.Println("I am leader!", nodeCtx.amILeader())
+	// a blacklisted node only leads if no one else can
+	if len(listOfHashes) == 0 || (!recBlock.isBlacklisted(nodeCtx.self.Priv.Pub.Bytes) && byte32Operations(selfHash, "<", listOfHashes[0].toSort)) {
+		nodeCtx.setCurrentLeader(nodeCtx.self.Priv.Pub)
+		consensusLog.infof(nodeCtx, "I am leader! %v", nodeCtx.amILeader())
 	} else {
 		leader := listOfHashes[0].original
-		nodeCtx.committee.CurrentLeader = nodeCtx.committee.Members[leader].Pub
+		nodeCtx.setCurrentLeader(nodeCtx.committee.Members[leader].Pub)
 	}
 }
 
@@ -159,9 +185,9 @@ func basicLeaderElectionasdf(nodeCtx *NodeCtx) *PubKey {
 		}
 	}
 
-	fmt.Println("Leader", lowestID == nodeCtx.self.Priv.Pub)
+	consensusLog.debugf(nodeCtx, "Leader %v", lowestID == nodeCtx.self.Priv.Pub)
 
-	nodeCtx.committee.CurrentLeader = lowestID
+	nodeCtx.setCurrentLeader(lowestID)
 
 	return lowestID
 }
@@ -170,29 +196,44 @@ func leader(nodeCtx *NodeCtx) {
 	// initates leader process
 
 	// create a block
+	start := clockNow()
 	block := createProposeBlock(nodeCtx)
 
-	// ida-gossip the block
-	IDAGossip(nodeCtx, block.encode(), "block")
+	// ida-gossip the block, an adversary can gossip others, see byzantine.go
+	blocks := nodeCtx.byzantine.lead(nodeCtx, block)
+	for _, b := range blocks {
+		IDAGossip(nodeCtx, encodeBlockForGossip(nodeCtx, b), "block")
+	}
+	if len(blocks) == 0 {
+		return
+	}
+	block = blocks[0]
 
 	// wait until we have recivied and recreated IDA message
 	for !nodeCtx.blockchain.isProposedBlock(block.GossipHash) {
-		time.Sleep(100 * time.Millisecond)
+		clockSleep(100 * time.Millisecond)
 	}
+	nodeCtx.blockPhases.proposal(block, start)
 
 	// sleep a delta before iniation consensus
-	time.Sleep(time.Duration(2*nodeCtx.flagArgs.delta) * time.Millisecond)
+	clockSleep(2 * nodeCtx.delta())
+
+	// a leader that restarted must not propose another block in an iteration it allready proposed in
+	if !nodeCtx.wal.vote("propose", block.Iteration, block.GossipHash) {
+		return
+	}
 
 	// create a propose msg to initate consensus
 	cMsg := new(ConsensusMsg)
 	cMsg.GossipHash = block.GossipHash
 	cMsg.Tag = "propose"
 	cMsg.Pub = nodeCtx.self.Priv.Pub
-	cMsg.sign(nodeCtx.self.Priv)
-
-	msg := Msg{"consensus", cMsg, nodeCtx.self.Priv.Pub}
+	if nodeCtx.flagArgs.vrf {
+		cMsg.Vrf = nodeCtx.vrfClaims.get(block.Iteration, nodeCtx.self.Priv.Pub.Bytes)
+	}
 
 	// start consensus rounds.
-	log.Printf("Leader starting conseuss in committee %s\n", bytes32ToString(nodeCtx.committee.ID))
-	sendMsgToCommitteeAndSelf(msg, nodeCtx)
+	consensusLog.infof(nodeCtx, "Leader starting conseuss in committee %s", bytes32ToString(nodeCtx.committee.ID))
+	traceProposal(nodeCtx, block, start)
+	sendConsensusMsg(cMsg, nodeCtx)
 }
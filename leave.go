@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// -leaveAfter, nodes that leave gracefully
+
+type Leave struct {
+	Pub       [32]byte
+	Iteration uint // first iteration without the node
+}
+
+// leaves announced by the coordinator, applied when their iteration starts
+type Departures struct {
+	pending map[[32]byte]uint
+	self    uint // iteration this node left in, 0 if it did not announce its leave
+	mux     sync.Mutex
+}
+
+func (d *Departures) init() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.pending = make(map[[32]byte]uint)
+}
+
+func (d *Departures) add(l Leave) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.pending[l.Pub] = l.Iteration
+}
+
+// removes and returns the leaves of iteration i and before
+func (d *Departures) due(i uint) [][32]byte {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	pubs := [][32]byte{}
+	for pub, iteration := range d.pending {
+		if iteration <= i {
+			pubs = append(pubs, pub)
+			delete(d.pending, pub)
+		}
+	}
+	return pubs
+}
+
+// announces the leave of this node when it reaches -leaveAfter, returns true from the iteration
+// it left in
+func leaving(nodeCtx *NodeCtx) bool {
+	l := nodeCtx.flagArgs.leaveAfter
+	i := nodeCtx.i.getI()
+	if l == 0 || i < l {
+		return false
+	}
+	d := &nodeCtx.departures
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.self == 0 {
+		d.self = i + 1
+		spawnDialAndSendToCoordinator("leave", Leave{nodeCtx.self.Priv.Pub.Bytes, d.self})
+		nodeLog.infof(nodeCtx, "Leaving committee %s after iteration %d", bytes32ToString(nodeCtx.committeeID()), i)
+	}
+	return i >= d.self
+}
+
+// removes the nodes that left before iteration i from the committees
+func applyLeaves(nodeCtx *NodeCtx, i uint) {
+	for _, pub := range nodeCtx.departures.due(i) {
+		c := nodeCtx.blockchain.getLastReconfigurationBlock().committeeOf(pub)
+		if c == nil {
+			continue
+		}
+		allInfo := make(map[[32]byte]NodeAllInfo)
+		for p, info := range nodeCtx.allInfo {
+			if p != pub {
+				allInfo[p] = info
+			}
+		}
+		nodeCtx.allInfo = allInfo
+		nodeCtx.blockchain.removeLeftMember(c.ID, pub)
+		nodeCtx.epochClock.change()
+		if c.ID == nodeCtx.committeeID() {
+			members := make(map[[32]byte]*CommitteeMember)
+			for p, m := range nodeCtx.committee.Members {
+				if p != pub {
+					members[p] = m
+				}
+			}
+			nodeCtx.setMembers(members)
+			buildCurrentNeighbours(nodeCtx)
+		}
+		buildRoutingTable(nodeCtx, nodeCtx.committeeID(), allInfo)
+		nodeLog.infof(nodeCtx, "Node %s left committee %s before iteration %d", bytes32ToString(pub), bytes32ToString(c.ID), i)
+	}
+}
+
+// the members of committee during the epoch, with the nodes that left
+func (rb *ReconfigurationBlock) membersOf(committeeID [32]byte) map[[32]byte]bool {
+	members := make(map[[32]byte]bool)
+	if c, ok := rb.Committees[committeeID]; ok {
+		for pub := range c.Members {
+			members[pub] = true
+		}
+	}
+	for pub, id := range rb.Departed {
+		if id == committeeID {
+			members[pub] = true
+		}
+	}
+	return members
+}
+
+// weight of the adversaries of c, their number without -weights, and if it is below the committeeF
+// bound of the setup
+func (ms *Membership) _adversaries(c *Committee) (int, bool) {
+	var w Weights
+	if ms.rBlock != nil {
+		w = ms.rBlock.Weights
+	}
+	f := 0
+	for pub := range c.Members {
+		if info, ok := ms.nodes[pub]; ok && !info.IsHonest {
+			f += w.of(pub)
+		}
+	}
+	return f, underCommitteeBound(f, w.ofMembers(c.Members), ms.committeeF)
+}
+
+// logs the committees of rBlock with too many adversaries
+func (ms *Membership) _checkAdversaries(rBlock *ReconfigurationBlock) {
+	for id, c := range rBlock.Committees {
+		if f, ok := ms._adversaries(c); !ok {
+			coordinatorLog.warnf(nil, "[Invariant] committee %s has %d adversaries of %d members", bytes32ToString(id), f, len(c.Members))
+		}
+	}
+}
+
+// removes a node that leaves and announces it to the other nodes. Returns the result row
+// pub,committee,iteration,size,adversaries,safe, or false if the node is not known
+func (ms *Membership) leave(l Leave) (string, bool) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	if _, ok := ms.nodes[l.Pub]; !ok || ms.rBlock == nil {
+		return "", false
+	}
+	c := ms.rBlock.committeeOf(l.Pub)
+	if c == nil {
+		return "", false
+	}
+	delete(ms.nodes, l.Pub)
+	// the node may leave with the others of its process, see shutdown.go
+	for _, n := range ms.nodes {
+		if !ms.mirroring {
+			spawnDialAndSendIfUp(n.IP, Msg{"node_leave", l, nil})
+		}
+	}
+	ms.rBlock = withChangedCommittee(ms.rBlock, c.ID, func(c *Committee) { delete(c.Members, l.Pub) })
+	ms.clock.change()
+	left := ms.rBlock.Committees[c.ID]
+	f, safe := ms._adversaries(left)
+	ms._checkAdversaries(ms.rBlock)
+	coordinatorLog.infof(nil, "[Leave] %s left committee %s before iteration %d", bytes32ToString(l.Pub), bytes32ToString(c.ID), l.Iteration)
+	return fmt.Sprintf("%s,%s,%d,%d,%d,%t", bytes32ToString(l.Pub), bytes32ToString(c.ID), l.Iteration, len(left.Members), f, safe), true
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// the global ledger of the chains of the committees
+
+type LedgerHeader struct {
+	CommitteeID        [32]byte
+	Iteration          uint
+	GossipHash         [32]byte
+	PreviousGossipHash [32]byte
+	MerkleRoot         [32]byte
+	Txs                int
+}
+
+type LedgerBlock struct {
+	Epoch    uint
+	Previous [32]byte
+	Headers  []LedgerHeader // sorted by committee and iteration
+	Hash     [32]byte
+	Complete bool // every committee has passed the end of the epoch
+}
+
+type GlobalLedger struct {
+	committees map[[32]byte]map[[32]byte]LedgerHeader // CommitteeID -> GossipHash -> header
+	blocks     []*LedgerBlock                         // assembled, complete epochs
+	mux        sync.Mutex
+}
+
+func (l *GlobalLedger) init() {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.committees = make(map[[32]byte]map[[32]byte]LedgerHeader)
+	l.blocks = []*LedgerBlock{}
+}
+
+func (l *GlobalLedger) add(b *FinalBlock) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	pb := b.ProposedBlock
+	if _, ok := l.committees[pb.CommitteeID]; !ok {
+		l.committees[pb.CommitteeID] = make(map[[32]byte]LedgerHeader)
+	}
+	l.committees[pb.CommitteeID][pb.GossipHash] = LedgerHeader{pb.CommitteeID, pb.Iteration, pb.GossipHash, pb.PreviousGossipHash, pb.MerkleRoot, len(pb.Transactions)}
+}
+
+func (l *GlobalLedger) addGenesis(blocks []*FinalBlock) {
+	for _, b := range blocks {
+		l.add(b)
+	}
+}
+
+// the longest chain of the committee, from genesis to head
+func (l *GlobalLedger) _canonicalChain(committeeID [32]byte) []LedgerHeader {
+	headers := l.committees[committeeID]
+	var head *LedgerHeader
+	for _, h := range headers {
+		h := h
+		if head == nil || h.Iteration > head.Iteration || (h.Iteration == head.Iteration && bytes.Compare(h.GossipHash[:], head.GossipHash[:]) < 0) {
+			head = &h
+		}
+	}
+	chain := []LedgerHeader{}
+	for head != nil {
+		chain = append(chain, *head)
+		parent, ok := headers[head.PreviousGossipHash]
+		if !ok {
+			break
+		}
+		head = &parent
+	}
+	// reverse
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+func (lb *LedgerBlock) calculateHash() [32]byte {
+	w := canonicalWriter{new(bytes.Buffer)}
+	w.uint(canonicalVersion)
+	w.uint(uint64(lb.Epoch))
+	w.bytes32(lb.Previous)
+	w.uint(uint64(len(lb.Headers)))
+	for _, h := range lb.Headers {
+		w.bytes32(h.CommitteeID)
+		w.uint(uint64(h.Iteration))
+		w.bytes32(h.GossipHash)
+		w.bytes32(h.PreviousGossipHash)
+		w.bytes32(h.MerkleRoot)
+	}
+	return hash(w.buf.Bytes())
+}
+
+// builds the ledger block of epoch from the canonical chains
+func (l *GlobalLedger) _assemble(epoch uint, previous [32]byte, chains map[[32]byte][]LedgerHeader) *LedgerBlock {
+	start := epoch * default_ledgerEpoch
+	end := start + default_ledgerEpoch
+	lb := &LedgerBlock{Epoch: epoch, Previous: previous, Complete: len(chains) > 0}
+	for _, chain := range chains {
+		if len(chain) == 0 || chain[len(chain)-1].Iteration < end-1 {
+			lb.Complete = false
+		}
+		for _, h := range chain {
+			if h.Iteration >= start && h.Iteration < end {
+				lb.Headers = append(lb.Headers, h)
+			}
+		}
+	}
+	sort.Slice(lb.Headers, func(i, j int) bool {
+		c := bytes.Compare(lb.Headers[i].CommitteeID[:], lb.Headers[j].CommitteeID[:])
+		if c != 0 {
+			return c < 0
+		}
+		return lb.Headers[i].Iteration < lb.Headers[j].Iteration
+	})
+	lb.Hash = lb.calculateHash()
+	return lb
+}
+
+func (l *GlobalLedger) _chains() map[[32]byte][]LedgerHeader {
+	chains := make(map[[32]byte][]LedgerHeader)
+	for committeeID := range l.committees {
+		chains[committeeID] = l._canonicalChain(committeeID)
+	}
+	return chains
+}
+
+func (l *GlobalLedger) _previousHash() [32]byte {
+	if len(l.blocks) == 0 {
+		return [32]byte{}
+	}
+	return l.blocks[len(l.blocks)-1].Hash
+}
+
+// assembles the epochs every committee has finished and returns the new ledger blocks
+func (l *GlobalLedger) assembleComplete() []*LedgerBlock {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	chains := l._chains()
+	added := []*LedgerBlock{}
+	for {
+		lb := l._assemble(uint(len(l.blocks)), l._previousHash(), chains)
+		if !lb.Complete {
+			return added
+		}
+		l.blocks = append(l.blocks, lb)
+		added = append(added, lb)
+	}
+}
+
+// the complete ledger blocks and the current, incomplete, epoch
+func (l *GlobalLedger) snapshot() []*LedgerBlock {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	blocks := append([]*LedgerBlock{}, l.blocks...)
+	current := l._assemble(uint(len(l.blocks)), l._previousHash(), l._chains())
+	if len(current.Headers) > 0 {
+		blocks = append(blocks, current)
+	}
+	return blocks
+}
+
+func ledgerBlockString(lb *LedgerBlock) string {
+	committees := make(map[[32]byte]bool)
+	txs := 0
+	for _, h := range lb.Headers {
+		committees[h.CommitteeID] = true
+		txs += h.Txs
+	}
+	return fmt.Sprintf("%d,%s,%s,%d,%d,%d", lb.Epoch, bytes32ToString(lb.Hash), bytes32ToString(lb.Previous), len(committees), len(lb.Headers), txs)
+}
+
+func (l *GlobalLedger) addAndAssemble(b *FinalBlock, f *os.File) {
+	l.add(b)
+	for _, lb := range l.assembleComplete() {
+		writeStringToFile(ledgerBlockString(lb), f)
+	}
+}
+
+type ledgerHeaderView struct {
+	Committee string `json:"committee"`
+	Iteration uint   `json:"iteration"`
+	Hash      string `json:"hash"`
+	Previous  string `json:"previous"`
+	Root      string `json:"merkleRoot"`
+	Txs       int    `json:"txs"`
+}
+
+type ledgerBlockView struct {
+	Epoch    uint               `json:"epoch"`
+	Hash     string             `json:"hash"`
+	Previous string             `json:"previous"`
+	Complete bool               `json:"complete"`
+	Headers  []ledgerHeaderView `json:"headers"`
+}
+
+func (l *GlobalLedger) write(name string) {
+	views := []ledgerBlockView{}
+	for _, lb := range l.snapshot() {
+		v := ledgerBlockView{lb.Epoch, bytes32ToString(lb.Hash), bytes32ToString(lb.Previous), lb.Complete, []ledgerHeaderView{}}
+		for _, h := range lb.Headers {
+			v.Headers = append(v.Headers, ledgerHeaderView{bytes32ToString(h.CommitteeID), h.Iteration, bytes32ToString(h.GossipHash), bytes32ToString(h.PreviousGossipHash), bytes32ToString(h.MerkleRoot), h.Txs})
+		}
+		views = append(views, v)
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{"epochLength": default_ledgerEpoch, "blocks": views}, "", "  ")
+	if ifErr(err, "ledger json") {
+		return
+	}
+	ifErr(os.WriteFile(name+".json", data, 0644), "ledger json")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// the loggers of the modules, logfmt lines with the node and the committee
+
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+type Logger struct {
+	module string
+}
+
+var consensusLog = Logger{"consensus"}
+var idaLog = Logger{"ida"}
+var routingLog = Logger{"routing"}
+var coordinatorLog = Logger{"coordinator"}
+var nodeLog = Logger{"node"}
+
+var loggers = []Logger{consensusLog, idaLog, routingLog, coordinatorLog, nodeLog}
+
+// lowest level written per module, set by the flags before anything logs
+var logLevels = map[string]int{}
+
+func parseLevel(s string) (int, bool) {
+	for l, name := range levelNames {
+		if s == name {
+			return l, true
+		}
+	}
+	return 0, false
+}
+
+// sets the levels of -logLevel and -logModules, returns "" or the reason they are invalid
+func setLogLevels(level string, modules string) string {
+	l, ok := parseLevel(level)
+	if !ok {
+		return "unknown -logLevel " + level
+	}
+	for _, logger := range loggers {
+		logLevels[logger.module] = l
+	}
+	if modules == "" {
+		return ""
+	}
+	for _, m := range strings.Split(modules, ",") {
+		kv := strings.SplitN(m, "=", 2)
+		if len(kv) != 2 {
+			return "-logModules entry " + m + " is not module=level"
+		}
+		if _, ok := logLevels[kv[0]]; !ok {
+			return "unknown -logModules module " + kv[0]
+		}
+		l, ok := parseLevel(kv[1])
+		if !ok {
+			return "unknown -logModules level " + kv[1]
+		}
+		logLevels[kv[0]] = l
+	}
+	return ""
+}
+
+func (l Logger) enabled(level int) bool {
+	min, ok := logLevels[l.module]
+	if !ok {
+		min = levelInfo
+	}
+	return level >= min
+}
+
+func shortID(b [32]byte) string {
+	if b == [32]byte{} {
+		return "-"
+	}
+	return bytes32ToString(b)[:8]
+}
+
+func logFields(nodeCtx *NodeCtx) (string, string) {
+	if nodeCtx == nil || nodeCtx.self.Priv == nil {
+		return "-", "-"
+	}
+	return shortID(nodeCtx.self.Priv.Pub.Bytes), shortID(nodeCtx.committeeID())
+}
+
+// nodeCtx is nil outside a node
+func (l Logger) logf(nodeCtx *NodeCtx, level int, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	if level >= levelError {
+		countLoggedError()
+	}
+	node, committee := logFields(nodeCtx)
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	log.Printf("level=%s module=%s node=%s committee=%s msg=%q", levelNames[level], l.module, node, committee, msg)
+}
+
+func (l Logger) debugf(nodeCtx *NodeCtx, format string, args ...interface{}) {
+	l.logf(nodeCtx, levelDebug, format, args...)
+}
+
+func (l Logger) infof(nodeCtx *NodeCtx, format string, args ...interface{}) {
+	l.logf(nodeCtx, levelInfo, format, args...)
+}
+
+func (l Logger) warnf(nodeCtx *NodeCtx, format string, args ...interface{}) {
+	l.logf(nodeCtx, levelWarn, format, args...)
+}
+
+func (l Logger) errorf(nodeCtx *NodeCtx, format string, args ...interface{}) {
+	l.logf(nodeCtx, levelError, format, args...)
+}
+
+func (l Logger) fatalf(nodeCtx *NodeCtx, format string, args ...interface{}) {
+	node, committee := logFields(nodeCtx)
+	dumpFlightRecorders("fatal: " + fmt.Sprintf(format, args...))
+	exitStatus.finish(exitFatal, fmt.Sprintf(format, args...))
+	log.Fatalf("level=fatal module=%s node=%s committee=%s msg=%q", l.module, node, committee, fmt.Sprintf(format, args...))
+}
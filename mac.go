@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	bls12381 "github.com/kilic/bls12-381"
+)
+
+// -mac, hmac instead of signatures on the propose and echo of a committee
+
+// static diffie-hellman of our key and pub, ecdsa and bls keys only
+func (k *PrivKey) sharedSecret(pub *PubKey) ([]byte, error) {
+	if k.Priv != nil && pub.Pub != nil {
+		x, _ := eCurve.ScalarMult(pub.Pub.X, pub.Pub.Y, k.Priv.D.Bytes())
+		return x.FillBytes(make([]byte, 32)), nil
+	}
+	if k.Bls != nil && pub.Bls != nil {
+		p, err := blsDecodePub(pub.Bls)
+		if err != nil {
+			return nil, err
+		}
+		g := bls12381.NewG1()
+		return g.ToCompressed(g.MulScalarBig(g.New(), p, k.Bls)), nil
+	}
+	return nil, errors.New("no shared key with " + bytes32ToString(pub.Bytes))
+}
+
+// The keys belong to an epoch: they are derived from the reconfiguration block, and replaced
+// together with it when the next epoch starts. The epoch is part of the mac, so messages of the
+// last epoch are rejected.
+// The bls threshold keys of bls.go are dealt and not derived, so they are not rotated here.
+type MacKeys struct {
+	epoch  uint
+	rBlock [32]byte              // hash of the reconfiguration block the keys are derived from
+	keys   map[[32]byte][32]byte // Pub.Bytes -> key
+	mux    sync.Mutex
+}
+
+func (m *MacKeys) init() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.keys = make(map[[32]byte][32]byte)
+}
+
+func deriveMacKey(self *PrivKey, pub *PubKey, rBlock *ReconfigurationBlock) ([32]byte, error) {
+	shared, err := self.sharedSecret(pub)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return hash(byteSliceAppend([]byte("mac"), rBlock.Hash[:], rBlock.Randomness[:], shared)), nil
+}
+
+// derives the keys of epoch with every member of our committee in rBlock and replaces the keys of
+// the last epoch with them
+func (m *MacKeys) rotate(nodeCtx *NodeCtx, rBlock *ReconfigurationBlock, epoch uint) {
+	keys := make(map[[32]byte][32]byte)
+	pubs := []*PubKey{nodeCtx.self.Priv.Pub}
+	if c := rBlock.committeeOf(nodeCtx.self.Priv.Pub.Bytes); c != nil {
+		for _, member := range c.Members {
+			pubs = append(pubs, member.Pub)
+		}
+	}
+	for _, pub := range pubs {
+		key, err := deriveMacKey(nodeCtx.self.Priv, pub, rBlock)
+		ifErrFatal(err, "mac key")
+		keys[pub.Bytes] = key
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.epoch = epoch
+	m.rBlock = rBlock.Hash
+	m.keys = keys
+	nodeLog.infof(nodeCtx, "Derived %d mac keys of epoch %d", len(keys), epoch)
+}
+
+// the key with pub and the epoch it belongs to
+func (m *MacKeys) key(nodeCtx *NodeCtx, pub *PubKey) ([32]byte, uint, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if key, ok := m.keys[pub.Bytes]; ok {
+		return key, m.epoch, nil
+	}
+	// not a member of the committee, derived from the same reconfiguration block
+	rBlock := nodeCtx.blockchain.getReconfigurationBlock(m.epoch)
+	if rBlock == nil || rBlock.Hash != m.rBlock {
+		return [32]byte{}, 0, errors.New("no reconfiguration block for mac keys")
+	}
+	key, err := deriveMacKey(nodeCtx.self.Priv, pub, rBlock)
+	if err != nil {
+		return [32]byte{}, 0, err
+	}
+	m.keys[pub.Bytes] = key
+	return key, m.epoch, nil
+}
+
+func (m *MacKeys) getEpoch() uint {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.epoch
+}
+
+func consensusMac(key [32]byte, cMsg *ConsensusMsg) []byte {
+	h := cMsg.calculateHash()
+	e := make([]byte, 8)
+	binary.LittleEndian.PutUint64(e, uint64(cMsg.MacEpoch))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write(byteSliceAppend(h[:], e))
+	return mac.Sum(nil)
+}
+
+// returns "" if the mac of a message that was sent to us is valid, otherwise the reason. A
+// message of the next epoch can arrive before our keys are rotated, so we wait for them
+func (m *MacKeys) verify(nodeCtx *NodeCtx, cMsg *ConsensusMsg) string {
+	if cMsg.Pub == nil || len(cMsg.Mac) == 0 {
+		return "no mac"
+	}
+	drgWait(nodeCtx, func() bool { return m.getEpoch() >= cMsg.MacEpoch })
+	key, epoch, err := m.key(nodeCtx, cMsg.Pub)
+	if err != nil {
+		return err.Error()
+	}
+	if cMsg.MacEpoch != epoch {
+		return fmt.Sprintf("mac of epoch %d in epoch %d", cMsg.MacEpoch, epoch)
+	}
+	if !hmac.Equal(cMsg.Mac, consensusMac(key, cMsg)) {
+		return "invalid mac"
+	}
+	return ""
+}
+
+// signs cMsg and sends it to the committee and self, or with -mac sends every member a copy with
+// the mac for that member
+func sendConsensusMsg(cMsg *ConsensusMsg, nodeCtx *NodeCtx) {
+	if nodeCtx.stopped.get() || !nodeCtx.byzantine.vote(nodeCtx, cMsg) {
+		return
+	}
+	nodeCtx.events.publish(nodeCtx, VoteSentEvent{cMsg.Tag, cMsg.GossipHash})
+	if !nodeCtx.flagArgs.mac || cMsg.Tag == "accept" {
+		cMsg.sign(nodeCtx.self.Priv)
+		sendMsgToCommitteeAndSelf(Msg{"consensus", cMsg, nodeCtx.self.Priv.Pub}, nodeCtx)
+		return
+	}
+	send := func(pub *PubKey, ip string) {
+		key, epoch, err := nodeCtx.macKeys.key(nodeCtx, pub)
+		if err != nil {
+			errr(err, "mac key")
+			return
+		}
+		c := *cMsg
+		c.MacEpoch = epoch
+		c.Mac = consensusMac(key, &c)
+		spawnDialAndSend(ip, Msg{"consensus", &c, nodeCtx.self.Priv.Pub})
+	}
+	for _, member := range nodeCtx.committee.sortedMembers() {
+		send(member.Pub, member.IP)
+	}
+	send(nodeCtx.self.Priv.Pub, nodeCtx.self.IP)
+}
+
+// the messages with a signature, the ones a final block can be verified with
+func signedConsensusMsgs(cMsgs []*ConsensusMsg) []*ConsensusMsg {
+	signed := []*ConsensusMsg{}
+	for _, cMsg := range cMsgs {
+		if cMsg.Sig != nil {
+			signed = append(signed, cMsg)
+		}
+	}
+	return signed
+}
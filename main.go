@@ -1,39 +1,23 @@
 package main
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"encoding/gob"
 	"flag"
-	"log"
 	"os"
-	"strconv"
 	"time"
 )
 
 func main() {
 	// Program starts here. This function will spawn the x RC instances.
 
-	// defaults in defaults.go
-	// fierst args is coordinator or normal node
-	var functionMod string
-	if len(os.Args) > 1 {
-		functionMod = os.Args[1]
-	} else {
-		functionMod = default_function
-	}
-	// second args is the num of nodes
-	var instances uint
-	if len(os.Args) > 2 {
-		instances_int, _ := strconv.Atoi(os.Args[2])
-		instances = uint(instances_int)
-	} else {
-		instances = default_instances
-	}
+	defer recordPanic()
 
-	functionPtr := flag.String("function", functionMod, "coordinator or node")
+	// defaults in defaults.go, the subcommands and their flags in subcommands.go
+	sub, args := splitSubcommand(os.Args[1:])
+
+	functionPtr := flag.String("function", default_function, "what runs without a subcommand, one of the subcommands of rapidchain help")
 	vCPUs := flag.Uint("vpcus", default_vCPUs, "amount of VCPUs available")
-	instancesPerVCPUPtr := flag.Uint("instances", instances, "Instances per VCPU")
+	instancesPerVCPUPtr := flag.Uint("instances", default_instances, "Instances per VCPU")
 	nPtr := flag.Uint("n", default_n, "Total amount of nodes")
 	mPtr := flag.Uint("m", default_m, "Number of committees")
 	totalFPtr := flag.Uint("totalF", default_totalF, "Total adversary tolerance in the form of the divisor (1/x)")
@@ -45,12 +29,129 @@ func main() {
 	tpsPtr := flag.Uint("tps", default_tps, "transactions per second")
 	localPtr := flag.Bool("local", true, "local run on this computer")
 	deltaPtr := flag.Uint("delta", default_delta, "delta")
-	portsBegin := flag.Uint("ports", default_ip_ports, "default ip port beginning")
-	flag.Parse()
+	portsBegin := flag.Uint("ports", default_ip_ports, "port of the first node of the process, the others take the next ones. 0 is a free port for every node")
+	rampPtr := flag.Bool("ramp", false, "increase tps stepwise untill confirmation latency exceeds rampLatency")
+	rampStepPtr := flag.Uint("rampStep", default_rampStep, "tps increase per ramp step")
+	rampIntervalPtr := flag.Uint("rampInterval", default_rampInterval, "seconds per ramp step")
+	rampLatencyPtr := flag.Uint("rampLatency", default_rampLatency, "mean confirmation latency threshold in ms for ramp mode")
+	maxOrphansPtr := flag.Uint("maxOrphans", default_maxOrphans, "max orphan transactions held per node")
+	receiptsPtr := flag.Bool("receipts", false, "leaders return signed receipts for committed transactions")
+	admissionPtr := flag.String("admission", "", "comma separated mempool admission policies: ratelimit, minfee, maxpending")
+	senderRatePtr := flag.Uint("senderRate", default_senderRate, "txes per second per sender for the ratelimit policy")
+	minFeePtr := flag.Uint("minFee", default_minFee, "minimum fee for the minfee policy")
+	maxPendingPerUserPtr := flag.Uint("maxPendingPerUser", default_maxPendingPerUser, "max txes in tx pool per sender for the maxpending policy")
+	blockStorePtr := flag.String("blockStore", "", "directory for the on disk block store, empty keeps blocks only in memory")
+	blocksInMemoryPtr := flag.Uint("blocksInMemory", default_blocksInMemory, "committed blocks kept in memory when the block store is used")
+	retentionPtr := flag.Uint("retention", 0, "keep transaction bodies of the last k blocks, older blocks keep only header and signatures. 0 keeps all")
+	explorerPortPtr := flag.Uint("explorerPort", 0, "first port of the block explorer http api on nodes, the node count is added. 0 is off")
+	hashPtr := flag.String("hash", hashSHA256, "hash function of blocks, transactions and ida chunks: sha256 or blake3")
+	sigSchemePtr := flag.String("sigScheme", schemeECDSA, "signature scheme of new keys: ecdsa (P-256), ed25519 or bls")
+	keyfilePtr := flag.String("keyfile", "", "load the node key from this file, or create it. With more instances the node count is appended, node.pem -> node-3.pem")
+	stableAssignmentPtr := flag.Bool("stableAssignment", false, "coordinator assigns committees by key hash instead of randomly, so persistent keys get the same committees every run")
+	blsAggregatePtr := flag.Bool("blsAggregate", false, "aggregate the accept signatures of a block into one BLS certificate, needs -sigScheme bls")
+	powDifficultyPtr := flag.Uint("powDifficulty", 0, "leading zero bits of the proof of work a node needs to be admitted, 0 is off")
+	drgPtr := flag.Bool("drg", false, "the reference committee generates the epoch randomness by commit-reveal instead of the coordinator")
+	vrfPtr := flag.Bool("vrf", false, "elect leaders by the lowest vrf output instead of hash(pub | randomness | iteration), proposals need a valid claim")
+	runSeedPtr := flag.Int64("runSeed", 0, "coordinator seed of the run for derived keys and the committee assignment, 0 is random")
+	deriveKeysPtr := flag.Bool("deriveKeys", false, "derive the node keys from the run seed of the coordinator and the instance index instead of random keys")
+	epochLengthPtr := flag.Uint("epochLength", 0, "iterations per epoch, the committees are reconfigured with the cuckoo rule after every epoch. Implies -drg with -reference committee, 0 is off")
+	churnPtr := flag.Float64("churn", default_churn, "fraction of the nodes that leave and join again every epoch")
+	leaveAfterPtr := flag.Uint("leaveAfter", 0, "the nodes leave gracefully after this iteration, 0 is never")
+	referencePtr := flag.String("reference", referenceByCoordinator, "who generates the epoch randomness, produces the reconfiguration blocks and admits joining nodes: coordinator (trusted) or committee (the reference committee)")
+	joinPtr := flag.Bool("join", false, "the nodes join an experiment that is already running, needs its own -ports")
+	macPtr := flag.Bool("mac", false, "authenticate proposes and echos with pairwise hmac keys instead of signatures, ecdsa or bls keys only")
+	sigCachePtr := flag.Uint("sigCache", default_sigCache, "verified signatures and parsed keys that are cached, 0 is off")
+	blockCachePtr := flag.Uint("blockCache", default_blockCache, "blocks read from the block store that are cached in memory, 0 is off")
+	compressPtr := flag.Bool("compress", true, "zstd compress block bodies in the block store and ida gossip")
+	genesisGossipPtr := flag.Bool("genesisGossip", false, "coordinator only sends genesis hashes and committees ida gossip the genesis blocks")
+	churnRatePtr := flag.Float64("churnRate", 0, "nodes killed per minute during the run, they start again after churnDowntime. Also give it to the coordinator, 0 is off")
+	churnDistPtr := flag.String("churnDist", churnFixed, "time between kills: fixed, uniform or poisson")
+	churnDowntimePtr := flag.Uint("churnDowntime", default_churnDowntime, "seconds a killed node is down, 0 keeps it down")
+	adversaryPtr := flag.String("adversary", adversaryStatic, "how the adversary picks its nodes every epoch: static (the ones of the setup), random, reference or smallest (corrupts the reference or the smallest committees). Coordinator only, needs -epochLength")
+	weightsPtr := flag.String("weights", weightsEqual, "voting weights of the nodes: equal (one vote each), uniform or pareto (stake weighted), drawn by the coordinator")
+	maxWeightPtr := flag.Uint("maxWeight", default_maxWeight, "highest voting weight of a node with -weights")
+	epochsPtr := flag.Uint("epochs", default_dryRunEpochs, "epochs simulated by -function dryrun")
+	trialsPtr := flag.Uint("trials", default_dryRunTrials, "runs of -function dryrun, each from a new random assignment")
+	epochTriggerPtr := flag.String("epochTrigger", epochByBlocks, "what ends an epoch: blocks (-epochLength iterations), time (about -epochTime seconds) or churn (about -epochChurn of the nodes joined or left). The first epoch has -epochLength iterations")
+	epochTimePtr := flag.Uint("epochTime", default_epochTime, "seconds of an epoch with -epochTrigger time")
+	epochChurnPtr := flag.Float64("epochChurn", default_epochChurn, "fraction of the nodes that join or leave in an epoch with -epochTrigger churn")
+	bootstrapPtr := flag.String("bootstrap", bootstrapByCoordinator, "how the first committees are formed: coordinator (assigned by the coordinator) or election (an election network of the pow identities samples them with a seed, the nodes verify it). Give it to the coordinator and the nodes")
+	viewCheckPtr := flag.Uint("viewCheck", 0, "nodes report a hash of the members of their committee every viewCheck iterations, the coordinator flags nodes that disagree with their committee. 0 is off")
+	metricsPortPtr := flag.Uint("metricsPort", 0, "port of the prometheus /metrics endpoint of the coordinator, nodes use the next ports by their node count. 0 is off")
+	latencyStatsPtr := flag.String("latencyStats", latencyByEvents, "how nodes report latencies: events (a stat to the coordinator for every echo, accept, reconstruction and routed tx) or histograms (histograms sent every few seconds, with p50/p95/p99 in results/latency*.csv). Give it to the coordinator and the nodes")
+	pprofPortPtr := flag.Uint("pprofPort", 0, "port of net/http/pprof on the coordinator, nodes use the next ports by their node count. The coordinator also collects profiles of nodes at /profile?type=cpu&seconds=10&nodes=<key prefixes>|all. 0 is off")
+	traceCollectorPtr := flag.String("traceCollector", "", "otlp http endpoint the coordinator and the nodes export the spans of traced txs to, e.g. http://localhost:4318/v1/traces. Empty is off")
+	traceSamplePtr := flag.Float64("traceSample", default_traceSample, "fraction of the txs the coordinator traces with -traceCollector")
+	resourceIntervalPtr := flag.Uint("resourceInterval", 0, "seconds between the samples of rss, heap, goroutines, gc pauses and open fds a node sends to the coordinator, written to results/resources*/<node key>.csv. 0 is off")
+	progressPtr := flag.Uint("progress", default_progressInterval, "seconds between the summaries of the run the coordinator logs: nodes alive, final blocks per committee, tps and errors. 0 is off")
+	wireStatsPtr := flag.Bool("wireStats", false, "nodes count the messages they receive and their bytes by type, the coordinator writes the bytes by committee and type to results/wire*.csv when it is stopped. Give it to the nodes")
+	abortStuckPtr := flag.Uint("abortStuck", default_abortStuck, "the coordinator aborts the run with a diagnostic dump when no committee sent a final block for this many deltas. 0 is off")
+	abortSilentPtr := flag.Float64("abortSilent", default_abortSilent, "the coordinator aborts the run with a diagnostic dump when more than this fraction of the nodes sent no stat for a minute. 0 is off")
+	flightRecorderPtr := flag.Uint("flightRecorder", default_flightRecorder, "last events every node keeps in memory and writes to results/flight on a fatal error, a panic or when the coordinator asks. Also give it to the coordinator, 0 is off")
+	statsBatchPtr := flag.Uint("statsBatch", default_statsBatch, "seconds the nodes of a process queue their routing, ida and consensus stats before they send them to the coordinator in one batch, below 3 deltas. 0 sends every stat on its own")
+	statsKeepPtr := flag.Float64("statsKeep", default_statsKeep, "fraction of the txs and gossips, picked by their id, whose routing, ida and consensus stats the coordinator keeps")
+	statsRatePtr := flag.Uint("statsRate", default_statsRate, "routing, ida and consensus stats a second the coordinator handles at most, it drops the ones above. 0 for no limit")
+	statsSamplePtr := flag.Float64("statsSample", default_statsSample, "fraction of the nodes, picked by their key, that send routing, ida and consensus stats to the coordinator")
+	clockSyncPtr := flag.Bool("clockSync", true, "nodes measure the offset of their clock to the coordinator and send their timestamps in its time")
+	simLatencyPtr := flag.Uint("simLatency", default_simLatency, "milliseconds every connection of -function simulate takes to arrive")
+	simJitterPtr := flag.Uint("simJitter", default_simJitter, "up to this many milliseconds -function simulate adds at random to -simLatency")
+	simBandwidthPtr := flag.Uint("simBandwidth", default_simBandwidth, "bytes per second of every connection of -function simulate, 0 is unlimited")
+	simTimePtr := flag.Uint("simTime", default_simTime, "seconds -function simulate runs before it exports the results and exits, 0 runs until it is stopped")
+	faultsPtr := flag.String("faults", "", "json scenario of faults injected into chosen nodes with the kinds of /fault, see README.md. Give it to the coordinator and the nodes")
+	recoverEveryPtr := flag.Uint("recoverEvery", default_recoverEvery, "seconds the nodes of -function supervise run before they save their state, exit and are started again. Also give it to the coordinator, 0 is off")
+	recoverNodesPtr := flag.Uint("recoverNodes", default_recoverNodes, "nodes of -function supervise that exit every -recoverEvery seconds")
+	recoverExitPtr := flag.Bool("recoverExit", false, "set by -function supervise on the nodes that exit")
+	recoverFromPtr := flag.Int64("recoverFrom", 0, "set by -function supervise on a node it starts again, unix nanoseconds of its exit")
+	chaosPtr := flag.Bool("chaos", false, "the nodes delay, reorder and rarely drop the messages they get, seeded with -runSeed")
+	goldenTracePtr := flag.String("goldenTrace", "", "stored trace -function tracediff compares -runTrace with")
+	runTracePtr := flag.String("runTrace", "", "results/trace*.txt of a run that -function tracediff compares with -goldenTrace")
+	traceFieldsPtr := flag.String("traceFields", default_traceFields, "fields of the blocks -function tracediff compares: leader, txs and sigs")
+	byzantinePtr := flag.String("byzantine", "", "what the adversaries of the setup do, comma separated strategies or prefix=strategy of equivocate, silent, withhold and blackhole. A node takes every prefix=strategy its key starts with, or else one without a prefix")
+	simLossPtr := flag.Float64("simLoss", 0, "share of the connections of -function simulate that are lost once and arrive after a retransmission timeout. It arrives after a retransmission timeout of default_simRetransmit ms, doubled for every further loss")
+	netProfilePtr := flag.String("netProfile", "", "named link model of -function simulate that sets -simLatency, -simJitter, -simBandwidth and -simLoss: "+netProfileNames()+". A flag that is given as well wins")
+	sweepPtr := flag.String("sweep", "", "grid of -function sweep, values of n, m, B, tps and delta, e.g. n=8,16;m=2;tps=2,4. Every point runs in -sweepDir, its row goes to sweep.csv there")
+	sweepRunPtr := flag.String("sweepRun", sweepSimulate, "how -function sweep runs a point: simulate (one process) or cluster (a coordinator and a node process on this machine)")
+	sweepTimePtr := flag.Uint("sweepTime", default_sweepTime, "seconds every point of -function sweep runs")
+	sweepDirPtr := flag.String("sweepDir", "sweep", "directory of the runs and the dataset of -function sweep, an interrupted sweep in it goes on")
+	coordinatorPtr := flag.String("coordinator", "", "host of the coordinator the nodes dial, by default 127.0.0.1 with -local and the aws address without")
+	advertisePtr := flag.String("advertise", "", "host the nodes give the coordinator instead of the one their connection comes from, they listen on all interfaces. The pod ip on kubernetes")
+	imagePtr := flag.String("image", "rapidchain:latest", "image of the pods of genmanifests")
+	awsTemplatePtr := flag.String("awsTemplate", "", "ec2 launch template of the instances of aws-launch")
+	awsRegionPtr := flag.String("awsRegion", "", "aws region of aws-launch, the one of the aws config if empty")
+	awsBucketPtr := flag.String("awsBucket", "rapidchain-bucket", "s3 bucket of aws-launch with the binary of aws-deploy.sh, the results of the run go to its results/")
+	awsRunTimePtr := flag.Uint("awsRunTime", default_awsRunTime, "seconds the coordinator of aws-launch runs before it writes its results and the instances are terminated")
+	awsStatsParameterPtr := flag.String("awsStatsParameter", "", "ssm parameter with the -statsSecret the instances of aws-launch read with their role, the secret is not passed in their user data")
+	resultsDirPtr := flag.String("resultsDir", "results", "directory of the results, created if it does not exist")
+	runNamePtr := flag.String("runName", "", "name of the run in the names of its results, e.g. results/tx-<runName>.csv. The time the process started by default")
+	restartsPtr := flag.Uint("restarts", 0, "times a node that panics is started again, after default_restartBackoff seconds and twice as long every time. With 0 it stays down and the other nodes of the process go on")
+	preflightPtr := flag.Bool("preflight", true, "check the open files limit, the ports, the disk under -resultsDir and the clock before the run and end on a problem. false skips the checks")
+	cpuSetPtr := flag.String("cpuSet", "", "cpus the process is pinned to on linux, like 0-3,8. GOMAXPROCS is their number without -vpcus, supervise splits them among its node processes")
+	mixedBuildsPtr := flag.Bool("mixedBuilds", false, "the coordinator admits nodes of another version or commit than its own with a warning instead of refusing them")
+	standbyPtr := flag.String("standby", "", "host of a standby coordinator (rapidchain standby) the coordinator mirrors the run to, it takes over the stats when the coordinator dies. The nodes take a takeover only from this host")
+	statsSecretPtr := flag.String("statsSecret", "", "secret the connections to the coordinator start with a token of and its pprof and metrics want as bearer token, better as RC_STATS_SECRET")
+	logLevelPtr := flag.String("logLevel", "info", "lowest level that is logged: debug, info, warn or error")
+	logModulesPtr := flag.String("logModules", "", "levels per module that override -logLevel, e.g. consensus=debug,ida=warn. Modules are consensus, ida, routing, coordinator and node")
+	equivocatePtr := flag.Bool("equivocate", false, "the adversaries of the setup gossip a second block in every iteration they lead, so they are blacklisted")
+	fastSyncNodesPtr := flag.Uint("fastSyncNodes", 0, "nodes per instance that skip the genesis block and join by state sync")
+	flag.Usage = func() { subcommandUsage(sub) }
+	// a bad flag is a usage error, not the exit code of a panic
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(args); err == flag.ErrHelp {
+		os.Exit(exitSuccess)
+	} else if err != nil {
+		os.Exit(exitUsage)
+	}
+	envFunction(sub)
+	function := checkSubcommand(sub, *functionPtr)
+	applyEnv(function)
+	if reason := setLogLevels(*logLevelPtr, *logModulesPtr); reason != "" {
+		errFatal(nil, reason)
+	}
+	applyNetProfile(*netProfilePtr)
 
 	var flagArgs FlagArgs
 
-	flagArgs.function = *functionPtr
+	flagArgs.function = function
 	flagArgs.vCPUs = *vCPUs
 	flagArgs.instances = *instancesPerVCPUPtr
 	flagArgs.n = *nPtr
@@ -65,60 +166,297 @@ func main() {
 	flagArgs.local = *localPtr
 	flagArgs.delta = *deltaPtr
 	flagArgs.portsBegin = *portsBegin
-	// generate a random key to send the P256 curve interface to gob.Register because it wouldnt cooperate
-	randomKey := new(PrivKey)
-	randomKey.gen()
+	flagArgs.ramp = *rampPtr
+	flagArgs.rampStep = *rampStepPtr
+	flagArgs.rampInterval = *rampIntervalPtr
+	flagArgs.rampLatency = *rampLatencyPtr
+	flagArgs.maxOrphans = *maxOrphansPtr
+	flagArgs.receipts = *receiptsPtr
+	flagArgs.admission = *admissionPtr
+	flagArgs.senderRate = *senderRatePtr
+	flagArgs.minFee = *minFeePtr
+	flagArgs.maxPendingPerUser = *maxPendingPerUserPtr
+	flagArgs.blockStore = *blockStorePtr
+	flagArgs.blocksInMemory = *blocksInMemoryPtr
+	flagArgs.fastSyncNodes = *fastSyncNodesPtr
+	flagArgs.retention = *retentionPtr
+	flagArgs.explorerPort = *explorerPortPtr
+	flagArgs.metricsPort = *metricsPortPtr
+	flagArgs.pprofPort = *pprofPortPtr
+	flagArgs.latencyStats = *latencyStatsPtr
+	flagArgs.traceCollector = *traceCollectorPtr
+	flagArgs.traceSample = *traceSamplePtr
+	flagArgs.resourceInterval = *resourceIntervalPtr
+	flagArgs.progress = *progressPtr
+	flagArgs.wireStats = *wireStatsPtr
+	flagArgs.abortStuck = *abortStuckPtr
+	flagArgs.abortSilent = *abortSilentPtr
+	flagArgs.flightRecorder = *flightRecorderPtr
+	flagArgs.statsBatch = *statsBatchPtr
+	flagArgs.statsSample = *statsSamplePtr
+	flagArgs.statsKeep = *statsKeepPtr
+	flagArgs.statsRate = *statsRatePtr
+	flagArgs.clockSync = *clockSyncPtr
+	flagArgs.simLatency = *simLatencyPtr
+	flagArgs.simJitter = *simJitterPtr
+	flagArgs.simBandwidth = *simBandwidthPtr
+	flagArgs.simTime = *simTimePtr
+	flagArgs.faults = *faultsPtr
+	flagArgs.recoverEvery = *recoverEveryPtr
+	flagArgs.recoverNodes = *recoverNodesPtr
+	flagArgs.recoverExit = *recoverExitPtr
+	flagArgs.recoverFrom = *recoverFromPtr
+	flagArgs.chaos = *chaosPtr
+	flagArgs.goldenTrace = *goldenTracePtr
+	flagArgs.runTrace = *runTracePtr
+	flagArgs.traceFields = *traceFieldsPtr
+	flagArgs.byzantine = *byzantinePtr
+	flagArgs.simLoss = *simLossPtr
+	flagArgs.sweep = *sweepPtr
+	flagArgs.sweepRun = *sweepRunPtr
+	flagArgs.sweepTime = *sweepTimePtr
+	flagArgs.sweepDir = *sweepDirPtr
+	flagArgs.coordinator = *coordinatorPtr
+	flagArgs.advertise = *advertisePtr
+	flagArgs.image = *imagePtr
+	flagArgs.awsTemplate = *awsTemplatePtr
+	flagArgs.awsRegion = *awsRegionPtr
+	flagArgs.awsBucket = *awsBucketPtr
+	flagArgs.awsRunTime = *awsRunTimePtr
+	flagArgs.awsStatsParameter = *awsStatsParameterPtr
+	flagArgs.resultsDir = *resultsDirPtr
+	flagArgs.runName = *runNamePtr
+	flagArgs.restarts = *restartsPtr
+	flagArgs.preflight = *preflightPtr
+	flagArgs.cpuSet = *cpuSetPtr
+	flagArgs.mixedBuilds = *mixedBuildsPtr
+	flagArgs.standby = *standbyPtr
+	flagArgs.statsSecret = *statsSecretPtr
+	flagArgs.genesisGossip = *genesisGossipPtr
+	flagArgs.compress = *compressPtr
+	flagArgs.blockCache = *blockCachePtr
+	flagArgs.keyfile = *keyfilePtr
+	flagArgs.stableAssignment = *stableAssignmentPtr
+	flagArgs.blsAggregate = *blsAggregatePtr
+	flagArgs.vrf = *vrfPtr
+	flagArgs.drg = *drgPtr
+	flagArgs.powDifficulty = *powDifficultyPtr
+	flagArgs.mac = *macPtr
+	flagArgs.runSeed = *runSeedPtr
+	flagArgs.deriveKeys = *deriveKeysPtr
+	flagArgs.epochLength = *epochLengthPtr
+	flagArgs.churn = *churnPtr
+	flagArgs.join = *joinPtr
+	flagArgs.leaveAfter = *leaveAfterPtr
+	flagArgs.reference = *referencePtr
+	flagArgs.churnRate = *churnRatePtr
+	flagArgs.churnDist = *churnDistPtr
+	flagArgs.churnDowntime = *churnDowntimePtr
+	flagArgs.adversary = *adversaryPtr
+	flagArgs.weights = *weightsPtr
+	flagArgs.maxWeight = *maxWeightPtr
+	flagArgs.epochs = *epochsPtr
+	flagArgs.trials = *trialsPtr
+	flagArgs.equivocate = *equivocatePtr
+	flagArgs.epochTrigger = *epochTriggerPtr
+	flagArgs.epochTime = *epochTimePtr
+	flagArgs.epochChurn = *epochChurnPtr
+	flagArgs.bootstrap = *bootstrapPtr
+	flagArgs.viewCheck = *viewCheckPtr
+	if !isChurnDist(flagArgs.churnDist) {
+		errFatal(nil, "unknown -churnDist "+flagArgs.churnDist)
+	}
+	if !isWeightsDist(flagArgs.weights) || flagArgs.maxWeight == 0 {
+		errFatal(nil, "unknown -weights "+flagArgs.weights+" or -maxWeight 0")
+	}
+	if !isAdversaryStrategy(flagArgs.adversary) {
+		errFatal(nil, "unknown -adversary "+flagArgs.adversary)
+	}
+	if !isBootstrapMode(flagArgs.bootstrap) {
+		errFatal(nil, "unknown -bootstrap "+flagArgs.bootstrap)
+	}
+	if !isLatencyStats(flagArgs.latencyStats) {
+		errFatal(nil, "unknown -latencyStats "+flagArgs.latencyStats)
+	}
+	if flagArgs.traceSample < 0 || flagArgs.traceSample > 1 {
+		errFatal(nil, "-traceSample must be between 0 and 1")
+	}
+	if flagArgs.abortSilent < 0 || flagArgs.abortSilent >= 1 {
+		errFatal(nil, "-abortSilent must be at least 0 and below 1")
+	}
+	if flagArgs.statsSample < 0 || flagArgs.statsSample > 1 || flagArgs.statsKeep < 0 || flagArgs.statsKeep > 1 {
+		errFatal(nil, "-statsSample and -statsKeep must be between 0 and 1")
+	}
+	if !isEpochTrigger(flagArgs.epochTrigger) || flagArgs.epochTime == 0 || flagArgs.epochChurn <= 0 {
+		errFatal(nil, "unknown -epochTrigger "+flagArgs.epochTrigger+", or -epochTime or -epochChurn 0")
+	}
+	// a peer that is down is skipped instead of ending the run
+	peersCanCrash = flagArgs.churnRate > 0 || flagArgs.recoverEvery > 0 || flagArgs.restarts > 0
+	if flagArgs.faults != "" {
+		readFaultScenario(flagArgs.faults)
+	}
+	if flagArgs.chaos {
+		initChaos(&flagArgs)
+	}
+	checkByzantine(&flagArgs)
+	if flagArgs.simLoss < 0 || flagArgs.simLoss >= 1 {
+		errFatal(nil, "-simLoss must be at least 0 and below 1")
+	}
+	if !isReferenceMode(flagArgs.reference) {
+		errFatal(nil, "unknown -reference "+flagArgs.reference)
+	}
+	// the reference committee draws the randomness of every epoch with the drg
+	flagArgs.drg = flagArgs.drg || (flagArgs.epochLength > 0 && flagArgs.reference == referenceByCommittee)
 
-	// register structs with gob
-	gob.Register(IDAGossipMsg{})
-	gob.Register([32]uint8{})
-	gob.Register(ProposedBlock{})
-	gob.Register(KademliaFindNodeMsg{})
-	gob.Register(KademliaFindNodeResponse{})
-	gob.Register(elliptic.CurveParams{})
-	gob.Register(ecdsa.PublicKey{})
-	gob.Register(PubKey{})
-	gob.Register(randomKey.Pub.Pub.Curve)
-	gob.Register(ConsensusMsg{})
-	gob.Register(Transaction{})
-	gob.Register(FinalBlock{})
-	dur := time.Now().Sub(time.Now())
-	gob.Register(dur)
-	gob.Register(ByteArrayAndTimestamp{})
-	gob.Register(RequestBlockAnswer{})
+	registerGobTypes()
+
+	if !isSigScheme(*sigSchemePtr) {
+		errFatal(nil, "unknown -sigScheme "+*sigSchemePtr)
+	}
+	sigScheme = *sigSchemePtr
+	if !isHashFunction(*hashPtr) {
+		errFatal(nil, "unknown -hash "+*hashPtr)
+	}
+	setHashFunction(*hashPtr)
+	initSigCaches(*sigCachePtr)
 
 	if flagArgs.local {
 		coord = coord_local
-		log.Println("local mod")
+		nodeLog.infof(nil, "local mod")
 	} else {
 		coord = coord_aws
-		log.Println("aws mod")
+		nodeLog.infof(nil, "aws mod")
+	}
+	if flagArgs.coordinator != "" {
+		coord = flagArgs.coordinator
 	}
-	log.Println("Coordinator IP: ", coord)
+	nodeLog.infof(nil, "Coordinator IP: %v", coord)
+	setResults(flagArgs.resultsDir, flagArgs.runName)
+	startExitStatus(subcommandMode(function))
+	startStatsAuth(subcommandMode(function), &flagArgs)
 
 	// ensure some invariants
 	if default_kappa > 256 {
 		errFatal(nil, "Default kappa was over 256/1byte")
 	}
 
-	// runtime.GOMAXPROCS(int(flagArgs.vCPUs))
+	applyCPUs(&flagArgs, givenFlags()["vpcus"])
+	preflight(subcommandMode(function), &flagArgs)
 
-	if *functionPtr == "coordinator" {
-		log.Println("Launching coordinator")
+	// errFatal in a node stops the node, not the process
+	switch subcommandMode(function) {
+	case "node", "simulate", "demo":
+		supervised = true
+	}
+
+	switch subcommandMode(function) {
+	case "coordinator":
+		coordinatorLog.infof(nil, "Launching coordinator")
 		launchCoordinator(&flagArgs)
-	} else {
+	case "standby":
+		coordinatorLog.infof(nil, "Launching standby coordinator")
+		launchCoordinator(&flagArgs)
+	case "benchcrypto":
+		benchCrypto(&flagArgs)
+	case "bench-ida":
+		benchIDA(&flagArgs)
+	case "bench-consensus":
+		benchConsensus(&flagArgs)
+	case "bench-routing":
+		benchRouting(&flagArgs)
+	case "audit":
+		audit(&flagArgs)
+	case "version":
+		printVersion()
+	case "dryrun":
+		dryRun(&flagArgs)
+	case "simulate":
+		simulate(&flagArgs)
+	case "demo":
+		demo(&flagArgs)
+	case "supervise":
+		supervise(&flagArgs)
+	case "tracediff":
+		traceDiff(&flagArgs)
+	case "sweep":
+		sweep(&flagArgs)
+	case "aws-launch":
+		awsLaunch(&flagArgs)
+	case "genmanifests":
+		genManifests(&flagArgs)
+	case "node":
 		launchNodes(&flagArgs)
 	}
 
 }
 
 func launchNodes(flagArgs *FlagArgs) {
-	log.Println("Launcing ", flagArgs.instances, " instances")
+	nodeLog.infof(nil, "Launcing %v instances", flagArgs.instances)
+	waitForCoordinator(flagArgs)
+	preflightClock(flagArgs)
+	instances := make([]*Instance, flagArgs.instances)
 	for i := uint(0); i < flagArgs.instances; i++ {
-		go launchNode(flagArgs, i)
+		instances[i] = &Instance{count: i}
+		if flagArgs.recoverFrom != 0 {
+			in := instances[i]
+			spawn(func() { in.supervise(recoverNode, flagArgs) })
+			continue
+		}
+		in := instances[i]
+		spawn(func() { in.supervise(launchNode, flagArgs) })
 	}
-
-	for {
-
+	if flagArgs.churnRate > 0 && flagArgs.local {
+		spawn(func() { churnLocal(flagArgs, instances) })
 	}
+	shutdownOnSignal(instances)
+}
+
+// register structs with gob
+func registerGobTypes() {
+	gob.Register(IDAGossipMsg{})
+	gob.Register([32]uint8{})
+	gob.Register(ProposedBlock{})
+	gob.Register(KademliaFindNodeMsg{})
+	gob.Register(KademliaFindNodeResponse{})
+	gob.Register(PubKey{})
+	gob.Register(ConsensusMsg{})
+	gob.Register(Transaction{})
+	gob.Register(FinalBlock{})
+	dur := time.Now().Sub(time.Now())
+	gob.Register(dur)
+	gob.Register(ByteArrayAndTimestamp{})
+	gob.Register(RequestBlockAnswer{})
+	gob.Register(TxReceiptBatch{})
+	gob.Register(ForkReport{})
+	gob.Register(TxInclusionProof{})
+	gob.Register(StateSnapshot{})
+	gob.Register(StateHashAnswer{})
+	gob.Register(RequestBlocksMsg{})
+	gob.Register(VrfClaim{})
+	gob.Register(DrgCommit{})
+	gob.Register(DrgReveal{})
+	gob.Register(DrgResult{})
+	gob.Register(ReconfigurationBlock{})
+	gob.Register(NodeAllInfo{})
+	gob.Register(Node_InitialMessageToCoordinator{})
+	gob.Register(Leave{})
+	gob.Register(EpochRequest{})
+	gob.Register(JoinRequest{})
+	gob.Register(JoinCertificate{})
+	gob.Register(RecBlockSig{})
+	gob.Register(CertifiedReconfiguration{})
+	gob.Register(CommitteeMsg{})
+	gob.Register(ControlMsg{})
+	gob.Register(EquivocationProof{})
+	gob.Register(CommitteeView{})
+	gob.Register(ProfileRequest{})
+	gob.Register(LatencyReport{})
+	gob.Register(ResourceSample{})
+	gob.Register(WireReport{})
+	gob.Register(StatsBatch{})
+	gob.Register(BlockPhases{})
+	gob.Register(ClockAnswer{})
+	gob.Register(Fault{})
+	gob.Register(Tuning{})
+	gob.Register(FaultHit{})
 }
@@ -0,0 +1,54 @@
+package main
+
+// the flags of a local run with their defaults of main.go, the results go to dir
+func testFlagArgs(dir string) *FlagArgs {
+	return &FlagArgs{
+		vCPUs:             default_vCPUs,
+		instances:         default_instances,
+		n:                 default_n,
+		m:                 default_m,
+		totalF:            default_totalF,
+		committeeF:        default_committeeF,
+		B:                 default_B,
+		nUsers:            default_nUsers,
+		totalCoins:        default_totalCoins,
+		tps:               default_tps,
+		local:             true,
+		delta:             default_delta,
+		portsBegin:        default_ip_ports,
+		rampStep:          default_rampStep,
+		rampInterval:      default_rampInterval,
+		rampLatency:       default_rampLatency,
+		maxOrphans:        default_maxOrphans,
+		senderRate:        default_senderRate,
+		minFee:            default_minFee,
+		maxPendingPerUser: default_maxPendingPerUser,
+		blocksInMemory:    default_blocksInMemory,
+		compress:          true,
+		blockCache:        default_blockCache,
+		churn:             default_churn,
+		reference:         referenceByCoordinator,
+		churnDist:         churnFixed,
+		churnDowntime:     default_churnDowntime,
+		adversary:         adversaryStatic,
+		weights:           weightsEqual,
+		maxWeight:         default_maxWeight,
+		epochTrigger:      epochByBlocks,
+		epochTime:         default_epochTime,
+		epochChurn:        default_epochChurn,
+		bootstrap:         bootstrapByCoordinator,
+		latencyStats:      latencyByEvents,
+		traceSample:       default_traceSample,
+		progress:          default_progressInterval,
+		abortStuck:        default_abortStuck,
+		abortSilent:       default_abortSilent,
+		flightRecorder:    default_flightRecorder,
+		statsBatch:        default_statsBatch,
+		statsSample:       default_statsSample,
+		statsKeep:         default_statsKeep,
+		statsRate:         default_statsRate,
+		clockSync:         true,
+		recoverNodes:      default_recoverNodes,
+		resultsDir:        dir,
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	runtimedebug "runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the manifest of a run with the commit, the flags and the schema of every results file
+
+const manifestVersion = 1
+
+// set by the linker
+var gitCommit string
+
+// the commit of a binary built without git, like the Dockerfile without --build-arg
+const unknownCommit = "unknown"
+
+// schema version of every kind of results file, 0 in a manifest is a kind that is not listed here
+var resultSchemas = map[string]int{
+	// the rows start with the time in unix nanoseconds since 2, in seconds before
+	"tx.csv":                  2,
+	"pocverify.csv":           2,
+	"pocadd.csv":              2,
+	"routing.csv":             2,
+	"ida.csv":                 2,
+	"consensusacceptfail.csv": 2,
+	"ramp.csv":                2,
+	"txclass.csv":             2,
+	"orphan.csv":              2,
+	"receipts.csv":            2,
+	"admission.csv":           2,
+	"fork.csv":                2,
+	"bootstrap.csv":           2,
+	"compression.csv":         2,
+	"blockcache.csv":          2,
+	"ledger.csv":              2,
+	"drg.csv":                 2,
+	"pow.csv":                 2,
+	"sigcache.csv":            2,
+	"epoch.csv":               2,
+	"join.csv":                2,
+	"leave.csv":               2,
+	"switch.csv":              2,
+	"churn.csv":               2,
+	"adversary.csv":           2,
+	"blacklist.csv":           2,
+	"election.csv":            2,
+	"views.csv":               2,
+	"latency.csv":             2,
+	"epochstats.csv":          2,
+	"chains.json":             1,
+	"chains.dot":              1,
+	"ledger.json":             1,
+	"wire.csv":                1,
+	"phases.csv":              1,
+	"tuning.csv":              1,
+	"faults.csv":              1,
+	"abort.txt":               1,
+	"status.json":             1,
+	"resources/":              1,
+	"flight/":                 1,
+	"profiles/":               1,
+}
+
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Schema int    `json:"schema"`
+}
+
+type Manifest struct {
+	Version        int               `json:"manifest_version"`
+	BuildVersion   string            `json:"version"`
+	Commit         string            `json:"commit"`
+	Flags          map[string]string `json:"flags"`
+	RunSeed        int64             `json:"run_seed"`
+	Start          time.Time         `json:"start"`
+	End            *time.Time        `json:"end,omitempty"`
+	NodesRequested uint              `json:"nodes_requested"`
+	Nodes          int               `json:"nodes"`
+	NodeBuilds     map[string]uint   `json:"node_builds,omitempty"`
+	Aborted        string            `json:"aborted,omitempty"`
+	Files          []ManifestFile    `json:"files"`
+	name           string
+	mux            sync.Mutex
+}
+
+// the commit of the binary, or the one go build recorded with a + when the tree had changes, see
+// build.go
+func runCommit() string {
+	if gitCommit != "" {
+		return gitCommit
+	}
+	info, ok := runtimedebug.ReadBuildInfo()
+	if !ok {
+		return unknownCommit
+	}
+	commit, modified := "", false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			commit = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if commit == "" {
+		return unknownCommit
+	}
+	if modified {
+		commit += "+"
+	}
+	return commit
+}
+
+func (m *Manifest) init(flagArgs *FlagArgs) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.Version = manifestVersion
+	m.BuildVersion = currentBuild().Version
+	m.Commit = currentBuild().Commit
+	m.Flags = make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		m.Flags[f.Name] = f.Value.String()
+	})
+	if m.Flags["statsSecret"] != "" {
+		m.Flags["statsSecret"] = "set"
+	}
+	m.RunSeed = flagArgs.runSeed
+	m.Start = time.Now()
+	m.NodesRequested = flagArgs.n
+	m.name = filepath.ToSlash(resultsName("manifest") + ".json")
+}
+
+// the kind of a results file, the name in -resultsDir up to the name of the run
+func resultKind(name string) string {
+	rel := filepath.ToSlash(name)
+	if r, err := filepath.Rel(resultsDir, name); err == nil {
+		rel = filepath.ToSlash(r)
+	}
+	dir := strings.Index(rel, "/")
+	if dir >= 0 {
+		rel = rel[:dir]
+	}
+	prefix := rel
+	if i := strings.Index(rel, "-"); i >= 0 {
+		prefix = rel[:i]
+	}
+	if dir >= 0 {
+		return prefix + "/"
+	}
+	return prefix + filepath.Ext(rel)
+}
+
+// the files in results changed since the start of the run, file times lag the clock by some
+// milliseconds
+func (m *Manifest) _scan() {
+	m.Files = []ManifestFile{}
+	since := m.Start.Truncate(time.Second)
+	filepath.Walk(resultsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.ModTime().Before(since) || filepath.ToSlash(path) == m.name {
+			return nil
+		}
+		kind := resultKind(path)
+		m.Files = append(m.Files, ManifestFile{filepath.ToSlash(path), kind, resultSchemas[kind]})
+		return nil
+	})
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Name < m.Files[j].Name })
+}
+
+// writes the manifest with the nodes that registered so far
+func (m *Manifest) write(ms *Membership) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if ms != nil {
+		ms.mux.Lock()
+		m.Nodes = len(ms.nodes)
+		ms.mux.Unlock()
+		m.NodeBuilds = ms.builds.counts()
+	}
+	m._scan()
+	b, err := json.MarshalIndent(m, "", "  ")
+	if ifErr(err, "manifest encode") {
+		return
+	}
+	ifErr(os.WriteFile(m.name, b, 0644), "manifest")
+}
+
+// the end of the run, aborted is the reason of the watchdog or empty
+func (m *Manifest) finish(ms *Membership, aborted string) {
+	m.mux.Lock()
+	end := time.Now()
+	m.End = &end
+	m.Aborted = aborted
+	m.mux.Unlock()
+	m.write(ms)
+}
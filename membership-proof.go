@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+	"sync"
+
+	"github.com/renzhf/go-merkletree"
+)
+
+// the proof that the sender of a msg to another committee is a member of its committee
+
+// the messages that are only accepted with a membership proof
+func needsMembershipProof(typ string) bool {
+	return typ == "crosstransaction" || typ == "crosstransactionresponse" || typ == "find_node"
+}
+
+type MembershipProof struct {
+	CommitteeID [32]byte
+	Pub         *PubKey
+	RBlockHash  [32]byte // the reconfiguration block of the epoch of the sender
+	Epoch       uint     // the epoch of the sender, only a block of the next one is waited for
+	Path        *merkletree.Proof
+	Sig         *Sig // on the message and RBlockHash, see membershipSigHash
+}
+
+// a Msg to another committee with the membership proof of the sender. Msg is encoded so the
+// receiver verifies the signature on the bytes that were signed
+type CommitteeMsg struct {
+	Msg   []byte
+	Proof MembershipProof
+}
+
+func membershipLeaf(committeeID, pub [32]byte) []byte {
+	h := hash(byteSliceAppend([]byte("member"), committeeID[:], pub[:]))
+	return h[:]
+}
+
+func membershipSigHash(data []byte, rBlockHash [32]byte) [32]byte {
+	return hash(byteSliceAppend([]byte("membership"), data, rBlockHash[:]))
+}
+
+// the merkle tree over the members of a reconfiguration block, and the leaf of every member
+type MembershipTree struct {
+	tree  *merkletree.MerkleTree
+	index map[[32]byte]int // Pub.Bytes -> leaf
+}
+
+// the trees of the reconfiguration blocks a node has. A block that changed with a join or a leave is
+// a new block with the same hash, so they are kept by block and not by hash
+type MembershipTrees struct {
+	trees   map[*ReconfigurationBlock]*MembershipTree
+	waiting int // messages that wait for the block of the next epoch
+	mux     sync.Mutex
+}
+
+// false if default_membershipWaits messages wait already
+func (mt *MembershipTrees) wait() bool {
+	mt.mux.Lock()
+	defer mt.mux.Unlock()
+	if mt.waiting >= default_membershipWaits {
+		return false
+	}
+	mt.waiting++
+	return true
+}
+
+func (mt *MembershipTrees) done() {
+	mt.mux.Lock()
+	defer mt.mux.Unlock()
+	mt.waiting--
+}
+
+func (mt *MembershipTrees) get(rBlock *ReconfigurationBlock) *MembershipTree {
+	mt.mux.Lock()
+	defer mt.mux.Unlock()
+	if mt.trees == nil {
+		mt.trees = make(map[*ReconfigurationBlock]*MembershipTree)
+	}
+	if t, ok := mt.trees[rBlock]; ok {
+		return t
+	}
+	t := newMembershipTree(rBlock)
+	mt.trees[rBlock] = t
+	return t
+}
+
+func newMembershipTree(rBlock *ReconfigurationBlock) *MembershipTree {
+	type member struct {
+		pub  [32]byte
+		leaf []byte
+	}
+	members := []member{}
+	for id, c := range rBlock.Committees {
+		for pub := range c.Members {
+			members = append(members, member{pub, membershipLeaf(id, pub)})
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return bytes.Compare(members[i].leaf, members[j].leaf) < 0 })
+	data := make([][]byte, len(members))
+	t := &MembershipTree{index: make(map[[32]byte]int)}
+	for i, m := range members {
+		data[i] = m.leaf
+		t.index[m.pub] = i
+	}
+	tree, err := merkletree.New(data)
+	ifErrFatal(err, "creating membership tree")
+	t.tree = tree
+	return t
+}
+
+// the membership proof of this node on data
+func proveMembership(nodeCtx *NodeCtx, data []byte) MembershipProof {
+	rBlock := nodeCtx.blockchain.getLastReconfigurationBlock()
+	self := nodeCtx.self.Priv.Pub
+	p := MembershipProof{CommitteeID: nodeCtx.committeeID(), Pub: self, RBlockHash: rBlock.Hash, Epoch: nodeCtx.blockchain.epoch()}
+	t := nodeCtx.membershipTrees.get(rBlock)
+	i, ok := t.index[self.Bytes]
+	if !ok {
+		errr(nil, "this node is not in its reconfiguration block")
+		return p
+	}
+	path, err := t.tree.GenerateProofUsingIndex(uint64(i), 0)
+	ifErrFatal(err, "membership proof")
+	p.Path = path
+	p.Sig = nodeCtx.self.Priv.sign(membershipSigHash(data, rBlock.Hash))
+	return p
+}
+
+// msg with the membership proof of this node
+func withMembershipProof(nodeCtx *NodeCtx, msg Msg) Msg {
+	data := getBytes(msg)
+	return Msg{"committee_msg", CommitteeMsg{data, proveMembership(nodeCtx, data)}, nodeCtx.self.Priv.Pub}
+}
+
+// the reconfiguration block of a proof, the current one or the one before it like for the proof of
+// consensus. The committees do not switch at the same time, so a message of a committee that is in
+// the next epoch waits until this node switched too. Others are dropped at once
+func membershipBlock(nodeCtx *NodeCtx, h [32]byte, senderEpoch uint) *ReconfigurationBlock {
+	find := func() *ReconfigurationBlock {
+		epoch := nodeCtx.blockchain.epoch()
+		if rBlock := nodeCtx.blockchain.getReconfigurationBlock(epoch); rBlock.Hash == h {
+			return rBlock
+		}
+		if epoch > 0 {
+			if rBlock := nodeCtx.blockchain.getReconfigurationBlock(epoch - 1); rBlock.Hash == h {
+				return rBlock
+			}
+		}
+		return nil
+	}
+	rBlock := find()
+	if rBlock != nil || senderEpoch != nodeCtx.blockchain.epoch()+1 || !nodeCtx.membershipTrees.wait() {
+		return rBlock
+	}
+	defer nodeCtx.membershipTrees.done()
+	pollWait(nodeCtx, default_drgTimeout, func() bool {
+		rBlock = find()
+		return rBlock != nil
+	})
+	return rBlock
+}
+
+// returns "" if p proves that its sender is a member of its committee and signed data, otherwise the
+// reason
+func verifyMembership(nodeCtx *NodeCtx, data []byte, p *MembershipProof) string {
+	if p.Pub == nil || p.Path == nil {
+		return "no proof"
+	}
+	rBlock := membershipBlock(nodeCtx, p.RBlockHash, p.Epoch)
+	if rBlock == nil {
+		return "unknown or old reconfiguration block " + bytes32ToString(p.RBlockHash)
+	}
+	root := nodeCtx.membershipTrees.get(rBlock).tree.Root()
+	verified, err := merkletree.VerifyProof(membershipLeaf(p.CommitteeID, p.Pub.Bytes), false, p.Path, [][]byte{root})
+	if err != nil || !verified {
+		return "not a member of committee " + bytes32ToString(p.CommitteeID)
+	}
+	if !p.Pub.verify(membershipSigHash(data, p.RBlockHash), p.Sig) {
+		return "signature"
+	}
+	if nodeCtx.blockchain.getLastReconfigurationBlock().isBlacklisted(p.Pub.Bytes) {
+		return "blacklisted"
+	}
+	return ""
+}
+
+// the Msg in cMsg if its proof holds
+func openCommitteeMsg(nodeCtx *NodeCtx, cMsg *CommitteeMsg) (Msg, bool) {
+	var msg Msg
+	if reason := verifyMembership(nodeCtx, cMsg.Msg, &cMsg.Proof); reason != "" {
+		nodeLog.warnf(nodeCtx, "[Membership] dropped a message of %s: %s", bytes32ToString(cMsg.Proof.CommitteeID), reason)
+		return msg, false
+	}
+	if err := gob.NewDecoder(bytes.NewBuffer(cMsg.Msg)).Decode(&msg); err != nil {
+		errr(err, "committee message decoding")
+		return msg, false
+	}
+	return msg, true
+}
+
+// a find_node answer is signed on the committee it names, the encoding of its members is not
+// deterministic
+func findNodeResponseData(c *Committee) []byte {
+	data := c.ID[:]
+	for _, pub := range c.getMemberIDsAsSortedList() {
+		data = byteSliceAppend(data, pub[:])
+	}
+	return data
+}
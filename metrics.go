@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the prometheus /metrics of -metricsPort
+
+var metricHelp = map[string][2]string{
+	"rapidchain_messages_received_total":    {"counter", "Messages received by type."},
+	"rapidchain_received_bytes_total":       {"counter", "Bytes of the messages received by type."},
+	"rapidchain_stats_received_total":       {"counter", "Stats received by the coordinator by type."},
+	"rapidchain_mempool_txs":                {"gauge", "Transactions in the pool."},
+	"rapidchain_chaos_total":                {"counter", "Messages dropped or held back by -chaos."},
+	"rapidchain_iteration":                  {"gauge", "Current iteration."},
+	"rapidchain_epoch":                      {"gauge", "Current epoch."},
+	"rapidchain_goroutines":                 {"gauge", "Goroutines of the process."},
+	"rapidchain_nodes":                      {"gauge", "Nodes known to the coordinator."},
+	"rapidchain_consensus_round_seconds":    {"histogram", "Time from a valid propose until its block is accepted."},
+	"rapidchain_ida_reconstruction_seconds": {"histogram", "Time from the first chunk until an ida message is reconstructed."},
+}
+
+var metricBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// seconds a start that was never observed is kept
+const metricStartTimeout = 600
+
+type Histogram struct {
+	counts []uint64 // per bucket of metricBuckets, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// the metrics of a node or the coordinator, nil without -metricsPort
+type Metrics struct {
+	counters   map[string]map[string]float64 // name -> type label -> value
+	gauges     map[string]func() float64
+	histograms map[string]*Histogram
+	starts     map[string]map[[32]byte]time.Time // kind -> id -> start of what is measured
+	mux        sync.Mutex
+}
+
+func (m *Metrics) init() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.counters = make(map[string]map[string]float64)
+	m.gauges = make(map[string]func() float64)
+	m.histograms = make(map[string]*Histogram)
+	m.starts = make(map[string]map[[32]byte]time.Time)
+}
+
+func (m *Metrics) add(name string, typ string, v float64) {
+	if m == nil {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if _, ok := m.counters[name]; !ok {
+		m.counters[name] = make(map[string]float64)
+	}
+	m.counters[name][typ] += v
+}
+
+func (m *Metrics) gauge(name string, f func() float64) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.gauges[name] = f
+}
+
+func (m *Metrics) observe(name string, v float64) {
+	if m == nil {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	h, ok := m.histograms[name]
+	if !ok {
+		h = &Histogram{counts: make([]uint64, len(metricBuckets))}
+		m.histograms[name] = h
+	}
+	for i, b := range metricBuckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// marks the start of id, the first start counts
+func (m *Metrics) start(kind string, id [32]byte) {
+	if m == nil {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	starts, ok := m.starts[kind]
+	if !ok {
+		starts = make(map[[32]byte]time.Time)
+		m.starts[kind] = starts
+	}
+	if _, ok := starts[id]; ok {
+		return
+	}
+	now := clockNow()
+	for other, t := range starts {
+		if now.Sub(t) > metricStartTimeout*time.Second {
+			delete(starts, other)
+		}
+	}
+	starts[id] = now
+}
+
+// observes the time since the start of id in the histogram name
+func (m *Metrics) observeSince(name string, kind string, id [32]byte) {
+	if m == nil {
+		return
+	}
+	m.mux.Lock()
+	t, ok := m.starts[kind][id]
+	delete(m.starts[kind], id)
+	m.mux.Unlock()
+	if ok {
+		m.observe(name, clockSince(t).Seconds())
+	}
+}
+
+func writeMetricHeader(w io.Writer, name string) {
+	help := metricHelp[name]
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help[1], name, help[0])
+}
+
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *Metrics) write(w io.Writer) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	names := []string{}
+	for name := range metricHelp {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if values, ok := m.counters[name]; ok {
+			writeMetricHeader(w, name)
+			for _, typ := range sortedKeys(values) {
+				fmt.Fprintf(w, "%s{type=%q} %s\n", name, typ, formatMetric(values[typ]))
+			}
+		}
+		if f, ok := m.gauges[name]; ok {
+			writeMetricHeader(w, name)
+			fmt.Fprintf(w, "%s %s\n", name, formatMetric(f()))
+		}
+		if h, ok := m.histograms[name]; ok {
+			writeMetricHeader(w, name)
+			cumulative := uint64(0)
+			for i, b := range metricBuckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatMetric(b), cumulative)
+			}
+			fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n", name, h.count, name, formatMetric(h.sum), name, h.count)
+		}
+	}
+}
+
+func serveMetrics(m *Metrics, port uint, local bool) {
+	addr := ":" + strconv.FormatUint(uint64(port), 10)
+	if local {
+		addr = "127.0.0.1" + addr
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.write(w)
+	})
+	nodeLog.infof(nil, "Metrics on %s/metrics", addr)
+	ifErrFatal(http.ListenAndServe(addr, statsAuth.http(mux)), "metrics listen")
+}
+
+// counts the bytes read from a connection
+type countingConn struct {
+	net.Conn
+	n int
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.n += n
+	return n, err
+}
+
+func nodeMetrics(nodeCtx *NodeCtx) *Metrics {
+	m := new(Metrics)
+	m.init()
+	m.gauge("rapidchain_mempool_txs", func() float64 { return float64(nodeCtx.txPool.len()) })
+	m.gauge("rapidchain_iteration", func() float64 { return float64(nodeCtx.i.getI()) })
+	m.gauge("rapidchain_epoch", func() float64 { return float64(nodeCtx.blockchain.epoch()) })
+	m.gauge("rapidchain_goroutines", func() float64 { return float64(runtime.NumGoroutine()) })
+	m.subscribe(nodeCtx)
+	return m
+}
+
+// the histograms of a node from its events
+func (m *Metrics) subscribe(nodeCtx *NodeCtx) {
+	nodeCtx.events.subscribe(func(e Event) {
+		switch d := e.Data.(type) {
+		case ChunkReceivedEvent:
+			m.start("ida", d.Root)
+		case ChunkReconstructedEvent:
+			m.observeSince("rapidchain_ida_reconstruction_seconds", "ida", d.Root)
+		case VoteReceivedEvent:
+			if d.Tag == "propose" {
+				m.start("consensus", d.GossipHash)
+			}
+		case BlockAcceptedEvent:
+			m.observeSince("rapidchain_consensus_round_seconds", "consensus", d.GossipHash)
+		}
+	})
+}
+
+func coordinatorMetrics(membership *Membership) *Metrics {
+	m := new(Metrics)
+	m.init()
+	m.gauge("rapidchain_goroutines", func() float64 { return float64(runtime.NumGoroutine()) })
+	m.gauge("rapidchain_nodes", func() float64 {
+		membership.mux.Lock()
+		defer membership.mux.Unlock()
+		return float64(len(membership.nodes))
+	})
+	m.gauge("rapidchain_epoch", func() float64 {
+		membership.mux.Lock()
+		defer membership.mux.Unlock()
+		return float64(len(membership.epochs))
+	})
+	return m
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the named link models of -netProfile
+
+type NetProfile struct {
+	name      string
+	latency   uint // ms
+	jitter    uint // ms
+	bandwidth uint // bytes per second
+	loss      float64
+}
+
+var netProfiles = map[string]NetProfile{
+	"lan":            {"lan", 1, 1, 125000000, 0},
+	"wan-us-eu":      {"wan-us-eu", 45, 5, 12500000, 0.001},
+	"home-broadband": {"home-broadband", 20, 15, 2500000, 0.005},
+	"lossy-mobile":   {"lossy-mobile", 60, 40, 625000, 0.03},
+}
+
+func netProfileNames() string {
+	names := []string{}
+	for name := range netProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// sets the sim flags of the profile that are not given, before they are read into the FlagArgs
+func applyNetProfile(name string) {
+	if name == "" {
+		return
+	}
+	p, ok := netProfiles[name]
+	if !ok {
+		errFatal(nil, "unknown -netProfile "+name+", give one of "+netProfileNames())
+	}
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	values := map[string]string{
+		"simLatency":   fmt.Sprint(p.latency),
+		"simJitter":    fmt.Sprint(p.jitter),
+		"simBandwidth": fmt.Sprint(p.bandwidth),
+		"simLoss":      fmt.Sprint(p.loss),
+	}
+	for f, v := range values {
+		if given[f] {
+			coordinatorLog.warnf(nil, "[Simulate] -%s overrides %s of -netProfile %s", f, v, name)
+			continue
+		}
+		ifErrFatal(flag.Set(f, v), "netProfile "+f)
+	}
+}
+
+// the extra time of a connection with the key of the scheduler: the losses of a connection in a row
+// are as likely as with loss per try, every one waits twice as long as the one before
+func simRetransmits(key uint64, loss float64) time.Duration {
+	if loss <= 0 {
+		return 0
+	}
+	// the high bits of the key, the low ones are the jitter
+	u := (float64(key>>11) + 1) / (1 << 53)
+	losses := int(math.Log(u) / math.Log(loss))
+	if losses > default_simMaxRetransmits {
+		losses = default_simMaxRetransmits
+	}
+	return time.Duration(default_simRetransmit*((1<<uint(losses))-1)) * time.Millisecond
+}
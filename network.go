@@ -3,46 +3,150 @@ package main
 import (
 	"encoding/gob"
 	"net"
+	"time"
 )
 
+// set with -churnRate, nodes crash during the run (churn.go)
+var peersCanCrash bool
+
+// the in-process network of -function simulate (simulate.go), nil over sockets
+var simNet *SimNetwork
+
+func netListen(addr string) (net.Listener, error) {
+	if simNet != nil {
+		return simNet.listen(addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// a connection to the coordinator starts with the token of -statsSecret, see stats-auth.go
+func netDialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	switch {
+	case simNet != nil:
+		conn, err = simNet.dial(addr)
+	case timeout == 0:
+		conn, err = net.Dial("tcp", addr)
+	default:
+		conn, err = net.DialTimeout("tcp", addr, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := statsAuth.sign(conn, addr); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// a connection the node cannot go on without, see error-policy.go
 func dial(addr string) net.Conn {
-	conn, err := net.Dial("tcp", addr)
+	conn, err := dialWithRetry(addr)
 	ifErrFatal(err, "dialing addr "+addr)
 	return conn
 }
 
-func sendMsg(conn net.Conn, msg interface{}) {
-	enc := gob.NewEncoder(conn)
-	err := enc.Encode(msg)
-	ifErrFatal(err, "encoding and sending")
+// false if the peer went away, which only loses msg
+func sendMsg(conn net.Conn, msg interface{}) bool {
+	if err := gob.NewEncoder(conn).Encode(msg); err != nil {
+		nodeLog.warnf(nil, "[Network] %s to %s dropped: %v", msgTyp(msg), conn.RemoteAddr(), err)
+		return false
+	}
+	return true
 }
 
 func dialAndSend(addr string, msg interface{}) {
-	conn := dial(addr)
+	if peersCanCrash {
+		dialAndSendIfUp(addr, msg)
+		return
+	}
+	conn, err := dialWithRetry(addr)
+	if err != nil {
+		nodeLog.errorf(nil, "[Network] %s to %s dropped after %d tries: %v", msgTyp(msg), addr, default_dialRetries+1, err)
+		return
+	}
 	sendMsg(conn, msg)
 	conn.Close()
 }
 
+// sends msg to a peer that may be down or shutting down, false if it was not sent
+func dialAndSendIfUp(addr string, msg interface{}) bool {
+	conn, err := netDialTimeout(addr, 0)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	return !ifErr(gob.NewEncoder(conn).Encode(msg), "sending to "+addr)
+}
+
+// sends req to addr and decodes the answer. Returns false if the peer is down or did not answer
+func requestFrom(addr string, req Msg, answer interface{}) bool {
+	conn, err := dialWithRetry(addr)
+	if err != nil {
+		if peersCanCrash {
+			nodeLog.warnf(nil, "[Churn] %s is down", addr)
+		} else {
+			nodeLog.errorf(nil, "[Network] %s did not take %s: %v", addr, req.Typ, err)
+		}
+		return false
+	}
+	defer conn.Close()
+	if !sendMsg(conn, req) {
+		return false
+	}
+	if err := gob.NewDecoder(conn).Decode(answer); err != nil {
+		nodeLog.warnf(nil, "[Network] %s did not answer %s: %v", addr, req.Typ, err)
+		return false
+	}
+	return true
+}
+
 func dialAndSendToCoordinator(identifier string, _msg interface{}) {
 	msg := Msg{identifier, _msg, nil}
-	dialAndSend(coord+":8080", msg)
+	dialAndSend(coordinatorAddr(), msg)
+}
+
+// the sends of their own goroutine, see spawn
+func spawnDialAndSend(addr string, msg interface{}) {
+	spawn(func() { dialAndSend(addr, msg) })
 }
 
+func spawnDialAndSendIfUp(addr string, msg interface{}) {
+	spawn(func() { dialAndSendIfUp(addr, msg) })
+}
+
+func spawnDialAndSendToCoordinator(identifier string, msg interface{}) {
+	spawn(func() { dialAndSendToCoordinator(identifier, msg) })
+}
+
+// an answer of the coordinator the node cannot go on without
 func reciveMsg(conn net.Conn, obj interface{}) {
 	dec := gob.NewDecoder(conn)
 	err := dec.Decode(obj) // deadlock here
 	ifErrFatal(err, "decoding")
 }
 
+// decodes the msg a peer sent on its own connection. Returns false if it is not a msg, the peer
+// exited while it sent it or sent garbage, see inbound.go
+func reciveMsgFromPeer(conn net.Conn, obj interface{}) bool {
+	if err := gob.NewDecoder(conn).Decode(obj); err != nil {
+		nodeLog.warnf(nil, "[Inbound] no msg from %s: %v", conn.RemoteAddr(), err)
+		return false
+	}
+	return true
+}
+
 func sendMsgToCommittee(msg Msg, committee *Committee) {
-	for _, v := range (*committee).Members {
-		go dialAndSend(v.IP, msg)
+	for _, v := range committee.sortedMembers() {
+		spawnDialAndSend(v.IP, msg)
 	}
 }
 
 func sendMsgToCommitteeAndSelf(msg Msg, nodeCtx *NodeCtx) {
-	for _, v := range nodeCtx.committee.Members {
-		go dialAndSend(v.IP, msg)
+	for _, v := range nodeCtx.committee.sortedMembers() {
+		spawnDialAndSend(v.IP, msg)
 	}
-	go dialAndSend(nodeCtx.self.IP, msg)
+	spawnDialAndSend(nodeCtx.self.IP, msg)
 }
@@ -1,37 +1,44 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"bytes"
 	"math"
 	"math/big"
 	"net"
 	"sort"
 )
 
-func coordinatorSetup(conn net.Conn, portNumber int, nodeCtx *NodeCtx) {
+func coordinatorSetup(conn net.Conn, portNumber int, privKey *PrivKey, powNonce uint64, nodeCtx *NodeCtx) {
 	// setup with the help of coordinator
 
-	// generate a key
-	privKey := new(PrivKey)
-	privKey.gen()
-
-	msg := Node_InitialMessageToCoordinator{privKey.Pub, portNumber}
+	msg := Node_InitialMessageToCoordinator{Pub: privKey.Pub, Port: portNumber, Host: nodeCtx.flagArgs.advertise, PowNonce: powNonce, Build: currentBuild()}
 
 	// fmt.Println("sending msg to coord")
 	sendMsg(conn, msg)
 
-	fmt.Printf("%d Waiting for return message\n", portNumber)
+	nodeLog.infof(nil, "%d Waiting for return message", portNumber)
 
 	response := new(ResponseToNodes)
 	reciveMsg(conn, response)
+	if response.Refused != "" {
+		errFatal(nil, "the coordinator refused the node: "+response.Refused)
+	}
 	// fmt.Println("recv msg to coord")
+	if nodeCtx.flagArgs.bootstrap == bootstrapByElection {
+		if reason := verifyElection(response, nodeCtx.flagArgs.m); reason != "" {
+			errFatal(nil, "the coordinator did not assign the committees of the election: "+reason)
+		}
+	}
 
+	setupFromResponse(nodeCtx, privKey, response)
+}
+
+// sets up the node with the nodes, committees and genesis blocks from the coordinator
+func setupFromResponse(nodeCtx *NodeCtx, privKey *PrivKey, response *ResponseToNodes) {
 	// declare variables to return
 	allInfo := make(map[[32]byte]NodeAllInfo)
 	var selfInfo SelfInfo
 	var currentCommittee Committee
-	var routingTable RoutingTable
 
 	for _, elem := range response.Nodes {
 		allInfo[elem.Pub.Bytes] = elem
@@ -63,127 +70,17 @@ func coordinatorSetup(conn net.Conn, portNumber int, nodeCtx *NodeCtx) {
 		}
 	}
 
-	// create routing table,
-	// length := math.Ceil(math.Log(float64(nodeCtx.flagArgs.m))) + 1
-	length := math.Log2(float64(nodeCtx.flagArgs.m)) + 1
-
-	// check if m is power of two:
-	if (nodeCtx.flagArgs.m & (nodeCtx.flagArgs.m - 1)) != 0 {
-		// if it isnt, increase length by one to get the last committee in routing table
-		length++
-		fmt.Println("increased length by one")
-	}
-	log.Println("Routingtable length: ", length, int(length))
-
-	routingTable.init(int(length))
-
-	committees := make(map[[32]byte]bool)
-	// get a list of committees
-	for _, node := range allInfo {
-		// exclude own committee
-		if selfInfo.CommitteeID == node.CommitteeID {
-			continue
-		}
-		committees[node.CommitteeID] = true
-	}
-
-	// generate committeeList with own committee
-	committeeList := make([][32]byte, len(committees)+1)
-	committeeList[0] = selfInfo.CommitteeID
-
-	iC := 1
-	for k := range committees {
-		committeeList[iC] = k
-		iC++
-	}
-	nodeCtx.committeeList = committeeList
-
-	selfCommitteeID := new(big.Int).SetBytes(selfInfo.CommitteeID[:])
-
-	xored := make([]*big.Int, len(committees))
-	// sort committes after some distance metric (XOR kademlia)
-	i := 0
-	for k := range committees {
-		// bitwise XOR
-		xored[i] = new(big.Int)
-		xored[i].Xor(selfCommitteeID, new(big.Int).SetBytes(k[:]))
-		i++
-	}
-	// now we sort by increasing value since the closest ids are the ones with the longest leading zero
-	sort.Slice(xored, func(i, j int) bool { return xored[i].Cmp(xored[j]) < 0 })
-
-	/*
-		for _, x := range xored {
-			fmt.Println(selfInfo.CommitteeID, "   ", selfInfo.CommitteeID^x, "   ", x)
-		}
-	*/
-
-	kademliaCommittees := [][32]byte{}
-	// pick committees in 2^i distances
-	for i := uint(0); true; i++ {
-		dist := int(math.Pow(2, float64(i))) - 1
-		if dist >= len(xored) {
-			dist = len(xored) - 1
-		}
-
-		// we need to xor the xored to get the original id
-		app := toByte32(new(big.Int).Xor(selfCommitteeID, xored[dist]).Bytes())
-		kademliaCommittees = append(kademliaCommittees, app)
-		routingTable.addCommittee(i, app)
-		if dist == len(xored)-1 {
-			break
-		}
-	}
-
-	// get all nodes from these committees
-	nodesInKadamliaCommittees := make(map[[32]byte][]NodeAllInfo)
-	for _, k := range kademliaCommittees {
-		nodesInKadamliaCommittees[k] = []NodeAllInfo{}
-	}
-	for _, n := range allInfo {
-		for _, k := range kademliaCommittees {
-			if k == n.CommitteeID {
-				nodesInKadamliaCommittees[n.CommitteeID] = append(nodesInKadamliaCommittees[n.CommitteeID], n)
-			}
-		}
-	}
-
-	fmt.Println(nodesInKadamliaCommittees)
-
-	// fmt.Printf("Had %d committes and turned it into %d neighbors\n", len(xored), len(kademliaCommittees))
-
-	// fmt.Printf("Nodes per committee %d, and inted %d\n", math.Log(math.Log(float64(flagArgs.n))), int(math.Log(math.Log(float64(flagArgs.n)))))
-	// get i random index arrays, and use them to get loglogn nodes from each of those committees
-	for i, c := range kademliaCommittees {
-		// pick loglogn
-		l := len(nodesInKadamliaCommittees[c])
-		per := int(math.Ceil(math.Log(float64(l))))
-		indexes := randIndexesWithoutReplacement(l, per)
-		// fmt.Println("Per committee: ", per)
-		if per == 0 {
-			errFatal(nil, "routingtable per was 0")
-		}
-		for _, j := range indexes {
-			node := nodesInKadamliaCommittees[c][j]
-			tmp := new(CommitteeMember)
-			tmp.Pub = node.Pub
-			tmp.IP = node.IP
-			routingTable.addMember(uint(i), tmp)
-		}
-	}
+	buildRoutingTable(nodeCtx, selfInfo.CommitteeID, allInfo)
 
 	// and success!
 
 	//log.Printf("Coordinaton setup finished \n")
 
-	nodeCtx.committee = currentCommittee
+	nodeCtx.setCommittee(currentCommittee)
 	nodeCtx.self = selfInfo
 	nodeCtx.allInfo = allInfo
 	nodeCtx.idaMsgs = IdaMsgs{}
 	nodeCtx.idaMsgs.init()
-	nodeCtx.consensusMsgs = ConsensusMsgs{}
-	nodeCtx.consensusMsgs.init()
-
 	nodeCtx.channels = Channels{}
 	nodeCtx.channels.init(len(currentCommittee.Members))
 
@@ -194,33 +91,59 @@ func coordinatorSetup(conn net.Conn, portNumber int, nodeCtx *NodeCtx) {
 	nodeCtx.i = CurrentIteration{}
 	nodeCtx.i.i = 1 // set start iteration to 1 because genesisBlock is iteration 0
 
-	nodeCtx.routingTable = routingTable
-
-	nodeCtx.txPool = TxPool{}
-	nodeCtx.txPool.init()
-
-	nodeCtx.crossTxPool = CrossTxPool{}
-	nodeCtx.crossTxPool.init()
-
-	nodeCtx.utxoSet = new(UTXOSet)
-	nodeCtx.utxoSet.init()
-
-	nodeCtx.blockchain = Blockchain{}
-	nodeCtx.blockchain.init(selfInfo.CommitteeID)
+	initCommitteeState(nodeCtx)
 
 	nodeCtx.blockchain.addRecBlock(response.ReconfigurationBlock)
+	// the reconfiguration block from the coordinator is epoch 0
+	nodeCtx.drg = DrgState{}
+	nodeCtx.drg.init(1)
+	nodeCtx.macKeys = MacKeys{}
+	nodeCtx.macKeys.init()
+	nodeCtx.departures = Departures{}
+	nodeCtx.departures.init()
+	nodeCtx.reconfigurations = Reconfigurations{}
+	nodeCtx.reconfigurations.init()
+	nodeCtx.equivocations = Equivocations{}
+	nodeCtx.equivocations.init()
+	nodeCtx.epochClock = EpochClock{}
+	nodeCtx.epochClock.init()
+	nodeCtx.latencies = LatencyStats{}
+	nodeCtx.latencies.init(nodeCtx.flagArgs.latencyStats == latencyByHistograms)
+	nodeCtx.events = EventBus{}
+	nodeCtx.events.init()
+	subscribeStats(nodeCtx)
+	nodeCtx.wire = WireStats{}
+	nodeCtx.wire.init(nodeCtx.flagArgs.wireStats)
+	nodeCtx.blockPhases = PhaseMarks{}
+	nodeCtx.blockPhases.init()
+	nodeCtx.blockPhases.subscribe(nodeCtx)
+	nodeCtx.faults = FaultInjector{}
+	nodeCtx.faults.init(nodeCtx)
+	nodeCtx.chaos = newChaos(nodeCtx)
+	nodeCtx.byzantine = newByzantine(nodeCtx)
 
 	gb := response.GensisisBlocks
 	// fmt.Println(gb)
 	// fmt.Println("Len genesis blocks", len(gb))
+	nodeCtx.genesisHashes = response.GenesisHashes
+	nodeCtx.genesisHash = response.GenesisHashes[selfInfo.CommitteeID]
+	foundGenesis := false
 	for _, b := range gb {
 		// fmt.Print("this committee ", b.ProposedBlock.CommitteeID == nodeCtx.self.CommitteeID, "\n")
-		if b.ProposedBlock.CommitteeID == nodeCtx.self.CommitteeID {
+		if b.ProposedBlock.CommitteeID == nodeCtx.committeeID() && !nodeCtx.fastSync {
 			b.processBlock(nodeCtx)
 			nodeCtx.blockchain._add(b)
+			foundGenesis = true
 			break
 		}
 	}
+	if !foundGenesis && !nodeCtx.fastSync && len(gb) == 0 {
+		// coordinator only sent the genesis hashes, the block is ida gossiped when listening
+		nodeCtx.genesisGossip = true
+	} else if !foundGenesis {
+		// missed the genesis distribution, get the state from the committee when listening
+		nodeCtx.fastSync = true
+	}
 
 	nodeCtx.utxoSet.verifyNonces()
 
@@ -262,6 +185,20 @@ func buildCurrentNeighbours(nodeCtx *NodeCtx) {
 	if newDint%2 != 0 {
 		newDint--
 	}
+	// a fanout the coordinator tuned, as far as the committee has members
+	if fanout := nodeCtx.tuning.getFanout(); fanout != 0 {
+		newDint = fanout
+		if newDint > uint(len(members)) {
+			newDint = uint(len(members))
+		}
+	}
+	// committees of less than four members, which churn can leave, still need a neighbour to gossip to
+	if newDint == 0 && len(members) > 0 {
+		newDint = 2
+		if len(members) == 1 {
+			newDint = 1
+		}
+	}
 
 	currentNeighbours := make([][32]byte, newDint)
 	// fmt.Println("New D ", newD, newDint)
@@ -296,5 +233,158 @@ func buildCurrentNeighbours(nodeCtx *NodeCtx) {
 
 	// }
 
-	nodeCtx.neighbors = currentNeighbours
+	nodeCtx.setNeighbors(currentNeighbours)
+}
+
+// builds the kademlia routing table to the other committees of allInfo and the committee list
+func buildRoutingTable(nodeCtx *NodeCtx, committeeID [32]byte, allInfo map[[32]byte]NodeAllInfo) {
+	routingTable := &nodeCtx.routingTable
+
+	// create routing table,
+	// length := math.Ceil(math.Log(float64(nodeCtx.flagArgs.m))) + 1
+	length := math.Log2(float64(nodeCtx.flagArgs.m)) + 1
+
+	// check if m is power of two:
+	if (nodeCtx.flagArgs.m & (nodeCtx.flagArgs.m - 1)) != 0 {
+		// if it isnt, increase length by one to get the last committee in routing table
+		length++
+		routingLog.debugf(nodeCtx, "increased length by one")
+	}
+	routingLog.infof(nodeCtx, "Routingtable length: %v %d", length, int(length))
+
+	routingTable.init(int(length))
+
+	committees := make(map[[32]byte]bool)
+	// get a list of committees
+	for _, node := range allInfo {
+		// exclude own committee
+		if committeeID == node.CommitteeID {
+			continue
+		}
+		committees[node.CommitteeID] = true
+	}
+
+	// generate committeeList with own committee
+	committeeList := make([][32]byte, len(committees)+1)
+	committeeList[0] = committeeID
+
+	iC := 1
+	for k := range committees {
+		committeeList[iC] = k
+		iC++
+	}
+	sort.Slice(committeeList[1:], func(i, j int) bool { return bytes.Compare(committeeList[1+i][:], committeeList[1+j][:]) < 0 })
+	nodeCtx.setCommitteeList(committeeList)
+
+	selfCommitteeID := new(big.Int).SetBytes(committeeID[:])
+
+	xored := make([]*big.Int, len(committees))
+	// sort committes after some distance metric (XOR kademlia)
+	i := 0
+	for k := range committees {
+		// bitwise XOR
+		xored[i] = new(big.Int)
+		xored[i].Xor(selfCommitteeID, new(big.Int).SetBytes(k[:]))
+		i++
+	}
+	// now we sort by increasing value since the closest ids are the ones with the longest leading zero
+	sort.Slice(xored, func(i, j int) bool { return xored[i].Cmp(xored[j]) < 0 })
+
+	/*
+		for _, x := range xored {
+			fmt.Println(committeeID, "   ", committeeID^x, "   ", x)
+		}
+	*/
+
+	kademliaCommittees := [][32]byte{}
+	// pick committees in 2^i distances
+	for i := uint(0); true; i++ {
+		dist := int(math.Pow(2, float64(i))) - 1
+		if dist >= len(xored) {
+			dist = len(xored) - 1
+		}
+
+		// we need to xor the xored to get the original id, padded so an id with leading zero bytes stays in place
+		var app [32]byte
+		new(big.Int).Xor(selfCommitteeID, xored[dist]).FillBytes(app[:])
+		kademliaCommittees = append(kademliaCommittees, app)
+		routingTable.addCommittee(i, app)
+		if dist == len(xored)-1 {
+			break
+		}
+	}
+
+	// get all nodes from these committees
+	nodesInKadamliaCommittees := make(map[[32]byte][]NodeAllInfo)
+	for _, k := range kademliaCommittees {
+		nodesInKadamliaCommittees[k] = []NodeAllInfo{}
+	}
+	// in the order of the keys, so a replay of a simulation picks the same members
+	pubs := make([][32]byte, 0, len(allInfo))
+	for pub := range allInfo {
+		pubs = append(pubs, pub)
+	}
+	sort.Slice(pubs, func(i, j int) bool { return bytes.Compare(pubs[i][:], pubs[j][:]) < 0 })
+	for _, pub := range pubs {
+		n := allInfo[pub]
+		for _, k := range kademliaCommittees {
+			if k == n.CommitteeID {
+				nodesInKadamliaCommittees[n.CommitteeID] = append(nodesInKadamliaCommittees[n.CommitteeID], n)
+			}
+		}
+	}
+
+	routingLog.debugf(nodeCtx, "%v", nodesInKadamliaCommittees)
+
+	// fmt.Printf("Had %d committes and turned it into %d neighbors\n", len(xored), len(kademliaCommittees))
+
+	// fmt.Printf("Nodes per committee %d, and inted %d\n", math.Log(math.Log(float64(flagArgs.n))), int(math.Log(math.Log(float64(flagArgs.n)))))
+	// get i random index arrays, and use them to get loglogn nodes from each of those committees
+	for i, c := range kademliaCommittees {
+		// pick loglogn
+		l := len(nodesInKadamliaCommittees[c])
+		per := int(math.Ceil(math.Log(float64(l))))
+		indexes := randIndexesWithoutReplacement(l, per)
+		// fmt.Println("Per committee: ", per)
+		if per == 0 {
+			errFatal(nil, "routingtable per was 0")
+		}
+		for _, j := range indexes {
+			node := nodesInKadamliaCommittees[c][j]
+			tmp := new(CommitteeMember)
+			tmp.Pub = node.Pub
+			tmp.IP = node.IP
+			routingTable.addMember(uint(i), tmp)
+		}
+	}
+}
+
+// the state of the own committee: its chain, utxos and pools. Set up again when a node moves to
+// another committee at an epoch boundary
+func initCommitteeState(nodeCtx *NodeCtx) {
+	// the goroutines of the last committee can still hold the state, so every part is reset under
+	// its own lock instead of replaced
+	nodeCtx.consensusMsgs.init()
+	nodeCtx.txPool.init()
+	nodeCtx.orphanPool.init(nodeCtx.flagArgs.maxOrphans)
+	nodeCtx.admission.init(&nodeCtx.flagArgs)
+	nodeCtx.crossTxPool.init()
+
+	if nodeCtx.utxoSet == nil {
+		nodeCtx.utxoSet = new(UTXOSet)
+	}
+	nodeCtx.utxoSet.init()
+
+	// the block store and wal of the last committee
+	nodeCtx.blockchain.closeStore()
+	nodeCtx.wal.close()
+	nodeCtx.blockchain.init(nodeCtx.committeeID())
+	nodeCtx.blockchain.setRetention(nodeCtx.flagArgs.retention)
+	nodeCtx.vrfClaims.init()
+	nodeCtx.wal.init()
+	if nodeCtx.flagArgs.blockStore != "" {
+		path := blockStorePath(nodeCtx.flagArgs.blockStore, nodeCtx.committeeID(), nodeCtx.self.IP)
+		nodeCtx.blockchain.openStore(path, nodeCtx.flagArgs.blocksInMemory, nodeCtx.flagArgs.compress, nodeCtx.flagArgs.blockCache)
+		ifErrFatal(nodeCtx.wal.open(walPath(path)), "opening wal")
+	}
 }
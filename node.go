@@ -1,46 +1,130 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"math/rand"
 	"net"
-	"strconv"
 	"time"
 )
 
-func launchNode(flagArgs *FlagArgs, count uint) {
+func launchNode(flagArgs *FlagArgs, in *Instance) {
+	count := in.count
+	bootstrapStart := clockNow()
+	privKey := nodeKey(flagArgs, count)
+
+	// the puzzle is solved before registering, the coordinator reads registrations one by one
+	var pow *PowSolution
+	var powDifficulty uint
+	powNonce := uint64(0)
+	// the puzzle of a node that joins by the reference committee is on the epoch randomness
+	if flagArgs.powDifficulty > 0 && !(flagArgs.join && flagArgs.reference == referenceByCommittee) {
+		pow, powDifficulty = solveCoordinatorPow(privKey.Pub, flagArgs.join)
+		powNonce = pow.Nonce
+	}
+
+	// before the registration, which the coordinator waits for once it accepted the connection
+	syncClock(flagArgs, flagArgs.join)
 
 	// coordinator ip is port is 8080 defualt
-	conn := dial(coord + ":8080")
+	conn := dial(coordinatorAddr())
 
-	// listening ip port is 9000 default
-	address := "127.0.0.1:" + strconv.FormatUint(uint64(flagArgs.portsBegin+count), 10)
+	// a free port by default, see ports.go
+	address := nodeListenAddr(flagArgs, nodePort(flagArgs, count))
 	// start listening. We do this here becuase we need to choose a unique port
 	// number, and send that port number to coordinator so every node has correct port and ip
-	listener, err := net.Listen("tcp", address)
+	listener, err := netListen(address)
 	ifErrFatal(err, "listener node")
 	portNumber := listener.Addr().(*net.TCPAddr).Port
 
 	//fmt.Print(allInfo, initialRandomness, currentCommittee, "\n\n")
-	log.Printf("Listen address: %v", listener.Addr())
+	nodeLog.infof(nil, "Listen address: %v", listener.Addr())
 	nodeCtx := new(NodeCtx)
 	nodeCtx.flagArgs = *flagArgs
+	nodeCtx.instance = in
+	// the first fastSyncNodes nodes of every instance join by state sync instead of the genesis block
+	nodeCtx.fastSync = count < flagArgs.fastSyncNodes
 	// fmt.Println("Before coord")
-	coordinatorSetup(conn, portNumber, nodeCtx)
+	if flagArgs.join {
+		if solution := joinSetup(conn, portNumber, privKey, powNonce, nodeCtx, bootstrapStart); solution != nil {
+			pow, powDifficulty = solution, flagArgs.powDifficulty
+		}
+	} else {
+		coordinatorSetup(conn, portNumber, privKey, powNonce, nodeCtx)
+	}
+	refreshClock(flagArgs)
 	// fmt.Println("After coord")
 	// launch listener
-	go listen(listener, nodeCtx)
+	if flagArgs.metricsPort != 0 {
+		nodeCtx.metrics = nodeMetrics(nodeCtx)
+		goRecorded(func() { serveMetrics(nodeCtx.metrics, flagArgs.metricsPort+1+count, flagArgs.local) })
+	}
+	if flagArgs.pprofPort != 0 {
+		goRecorded(func() { servePprof(pprofHandler(), flagArgs.pprofPort+1+count, flagArgs.local) })
+	}
+	nodeCtx.tracer = newTracer(flagArgs, "node", shortID(nodeCtx.self.Priv.Pub.Bytes))
+	nodeCtx.tracer.subscribe(nodeCtx)
+	nodeCtx.recorder = newFlightRecorder(nodeCtx, flagArgs.flightRecorder)
+	spawn(func() { listen(listener, nodeCtx) })
+	if nodeCtx.latencies.enabled {
+		spawn(func() { reportLatencies(nodeCtx) })
+	}
+	if flagArgs.resourceInterval != 0 {
+		spawn(func() { reportResources(nodeCtx) })
+	}
+	if nodeCtx.wire.enabled {
+		spawn(func() { reportWire(nodeCtx) })
+	}
+	if flagArgs.explorerPort != 0 {
+		goRecorded(func() { launchExplorer(nodeCtx, flagArgs.explorerPort+count) })
+	}
+	if nodeCtx.genesisGossip {
+		receiveGenesis(nodeCtx)
+	}
+	if nodeCtx.join != nil {
+		joinSync(nodeCtx)
+	} else if nodeCtx.fastSync {
+		fastSync(nodeCtx)
+	}
+	rejoinFromWAL(nodeCtx)
+	reportBootstrap(nodeCtx, bootstrapStart)
+	if pow != nil {
+		reportPow(nodeCtx, pow, powDifficulty)
+	}
+	if flagArgs.mac {
+		nodeCtx.macKeys.rotate(nodeCtx, nodeCtx.blockchain.getLastReconfigurationBlock(), nodeCtx.blockchain.epoch())
+	}
+	if flagArgs.drg && !flagArgs.join {
+		runDrg(nodeCtx)
+	}
 	// if nodeCtx.self.Debug {
 	// 	go debug(nodeCtx)
 	// }
 	rand.Seed(69)
+	in.started(flagArgs, privKey, listener, nodeCtx)
 	startNewIteration(nodeCtx)
 	// blocker
 	// for {
 	// }
 }
 
+// the key from -keyfile, or a new key every run
+func nodeKey(flagArgs *FlagArgs, count uint) *PrivKey {
+	if flagArgs.keyfile != "" {
+		return loadOrGenKey(keyfilePath(flagArgs.keyfile, count, flagArgs.instances))
+	}
+	if flagArgs.deriveKeys && flagArgs.join {
+		// the indices of the nodes of the setup are taken
+		return deriveNodeKey(requestRunSeed(true), flagArgs.n+count)
+	}
+	if flagArgs.deriveKeys {
+		return deriveNodeKey(requestRunSeed(false), count)
+	}
+	privKey := new(PrivKey)
+	privKey.gen()
+	return privKey
+}
+
 func listen(
 	listener net.Listener,
 	nodeCtx *NodeCtx) {
@@ -49,20 +133,70 @@ func listen(
 	for {
 		// accept new connection
 		conn, err := listener.Accept()
-		ifErrFatal(err, "tcp accept")
+		// the listener is closed when the node is killed
+		if nodeCtx.stopped.get() {
+			return
+		}
+		// a node without its listener cannot go on
+		if errors.Is(err, net.ErrClosed) {
+			errFatal(err, "tcp accept")
+		}
+		// out of file descriptors or a connection reset before it was accepted
+		if err != nil {
+			nodeLog.errorf(nodeCtx, "tcp accept: %v", err)
+			clockSleep(default_dialBackoff * time.Millisecond)
+			continue
+		}
 
 		// TODO do I need to have a mutex lock on the maps?
-		go nodeHandleConnection(conn, nodeCtx)
+		spawn(func() { nodeHandleConnection(conn, nodeCtx) })
 	}
 }
 
 func nodeHandleConnection(
 	conn net.Conn,
 	nodeCtx *NodeCtx) {
+	defer nodeCtx.instance.catchPanic()
 	// decode the msg using the genereic Msg struct
 	var msg Msg
-	reciveMsg(conn, &msg)
+	counted := &countingConn{Conn: conn}
+	if !reciveMsgFromPeer(counted, &msg) {
+		conn.Close()
+		return
+	}
+	nodeCtx.metrics.add("rapidchain_received_bytes_total", msg.Typ, float64(counted.n))
+	nodeCtx.wire.add(msg, counted.n)
+	// a killed node drops what it still gets, and does not answer
+	if nodeCtx.stopped.get() {
+		conn.Close()
+		return
+	}
+	// messages of other committees come in a committee_msg with the proof of the sender
+	if needsMembershipProof(msg.Typ) {
+		nodeLog.warnf(nodeCtx, "[Membership] dropped %s without a membership proof", msg.Typ)
+		conn.Close()
+		return
+	}
+	// messages of the coordinator come in a control msg with a token once there is a secret
+	if statsAuth != nil && controlMsgs[msg.Typ] {
+		nodeLog.warnf(nodeCtx, "[Auth] dropped %s from %s without a token", msg.Typ, conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	nodeHandleMsg(conn, msg, nodeCtx)
+}
 
+func nodeHandleMsg(conn net.Conn, msg Msg, nodeCtx *NodeCtx) {
+	nodeCtx.metrics.add("rapidchain_messages_received_total", msg.Typ, 1)
+	if !nodeCtx.faults.inject(nodeCtx, &msg) || !nodeCtx.chaos.shake(nodeCtx, &msg) {
+		conn.Close()
+		return
+	}
+	if reason := checkInbound(nodeInbound, &msg); reason != "" {
+		nodeLog.warnf(nodeCtx, "[Inbound] dropped %s from %s: %s", msg.Typ, conn.RemoteAddr(), reason)
+		conn.Close()
+		return
+	}
 	// determine msg type and msg struct using Msg.typ
 	// fmt.Println(msg.Typ)
 	switch msg.Typ {
@@ -72,36 +206,67 @@ func nodeHandleConnection(
 		notOkErr(ok, "IDAGossipMsg decoding")
 		reconstructed := handleIDAGossipMsg(idaMsg, nodeCtx)
 		if reconstructed {
-
-			// log to coordinator
-			bat := new(ByteArrayAndTimestamp)
 			data := nodeCtx.reconstructedIdaMsgs.getData(idaMsg.MerkleRoot)
-			id := hash(data)
-			bat.B = id[:]
-			bat.T = time.Now()
-			go dialAndSendToCoordinator("reconstructed_ida_gossip", bat)
+			nodeCtx.events.publish(nodeCtx, ChunkReconstructedEvent{idaMsg.MerkleRoot, hash(data)})
 
 			switch idaMsg.Typ {
 			case "tx":
 				// reconstruct tx
-				tx := new(Transaction)
-				tx.decode(data)
+				tx, err := decodeTransaction(data)
+				if ifErr(err, "gossiped tx") {
+					return
+				}
+
+				// gossiped by the committee this node was in before it moved with an epoch
+				if tx.gossipCommittee(nodeCtx) != nodeCtx.committeeID() {
+					idaLog.infof(nodeCtx, "Dropped gossiped tx of another committee %s", bytes32ToString(tx.id()))
+					break
+				}
 
-				// add tx to pool
-				nodeCtx.txPool.add(tx)
+				// add tx to pool if the admission policies allow it
+				if nodeCtx.admission.admit(nodeCtx, tx) {
+					tx.pooled = clockNow()
+					nodeCtx.txPool.add(tx)
+				}
 				//fmt.Println("Added to txpool")
+			case "genesis":
+				block, err := decodeGossipedBlock(data)
+				if ifErr(err, "genesis block body") {
+					return
+				}
+				nodeCtx.channels.genesisChan.trySend(block)
+			case "reconfiguration":
+				c, err := decodeCertifiedReconfiguration(data)
+				if ifErr(err, "reconfiguration block body") {
+					return
+				}
+				// forwarded to the old neighbours before the block is applied and they change
+				gossipSend(idaMsg, nodeCtx)
+				handleCertifiedReconfiguration(nodeCtx, c, false)
 			case "block":
 				// ProposedBlock
+				block, err := decodeGossipedBlock(data)
+				if ifErr(err, "block body") {
+					return
+				}
+				if block.LeaderPub != nil && nodeCtx.blockchain.getLastReconfigurationBlock().isBlacklisted(block.LeaderPub.Bytes) {
+					idaLog.warnf(nodeCtx, "[Blacklist] dropped a block of %s", bytes32ToString(block.LeaderPub.Bytes))
+					return
+				}
 				nodeCtx.blockchain.mux.Lock()
-				block := new(ProposedBlock)
-				block.decode(data)
 
-				// TODO verify block
+				// header hashes and merkle root must match the transactions
+				if !block.isHashesCorrect() || !block.isMerkleRootCorrect(nodeCtx) {
+					nodeCtx.blockchain.mux.Unlock()
+					errr(nil, fmt.Sprintf("Block with gh %s has invalid header", bytes32ToString(block.GossipHash)))
+					return
+				}
 				nodeCtx.blockchain._addProposedBlock(block)
 				nodeCtx.blockchain.mux.Unlock()
-				fmt.Printf("Block with gh %s added\n", bytes32ToString(block.GossipHash))
+				nodeCtx.equivocations.check(nodeCtx, block)
+				idaLog.infof(nodeCtx, "Block with gh %s added", bytes32ToString(block.GossipHash))
 			default:
-				errFatal(nil, "Unknown IDAGossipMsg type")
+				idaLog.warnf(nodeCtx, "dropped IDAGossipMsg of unknown type %s", idaMsg.Typ)
 			}
 		}
 
@@ -109,9 +274,19 @@ func nodeHandleConnection(
 		cMsg, ok := msg.Msg.(ConsensusMsg)
 		notOkErr(ok, "ConsensusSignature decoding")
 
+		// a node that joined missed the gossip of the blocks before its sync
+		if nodeCtx.join != nil && !nodeCtx.join.isSynced() {
+			return
+		}
+
+		if cMsg.Pub != nil && nodeCtx.blockchain.getLastReconfigurationBlock().isBlacklisted(cMsg.Pub.Bytes) {
+			consensusLog.warnf(nodeCtx, "[Blacklist] dropped %s of %s", cMsg.Tag, bytes32ToString(cMsg.Pub.Bytes))
+			return
+		}
+
 		// check if we allready have accepted the block
 		if nodeCtx.blockchain.isBlock(cMsg.GossipHash) {
-			log.Println("Block allready accepted", bytes32ToString(cMsg.GossipHash), cMsg.Tag)
+			consensusLog.infof(nodeCtx, "Block allready accepted %s %s", bytes32ToString(cMsg.GossipHash), cMsg.Tag)
 			// TODO add signature maybe?
 			return
 		}
@@ -121,7 +296,7 @@ func nodeHandleConnection(
 			timeout := 0
 			var found bool = false
 			for {
-				time.Sleep(time.Duration(nodeCtx.flagArgs.delta) * time.Millisecond)
+				clockSleep(nodeCtx.delta())
 				if nodeCtx.blockchain.isProposedBlock(cMsg.GossipHash) {
 					found = true
 					break
@@ -133,15 +308,17 @@ func nodeHandleConnection(
 				}
 			}
 			if !found {
-				fmt.Println("Comittee ", bytes32ToString(nodeCtx.committee.ID))
-				fmt.Println("Selfid ", bytes32ToString(nodeCtx.self.Priv.Pub.Bytes))
-				fmt.Println("isLeader?", nodeCtx.committee.CurrentLeader.Bytes == nodeCtx.self.Priv.Pub.Bytes)
-				fmt.Println("len of proposed blocks: ", len(nodeCtx.blockchain.ProposedBlocks))
-				fmt.Println("Gossiphash: ", bytes32ToString(cMsg.GossipHash))
-				fmt.Println("Tag ", cMsg.Tag)
-				fmt.Println("len Idamsgs ", len(nodeCtx.idaMsgs.m))
-				fmt.Println("len r ida ", len(nodeCtx.reconstructedIdaMsgs.m))
-				errFatal(nil, "ProposedBlock not recivied")
+				consensusLog.debugf(nodeCtx, "Comittee %s", bytes32ToString(nodeCtx.committee.ID))
+				consensusLog.debugf(nodeCtx, "Selfid %s", bytes32ToString(nodeCtx.self.Priv.Pub.Bytes))
+				leader := nodeCtx.currentLeader()
+				consensusLog.debugf(nodeCtx, "isLeader? %v", leader != nil && leader.Bytes == nodeCtx.self.Priv.Pub.Bytes)
+				consensusLog.debugf(nodeCtx, "len of proposed blocks: %d", len(nodeCtx.blockchain.ProposedBlocks))
+				consensusLog.debugf(nodeCtx, "Gossiphash: %s", bytes32ToString(cMsg.GossipHash))
+				consensusLog.debugf(nodeCtx, "Tag %s", cMsg.Tag)
+				consensusLog.debugf(nodeCtx, "len Idamsgs %d", len(nodeCtx.idaMsgs.m))
+				consensusLog.debugf(nodeCtx, "len r ida %d", len(nodeCtx.reconstructedIdaMsgs.m))
+				consensusLog.warnf(nodeCtx, "dropped %s of %s, the proposed block was not recivied", cMsg.Tag, bytes32ToString(cMsg.GossipHash))
+				return
 			}
 		}
 
@@ -151,25 +328,69 @@ func nodeHandleConnection(
 			for len(nodeCtx.channels.echoChan) > 0 {
 				<-nodeCtx.channels.echoChan
 			}
-			go handleConsensusEcho(cMsg, nodeCtx, 0)
-			go handleConsensusAccept(cMsg, nodeCtx, 0)
+			spawn(func() { handleConsensusEcho(cMsg, nodeCtx, 0) })
+			spawn(func() { handleConsensusAccept(cMsg, nodeCtx, 0) })
 		}
 
 		handleConsensus(nodeCtx, cMsg, msg.FromPub)
 
+	case "vrf_claim":
+		claim, ok := msg.Msg.(VrfClaim)
+		notOkErr(ok, "vrf claim decoding")
+		if !nodeCtx.vrfClaims.verifyAndAdd(nodeCtx, &claim) {
+			errr(nil, "invalid vrf claim")
+		}
+
+	case "drg_commit":
+		c, ok := msg.Msg.(DrgCommit)
+		notOkErr(ok, "drg commit decoding")
+		handleDrgCommit(nodeCtx, c)
+	case "drg_commits":
+		r, ok := msg.Msg.(DrgResult)
+		notOkErr(ok, "drg commit set decoding")
+		handleDrgCommitSet(nodeCtx, r)
+	case "drg_reveal":
+		reveal, ok := msg.Msg.(DrgReveal)
+		notOkErr(ok, "drg reveal decoding")
+		handleDrgReveal(nodeCtx, reveal)
+	case "drg_result":
+		r, ok := msg.Msg.(DrgResult)
+		notOkErr(ok, "drg result decoding")
+		handleDrgResult(nodeCtx, r)
+
+	case "equivocation_proof":
+		p, ok := msg.Msg.(EquivocationProof)
+		notOkErr(ok, "equivocation proof decoding")
+		nodeCtx.equivocations.add(nodeCtx, p)
+	case "committee_msg":
+		cMsg, ok := msg.Msg.(CommitteeMsg)
+		notOkErr(ok, "committee_msg decoding")
+		inner, ok := openCommitteeMsg(nodeCtx, &cMsg)
+		if !ok || controlMsgs[inner.Typ] {
+			conn.Close()
+			return
+		}
+		nodeHandleMsg(conn, inner, nodeCtx)
+	case "control":
+		c, ok := msg.Msg.(ControlMsg)
+		notOkErr(ok, "control decoding")
+		inner, ok := openControlMsg(nodeCtx, &c)
+		if !ok {
+			conn.Close()
+			return
+		}
+		nodeHandleMsg(conn, inner, nodeCtx)
 	case "find_node":
 		kMsg, ok := msg.Msg.(KademliaFindNodeMsg)
 		notOkErr(ok, "findNode decoding")
 
-		if kMsg.ID == nodeCtx.self.CommitteeID {
-			errFatal(nil, "Got a find_node msg but I am the target committee")
+		if kMsg.ID == nodeCtx.committeeID() {
+			routingLog.warnf(nodeCtx, "dropped a find_node for this committee")
+			conn.Close()
+			return
 		}
 
-		b := new(ByteArrayAndTimestamp)
-		b.B = byteSliceAppend(kMsg.ID[:], nodeCtx.self.CommitteeID[:])
-		b.T = time.Now()
-
-		go dialAndSendToCoordinator("find_node", b)
+		nodeCtx.events.publish(nodeCtx, FindNodeEvent{kMsg.ID})
 
 		handleFindNode(nodeCtx, conn, kMsg)
 	case "transaction":
@@ -178,21 +399,23 @@ func nodeHandleConnection(
 		notOkErr(ok, "transaction decoding") //todo dont need such strict err
 		// figure out which committee the transaction belongs to
 		if tMsg.Hash == [32]byte{} {
-			errFatal(nil, fmt.Sprintf("tMsg.Hash was empty with t:", tMsg))
+			nodeLog.warnf(nodeCtx, "dropped a transaction without a hash")
+			return
 		}
-		cID := txFindClosestCommittee(nodeCtx, tMsg.Hash)
+		cID := tMsg.closestCommittee(nodeCtx, tMsg.Hash)
 
 		// if current committe then initiate IDA-Gossip
-		if cID == nodeCtx.self.CommitteeID {
-			// send log to coordinator that tx has been recived at target destination
-			b := new(ByteArrayAndTimestamp)
-			btmp := tMsg.id()
-			b.B = btmp[:]
-			b.T = time.Now()
-			go dialAndSendToCoordinator("transaction_recieved", b)
-
-			// ida gossip the tx so the rest of the committee gets the tx
+		if cID == nodeCtx.committeeID() {
+			// the tx has been recived at target destination
+			nodeCtx.events.publish(nodeCtx, TxReceivedEvent{tMsg.id(), true})
+
+			// ida gossip the tx so the rest of the committee gets the tx, the committee gets the
+			// trace of the gossip
+			start := clockNow()
+			parent := tMsg.Trace
+			tMsg.Trace = nodeCtx.tracer.child(parent)
 			IDAGossip(nodeCtx, tMsg.encode(), "tx")
+			traceSpan(nodeCtx, tMsg.Trace, parent, "ida_gossip", start, clockNow())
 
 			// add to tx pool
 			//ok := nodeCtx.txPool.safeAdd(&tMsg)
@@ -202,16 +425,10 @@ func nodeHandleConnection(
 		} else {
 			// log.Println("Tx not target committe, routing", tMsg.Hash)
 
-			// indicate to coordinator that we are starting a routing
+			// we are starting a routing
+			nodeCtx.events.publish(nodeCtx, TxReceivedEvent{tMsg.id(), false})
 
-			b := new(ByteArrayAndTimestamp)
-			btmp := tMsg.id()
-			b.B = btmp[:]
-			b.T = time.Now()
-
-			go dialAndSendToCoordinator("routetx", b)
-
-			go routeTx(nodeCtx, msg, cID)
+			spawn(func() { routeTx(nodeCtx, msg, cID) })
 
 		}
 	case "crosstransaction":
@@ -247,8 +464,93 @@ func nodeHandleConnection(
 		tmp.LastIteration = uint64(lastBlock.ProposedBlock.Iteration)
 		sendMsg(conn, tmp)
 
+	case "request_blocks":
+		rMsg, ok := msg.Msg.(RequestBlocksMsg)
+		notOkErr(ok, "request_blocks decoding")
+		sendMsg(conn, answerRequestBlocks(nodeCtx, rMsg))
+	case "request_snapshot":
+		sendMsg(conn, createSnapshot(nodeCtx))
+	case "request_state_hash":
+		sendMsg(conn, stateHash(nodeCtx))
+	case "request_reconfiguration_blocks":
+		sendMsg(conn, nodeCtx.blockchain.getReconfigurationBlocks())
+	case "node_leave":
+		l, ok := msg.Msg.(Leave)
+		notOkErr(ok, "node_leave decoding")
+		nodeCtx.departures.add(l)
+	case "churn_kill":
+		downtime, ok := msg.Msg.(uint)
+		notOkErr(ok, "churn_kill decoding")
+		spawn(func() { nodeCtx.instance.kill(time.Duration(downtime) * time.Second) })
+	case "node_join":
+		switch join := msg.Msg.(type) {
+		case NodeAllInfo:
+			// announced by the coordinator, only trusted when it admits nodes
+			if nodeCtx.flagArgs.reference != referenceByCoordinator {
+				nodeLog.warnf(nodeCtx, "[Admission] ignored join announced by the coordinator")
+				return
+			}
+			handleNodeJoin(nodeCtx, join)
+		case JoinCertificate:
+			if reason := verifyJoinCertificate(nodeCtx, nodeCtx.blockchain.getLastReconfigurationBlock(), &join); reason != "" {
+				nodeLog.warnf(nodeCtx, "[Admission] join certificate rejected: %s", reason)
+				return
+			}
+			handleNodeJoin(nodeCtx, join.Info)
+		default:
+			nodeLog.warnf(nodeCtx, "[Admission] dropped a node_join of type %T", msg.Msg)
+		}
+	case "reconfiguration_sig":
+		s, ok := msg.Msg.(RecBlockSig)
+		notOkErr(ok, "reconfiguration_sig decoding")
+		handleRecBlockSig(nodeCtx, s)
+	case "certified_reconfiguration":
+		c, ok := msg.Msg.(CertifiedReconfiguration)
+		notOkErr(ok, "certified_reconfiguration decoding")
+		handleCertifiedReconfiguration(nodeCtx, &c, true)
+	case "request_certified_reconfiguration":
+		i, ok := msg.Msg.(uint)
+		notOkErr(ok, "request_certified_reconfiguration decoding")
+		c := CertifiedReconfiguration{}
+		if known := nodeCtx.reconfigurations.get(i); known != nil {
+			c = *known
+		}
+		sendMsg(conn, c)
+	case "request_join_challenge":
+		sendMsg(conn, joinChallenge(nodeCtx))
+	case "join_request":
+		req, ok := msg.Msg.(JoinRequest)
+		notOkErr(ok, "join_request decoding")
+		answer, ok := admit(nodeCtx, req, nodeAddr(conn, req.Host, req.Port))
+		if !ok {
+			answer = &AdmissionAnswer{}
+		}
+		sendMsg(conn, *answer)
+	case "request_inclusion_proof":
+		txID, ok := msg.Msg.([32]byte)
+		notOkErr(ok, "request_inclusion_proof decoding")
+		sendMsg(conn, handleRequestInclusionProof(nodeCtx, txID))
+	case "profile":
+		req, ok := msg.Msg.(ProfileRequest)
+		notOkErr(ok, "profile decoding")
+		handleProfileRequest(nodeCtx, conn, req)
+	case "flight_dump":
+		handleFlightDump(nodeCtx, conn)
+	case "fault":
+		f, ok := msg.Msg.(Fault)
+		notOkErr(ok, "fault decoding")
+		nodeCtx.faults.add(nodeCtx, f)
+	case "tune":
+		t, ok := msg.Msg.(Tuning)
+		notOkErr(ok, "tune decoding")
+		nodeCtx.tuning.add(nodeCtx, t)
+	case "coordinator_takeover":
+		host, ok := msg.Msg.(string)
+		notOkErr(ok, "coordinator_takeover decoding")
+		handleCoordinatorTakeover(nodeCtx, host)
+
 	default:
-		log.Fatal("[Error] no known message type")
+		nodeLog.fatalf(nodeCtx, "no known message type %s", msg.Typ)
 	}
 
 }
@@ -263,67 +565,52 @@ func requestAndAddMissingBlocks(nodeCtx *NodeCtx) {
 	lastBlock := nodeCtx.blockchain.getLatest()
 
 	if lastBlock.ProposedBlock.Iteration+1 != nodeCtx.i.getI() {
-		log.Println(lastBlock.ProposedBlock.Iteration+1, nodeCtx.i.getI())
+		nodeLog.infof(nodeCtx, "Missing blocks from iteration %d to %d", lastBlock.ProposedBlock.Iteration+1, nodeCtx.i.getI())
 		errFatal(nil, "blockchain and iteration not in sync not equal")
 	}
 
-	request := new(Msg)
-	request.Typ = "request_block"
-	request.Msg = uint64(lastBlock.ProposedBlock.Iteration + 1)
-	request.FromPub = nodeCtx.self.Priv.Pub
-	response := new(RequestBlockAnswer)
+	var node *CommitteeMember
+	var response *RequestBlocksAnswer
 
 	// choose a random node from the committee
 	for {
-		rnd := rand.Intn(len(nodeCtx.committee.Members))
-		var node *CommitteeMember
-		i := 0
-		for _, cm := range nodeCtx.committee.Members {
-			if i == rnd {
-				node = cm
-				break
-			}
-			i++
-		}
-		if node == nil {
+		members := nodeCtx.committee.sortedMembers()
+		if len(members) == 0 {
 			errFatal(nil, "could not pick neighbour")
 		}
+		node = members[rand.Intn(len(members))]
 
-		conn := dial(node.IP)
+		response = requestBlocks(nodeCtx, node, uint64(lastBlock.ProposedBlock.Iteration+1))
 
-		sendMsg(conn, request)
-		reciveMsg(conn, response)
-		conn.Close()
-
-		if response == nil {
-			errFatal(nil, "response was nil")
-		}
-
-		if lastBlock.ProposedBlock.Iteration >= response.Block.ProposedBlock.Iteration {
-			log.Println("No new block, try again with new node", lastBlock.ProposedBlock.Iteration, response.Block.ProposedBlock.Iteration)
+		if len(response.Blocks) == 0 {
+			nodeLog.infof(nodeCtx, "No new block, try again with new node %d %d", lastBlock.ProposedBlock.Iteration, response.LastIteration)
 			continue
 		}
 		break
 	}
 
-	// // TODO verify block and signatures
-	// if len(response.Block.Signatures) < len(nodeCtx.committee.Members)/2 {
-	// 	log.Println("not enough signatures")
-	// 	return
-	// }
-
-	// if response.Block.ProposedBlock.PreviousGossipHash != lastBlock.ProposedBlock.GossipHash {
-	// 	log.Println("PreviousGossipHash was not lastBlock")
-	// 	return
-	// }
+	// add the blocks, and request the next page from the same node while the answer was capped
+	for {
+		for _, block := range response.Blocks {
+			if block.Pruned {
+				// the peer only has the header, so the state is fetched instead
+				nodeLog.infof(nodeCtx, "Missing block was pruned by peer, state sync instead")
+				fastSync(nodeCtx)
+				return
+			}
 
-	nodeCtx.blockchain.add(response.Block)
-	response.Block.forceProcessBlock(nodeCtx)
-	nodeCtx.i.add()
+			verifyLinkage(nodeCtx, block.ProposedBlock, "sync")
+			nodeCtx.wal.accept(block.ProposedBlock)
 
-	if response.LastIteration != uint64(response.Block.ProposedBlock.Iteration) {
-		log.Println(response.LastIteration, response.Block.ProposedBlock.Iteration, nodeCtx.i.getI())
-		log.Println("Last iteration in response was not the returend block, therefor request next missing block")
-		requestAndAddMissingBlocks(nodeCtx)
+			nodeCtx.blockchain.add(block)
+			block.forceProcessBlock(nodeCtx)
+			nodeCtx.i.add()
+		}
+		if !response.More {
+			break
+		}
+		lastBlock = nodeCtx.blockchain.getLatest()
+		nodeLog.infof(nodeCtx, "Block range was capped at %d of %d request next page", lastBlock.ProposedBlock.Iteration, response.LastIteration)
+		response = requestBlocks(nodeCtx, node, uint64(lastBlock.ProposedBlock.Iteration+1))
 	}
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Transactions that spend outputs of a parent transaction which is not yet committed.
+// Instead of being rejected, they are held here keyed by the missing parent TxHash and
+// released back into the tx pool when a block containing the parent is processed.
+type OrphanPool struct {
+	orphans  map[[32]byte]*orphanEntry      // tx id -> orphan
+	byParent map[[32]byte]map[[32]byte]bool // missing parent TxHash -> orphan tx ids
+	order    [][32]byte                     // insertion order, used for eviction
+	max      int
+	added    uint64
+	released uint64
+	evicted  uint64
+	reported [3]uint64 // counters at last report to coordinator
+	mux      sync.Mutex
+}
+
+type orphanEntry struct {
+	tx        *Transaction
+	missing   map[[32]byte]bool // parents that are still missing
+	iteration uint
+}
+
+func (op *OrphanPool) init(max uint) {
+	op.mux.Lock()
+	defer op.mux.Unlock()
+	op.orphans = make(map[[32]byte]*orphanEntry)
+	op.byParent = make(map[[32]byte]map[[32]byte]bool)
+	op.order = [][32]byte{}
+	op.max = int(max)
+	op.added, op.released, op.evicted = 0, 0, 0
+	op.reported = [3]uint64{}
+}
+
+func (op *OrphanPool) len() int {
+	op.mux.Lock()
+	defer op.mux.Unlock()
+	return len(op.orphans)
+}
+
+// adds an orphan waiting for the given parents. Returns false if it allready exists
+func (op *OrphanPool) add(tx *Transaction, parents [][32]byte, iteration uint) bool {
+	op.mux.Lock()
+	defer op.mux.Unlock()
+	if op.max == 0 {
+		op.evicted++
+		return false
+	}
+	id := tx.id()
+	if _, ok := op.orphans[id]; ok {
+		return false
+	}
+
+	// evict oldest if full
+	for len(op.orphans) >= op.max && len(op.order) > 0 {
+		oldest := op.order[0]
+		op.order = op.order[1:]
+		if _, ok := op.orphans[oldest]; ok {
+			op._remove(oldest)
+			op.evicted++
+		}
+	}
+
+	entry := &orphanEntry{tx, make(map[[32]byte]bool), iteration}
+	for _, p := range parents {
+		entry.missing[p] = true
+		if _, ok := op.byParent[p]; !ok {
+			op.byParent[p] = make(map[[32]byte]bool)
+		}
+		op.byParent[p][id] = true
+	}
+	op.orphans[id] = entry
+	op.order = append(op.order, id)
+	op.added++
+	return true
+}
+
+func (op *OrphanPool) _remove(id [32]byte) {
+	entry, ok := op.orphans[id]
+	if !ok {
+		return
+	}
+	for p := range entry.missing {
+		delete(op.byParent[p], id)
+		if len(op.byParent[p]) == 0 {
+			delete(op.byParent, p)
+		}
+	}
+	delete(op.orphans, id)
+}
+
+// a parent has been committed. Returns all orphans that are no longer missing any parents
+func (op *OrphanPool) release(parent [32]byte) []*Transaction {
+	op.mux.Lock()
+	defer op.mux.Unlock()
+	ready := []*Transaction{}
+	for id := range op.byParent[parent] {
+		entry := op.orphans[id]
+		delete(entry.missing, parent)
+		if len(entry.missing) == 0 {
+			ready = append(ready, entry.tx)
+			delete(op.orphans, id)
+			op.released++
+		}
+	}
+	delete(op.byParent, parent)
+	return ready
+}
+
+// removes orphans that have waited more than maxAge iterations
+func (op *OrphanPool) expire(iteration uint, maxAge uint) {
+	op.mux.Lock()
+	defer op.mux.Unlock()
+	for id, entry := range op.orphans {
+		if iteration > entry.iteration+maxAge {
+			op._remove(id)
+			op.evicted++
+		}
+	}
+	// compact order list
+	newOrder := [][32]byte{}
+	for _, id := range op.order {
+		if _, ok := op.orphans[id]; ok {
+			newOrder = append(newOrder, id)
+		}
+	}
+	op.order = newOrder
+}
+
+// returns the counters if they changed since last call
+func (op *OrphanPool) statsIfChanged() (added, released, evicted uint64, size int, changed bool) {
+	op.mux.Lock()
+	defer op.mux.Unlock()
+	now := [3]uint64{op.added, op.released, op.evicted}
+	if now == op.reported {
+		return 0, 0, 0, 0, false
+	}
+	op.reported = now
+	return op.added, op.released, op.evicted, len(op.orphans), true
+}
+
+// returns the parent TxHashes of inputs that are neither in the UTXO set, added in this block, nor spent in this block.
+func missingParents(nodeCtx *NodeCtx, t *Transaction, spentUTXOSet *UTXOSet, addedUTXOSet *UTXOSet) [][32]byte {
+	missing := [][32]byte{}
+	for _, inp := range t.Inputs {
+		if nodeCtx.utxoSet.get(inp.TxHash, inp.N) != nil ||
+			addedUTXOSet.get(inp.TxHash, inp.N) != nil ||
+			spentUTXOSet.get(inp.TxHash, inp.N) != nil {
+			continue
+		}
+		missing = append(missing, inp.TxHash)
+	}
+	return missing
+}
+
+// moves orphans whose parents was committed in block b back into the tx pool and reports orphan metrics
+func releaseOrphans(nodeCtx *NodeCtx, b *FinalBlock) {
+	parents := [][32]byte{}
+	for _, t := range b.ProposedBlock.Transactions {
+		switch t.whatAmI(nodeCtx) {
+		case "normal":
+			parents = append(parents, t.Hash)
+		case "finaltransaction":
+			parents = append(parents, t.OrigTxHash)
+		case "crosstxresponse_C_out":
+			for _, inp := range t.Inputs {
+				parents = append(parents, inp.TxHash)
+			}
+		}
+	}
+	for _, p := range parents {
+		for _, orphan := range nodeCtx.orphanPool.release(p) {
+			nodeCtx.txPool.add(orphan)
+		}
+	}
+	nodeCtx.orphanPool.expire(b.ProposedBlock.Iteration, default_orphanMaxAge)
+
+	added, released, evicted, size, changed := nodeCtx.orphanPool.statsIfChanged()
+	if !changed {
+		return
+	}
+	bat := new(ByteArrayAndTimestamp)
+	// 32 8 8 8 8
+	counters := make([]byte, 32)
+	binary.LittleEndian.PutUint64(counters[0:8], added)
+	binary.LittleEndian.PutUint64(counters[8:16], released)
+	binary.LittleEndian.PutUint64(counters[16:24], evicted)
+	binary.LittleEndian.PutUint64(counters[24:32], uint64(size))
+	bat.B = byteSliceAppend(nodeCtx.self.Priv.Pub.Bytes[:], counters)
+	bat.T = clockNow()
+	spawnDialAndSendToCoordinator("orphan_stats", bat)
+}
+
+func orphanStatsString(b []byte) string {
+	if len(b) != 64 {
+		errFatal(nil, fmt.Sprintf("length of orphan stats msg was not 64: %d", len(b)))
+	}
+	pub := toByte32(b[:32])
+	added := binary.LittleEndian.Uint64(b[32:40])
+	released := binary.LittleEndian.Uint64(b[40:48])
+	evicted := binary.LittleEndian.Uint64(b[48:56])
+	size := binary.LittleEndian.Uint64(b[56:64])
+	return fmt.Sprintf("%s,%d,%d,%d,%d", bytes32ToString(pub), added, released, evicted, size)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net"
+)
+
+// the ports of the nodes, picked by the os without -ports
+
+// the port node count of the process listens on, 0 for a free one
+func nodePort(flagArgs *FlagArgs, count uint) uint {
+	if flagArgs.portsBegin == 0 {
+		return 0
+	}
+	return flagArgs.portsBegin + count
+}
+
+// a port that is free now, for a process that has to be started on it again
+func freePort() (uint, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return uint(listener.Addr().(*net.TCPAddr).Port), nil
+}
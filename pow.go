@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math/bits"
+	"net"
+	"time"
+)
+
+// -powDifficulty, the identity puzzle of a node
+
+type PowChallenge struct {
+	Seed       [32]byte
+	Difficulty uint
+}
+
+type PowSolution struct {
+	Nonce    uint64
+	Attempts uint64
+	Duration time.Duration
+}
+
+func powHash(seed [32]byte, pub [32]byte, nonce uint64) [32]byte {
+	n := make([]byte, 8)
+	binary.LittleEndian.PutUint64(n, nonce)
+	return hash(byteSliceAppend(seed[:], pub[:], n))
+}
+
+func leadingZeroBits(h [32]byte) uint {
+	zeros := uint(0)
+	for _, b := range h {
+		zeros += uint(bits.LeadingZeros8(b))
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+func verifyPow(c PowChallenge, pub [32]byte, nonce uint64) bool {
+	return leadingZeroBits(powHash(c.Seed, pub, nonce)) >= c.Difficulty
+}
+
+func solvePow(c PowChallenge, pub [32]byte) *PowSolution {
+	start := clockNow()
+	nonce := uint64(0)
+	for !verifyPow(c, pub, nonce) {
+		nonce++
+	}
+	return &PowSolution{nonce, nonce + 1, clockSince(start)}
+}
+
+// gets the puzzle of the bootstrap from the coordinator and solves it
+func solveCoordinatorPow(pub *PubKey, join bool) (*PowSolution, uint) {
+	challenge := new(PowChallenge)
+	requestFromCoordinator(join, "pow_challenge", Node_InitialMessageToCoordinator{Pub: pub, ChallengeRequest: true}, challenge)
+	return solvePow(*challenge, pub.Bytes), challenge.Difficulty
+}
+
+// reads the first message of a node on the coordinator. Requests for the puzzle or the run seed
+// are answered and registrations without a valid solution or of another build are rejected, all
+// return false
+func coordinatorAdmit(conn net.Conn, challenge PowChallenge, runSeed int64, builds *BuildCheck) (*Node_InitialMessageToCoordinator, bool) {
+	if !statsAuth.admit(conn) {
+		conn.Close()
+		return nil, false
+	}
+	// a node sends its registration once it connected, what sends nothing holds up the others
+	rec_msg := new(Node_InitialMessageToCoordinator)
+	conn.SetReadDeadline(clockNow().Add(default_statsAuthTimeout * time.Second))
+	err := gob.NewDecoder(conn).Decode(rec_msg)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		coordinatorLog.warnf(nil, "[Inbound] no registration from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return nil, false
+	}
+	got := clockNow()
+	if rec_msg.ClockRequest {
+		answerClock(conn, got)
+		conn.Close()
+		return nil, false
+	}
+	if rec_msg.ChallengeRequest {
+		ifErrFatal(gob.NewEncoder(conn).Encode(challenge), "encoding pow challenge")
+		conn.Close()
+		return nil, false
+	}
+	if rec_msg.SeedRequest {
+		ifErrFatal(gob.NewEncoder(conn).Encode(runSeed), "encoding run seed")
+		conn.Close()
+		return nil, false
+	}
+	if challenge.Difficulty > 0 && (rec_msg.Pub == nil || !verifyPow(challenge, rec_msg.Pub.Bytes, rec_msg.PowNonce)) {
+		coordinatorLog.warnf(nil, "Rejected node at %s without a valid proof of work", conn.RemoteAddr())
+		conn.Close()
+		return nil, false
+	}
+	if reason := builds.admit(conn.RemoteAddr().String(), rec_msg.Build); reason != "" {
+		ifErr(gob.NewEncoder(conn).Encode(ResponseToNodes{Refused: reason}), "encoding refusal")
+		conn.Close()
+		return nil, false
+	}
+	return rec_msg, true
+}
+
+func reportPow(nodeCtx *NodeCtx, pow *PowSolution, difficulty uint) {
+	s := fmt.Sprintf("%s,%s,%d,%d,%d", bytes32ToString(nodeCtx.committeeID()), bytes32ToString(nodeCtx.self.Priv.Pub.Bytes), difficulty, pow.Attempts, pow.Duration.Milliseconds())
+	spawnDialAndSendToCoordinator("pow", s)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// the checks of the machine before a run
+
+// what a check found wrong and what to do about it
+type PreflightFailure struct {
+	check string
+	found string
+	fix   string
+}
+
+// the connections and files of the nodes of the process and of the coordinator if it runs in it
+func expectedFiles(mode string, flagArgs *FlagArgs) uint64 {
+	committee := uint64((flagArgs.n + flagArgs.m - 1) / flagArgs.m)
+	files := uint64(default_preflightFiles)
+	switch mode {
+	case "coordinator", "standby":
+		files += 2 * uint64(flagArgs.n)
+	case "node":
+		files += 2 * committee * uint64(flagArgs.instances)
+	case "demo":
+		files += 2*uint64(flagArgs.n) + 2*committee*uint64(flagArgs.n)
+	}
+	return files
+}
+
+func checkOpenFiles(mode string, flagArgs *FlagArgs) []PreflightFailure {
+	need := expectedFiles(mode, flagArgs)
+	var limit syscall.Rlimit
+	if ifErr(syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit), "open files limit") || limit.Cur >= need {
+		return nil
+	}
+	if limit.Max >= need {
+		raised := syscall.Rlimit{Cur: limit.Max, Max: limit.Max}
+		if !ifErr(syscall.Setrlimit(syscall.RLIMIT_NOFILE, &raised), "raising the open files limit") {
+			nodeLog.infof(nil, "[Preflight] raised the open files limit from %d to %d", limit.Cur, limit.Max)
+			return nil
+		}
+	}
+	return []PreflightFailure{{
+		"open files",
+		fmt.Sprintf("the limit is %d (hard %d), the process needs about %d", limit.Cur, limit.Max, need),
+		fmt.Sprintf("run ulimit -n %d before it, raise the hard limit in /etc/security/limits.conf, or run fewer -instances per process", need),
+	}}
+}
+
+// the ports the process listens on, the free ones of the nodes without -ports are not known yet
+func listenPorts(mode string, flagArgs *FlagArgs) []uint {
+	ports := []uint{}
+	coordinator := mode == "coordinator" || mode == "demo"
+	if coordinator {
+		ports = append(ports, 8080)
+	}
+	// a standby listens on 8080 once its coordinator died
+	if mode == "standby" {
+		ports = append(ports, default_standbyPort)
+	}
+	for _, port := range []uint{flagArgs.metricsPort, flagArgs.pprofPort} {
+		if port != 0 && coordinator {
+			ports = append(ports, port)
+		}
+	}
+	nodes := flagArgs.instances
+	if mode == "demo" {
+		nodes = flagArgs.n
+	} else if mode != "node" {
+		nodes = 0
+	}
+	for i := uint(0); i < nodes; i++ {
+		if port := nodePort(flagArgs, i); port != 0 {
+			ports = append(ports, port)
+		}
+		for _, port := range []uint{flagArgs.metricsPort, flagArgs.pprofPort} {
+			if port != 0 {
+				ports = append(ports, port+1+i)
+			}
+		}
+		if flagArgs.explorerPort != 0 {
+			ports = append(ports, flagArgs.explorerPort+i)
+		}
+	}
+	return ports
+}
+
+func checkPorts(mode string, flagArgs *FlagArgs) []PreflightFailure {
+	failures := []PreflightFailure{}
+	taken := []string{}
+	for _, port := range listenPorts(mode, flagArgs) {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			taken = append(taken, fmt.Sprint(port))
+			continue
+		}
+		listener.Close()
+	}
+	if len(taken) > 0 {
+		failures = append(failures, PreflightFailure{
+			"ports",
+			"taken by another process: " + strings.Join(taken, " "),
+			"end the process that has them (ss -ltnp shows it), or move -ports, -metricsPort, -pprofPort and -explorerPort",
+		})
+	}
+	// every connection the process dials takes a local port, and keeps it for a while after it is closed
+	b, err := ioutil.ReadFile("/proc/sys/net/ipv4/ip_local_port_range")
+	var low, high uint64
+	if err != nil || len(strings.Fields(string(b))) != 2 {
+		return failures
+	}
+	fmt.Sscan(string(b), &low, &high)
+	if need := expectedFiles(mode, flagArgs); high-low+1 < need {
+		failures = append(failures, PreflightFailure{
+			"ports",
+			fmt.Sprintf("the local ports %d-%d are fewer than the about %d connections of the process", low, high, need),
+			"widen them with sysctl -w net.ipv4.ip_local_port_range=\"1024 65000\" and reuse closed ones with sysctl -w net.ipv4.tcp_tw_reuse=1",
+		})
+	}
+	return failures
+}
+
+func checkDisk(flagArgs *FlagArgs) []PreflightFailure {
+	if err := os.MkdirAll(resultsDir, 0755); err != nil {
+		return []PreflightFailure{{"disk", err.Error(), "give a -resultsDir the process can create"}}
+	}
+	f, err := ioutil.TempFile(resultsDir, ".preflight")
+	if err != nil {
+		return []PreflightFailure{{"disk", err.Error(), "give a -resultsDir the process can write, or fix its permissions"}}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	var stat syscall.Statfs_t
+	if ifErr(syscall.Statfs(resultsDir, &stat), "free disk space") {
+		return nil
+	}
+	free := stat.Bavail * uint64(stat.Bsize) >> 20
+	if free < default_preflightDisk {
+		dir, _ := filepath.Abs(resultsDir)
+		return []PreflightFailure{{
+			"disk",
+			fmt.Sprintf("%d MB free under %s, a run needs %d MB", free, dir, default_preflightDisk),
+			"free space there or give a -resultsDir on another disk",
+		}}
+	}
+	return nil
+}
+
+func checkClock() []PreflightFailure {
+	if now := time.Now(); now.Year() < default_preflightYear {
+		return []PreflightFailure{{
+			"clock",
+			"the clock of the machine is at " + now.Format(time.RFC3339),
+			"set it, with timedatectl set-ntp true or ntpdate",
+		}}
+	}
+	return nil
+}
+
+func reportPreflight(failures []PreflightFailure) {
+	if len(failures) == 0 {
+		return
+	}
+	for _, f := range failures {
+		nodeLog.errorf(nil, "[Preflight] %s: %s. %s", f.check, f.found, f.fix)
+	}
+	errFatal(nil, fmt.Sprintf("%d preflight checks failed, -preflight=false skips them", len(failures)))
+}
+
+// checks the machine before the process of mode starts
+func preflight(mode string, flagArgs *FlagArgs) {
+	if !flagArgs.preflight {
+		return
+	}
+	switch mode {
+	case "coordinator", "standby", "node", "supervise", "demo":
+	default:
+		return
+	}
+	failures := checkOpenFiles(mode, flagArgs)
+	failures = append(failures, checkPorts(mode, flagArgs)...)
+	failures = append(failures, checkDisk(flagArgs)...)
+	failures = append(failures, checkClock()...)
+	reportPreflight(failures)
+}
+
+// the nodes compare their clock with the one of the coordinator once it is up, without touching the
+// offset of -clockSync
+func preflightClock(flagArgs *FlagArgs) {
+	if !flagArgs.preflight || simNet != nil {
+		return
+	}
+	// a node that joins or recovers asks the running coordinator like after the setup
+	afterSetup := flagArgs.join || flagArgs.recoverFrom != 0
+	var offset, roundTrip time.Duration
+	for i := 0; i < default_clockSamples; i++ {
+		o, rt, err := exchangeClock(afterSetup)
+		if ifErr(err, "clock of the coordinator") {
+			return
+		}
+		if i == 0 || rt < roundTrip {
+			offset, roundTrip = o, rt
+		}
+	}
+	skew := offset
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew <= default_preflightSkew*time.Millisecond {
+		return
+	}
+	if flagArgs.clockSync {
+		nodeLog.warnf(nil, "[Preflight] the clock is %s off the one of the coordinator, -clockSync corrects it", offset)
+		return
+	}
+	reportPreflight([]PreflightFailure{{
+		"clock",
+		fmt.Sprintf("the clock is %s off the one of the coordinator", offset),
+		"sync the clocks of the machines with ntp or run with -clockSync",
+	}})
+}
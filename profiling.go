@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// net/http/pprof of -pprofPort and the profiles of the nodes
+
+type ProfileRequest struct {
+	Type    string
+	Seconds uint
+}
+
+type ProfileAnswer struct {
+	Profile []byte
+	Err     string
+}
+
+func pprofHandler() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+func servePprof(mux *http.ServeMux, port uint, local bool) {
+	addr := ":" + strconv.FormatUint(uint64(port), 10)
+	if local {
+		addr = "127.0.0.1" + addr
+	}
+	nodeLog.infof(nil, "pprof on %s/debug/pprof/", addr)
+	ifErrFatal(http.ListenAndServe(addr, statsAuth.http(mux)), "pprof listen")
+}
+
+func takeProfile(req ProfileRequest) ProfileAnswer {
+	var buf bytes.Buffer
+	if req.Type == "cpu" {
+		// fails while another cpu profile of the process runs
+		if err := rpprof.StartCPUProfile(&buf); err != nil {
+			return ProfileAnswer{Err: err.Error()}
+		}
+		time.Sleep(time.Duration(req.Seconds) * time.Second)
+		rpprof.StopCPUProfile()
+		return ProfileAnswer{Profile: buf.Bytes()}
+	}
+	p := rpprof.Lookup(req.Type)
+	if p == nil {
+		return ProfileAnswer{Err: "unknown profile " + req.Type}
+	}
+	if req.Type == "heap" {
+		runtime.GC()
+	}
+	if err := p.WriteTo(&buf, 0); err != nil {
+		return ProfileAnswer{Err: err.Error()}
+	}
+	return ProfileAnswer{Profile: buf.Bytes()}
+}
+
+func handleProfileRequest(nodeCtx *NodeCtx, conn net.Conn, req ProfileRequest) {
+	answer := ProfileAnswer{Err: "pprof is off"}
+	if nodeCtx.flagArgs.pprofPort != 0 {
+		nodeLog.infof(nodeCtx, "[Profile] %s profile for %d seconds", req.Type, req.Seconds)
+		answer = takeProfile(req)
+	}
+	// the coordinator may have given up on a long profile
+	ifErr(gob.NewEncoder(conn).Encode(answer), "profile answer")
+}
+
+// asks the node of info for a profile, a node that is down is an error and does not end the run
+func requestProfile(info NodeAllInfo, req ProfileRequest) ProfileAnswer {
+	conn, err := netDialTimeout(info.IP, 5*time.Second)
+	if err != nil {
+		return ProfileAnswer{Err: err.Error()}
+	}
+	defer conn.Close()
+	conn.SetDeadline(clockNow().Add(time.Duration(req.Seconds)*time.Second + default_profileTimeout*time.Second))
+	if err := gob.NewEncoder(conn).Encode(withControlToken(info.Pub, Msg{"profile", req, nil})); err != nil {
+		return ProfileAnswer{Err: err.Error()}
+	}
+	var answer ProfileAnswer
+	if err := gob.NewDecoder(conn).Decode(&answer); err != nil {
+		return ProfileAnswer{Err: err.Error()}
+	}
+	return answer
+}
+
+// the nodes of the coordinator whose keys start with one of prefixes, or all of them
+func (ms *Membership) selectNodes(prefixes []string) []NodeAllInfo {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	selected := []NodeAllInfo{}
+	for pub, info := range ms.nodes {
+		key := bytes32ToString(pub)
+		for _, p := range prefixes {
+			if p == "all" || (p != "" && strings.HasPrefix(key, p)) {
+				selected = append(selected, info)
+				break
+			}
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return bytes.Compare(selected[i].Pub.Bytes[:], selected[j].Pub.Bytes[:]) < 0
+	})
+	return selected
+}
+
+// collects the profiles of the nodes in the query and answers a line for every node
+func collectProfiles(ms *Membership, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := ProfileRequest{Type: q.Get("type"), Seconds: default_profileSeconds}
+	if req.Type == "" {
+		req.Type = "cpu"
+	}
+	if s := q.Get("seconds"); s != "" {
+		seconds, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			http.Error(w, "seconds: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Seconds = uint(seconds)
+	}
+	nodes := ms.selectNodes(strings.Split(q.Get("nodes"), ","))
+	if len(nodes) == 0 {
+		http.Error(w, "no nodes selected, give nodes=<key prefixes> or nodes=all", http.StatusBadRequest)
+		return
+	}
+	dir := filepath.Join(resultsDir, "profiles")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	coordinatorLog.infof(nil, "[Profile] %s profile of %d nodes for %d seconds", req.Type, len(nodes), req.Seconds)
+	lines := make([]string, len(nodes))
+	var wg WaitGroup
+	for i, info := range nodes {
+		i, info := i, info
+		wg.Add(1)
+		spawn(func() {
+			defer wg.Done()
+			key := bytes32ToString(info.Pub.Bytes)[:8]
+			answer := requestProfile(info, req)
+			if answer.Err != "" {
+				lines[i] = fmt.Sprintf("%s error %s", key, answer.Err)
+				return
+			}
+			name := filepath.Join(dir, fmt.Sprintf("%s_%s_%d.pprof", req.Type, key, time.Now().Unix()))
+			if err := os.WriteFile(name, answer.Profile, 0644); err != nil {
+				lines[i] = fmt.Sprintf("%s error %s", key, err)
+				return
+			}
+			lines[i] = fmt.Sprintf("%s %s %d", key, name, len(answer.Profile))
+		})
+	}
+	wg.Wait()
+	fmt.Fprintln(w, strings.Join(lines, "\n"))
+}
+
+func coordinatorPprofHandler(ms *Membership) *http.ServeMux {
+	mux := pprofHandler()
+	mux.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) { spawnAndWait(func() { collectProfiles(ms, w, r) }) })
+	mux.HandleFunc("/flight", func(w http.ResponseWriter, r *http.Request) { spawnAndWait(func() { serveFlightDumps(ms, w, r) }) })
+	mux.HandleFunc("/fault", func(w http.ResponseWriter, r *http.Request) { spawnAndWait(func() { serveFault(ms, w, r) }) })
+	mux.HandleFunc("/tune", func(w http.ResponseWriter, r *http.Request) { spawnAndWait(func() { serveTuning(ms, w, r) }) })
+	return mux
+}
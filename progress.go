@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the progress summary the coordinator logs every -progress seconds
+
+// errors logged by this process, see logging.go
+var loggedErrors uint64
+
+func countLoggedError() {
+	atomic.AddUint64(&loggedErrors, 1)
+}
+
+type Progress struct {
+	start       time.Time
+	seen        map[[32]byte]time.Time // node -> last stat
+	blocks      map[[32]byte]uint      // committee -> final blocks
+	lastBlocks  map[[32]byte]time.Time // committee -> last final block
+	txs         uint64                 // finished txs of the run
+	printedTxs  uint64                 // at the last summary
+	printed     time.Time
+	target      uint
+	acceptFails uint
+	forks       uint
+	mux         sync.Mutex
+}
+
+func (p *Progress) init() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.start = clockNow()
+	p.printed = p.start
+	p.seen = make(map[[32]byte]time.Time)
+	p.blocks = make(map[[32]byte]uint)
+	p.lastBlocks = make(map[[32]byte]time.Time)
+}
+
+func (p *Progress) heard(pub *PubKey) {
+	if pub == nil {
+		return
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.seen[pub.Bytes] = clockNow()
+}
+
+func (p *Progress) finalBlock(committeeID [32]byte) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.blocks[committeeID]++
+	p.lastBlocks[committeeID] = clockNow()
+}
+
+// txs finished by the tx generator while it sends target tps
+func (p *Progress) finished(txs int, target uint) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.txs += uint64(txs)
+	p.target = target
+}
+
+func (p *Progress) acceptFail() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.acceptFails++
+}
+
+func (p *Progress) fork() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.forks++
+}
+
+// the summary since the last one
+func (p *Progress) summary(nodes int) string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	now := clockNow()
+	alive := 0
+	for _, t := range p.seen {
+		if now.Sub(t) <= default_progressAlive*time.Second {
+			alive++
+		}
+	}
+	committees := make([]string, 0, len(p.blocks))
+	for c, n := range p.blocks {
+		committees = append(committees, fmt.Sprintf("%s:%d", shortID(c), n))
+	}
+	sort.Strings(committees)
+	if len(committees) == 0 {
+		committees = append(committees, "none")
+	}
+	tps := float64(p.txs-p.printedTxs) / now.Sub(p.printed).Seconds()
+	p.printed, p.printedTxs = now, p.txs
+	return fmt.Sprintf("[Progress] %s nodes alive %d/%d, blocks %s, tps %.1f of %d (%d txs), errors %d (accept fails %d, forks %d)",
+		now.Sub(p.start).Round(time.Second), alive, nodes, strings.Join(committees, " "), tps, p.target, p.txs, atomic.LoadUint64(&loggedErrors), p.acceptFails, p.forks)
+}
+
+func logProgress(p *Progress, ms *Membership, interval uint) {
+	for {
+		clockSleep(time.Duration(interval) * time.Second)
+		ms.mux.Lock()
+		nodes := len(ms.nodes)
+		ms.mux.Unlock()
+		coordinatorLog.infof(nil, "%s", p.summary(nodes))
+	}
+}
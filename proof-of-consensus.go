@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/renzhf/go-merkletree"
 )
@@ -23,7 +22,7 @@ func createMerkleTree(nodeCtx *NodeCtx, transactions []*Transaction) *merkletree
 
 func addProofOfConsensus(nodeCtx *NodeCtx, t *Transaction, finalBlock *FinalBlock) {
 
-	before := time.Now()
+	before := clockNow()
 	t.ProofOfConsensus = new(ProofOfConsensus)
 	t.ProofOfConsensus.GossipHash = finalBlock.ProposedBlock.GossipHash
 	t.ProofOfConsensus.IntermediateHash = finalBlock.ProposedBlock.calculateHashExceptMerkleRoot()
@@ -63,8 +62,8 @@ func addProofOfConsensus(nodeCtx *NodeCtx, t *Transaction, finalBlock *FinalBloc
 	t.ProofOfConsensus.MerkleRoot = root32
 	t.ProofOfConsensus.MerkleProof = proof
 
-	dur := time.Now().Sub(before)
-	go dialAndSendToCoordinator("pocadd", dur)
+	dur := clockNow().Sub(before)
+	spawnDialAndSendToCoordinator("pocadd", dur)
 
 	// fmt.Println("new tx PoC : ", t)
 }
@@ -0,0 +1,54 @@
+package main
+
+// pruning of the tx bodies of old blocks
+
+// returns a copy of the block without transactions
+func (b *FinalBlock) pruned() *FinalBlock {
+	pb := *b.ProposedBlock
+	pb.Transactions = nil
+	if b.Certificate != nil {
+		return &FinalBlock{ProposedBlock: &pb, Certificate: b.Certificate, Pruned: true}
+	}
+	return &FinalBlock{ProposedBlock: &pb, Signatures: b.Signatures, Pruned: true}
+}
+
+func (b *Blockchain) setRetention(retention uint) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.retention = retention
+}
+
+// walks back from the latest block and replaces blocks older than the retention with pruned copies,
+// stops at the first block that is allready pruned
+func (b *Blockchain) _prune() {
+	gh := b.LatestBlock
+	for i := uint(0); ; i++ {
+		block, ok := b.Blocks[gh]
+		if !ok {
+			return
+		}
+		if i > b.retention {
+			if block.Pruned {
+				return
+			}
+			b.Blocks[gh] = block.pruned()
+		}
+		if block.ProposedBlock.PreviousGossipHash == [32]byte{} {
+			return
+		}
+		gh = block.ProposedBlock.PreviousGossipHash
+	}
+}
+
+// the store is compacted every retention blocks
+func (b *Blockchain) _pruneStore() {
+	latest := b._getLatest().ProposedBlock.Iteration
+	if b.store == nil || latest <= b.retention || latest%b.retention != 0 {
+		return
+	}
+	before, after, err := b.store.prune(uint64(latest - b.retention))
+	if ifErr(err, "pruning block store") {
+		return
+	}
+	nodeLog.infof(nil, "[Pruning] block store %d -> %d bytes", before, after)
+}
@@ -0,0 +1,7 @@
+# runs the small cluster under the race detector, exits with the code of the first run that fails.
+# The integration test fails on a race, the simulation exits with 66
+set -e
+export GORACE="exitcode=66"
+go test -race -tags integration -run Integration -timeout 10m . -integrationTime 240
+go build -race -o rapidchain-race
+./rapidchain-race simulate -n 8 -m 2 -simTime 120 -epochLength 3 -chaos
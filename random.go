@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math/big"
+	mathrand "math/rand"
+	"sync"
+)
+
+// the source of the randomness of the protocol, seeded with -runSeed
+
+type RandomSource interface {
+	Read(b []byte)
+	Intn(n int) int
+	Shuffle(n int, swap func(i, j int))
+}
+
+// used by the coordinator, replaced by a seeded source with -runSeed
+var protocolRand RandomSource = cryptoSource{}
+
+type cryptoSource struct{}
+
+func (cryptoSource) Read(b []byte) {
+	_, err := rand.Read(b)
+	ifErrFatal(err, "crypto/rand")
+}
+
+func (cryptoSource) Intn(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	ifErrFatal(err, "crypto/rand")
+	return int(i.Int64())
+}
+
+// Fisher-Yates, like math/rand
+func (s cryptoSource) Shuffle(n int, swap func(i, j int)) {
+	for i := n - 1; i > 0; i-- {
+		swap(i, s.Intn(i+1))
+	}
+}
+
+type seededSource struct {
+	r   *mathrand.Rand
+	mux sync.Mutex
+}
+
+func newSeededSource(seed int64) *seededSource {
+	return &seededSource{r: mathrand.New(mathrand.NewSource(seed))}
+}
+
+func (s *seededSource) Read(b []byte) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.r.Read(b)
+}
+
+func (s *seededSource) Intn(n int) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.r.Intn(n)
+}
+
+func (s *seededSource) Shuffle(n int, swap func(i, j int)) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.r.Shuffle(n, swap)
+}
+
+// the randomness of the nodes: drg secrets, commitment nonces, keys and trace ids. In a simulation
+// it is drawn from the run seed, one goroutine at a time, so a replay draws the same
+func nodeRand() RandomSource {
+	if simNet != nil {
+		return simNet.scheduler.rand
+	}
+	return cryptoSource{}
+}
+
+// a random int64 from source, for seeds
+func randomInt64(source RandomSource) int64 {
+	b := make([]byte, 8)
+	source.Read(b)
+	return int64(binary.LittleEndian.Uint64(b))
+}
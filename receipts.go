@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A receipt for a committed transaction, signed by a member of the committee that committed it.
+// It carries an inclusion proof so the client can check that the transaction is in the block with
+// GossipHash without having the block or trusting the coordinator.
+type TxReceipt struct {
+	TxInclusionProof
+	CommitteeID     [32]byte
+	Iteration       uint
+	CertificateHash [32]byte // hash of the signature set the block was accepted with
+	Pub             *PubKey
+	Sig             *Sig // sig of the hash of the above
+}
+
+type TxReceiptBatch struct {
+	Receipts []*TxReceipt
+}
+
+func (r *TxReceipt) calculateHash() [32]byte {
+	mr := hash(r.MerkleRoot[:])
+	return hash(byteSliceAppend(r.TxID[:], r.CommitteeID[:], uintToByte(r.Iteration), uintToByte(r.Position), r.GossipHash[:], r.IntermediateHash[:], mr[:], r.CertificateHash[:], r.Pub.Bytes[:]))
+}
+
+func (r *TxReceipt) sign(pk *PrivKey) {
+	r.Pub = pk.Pub
+	r.Sig = pk.sign(r.calculateHash())
+}
+
+// hash of the signature set of a final block
+func certificateHash(signatures []*ConsensusMsg) [32]byte {
+	b := []byte{}
+	for _, cMsg := range signatures {
+		h := cMsg.calculateHash()
+		b = append(b, h[:]...)
+		b = append(b, cMsg.Sig.bytes()...)
+	}
+	return hash(b)
+}
+
+// creates a signed receipt for every committed user transaction in the block
+func createReceipts(nodeCtx *NodeCtx, finalBlock *FinalBlock) *TxReceiptBatch {
+	block := finalBlock.ProposedBlock
+	tree := createMerkleTree(nodeCtx, block.Transactions)
+	intermediate := block.calculateHashExceptMerkleRoot()
+	cert := certificateHash(finalBlock.Signatures)
+
+	batch := new(TxReceiptBatch)
+	for i, t := range block.Transactions {
+		what := t.whatAmI(nodeCtx)
+		if what != "normal" && what != "finaltransaction" {
+			continue
+		}
+		proof, err := txInclusionProofFromTree(block, tree, intermediate, i)
+		if ifErr(err, "generating receipt proof") {
+			continue
+		}
+		r := new(TxReceipt)
+		r.TxInclusionProof = *proof
+		r.CommitteeID = block.CommitteeID
+		r.Iteration = block.Iteration
+		r.CertificateHash = cert
+		r.sign(nodeCtx.self.Priv)
+		batch.Receipts = append(batch.Receipts, r)
+	}
+	return batch
+}
+
+// Verifies receipts on the client side, only using the public committee membership
+type ReceiptVerifier struct {
+	members  map[[32]byte][32]byte // Pub.Bytes -> CommitteeID
+	previous map[[32]byte][32]byte // of the last epoch, committees switch at different times
+	mux      sync.Mutex
+}
+
+func (rv *ReceiptVerifier) init() {
+	rv.mux.Lock()
+	defer rv.mux.Unlock()
+	rv.members = make(map[[32]byte][32]byte)
+	rv.previous = make(map[[32]byte][32]byte)
+}
+
+// sets the committees of a new epoch, returns how many nodes changed committee
+func (rv *ReceiptVerifier) setCommittees(rBlock *ReconfigurationBlock) int {
+	rv.mux.Lock()
+	defer rv.mux.Unlock()
+	rv.previous = rv.members
+	rv.members = make(map[[32]byte][32]byte)
+	moved := 0
+	for cID, c := range rBlock.Committees {
+		for pub := range c.Members {
+			rv.members[pub] = cID
+			if prev, ok := rv.previous[pub]; ok && prev != cID {
+				moved++
+			}
+		}
+	}
+	return moved
+}
+
+// a node that joined during the epoch
+func (rv *ReceiptVerifier) addMember(pub [32]byte, committeeID [32]byte) {
+	rv.mux.Lock()
+	defer rv.mux.Unlock()
+	rv.members[pub] = committeeID
+}
+
+// returns if the receipt is valid, and the reason if it is not
+func (rv *ReceiptVerifier) verify(r *TxReceipt) (bool, string) {
+	if r.Pub == nil || r.Sig == nil {
+		return false, "incomplete"
+	}
+	rv.mux.Lock()
+	cID, ok := rv.members[r.Pub.Bytes]
+	prev, prevOk := rv.previous[r.Pub.Bytes]
+	rv.mux.Unlock()
+	if (!ok || cID != r.CommitteeID) && (!prevOk || prev != r.CommitteeID) {
+		return false, "signer not in committee"
+	}
+	if !r.Pub.verify(r.calculateHash(), r.Sig) {
+		return false, "signature"
+	}
+	return r.TxInclusionProof.verify()
+}
+
+func receiptResultString(r *TxReceipt, ok bool, reason string) string {
+	return fmt.Sprintf("%s,%s,%d,%d,%s,%t,%s", bytes32ToString(r.TxID), bytes32ToString(r.CommitteeID), r.Iteration, r.Position, bytes32ToString(r.GossipHash), ok, reason)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// the reconfiguration block of the reference committee, signed and gossiped to the committees
+
+// a signature of a member of the reference committee on the block of the next epoch
+type RecBlockSig struct {
+	Hash [32]byte
+	Pub  *PubKey
+	Sig  *Sig
+}
+
+func recBlockSigHash(h [32]byte) [32]byte {
+	return hash(byteSliceAppend([]byte("reconfiguration"), h[:]))
+}
+
+type CertifiedReconfiguration struct {
+	Block ReconfigurationBlock
+	Sigs  []RecBlockSig
+}
+
+func (c *CertifiedReconfiguration) encode() []byte {
+	return getBytes(c)
+}
+
+func decodeCertifiedReconfiguration(b []byte) (*CertifiedReconfiguration, error) {
+	c := new(CertifiedReconfiguration)
+	err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(c)
+	return c, err
+}
+
+// certified blocks by their start iteration, and the signatures the aggregator collects
+type Reconfigurations struct {
+	certified map[uint]*CertifiedReconfiguration
+	sigs      map[[32]byte]map[[32]byte]RecBlockSig // block hash -> Pub.Bytes -> signature
+	mux       sync.Mutex
+}
+
+func (r *Reconfigurations) init() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.certified = make(map[uint]*CertifiedReconfiguration)
+	r.sigs = make(map[[32]byte]map[[32]byte]RecBlockSig)
+}
+
+func (r *Reconfigurations) get(i uint) *CertifiedReconfiguration {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.certified[i]
+}
+
+func (r *Reconfigurations) add(c *CertifiedReconfiguration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.certified[c.Block.StartIteration] = c
+}
+
+func (r *Reconfigurations) addSig(s RecBlockSig) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if _, ok := r.sigs[s.Hash]; !ok {
+		r.sigs[s.Hash] = make(map[[32]byte]RecBlockSig)
+	}
+	r.sigs[s.Hash][s.Pub.Bytes] = s
+}
+
+func (r *Reconfigurations) lenSigs(h [32]byte) int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return len(r.sigs[h])
+}
+
+// removes and returns the signatures on h
+func (r *Reconfigurations) takeSigs(h [32]byte) []RecBlockSig {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	sigs := []RecBlockSig{}
+	for _, s := range r.sigs[h] {
+		sigs = append(sigs, s)
+	}
+	delete(r.sigs, h)
+	return sigs
+}
+
+// returns "" if c is signed by enough members of the reference committee of rBlock, the block before
+// it, otherwise the reason
+func verifyCertifiedReconfiguration(nodeCtx *NodeCtx, rBlock *ReconfigurationBlock, c *CertifiedReconfiguration) string {
+	if c.Block.Hash != c.Block.calculateHash() {
+		return "block hash"
+	}
+	if c.Block.StartIteration <= rBlock.StartIteration {
+		return "block of an earlier epoch"
+	}
+	ref := referenceCommittee(rBlock)
+	signed := make(map[[32]byte]bool)
+	for _, s := range c.Sigs {
+		if s.Pub == nil || s.Hash != c.Block.Hash {
+			return "signature on another block"
+		}
+		if _, ok := ref.Members[s.Pub.Bytes]; !ok {
+			return "signature of a node outside the reference committee"
+		}
+		if !s.Pub.verify(recBlockSigHash(s.Hash), s.Sig) {
+			return "signature"
+		}
+		signed[s.Pub.Bytes] = true
+	}
+	if len(signed) < drgRequired(ref, nodeCtx) {
+		return fmt.Sprintf("%d of %d signatures", len(signed), drgRequired(ref, nodeCtx))
+	}
+	return ""
+}
+
+// waits until cond or the timeout in deltas. Unlike drgWait it checks cond every 100ms, the time
+// until the switch is measured
+func pollWait(nodeCtx *NodeCtx, deltas int, cond func() bool) {
+	timeout := clockNow().Add(time.Duration(deltas) * nodeCtx.delta())
+	for !cond() && clockNow().Before(timeout) {
+		clockSleep(100 * time.Millisecond)
+	}
+}
+
+// the member with the lowest pub, the aggregator in the reference committee, as in the drg, and the
+// disperser in the others
+func lowestMember(c *Committee) *CommitteeMember {
+	return c.Members[c.getMemberIDsAsSortedList()[0]]
+}
+
+// signs rBlock, computed by a member of the reference committee, and sends the signature to the
+// aggregator. The aggregator certifies the block and sends it to the other committees
+func signReconfiguration(nodeCtx *NodeCtx, prev, rBlock *ReconfigurationBlock) {
+	ref := referenceCommittee(prev)
+	s := RecBlockSig{rBlock.Hash, nodeCtx.self.Priv.Pub, nodeCtx.self.Priv.sign(recBlockSigHash(rBlock.Hash))}
+	if !amIDrgAggregator(nodeCtx, ref) {
+		spawnDialAndSend(lowestMember(ref).IP, Msg{"reconfiguration_sig", s, nodeCtx.self.Priv.Pub})
+		return
+	}
+	nodeCtx.reconfigurations.addSig(s)
+	spawn(func() { certifyReconfiguration(nodeCtx, prev, rBlock) })
+}
+
+func handleRecBlockSig(nodeCtx *NodeCtx, s RecBlockSig) {
+	if s.Pub == nil || !s.Pub.verify(recBlockSigHash(s.Hash), s.Sig) {
+		errr(nil, "reconfiguration signature")
+		return
+	}
+	nodeCtx.reconfigurations.addSig(s)
+}
+
+// waits for the signatures of the reference committee on rBlock and sends the certified block to
+// the reference committee, the disperser of every other committee and the coordinator
+func certifyReconfiguration(nodeCtx *NodeCtx, prev, rBlock *ReconfigurationBlock) {
+	defer nodeCtx.instance.catchPanic()
+	ref := referenceCommittee(prev)
+	pollWait(nodeCtx, default_drgTimeout, func() bool { return nodeCtx.reconfigurations.lenSigs(rBlock.Hash) >= drgRequired(ref, nodeCtx) })
+	c := CertifiedReconfiguration{*rBlock, nodeCtx.reconfigurations.takeSigs(rBlock.Hash)}
+	if reason := verifyCertifiedReconfiguration(nodeCtx, prev, &c); reason != "" {
+		errFatal(nil, "reconfiguration certificate: "+reason)
+	}
+	msg := Msg{"certified_reconfiguration", c, nodeCtx.self.Priv.Pub}
+	sendMsgToCommittee(msg, ref)
+	for id, committee := range prev.Committees {
+		if id != ref.ID && len(committee.Members) > 0 {
+			spawnDialAndSend(lowestMember(committee).IP, msg)
+		}
+	}
+	spawnDialAndSendToCoordinator("reconfiguration", *rBlock)
+}
+
+// adds a certified block of the next epoch. A member outside the reference committee that got it
+// from the aggregator disperses it in its committee before the block can be applied. It is verified
+// with the block before it, the members of the reference committee already switched when it arrives
+func handleCertifiedReconfiguration(nodeCtx *NodeCtx, c *CertifiedReconfiguration, disperse bool) {
+	if c.Block.StartIteration == 0 || nodeCtx.reconfigurations.get(c.Block.StartIteration) != nil {
+		return
+	}
+	rBlock := nodeCtx.blockchain.reconfigurationBlockAt(c.Block.StartIteration - 1)
+	if reason := verifyCertifiedReconfiguration(nodeCtx, rBlock, c); reason != "" {
+		errr(nil, "certified reconfiguration: "+reason)
+		return
+	}
+	_, inReference := referenceCommittee(rBlock).Members[nodeCtx.self.Priv.Pub.Bytes]
+	if disperse && !inReference && len(nodeCtx.neighborAddrs()) > 0 {
+		IDAGossip(nodeCtx, c.encode(), "reconfiguration")
+	}
+	nodeCtx.reconfigurations.add(c)
+}
+
+// the certified block of the epoch that starts at iteration i. Waits for the gossip and asks the
+// reference committee if it does not arrive
+func awaitReconfiguration(nodeCtx *NodeCtx, i uint) *ReconfigurationBlock {
+	for attempt := 0; attempt < 3; attempt++ {
+		pollWait(nodeCtx, default_drgTimeout, func() bool { return nodeCtx.reconfigurations.get(i) != nil })
+		if c := nodeCtx.reconfigurations.get(i); c != nil {
+			return &c.Block
+		}
+		nodeLog.warnf(nodeCtx, "Reconfiguration block of iteration %d not recived by ida gossip, asking the reference committee", i)
+		requestCertifiedReconfiguration(nodeCtx, i)
+	}
+	errFatal(nil, fmt.Sprintf("reconfiguration block of iteration %d not recived", i))
+	return nil
+}
+
+func requestCertifiedReconfiguration(nodeCtx *NodeCtx, i uint) {
+	ref := referenceCommittee(nodeCtx.blockchain.getLastReconfigurationBlock())
+	for _, member := range ref.sortedMembers() {
+		c := new(CertifiedReconfiguration)
+		if !requestFrom(member.IP, Msg{"request_certified_reconfiguration", i, nodeCtx.self.Priv.Pub}, c) {
+			continue
+		}
+		if c.Block.StartIteration == i {
+			handleCertifiedReconfiguration(nodeCtx, c, false)
+			return
+		}
+	}
+}
+
+// committee before,committee after,moved,reference mode,time until the block was known in ms,time
+// until the switch in ms
+func reportSwitch(nodeCtx *NodeCtx, from [32]byte, start time.Time, known time.Duration) {
+	s := fmt.Sprintf("%s,%s,%t,%s,%d,%d", bytes32ToString(from), bytes32ToString(nodeCtx.committeeID()), from != nodeCtx.committeeID(), nodeCtx.flagArgs.reference, known.Milliseconds(), clockSince(start).Milliseconds())
+	spawnDialAndSendToCoordinator("switch", s)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// supervise, nodes as processes that exit and recover
+
+// exit code of a node that exits to be started again
+const recoverExitCode = 3
+
+var processStarted = time.Now()
+
+// syncs the state of the node and exits if it is due, at the start of an iteration
+func recoverExit(nodeCtx *NodeCtx) {
+	if !nodeCtx.flagArgs.recoverExit || clockSince(processStarted) < time.Duration(nodeCtx.flagArgs.recoverEvery)*time.Second {
+		return
+	}
+	nodeLog.warnf(nodeCtx, "[Recovery] exits at iteration %d to be started again", nodeCtx.i.getI())
+	nodeCtx.stopped.set()
+	nodeCtx.blockchain.closeStore()
+	nodeCtx.wal.close()
+	exitStatus.exit(recoverExitCode, "")
+}
+
+// the node of a process the supervisor started again, with the key and port of the one that exited
+func recoverNode(flagArgs *FlagArgs, in *Instance) {
+	privKey := nodeKey(flagArgs, in.count)
+	address := nodeListenAddr(flagArgs, nodePort(flagArgs, in.count))
+	listener, err := netListen(address)
+	ifErrFatal(err, "listener recovered node")
+	in.mux.Lock()
+	in.flagArgs, in.privKey, in.listener = flagArgs, privKey, listener
+	in.killed = time.Unix(0, flagArgs.recoverFrom)
+	in.down = true
+	in.mux.Unlock()
+	nodeLog.infof(nil, "[Recovery] %s starts again %s after its exit", address, time.Since(in.killed).Round(time.Millisecond))
+	in.rejoin(listener, true)
+}
+
+// the arguments of node i, later flags win over the ones of the supervisor
+func recoveryArgs(flagArgs *FlagArgs, i uint, port uint, exited time.Time) []string {
+	keyfile := filepath.Join("recovery", fmt.Sprintf("node-%d.pem", i))
+	if flagArgs.keyfile != "" {
+		keyfile = keyfilePath(flagArgs.keyfile, i, flagArgs.n)
+	}
+	blockStore := flagArgs.blockStore
+	if blockStore == "" {
+		blockStore = filepath.Join("recovery", "blocks")
+	}
+	args := append([]string{"node"}, commandLineFlags("node")...)
+	args = append(args, "-instances=1",
+		fmt.Sprintf("-ports=%d", port),
+		"-keyfile="+keyfile,
+		"-blockStore="+blockStore,
+		fmt.Sprintf("-recoverExit=%t", i < flagArgs.recoverNodes))
+	if flagArgs.cpuSet != "" {
+		cpus, _ := parseCPUSet(flagArgs.cpuSet)
+		args = append(args, "-cpuSet="+formatCPUSet(cpuShare(cpus, i, flagArgs.n)))
+	}
+	if !exited.IsZero() {
+		args = append(args, fmt.Sprintf("-recoverFrom=%d", exited.UnixNano()))
+	}
+	return args
+}
+
+// runs node i and starts it again every time it exits to recover
+func superviseNode(flagArgs *FlagArgs, exe string, i uint, running *sync.Map) {
+	var exited time.Time
+	port := nodePort(flagArgs, i)
+	if port == 0 {
+		var err error
+		if port, err = freePort(); ifErr(err, fmt.Sprintf("free port of node %d", i)) {
+			return
+		}
+	}
+	for {
+		cmd := exec.Command(exe, recoveryArgs(flagArgs, i, port, exited)...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if ifErr(cmd.Start(), fmt.Sprintf("starting node %d", i)) {
+			return
+		}
+		running.Store(i, cmd.Process)
+		err := cmd.Wait()
+		running.Delete(i)
+		if cmd.ProcessState.ExitCode() != recoverExitCode {
+			nodeLog.warnf(nil, "[Recovery] node %d ended: %v", i, err)
+			return
+		}
+		exited = time.Now()
+		nodeLog.infof(nil, "[Recovery] node %d exited to recover, starting it again", i)
+	}
+}
+
+func supervise(flagArgs *FlagArgs) {
+	exe, err := os.Executable()
+	ifErrFatal(err, "supervisor executable")
+	ifErrFatal(os.MkdirAll("recovery", 0755), "recovery directory")
+	nodeLog.infof(nil, "[Recovery] %d node processes, %d of them exit every %d seconds", flagArgs.n, flagArgs.recoverNodes, flagArgs.recoverEvery)
+	running := new(sync.Map)
+	for i := uint(0); i < flagArgs.n; i++ {
+		i := i
+		goRecorded(func() { superviseNode(flagArgs, exe, i, running) })
+	}
+	// the nodes stop with the supervisor
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	<-sigs
+	running.Range(func(_, p interface{}) bool {
+		p.(*os.Process).Signal(os.Interrupt)
+		return true
+	})
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// -reference, who produces the reconfiguration blocks
+
+const referenceByCoordinator = "coordinator"
+const referenceByCommittee = "committee"
+
+func isReferenceMode(s string) bool {
+	return s == referenceByCoordinator || s == referenceByCommittee
+}
+
+// the epoch a node asks the coordinator for and the iteration it starts at
+type EpochRequest struct {
+	Epoch          uint
+	StartIteration uint
+}
+
+// the block of epoch, computed with randomness of the coordinator when the first node asks for it.
+// Returns the block, the number of nodes that moved and if it is new
+func (ms *Membership) reconfiguration(req EpochRequest) (*ReconfigurationBlock, int, bool, string) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	if rBlock, ok := ms.epochs[req.Epoch]; ok {
+		return rBlock, 0, false, ""
+	}
+	rnd := make([]byte, 32)
+	protocolRand.Read(rnd)
+	rBlock, moved := cuckooRule(ms.rBlock, hash(rnd), ms.churn, default_cuckooRegions)
+	rBlock.StartIteration = req.StartIteration
+	rBlock.addEvidence(pendingEvidence(ms.blacklist.evidence, ms.rBlock))
+	if ms.flagArgs.epochLength > 0 {
+		elapsed, changes := ms.clock.get()
+		rBlock.EpochLength = nextEpochLength(ms.flagArgs, ms.rBlock, elapsed, changes)
+		ms.clock.init()
+	}
+	rBlock.setHash()
+	ms.epochs[req.Epoch] = rBlock
+	for _, c := range rBlock.Committees {
+		for pub := range c.Members {
+			if info, ok := ms.nodes[pub]; ok {
+				info.CommitteeID = c.ID
+				ms.nodes[pub] = info
+			}
+		}
+	}
+	ms.rBlock = rBlock
+	row := ms._adapt(rBlock)
+	ms._checkAdversaries(rBlock)
+	return rBlock, moved, true, row
+}
+
+// the block of the next epoch from the coordinator
+func requestReconfiguration(nodeCtx *NodeCtx, i uint) *ReconfigurationBlock {
+	rBlock := new(ReconfigurationBlock)
+	conn := dial(coordinatorAddr())
+	defer conn.Close()
+	sendMsg(conn, Msg{"request_reconfiguration", EpochRequest{nodeCtx.blockchain.epoch() + 1, i}, nodeCtx.self.Priv.Pub})
+	reciveMsg(conn, rBlock)
+	if rBlock.StartIteration != i || rBlock.Hash != rBlock.calculateHash() {
+		errFatal(nil, "invalid reconfiguration block from the coordinator")
+	}
+	return rBlock
+}
+
+// nodes that are in another committee in next than in prev
+func movedNodes(prev, next *ReconfigurationBlock) int {
+	moved := 0
+	for id, c := range next.Committees {
+		for pub := range c.Members {
+			if p := prev.committeeOf(pub); p != nil && p.ID != id {
+				moved++
+			}
+		}
+	}
+	return moved
+}
+
+// the members of the reference committee, the addresses a joining node asks for admission
+func (ms *Membership) reference() []NodeAllInfo {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	members := []NodeAllInfo{}
+	if ms.rBlock == nil {
+		return members
+	}
+	for _, m := range referenceCommittee(ms.rBlock).sortedMembers() {
+		if info, ok := ms.nodes[m.Pub.Bytes]; ok {
+			members = append(members, info)
+		}
+	}
+	return members
+}
+
+// a node that was admitted by the reference committee
+func (ms *Membership) add(info NodeAllInfo) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	if _, ok := ms.nodes[info.Pub.Bytes]; ok || ms.rBlock == nil {
+		return
+	}
+	ms.nodes[info.Pub.Bytes] = info
+	ms.rBlock = withMember(ms.rBlock, info.CommitteeID, &CommitteeMember{info.Pub, info.IP})
+	ms.clock.change()
+}
+
+type JoinRequest struct {
+	Pub      *PubKey
+	Port     int
+	Host     string // see Node_InitialMessageToCoordinator
+	PowNonce uint64
+}
+
+// a member of the reference committee admits Info to its committee in Epoch
+type Admission struct {
+	Info  NodeAllInfo
+	Epoch uint
+	Pub   *PubKey
+	Sig   *Sig
+}
+
+func (a *Admission) calculateHash() [32]byte {
+	e := make([]byte, 8)
+	binary.LittleEndian.PutUint64(e, uint64(a.Epoch))
+	return hash(byteSliceAppend([]byte("admission"), e, a.Info.Pub.Bytes[:], a.Info.CommitteeID[:], []byte(a.Info.IP)))
+}
+
+// the answer of a member: its admission and its view of the nodes, which includes the new node
+type AdmissionAnswer struct {
+	Admission Admission
+	Setup     ResponseToNodes
+}
+
+// the admissions a node announces itself with
+type JoinCertificate struct {
+	Info       NodeAllInfo
+	Admissions []Admission
+}
+
+// the puzzle of a joining node, on the randomness of the epoch it joins
+func joinChallenge(nodeCtx *NodeCtx) PowChallenge {
+	rnd := nodeCtx.blockchain.getLastReconfigurationBlock().Randomness
+	return PowChallenge{hash(byteSliceAppend([]byte("join"), rnd[:])), nodeCtx.flagArgs.powDifficulty}
+}
+
+// admits req of a node at addr if this node is a member of the reference committee, the solution
+// is valid and the key is new. Returns false otherwise
+func admit(nodeCtx *NodeCtx, req JoinRequest, addr string) (*AdmissionAnswer, bool) {
+	rBlock := nodeCtx.blockchain.getLastReconfigurationBlock()
+	if req.Pub == nil || nodeCtx.committeeID() != referenceCommittee(rBlock).ID {
+		return nil, false
+	}
+	if nodeCtx.flagArgs.powDifficulty > 0 && !verifyPow(joinChallenge(nodeCtx), req.Pub.Bytes, req.PowNonce) {
+		nodeLog.warnf(nodeCtx, "[Admission] rejected %s without a valid proof of work", addr)
+		return nil, false
+	}
+	if _, ok := nodeCtx.allInfo[req.Pub.Bytes]; ok || req.Pub.Bytes == nodeCtx.self.Priv.Pub.Bytes {
+		nodeLog.warnf(nodeCtx, "[Admission] rejected %s, key %s is already a node", addr, bytes32ToString(req.Pub.Bytes))
+		return nil, false
+	}
+	info := NodeAllInfo{Pub: req.Pub, CommitteeID: smallestCommittee(rBlock), IP: addr, IsHonest: true}
+	a := Admission{Info: info, Epoch: nodeCtx.blockchain.epoch(), Pub: nodeCtx.self.Priv.Pub}
+	a.Sig = nodeCtx.self.Priv.sign(a.calculateHash())
+
+	nodes := []NodeAllInfo{info, {nodeCtx.self.Priv.Pub, nodeCtx.committeeID(), nodeCtx.self.IP, nodeCtx.self.IsHonest}}
+	for _, n := range nodeCtx.allInfo {
+		nodes = append(nodes, n)
+	}
+	setup := ResponseToNodes{Nodes: nodes, GenesisHashes: nodeCtx.genesisHashes, ReconfigurationBlock: withMember(rBlock, info.CommitteeID, &CommitteeMember{info.Pub, info.IP})}
+	return &AdmissionAnswer{a, setup}, true
+}
+
+// returns "" if the certificate has admissions of enough members of the reference committee in
+// rBlock, otherwise the reason
+func verifyJoinCertificate(nodeCtx *NodeCtx, rBlock *ReconfigurationBlock, cert *JoinCertificate) string {
+	if cert.Info.Pub == nil {
+		return "no pub"
+	}
+	ref := referenceCommittee(rBlock)
+	admitted := make(map[[32]byte]bool)
+	for _, a := range cert.Admissions {
+		a := a
+		if a.Pub == nil || a.Info.Pub == nil || a.Info.Pub.Bytes != cert.Info.Pub.Bytes || a.Info.CommitteeID != cert.Info.CommitteeID || a.Info.IP != cert.Info.IP {
+			return "admission of another node"
+		}
+		if _, ok := ref.Members[a.Pub.Bytes]; !ok {
+			return "admission of a node outside the reference committee"
+		}
+		if !a.Pub.verify(a.calculateHash(), a.Sig) {
+			return "admission signature"
+		}
+		admitted[a.Pub.Bytes] = true
+	}
+	if len(admitted) < drgRequired(ref, nodeCtx) {
+		return fmt.Sprintf("%d of %d admissions", len(admitted), drgRequired(ref, nodeCtx))
+	}
+	return ""
+}
+
+// asks every member of the reference committee to admit this node and announces it with their
+// admissions. Returns the view of the nodes of the first member that admitted it and the solution
+// of the puzzle
+func admissionByReference(conn net.Conn, portNumber int, privKey *PrivKey, nodeCtx *NodeCtx) (*ResponseToNodes, *PowSolution) {
+	refs := []NodeAllInfo{}
+	requestFromCoordinator(true, "request_reference", Node_InitialMessageToCoordinator{}, &refs)
+	if len(refs) == 0 {
+		errFatal(nil, "no reference committee to join with")
+	}
+	// the challenge of the first member that answers, one that is down is skipped
+	var challenge *PowChallenge
+	for _, ref := range refs {
+		c := new(PowChallenge)
+		if requestFrom(ref.IP, Msg{"request_join_challenge", "", privKey.Pub}, c) {
+			challenge = c
+			break
+		}
+		nodeLog.warnf(nodeCtx, "[Admission] no join challenge from %s", ref.IP)
+	}
+	if challenge == nil {
+		errFatal(nil, "no member of the reference committee gave a join challenge")
+	}
+	var pow *PowSolution
+	req := JoinRequest{Pub: privKey.Pub, Port: portNumber, Host: nodeCtx.flagArgs.advertise}
+	if challenge.Difficulty > 0 {
+		pow = solvePow(*challenge, privKey.Pub.Bytes)
+		req.PowNonce = pow.Nonce
+	}
+
+	var setup *ResponseToNodes
+	cert := JoinCertificate{}
+	for _, ref := range refs {
+		answer := new(AdmissionAnswer)
+		if !requestFrom(ref.IP, Msg{"join_request", req, privKey.Pub}, answer) || answer.Admission.Info.Pub == nil {
+			nodeLog.warnf(nodeCtx, "[Admission] not admitted by %s", ref.IP)
+			continue
+		}
+		if setup == nil {
+			setup = &answer.Setup
+			cert.Info = answer.Admission.Info
+		}
+		cert.Admissions = append(cert.Admissions, answer.Admission)
+	}
+	if setup == nil {
+		errFatal(nil, "join rejected by the reference committee")
+	}
+	if reason := verifyJoinCertificate(nodeCtx, setup.ReconfigurationBlock, &cert); reason != "" {
+		errFatal(nil, "join certificate: "+reason)
+	}
+	for _, n := range setup.Nodes {
+		if n.Pub.Bytes != privKey.Pub.Bytes {
+			spawnDialAndSend(n.IP, Msg{"node_join", cert, privKey.Pub})
+		}
+	}
+	// the coordinator only collects the stats
+	sendMsg(conn, Msg{"node_join", cert, privKey.Pub})
+	return setup, pow
+}
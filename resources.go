@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -resourceInterval, the resources of the nodes over time
+
+type ResourceSample struct {
+	Pub          [32]byte
+	T            time.Time
+	Epoch        uint
+	Iteration    uint
+	RSS          int64
+	Heap         uint64
+	Goroutines   int
+	GCPauseTotal time.Duration
+	GCs          uint32
+	OpenFDs      int
+}
+
+// resident bytes of the process, -1 without /proc
+func residentBytes() int64 {
+	b, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return -1
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 {
+		return -1
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return pages * int64(os.Getpagesize())
+}
+
+// open file descriptors of the process, -1 without /proc
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	// the fd of the directory itself
+	return len(entries) - 1
+}
+
+func sampleResources(nodeCtx *NodeCtx) ResourceSample {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ResourceSample{
+		Pub:          nodeCtx.self.Priv.Pub.Bytes,
+		T:            coordinatorTime(clockNow()),
+		Epoch:        nodeCtx.blockchain.epoch(),
+		Iteration:    nodeCtx.i.getI(),
+		RSS:          residentBytes(),
+		Heap:         ms.HeapAlloc,
+		Goroutines:   runtime.NumGoroutine(),
+		GCPauseTotal: time.Duration(ms.PauseTotalNs),
+		GCs:          ms.NumGC,
+		OpenFDs:      openFDs(),
+	}
+}
+
+// sends a sample of the resources to the coordinator every -resourceInterval seconds
+func reportResources(nodeCtx *NodeCtx) {
+	for {
+		clockSleep(time.Duration(nodeCtx.flagArgs.resourceInterval) * time.Second)
+		if nodeCtx.stopped.get() {
+			return
+		}
+		spawnDialAndSend(coordinatorAddr(), Msg{"resource_sample", sampleResources(nodeCtx), nodeCtx.self.Priv.Pub})
+	}
+}
+
+// the resource files of the nodes on the coordinator
+type ResourceFiles struct {
+	dir   string
+	files map[[32]byte]*os.File
+	mux   sync.Mutex
+}
+
+func (rf *ResourceFiles) init(dir string) {
+	rf.mux.Lock()
+	defer rf.mux.Unlock()
+	rf.dir = dir
+	rf.files = make(map[[32]byte]*os.File)
+}
+
+func (rf *ResourceFiles) write(s ResourceSample) {
+	rf.mux.Lock()
+	defer rf.mux.Unlock()
+	f, ok := rf.files[s.Pub]
+	if !ok {
+		err := os.MkdirAll(rf.dir, 0755)
+		if ifErr(err, "resources dir") {
+			return
+		}
+		f, err = os.Create(rf.dir + "/" + bytes32ToString(s.Pub) + ".csv")
+		if ifErr(err, "resources file") {
+			return
+		}
+		rf.files[s.Pub] = f
+	}
+	row := fmt.Sprintf("%d,%d,%d,%d,%d,%d,%d,%d,%d", s.T.UnixNano(), s.Epoch, s.Iteration, s.RSS, s.Heap, s.Goroutines, s.GCPauseTotal.Milliseconds(), s.GCs, s.OpenFDs)
+	writeStringToFile(row, f)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// the names of the results files in -resultsDir
+
+const resultsTimeFormat = "2006-01-02T15-04-05"
+
+var resultsDir = "results"
+var runName = time.Now().Format(resultsTimeFormat)
+
+// the results of the process go to dir, named by name if it is not empty
+func setResults(dir string, name string) {
+	resultsDir = dir
+	if name != "" {
+		runName = name
+	}
+}
+
+// the path of the results of kind without the extension, creates the directory
+func resultsName(kind string) string {
+	ifErrFatal(os.MkdirAll(resultsDir, 0755), "results directory "+resultsDir)
+	return filepath.Join(resultsDir, kind+"-"+runName)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// a node process that stops on SIGINT or SIGTERM
+
+// waits for a signal and shuts the nodes of the process down, does not return outside of a
+// simulation
+func shutdownOnSignal(instances []*Instance) {
+	if simNet != nil {
+		return
+	}
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigs
+	nodeLog.infof(nil, "[Shutdown] %v, stopping %d instances", sig, len(instances))
+	var wg sync.WaitGroup
+	for _, in := range instances {
+		wg.Add(1)
+		go func(in *Instance) {
+			defer recordPanic()
+			defer wg.Done()
+			in.shutdown()
+		}(in)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer recordPanic()
+		wg.Wait()
+		flushStatsQueue()
+		close(done)
+	}()
+	select {
+	case <-done:
+		nodeLog.infof(nil, "[Shutdown] done")
+	case sig = <-sigs:
+		nodeLog.warnf(nil, "[Shutdown] %v, exiting before the nodes are done", sig)
+	case <-time.After(default_shutdownTimeout * time.Second):
+		nodeLog.warnf(nil, "[Shutdown] nodes not done after %d seconds, exiting", default_shutdownTimeout)
+	}
+	exitStatus.exit(exitSuccess, "")
+}
+
+// stops the node of the instance, flushes its state and tells the coordinator
+func (in *Instance) shutdown() {
+	in.mux.Lock()
+	nodeCtx, listener, down := in.nodeCtx, in.listener, in.down
+	in.down = true
+	in.mux.Unlock()
+	// a node that is not set up yet has nothing to flush, a killed one did it already
+	if nodeCtx == nil || down {
+		return
+	}
+	nodeCtx.stopped.set()
+	ifErr(listener.Close(), "closing listener of stopped node")
+	nodeCtx.blockchain.closeStore()
+	nodeCtx.wal.close()
+
+	i := nodeCtx.i.getI()
+	for _, msg := range finalStats(nodeCtx) {
+		sendBeforeExit(msg)
+	}
+	height := uint(0)
+	if latest := nodeCtx.blockchain.getLatest(); latest != nil {
+		height = latest.ProposedBlock.Iteration
+	}
+	s := fmt.Sprintf("shutdown,%s,%s,%d,%d,%d,%d", bytes32ToString(nodeCtx.committeeID()), bytes32ToString(nodeCtx.self.Priv.Pub.Bytes), i, height, nodeCtx.txPool.len(), clockSince(processStarted).Milliseconds())
+	sendBeforeExit(Msg{"churn", s, nil})
+
+	d := &nodeCtx.departures
+	d.mux.Lock()
+	announced := d.self != 0
+	if !announced {
+		d.self = i + 1
+	}
+	d.mux.Unlock()
+	if !announced {
+		sendBeforeExit(Msg{"leave", Leave{nodeCtx.self.Priv.Pub.Bytes, i + 1}, nil})
+	}
+	nodeLog.infof(nodeCtx, "[Shutdown] stopped in committee %s at iteration %d, height %d", bytes32ToString(nodeCtx.committeeID()), i, height)
+}
+
+// the reports of the node since its last ones
+func finalStats(nodeCtx *NodeCtx) []Msg {
+	msgs := []Msg{}
+	if nodeCtx.latencies.enabled {
+		if r := nodeCtx.latencies.take(nodeCtx.blockchain.epoch()); r != nil {
+			msgs = append(msgs, Msg{"latency_report", *r, nodeCtx.self.Priv.Pub})
+		}
+	}
+	if nodeCtx.wire.enabled {
+		if r := nodeCtx.wire.take(nodeCtx.committeeID()); r != nil {
+			msgs = append(msgs, Msg{"wire_stats", *r, nodeCtx.self.Priv.Pub})
+		}
+	}
+	if nodeCtx.flagArgs.resourceInterval != 0 {
+		msgs = append(msgs, Msg{"resource_sample", sampleResources(nodeCtx), nodeCtx.self.Priv.Pub})
+	}
+	return msgs
+}
+
+// sends the stats of -statsBatch that are still queued
+func flushStatsQueue() {
+	statsQueue.mux.Lock()
+	msgs := statsQueue.msgs
+	statsQueue.msgs = nil
+	statsQueue.mux.Unlock()
+	if len(msgs) > 0 {
+		sendBeforeExit(Msg{"stats_batch", StatsBatch{msgs}, nil})
+	}
+}
+
+// sends msg to the coordinator, gives up if it is gone
+func sendBeforeExit(msg Msg) {
+	timeout := default_shutdownDial * time.Second
+	conn, err := netDialTimeout(coordinatorAddr(), timeout)
+	if err != nil {
+		nodeLog.warnf(nil, "[Shutdown] %s not sent, coordinator gone: %v", msg.Typ, err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(clockNow().Add(timeout))
+	ifErr(gob.NewEncoder(conn).Encode(msg), "shutdown "+msg.Typ)
+}
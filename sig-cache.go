@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// caches of verified signatures and parsed keys
+
+type lruEntry struct {
+	key   [32]byte
+	value interface{}
+}
+
+type LRUCache struct {
+	size    uint
+	ll      *list.List                 // front is most recently used
+	m       map[[32]byte]*list.Element // key -> element with lruEntry
+	hits    uint64
+	misses  uint64
+	changed bool
+	mux     sync.Mutex
+}
+
+func (c *LRUCache) init(size uint) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.size = size
+	c.ll = list.New()
+	c.m = make(map[[32]byte]*list.Element)
+}
+
+func (c *LRUCache) enabled() bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.size > 0
+}
+
+func (c *LRUCache) get(key [32]byte) (interface{}, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.size == 0 {
+		return nil, false
+	}
+	c.changed = true
+	e, ok := c.m[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.ll.MoveToFront(e)
+	return e.Value.(lruEntry).value, true
+}
+
+func (c *LRUCache) add(key [32]byte, value interface{}) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.size == 0 {
+		return
+	}
+	if e, ok := c.m[key]; ok {
+		e.Value = lruEntry{key, value}
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.m[key] = c.ll.PushFront(lruEntry{key, value})
+	for uint(c.ll.Len()) > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.m, oldest.Value.(lruEntry).key)
+	}
+}
+
+// returns "hits,misses,hitrate,entries" and if there has been lookups since last call
+func (c *LRUCache) statsIfChanged() (string, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	changed := c.changed
+	c.changed = false
+	rate := 0.0
+	if c.hits+c.misses > 0 {
+		rate = float64(c.hits) / float64(c.hits+c.misses)
+	}
+	return fmt.Sprintf("%d,%d,%.3f,%d", c.hits, c.misses, rate, c.ll.Len()), changed
+}
+
+// verified signatures and parsed keys, sized with -sigCache
+var sigCache LRUCache
+var keyCache LRUCache
+
+func initSigCaches(size uint) {
+	sigCache.init(size)
+	keyCache.init(size)
+}
+
+// the key itself and not PubKey.Bytes, which is only a hash of it and could be sent with another key
+func sigCacheKey(keyBytes []byte, hashedMsg [32]byte, sig *Sig) [32]byte {
+	w := canonicalWriter{new(bytes.Buffer)}
+	w.bytes(keyBytes)
+	w.bytes32(hashedMsg)
+	w.sig(sig)
+	return hash(w.buf.Bytes())
+}
+
+func reportSigCache(nodeCtx *NodeCtx) {
+	sigStats, sigChanged := sigCache.statsIfChanged()
+	keyStats, keyChanged := keyCache.statsIfChanged()
+	if !sigChanged && !keyChanged {
+		return
+	}
+	spawnDialAndSendToCoordinator("sig_cache", bytes32ToString(nodeCtx.self.Priv.Pub.Bytes)+","+sigStats+","+keyStats)
+}
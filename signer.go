@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"math/big"
+)
+
+// the signature schemes of -sigScheme
+
+const (
+	schemeECDSA   = "ecdsa" // P-256
+	schemeEd25519 = "ed25519"
+	schemeBls     = "bls" // BLS12-381, see bls.go
+)
+
+// scheme for new keys, every node uses the same so it is a global like eCurve
+var sigScheme string = schemeECDSA
+
+var sigSchemes = []string{schemeECDSA, schemeEd25519, schemeBls}
+
+func isSigScheme(scheme string) bool {
+	for _, s := range sigSchemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+type Signer interface {
+	sign(hashedMsg [32]byte) *Sig
+}
+
+type Verifier interface {
+	verify(hashedMsg [32]byte, sig *Sig) bool
+	keyBytes() []byte // hashed to PubKey.Bytes
+	encode() []byte   // wire encoding, see key-encoding.go
+}
+
+func (k *PrivKey) signer() Signer {
+	if k.Ed != nil {
+		return ed25519Signer{k.Ed}
+	}
+	if k.Bls != nil {
+		return blsSigner{k.Bls}
+	}
+	return ecdsaSigner{k.Priv}
+}
+
+func (k *PubKey) verifier() Verifier {
+	if k.Ed != nil {
+		return ed25519Verifier{k.Ed}
+	}
+	if k.Bls != nil {
+		return blsVerifier{k.Bls}
+	}
+	return ecdsaVerifier{k}
+}
+
+type ecdsaSigner struct {
+	priv *ecdsa.PrivateKey
+}
+
+func (s ecdsaSigner) sign(hashedMsg [32]byte) *Sig {
+	random := io.Reader(rand.Reader)
+	if simNet != nil {
+		random = zeroReader{}
+	}
+	r, ss, err := ecdsa.Sign(random, s.priv, hashedMsg[:])
+	ifErrFatal(err, "ecdsa sign")
+	return &Sig{R: r, S: ss}
+}
+
+// the nonce of a signature of a simulation comes from the key and the msg alone, like with rfc 6979,
+// so a replay signs the same
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+type ecdsaVerifier struct {
+	pub *PubKey
+}
+
+func (v ecdsaVerifier) verify(hashedMsg [32]byte, sig *Sig) bool {
+	if sig.R == nil || sig.S == nil {
+		return false
+	}
+	return ecdsa.Verify(v.pub.Pub, hashedMsg[:], sig.R, sig.S)
+}
+
+func (v ecdsaVerifier) keyBytes() []byte {
+	b := v.pub.xyBytes()
+	return b[:]
+}
+
+type ed25519Signer struct {
+	priv ed25519.PrivateKey
+}
+
+func (s ed25519Signer) sign(hashedMsg [32]byte) *Sig {
+	return &Sig{Raw: ed25519.Sign(s.priv, hashedMsg[:])}
+}
+
+type ed25519Verifier struct {
+	pub ed25519.PublicKey
+}
+
+func (v ed25519Verifier) verify(hashedMsg [32]byte, sig *Sig) bool {
+	return len(sig.Raw) == ed25519.SignatureSize && ed25519.Verify(v.pub, hashedMsg[:], sig.Raw)
+}
+
+func (v ed25519Verifier) keyBytes() []byte {
+	return v.pub
+}
+
+type blsSigner struct {
+	sk *big.Int
+}
+
+func (s blsSigner) sign(hashedMsg [32]byte) *Sig {
+	return &Sig{Raw: blsSign(s.sk, hashedMsg[:])}
+}
+
+type blsVerifier struct {
+	pk []byte
+}
+
+func (v blsVerifier) verify(hashedMsg [32]byte, sig *Sig) bool {
+	return blsVerify(v.pk, hashedMsg[:], sig.Raw)
+}
+
+func (v blsVerifier) keyBytes() []byte {
+	return v.pk
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the scheduler of simulate, the goroutines run one at a time on a virtual clock
+
+// a goroutine of the simulation, it runs when it gets the turn on run
+type simTask struct {
+	run     chan struct{}
+	wait    uint64 // the waits so far
+	waiting bool
+}
+
+// wakes the task from the wait it was in when it was taken, not from a later one
+type simWaiter struct {
+	t    *simTask
+	wait uint64
+}
+
+type simTimer struct {
+	due       time.Time
+	key       uint64
+	fire      func() // runs in the scheduler, it must not wait
+	cancelled bool
+}
+
+type simTimers []*simTimer
+
+func (q simTimers) Len() int { return len(q) }
+func (q simTimers) Less(i, j int) bool {
+	if !q[i].due.Equal(q[j].due) {
+		return q[i].due.Before(q[j].due)
+	}
+	return q[i].key < q[j].key
+}
+func (q simTimers) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *simTimers) Push(x interface{}) { *q = append(*q, x.(*simTimer)) }
+func (q *simTimers) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return t
+}
+
+// the clock of every simulation starts at the same time, the blocks and txs of a replay are the same
+var simEpoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// the scheduler of the goroutines, timers and connections of a simulation. mux guards everything
+// of the simulated network, the goroutine with the turn takes it for every step
+type SimScheduler struct {
+	seed    int64
+	dials   map[int]uint64 // connections to every port so far
+	now     time.Time
+	timers  simTimers
+	ready   []*simTask
+	current *simTask
+	keys    uint64 // the keys of the timers that are not connections
+	turns   uint64
+	rand    *seededSource // nodeRand
+	trace   [32]byte      // the hash of the connections in the order they were delivered
+	halting bool
+	halted  chan struct{}
+	mux     sync.Mutex
+}
+
+func (s *SimScheduler) init(seed int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.seed = seed
+	s.dials = make(map[int]uint64)
+	s.now = simEpoch
+	h := hash(byteSliceAppend([]byte("simulate rand"), getBytes(seed)))
+	s.rand = newSeededSource(int64(binary.LittleEndian.Uint64(h[:8])))
+	s.halted = make(chan struct{})
+}
+
+func (s *SimScheduler) clockNow() time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.now
+}
+
+// the key of the next connection to port, it picks the jitter and the order at the same time
+func (s *SimScheduler) _key(port int) uint64 {
+	b := make([]byte, 24)
+	binary.LittleEndian.PutUint64(b, uint64(s.seed))
+	binary.LittleEndian.PutUint64(b[8:], uint64(port))
+	binary.LittleEndian.PutUint64(b[16:], s.dials[port])
+	s.dials[port]++
+	h := hash(byteSliceAppend([]byte("simulate"), b))
+	return binary.LittleEndian.Uint64(h[:8])
+}
+
+func (s *SimScheduler) _timer(due time.Time, key uint64, fire func()) *simTimer {
+	t := &simTimer{due: due, key: key, fire: fire}
+	heap.Push(&s.timers, t)
+	return t
+}
+
+// a timer that fires after the timers due at the same time that were set before it
+func (s *SimScheduler) _at(due time.Time, fire func()) *simTimer {
+	s.keys++
+	return s._timer(due, s.keys, fire)
+}
+
+func (s *SimScheduler) _after(d time.Duration, fire func()) *simTimer {
+	return s._at(s.now.Add(d), fire)
+}
+
+// a goroutine that runs when it gets the turn
+func (s *SimScheduler) spawn(f func()) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s._spawn(f)
+}
+
+func (s *SimScheduler) _spawn(f func()) {
+	t := &simTask{run: make(chan struct{}, 1)}
+	s.ready = append(s.ready, t)
+	go func() {
+		defer recordPanic()
+		<-t.run
+		f()
+		s.mux.Lock()
+		s._next()
+		s.mux.Unlock()
+	}()
+}
+
+// the wait the goroutine with the turn goes into, to hand to what wakes it
+func (s *SimScheduler) _waiter() simWaiter {
+	t := s.current
+	t.wait++
+	t.waiting = true
+	return simWaiter{t, t.wait}
+}
+
+func (s *SimScheduler) _wake(w simWaiter) {
+	if w.t.waiting && w.t.wait == w.wait {
+		w.t.waiting = false
+		s.ready = append(s.ready, w.t)
+	}
+}
+
+func (s *SimScheduler) _wakeAll(ws *[]simWaiter) {
+	for _, w := range *ws {
+		s._wake(w)
+	}
+	*ws = nil
+}
+
+// the goroutine with the turn waits until it is woken, the next one gets the turn
+func (s *SimScheduler) _park() {
+	t := s.current
+	s._next()
+	s.mux.Unlock()
+	<-t.run
+	s.mux.Lock()
+}
+
+func (s *SimScheduler) sleep(d time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	w := s._waiter()
+	s._after(d, func() { s._wake(w) })
+	s._park()
+}
+
+// f runs as a goroutine after d
+func (s *SimScheduler) afterFunc(d time.Duration, f func()) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s._after(d, func() { s._spawn(f) })
+}
+
+// gives the turn to the next goroutine that can run, the clock goes on to the next timer when none
+// can. Stops the simulation when it is halted or nothing is left to run
+func (s *SimScheduler) _next() {
+	for len(s.ready) == 0 && !s.halting {
+		if len(s.timers) == 0 {
+			coordinatorLog.errorf(nil, "[Simulate] every goroutine waits and no timer is left, stopping")
+			s._halt()
+			return
+		}
+		t := heap.Pop(&s.timers).(*simTimer)
+		if t.cancelled {
+			continue
+		}
+		if t.due.After(s.now) {
+			s.now = t.due
+		}
+		t.fire()
+	}
+	if s.halting {
+		s._halt()
+		return
+	}
+	s.current = s.ready[0]
+	s.ready = s.ready[1:]
+	s.turns++
+	s.current.run <- struct{}{}
+}
+
+func (s *SimScheduler) _halt() {
+	s.current = nil
+	select {
+	case <-s.halted:
+	default:
+		close(s.halted)
+		goRecorded(func() { stopSimulation() })
+	}
+}
+
+// the turns so far and the digest of the connections and when they were delivered, a replay has
+// the same
+func (s *SimScheduler) digest() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return fmt.Sprintf("%d turns, digest %x", s.turns, s.trace[:8])
+}
+
+// starts the goroutines that were spawned so far
+func (s *SimScheduler) start() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	goRecorded(func() { s.watch() })
+	s._next()
+}
+
+// stops the simulation once the goroutine with the turn waits, the results are exported then
+func (s *SimScheduler) halt() {
+	s.mux.Lock()
+	s.halting = true
+	s.mux.Unlock()
+	select {
+	case <-s.halted:
+	case <-time.After(default_simStall * time.Second):
+		coordinatorLog.errorf(nil, "[Simulate] the goroutine with the turn did not wait for %d seconds, exporting anyway", default_simStall)
+	}
+}
+
+// stops the simulation at d on the clock
+func (s *SimScheduler) haltAfter(d time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s._after(d, func() { s.halting = true })
+}
+
+// logs the goroutines when the turn did not change for default_simStall seconds
+func (s *SimScheduler) watch() {
+	var turns uint64
+	for {
+		select {
+		case <-s.halted:
+			return
+		case <-time.After(default_simStall * time.Second):
+		}
+		s.mux.Lock()
+		stalled := s.turns == turns
+		turns = s.turns
+		now := s.now
+		s.mux.Unlock()
+		if stalled {
+			coordinatorLog.errorf(nil, "[Simulate] no goroutine got the turn for %d seconds at %s, one waits outside of the scheduler or computes:", default_simStall, now.Format(time.StampMilli))
+			pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
+		}
+	}
+}
+
+// the end of a simulation, like a stopped coordinator
+func stopSimulation() {
+	p, err := os.FindProcess(os.Getpid())
+	if !ifErr(err, "simulate stop") {
+		p.Signal(os.Interrupt)
+	}
+}
+
+// queues conn to port after latency plus its jitter and retransmissions and waits until the
+// listener has it, errSimRefused if there is none
+func (s *SimScheduler) connect(sn *SimNetwork, port int, conn *simConn, latency, jitter time.Duration, loss float64) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	key := s._key(port)
+	due := s.now.Add(latency)
+	if jitter > 0 {
+		due = due.Add(time.Duration(key % uint64(jitter)))
+	}
+	due = due.Add(simRetransmits(key, loss))
+	w := s._waiter()
+	var err error
+	s._timer(due, key, func() {
+		err = sn._deliver(port, conn)
+		b := make([]byte, 24)
+		binary.LittleEndian.PutUint64(b, uint64(s.now.Sub(simEpoch)))
+		binary.LittleEndian.PutUint64(b[8:], uint64(port))
+		binary.LittleEndian.PutUint64(b[16:], key)
+		s.trace = hash(byteSliceAppend(s.trace[:], b))
+		s._wake(w)
+	})
+	s._park()
+	return err
+}
+
+// logs a safety violation, in a simulation with the flags that replay it
+func safetyViolation(description string) {
+	coordinatorLog.errorf(nil, "[Safety] %s", description)
+	exitStatus.violation(description)
+	if simNet == nil {
+		return
+	}
+	args := []string{"rapidchain simulate"}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "function" && f.Name != "runSeed" {
+			args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
+		}
+	})
+	args = append(args, fmt.Sprintf("-runSeed=%d", simNet.scheduler.seed))
+	coordinatorLog.errorf(nil, "[Simulate] replay with seed %d: %s", simNet.scheduler.seed, strings.Join(args, " "))
+}
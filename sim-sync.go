@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// a channel between goroutines of the protocol, in a simulation the scheduler sees them wait on it
+type Queue struct {
+	ch        chan interface{}
+	sch       *SimScheduler
+	buf       []interface{}
+	size      int
+	senders   []simWaiter
+	receivers []simWaiter
+}
+
+// a queue of size items, at least one
+func newQueue(size int) *Queue {
+	if size < 1 {
+		size = 1
+	}
+	if simNet != nil {
+		return &Queue{sch: &simNet.scheduler, size: size}
+	}
+	return &Queue{ch: make(chan interface{}, size)}
+}
+
+// waits while the queue is full
+func (q *Queue) send(v interface{}) {
+	if q.sch == nil {
+		q.ch <- v
+		return
+	}
+	q.sch.mux.Lock()
+	defer q.sch.mux.Unlock()
+	for len(q.buf) >= q.size {
+		q.senders = append(q.senders, q.sch._waiter())
+		q.sch._park()
+	}
+	q.buf = append(q.buf, v)
+	q.sch._wakeAll(&q.receivers)
+}
+
+// false if the queue is full
+func (q *Queue) trySend(v interface{}) bool {
+	if q.sch == nil {
+		select {
+		case q.ch <- v:
+			return true
+		default:
+			return false
+		}
+	}
+	q.sch.mux.Lock()
+	defer q.sch.mux.Unlock()
+	if len(q.buf) >= q.size {
+		return false
+	}
+	q.buf = append(q.buf, v)
+	q.sch._wakeAll(&q.receivers)
+	return true
+}
+
+func (q *Queue) _take() interface{} {
+	v := q.buf[0]
+	q.buf = q.buf[1:]
+	q.sch._wakeAll(&q.senders)
+	return v
+}
+
+// waits for an item
+func (q *Queue) recv() interface{} {
+	if q.sch == nil {
+		return <-q.ch
+	}
+	q.sch.mux.Lock()
+	defer q.sch.mux.Unlock()
+	for len(q.buf) == 0 {
+		q.receivers = append(q.receivers, q.sch._waiter())
+		q.sch._park()
+	}
+	return q._take()
+}
+
+// waits for an item at most d, false if none came
+func (q *Queue) recvTimeout(d time.Duration) (interface{}, bool) {
+	if q.sch == nil {
+		select {
+		case v := <-q.ch:
+			return v, true
+		case <-time.After(d):
+			return nil, false
+		}
+	}
+	q.sch.mux.Lock()
+	defer q.sch.mux.Unlock()
+	deadline := q.sch.now.Add(d)
+	for len(q.buf) == 0 {
+		if !q.sch.now.Before(deadline) {
+			return nil, false
+		}
+		w := q.sch._waiter()
+		q.receivers = append(q.receivers, w)
+		t := q.sch._at(deadline, func() { q.sch._wake(w) })
+		q.sch._park()
+		t.cancelled = true
+	}
+	return q._take(), true
+}
+
+// the items in the queue
+func (q *Queue) len() int {
+	if q.sch == nil {
+		return len(q.ch)
+	}
+	q.sch.mux.Lock()
+	defer q.sch.mux.Unlock()
+	return len(q.buf)
+}
+
+// a sync.WaitGroup, in a simulation the scheduler sees the goroutines wait on it
+type WaitGroup struct {
+	wg      sync.WaitGroup
+	n       int
+	waiters []simWaiter
+}
+
+func (wg *WaitGroup) Add(n int) {
+	if simNet == nil {
+		wg.wg.Add(n)
+		return
+	}
+	sch := &simNet.scheduler
+	sch.mux.Lock()
+	defer sch.mux.Unlock()
+	wg.n += n
+	if wg.n < 0 {
+		panic("negative WaitGroup counter")
+	}
+	if wg.n == 0 {
+		sch._wakeAll(&wg.waiters)
+	}
+}
+
+func (wg *WaitGroup) Done() {
+	wg.Add(-1)
+}
+
+func (wg *WaitGroup) Wait() {
+	if simNet == nil {
+		wg.wg.Wait()
+		return
+	}
+	sch := &simNet.scheduler
+	sch.mux.Lock()
+	defer sch.mux.Unlock()
+	for wg.n > 0 {
+		wg.waiters = append(wg.waiters, sch._waiter())
+		sch._park()
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// simulate runs the coordinator and the nodes in this process on a simulated network
+
+var errSimRefused = errors.New("simulated connection refused")
+
+// one direction of a simulated connection
+type simStream struct {
+	buf      []byte
+	closed   bool
+	deadline time.Time
+	timer    *simTimer // wakes the readers at the deadline
+	readers  []simWaiter
+	sch      *SimScheduler
+}
+
+func newSimStream(sch *SimScheduler) *simStream {
+	return &simStream{sch: sch}
+}
+
+func (s *simStream) write(b []byte) (int, error) {
+	s.sch.mux.Lock()
+	defer s.sch.mux.Unlock()
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	s.buf = append(s.buf, b...)
+	s.sch._wakeAll(&s.readers)
+	return len(b), nil
+}
+
+// waits for bytes until the stream is closed or the deadline passed
+func (s *simStream) read(b []byte) (int, error) {
+	s.sch.mux.Lock()
+	defer s.sch.mux.Unlock()
+	for len(s.buf) == 0 {
+		if s.closed {
+			return 0, io.EOF
+		}
+		if !s.deadline.IsZero() && !s.sch.now.Before(s.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		s.readers = append(s.readers, s.sch._waiter())
+		s.sch._park()
+	}
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *simStream) close() {
+	s.sch.mux.Lock()
+	defer s.sch.mux.Unlock()
+	s.closed = true
+	s.sch._wakeAll(&s.readers)
+}
+
+func (s *simStream) setDeadline(t time.Time) {
+	s.sch.mux.Lock()
+	defer s.sch.mux.Unlock()
+	s.deadline = t
+	if s.timer != nil {
+		s.timer.cancelled = true
+		s.timer = nil
+	}
+	if !t.IsZero() {
+		s.timer = s.sch._at(t, func() { s.sch._wakeAll(&s.readers) })
+	}
+}
+
+type simConn struct {
+	in        *simStream
+	out       *simStream
+	local     net.Addr
+	remote    net.Addr
+	bandwidth uint
+}
+
+func (c *simConn) Read(b []byte) (int, error) {
+	return c.in.read(b)
+}
+
+func (c *simConn) Write(b []byte) (int, error) {
+	if c.bandwidth != 0 {
+		clockSleep(time.Duration(len(b)) * time.Second / time.Duration(c.bandwidth))
+	}
+	return c.out.write(b)
+}
+
+// the peer reads what was written before it gets EOF, its writes fail
+func (c *simConn) Close() error {
+	c.out.close()
+	c.in.close()
+	return nil
+}
+
+func (c *simConn) LocalAddr() net.Addr  { return c.local }
+func (c *simConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *simConn) SetDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+func (c *simConn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+func (c *simConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type simListener struct {
+	addr    *net.TCPAddr
+	backlog []net.Conn
+	closed  bool
+	accepts []simWaiter
+	sn      *SimNetwork
+}
+
+func (l *simListener) Accept() (net.Conn, error) {
+	sch := &l.sn.scheduler
+	sch.mux.Lock()
+	defer sch.mux.Unlock()
+	for len(l.backlog) == 0 {
+		if l.closed {
+			return nil, net.ErrClosed
+		}
+		l.accepts = append(l.accepts, sch._waiter())
+		sch._park()
+	}
+	conn := l.backlog[0]
+	l.backlog = l.backlog[1:]
+	return conn, nil
+}
+
+func (l *simListener) Close() error {
+	sch := &l.sn.scheduler
+	sch.mux.Lock()
+	defer sch.mux.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	sch._wakeAll(&l.accepts)
+	if l.sn.listeners[l.addr.Port] == l {
+		delete(l.sn.listeners, l.addr.Port)
+	}
+	return nil
+}
+
+func (l *simListener) Addr() net.Addr {
+	return l.addr
+}
+
+// the listeners of the process by port, all nodes are on one host. The listeners are guarded by
+// the mux of the scheduler
+type SimNetwork struct {
+	listeners map[int]*simListener
+	latency   time.Duration
+	jitter    time.Duration
+	bandwidth uint
+	loss      float64
+	scheduler SimScheduler
+}
+
+func (sn *SimNetwork) init(flagArgs *FlagArgs) {
+	sn.scheduler.init(flagArgs.runSeed)
+	sn.listeners = make(map[int]*simListener)
+	sn.latency = time.Duration(flagArgs.simLatency) * time.Millisecond
+	sn.jitter = time.Duration(flagArgs.simJitter) * time.Millisecond
+	sn.bandwidth = flagArgs.simBandwidth
+	sn.loss = flagArgs.simLoss
+}
+
+// the port of the first node of a simulation without -ports
+const simPortsBegin = 9000
+
+func simPort(addr string) (int, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(port)
+}
+
+func (sn *SimNetwork) listen(addr string) (net.Listener, error) {
+	port, err := simPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	sn.scheduler.mux.Lock()
+	defer sn.scheduler.mux.Unlock()
+	if _, ok := sn.listeners[port]; ok {
+		return nil, errors.New("simulated address in use " + addr)
+	}
+	l := &simListener{addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}, sn: sn}
+	sn.listeners[port] = l
+	return l, nil
+}
+
+func (sn *SimNetwork) listening(port int) bool {
+	sn.scheduler.mux.Lock()
+	defer sn.scheduler.mux.Unlock()
+	_, ok := sn.listeners[port]
+	return ok
+}
+
+// the listener of the port gets the connection, or the dial is refused
+func (sn *SimNetwork) _deliver(port int, conn *simConn) error {
+	l, ok := sn.listeners[port]
+	if !ok {
+		return errSimRefused
+	}
+	l.backlog = append(l.backlog, conn)
+	sn.scheduler._wakeAll(&l.accepts)
+	return nil
+}
+
+// the connection arrives at the listener after the latency of the link
+func (sn *SimNetwork) dial(addr string) (net.Conn, error) {
+	port, err := simPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	up, down := newSimStream(&sn.scheduler), newSimStream(&sn.scheduler)
+	client := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	server := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	if err := sn.scheduler.connect(sn, port, &simConn{down, up, server, client, sn.bandwidth}, sn.latency, sn.jitter, sn.loss); err != nil {
+		return nil, err
+	}
+	return &simConn{up, down, client, server, sn.bandwidth}, nil
+}
+
+func simulate(flagArgs *FlagArgs) {
+	// the seed is known before the coordinator starts, the nodes derive their keys from it
+	if flagArgs.runSeed == 0 {
+		flagArgs.runSeed = randomInt64(protocolRand)
+	}
+	flagArgs.deriveKeys = true
+	simNet = new(SimNetwork)
+	simNet.init(flagArgs)
+	coord = coord_local
+	flagArgs.instances = flagArgs.n
+	if flagArgs.portsBegin == 0 {
+		flagArgs.portsBegin = simPortsBegin
+	}
+	coordinatorLog.infof(nil, "[Simulate] %d nodes in this process, latency %dms jitter %dms bandwidth %d B/s loss %g, run seed %d", flagArgs.n, flagArgs.simLatency, flagArgs.simJitter, flagArgs.simBandwidth, flagArgs.simLoss, flagArgs.runSeed)
+	spawn(func() { launchCoordinator(flagArgs) })
+	spawn(func() {
+		for !simNet.listening(8080) {
+			clockSleep(10 * time.Millisecond)
+		}
+		launchNodes(flagArgs)
+	})
+	if flagArgs.simTime != 0 {
+		simNet.scheduler.haltAfter(time.Duration(flagArgs.simTime) * time.Second)
+	}
+	start, begin := time.Now(), clockNow()
+	simNet.scheduler.start()
+	<-simNet.scheduler.halted
+	coordinatorLog.infof(nil, "[Simulate] stopped after %s of the simulation in %s, %s", clockSince(begin).Round(time.Millisecond), time.Since(start).Round(time.Millisecond), simNet.scheduler.digest())
+	// the coordinator exports and exits
+	select {}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the standby coordinator that mirrors the run and takes over the stats
+
+// what the coordinator sends the standby, a heartbeat is empty
+type StandbyMsg struct {
+	Setup *StandbySetup
+	From  string // the address of the connection of Msg
+	Msg   *Msg
+	End   bool // the coordinator was stopped
+}
+
+type StandbySetup struct {
+	Challenge PowChallenge
+	RunSeed   int64
+	Response  ResponseToNodes // with the genesis blocks also with -genesisGossip
+	Host      string          // the host of the standby for the nodes
+}
+
+// the mirror of the coordinator, nil without -standby
+var standbyMirror *StandbyMirror
+
+type StandbyMirror struct {
+	addr    string
+	queue   chan StandbyMsg
+	ended   chan struct{}
+	dropped uint64
+}
+
+func startStandbyMirror(flagArgs *FlagArgs) {
+	if flagArgs.standby == "" {
+		return
+	}
+	addr := net.JoinHostPort(flagArgs.standby, strconv.Itoa(default_standbyPort))
+	standbyMirror = &StandbyMirror{addr: addr, queue: make(chan StandbyMsg, default_standbyQueue), ended: make(chan struct{})}
+	goRecorded(func() { standbyMirror.run(addr) })
+}
+
+func (m *StandbyMirror) run(addr string) {
+	defer close(m.ended)
+	conn, err := dialWithRetry(addr)
+	if ifErr(err, "standby "+addr) {
+		coordinatorLog.errorf(nil, "[Standby] no standby at %s, the run is not mirrored", addr)
+		return
+	}
+	defer conn.Close()
+	coordinatorLog.infof(nil, "[Standby] mirroring to %s", addr)
+	enc := gob.NewEncoder(conn)
+	heartbeat := time.NewTicker(time.Second)
+	defer heartbeat.Stop()
+	for {
+		var msg StandbyMsg
+		select {
+		case msg = <-m.queue:
+		case <-heartbeat.C:
+		}
+		if err := enc.Encode(msg); err != nil {
+			coordinatorLog.errorf(nil, "[Standby] lost the standby at %s, the run is not mirrored any more: %v", addr, err)
+			return
+		}
+		if msg.End {
+			coordinatorLog.infof(nil, "[Standby] stopped the standby, %d stats were not mirrored", atomic.LoadUint64(&m.dropped))
+			return
+		}
+	}
+}
+
+func (m *StandbyMirror) send(msg StandbyMsg) {
+	select {
+	case m.queue <- msg:
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+	}
+}
+
+// the setup of the nodes, once they registered
+func (m *StandbyMirror) setup(flagArgs *FlagArgs, challenge PowChallenge, response ResponseToNodes) {
+	if m == nil {
+		return
+	}
+	m.send(StandbyMsg{Setup: &StandbySetup{challenge, flagArgs.runSeed, response, flagArgs.standby}})
+}
+
+// a stat of the connection conn
+func (m *StandbyMirror) stat(conn net.Conn, msg *Msg) {
+	if m == nil {
+		return
+	}
+	m.send(StandbyMsg{From: conn.RemoteAddr().String(), Msg: msg})
+}
+
+// stops the standby when the coordinator is stopped, waits for it a while
+func (m *StandbyMirror) end() {
+	if m == nil {
+		return
+	}
+	// the end is not dropped, the queue is emptied while it waits
+	go func() { m.queue <- StandbyMsg{End: true} }()
+	select {
+	case <-m.ended:
+	case <-time.After(default_standbyTimeout * time.Second):
+	}
+}
+
+// a connection of a mirrored stat, answers go nowhere
+type mirroredConn struct {
+	net.Conn
+	from mirroredAddr
+}
+
+type mirroredAddr string
+
+func (a mirroredAddr) Network() string { return "tcp" }
+func (a mirroredAddr) String() string  { return string(a) }
+
+func (c *mirroredConn) RemoteAddr() net.Addr { return c.from }
+
+func newMirroredConn(from string) *mirroredConn {
+	conn, peer := net.Pipe()
+	go func() {
+		defer recordPanic()
+		io.Copy(ioutil.Discard, peer)
+		peer.Close()
+	}()
+	return &mirroredConn{conn, mirroredAddr(from)}
+}
+
+// the connection of the coordinator, the ones without the token of -statsSecret are dropped
+func acceptCoordinator(listener net.Listener) net.Conn {
+	for {
+		conn, err := listener.Accept()
+		ifErrFatal(err, "standby accept")
+		if statsAuth.admit(conn) {
+			coordinatorLog.infof(nil, "[Standby] mirroring the coordinator at %s", conn.RemoteAddr())
+			return conn
+		}
+		conn.Close()
+	}
+}
+
+// the standby takes the setup of the coordinator
+func (ms *Membership) follow(setup *StandbySetup) {
+	ms.mux.Lock()
+	ms.challenge = setup.Challenge
+	ms.runSeed = setup.RunSeed
+	ms.mirroring = true
+	ms.mux.Unlock()
+	r := setup.Response
+	ms.set(r.Nodes, r.ReconfigurationBlock, r.GenesisHashes)
+}
+
+// the standby announces to the nodes once it took over
+func (ms *Membership) takeOver() []NodeAllInfo {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	ms.mirroring = false
+	nodes := make([]NodeAllInfo, 0, len(ms.nodes))
+	for _, n := range ms.nodes {
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// mirrors the coordinator until it dies and returns the listener of the stats then. setup is the
+// setup of the coordinator, handle handles a stat
+func followCoordinator(membership *Membership, setup func(*StandbySetup), handle func(net.Conn, *Msg)) net.Listener {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(default_standbyPort))
+	ifErrFatal(err, "standby listen")
+	coordinatorLog.infof(nil, "[Standby] waiting for the coordinator on port %d", default_standbyPort)
+	conn := acceptCoordinator(listener)
+	dec := gob.NewDecoder(conn)
+	var host string
+	var handled sync.WaitGroup
+	for {
+		// the setup takes as long as the nodes take to register
+		if host != "" {
+			conn.SetReadDeadline(time.Now().Add(default_standbyTimeout * time.Second))
+		}
+		var msg StandbyMsg
+		err := dec.Decode(&msg)
+		if err != nil {
+			// not a coordinator, or one that ended before its setup
+			if host == "" {
+				coordinatorLog.warnf(nil, "[Standby] dropped %s before the setup: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				conn = acceptCoordinator(listener)
+				dec = gob.NewDecoder(conn)
+				continue
+			}
+			coordinatorLog.errorf(nil, "[Standby] lost the coordinator, taking over: %v", err)
+			break
+		}
+		if msg.End {
+			coordinatorLog.infof(nil, "[Standby] the coordinator was stopped, stopping too")
+			handled.Wait()
+			p, err := os.FindProcess(os.Getpid())
+			if !ifErr(err, "standby stop") {
+				p.Signal(os.Interrupt)
+			}
+			select {}
+		}
+		if msg.Setup != nil {
+			listener.Close()
+			host = msg.Setup.Host
+			setup(msg.Setup)
+			coordinatorLog.infof(nil, "[Standby] got the setup of %d nodes", len(msg.Setup.Response.Nodes))
+		}
+		if msg.Msg != nil {
+			handled.Add(1)
+			go func(msg StandbyMsg) {
+				defer recordPanic()
+				defer handled.Done()
+				c := newMirroredConn(msg.From)
+				defer c.Close()
+				handle(c, msg.Msg)
+			}(msg)
+		}
+	}
+	conn.Close()
+
+	// the port of the coordinator is free once it died on this machine
+	for {
+		listener, err = netListen(":8080")
+		if err == nil {
+			break
+		}
+		coordinatorLog.warnf(nil, "[Standby] port 8080 is not free yet: %v", err)
+		time.Sleep(time.Second)
+	}
+	nodes := membership.takeOver()
+	for _, n := range nodes {
+		n := n
+		goRecorded(func() { dialAndSend(n.IP, withControlToken(n.Pub, Msg{"coordinator_takeover", host, nil})) })
+	}
+	coordinatorLog.warnf(nil, "[Standby] took over the stats of %d nodes, they send to %s now", len(nodes), host)
+	return listener
+}
+
+// the coordinator the nodes send to, the standby once it took over
+var coordinatorOverride struct {
+	host string
+	mux  sync.Mutex
+}
+
+func coordinatorAddr() string {
+	coordinatorOverride.mux.Lock()
+	defer coordinatorOverride.mux.Unlock()
+	if coordinatorOverride.host != "" {
+		return coordinatorOverride.host + ":8080"
+	}
+	return coord + ":8080"
+}
+
+// the stats of the node go to host from now on, if it is the standby of -standby
+func handleCoordinatorTakeover(nodeCtx *NodeCtx, host string) {
+	if host == "" || host != nodeCtx.flagArgs.standby {
+		nodeLog.warnf(nodeCtx, "[Standby] dropped a takeover by %q, the standby of -standby is %q", host, nodeCtx.flagArgs.standby)
+		return
+	}
+	coordinatorOverride.mux.Lock()
+	changed := coordinatorOverride.host != host
+	coordinatorOverride.host = host
+	coordinatorOverride.mux.Unlock()
+	if changed {
+		nodeLog.warnf(nodeCtx, "[Standby] the standby at %s took over the coordinator", host)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// state sync of a member that joins late or recovers
+
+type SnapshotUTXO struct {
+	TxID [32]byte
+	Out  *OutTx
+}
+
+type StateSnapshot struct {
+	Head      *FinalBlock // the state is the UTXO set after processing this block
+	UTXOs     []SnapshotUTXO
+	StateHash [32]byte
+	Originals []*Transaction // cross-txes waiting for responses, not covered by the state hash
+}
+
+// answer to request_state_hash
+type StateHashAnswer struct {
+	GossipHash [32]byte // head of the peer
+	StateHash  [32]byte
+}
+
+// hash of the UTXO set, sorted by TxID and N so all members get the same hash
+func utxosHash(utxos []SnapshotUTXO) [32]byte {
+	sort.Slice(utxos, func(i, j int) bool {
+		c := bytes.Compare(utxos[i].TxID[:], utxos[j].TxID[:])
+		if c != 0 {
+			return c < 0
+		}
+		return utxos[i].Out.N < utxos[j].Out.N
+	})
+	buf := getBuffer()
+	defer putBuffer(buf)
+	for _, u := range utxos {
+		buf.Write(u.TxID[:])
+		u.Out.writeBytes(buf)
+	}
+	return hash(buf.Bytes())
+}
+
+func (s *UTXOSet) _snapshot() []SnapshotUTXO {
+	utxos := []SnapshotUTXO{}
+	for txID, outs := range s.set {
+		for _, out := range outs {
+			utxos = append(utxos, SnapshotUTXO{txID, out})
+		}
+	}
+	return utxos
+}
+
+// snapshot of the current state, the blockchain is locked so no block is added while copying
+func createSnapshot(nodeCtx *NodeCtx) *StateSnapshot {
+	nodeCtx.blockchain.mux.Lock()
+	defer nodeCtx.blockchain.mux.Unlock()
+	nodeCtx.utxoSet.mux.Lock()
+	defer nodeCtx.utxoSet.mux.Unlock()
+
+	snapshot := new(StateSnapshot)
+	snapshot.Head = nodeCtx.blockchain._getLatest()
+	snapshot.UTXOs = nodeCtx.utxoSet._snapshot()
+	snapshot.StateHash = utxosHash(snapshot.UTXOs)
+	nodeCtx.crossTxPool.mux.Lock()
+	for _, t := range nodeCtx.crossTxPool.original {
+		snapshot.Originals = append(snapshot.Originals, t)
+	}
+	nodeCtx.crossTxPool.mux.Unlock()
+	return snapshot
+}
+
+func stateHash(nodeCtx *NodeCtx) StateHashAnswer {
+	nodeCtx.blockchain.mux.Lock()
+	defer nodeCtx.blockchain.mux.Unlock()
+	nodeCtx.utxoSet.mux.Lock()
+	defer nodeCtx.utxoSet.mux.Unlock()
+	head := nodeCtx.blockchain._getLatest()
+	if head == nil {
+		return StateHashAnswer{}
+	}
+	return StateHashAnswer{head.ProposedBlock.GossipHash, utxosHash(nodeCtx.utxoSet._snapshot())}
+}
+
+// Returns "" if the final block has enough valid accept signatures from members of this committee,
+// otherwise the reason. The genesis block has no signatures, its hashes are checked only.
+func verifyCertificate(nodeCtx *NodeCtx, b *FinalBlock) string {
+	block := b.ProposedBlock
+	if block == nil {
+		return "no block"
+	}
+	if block.CommitteeID != nodeCtx.committeeID() {
+		return "committee"
+	}
+	if block.Iteration == 0 && block.PreviousGossipHash == [32]byte{} {
+		// the genesis block hash is set by the coordinator
+		if block.GossipHash != nodeCtx.genesisHash {
+			return "genesis hash"
+		}
+		return ""
+	}
+	if block.GossipHash != block.calculateHash() {
+		return "block hash"
+	}
+	// the committee of the epoch the block is in, which does not have to be the current one
+	rb := nodeCtx.blockchain.reconfigurationBlockAt(block.Iteration)
+	members := rb.membersOf(block.CommitteeID)
+	if len(members) == 0 {
+		return "no committee for iteration"
+	}
+	requiredVotes := rb.Weights.blockQuorum(members, nodeCtx.flagArgs.committeeF)
+	return signaturesError(b, members, rb.Weights, requiredVotes, nodeCtx.flagArgs.vCPUs)
+}
+
+// returns "" if the block has valid accept signatures from members with requiredVotes weight,
+// otherwise the reason
+func signaturesError(b *FinalBlock, members map[[32]byte]bool, w Weights, requiredVotes int, workers uint) string {
+	if len(b.Signatures) == 0 && b.Certificate != nil {
+		return b.Certificate.verifyError(b.ProposedBlock.GossipHash, members, w, requiredVotes)
+	}
+	votes := []*ConsensusMsg{}
+	for _, cMsg := range b.Signatures {
+		if cMsg.Tag != "accept" || cMsg.GossipHash != b.ProposedBlock.GossipHash {
+			continue
+		}
+		if !members[cMsg.Pub.Bytes] {
+			continue
+		}
+		votes = append(votes, cMsg)
+	}
+	if ok, _ := VerifyBatch(consensusMsgItems(votes), workers, true); !ok {
+		return "signature"
+	}
+	signers := make(map[[32]byte]bool)
+	for _, cMsg := range votes {
+		signers[cMsg.Pub.Bytes] = true
+	}
+	if w.ofSet(signers) < requiredVotes {
+		return fmt.Sprintf("%d of %d signatures", w.ofSet(signers), requiredVotes)
+	}
+	return ""
+}
+
+// returns "" if the snapshot is valid and enough of peers agree with it, otherwise the reason
+func verifySnapshot(nodeCtx *NodeCtx, snapshot *StateSnapshot, peers []*CommitteeMember) string {
+	if snapshot.Head == nil {
+		return "no head"
+	}
+	if reason := verifyCertificate(nodeCtx, snapshot.Head); reason != "" {
+		return "certificate: " + reason
+	}
+	if utxosHash(snapshot.UTXOs) != snapshot.StateHash {
+		return "state hash"
+	}
+
+	// the certificate only covers the block, so the state is checked against other members.
+	// At least a committee adversary fraction of the peers must agree so one of them is honest
+	required := len(peers) / int(nodeCtx.flagArgs.committeeF)
+	agree := 0
+	for _, cm := range peers {
+		if agree >= required {
+			break
+		}
+		answer := new(StateHashAnswer)
+		if !requestFrom(cm.IP, Msg{"request_state_hash", "", nodeCtx.self.Priv.Pub}, answer) {
+			continue
+		}
+		if answer.GossipHash != snapshot.Head.ProposedBlock.GossipHash {
+			// peer is at another block, can not compare
+			continue
+		}
+		if answer.StateHash != snapshot.StateHash {
+			return "state hash differs from " + cm.IP
+		}
+		agree++
+	}
+	if agree < required {
+		return fmt.Sprintf("%d of %d members agree", agree, required)
+	}
+	return ""
+}
+
+// replaces the state of the node with the snapshot
+func applySnapshot(nodeCtx *NodeCtx, snapshot *StateSnapshot) {
+	nodeCtx.utxoSet.mux.Lock()
+	nodeCtx.utxoSet._init()
+	for _, u := range snapshot.UTXOs {
+		nodeCtx.utxoSet._add(u.TxID, u.Out)
+	}
+	nodeCtx.utxoSet.mux.Unlock()
+
+	// without them the responses to cross-txes of earlier blocks can not be completed
+	for _, t := range snapshot.Originals {
+		nodeCtx.crossTxPool.addOriginalTx(nodeCtx, t)
+	}
+
+	nodeCtx.blockchain.mux.Lock()
+	nodeCtx.blockchain._add(snapshot.Head)
+	nodeCtx.blockchain.mux.Unlock()
+
+	nodeCtx.i.mux.Lock()
+	nodeCtx.i.i = snapshot.Head.ProposedBlock.Iteration + 1
+	nodeCtx.i.mux.Unlock()
+}
+
+// fetches and applies a verified snapshot from a random committee member, then the blocks after it
+func fastSync(nodeCtx *NodeCtx) {
+	fastSyncFrom(nodeCtx, nodeCtx.committee.sortedMembers())
+}
+
+// fast sync from members only, a node that joins a committee in a new epoch only trusts the
+// members that were in it before
+func fastSyncFrom(nodeCtx *NodeCtx, members []*CommitteeMember) {
+	if len(members) == 0 {
+		errFatal(nil, "fast sync without committee members")
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt >= default_fastSyncAttempts {
+			errFatal(nil, "fast sync failed")
+		}
+		// let the peers process the genesis block or the block they are on
+		clockSleep(nodeCtx.delta())
+
+		peer := members[rand.Intn(len(members))]
+		snapshot := new(StateSnapshot)
+		if !requestFrom(peer.IP, Msg{"request_snapshot", "", nodeCtx.self.Priv.Pub}, snapshot) {
+			continue
+		}
+
+		if reason := verifySnapshot(nodeCtx, snapshot, members); reason != "" {
+			nodeLog.warnf(nodeCtx, "[FastSync] snapshot from %s rejected: %s", peer.IP, reason)
+			continue
+		}
+		applySnapshot(nodeCtx, snapshot)
+		nodeLog.infof(nodeCtx, "[FastSync] applied snapshot from %s at iteration %d with %d utxos", peer.IP, snapshot.Head.ProposedBlock.Iteration, len(snapshot.UTXOs))
+		break
+	}
+	syncBlocksFrom(nodeCtx, members)
+}
+
+// fetches and processes blocks from members until none of them has a newer block
+func syncBlocksFrom(nodeCtx *NodeCtx, members []*CommitteeMember) {
+members:
+	for _, cm := range members {
+		for {
+			last := nodeCtx.blockchain.getLatest()
+			response := requestBlocks(nodeCtx, cm, uint64(last.ProposedBlock.Iteration+1))
+			for _, block := range response.Blocks {
+				if block.Pruned {
+					continue members
+				}
+				if reason := verifyCertificate(nodeCtx, block); reason != "" {
+					nodeLog.warnf(nodeCtx, "[FastSync] block from %s rejected: %s", cm.IP, reason)
+					continue members
+				}
+				if !verifyLinkage(nodeCtx, block.ProposedBlock, "sync") {
+					continue members
+				}
+				nodeCtx.blockchain.add(block)
+				block.forceProcessBlock(nodeCtx)
+				nodeCtx.i.add()
+			}
+			if !response.More {
+				break
+			}
+		}
+	}
+}
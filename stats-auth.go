@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// -statsSecret, the token of a connection to the coordinator and of a control message to a node
+
+// a token is the time it was made, a nonce and the mac of both and what it is for
+const statsNonceLength = 16
+const statsTokenLength = 8 + statsNonceLength + sha256.Size
+
+// the secret of the stats, nil without -statsSecret
+var statsAuth *StatsAuth
+
+type StatsAuth struct {
+	secret []byte
+	warned map[string]bool                      // hosts a dropped connection came from
+	seen   map[[statsNonceLength]byte]time.Time // nonces of the tokens in the window, a token is taken once
+	pruned time.Time
+	addrs  map[string]bool // the configured coordinator and standby, the only ones a token is sent to
+	mux    sync.Mutex
+}
+
+func startStatsAuth(mode string, flagArgs *FlagArgs) {
+	if flagArgs.statsSecret == "" {
+		if (mode == "coordinator" || mode == "standby") && !flagArgs.local {
+			coordinatorLog.warnf(nil, "[Auth] the stats are taken from any connection, set -statsSecret or RC_STATS_SECRET on the coordinator and the nodes")
+		}
+		if mode == "node" && !flagArgs.local {
+			nodeLog.warnf(nil, "[Auth] the control messages of the coordinator are taken from any peer, set -statsSecret or RC_STATS_SECRET on the coordinator and the nodes")
+		}
+		return
+	}
+	statsAuth = &StatsAuth{secret: []byte(flagArgs.statsSecret), warned: make(map[string]bool), seen: make(map[[statsNonceLength]byte]time.Time)}
+	statsAuth.addrs = map[string]bool{coord + ":8080": true}
+	if flagArgs.standby != "" {
+		statsAuth.addrs[flagArgs.standby+":8080"] = true
+		statsAuth.addrs[net.JoinHostPort(flagArgs.standby, strconv.Itoa(default_standbyPort))] = true
+	}
+}
+
+func (a *StatsAuth) mac(label string, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(label))
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return mac.Sum(nil)
+}
+
+// a new token for label and data
+func (a *StatsAuth) token(label string, data []byte) []byte {
+	token := make([]byte, 8+statsNonceLength, statsTokenLength)
+	binary.BigEndian.PutUint64(token, uint64(clockNow().UnixNano()))
+	_, err := rand.Read(token[8:])
+	ifErrFatal(err, "token nonce")
+	return append(token, a.mac(label, token, data)...)
+}
+
+// why token is not a token for label and data that was not taken before, "" if it is
+func (a *StatsAuth) check(label string, token []byte, data []byte) string {
+	if len(token) != statsTokenLength {
+		return "no token"
+	}
+	head := token[:8+statsNonceLength]
+	if !hmac.Equal(token[len(head):], a.mac(label, head, data)) {
+		return "invalid token"
+	}
+	stamp := time.Unix(0, int64(binary.BigEndian.Uint64(token[:8])))
+	off := clockSince(stamp)
+	if off < 0 {
+		off = -off
+	}
+	if off > default_statsAuthWindow*time.Second {
+		return "token " + off.Round(time.Second).String() + " off"
+	}
+	var nonce [statsNonceLength]byte
+	copy(nonce[:], token[8:])
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	// a token out of the window is dropped by its time, so its nonce is forgotten
+	if clockSince(a.pruned) > default_statsAuthWindow*time.Second {
+		for n, t := range a.seen {
+			if d := clockSince(t); d > default_statsAuthWindow*time.Second || d < -default_statsAuthWindow*time.Second {
+				delete(a.seen, n)
+			}
+		}
+		a.pruned = clockNow()
+	}
+	if _, ok := a.seen[nonce]; ok {
+		return "replayed token"
+	}
+	a.seen[nonce] = stamp
+	return ""
+}
+
+// the connections that start with a token, the ones to the coordinator and to the standby of the
+// flags. A host a message names does not get one
+func (a *StatsAuth) authenticates(addr string) bool {
+	return a.addrs[addr]
+}
+
+// writes the token to conn if addr takes one
+func (a *StatsAuth) sign(conn net.Conn, addr string) error {
+	if a == nil || !a.authenticates(addr) {
+		return nil
+	}
+	_, err := conn.Write(a.token("rapidchain stats", nil))
+	return err
+}
+
+// reads the token of conn, false if the connection is dropped. The caller closes it
+func (a *StatsAuth) admit(conn net.Conn) bool {
+	if a == nil {
+		return true
+	}
+	token := make([]byte, statsTokenLength)
+	conn.SetReadDeadline(clockNow().Add(default_statsAuthTimeout * time.Second))
+	_, err := io.ReadFull(conn, token)
+	conn.SetReadDeadline(time.Time{})
+	reason := ""
+	if err != nil {
+		reason = "no token: " + err.Error()
+	} else {
+		reason = a.check("rapidchain stats", token, nil)
+	}
+	if reason == "" {
+		return true
+	}
+	a.dropped(conn.RemoteAddr().String(), reason)
+	return false
+}
+
+func (a *StatsAuth) dropped(addr string, reason string) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	a.mux.Lock()
+	first := !a.warned[host]
+	a.warned[host] = true
+	a.mux.Unlock()
+	if first {
+		coordinatorLog.warnf(nil, "[Auth] dropped a connection of %s, more of the host are dropped quietly: %s", addr, reason)
+		return
+	}
+	coordinatorLog.debugf(nil, "[Auth] dropped a connection of %s: %s", addr, reason)
+}
+
+// h for the requests with the secret as bearer token
+func (a *StatsAuth) http(h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	want := []byte("Bearer " + string(a.secret))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			a.dropped(r.RemoteAddr, "http "+r.URL.Path+" without the secret")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// the messages a node only takes from the coordinator, in a control msg with a token of -statsSecret
+var controlMsgs = map[string]bool{
+	"profile":              true,
+	"flight_dump":          true,
+	"churn_kill":           true,
+	"fault":                true,
+	"tune":                 true,
+	"coordinator_takeover": true,
+}
+
+// a Msg of the coordinator to the node with the key To. Msg is encoded so the node checks the token
+// on the bytes it was made for
+type ControlMsg struct {
+	Msg   []byte
+	To    [32]byte
+	Token []byte
+}
+
+func controlData(to [32]byte, data []byte) []byte {
+	return byteSliceAppend(to[:], data)
+}
+
+// msg for the node with the key to, in a control msg without -statsSecret it is sent as it is
+func withControlToken(to *PubKey, msg Msg) Msg {
+	if statsAuth == nil {
+		return msg
+	}
+	c := ControlMsg{Msg: getBytes(msg), To: to.Bytes}
+	c.Token = statsAuth.token("rapidchain control", controlData(c.To, c.Msg))
+	return Msg{"control", c, nil}
+}
+
+// the Msg in c if its token holds for this node and is not replayed
+func openControlMsg(nodeCtx *NodeCtx, c *ControlMsg) (Msg, bool) {
+	var msg Msg
+	reason := ""
+	switch {
+	case statsAuth == nil:
+		reason = "control messages need -statsSecret"
+	case c.To != nodeCtx.self.Priv.Pub.Bytes:
+		reason = "for another node"
+	default:
+		reason = statsAuth.check("rapidchain control", c.Token, controlData(c.To, c.Msg))
+	}
+	if reason != "" {
+		nodeLog.warnf(nodeCtx, "[Auth] dropped a control message: %s", reason)
+		return msg, false
+	}
+	if err := gob.NewDecoder(bytes.NewBuffer(c.Msg)).Decode(&msg); err != nil {
+		errr(err, "control message decoding")
+		return msg, false
+	}
+	if !controlMsgs[msg.Typ] {
+		nodeLog.warnf(nodeCtx, "[Auth] dropped a control message with a %s", msg.Typ)
+		return msg, false
+	}
+	return msg, true
+}
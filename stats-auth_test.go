@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+// a token is taken once, and a control msg only by the node it was made for
+func TestStatsAuthReplay(t *testing.T) {
+	defer func(a *StatsAuth) { statsAuth = a }(statsAuth)
+	statsAuth = nil
+	startStatsAuth("coordinator", &FlagArgs{statsSecret: "secret", local: true})
+
+	token := statsAuth.token("rapidchain stats", nil)
+	if reason := statsAuth.check("rapidchain stats", token, nil); reason != "" {
+		t.Fatalf("fresh token dropped: %s", reason)
+	}
+	if reason := statsAuth.check("rapidchain stats", token, nil); reason != "replayed token" {
+		t.Errorf("replayed token: %q", reason)
+	}
+	if reason := statsAuth.check("rapidchain control", statsAuth.token("rapidchain stats", nil), nil); reason != "invalid token" {
+		t.Errorf("stats token as a control token: %q", reason)
+	}
+
+	node := &NodeCtx{self: SelfInfo{Priv: new(PrivKey)}}
+	node.self.Priv.gen()
+	other := new(PrivKey)
+	other.gen()
+	c, ok := withControlToken(node.self.Priv.Pub, Msg{"flight_dump", "", nil}).Msg.(ControlMsg)
+	if !ok {
+		t.Fatalf("no control msg with a secret")
+	}
+	if _, ok := openControlMsg(node, &c); !ok {
+		t.Errorf("control msg dropped")
+	}
+	if _, ok := openControlMsg(node, &c); ok {
+		t.Errorf("replayed control msg taken")
+	}
+	c, _ = withControlToken(other.Pub, Msg{"flight_dump", "", nil}).Msg.(ControlMsg)
+	if _, ok := openControlMsg(node, &c); ok {
+		t.Errorf("control msg of another node taken")
+	}
+}
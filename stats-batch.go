@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// the per-event stats, the ones -statsSample, -statsKeep and -statsRate thin out
+var perEventStats = map[string]bool{
+	"consensus":                true,
+	"IDASuccess":               true,
+	"routetx":                  true,
+	"transaction_recieved":     true,
+	"start_ida_gossip":         true,
+	"reconstructed_ida_gossip": true,
+	"find_node":                true,
+}
+
+// -statsBatch and the sampling of the stats on the nodes and the coordinator
+
+type StatsBatch struct {
+	Msgs []Msg
+}
+
+// the stats of the nodes of this process until the next batch
+var statsQueue struct {
+	msgs    []Msg
+	started bool
+	mux     sync.Mutex
+}
+
+// whether the node with pub sends its per-event stats with -statsSample fraction
+func statsSampled(pub [32]byte, fraction float64) bool {
+	if fraction >= 1 {
+		return true
+	}
+	h := hash(pub[:])
+	return float64(binary.LittleEndian.Uint64(h[:8])>>11)/(1<<53) < fraction
+}
+
+// sends a per-event stat of the node to the coordinator, or queues it with -statsBatch
+func sendStat(nodeCtx *NodeCtx, msg Msg) {
+	if !statsSampled(nodeCtx.self.Priv.Pub.Bytes, nodeCtx.flagArgs.statsSample) {
+		return
+	}
+	if nodeCtx.flagArgs.statsBatch == 0 {
+		spawnDialAndSend(coordinatorAddr(), msg)
+		return
+	}
+	statsQueue.mux.Lock()
+	defer statsQueue.mux.Unlock()
+	statsQueue.msgs = append(statsQueue.msgs, msg)
+	if !statsQueue.started {
+		statsQueue.started = true
+		interval := nodeCtx.flagArgs.statsBatch
+		spawn(func() { sendStatsBatches(interval) })
+	}
+}
+
+func sendStatsBatches(interval uint) {
+	for {
+		clockSleep(time.Duration(interval) * time.Second)
+		statsQueue.mux.Lock()
+		msgs := statsQueue.msgs
+		statsQueue.msgs = nil
+		statsQueue.mux.Unlock()
+		if len(msgs) > 0 {
+			dialAndSend(coordinatorAddr(), Msg{"stats_batch", StatsBatch{msgs}, nil})
+		}
+	}
+}
+
+// the per-event stats the coordinator handles with -statsKeep and -statsRate, nil without them
+var statsLimit *StatsLimit
+
+type StatsLimit struct {
+	keep    float64
+	rate    uint
+	second  time.Time // the second count is of
+	count   uint
+	skipped uint64 // not of the ids -statsKeep keeps
+	dropped uint64 // above -statsRate
+	mux     sync.Mutex
+}
+
+func startStatsLimit(flagArgs *FlagArgs) {
+	if flagArgs.statsKeep >= 1 && flagArgs.statsRate == 0 {
+		return
+	}
+	statsLimit = &StatsLimit{keep: flagArgs.statsKeep, rate: flagArgs.statsRate}
+}
+
+// the id a per-event stat is kept by: the tx or gossip, or the node of a consensus stat
+func statID(msg *Msg) [32]byte {
+	var id [32]byte
+	switch m := msg.Msg.(type) {
+	case [32]byte:
+		id = m
+	case ByteArrayAndTimestamp:
+		if msg.Typ == "consensus" && msg.FromPub != nil {
+			id = msg.FromPub.Bytes
+		} else {
+			copy(id[:], m.B)
+		}
+	}
+	return id
+}
+
+// whether the coordinator handles msg, false for a per-event stat it drops
+func (l *StatsLimit) admit(msg *Msg) bool {
+	if l == nil || !perEventStats[msg.Typ] {
+		return true
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if !statsSampled(statID(msg), l.keep) {
+		l.skipped++
+		return false
+	}
+	if l.rate == 0 {
+		return true
+	}
+	if now := clockNow().Truncate(time.Second); now != l.second {
+		l.second, l.count = now, 0
+	}
+	if l.count >= l.rate {
+		if l.dropped == 0 {
+			coordinatorLog.warnf(nil, "[Stats] more than -statsRate %d per-event stats a second, the ones above it are dropped", l.rate)
+		}
+		l.dropped++
+		return false
+	}
+	l.count++
+	return true
+}
+
+func (l *StatsLimit) report() {
+	if l == nil {
+		return
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	coordinatorLog.infof(nil, "[Stats] %d per-event stats not kept with -statsKeep %g, %d dropped above -statsRate %d", l.skipped, l.keep, l.dropped, l.rate)
+}
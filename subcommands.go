@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// the subcommands and the flags of each
+
+type Subcommand struct {
+	name  string
+	about string
+	flags []string // the flags only this one takes
+}
+
+var simFlags = []string{"simLatency", "simJitter", "simBandwidth", "simLoss", "simTime", "netProfile"}
+var trialFlags = []string{"trials"}
+
+var subcommands = []Subcommand{
+	{"coordinator", "sets up the committees, generates the txs and collects the results of a run", []string{"recoverEvery"}},
+	{"standby", "mirrors the coordinator of a run with -standby and takes over its stats when it dies", nil},
+	{"node", "runs -instances nodes that register with the coordinator", []string{"recoverEvery", "recoverExit", "recoverFrom"}},
+	{"simulate", "runs the coordinator and -n nodes in this process on a simulated network", simFlags},
+	{"verify", "verifies every block of the -blockStore stores against its committee, like audit", nil},
+	{"audit", "verifies every block of the -blockStore stores against its committee", nil},
+	{"demo", "runs a small cluster in this process and narrates the blocks it finalizes", nil},
+	{"supervise", "runs the -n nodes as processes that exit and recover", []string{"recoverEvery", "recoverNodes"}},
+	{"sweep", "runs a grid of parameters, one simulate or cluster run per point", append([]string{"sweep", "sweepRun", "sweepTime", "sweepDir"}, simFlags...)},
+	{"dryrun", "simulates the committee assignment over epochs without a network", trialFlags},
+	{"aws-launch", "runs the coordinator and the nodes on ec2 instances and terminates them at the end", []string{"awsTemplate", "awsRegion", "awsBucket", "awsRunTime", "awsStatsParameter"}},
+	{"genmanifests", "writes the kubernetes manifests of a run of -n nodes to stdout", []string{"image"}},
+	{"version", "prints the version and commit of the binary", nil},
+	{"tracediff", "compares the trace of a run with a golden trace", []string{"goldenTrace", "runTrace", "traceFields"}},
+	{"benchcrypto", "benchmarks the signature schemes", nil},
+	{"bench-ida", "benchmarks ida on synthetic messages", trialFlags},
+	{"bench-consensus", "benchmarks a consensus round on synthetic votes", trialFlags},
+	{"bench-routing", "benchmarks the routing on synthetic committees", trialFlags},
+}
+
+// the mode of function that runs the subcommand
+var subcommandModes = map[string]string{"verify": "audit"}
+
+func findSubcommand(name string) *Subcommand {
+	for i := range subcommands {
+		if subcommands[i].name == name {
+			return &subcommands[i]
+		}
+	}
+	return nil
+}
+
+// the subcommands that take flag, nil if every one does
+func flagOwners(name string) []string {
+	owners := []string{}
+	for _, s := range subcommands {
+		for _, f := range s.flags {
+			if f == name {
+				owners = append(owners, s.name)
+			}
+		}
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+	return owners
+}
+
+func takesFlag(sub string, name string) bool {
+	owners := flagOwners(name)
+	if owners == nil {
+		return name != "function"
+	}
+	for _, o := range owners {
+		if o == sub {
+			return true
+		}
+	}
+	return false
+}
+
+// ends the process like the flag package does on a bad flag
+func usageErr(sub string, format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	subcommandUsage(sub)
+	os.Exit(exitUsage)
+}
+
+// the subcommand of the arguments and the flags after it, "" if they start with a flag
+func splitSubcommand(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", args
+	}
+	if args[0] == "help" {
+		subcommandUsage("")
+		os.Exit(0)
+	}
+	if findSubcommand(args[0]) == nil {
+		usageErr("", "unknown subcommand %s", args[0])
+	}
+	return args[0], args[1:]
+}
+
+// checks the parsed command line and returns the subcommand that runs
+func checkSubcommand(sub string, function string) string {
+	functionGiven := false
+	flag.Visit(func(f *flag.Flag) { functionGiven = functionGiven || f.Name == "function" })
+	if sub == "" {
+		sub = function
+		if findSubcommand(sub) == nil {
+			usageErr("", "unknown -function %s", sub)
+		}
+	} else if functionGiven && function != sub {
+		usageErr(sub, "-function %s given to subcommand %s", function, sub)
+	}
+	if flag.NArg() > 0 {
+		usageErr(sub, "unexpected argument %s, the flags come after the subcommand, e.g. -instances 8", flag.Arg(0))
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "function" && !takesFlag(sub, f.Name) {
+			usageErr(sub, "-%s is not a flag of %s, it is one of %s", f.Name, sub, strings.Join(flagOwners(f.Name), ", "))
+		}
+	})
+	return sub
+}
+
+// the function mode of a subcommand
+func subcommandMode(sub string) string {
+	if mode, ok := subcommandModes[sub]; ok {
+		return mode
+	}
+	return sub
+}
+
+// the flags of the command line that sub takes, for the processes a subcommand starts
+func commandLineFlags(sub string) []string {
+	_, args := splitSubcommand(os.Args[1:])
+	flags := []string{}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		name := strings.SplitN(strings.TrimLeft(a, "-"), "=", 2)[0]
+		// a flag that is not a bool has its value in the next arg, unless it is given with =
+		value := !strings.Contains(a, "=") && i+1 < len(args)
+		if f := flag.Lookup(name); f != nil {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+				value = false
+			}
+		}
+		if takesFlag(sub, name) {
+			flags = append(flags, a)
+			if value {
+				flags = append(flags, args[i+1])
+			}
+		}
+		if value {
+			i++
+		}
+	}
+	return flags
+}
+
+func subcommandUsage(sub string) {
+	w := flag.CommandLine.Output()
+	s := findSubcommand(sub)
+	if s == nil {
+		fmt.Fprintf(w, "usage: rapidchain <subcommand> [flags]\n\nsubcommands:\n")
+		for _, s := range subcommands {
+			fmt.Fprintf(w, "  %-16s %s\n", s.name, s.about)
+		}
+		fmt.Fprintf(w, "\nrapidchain <subcommand> -h lists its flags\n")
+		return
+	}
+	fmt.Fprintf(w, "usage: rapidchain %s [flags]\n  %s\n", s.name, s.about)
+	own, run := []*flag.Flag{}, []*flag.Flag{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if flagOwners(f.Name) != nil && takesFlag(sub, f.Name) {
+			own = append(own, f)
+		} else if flagOwners(f.Name) == nil && f.Name != "function" {
+			run = append(run, f)
+		}
+	})
+	if len(own) > 0 {
+		fmt.Fprintf(w, "\nflags of %s:\n", s.name)
+		printFlags(w, own)
+	}
+	fmt.Fprintf(w, "\nflags of a run:\n")
+	printFlags(w, run)
+}
+
+func printFlags(w io.Writer, flags []*flag.Flag) {
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	for _, f := range flags {
+		fmt.Fprintf(w, "  -%s\n    \t%s", f.Name, f.Usage)
+		if f.DefValue != "" && f.DefValue != "0" && f.DefValue != "false" {
+			fmt.Fprintf(w, " (default %s)", f.DefValue)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// sweep, a run for every point of a grid of parameters
+
+const (
+	sweepSimulate = "simulate"
+	sweepCluster  = "cluster"
+)
+
+var sweepParams = []string{"n", "m", "B", "tps", "delta"}
+
+// the grid, the sweep state in sweep.json
+type SweepGrid struct {
+	Values map[string][]uint `json:"values"` // parameter -> values, only the swept ones
+	Run    string            `json:"run"`
+	Time   uint              `json:"time"`
+	Args   []string          `json:"args"`
+}
+
+// the values of -sweep, returns "" or the reason it is invalid
+func parseSweepGrid(s string) (map[string][]uint, string) {
+	values := make(map[string][]uint)
+	for _, e := range strings.Split(s, ";") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			return nil, "-sweep entry " + e + " is not parameter=values"
+		}
+		param := strings.TrimSpace(kv[0])
+		if !isSweepParam(param) {
+			return nil, "-sweep parameter " + param + " is not one of " + strings.Join(sweepParams, ", ")
+		}
+		if _, ok := values[param]; ok {
+			return nil, "-sweep parameter " + param + " is given twice"
+		}
+		for _, v := range strings.Split(kv[1], ",") {
+			u, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return nil, "-sweep value " + v + " of " + param + " is not a number"
+			}
+			values[param] = append(values[param], uint(u))
+		}
+	}
+	if len(values) == 0 {
+		return nil, "-sweep has no parameter"
+	}
+	return values, ""
+}
+
+func isSweepParam(param string) bool {
+	for _, p := range sweepParams {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
+// the points of the grid in the order of sweepParams, the last parameter changes fastest
+func (g *SweepGrid) points(flagArgs *FlagArgs) []map[string]uint {
+	base := map[string]uint{"n": flagArgs.n, "m": flagArgs.m, "B": flagArgs.B, "tps": flagArgs.tps, "delta": flagArgs.delta}
+	points := []map[string]uint{base}
+	for _, param := range sweepParams {
+		values, ok := g.Values[param]
+		if !ok {
+			continue
+		}
+		next := []map[string]uint{}
+		for _, p := range points {
+			for _, v := range values {
+				point := make(map[string]uint)
+				for k, pv := range p {
+					point[k] = pv
+				}
+				point[param] = v
+				next = append(next, point)
+			}
+		}
+		points = next
+	}
+	return points
+}
+
+// the flags of the sweep that the runs of a point take, the values of the point are added after
+// them and win
+func sweepArgs(run string) []string {
+	if run == sweepCluster {
+		return commandLineFlags("node")
+	}
+	return commandLineFlags("simulate")
+}
+
+// reads sweep.json of an interrupted sweep, or writes it for a new one
+func sweepState(dir string, grid *SweepGrid) {
+	name := filepath.Join(dir, "sweep.json")
+	if b, err := os.ReadFile(name); err == nil {
+		started := new(SweepGrid)
+		ifErrFatal(json.Unmarshal(b, started), "decoding "+name)
+		if !reflect.DeepEqual(started, grid) {
+			errFatal(nil, dir+" has a sweep of another grid or other flags, give it a new -sweepDir")
+		}
+		return
+	}
+	ifErrFatal(os.MkdirAll(dir, 0755), "sweep directory")
+	b, err := json.MarshalIndent(grid, "", "  ")
+	ifErrFatal(err, "encoding sweep state")
+	ifErrFatal(os.WriteFile(name, b, 0644), "sweep state")
+}
+
+// the indices of the points with a row in the dataset
+func sweepDone(name string) map[int]bool {
+	done := make(map[int]bool)
+	f, err := os.Open(name)
+	if err != nil {
+		return done
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		index, err := strconv.Atoi(strings.SplitN(scanner.Text(), ",", 2)[0])
+		if err == nil {
+			done[index] = true
+		}
+	}
+	return done
+}
+
+// the first file of results of the kind, tx.csv, "" if there is none
+func sweepFile(results string, kind string) string {
+	ext := filepath.Ext(kind)
+	matches, _ := filepath.Glob(filepath.Join(results, strings.TrimSuffix(kind, ext)+"-*"+ext))
+	sort.Strings(matches)
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[0]
+}
+
+func sweepLines(name string) []string {
+	b, err := os.ReadFile(name)
+	if name == "" || err != nil {
+		return nil
+	}
+	lines := []string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// what the run in dir measured: seconds,txs,tps,latency mean,p50,p99,final blocks,accept fails,
+// forks,aborted, and the reason of the watchdog
+func sweepMeasure(dir string, seconds float64) (string, string) {
+	results := filepath.Join(dir, "results")
+	latencies := []float64{}
+	for _, row := range sweepLines(sweepFile(results, "tx.csv")) {
+		fields := strings.Split(row, ",")
+		if len(fields) < 2 {
+			continue
+		}
+		if l, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			latencies = append(latencies, l)
+		}
+	}
+	sort.Float64s(latencies)
+	mean, p50, p99 := 0.0, 0.0, 0.0
+	for _, l := range latencies {
+		mean += l
+	}
+	if len(latencies) > 0 {
+		mean /= float64(len(latencies))
+		p50 = latencies[len(latencies)/2]
+		p99 = latencies[len(latencies)*99/100]
+	}
+
+	blocks := 0
+	if b, err := os.ReadFile(sweepFile(results, "chains.json")); err == nil {
+		var chains struct {
+			Committees []ChainExportCommittee `json:"committees"`
+		}
+		if !ifErr(json.Unmarshal(b, &chains), "sweep chains") {
+			for _, c := range chains.Committees {
+				for _, block := range c.Blocks {
+					if block.Height > 0 {
+						blocks++
+					}
+				}
+			}
+		}
+	}
+
+	aborted := ""
+	if b, err := os.ReadFile(sweepFile(results, "manifest.json")); err == nil {
+		m := new(Manifest)
+		if !ifErr(json.Unmarshal(b, m), "sweep manifest") {
+			aborted = m.Aborted
+		}
+	}
+	tps := 0.0
+	if seconds > 0 {
+		tps = float64(len(latencies)) / seconds
+	}
+	row := fmt.Sprintf("%.1f,%d,%.2f,%.4f,%.4f,%.4f,%d,%d,%d,%s", seconds, len(latencies), tps, mean, p50, p99, blocks,
+		len(sweepLines(sweepFile(results, "consensusacceptfail.csv"))), len(sweepLines(sweepFile(results, "fork.csv"))),
+		strings.ReplaceAll(aborted, ",", ";"))
+	return row, aborted
+}
+
+// starts the run in dir with args, its output goes to log
+func sweepStart(exe string, dir string, log *os.File, args ...string) (*exec.Cmd, error) {
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = log, log
+	// an interrupt of the sweep is not sent to the runs, the sweep stops them
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd, cmd.Start()
+}
+
+// waits for cmd until timeout, then interrupts and kills it, false if it had to be stopped
+func sweepWait(cmd *exec.Cmd, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		defer recordPanic()
+		cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+	}
+	cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-done:
+	case <-time.After(default_sweepGrace * time.Second):
+		cmd.Process.Kill()
+		<-done
+	}
+	return false
+}
+
+// the processes of the point that runs, killed when the sweep is interrupted
+type SweepRuns struct {
+	cmds []*exec.Cmd
+	mux  sync.Mutex
+}
+
+var sweepRuns SweepRuns
+
+func (sr *SweepRuns) set(cmds ...*exec.Cmd) {
+	sr.mux.Lock()
+	defer sr.mux.Unlock()
+	sr.cmds = cmds
+}
+
+func (sr *SweepRuns) kill() {
+	sr.mux.Lock()
+	defer sr.mux.Unlock()
+	for _, cmd := range sr.cmds {
+		cmd.Process.Kill()
+	}
+}
+
+// runs a point with n nodes and returns its status
+func sweepPoint(flagArgs *FlagArgs, exe string, dir string, n uint, args []string) string {
+	ifErrFatal(os.RemoveAll(dir), "cleaning "+dir)
+	// the results of every point are in its directory, whatever -resultsDir the sweep has
+	args = append(args, "-resultsDir=results")
+	ifErrFatal(os.MkdirAll(filepath.Join(dir, "results"), 0755), "sweep point directory")
+	log, err := os.Create(filepath.Join(dir, "run.log"))
+	ifErrFatal(err, "sweep log")
+	defer log.Close()
+	runTime := time.Duration(flagArgs.sweepTime) * time.Second
+	if flagArgs.sweepRun == sweepSimulate {
+		cmd, err := sweepStart(exe, dir, log, append(append([]string{"simulate"}, args...), fmt.Sprintf("-simTime=%d", flagArgs.sweepTime))...)
+		if ifErr(err, "starting "+dir) {
+			return "failed"
+		}
+		sweepRuns.set(cmd)
+		defer sweepRuns.set()
+		if !sweepWait(cmd, runTime+default_sweepGrace*time.Second) {
+			return "timeout"
+		}
+		return sweepExit(cmd)
+	}
+	coordinator, err := sweepStart(exe, dir, log, append([]string{"coordinator"}, args...)...)
+	if ifErr(err, "starting coordinator of "+dir) {
+		return "failed"
+	}
+	// the nodes ask the coordinator for the setup when they start
+	time.Sleep(time.Second)
+	nodes, err := sweepStart(exe, dir, log, append(append([]string{"node"}, args...), fmt.Sprintf("-instances=%d", n))...)
+	if ifErr(err, "starting nodes of "+dir) {
+		coordinator.Process.Kill()
+		coordinator.Wait()
+		return "failed"
+	}
+	sweepRuns.set(coordinator, nodes)
+	defer sweepRuns.set()
+	// the coordinator exports its results when it is stopped, before the nodes are
+	sweepWait(coordinator, runTime)
+	status := sweepExit(coordinator)
+	nodes.Process.Signal(os.Interrupt)
+	if !sweepWait(nodes, default_sweepGrace*time.Second) {
+		nodeLog.warnf(nil, "[Sweep] nodes of %s did not stop, killed", dir)
+	}
+	return status
+}
+
+// ok, aborted, violation or failed by the exit code of a stopped run, see exit-status.go
+func sweepExit(cmd *exec.Cmd) string {
+	switch cmd.ProcessState.ExitCode() {
+	case exitSuccess:
+		return "ok"
+	case exitAborted:
+		return "aborted"
+	case exitViolation:
+		return "violation"
+	}
+	return "failed"
+}
+
+func sweep(flagArgs *FlagArgs) {
+	values, reason := parseSweepGrid(flagArgs.sweep)
+	if reason != "" {
+		errFatal(nil, reason)
+	}
+	if flagArgs.sweepRun != sweepSimulate && flagArgs.sweepRun != sweepCluster {
+		errFatal(nil, "unknown -sweepRun "+flagArgs.sweepRun+", give simulate or cluster")
+	}
+	exe, err := os.Executable()
+	ifErrFatal(err, "sweep executable")
+	grid := &SweepGrid{values, flagArgs.sweepRun, flagArgs.sweepTime, sweepArgs(flagArgs.sweepRun)}
+	sweepState(flagArgs.sweepDir, grid)
+	dataset := filepath.Join(flagArgs.sweepDir, "sweep.csv")
+	done := sweepDone(dataset)
+	points := grid.points(flagArgs)
+	coordinatorLog.infof(nil, "[Sweep] %d points of %s runs of %ds, %d done, dataset %s", len(points), flagArgs.sweepRun, flagArgs.sweepTime, len(done), dataset)
+
+	// an interrupted point has no row and runs again
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer recordPanic()
+		<-sigs
+		coordinatorLog.warnf(nil, "[Sweep] interrupted, run it again to go on")
+		sweepRuns.kill()
+		os.Exit(1)
+	}()
+
+	for i, p := range points {
+		if done[i] {
+			continue
+		}
+		args := append([]string{}, grid.Args...)
+		for _, param := range sweepParams {
+			args = append(args, fmt.Sprintf("-%s=%d", param, p[param]))
+		}
+		dir := filepath.Join(flagArgs.sweepDir, fmt.Sprintf("point-%d", i))
+		coordinatorLog.infof(nil, "[Sweep] point %d of %d points: n=%d m=%d B=%d tps=%d delta=%d", i, len(points), p["n"], p["m"], p["B"], p["tps"], p["delta"])
+		start := time.Now()
+		status := sweepPoint(flagArgs, exe, dir, p["n"], args)
+		measured, aborted := sweepMeasure(dir, time.Since(start).Seconds())
+		if aborted != "" {
+			status = "aborted"
+		}
+		row := fmt.Sprintf("%d,%d,%d,%d,%d,%d,%s,%s\n", i, p["n"], p["m"], p["B"], p["tps"], p["delta"], status, measured)
+		f, err := os.OpenFile(dataset, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		ifErrFatal(err, "sweep dataset")
+		_, err = f.WriteString(row)
+		ifErrFatal(err, "sweep dataset")
+		ifErrFatal(f.Sync(), "sweep dataset")
+		f.Close()
+		coordinatorLog.infof(nil, "[Sweep] point %d %s, see %s", i, status, dir)
+	}
+	coordinatorLog.infof(nil, "[Sweep] done, %s", dataset)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Ramp mode for the tx generator. Tps is increased by rampStep every rampInterval untill the mean
+// confirmation latency of the transactions finished in the last interval exceeds rampLatency.
+// The last tps under the threshold is the saturation point of the system.
+type TpsRamp struct {
+	enabled   bool
+	tps       uint
+	step      uint
+	interval  time.Duration
+	threshold time.Duration
+	stepStart time.Time
+	latencies []time.Duration // latencies of txes finished in the current step
+	saturated bool
+	satTps    uint // last tps where latency was below threshold
+	mux       sync.Mutex
+}
+
+func (r *TpsRamp) init(flagArgs *FlagArgs) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.enabled = flagArgs.ramp
+	r.tps = flagArgs.tps
+	r.step = flagArgs.rampStep
+	r.interval = time.Duration(flagArgs.rampInterval) * time.Second
+	r.threshold = time.Duration(flagArgs.rampLatency) * time.Millisecond
+	r.stepStart = clockNow()
+	r.latencies = []time.Duration{}
+}
+
+func (r *TpsRamp) getTps() uint {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.tps
+}
+
+// the tps of a tuning, a ramp goes on from it
+func (r *TpsRamp) setTps(tps uint) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.tps = tps
+}
+
+// records the confirmation latency of a finished transaction
+func (r *TpsRamp) addSample(dur time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if !r.enabled || r.saturated {
+		return
+	}
+	r.latencies = append(r.latencies, dur)
+}
+
+func (r *TpsRamp) _meanLatency() time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	var tot time.Duration
+	for _, l := range r.latencies {
+		tot += l
+	}
+	return tot / time.Duration(len(r.latencies))
+}
+
+// checks if the current step is over, and either increases tps or records the saturation point.
+// Returns true the first time the saturation point is detected.
+func (r *TpsRamp) update(f *os.File) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if !r.enabled || r.saturated || clockSince(r.stepStart) < r.interval {
+		return false
+	}
+
+	mean := r._meanLatency()
+	samples := len(r.latencies)
+	writeStringToFile(fmt.Sprintf("step,%d,%d,%.4f", r.tps, samples, mean.Seconds()), f)
+
+	r.stepStart = clockNow()
+	r.latencies = []time.Duration{}
+
+	if samples > 0 && mean > r.threshold {
+		// latency exceeded threshold, the previous step is the saturation point
+		r.saturated = true
+		if r.tps > r.step {
+			r.satTps = r.tps - r.step
+		} else {
+			r.satTps = r.tps
+		}
+		writeStringToFile(fmt.Sprintf("saturation,%d,%d,%.4f", r.satTps, samples, mean.Seconds()), f)
+		coordinatorLog.infof(nil, "[Ramp] saturation point found at %d tps (mean latency %.4fs at %d tps)", r.satTps, mean.Seconds(), r.tps)
+		// hold the generator at the saturation point for the rest of the run
+		r.tps = r.satTps
+		return true
+	}
+
+	r.tps += r.step
+	coordinatorLog.infof(nil, "[Ramp] mean latency %.4fs with %d samples, increasing tps to %d", mean.Seconds(), samples, r.tps)
+	return false
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// -traceCollector, otlp spans of traced txs
+
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+func (tc TraceContext) traced() bool {
+	return tc.TraceID != [16]byte{}
+}
+
+func newSpanID() [8]byte {
+	var id [8]byte
+	nodeRand().Read(id[:])
+	return id
+}
+
+func newTrace() TraceContext {
+	var tc TraceContext
+	nodeRand().Read(tc.TraceID[:])
+	tc.SpanID = newSpanID()
+	return tc
+}
+
+type Span struct {
+	Ctx        TraceContext
+	Parent     [8]byte
+	Name       string
+	Start, End time.Time
+	Attrs      []string // key, value, key, value...
+}
+
+// the spans of a node or the coordinator until they are exported, nil without -traceCollector
+type Tracer struct {
+	collector string
+	sample    float64
+	resource  []string
+	spans     []Span
+	dropped   int
+	phases    map[[32]byte]map[string]time.Time // gossip hash -> consensus phase -> start
+	mux       sync.Mutex
+}
+
+// starts the export, nil without -traceCollector
+func newTracer(flagArgs *FlagArgs, role string, node string) *Tracer {
+	if flagArgs.traceCollector == "" {
+		return nil
+	}
+	tr := new(Tracer)
+	tr.collector = flagArgs.traceCollector
+	tr.sample = flagArgs.traceSample
+	tr.resource = []string{"service.name", "rapidchain", "rapidchain.role", role, "rapidchain.node", node}
+	tr.phases = make(map[[32]byte]map[string]time.Time)
+	spawn(tr.export)
+	return tr
+}
+
+// whether the next tx of the coordinator is traced
+func (tr *Tracer) sampled() bool {
+	if tr == nil {
+		return false
+	}
+	var b [8]byte
+	nodeRand().Read(b[:])
+	return float64(binary.LittleEndian.Uint64(b[:])>>11)/(1<<53) < tr.sample
+}
+
+// the context of a new span under parent, parent itself when nothing is traced
+func (tr *Tracer) child(parent TraceContext) TraceContext {
+	if tr == nil || !parent.traced() {
+		return parent
+	}
+	return TraceContext{parent.TraceID, newSpanID()}
+}
+
+// records the span ctx under parent, a zero parent makes it the root of the trace
+func (tr *Tracer) record(ctx TraceContext, parent TraceContext, name string, start, end time.Time, attrs ...string) {
+	if tr == nil || !ctx.traced() {
+		return
+	}
+	tr.mux.Lock()
+	defer tr.mux.Unlock()
+	if len(tr.spans) >= default_traceBuffer {
+		tr.dropped++
+		return
+	}
+	s := Span{Ctx: ctx, Name: name, Start: start, End: end, Attrs: attrs}
+	if parent.traced() {
+		s.Parent = parent.SpanID
+	}
+	tr.spans = append(tr.spans, s)
+}
+
+// records a span of a node, with its committee, in the time of the coordinator
+func traceSpan(nodeCtx *NodeCtx, ctx TraceContext, parent TraceContext, name string, start, end time.Time, attrs ...string) {
+	attrs = append(attrs, "rapidchain.committee", shortID(nodeCtx.committeeID()))
+	nodeCtx.tracer.record(ctx, parent, name, coordinatorTime(start), coordinatorTime(end), attrs...)
+}
+
+// marks the consensus phases of the blocks from the events of the node
+func (tr *Tracer) subscribe(nodeCtx *NodeCtx) {
+	if tr == nil {
+		return
+	}
+	nodeCtx.events.subscribe(func(e Event) {
+		switch d := e.Data.(type) {
+		case VoteReceivedEvent:
+			if d.Tag == "propose" {
+				tr.mark(d.GossipHash, "propose")
+			}
+		case VoteSentEvent:
+			// the echos of the block are in when the node sends its accept
+			if d.Tag == "accept" {
+				tr.mark(d.GossipHash, "echo")
+			}
+		}
+	})
+}
+
+// the msg of a routed tx with the tx in a new span of the hop, so the next hop is its child, the span
+// and its parent
+func traceRouting(nodeCtx *NodeCtx, msg Msg) (Msg, TraceContext, TraceContext) {
+	var t Transaction
+	switch tx := msg.Msg.(type) {
+	case Transaction:
+		t = tx
+	case *Transaction:
+		t = *tx
+	default:
+		return msg, TraceContext{}, TraceContext{}
+	}
+	parent := t.Trace
+	if nodeCtx.tracer == nil || !parent.traced() {
+		return msg, parent, parent
+	}
+	// a copy, the tx of a cross-tx is in a block
+	t.Trace = nodeCtx.tracer.child(parent)
+	if _, ok := msg.Msg.(*Transaction); ok {
+		msg.Msg = &t
+	} else {
+		msg.Msg = t
+	}
+	return msg, t.Trace, parent
+}
+
+// marks the start of a consensus phase of the block with gossip hash h
+func (tr *Tracer) mark(h [32]byte, phase string) {
+	if tr == nil {
+		return
+	}
+	tr.mux.Lock()
+	defer tr.mux.Unlock()
+	now := clockNow()
+	for other, phases := range tr.phases {
+		if now.Sub(phases["propose"]) > metricStartTimeout*time.Second {
+			delete(tr.phases, other)
+		}
+	}
+	if _, ok := tr.phases[h]; !ok {
+		tr.phases[h] = make(map[string]time.Time)
+	}
+	if _, ok := tr.phases[h][phase]; !ok {
+		tr.phases[h][phase] = now
+	}
+}
+
+// the marks of the block with gossip hash h, which are forgotten
+func (tr *Tracer) take(h [32]byte) map[string]time.Time {
+	if tr == nil {
+		return nil
+	}
+	tr.mux.Lock()
+	defer tr.mux.Unlock()
+	phases := tr.phases[h]
+	delete(tr.phases, h)
+	return phases
+}
+
+// the spans of the leader for the traced txs of block, taken from the pool at start
+func traceProposal(nodeCtx *NodeCtx, block *ProposedBlock, start time.Time) {
+	if nodeCtx.tracer == nil {
+		return
+	}
+	now := clockNow()
+	iteration := strconv.FormatUint(uint64(block.Iteration), 10)
+	for _, t := range block.Transactions {
+		if !t.Trace.traced() {
+			continue
+		}
+		if !t.pooled.IsZero() {
+			traceSpan(nodeCtx, nodeCtx.tracer.child(t.Trace), t.Trace, "mempool", t.pooled, start)
+		}
+		traceSpan(nodeCtx, nodeCtx.tracer.child(t.Trace), t.Trace, "proposal", start, now, "rapidchain.iteration", iteration, "rapidchain.txs", strconv.Itoa(len(block.Transactions)))
+	}
+}
+
+// the consensus and final block spans of the leader for the traced txs of block, the phases are the
+// marks of its consensus
+func traceFinalBlock(nodeCtx *NodeCtx, block *ProposedBlock, phases map[string]time.Time, accepted time.Time) {
+	if nodeCtx.tracer == nil {
+		return
+	}
+	now := clockNow()
+	iteration := strconv.FormatUint(uint64(block.Iteration), 10)
+	hash := bytes32ToString(block.GossipHash)
+	propose, proposed := phases["propose"]
+	echo, echoed := phases["echo"]
+	for _, t := range block.Transactions {
+		if !t.Trace.traced() {
+			continue
+		}
+		if proposed {
+			consensus := nodeCtx.tracer.child(t.Trace)
+			traceSpan(nodeCtx, consensus, t.Trace, "consensus", propose, accepted, "rapidchain.iteration", iteration, "rapidchain.block", hash)
+			if echoed {
+				traceSpan(nodeCtx, nodeCtx.tracer.child(consensus), consensus, "echo", propose, echo)
+				traceSpan(nodeCtx, nodeCtx.tracer.child(consensus), consensus, "accept", echo, accepted)
+			}
+		}
+		traceSpan(nodeCtx, nodeCtx.tracer.child(t.Trace), t.Trace, "final_block", accepted, now, "rapidchain.iteration", iteration, "rapidchain.block", hash)
+	}
+}
+
+// the otlp json encoding, ids are hex and times unix nanoseconds in strings
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpAttributes(kv []string) []otlpAttribute {
+	attrs := []otlpAttribute{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		attrs = append(attrs, otlpAttribute{kv[i], otlpValue{kv[i+1]}})
+	}
+	return attrs
+}
+
+func (tr *Tracer) encode(spans []Span) []byte {
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "rapidchain"
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.Ctx.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.Ctx.SpanID[:]),
+			Name:              s.Name,
+			Kind:              1, // internal
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attrs),
+		}
+		if s.Parent != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.Parent[:])
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	resource.Resource.Attributes = otlpAttributes(tr.resource)
+	b, err := json.Marshal(otlpTraces{[]otlpResourceSpans{resource}})
+	ifErrFatal(err, "otlp encode")
+	return b
+}
+
+// posts the recorded spans to the collector every default_traceFlush seconds, spans the collector
+// does not take are dropped
+func (tr *Tracer) export() {
+	client := &http.Client{Timeout: default_traceTimeout * time.Second}
+	for {
+		clockSleep(default_traceFlush * time.Second)
+		tr.mux.Lock()
+		spans, dropped := tr.spans, tr.dropped
+		tr.spans, tr.dropped = nil, 0
+		tr.mux.Unlock()
+		if dropped > 0 {
+			nodeLog.warnf(nil, "[Trace] dropped %d spans, more than %d in %d seconds", dropped, default_traceBuffer, default_traceFlush)
+		}
+		if len(spans) == 0 {
+			continue
+		}
+		resp, err := client.Post(tr.collector, "application/json", bytes.NewReader(tr.encode(spans)))
+		if err != nil {
+			nodeLog.warnf(nil, "[Trace] export of %d spans: %s", len(spans), err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			nodeLog.warnf(nil, "[Trace] export of %d spans: %s", len(spans), resp.Status)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// /tune, parameters changed during a run
+
+const tuneAtBlock = "block"
+const tuneAtEpoch = "epoch"
+
+type Tuning struct {
+	ID     uint
+	At     string  // tuneAtBlock or tuneAtEpoch
+	Tps    uint    // 0 keeps it
+	Delta  float64 // factor of -delta, 0 keeps it
+	Fanout uint    // 0 keeps it
+}
+
+func (t Tuning) row(kind string, node string, iteration uint) string {
+	return fmt.Sprintf("%s,%d,%s,%s,%d,%d,%g,%d", kind, t.ID, t.At, node, iteration, t.Tps, t.Delta, t.Fanout)
+}
+
+// the parameters of a node, the zero value is the one of its flags
+type NodeTuning struct {
+	deltaFactor float64
+	fanout      uint
+	pending     []Tuning
+	mux         sync.Mutex
+}
+
+// why tuning cannot be applied, nil if it can
+func (t Tuning) check() error {
+	if t.At != tuneAtBlock && t.At != tuneAtEpoch {
+		return fmt.Errorf("unknown at %q, give at=block or epoch", t.At)
+	}
+	if t.Delta < 0 || math.IsNaN(t.Delta) || math.IsInf(t.Delta, 0) {
+		return fmt.Errorf("delta %g is not a positive factor", t.Delta)
+	}
+	// every neighbour gets at least a chunk
+	if t.Fanout > default_kappa+default_parity {
+		return fmt.Errorf("fanout %d is more than the %d chunks of ida", t.Fanout, default_kappa+default_parity)
+	}
+	return nil
+}
+
+func (t *NodeTuning) add(nodeCtx *NodeCtx, tuning Tuning) {
+	if err := tuning.check(); err != nil {
+		nodeLog.warnf(nodeCtx, "[Tuning] dropped %d: %v", tuning.ID, err)
+		return
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.pending = append(t.pending, tuning)
+	nodeLog.infof(nodeCtx, "[Tuning] %d at the next %s", tuning.ID, tuning.At)
+}
+
+// applies the changes due at the boundary at
+func (t *NodeTuning) boundary(nodeCtx *NodeCtx, at string) {
+	t.mux.Lock()
+	applied := []Tuning{}
+	pending := t.pending[:0]
+	for _, tuning := range t.pending {
+		if tuning.At != at {
+			pending = append(pending, tuning)
+			continue
+		}
+		if tuning.Delta != 0 {
+			t.deltaFactor = tuning.Delta
+		}
+		if tuning.Fanout != 0 {
+			t.fanout = tuning.Fanout
+		}
+		applied = append(applied, tuning)
+	}
+	t.pending = pending
+	t.mux.Unlock()
+	i := nodeCtx.i.getI()
+	for _, tuning := range applied {
+		if tuning.Fanout != 0 {
+			buildCurrentNeighbours(nodeCtx)
+		}
+		nodeLog.infof(nodeCtx, "[Tuning] applied %d at iteration %d, delta %s, %d neighbours", tuning.ID, i, nodeCtx.delta(), len(nodeCtx.neighborAddrs()))
+		spawnDialAndSendToCoordinator("tuning", tuning.row("applied", bytes32ToString(nodeCtx.self.Priv.Pub.Bytes), i))
+	}
+}
+
+// the gossip neighbours of the node, 0 for log2 of its committee
+func (t *NodeTuning) getFanout() uint {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.fanout
+}
+
+// -delta of the node with the factor of its tuning
+func (nc *NodeCtx) delta() time.Duration {
+	nc.tuning.mux.Lock()
+	factor := nc.tuning.deltaFactor
+	nc.tuning.mux.Unlock()
+	d := time.Duration(nc.flagArgs.delta) * time.Millisecond
+	if factor == 0 {
+		return d
+	}
+	return time.Duration(float64(d) * factor)
+}
+
+// the changes the coordinator sent, for its tx generator and its watchdog
+type CoordinatorTuning struct {
+	f           *os.File
+	generating  bool // the tx generator runs, not with -tps 0
+	sent        uint
+	pending     []Tuning
+	tps         uint // due for the tx generator, 0 if none is
+	deltaFactor float64
+	mux         sync.Mutex
+}
+
+var coordinatorTuning CoordinatorTuning
+
+func (c *CoordinatorTuning) init(flagArgs *FlagArgs, f *os.File) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.f = f
+	c.generating = flagArgs.tps > 0
+}
+
+// applies the tps due at the boundary at, iteration is the one of the final block
+func (c *CoordinatorTuning) boundary(at string, iteration uint) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	pending := c.pending[:0]
+	for _, tuning := range c.pending {
+		if tuning.At != at {
+			pending = append(pending, tuning)
+			continue
+		}
+		c.tps = tuning.Tps
+		writeStringToFile(tuning.row("applied", "coordinator", iteration), c.f)
+	}
+	c.pending = pending
+}
+
+// the tps the tx generator changes to, 0 if it stays
+func (c *CoordinatorTuning) takeTps() uint {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	tps := c.tps
+	c.tps = 0
+	return tps
+}
+
+// -delta of the nodes with the last factor the coordinator sent
+func (c *CoordinatorTuning) delta(flagArgs *FlagArgs) time.Duration {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	d := time.Duration(flagArgs.delta) * time.Millisecond
+	if c.deltaFactor == 0 {
+		return d
+	}
+	return time.Duration(float64(d) * c.deltaFactor)
+}
+
+func tuningFromQuery(r *http.Request, generating bool) (Tuning, error) {
+	q := r.URL.Query()
+	t := Tuning{At: q.Get("at")}
+	if t.At == "" {
+		t.At = tuneAtBlock
+	}
+	for name, value := range map[string]*uint{"tps": &t.Tps, "fanout": &t.Fanout} {
+		if q.Get(name) == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(q.Get(name), 10, 64)
+		if err != nil || n == 0 {
+			return t, fmt.Errorf("%s %q is not a positive number", name, q.Get(name))
+		}
+		*value = uint(n)
+	}
+	if q.Get("delta") != "" {
+		f, err := strconv.ParseFloat(q.Get("delta"), 64)
+		if err != nil || !(f > 0) {
+			return t, fmt.Errorf("delta %q is not a positive factor", q.Get("delta"))
+		}
+		t.Delta = f
+	}
+	if t.Tps == 0 && t.Delta == 0 && t.Fanout == 0 {
+		return t, fmt.Errorf("nothing to change, give tps, delta or fanout")
+	}
+	if t.Tps != 0 && !generating {
+		return t, fmt.Errorf("the run has no tx generator to change, it runs with -tps 0")
+	}
+	return t, t.check()
+}
+
+// sends the change of the query to every node
+func serveTuning(ms *Membership, w http.ResponseWriter, r *http.Request) {
+	c := &coordinatorTuning
+	c.mux.Lock()
+	t, err := tuningFromQuery(r, c.generating)
+	if err != nil {
+		c.mux.Unlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.sent++
+	t.ID = c.sent
+	if t.Tps != 0 {
+		c.pending = append(c.pending, t)
+	}
+	if t.Delta != 0 {
+		c.deltaFactor = t.Delta
+	}
+	writeStringToFile(t.row("sent", "-", 0), c.f)
+	c.mux.Unlock()
+
+	nodes := ms.selectNodes([]string{"all"})
+	if t.Delta != 0 || t.Fanout != 0 {
+		for _, info := range nodes {
+			spawnDialAndSend(info.IP, withControlToken(info.Pub, Msg{"tune", t, nil}))
+		}
+	}
+	coordinatorLog.infof(nil, "[Tuning] %d sent to %d nodes: tps %d, delta %g, fanout %d at the next %s", t.ID, len(nodes), t.Tps, t.Delta, t.Fanout, t.At)
+	fmt.Fprintf(w, "tuning %d sent to %d nodes\n", t.ID, len(nodes))
+}
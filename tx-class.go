@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Transaction classes set by the tx generator. The class is carried in the transaction trough
+// consensus and back to the coordinator in the final block, so results can be broken down per class.
+const (
+	txClassIntra = "intra" // all inputs belong to the committee of the transaction
+	txClassCross = "cross" // one or more inputs belong to another committee
+	txClassHot   = "hot"   // sent from one of the hot accounts
+	txClassLarge = "large" // many inputs
+)
+
+// classifies a generated transaction. Large and hot takes precedence over intra/cross
+func classifyTx(nodeCtx *NodeCtx, t *Transaction, senderIndex int, flagArgs *FlagArgs) string {
+	if len(t.Inputs) >= default_largeTxInputs {
+		return txClassLarge
+	}
+	if senderIndex < hotAccounts(flagArgs) {
+		return txClassHot
+	}
+	target := txFindClosestCommittee(nodeCtx, t.Hash)
+	for _, inp := range t.Inputs {
+		if txFindClosestCommittee(nodeCtx, inp.TxHash) != target {
+			return txClassCross
+		}
+	}
+	return txClassIntra
+}
+
+// the first nUsers/default_hotAccountDivisor users are hot accounts
+func hotAccounts(flagArgs *FlagArgs) int {
+	h := int(flagArgs.nUsers / default_hotAccountDivisor)
+	if h < 1 {
+		h = 1
+	}
+	return h
+}
+
+type txClassResult struct {
+	count    uint64
+	totalDur time.Duration
+	maxDur   time.Duration
+}
+
+// Per class latency/throughput aggregation on the coordinator
+type TxClassStats struct {
+	m     map[string]*txClassResult
+	start time.Time
+	mux   sync.Mutex
+}
+
+func (s *TxClassStats) init() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.m = make(map[string]*txClassResult)
+	s.start = clockNow()
+}
+
+func (s *TxClassStats) add(class string, dur time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if class == "" {
+		class = "unknown"
+	}
+	r, ok := s.m[class]
+	if !ok {
+		r = new(txClassResult)
+		s.m[class] = r
+	}
+	r.count++
+	r.totalDur += dur
+	if dur > r.maxDur {
+		r.maxDur = dur
+	}
+}
+
+// writes one row per class: class, count, tps, mean latency, max latency
+func (s *TxClassStats) writeSummary(f *os.File) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	elapsed := clockSince(s.start).Seconds()
+	classes := make([]string, 0, len(s.m))
+	for c := range s.m {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+	for _, c := range classes {
+		r := s.m[c]
+		mean := r.totalDur / time.Duration(r.count)
+		writeStringToFile(fmt.Sprintf("%s,%d,%.4f,%.4f,%.4f", c, r.count, float64(r.count)/elapsed, mean.Seconds(), r.maxDur.Seconds()), f)
+	}
+}
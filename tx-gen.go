@@ -1,8 +1,6 @@
 package main
 
 import (
-	"fmt"
-	"log"
 	"math/rand"
 	"os"
 	"strconv"
@@ -85,6 +83,7 @@ func genGenesisBlock(flagArgs *FlagArgs, committeeInfos []committeeInfo, users *
 
 type Tracker struct {
 	t         *Transaction
+	class     string
 	sent      time.Time
 	recived   time.Time
 	dur       time.Duration
@@ -92,13 +91,13 @@ type Tracker struct {
 }
 
 func (t *Tracker) completeTx(files []*os.File) {
-	t.recived = time.Now()
+	t.recived = clockNow()
 	t.dur = t.recived.Sub(t.sent)
 
 	dur := strconv.FormatFloat(t.dur.Seconds(), 'f', 4, 64)
 	cross := strconv.FormatUint(t.crossTxes, 10)
 
-	s := prepareResultString(dur + "," + cross)
+	s := prepareResultString(dur + "," + cross + "," + t.class)
 
 	files[0].WriteString(s)
 	files[0].Sync()
@@ -114,13 +113,19 @@ type TransactionTracker struct {
 	mux sync.Mutex
 }
 
-func txGenerator(flagArgs *FlagArgs, allNodes []NodeAllInfo, users *[]PrivKey, gensisBlocks []*FinalBlock, finalBlockChan chan FinalBlock, files []*os.File) {
+func txGenerator(flagArgs *FlagArgs, allNodes []NodeAllInfo, users *[]PrivKey, gensisBlocks []*FinalBlock, finalBlockChan *Queue, files []*os.File, progress *Progress) {
 	// Emulates users by continously generating transactions
 
 	if flagArgs.tps == 0 {
 		return
 	}
 
+	ramp := new(TpsRamp)
+	ramp.init(flagArgs)
+
+	classStats := new(TxClassStats)
+	classStats.init()
+
 	// make a UTXO set for each user, such that we can easily look up UTXO for each user
 	userSets := new(UserSets)
 	userSets.m = make(map[[32]byte]*UTXOSet)
@@ -153,30 +158,36 @@ func txGenerator(flagArgs *FlagArgs, allNodes []NodeAllInfo, users *[]PrivKey, g
 
 	transactionTracker := new(TransactionTracker)
 	transactionTracker.m = make(map[[32]byte]*Tracker)
+	integration.generator(userSets, transactionTracker)
+
+	// traces of -traceSample of the txs with -traceCollector
+	tracer := newTracer(flagArgs, "coordinator", "-")
 
 	if flagArgs.local {
-		time.Sleep(1 * time.Second) // 网络延时？
+		clockSleep(1 * time.Second) // 网络延时？
 	} else {
-		time.Sleep(3 * time.Second)
+		clockSleep(3 * time.Second)
 	}
-	log.Println("starting tx-gen")
+	coordinatorLog.infof(nil, "starting tx-gen")
 	rand.Seed(42)
 	for {
-		before := time.Now()
+		before := clockNow()
 
-		l := len(finalBlockChan)
+		l := finalBlockChan.len()
+		completed := 0
 		for i := 0; i < l; i++ {
-			fmt.Println("Recived finalblock")
-			finalBlock := <-finalBlockChan
-			fmt.Println(finalBlock.ProposedBlock)
+			coordinatorLog.debugf(nil, "Recived finalblock")
+			finalBlock := finalBlockChan.recv().(FinalBlock)
+			coordinatorTuning.boundary(tuneAtBlock, finalBlock.ProposedBlock.Iteration)
+			coordinatorLog.debugf(nil, "%v", finalBlock.ProposedBlock)
 			for _, t := range finalBlock.ProposedBlock.Transactions {
 				if t.Hash == [32]byte{} && t.OrigTxHash != [32]byte{} && t.Outputs == nil {
-					fmt.Println("crosstx")
+					coordinatorLog.debugf(nil, "crosstx")
 					// return "crosstx"
 					transactionTracker.mux.Lock()
 					if _, ok := transactionTracker.m[t.OrigTxHash]; !ok {
-						fmt.Println("T: ", t)
-						fmt.Println("Tracker: ", transactionTracker.m[t.OrigTxHash])
+						coordinatorLog.debugf(nil, "T: %v", t)
+						coordinatorLog.debugf(nil, "Tracker: %v", transactionTracker.m[t.OrigTxHash])
 						errFatal(nil, "transaction in recived finalblock not in transactionTracker")
 					}
 
@@ -186,27 +197,36 @@ func txGenerator(flagArgs *FlagArgs, allNodes []NodeAllInfo, users *[]PrivKey, g
 					continue
 				} else if t.Hash == [32]byte{} && t.OrigTxHash != [32]byte{} && t.Outputs != nil {
 					// return "originaltx"
-					fmt.Println("originaltx")
+					coordinatorLog.debugf(nil, "originaltx")
 					continue
 				} else if t.Hash != [32]byte{} && t.OrigTxHash != [32]byte{} && txFindClosestCommittee(nodeCtx, t.OrigTxHash) != finalBlock.ProposedBlock.CommitteeID {
 					// return "crosstxresponse"
-					fmt.Println("crosstxresponse_C_in")
+					coordinatorLog.debugf(nil, "crosstxresponse_C_in")
 					continue
 				} else if t.Hash != [32]byte{} && t.OrigTxHash != [32]byte{} && t.ProofOfConsensus != nil {
 					// TODO ADD crosstxresponse_C_out or not
-					fmt.Println("crosstxresponse_C_out")
+					coordinatorLog.debugf(nil, "crosstxresponse_C_out")
 					continue
 				}
 
 				id := t.ifOrigRetOrigIfNotRetHash()
 				transactionTracker.mux.Lock()
 				if _, ok := transactionTracker.m[id]; !ok {
-					fmt.Println("id", id)
-					fmt.Println("T: ", t)
-					fmt.Println("Tracker: ", transactionTracker.m[id])
+					coordinatorLog.debugf(nil, "id %v", id)
+					coordinatorLog.debugf(nil, "T: %v", t)
+					coordinatorLog.debugf(nil, "Tracker: %v", transactionTracker.m[id])
 					errFatal(nil, "transaction in recived finalblock not in transactionTracker")
 				}
+				if t.Class != "" {
+					// class carried trough the final block
+					transactionTracker.m[id].class = t.Class
+				}
 				transactionTracker.m[id].completeTx(files)
+				track := transactionTracker.m[id]
+				tracer.record(track.t.Trace, TraceContext{}, "tx", track.sent, track.recived, "rapidchain.class", track.class, "rapidchain.crosstxes", strconv.FormatUint(track.crossTxes, 10))
+				ramp.addSample(transactionTracker.m[id].dur)
+				classStats.add(transactionTracker.m[id].class, transactionTracker.m[id].dur)
+				completed++
 
 				var normalorfinal string
 				if t.Hash != [32]byte{} && t.OrigTxHash == [32]byte{} {
@@ -217,7 +237,7 @@ func txGenerator(flagArgs *FlagArgs, allNodes []NodeAllInfo, users *[]PrivKey, g
 					errFatal(nil, t.String())
 				}
 
-				log.Println(normalorfinal, " tx finished in ", transactionTracker.m[id].dur.Seconds(), " seconds, with ", transactionTracker.m[id].crossTxes, " crosstxes.")
+				coordinatorLog.infof(nil, "%v tx finished in %v seconds, with %v crosstxes.", normalorfinal, transactionTracker.m[id].dur.Seconds(), transactionTracker.m[id].crossTxes)
 				transactionTracker.mux.Unlock()
 
 				userSets.mux.Lock()
@@ -229,21 +249,32 @@ func txGenerator(flagArgs *FlagArgs, allNodes []NodeAllInfo, users *[]PrivKey, g
 			}
 		}
 
-		after := time.Now()
+		if completed > 0 {
+			classStats.writeSummary(files[7])
+		}
+		progress.finished(completed, ramp.getTps())
 
-		go _txGenerator(flagArgs, &allNodes, users, userSets, transactionTracker)
+		ramp.update(files[6])
+		if tps := coordinatorTuning.takeTps(); tps != 0 {
+			ramp.setTps(tps)
+			coordinatorLog.infof(nil, "[Tuning] tx generator at %d tps", tps)
+		}
+
+		after := clockNow()
+
+		spawn(func() { _txGenerator(flagArgs, nodeCtx, &allNodes, users, userSets, transactionTracker, tracer) })
 
 		// Sleep such that time used to process finishedblock and create new tx is subtracted such that we emulate near perfect tps.
 		// fmt.Println("Sleep for: ", (time.Second/time.Duration(flagArgs.tps))-after.Sub(before))
-		dur := (time.Second / time.Duration(flagArgs.tps)) - after.Sub(before)
+		dur := (time.Second / time.Duration(ramp.getTps())) - after.Sub(before)
 		// log.Println("sleeping for ", dur)
 		if dur > 0 {
-			time.Sleep(dur)
+			clockSleep(dur)
 		}
 	}
 }
 
-func _txGenerator(flagArgs *FlagArgs, allNodes *[]NodeAllInfo, users *[]PrivKey, userSets *UserSets, transactionTracker *TransactionTracker) {
+func _txGenerator(flagArgs *FlagArgs, nodeCtx *NodeCtx, allNodes *[]NodeAllInfo, users *[]PrivKey, userSets *UserSets, transactionTracker *TransactionTracker, tracer *Tracer) {
 
 	// pick random user to send transaction from
 	rnd := rand.Intn(len(*users))
@@ -265,7 +296,7 @@ func _txGenerator(flagArgs *FlagArgs, allNodes *[]NodeAllInfo, users *[]PrivKey,
 			userSets.mux.Lock()
 			totVal = userSets.m[user.Pub.Bytes]._totalValue()
 			userSets.mux.Unlock()
-			time.Sleep(10 * time.Millisecond)
+			clockSleep(10 * time.Millisecond)
 			timeout++
 			if timeout >= 10 {
 				return
@@ -342,6 +373,10 @@ func _txGenerator(flagArgs *FlagArgs, allNodes *[]NodeAllInfo, users *[]PrivKey,
 	t.Inputs = inputs
 	t.Outputs = txOutputs
 	t.setHash()
+	t.Class = classifyTx(nodeCtx, t, rnd, flagArgs)
+	if tracer.sampled() {
+		t.Trace = newTrace()
+	}
 	t.signInputs(&user)
 
 	// fmt.Println("newTx", bytes32ToString(t.Hash), bytes32ToString(t.OrigTxHash), bytes32ToString(t.id()))
@@ -354,20 +389,21 @@ func _txGenerator(flagArgs *FlagArgs, allNodes *[]NodeAllInfo, users *[]PrivKey,
 	rndNode := rand.Intn(len(*allNodes))
 	node := (*allNodes)[rndNode]
 
-	// send transaction
+	// send transaction, the node may have shut down (shutdown.go) and the tx is lost like with churn
 	msg := Msg{"transaction", t, user.Pub}
-	go dialAndSend(node.IP, msg)
+	spawnDialAndSendIfUp(node.IP, msg)
 
 	transactionTracker.mux.Lock()
 	if _, ok := transactionTracker.m[t.Hash]; ok {
-		fmt.Println("Previous tx: ", transactionTracker.m[t.Hash])
-		fmt.Println("New tx: ", t)
+		coordinatorLog.debugf(nil, "Previous tx: %v", transactionTracker.m[t.Hash])
+		coordinatorLog.debugf(nil, "New tx: %v", t)
 		transactionTracker.mux.Unlock()
 		errFatal(nil, "transaction allready sent")
 	}
 	track := new(Tracker)
 	track.t = t
-	track.sent = time.Now()
+	track.class = t.Class
+	track.sent = clockNow()
 	transactionTracker.m[t.Hash] = track
 	transactionTracker.mux.Unlock()
 
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// verification of many signatures on a pool of workers
+
+type SignedItem struct {
+	Pub  *PubKey
+	Hash [32]byte
+	Sig  *Sig
+}
+
+// returns whether every item is valid and the result per item. With abortEarly the workers stop at
+// the first invalid item, items that were not verified are reported invalid
+func VerifyBatch(items []SignedItem, workers uint, abortEarly bool) (bool, []bool) {
+	valid := make([]bool, len(items))
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > uint(len(items)) {
+		workers = uint(len(items))
+	}
+	var next int64 = -1
+	var failed int32
+	var wg sync.WaitGroup
+	wg.Add(int(workers))
+	for w := uint(0); w < workers; w++ {
+		go func() {
+			defer recordPanic()
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(items) || (abortEarly && atomic.LoadInt32(&failed) != 0) {
+					return
+				}
+				it := items[i]
+				valid[i] = it.Pub != nil && it.Pub.verify(it.Hash, it.Sig)
+				if !valid[i] {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return failed == 0, valid
+}
+
+func consensusMsgItems(cMsgs []*ConsensusMsg) []SignedItem {
+	items := make([]SignedItem, len(cMsgs))
+	for i, cMsg := range cMsgs {
+		items[i] = SignedItem{cMsg.Pub, cMsg.calculateHash(), cMsg.Sig}
+	}
+	return items
+}
@@ -15,6 +15,9 @@ import math
 
 RESULT_FOLDER = "results/10tps4000delta8m/"
 
+# the timestamps of the results are unix nanoseconds
+NANOSECONDS = 1e9
+
 def genCrossTx(data):
     # Count numer of transactions grouped by crosstxes
     crosstxes = defaultdict(int)
@@ -159,11 +162,11 @@ def ida():
     avgtime = list()
     timetocompletion = list()
     for _, row in data.iterrows():
-        average = (row[2:] - row[1]).mean()
+        average = (row[2:] - row[1]).mean() / NANOSECONDS
         average = np.abs(average)
         avgtime.append(average)
 
-        tim = row[2:] - row[1]
+        tim = (row[2:] - row[1]) / NANOSECONDS
         for t in tim:
             if np.isnan(t):
                 continue
@@ -183,7 +186,7 @@ def ida():
     ax[0].set_ylim(ymin=0.0)
 
     print(int(max(timetocompletion)))
-    ax[1].hist(timetocompletion, bins = int(max(timetocompletion)), edgecolor = 'black')
+    ax[1].hist(timetocompletion, bins = max(int(max(timetocompletion)), 1), edgecolor = 'black')
     # ax[1].set_xticks([i for i in range(55) if i % 2 == 0])
     ax[1].xaxis.set_major_locator(ticker.MultipleLocator(4))
 
@@ -211,7 +214,7 @@ def routing():
     # ax[1].set_ylabel("Amount of IDA messages", labelpad=15)
     # ax[1].set_xlabel('Seconds to successfully recover IDA message', labelpad=10)
 
-    difference = data[data["start"] != 0]["end"] - data[data["start"] != 0]["start"]  
+    difference = (data[data["start"] != 0]["end"] - data[data["start"] != 0]["start"]) / NANOSECONDS
 
     for _, dif in difference.iteritems():
         if dif != 0:
@@ -326,7 +329,7 @@ def routing():
     # ax[1].set_ylabel("Amount of IDA messages", labelpad=15)
     # ax[1].set_xlabel('Seconds to successfully recover IDA message', labelpad=10)
 
-    difference = data[data["start"] != 0]["end"] - data[data["start"] != 0]["start"]  
+    difference = (data[data["start"] != 0]["end"] - data[data["start"] != 0]["start"]) / NANOSECONDS
 
     for _, dif in difference.iteritems():
         if dif != 0:
@@ -338,6 +341,30 @@ def routing():
 
 
 
+def phases():
+    file = RESULT_FOLDER + "phases.csv"
+    names = ["timestamp", "committee", "iteration", "block", "txs", "echos", "accepts",
+             "proposal", "propose", "echo", "pending", "accept", "certificate", "total"]
+    data = pd.read_csv(file, header=None, names=names)
+    phasenames = names[7:13]
+
+    # mean seconds of every phase per committee, phases the leader did not see are -1
+    means = pd.DataFrame({p: data[data[p] >= 0].groupby("committee")[p].mean() / NANOSECONDS for p in phasenames})
+    means.index = [c[:8] for c in means.index]
+    print(means)
+
+    fig, ax = plt.subplots(nrows=1, ncols=1, figsize=(10,10))
+    ax.set_ylabel("Seconds from creating a block to its final block", labelpad=10)
+    ax.set_xlabel("Committee", labelpad=10)
+    bottom = np.zeros(len(means))
+    for p in phasenames:
+        ax.bar(means.index, means[p], bottom=bottom, label=p, edgecolor='black')
+        bottom += means[p].fillna(0).values
+    ax.legend()
+
+    plt.show()
+
+
 def main():
 
     mpl.rcParams['axes.labelsize'] = 22
@@ -349,6 +376,7 @@ def main():
  
     #ida(
     #routing()
+    #phases()
 
     emulatepoc()
 
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"sync"
+
+	bls12381 "github.com/kilic/bls12-381"
+)
+
+// -vrf, leader election by a verifiable random function
+
+const (
+	vrfSuite     = 0x01
+	vrfCLen      = 16
+	vrfProofSize = 33 + vrfCLen + 32
+)
+
+var blsVrfDomain = []byte("BLS_VRF_BLS12381G2_XMD:SHA-256_SSWU_RO_")
+
+func ecvrfPoint(x, y *big.Int) []byte {
+	return elliptic.MarshalCompressed(eCurve, x, y)
+}
+
+// ECVRF_encode_to_curve_try_and_increment
+func ecvrfHashToCurve(pk []byte, alpha []byte) (*big.Int, *big.Int) {
+	for ctr := 0; ctr < 256; ctr++ {
+		h := sha256.Sum256(byteSliceAppend([]byte{vrfSuite, 0x01}, pk, alpha, []byte{byte(ctr), 0x00}))
+		x, y := elliptic.UnmarshalCompressed(eCurve, append([]byte{0x02}, h[:]...))
+		if x != nil {
+			return x, y
+		}
+	}
+	errFatal(nil, "vrf hash to curve failed")
+	return nil, nil
+}
+
+func ecvrfChallenge(points ...[]byte) *big.Int {
+	h := sha256.Sum256(byteSliceAppend([]byte{vrfSuite, 0x02}, byteSliceAppend(points...), []byte{0x00}))
+	return new(big.Int).SetBytes(h[:vrfCLen])
+}
+
+func ecvrfProofToHash(gamma []byte) [32]byte {
+	return sha256.Sum256(byteSliceAppend([]byte{vrfSuite, 0x03}, gamma, []byte{0x00}))
+}
+
+func ecvrfProve(priv *ecdsa.PrivateKey, alpha []byte) []byte {
+	q := eCurve.Params().N
+	pk := ecvrfPoint(priv.X, priv.Y)
+	hx, hy := ecvrfHashToCurve(pk, alpha)
+	h := ecvrfPoint(hx, hy)
+	gamma := ecvrfPoint(eCurve.ScalarMult(hx, hy, priv.D.Bytes()))
+
+	nonce := sha512.Sum512(byteSliceAppend(priv.D.FillBytes(make([]byte, 32)), h))
+	k := new(big.Int).Mod(new(big.Int).SetBytes(nonce[:]), q)
+	u := ecvrfPoint(eCurve.ScalarBaseMult(k.Bytes()))
+	v := ecvrfPoint(eCurve.ScalarMult(hx, hy, k.Bytes()))
+
+	c := ecvrfChallenge(pk, h, gamma, u, v)
+	s := new(big.Int).Mul(c, priv.D)
+	s.Add(s, k).Mod(s, q)
+	return byteSliceAppend(gamma, c.FillBytes(make([]byte, vrfCLen)), s.FillBytes(make([]byte, 32)))
+}
+
+func ecvrfVerify(pub *ecdsa.PublicKey, alpha []byte, proof []byte) ([32]byte, bool) {
+	q := eCurve.Params().N
+	if len(proof) != vrfProofSize {
+		return [32]byte{}, false
+	}
+	gx, gy := elliptic.UnmarshalCompressed(eCurve, proof[:33])
+	if gx == nil {
+		return [32]byte{}, false
+	}
+	c := new(big.Int).SetBytes(proof[33 : 33+vrfCLen])
+	s := new(big.Int).SetBytes(proof[33+vrfCLen:])
+	if s.Cmp(q) >= 0 {
+		return [32]byte{}, false
+	}
+	pk := ecvrfPoint(pub.X, pub.Y)
+	hx, hy := ecvrfHashToCurve(pk, alpha)
+	negC := new(big.Int).Sub(q, c).Bytes()
+
+	// U = s*B - c*Y, V = s*H - c*Gamma
+	sx, sy := eCurve.ScalarBaseMult(s.Bytes())
+	cx, cy := eCurve.ScalarMult(pub.X, pub.Y, negC)
+	u := ecvrfPoint(eCurve.Add(sx, sy, cx, cy))
+	sx, sy = eCurve.ScalarMult(hx, hy, s.Bytes())
+	cx, cy = eCurve.ScalarMult(gx, gy, negC)
+	v := ecvrfPoint(eCurve.Add(sx, sy, cx, cy))
+
+	if ecvrfChallenge(pk, ecvrfPoint(hx, hy), proof[:33], u, v).Cmp(c) != 0 {
+		return [32]byte{}, false
+	}
+	return ecvrfProofToHash(proof[:33]), true
+}
+
+func (k *PrivKey) vrfProve(alpha []byte) ([]byte, error) {
+	if k.Bls != nil {
+		return blsSignDomain(k.Bls, alpha, blsVrfDomain), nil
+	}
+	if k.Priv != nil {
+		return ecvrfProve(k.Priv, alpha), nil
+	}
+	return nil, errors.New("no vrf for " + sigScheme + " keys")
+}
+
+// the output of the vrf if the proof is valid
+func (k *PubKey) vrfVerify(alpha []byte, proof []byte) ([32]byte, bool) {
+	if k.Bls != nil {
+		p, err := blsDecodePub(k.Bls)
+		if err != nil || !blsVerifyPairs([]*bls12381.PointG1{p}, [][]byte{alpha}, blsVrfDomain, proof) {
+			return [32]byte{}, false
+		}
+		return sha256.Sum256(proof), true
+	}
+	if k.Pub != nil {
+		return ecvrfVerify(k.Pub, alpha, proof)
+	}
+	return [32]byte{}, false
+}
+
+// the vrf message of an iteration, epoch randomness | iteration
+func vrfAlpha(nodeCtx *NodeCtx, iteration uint) []byte {
+	rnd := nodeCtx.blockchain.getLastReconfigurationBlock().Randomness
+	i := make([]byte, 8)
+	binary.LittleEndian.PutUint64(i, uint64(iteration))
+	return byteSliceAppend(rnd[:], i)
+}
+
+type VrfClaim struct {
+	Iteration uint
+	Pub       *PubKey
+	Proof     []byte
+}
+
+type vrfClaimEntry struct {
+	claim  *VrfClaim
+	output [32]byte
+}
+
+// verified claims of the members, per iteration
+type VrfClaims struct {
+	m   map[uint]map[[32]byte]vrfClaimEntry // iteration -> Pub.Bytes -> claim
+	mux sync.Mutex
+}
+
+func (v *VrfClaims) init() {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	v.m = make(map[uint]map[[32]byte]vrfClaimEntry)
+}
+
+func (v *VrfClaims) add(claim *VrfClaim, output [32]byte) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if _, ok := v.m[claim.Iteration]; !ok {
+		v.m[claim.Iteration] = make(map[[32]byte]vrfClaimEntry)
+	}
+	v.m[claim.Iteration][claim.Pub.Bytes] = vrfClaimEntry{claim, output}
+	// claims of old iterations are not needed anymore
+	delete(v.m, claim.Iteration-2)
+}
+
+func (v *VrfClaims) get(iteration uint, pub [32]byte) *VrfClaim {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	return v.m[iteration][pub].claim
+}
+
+// the claim with the lowest output in iteration
+func (v *VrfClaims) lowest(iteration uint) *VrfClaim {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	var low *vrfClaimEntry
+	for _, e := range v.m[iteration] {
+		e := e
+		if low == nil || byte32Operations(e.output, "<", low.output) {
+			low = &e
+		}
+	}
+	if low == nil {
+		return nil
+	}
+	return low.claim
+}
+
+// verifies the claim of a member (or self) and adds it, returns false if it is invalid
+func (v *VrfClaims) verifyAndAdd(nodeCtx *NodeCtx, claim *VrfClaim) bool {
+	if claim == nil || claim.Pub == nil {
+		return false
+	}
+	if _, ok := nodeCtx.committee.Members[claim.Pub.Bytes]; !ok && claim.Pub.Bytes != nodeCtx.self.Priv.Pub.Bytes {
+		return false
+	}
+	output, ok := claim.Pub.vrfVerify(vrfAlpha(nodeCtx, claim.Iteration), claim.Proof)
+	if !ok {
+		return false
+	}
+	v.add(claim, output)
+	return true
+}
+
+func (nodeCtx *NodeCtx) setLeader(pub [32]byte) {
+	if pub == nodeCtx.self.Priv.Pub.Bytes {
+		nodeCtx.setCurrentLeader(nodeCtx.self.Priv.Pub)
+		consensusLog.infof(nodeCtx, "I am leader! %v", nodeCtx.amILeader())
+	} else {
+		nodeCtx.setCurrentLeader(nodeCtx.committee.Members[pub].Pub)
+	}
+}
+
+// publishes the claim of this node and waits a delta for the others, the lowest output is leader
+func vrfLeaderElection(nodeCtx *NodeCtx) {
+	iteration := nodeCtx.i.getI()
+	proof, err := nodeCtx.self.Priv.vrfProve(vrfAlpha(nodeCtx, iteration))
+	if err != nil {
+		consensusLog.warnf(nodeCtx, "vrf leader election: %v using hash election", err)
+		leaderElection(nodeCtx)
+		return
+	}
+	claim := &VrfClaim{iteration, nodeCtx.self.Priv.Pub, proof}
+	if !nodeCtx.vrfClaims.verifyAndAdd(nodeCtx, claim) {
+		errFatal(nil, "own vrf proof invalid")
+	}
+	sendMsgToCommittee(Msg{"vrf_claim", *claim, nodeCtx.self.Priv.Pub}, &nodeCtx.committee)
+
+	clockSleep(nodeCtx.delta())
+	nodeCtx.setLeader(nodeCtx.vrfClaims.lowest(iteration).Pub.Bytes)
+}
+
+// checks the claim a proposal is sent with. The proposer must have the lowest output of the claims
+// we know, a claim that arrives with the proposal can still lower it
+func verifyLeaderClaim(nodeCtx *NodeCtx, cMsg *ConsensusMsg, block *ProposedBlock) bool {
+	claim := cMsg.Vrf
+	if claim == nil || claim.Pub == nil || claim.Pub.Bytes != cMsg.Pub.Bytes || claim.Iteration != block.Iteration {
+		return false
+	}
+	if !nodeCtx.vrfClaims.verifyAndAdd(nodeCtx, claim) {
+		return false
+	}
+	if nodeCtx.vrfClaims.lowest(claim.Iteration).Pub.Bytes != claim.Pub.Bytes {
+		return false
+	}
+	nodeCtx.setLeader(claim.Pub.Bytes)
+	return true
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// the write-ahead log of the votes of a node
+
+type WALRecord struct {
+	Tag                string // propose, echo, accept or accepted
+	Iteration          uint
+	GossipHash         [32]byte
+	PreviousGossipHash [32]byte // only for accepted
+}
+
+type walVoteKey struct {
+	tag       string
+	iteration uint
+}
+
+type ConsensusWAL struct {
+	f            *os.File
+	votes        map[walVoteKey][32]byte // vote -> gossip hash voted for
+	accepted     map[uint]WALRecord      // iteration -> accepted header
+	lastAccepted uint
+	hasAccepted  bool
+	sinceCompact uint
+	mux          sync.Mutex
+}
+
+func (w *ConsensusWAL) init() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.votes = make(map[walVoteKey][32]byte)
+	w.accepted = make(map[uint]WALRecord)
+	w.lastAccepted = 0
+	w.hasAccepted = false
+	w.sinceCompact = 0
+}
+
+func walPath(blockStorePath string) string {
+	return blockStorePath + ".wal"
+}
+
+// reads the log at path and keeps appending to it
+func (w *ConsensusWAL) open(path string) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	n := 0
+	for len(data) >= 4 {
+		l := int(binary.LittleEndian.Uint32(data[:4]))
+		if len(data) < 4+l {
+			// partial record from a crash while appending, the vote was never sent
+			break
+		}
+		rec := WALRecord{}
+		if err := gob.NewDecoder(bytes.NewReader(data[4 : 4+l])).Decode(&rec); err != nil {
+			consensusLog.warnf(nil, "[WAL] corrupt record %v", err)
+			break
+		}
+		w._apply(rec)
+		data = data[4+l:]
+		n++
+	}
+	if n > 0 {
+		consensusLog.infof(nil, "[WAL] loaded %d records from %s, last accepted iteration %d", n, path, w.lastAccepted)
+	}
+	w.f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	return w._compact()
+}
+
+func (w *ConsensusWAL) _apply(rec WALRecord) {
+	if rec.Tag == "accepted" {
+		w.accepted[rec.Iteration] = rec
+		if !w.hasAccepted || rec.Iteration > w.lastAccepted {
+			w.lastAccepted = rec.Iteration
+			w.hasAccepted = true
+		}
+		return
+	}
+	w.votes[walVoteKey{rec.Tag, rec.Iteration}] = rec.GossipHash
+}
+
+func (w *ConsensusWAL) _append(rec WALRecord) {
+	w._apply(rec)
+	if w.f == nil {
+		return
+	}
+	data := getBytes(rec)
+	var l [4]byte
+	binary.LittleEndian.PutUint32(l[:], uint32(len(data)))
+	_, err := w.f.Write(byteSliceAppend(l[:], data))
+	ifErrFatal(err, "writing wal")
+	ifErrFatal(w.f.Sync(), "wal sync")
+}
+
+func (w *ConsensusWAL) close() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.f == nil {
+		return
+	}
+	ifErr(w.f.Close(), "wal close")
+	w.f = nil
+}
+
+// drops everything from before the last accepted header, it is not needed to rejoin or to avoid
+// voting twice since those iterations are finished
+func (w *ConsensusWAL) _compact() error {
+	w.sinceCompact = 0
+	for k := range w.votes {
+		if w.hasAccepted && k.iteration < w.lastAccepted {
+			delete(w.votes, k)
+		}
+	}
+	for i := range w.accepted {
+		if i < w.lastAccepted {
+			delete(w.accepted, i)
+		}
+	}
+	if w.f == nil {
+		return nil
+	}
+
+	path := w.f.Name()
+	buf := new(bytes.Buffer)
+	records := []WALRecord{}
+	for _, rec := range w.accepted {
+		records = append(records, rec)
+	}
+	for k, gh := range w.votes {
+		records = append(records, WALRecord{k.tag, k.iteration, gh, [32]byte{}})
+	}
+	for _, rec := range records {
+		data := getBytes(rec)
+		var l [4]byte
+		binary.LittleEndian.PutUint32(l[:], uint32(len(data)))
+		buf.Write(byteSliceAppend(l[:], data))
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	w.f.Close()
+	w.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// records the vote and returns true, or returns false if we have allready voted for another
+// block with this tag in the iteration. Must be called before the vote is sent
+func (w *ConsensusWAL) vote(tag string, iteration uint, gossipHash [32]byte) bool {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if gh, ok := w.votes[walVoteKey{tag, iteration}]; ok {
+		if gh != gossipHash {
+			errr(nil, fmt.Sprintf("refusing %s on %s in iteration %d, allready voted for %s", tag, bytes32ToString(gossipHash), iteration, bytes32ToString(gh)))
+			return false
+		}
+		return true
+	}
+	w._append(WALRecord{tag, iteration, gossipHash, [32]byte{}})
+	return true
+}
+
+// records the header of a block added to the chain. Returns false if another block was accepted
+// in the same iteration before
+func (w *ConsensusWAL) accept(block *ProposedBlock) bool {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if rec, ok := w.accepted[block.Iteration]; ok {
+		if rec.GossipHash != block.GossipHash {
+			errr(nil, fmt.Sprintf("block %s in iteration %d differs from %s accepted before restart", bytes32ToString(block.GossipHash), block.Iteration, bytes32ToString(rec.GossipHash)))
+			return false
+		}
+		return true
+	}
+	w._append(WALRecord{"accepted", block.Iteration, block.GossipHash, block.PreviousGossipHash})
+	w.sinceCompact++
+	if w.sinceCompact >= default_walCompactEvery {
+		ifErr(w._compact(), "wal compaction")
+	}
+	return true
+}
+
+func (w *ConsensusWAL) getLastAccepted() (uint, bool) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.lastAccepted, w.hasAccepted
+}
+
+// a restarted node catches up to the last block it accepted before the restart, so it rejoins
+// the committee at the right iteration instead of starting over
+func rejoinFromWAL(nodeCtx *NodeCtx) {
+	last, ok := nodeCtx.wal.getLastAccepted()
+	if !ok || last < nodeCtx.i.getI() {
+		return
+	}
+	consensusLog.infof(nodeCtx, "[WAL] accepted iteration %d before restart, catching up from iteration %d", last, nodeCtx.i.getI())
+	for nodeCtx.i.getI() <= last {
+		requestAndAddMissingBlocks(nodeCtx)
+	}
+}
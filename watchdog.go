@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// -abortStuck and -abortSilent, the coordinator aborts a stuck run
+
+// the nodes that sent no stat for default_progressAlive seconds, the ones that never sent one are
+// silent once the watch ran that long
+func (p *Progress) _silent(now time.Time, started time.Time, nodes map[[32]byte]NodeAllInfo) map[[32]byte]time.Duration {
+	silent := make(map[[32]byte]time.Duration)
+	for pub := range nodes {
+		last, ok := p.seen[pub]
+		if !ok {
+			last = started
+		}
+		if now.Sub(last) > default_progressAlive*time.Second {
+			silent[pub] = now.Sub(last)
+		}
+	}
+	return silent
+}
+
+// why the run is stuck, empty if it is not
+func (p *Progress) stuck(started time.Time, nodes map[[32]byte]NodeAllInfo, flagArgs *FlagArgs) string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	now := clockNow()
+	if flagArgs.abortStuck != 0 {
+		last := started
+		for _, t := range p.lastBlocks {
+			if t.After(last) {
+				last = t
+			}
+		}
+		if now.Sub(last) > time.Duration(flagArgs.abortStuck)*coordinatorTuning.delta(flagArgs) {
+			return fmt.Sprintf("no final block for %s, more than %d deltas", now.Sub(last).Round(time.Second), flagArgs.abortStuck)
+		}
+	}
+	if flagArgs.abortSilent != 0 && len(nodes) > 0 {
+		silent := p._silent(now, started, nodes)
+		if float64(len(silent)) > flagArgs.abortSilent*float64(len(nodes)) {
+			return fmt.Sprintf("%d of %d nodes sent no stat for %d seconds, more than %.0f%%", len(silent), len(nodes), default_progressAlive, 100*flagArgs.abortSilent)
+		}
+	}
+	return ""
+}
+
+// the committees and silent nodes for the dump
+func (p *Progress) diagnose(started time.Time, nodes map[[32]byte]NodeAllInfo) string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	now := clockNow()
+	committees := make(map[[32]byte]bool)
+	for _, n := range nodes {
+		committees[n.CommitteeID] = true
+	}
+	for c := range p.blocks {
+		committees[c] = true
+	}
+	rows := []string{}
+	for c := range committees {
+		since := "never"
+		if t, ok := p.lastBlocks[c]; ok {
+			since = fmt.Sprintf("%.1f", now.Sub(t).Seconds())
+		}
+		rows = append(rows, fmt.Sprintf("%s,%d,%s", bytes32ToString(c), p.blocks[c], since))
+	}
+	sort.Strings(rows)
+	var b strings.Builder
+	b.WriteString("committee,final blocks,seconds since the last final block\n")
+	for _, r := range rows {
+		b.WriteString(r + "\n")
+	}
+	rows = rows[:0]
+	for pub, d := range p._silent(now, started, nodes) {
+		since := fmt.Sprintf("%.1f", d.Seconds())
+		if _, ok := p.seen[pub]; !ok {
+			since = "never"
+		}
+		rows = append(rows, fmt.Sprintf("%s,%s,%s", bytes32ToString(pub), bytes32ToString(nodes[pub].CommitteeID), since))
+	}
+	sort.Strings(rows)
+	b.WriteString("\nsilent node,committee,seconds since the last stat\n")
+	for _, r := range rows {
+		b.WriteString(r + "\n")
+	}
+	return b.String()
+}
+
+func writeAbortDump(reason string, p *Progress, nodes map[[32]byte]NodeAllInfo, started time.Time) {
+	dump := "reason: " + reason + "\n" + p.summary(len(nodes)) + "\n\n" + p.diagnose(started, nodes)
+	name := resultsName("abort") + ".txt"
+	if !ifErr(os.WriteFile(name, []byte(dump), 0644), "abort dump") {
+		coordinatorLog.errorf(nil, "[Abort] wrote %s", name)
+	}
+}
+
+// checks the run every second, aborted gets the reason when it is stuck
+func watchdog(p *Progress, ms *Membership, flagArgs *FlagArgs, aborted chan<- string) {
+	started := clockNow()
+	for {
+		clockSleep(time.Second)
+		ms.mux.Lock()
+		nodes := make(map[[32]byte]NodeAllInfo, len(ms.nodes))
+		for pub, n := range ms.nodes {
+			nodes[pub] = n
+		}
+		ms.mux.Unlock()
+		reason := p.stuck(started, nodes, flagArgs)
+		if reason == "" {
+			continue
+		}
+		coordinatorLog.errorf(nil, "[Abort] %s", reason)
+		faultCoverage.react("abort", "", "")
+		writeAbortDump(reason, p, nodes, started)
+		if flagArgs.flightRecorder != 0 {
+			list := make([]NodeAllInfo, 0, len(nodes))
+			for _, n := range nodes {
+				list = append(list, n)
+			}
+			for _, line := range collectFlightDumps(list) {
+				coordinatorLog.infof(nil, "[Flight] %s", line)
+			}
+		}
+		aborted <- reason
+		return
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+)
+
+// -weights, the voting weights of the nodes
+
+const weightsEqual = "equal"
+const weightsUniform = "uniform"
+const weightsPareto = "pareto"
+
+// shape of the pareto distribution, about 80% of the weight is held by 20% of the nodes
+const paretoShape = 1.16
+
+func isWeightsDist(s string) bool {
+	return s == weightsEqual || s == weightsUniform || s == weightsPareto
+}
+
+// Pub.Bytes -> voting weight, nil if every node has one vote
+type Weights map[[32]byte]uint64
+
+func (w Weights) of(pub [32]byte) int {
+	if weight, ok := w[pub]; ok {
+		return int(weight)
+	}
+	return 1
+}
+
+func (w Weights) ofMembers(members map[[32]byte]*CommitteeMember) int {
+	total := 0
+	for pub := range members {
+		total += w.of(pub)
+	}
+	return total
+}
+
+func (w Weights) ofSet(pubs map[[32]byte]bool) int {
+	total := 0
+	for pub := range pubs {
+		total += w.of(pub)
+	}
+	return total
+}
+
+// weight needed to verify the signatures on a block of members. A member needed a committeeF fraction
+// of the weight of the others, see consensusQuorum, so the heaviest member gives the lowest quorum
+func (w Weights) blockQuorum(members map[[32]byte]bool, committeeF uint) int {
+	heaviest := 0
+	for pub := range members {
+		if w.of(pub) > heaviest {
+			heaviest = w.of(pub)
+		}
+	}
+	return (w.ofSet(members)-heaviest)/int(committeeF) + 1
+}
+
+// weight of the echos or accepts a node needs, a committeeF fraction of the weight of the other
+// members of its committee
+func consensusQuorum(nodeCtx *NodeCtx) int {
+	w := nodeCtx.blockchain.getLastReconfigurationBlock().Weights
+	return w.ofMembers(nodeCtx.committee.Members)/int(nodeCtx.flagArgs.committeeF) + 1
+}
+
+// the weights of nodes with dist, nil with weightsEqual
+func drawWeights(nodes []NodeAllInfo, dist string, maxWeight uint, source RandomSource) Weights {
+	if dist == weightsEqual {
+		return nil
+	}
+	w := make(Weights)
+	for _, n := range nodes {
+		var weight uint64
+		switch dist {
+		case weightsUniform:
+			weight = uint64(source.Intn(int(maxWeight))) + 1
+		case weightsPareto:
+			u := float64(source.Intn(math.MaxInt32)+1) / float64(math.MaxInt32)
+			weight = uint64(math.Min(math.Floor(math.Pow(u, -1/paretoShape)), float64(maxWeight)))
+		}
+		w[n.Pub.Bytes] = weight
+	}
+	return w
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -wireStats, the messages and bytes a node receives by type
+
+type WireStats struct {
+	enabled     bool
+	counts      map[string]uint64
+	bytes       map[string]uint64
+	headers     map[string]uint64
+	headerSizes map[string]uint64 // type -> gob type bytes of a message
+	mux         sync.Mutex
+}
+
+func (ws *WireStats) init(enabled bool) {
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	ws.enabled = enabled
+	ws.headerSizes = make(map[string]uint64)
+	ws._reset()
+}
+
+func (ws *WireStats) _reset() {
+	ws.counts = make(map[string]uint64)
+	ws.bytes = make(map[string]uint64)
+	ws.headers = make(map[string]uint64)
+}
+
+func wireType(msg Msg) string {
+	switch m := msg.Msg.(type) {
+	case ConsensusMsg:
+		return "consensus_" + m.Tag
+	case IDAGossipMsg:
+		return "ida_" + m.Typ
+	case CommitteeMsg:
+		var inner Msg
+		if err := gob.NewDecoder(bytes.NewBuffer(m.Msg)).Decode(&inner); err != nil {
+			return msg.Typ
+		}
+		return "routed_" + inner.Typ
+	}
+	return msg.Typ
+}
+
+// the bytes of the gob type descriptions in the encoding of msg, a second encoding with the same
+// encoder leaves them out
+func gobHeaderSize(msg Msg) uint64 {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if enc.Encode(msg) != nil {
+		return 0
+	}
+	first := buf.Len()
+	if enc.Encode(msg) != nil {
+		return 0
+	}
+	second := buf.Len() - first
+	if second > first {
+		return 0
+	}
+	return uint64(first - second)
+}
+
+// counts msg with the n bytes it took on the wire
+func (ws *WireStats) add(msg Msg, n int) {
+	if !ws.enabled {
+		return
+	}
+	typ := wireType(msg)
+	ws.mux.Lock()
+	header, ok := ws.headerSizes[typ]
+	ws.mux.Unlock()
+	if !ok {
+		header = gobHeaderSize(msg)
+	}
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	ws.headerSizes[typ] = header
+	ws.counts[typ]++
+	ws.bytes[typ] += uint64(n)
+	ws.headers[typ] += header
+}
+
+type WireReport struct {
+	CommitteeID [32]byte
+	Counts      map[string]uint64
+	Bytes       map[string]uint64
+	Headers     map[string]uint64
+}
+
+// the counts since the last report, nil if nothing was received
+func (ws *WireStats) take(committeeID [32]byte) *WireReport {
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	if len(ws.counts) == 0 {
+		return nil
+	}
+	r := &WireReport{committeeID, ws.counts, ws.bytes, ws.headers}
+	ws._reset()
+	return r
+}
+
+// sends the counts of the node to the coordinator every default_wireInterval seconds
+func reportWire(nodeCtx *NodeCtx) {
+	for {
+		clockSleep(default_wireInterval * time.Second)
+		if nodeCtx.stopped.get() {
+			return
+		}
+		if r := nodeCtx.wire.take(nodeCtx.committeeID()); r != nil {
+			spawnDialAndSend(coordinatorAddr(), Msg{"wire_stats", *r, nodeCtx.self.Priv.Pub})
+		}
+	}
+}
+
+type wireTotal struct {
+	count, bytes, headers uint64
+}
+
+// the counts of all nodes on the coordinator, by committee and type
+type WireResults struct {
+	committees map[[32]byte]map[string]*wireTotal
+	mux        sync.Mutex
+}
+
+func (wr *WireResults) init() {
+	wr.mux.Lock()
+	defer wr.mux.Unlock()
+	wr.committees = make(map[[32]byte]map[string]*wireTotal)
+}
+
+func (wr *WireResults) add(r *WireReport) {
+	wr.mux.Lock()
+	defer wr.mux.Unlock()
+	types, ok := wr.committees[r.CommitteeID]
+	if !ok {
+		types = make(map[string]*wireTotal)
+		wr.committees[r.CommitteeID] = types
+	}
+	for typ, c := range r.Counts {
+		if _, ok := types[typ]; !ok {
+			types[typ] = new(wireTotal)
+		}
+		types[typ].count += c
+		types[typ].bytes += r.Bytes[typ]
+		types[typ].headers += r.Headers[typ]
+	}
+}
+
+// rows of a committee, the types with the most bytes first
+func wireRows(b *strings.Builder, committee string, types map[string]*wireTotal) {
+	names := []string{}
+	sum := uint64(0)
+	for typ, t := range types {
+		names = append(names, typ)
+		sum += t.bytes
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if types[names[i]].bytes != types[names[j]].bytes {
+			return types[names[i]].bytes > types[names[j]].bytes
+		}
+		return names[i] < names[j]
+	})
+	for _, typ := range names {
+		t := types[typ]
+		fmt.Fprintf(b, "%s,%s,%d,%d,%d,%.2f\n", committee, typ, t.count, t.bytes, t.headers, 100*float64(t.bytes)/float64(sum))
+	}
+}
+
+func (wr *WireResults) write(name string) {
+	wr.mux.Lock()
+	defer wr.mux.Unlock()
+	if len(wr.committees) == 0 {
+		return
+	}
+	ids := make([][32]byte, 0, len(wr.committees))
+	all := make(map[string]*wireTotal)
+	for id, types := range wr.committees {
+		ids = append(ids, id)
+		for typ, t := range types {
+			if _, ok := all[typ]; !ok {
+				all[typ] = new(wireTotal)
+			}
+			all[typ].count += t.count
+			all[typ].bytes += t.bytes
+			all[typ].headers += t.headers
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+	var b strings.Builder
+	for _, id := range ids {
+		wireRows(&b, bytes32ToString(id), wr.committees[id])
+	}
+	wireRows(&b, "all", all)
+	ifErr(os.WriteFile(name+".csv", []byte(b.String()), 0644), "wire stats csv")
+}